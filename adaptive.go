@@ -0,0 +1,155 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// AdaptiveConfig configures the [AdaptiveDefense].
+type AdaptiveConfig struct {
+	// Interval between two evaluations of the relay load.
+	Interval time.Duration
+
+	// The defenses are tightened by one level when the queue load or the events ingress rate
+	// rises above the high thresholds, and relaxed by one level when both drop below the low ones.
+	// The gap between high and low thresholds provides the hysteresis that prevents flapping.
+	HighQueueLoad, LowQueueLoad float64
+	HighEventRate, LowEventRate float64 // events per second
+
+	// MaxLevel is the maximum tightening level.
+	MaxLevel int
+
+	// BasePow is the minimum PoW difficulty at level zero, raised by PowStep for each level.
+	BasePow, PowStep int
+
+	// IPRate and IPBurst are the per-IP events rate limits at level zero.
+	// The rate is halved for each level.
+	IPRate, IPBurst float64
+}
+
+// DefaultAdaptiveConfig returns an [AdaptiveConfig] with sane defaults.
+func DefaultAdaptiveConfig() AdaptiveConfig {
+	return AdaptiveConfig{
+		Interval:      5 * time.Second,
+		HighQueueLoad: 0.7,
+		LowQueueLoad:  0.3,
+		HighEventRate: 2000,
+		LowEventRate:  500,
+		MaxLevel:      4,
+		BasePow:       0,
+		PowStep:       4,
+		IPRate:        10,
+		IPBurst:       50,
+	}
+}
+
+// AdaptiveDefense is a control loop that raises the minimum PoW difficulty and tightens
+// the per-IP rate limits when the relay is under load, and relaxes them when the load subsides.
+// The minimum PoW difficulty is advertised in the NIP-11 limitation document.
+//
+// Example:
+//
+//	defense := NewAdaptiveDefense(DefaultAdaptiveConfig())
+//	relay.Reject.Event = append(relay.Reject.Event, defense.Reject)
+//	go defense.Run(ctx, relay)
+type AdaptiveDefense struct {
+	config  AdaptiveConfig
+	limiter *RateLimiter
+
+	level    atomic.Int32
+	received atomic.Int64
+	rate     atomic.Uint64 // float64 bits of the last measured events rate
+
+	powRejected  atomic.Int64
+	rateRejected atomic.Int64
+}
+
+// NewAdaptiveDefense returns an [AdaptiveDefense] at level zero.
+func NewAdaptiveDefense(config AdaptiveConfig) *AdaptiveDefense {
+	return &AdaptiveDefense{
+		config:  config,
+		limiter: NewRateLimiter(),
+	}
+}
+
+// Level returns the current tightening level, between zero and MaxLevel.
+func (a *AdaptiveDefense) Level() int { return int(a.level.Load()) }
+
+// MinPow returns the minimum PoW difficulty currently required.
+func (a *AdaptiveDefense) MinPow() int { return a.config.BasePow + a.Level()*a.config.PowStep }
+
+// EventRate returns the events ingress rate (per second) measured during the last interval.
+func (a *AdaptiveDefense) EventRate() float64 { return math.Float64frombits(a.rate.Load()) }
+
+// Rejected returns the number of events rejected because of insufficient PoW and rate limits.
+func (a *AdaptiveDefense) Rejected() (pow, rateLimited int64) {
+	return a.powRejected.Load(), a.rateRejected.Load()
+}
+
+// Reject is a Reject.Event hook that enforces the current PoW difficulty and per-IP rate limit.
+func (a *AdaptiveDefense) Reject(ctx context.Context, c Client, e *nostr.Event) error {
+	a.received.Add(1)
+
+	if pow := a.MinPow(); pow > 0 {
+		if difficulty := nip13.Difficulty(e.ID); difficulty < pow {
+			a.powRejected.Add(1)
+			return fmt.Errorf("pow: difficulty %d is less than %d", difficulty, pow)
+		}
+	}
+
+	rate := a.config.IPRate / float64(int(1)<<a.Level())
+	if !a.limiter.Allow(c.IP(), rate, a.config.IPBurst) {
+		a.rateRejected.Add(1)
+		return fmt.Errorf("rate-limited: slow down, the relay is under load")
+	}
+	return nil
+}
+
+// Run the control loop until the context is cancelled.
+func (a *AdaptiveDefense) Run(ctx context.Context, r *Relay) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			rate := float64(a.received.Swap(0)) / now.Sub(last).Seconds()
+			last = now
+
+			a.rate.Store(math.Float64bits(rate))
+			a.adjust(r, r.QueueLoad(), rate)
+			a.limiter.Prune(10 * a.config.Interval)
+		}
+	}
+}
+
+// adjust the level based on the measured load, and advertise the new PoW if it changed.
+func (a *AdaptiveDefense) adjust(r *Relay, load, rate float64) {
+	level := a.Level()
+	switch {
+	case load > a.config.HighQueueLoad || rate > a.config.HighEventRate:
+		level = min(level+1, a.config.MaxLevel)
+
+	case load < a.config.LowQueueLoad && rate < a.config.LowEventRate:
+		level = max(level-1, 0)
+	}
+
+	if old := a.level.Swap(int32(level)); int(old) == level {
+		return
+	}
+
+	pow := a.MinPow()
+	r.UpdateLimits(func(l *nip11.RelayLimitationDocument) { l.MinPowDifficulty = pow })
+	r.log.Info("adaptive defense level changed", "level", level, "min_pow", pow, "queue_load", load, "event_rate", rate)
+}
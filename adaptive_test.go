@@ -0,0 +1,66 @@
+package rely
+
+import (
+	"testing"
+)
+
+func TestAdaptiveAdjust(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+	defense := NewAdaptiveDefense(DefaultAdaptiveConfig())
+
+	steps := []struct {
+		load, rate float64
+		level      int
+	}{
+		{load: 0.1, rate: 10, level: 0},
+		{load: 0.8, rate: 10, level: 1},
+		{load: 0.1, rate: 3000, level: 2},
+		{load: 0.5, rate: 1000, level: 2}, // between thresholds, level is kept
+		{load: 0.1, rate: 1000, level: 2}, // rate still above low threshold
+		{load: 0.1, rate: 10, level: 1},
+		{load: 0.1, rate: 10, level: 0},
+		{load: 0.1, rate: 10, level: 0},
+	}
+
+	for i, step := range steps {
+		defense.adjust(relay, step.load, step.rate)
+		if defense.Level() != step.level {
+			t.Fatalf("step %d: expected level %d, got %d", i, step.level, defense.Level())
+		}
+
+		if pow := relay.Info().Limitation.MinPowDifficulty; pow != defense.MinPow() {
+			t.Fatalf("step %d: expected advertised pow %d, got %d", i, defense.MinPow(), pow)
+		}
+	}
+}
+
+func TestAdaptiveMaxLevel(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+	defense := NewAdaptiveDefense(DefaultAdaptiveConfig())
+
+	for range 10 {
+		defense.adjust(relay, 1, 0)
+	}
+
+	if defense.Level() != defense.config.MaxLevel {
+		t.Fatalf("expected level %d, got %d", defense.config.MaxLevel, defense.Level())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter()
+
+	for i := range 5 {
+		if !limiter.Allow("key", 0, 5) {
+			t.Fatalf("request %d should have been allowed within the burst", i)
+		}
+	}
+
+	if limiter.Allow("key", 0, 5) {
+		t.Fatal("request should have been denied after exhausting the burst")
+	}
+
+	if !limiter.Allow("other", 0, 5) {
+		t.Fatal("keys should have independent buckets")
+	}
+}
@@ -0,0 +1,308 @@
+package rely
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdmissionMode is how the [Admission] handles the new connections once the relay is full.
+type AdmissionMode int
+
+const (
+	// AdmissionLIFO refuses the new connections until some of the connected clients leave.
+	AdmissionLIFO AdmissionMode = iota
+
+	// AdmissionFair makes room for the new connection by disconnecting the newest client of the IP holding
+	// the most connections, if it holds more than the IP of the new connection would. A few IPs can't lock
+	// out the others, as they are the first to give up their connections.
+	AdmissionFair
+)
+
+func (m AdmissionMode) String() string {
+	switch m {
+	case AdmissionLIFO:
+		return "lifo"
+	case AdmissionFair:
+		return "fair"
+	default:
+		return fmt.Sprintf("AdmissionMode(%d)", int(m))
+	}
+}
+
+// AdmissionConfig configures the [Admission].
+type AdmissionConfig struct {
+	// MaxConnections is the maximum number of connected clients.
+	MaxConnections int
+
+	// Reserved is the headroom of the connections reserved to the priority clients. Once MaxConnections - Reserved
+	// clients are connected, the others are admitted on probation: they are challenged to authenticate (NIP-42),
+	// and disconnected unless they are priority clients within the Grace.
+	Reserved int
+	Grace    time.Duration
+
+	// Priority reports whether the client has priority, for example because it's authenticated as a paying user.
+	// If nil, the authenticated clients have priority.
+	Priority func(Client) bool
+
+	// Mode is how the new connections are handled once MaxConnections clients are connected.
+	Mode AdmissionMode
+
+	// RetryAfter is when the refused clients are told to reconnect (see [RetryAfter]).
+	RetryAfter time.Duration
+}
+
+// DefaultAdmissionConfig returns an [AdmissionConfig] admitting up to the maximum connections,
+// reserving a tenth of them to the authenticated clients.
+func DefaultAdmissionConfig(max int) AdmissionConfig {
+	return AdmissionConfig{
+		MaxConnections: max,
+		Reserved:       max / 10,
+		Grace:          15 * time.Second,
+		Mode:           AdmissionLIFO,
+		RetryAfter:     30 * time.Second,
+	}
+}
+
+// Admission guards the capacity of the relay: it caps the connected clients, and reserves headroom for the
+// priority clients (e.g. authenticated or paying users), so that they are not locked out during traffic spikes.
+// The connections are refused before the upgrade with [ErrOverloaded], so the clients are told to retry later.
+// Since the connections are counted once registered, simultaneous upgrades can slightly exceed the maximum.
+//
+// Example:
+//
+//	admission, err := NewAdmission(DefaultAdmissionConfig(10000))
+//	relay.Reject.Connection = append(relay.Reject.Connection, admission.RejectConnection)
+//	relay.On.Connect = admission.OnConnect
+//	relay.On.Auth = admission.OnAuth
+//	relay.On.Disconnect = admission.OnDisconnect
+type Admission struct {
+	config AdmissionConfig
+
+	mu    sync.Mutex
+	conns map[string]*admitted // by the UID of the client
+	byIP  map[string]int       // the number of connections by IP
+
+	refused  atomic.Int64
+	evicted  atomic.Int64
+	expelled atomic.Int64
+}
+
+// admitted is a connected client.
+type admitted struct {
+	client    Client
+	ip        string
+	priority  bool
+	admitted  time.Time
+	probation *time.Timer // nil unless the client was admitted on probation
+}
+
+// NewAdmission returns an [Admission], or an error if the config is invalid.
+func NewAdmission(config AdmissionConfig) (*Admission, error) {
+	if config.MaxConnections < 1 {
+		return nil, errors.New("the max connections must be positive")
+	}
+
+	if config.Reserved < 0 || config.Reserved >= config.MaxConnections {
+		return nil, errors.New("the reserved connections must be between zero and the max connections")
+	}
+
+	if config.Reserved > 0 && config.Grace <= 0 {
+		return nil, errors.New("the grace of the reserved connections must be positive")
+	}
+
+	if config.Mode != AdmissionLIFO && config.Mode != AdmissionFair {
+		return nil, fmt.Errorf("invalid admission mode %v", config.Mode)
+	}
+
+	if config.RetryAfter < 0 {
+		return nil, errors.New("the retry after must not be negative")
+	}
+
+	if config.Priority == nil {
+		config.Priority = func(c Client) bool { return c.Pubkey() != "" }
+	}
+
+	return &Admission{
+		config: config,
+		conns:  make(map[string]*admitted),
+		byIP:   make(map[string]int),
+	}, nil
+}
+
+// Connections returns the number of connected clients.
+func (a *Admission) Connections() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.conns)
+}
+
+// Refused returns the number of connections refused because the relay was full.
+func (a *Admission) Refused() int64 { return a.refused.Load() }
+
+// Evicted returns the number of clients disconnected to make room for the priority clients,
+// or for the connections of other IPs.
+func (a *Admission) Evicted() int64 { return a.evicted.Load() }
+
+// Expelled returns the number of clients admitted on probation in the reserved connections, and disconnected
+// because they didn't become priority clients within the grace, or to make room for new connections.
+func (a *Admission) Expelled() int64 { return a.expelled.Load() }
+
+// RejectConnection is a Reject.Connection hook refusing the connections once the relay is full, unless it can make
+// room for them: by disconnecting the oldest client on probation, or the oldest client without priority if the
+// connection has priority, which is known before the upgrade only from its credentials (see [WithAuthenticators]),
+// or as in [AdmissionFair].
+func (a *Admission) RejectConnection(s Stats, r *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.conns) < a.config.MaxConnections {
+		return nil
+	}
+
+	// the clients on probation only hold the reserved connections until they are needed
+	if conn := a.oldest(func(conn *admitted) bool { return conn.probation != nil && !conn.priority }); conn != nil {
+		a.forget(conn)
+		a.expelled.Add(1)
+		go func() {
+			conn.client.SendNotice("the relay is full, only priority users can use the reserved connections")
+			conn.client.Disconnect()
+		}()
+		return nil
+	}
+
+	var victim *admitted
+	if a.config.Priority(&client{ip: IP(r), pubkey: UpgradePubkey(r)}) {
+		victim = a.oldest(func(conn *admitted) bool { return !conn.priority })
+	}
+
+	if victim == nil && a.config.Mode == AdmissionFair {
+		victim = a.victim(IP(r))
+	}
+
+	if victim != nil {
+		a.forget(victim)
+		a.evicted.Add(1)
+		go victim.client.Disconnect()
+		return nil
+	}
+
+	a.refused.Add(1)
+	return RetryAfter(ErrOverloaded, a.config.RetryAfter)
+}
+
+// oldest returns the oldest connected client matching the condition. It must be called with the lock held.
+func (a *Admission) oldest(match func(*admitted) bool) *admitted {
+	var oldest *admitted
+	for _, conn := range a.conns {
+		if match(conn) && (oldest == nil || conn.admitted.Before(oldest.admitted)) {
+			oldest = conn
+		}
+	}
+	return oldest
+}
+
+// victim returns the newest client without priority of the IP holding the most connections,
+// if it holds more than the IP would after connecting. It must be called with the lock held.
+func (a *Admission) victim(ip string) *admitted {
+	var victim *admitted
+	for _, conn := range a.conns {
+		if conn.priority || a.byIP[conn.ip] <= a.byIP[ip]+1 {
+			continue
+		}
+
+		if victim == nil ||
+			a.byIP[conn.ip] > a.byIP[victim.ip] ||
+			(conn.ip == victim.ip && conn.admitted.After(victim.admitted)) {
+			victim = conn
+		}
+	}
+	return victim
+}
+
+// OnConnect is an On.Connect hook counting the client. If the connections outside the reserve are exhausted,
+// a client without priority is admitted on probation: it's challenged to authenticate, and disconnected
+// unless it has priority within the grace.
+func (a *Admission) OnConnect(c Client) {
+	conn := &admitted{
+		client:   c,
+		ip:       c.IP(),
+		priority: a.config.Priority(c),
+		admitted: time.Now(),
+	}
+
+	a.mu.Lock()
+	probation := !conn.priority && a.config.Reserved > 0 && len(a.conns) >= a.config.MaxConnections-a.config.Reserved
+	if probation {
+		conn.probation = time.AfterFunc(a.config.Grace, func() { a.expel(c.UID()) })
+	}
+
+	a.conns[c.UID()] = conn
+	a.byIP[conn.ip]++
+	a.mu.Unlock()
+
+	if probation && c.Pubkey() == "" {
+		c.SendAuth()
+	}
+}
+
+// expel disconnects the client admitted on probation, unless it gained priority or already disconnected.
+func (a *Admission) expel(uid string) {
+	a.mu.Lock()
+	conn, ok := a.conns[uid]
+	if !ok || conn.priority {
+		a.mu.Unlock()
+		return
+	}
+
+	a.forget(conn)
+	a.mu.Unlock()
+
+	a.expelled.Add(1)
+	conn.client.SendNotice("the relay is full, only priority users can use the reserved connections")
+	conn.client.Disconnect()
+}
+
+// OnAuth is an On.Auth hook updating the priority of the client, which ends its probation if it gained priority.
+func (a *Admission) OnAuth(c Client) {
+	priority := a.config.Priority(c)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	conn, ok := a.conns[c.UID()]
+	if !ok {
+		return
+	}
+
+	conn.priority = priority
+	if priority && conn.probation != nil {
+		conn.probation.Stop()
+		conn.probation = nil
+	}
+}
+
+// OnDisconnect is an On.Disconnect hook forgetting the client.
+func (a *Admission) OnDisconnect(c Client, _ DisconnectReason, _ error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if conn, ok := a.conns[c.UID()]; ok {
+		a.forget(conn)
+	}
+}
+
+// forget stops counting the client. It must be called with the lock held.
+func (a *Admission) forget(conn *admitted) {
+	if conn.probation != nil {
+		conn.probation.Stop()
+	}
+
+	delete(a.conns, conn.client.UID())
+	if a.byIP[conn.ip]--; a.byIP[conn.ip] <= 0 {
+		delete(a.byIP, conn.ip)
+	}
+}
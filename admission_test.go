@@ -0,0 +1,207 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// admissionClient is a [Client] recording whether it was challenged and disconnected.
+type admissionClient struct {
+	Client
+	uid, ip, pubkey string
+
+	challenged   atomic.Bool
+	disconnected atomic.Bool
+}
+
+func (c *admissionClient) UID() string       { return c.uid }
+func (c *admissionClient) IP() string        { return c.ip }
+func (c *admissionClient) Pubkey() string    { return c.pubkey }
+func (c *admissionClient) SendAuth()         { c.challenged.Store(true) }
+func (c *admissionClient) SendNotice(string) {}
+func (c *admissionClient) Disconnect()       { c.disconnected.Store(true) }
+
+func admissionRequest(ip string) *http.Request {
+	return &http.Request{RemoteAddr: ip + ":1234", Header: http.Header{}}
+}
+
+func TestAdmissionLIFO(t *testing.T) {
+	config := DefaultAdmissionConfig(3)
+	config.Reserved = 1
+	config.Grace = 20 * time.Millisecond
+
+	admission, err := NewAdmission(config)
+	if err != nil {
+		t.Fatalf("failed to create the admission: %v", err)
+	}
+
+	priority := &admissionClient{uid: "1", ip: "1.1.1.1", pubkey: "user"}
+	anonymous := &admissionClient{uid: "2", ip: "2.2.2.2"}
+	admission.OnConnect(priority)
+	admission.OnConnect(anonymous)
+
+	// the reserved connection admits on probation
+	if err := admission.RejectConnection(nil, admissionRequest("3.3.3.3")); err != nil {
+		t.Fatalf("expected the connection to be admitted, got %v", err)
+	}
+
+	probation := &admissionClient{uid: "3", ip: "3.3.3.3"}
+	admission.OnConnect(probation)
+	if !probation.challenged.Load() {
+		t.Fatal("expected the client on probation to be challenged")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !probation.disconnected.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !probation.disconnected.Load() || admission.Expelled() != 1 {
+		t.Fatal("expected the client on probation to be expelled")
+	}
+
+	// once full without clients on probation, only the priority connections are admitted
+	admission.OnConnect(&admissionClient{uid: "4", ip: "4.4.4.4", pubkey: "other"})
+
+	err = admission.RejectConnection(nil, admissionRequest("5.5.5.5"))
+	if !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected %v once full, got %v", ErrOverloaded, err)
+	}
+
+	if admission.Connections() != 3 || admission.Refused() != 1 {
+		t.Fatalf("expected 3 connections and 1 refused, got %d and %d", admission.Connections(), admission.Refused())
+	}
+}
+
+func TestAdmissionFullProbation(t *testing.T) {
+	config := DefaultAdmissionConfig(2)
+	config.Reserved = 1
+	config.Grace = time.Minute
+
+	admission, err := NewAdmission(config)
+	if err != nil {
+		t.Fatalf("failed to create the admission: %v", err)
+	}
+
+	admission.OnConnect(&admissionClient{uid: "1", ip: "1.1.1.1", pubkey: "user"})
+	probation := &admissionClient{uid: "2", ip: "2.2.2.2"}
+	admission.OnConnect(probation)
+
+	// the flood of anonymous connections can't keep the reserve full
+	if err := admission.RejectConnection(nil, admissionRequest("3.3.3.3")); err != nil {
+		t.Fatalf("expected the client on probation to make room, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !probation.disconnected.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !probation.disconnected.Load() || admission.Expelled() != 1 || admission.Connections() != 1 {
+		t.Fatal("expected the client on probation to be expelled")
+	}
+}
+
+func TestAdmissionUpgradeCredentials(t *testing.T) {
+	config := DefaultAdmissionConfig(2)
+	config.Reserved = 0
+
+	admission, err := NewAdmission(config)
+	if err != nil {
+		t.Fatalf("failed to create the admission: %v", err)
+	}
+
+	anonymous := &admissionClient{uid: "1", ip: "1.1.1.1"}
+	admission.OnConnect(anonymous)
+	admission.OnConnect(&admissionClient{uid: "2", ip: "2.2.2.2", pubkey: "user"})
+
+	if err := admission.RejectConnection(nil, admissionRequest("3.3.3.3")); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected %v, got %v", ErrOverloaded, err)
+	}
+
+	// the credentials of the upgrade request give it priority before it's admitted
+	r := admissionRequest("3.3.3.3")
+	r = r.WithContext(context.WithValue(context.Background(), credentialsKey{}, credentials{pubkey: "other"}))
+	if err := admission.RejectConnection(nil, r); err != nil {
+		t.Fatalf("expected the priority connection to be admitted, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !anonymous.disconnected.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !anonymous.disconnected.Load() || admission.Evicted() != 1 {
+		t.Fatal("expected the client without priority to be evicted")
+	}
+}
+
+func TestAdmissionProbationAuth(t *testing.T) {
+	config := DefaultAdmissionConfig(2)
+	config.Reserved = 1
+	config.Grace = 20 * time.Millisecond
+
+	admission, err := NewAdmission(config)
+	if err != nil {
+		t.Fatalf("failed to create the admission: %v", err)
+	}
+
+	admission.OnConnect(&admissionClient{uid: "1", ip: "1.1.1.1"})
+
+	probation := &admissionClient{uid: "2", ip: "2.2.2.2"}
+	admission.OnConnect(probation)
+
+	probation.pubkey = "user"
+	admission.OnAuth(probation)
+
+	time.Sleep(50 * time.Millisecond)
+	if probation.disconnected.Load() {
+		t.Fatal("expected the authenticated client to keep its reserved connection")
+	}
+}
+
+func TestAdmissionFair(t *testing.T) {
+	config := DefaultAdmissionConfig(3)
+	config.Reserved = 0
+	config.Mode = AdmissionFair
+
+	admission, err := NewAdmission(config)
+	if err != nil {
+		t.Fatalf("failed to create the admission: %v", err)
+	}
+
+	greedy := []*admissionClient{
+		{uid: "1", ip: "1.1.1.1"},
+		{uid: "2", ip: "1.1.1.1"},
+	}
+	for _, c := range greedy {
+		admission.OnConnect(c)
+		time.Sleep(time.Millisecond)
+	}
+	admission.OnConnect(&admissionClient{uid: "3", ip: "2.2.2.2"})
+
+	if err := admission.RejectConnection(nil, admissionRequest("3.3.3.3")); err != nil {
+		t.Fatalf("expected room to be made for the connection, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !greedy[1].disconnected.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !greedy[1].disconnected.Load() || greedy[0].disconnected.Load() {
+		t.Fatal("expected the newest client of the greedy IP to be evicted")
+	}
+
+	admission.OnConnect(&admissionClient{uid: "4", ip: "3.3.3.3"})
+
+	// every IP holds one connection, so there's nobody to evict
+	err = admission.RejectConnection(nil, admissionRequest("4.4.4.4"))
+	if !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected %v, got %v", ErrOverloaded, err)
+	}
+}
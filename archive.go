@@ -0,0 +1,217 @@
+package rely
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ArchiveConfig configures the [Archive].
+type ArchiveConfig struct {
+	// Dir is the directory where the archive files are created.
+	Dir string
+
+	// FlushInterval is how often the compressed events are flushed to the file.
+	// Events not flushed yet are lost if the process crashes.
+	FlushInterval time.Duration
+
+	// QueueSize is the number of events waiting to be written. When full, new events are dropped.
+	QueueSize int
+}
+
+// DefaultArchiveConfig returns an [ArchiveConfig] with sane defaults, writing in the directory.
+func DefaultArchiveConfig(dir string) ArchiveConfig {
+	return ArchiveConfig{
+		Dir:           dir,
+		FlushInterval: time.Second,
+		QueueSize:     100_000,
+	}
+}
+
+// Archive tees the stored events to append-only files of gzip-compressed JSON lines, one per day (UTC)
+// named events-YYYY-MM-DD.jsonl.gz, providing a cheap recovery path independent of the health of the database.
+// Restarting appends a new gzip member to the file of the day, which gzip readers handle transparently.
+//
+// Example:
+//
+//	archive, err := NewArchive(DefaultArchiveConfig("/var/lib/relay/archive"))
+//	relay.On.Event = archive.Save(relay.On.Event)
+//	go archive.Run(ctx)
+type Archive struct {
+	config ArchiveConfig
+	queue  chan *nostr.Event
+
+	archived atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+// NewArchive returns an [Archive], or an error if the config is invalid or the directory can't be created.
+func NewArchive(config ArchiveConfig) (*Archive, error) {
+	if config.Dir == "" {
+		return nil, errors.New("the archive directory must be set")
+	}
+
+	if config.FlushInterval <= 0 || config.QueueSize <= 0 {
+		return nil, errors.New("the archive flush interval and queue size must be positive")
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the archive directory: %w", err)
+	}
+
+	return &Archive{
+		config: config,
+		queue:  make(chan *nostr.Event, config.QueueSize),
+	}, nil
+}
+
+// Archived returns the number of events written to the archive.
+func (a *Archive) Archived() int64 { return a.archived.Load() }
+
+// Dropped returns the number of events not archived because the queue was full.
+func (a *Archive) Dropped() int64 { return a.dropped.Load() }
+
+// Failed returns the number of events not archived because of file errors.
+func (a *Archive) Failed() int64 { return a.failed.Load() }
+
+// Save wraps the On.Event hook, queueing the events it saves successfully to be archived, without blocking.
+func (a *Archive) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		select {
+		case a.queue <- e:
+		default:
+			a.dropped.Add(1)
+		}
+		return nil
+	}
+}
+
+// Run writes the queued events to the archive until the context is cancelled,
+// after which the events still queued are written and the file is closed.
+func (a *Archive) Run(ctx context.Context) {
+	w := &archiveWriter{dir: a.config.Dir}
+	defer w.close()
+
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-a.queue:
+					a.write(w, e)
+				default:
+					return
+				}
+			}
+
+		case e := <-a.queue:
+			a.write(w, e)
+
+		case <-ticker.C:
+			if err := w.flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "archive: failed to flush: %v\n", err)
+			}
+		}
+	}
+}
+
+func (a *Archive) write(w *archiveWriter, e *nostr.Event) {
+	if err := w.write(e, time.Now()); err != nil {
+		a.failed.Add(1)
+		fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		return
+	}
+	a.archived.Add(1)
+}
+
+// archiveWriter writes the events to the file of the day, rotating it at midnight UTC.
+type archiveWriter struct {
+	dir  string
+	day  string
+	file *os.File
+	gzip *gzip.Writer
+	buf  *bufio.Writer
+}
+
+func (w *archiveWriter) write(e *nostr.Event, now time.Time) error {
+	if day := now.UTC().Format(time.DateOnly); day != w.day {
+		if err := w.rotate(day); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event %s: %w", e.ID, err)
+	}
+
+	line = append(line, '\n')
+	if _, err := w.buf.Write(line); err != nil {
+		return fmt.Errorf("failed to write event %s: %w", e.ID, err)
+	}
+	return nil
+}
+
+// rotate closes the current file, and opens the one of the day for appending.
+func (w *archiveWriter) rotate(day string) error {
+	w.close()
+
+	name := filepath.Join(w.dir, "events-"+day+".jsonl.gz")
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	w.day = day
+	w.file = file
+	w.gzip = gzip.NewWriter(file)
+	w.buf = bufio.NewWriterSize(w.gzip, 64*1024)
+	return nil
+}
+
+// flush writes the buffered events to the file as a complete deflate block, so that they can be
+// recovered even if the process crashes before closing the file.
+func (w *archiveWriter) flush() error {
+	if w.file == nil {
+		return nil
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.gzip.Flush()
+}
+
+func (w *archiveWriter) close() {
+	if w.file == nil {
+		return
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "archive: failed to flush: %v\n", err)
+	}
+	if err := w.gzip.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "archive: failed to close: %v\n", err)
+	}
+
+	w.file.Close()
+	w.file = nil
+	w.day = ""
+}
@@ -0,0 +1,93 @@
+package rely
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	save := func(context.Context, Client, *nostr.Event) error { return nil }
+
+	// two runs append to the same file of the day
+	for run := range 2 {
+		archive, err := NewArchive(DefaultArchiveConfig(dir))
+		if err != nil {
+			t.Fatalf("failed to create archive: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			archive.Run(ctx)
+			close(done)
+		}()
+
+		hook := archive.Save(save)
+		for i := range 3 {
+			hook(context.Background(), nil, &nostr.Event{ID: string(rune('a' + run*3 + i)), Kind: 1, CreatedAt: nostr.Now()})
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-done
+
+		if archive.Archived() != 3 {
+			t.Fatalf("run %d: expected 3 archived events, got %d", run, archive.Archived())
+		}
+	}
+
+	name := filepath.Join(dir, "events-"+time.Now().UTC().Format(time.DateOnly)+".jsonl.gz")
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open the archive file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("failed to read the archive file: %v", err)
+	}
+
+	var ids string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var event nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		ids += event.ID
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to scan the archive file: %v", err)
+	}
+
+	if ids != "abcdef" {
+		t.Errorf("expected the events of both runs in order, got %q", ids)
+	}
+}
+
+func TestArchiveSkipsFailedSaves(t *testing.T) {
+	archive, err := NewArchive(DefaultArchiveConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+
+	hook := archive.Save(func(context.Context, Client, *nostr.Event) error { return ErrGeneric })
+	if err := hook(context.Background(), nil, &nostr.Event{ID: "a"}); err != ErrGeneric {
+		t.Errorf("expected the error of the save, got %v", err)
+	}
+
+	if len(archive.queue) != 0 {
+		t.Errorf("expected the event not to be queued, got %d", len(archive.queue))
+	}
+}
@@ -0,0 +1,198 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ArchiveModeConfig configures the archive query mode of the researchers, see [WithArchiveMode].
+type ArchiveModeConfig struct {
+	// Researchers are the pubkeys whose REQs are served in archive mode, once authenticated.
+	Researchers []string
+
+	// Bucket is the maximum time span (until - since) of each filter of an archive REQ.
+	// Researchers crawl the history one bucket after the other, which bounds the work of each query.
+	Bucket time.Duration
+
+	// MaxEvents is the maximum number of events returned by an archive REQ, replacing the response budget.
+	MaxEvents int
+
+	// Rate is the number of events per second sent to each researcher, across all its archive REQs.
+	// Zero sends the events at the pace the researcher can read them.
+	Rate int
+
+	// ChunkSize is the number of events sent at once, and MaxPause is how long to wait for the researcher
+	// to make room for a chunk in its response buffer before closing the subscription.
+	ChunkSize int
+	MaxPause  time.Duration
+}
+
+// DefaultArchiveModeConfig returns an [ArchiveModeConfig] with sane defaults, for the researchers.
+func DefaultArchiveModeConfig(researchers ...string) ArchiveModeConfig {
+	return ArchiveModeConfig{
+		Researchers: researchers,
+		Bucket:      24 * time.Hour,
+		MaxEvents:   100_000,
+		Rate:        5000,
+		ChunkSize:   500,
+		MaxPause:    time.Minute,
+	}
+}
+
+func (c ArchiveModeConfig) validate() error {
+	if len(c.Researchers) == 0 {
+		return errors.New("the archive mode needs at least one researcher")
+	}
+
+	for _, pubkey := range c.Researchers {
+		if !nostr.IsValidPublicKey(pubkey) {
+			return fmt.Errorf("invalid researcher pubkey %q", pubkey)
+		}
+	}
+
+	if c.Bucket <= 0 {
+		return errors.New("the archive bucket must be positive")
+	}
+
+	if c.MaxEvents < 1 {
+		return errors.New("the archive max events must be greater than 1")
+	}
+
+	if c.Rate < 0 {
+		return errors.New("the archive rate must not be negative")
+	}
+
+	if c.ChunkSize < 1 || c.MaxPause <= 0 {
+		return errors.New("the archive chunk size and max pause must be positive")
+	}
+	return nil
+}
+
+// archiveMode is the archive query mode of the relay, see [WithArchiveMode].
+type archiveMode struct {
+	config      ArchiveModeConfig
+	researchers map[string]struct{}
+}
+
+// IsArchiveReq reports whether the REQ of the client is served in archive mode: the client is authenticated
+// as one of the researchers of [WithArchiveMode], and every filter has a since and until at most a bucket apart.
+// Use it to exempt the archive REQs from the Reject.Req hooks meant for the other clients, such as [QueryBudget].
+func (r *Relay) IsArchiveReq(c Client, filters nostr.Filters) bool {
+	if r.archive == nil || c == nil || len(filters) == 0 {
+		return false
+	}
+
+	if _, ok := r.archive.researchers[c.Pubkey()]; !ok {
+		return false
+	}
+
+	for _, f := range filters {
+		if f.Since == nil || f.Until == nil || *f.Since > *f.Until {
+			return false
+		}
+		if time.Duration(*f.Until-*f.Since)*time.Second > r.archive.config.Bucket {
+			return false
+		}
+	}
+	return true
+}
+
+// pacer spaces the events sent to a client to a rate, across all its archive REQs.
+type pacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// reserve books the sending of n events at the rate, and returns how long to wait before sending them.
+func (p *pacer) reserve(n, rate int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	start := now
+	if p.next.After(now) {
+		start = p.next
+	}
+
+	p.next = start.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	return start.Sub(now)
+}
+
+// applyArchiveBudget adjusts the limits of the filters of an archive REQ in-place, like [Relay.applyBudget]
+// but with the maximum events of the archive mode instead of the response budget.
+func (r *Relay) applyArchiveBudget(filters nostr.Filters) (total int, limitedBy string) {
+	requested := make([]int, len(filters))
+	for i := range filters {
+		requested[i] = filters[i].Limit
+	}
+
+	allowance := r.memoryAllowance()
+	memoryBound := allowance < r.archive.config.MaxEvents
+	ApplyBudget(min(r.archive.config.MaxEvents, allowance), filters...)
+
+	var truncated bool
+	for i := range filters {
+		total += filters[i].Limit
+		if requested[i] > filters[i].Limit || (filters[i].Limit == 0 && !filters[i].LimitZero) {
+			// an unspecified limit is truncated when the budget leaves no events
+			truncated = true
+		}
+	}
+
+	switch {
+	case memoryBound && (truncated || total == 0):
+		r.stats.shedReqs.Add(1)
+		return total, "memory"
+	case truncated:
+		return total, "archive"
+	default:
+		return total, ""
+	}
+}
+
+// sendArchive sends the events of the archive REQ in chunks, at the pace of the client and at most
+// at the rate of the archive mode. It returns false if the client didn't make room within the maximum pause,
+// or if the subscription was closed in the meantime.
+func (p *processor) sendArchive(request reqRequest, events []nostr.Event) bool {
+	config := p.relay.archive.config
+	for start := 0; start < len(events); start += config.ChunkSize {
+		end := min(start+config.ChunkSize, len(events))
+		if config.Rate > 0 {
+			wait := request.client.pace.reserve(end-start, config.Rate)
+			if !sleep(request.ctx, wait) {
+				return false
+			}
+		}
+
+		if !request.client.waitCapacity(request.ctx, end-start+1, config.MaxPause) {
+			return false
+		}
+
+		for i := start; i < end; i++ {
+			request.client.send(eventResponse{ID: request.ID(), Event: &events[i]})
+		}
+	}
+	return true
+}
+
+// sleep waits for the duration, returning false if the context is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,128 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsArchiveReq(t *testing.T) {
+	researcher, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	relay := NewRelay(WithDomain("example.com"), WithArchiveMode(DefaultArchiveModeConfig(researcher)))
+
+	ts := func(t time.Time) *nostr.Timestamp {
+		timestamp := nostr.Timestamp(t.Unix())
+		return &timestamp
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bucket := nostr.Filter{Since: ts(day), Until: ts(day.Add(24 * time.Hour))}
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		filters  nostr.Filters
+		expected bool
+	}{
+		{name: "bucket", pubkey: researcher, filters: nostr.Filters{bucket}, expected: true},
+		{name: "unauthenticated", filters: nostr.Filters{bucket}},
+		{name: "not a researcher", pubkey: other, filters: nostr.Filters{bucket}},
+		{name: "without until", pubkey: researcher, filters: nostr.Filters{{Since: ts(day)}}},
+		{name: "bigger than the bucket", pubkey: researcher, filters: nostr.Filters{{Since: ts(day), Until: ts(day.Add(25 * time.Hour))}}},
+		{name: "since after until", pubkey: researcher, filters: nostr.Filters{{Since: ts(day.Add(time.Hour)), Until: ts(day)}}},
+		{name: "one unbounded filter", pubkey: researcher, filters: nostr.Filters{bucket, {Kinds: []int{1}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &client{relay: relay, pubkey: test.pubkey}
+			if got := relay.IsArchiveReq(c, test.filters); got != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestApplyArchiveBudget(t *testing.T) {
+	researcher, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	config := DefaultArchiveModeConfig(researcher)
+	config.MaxEvents = 5000
+	relay := NewRelay(WithDomain("example.com"), WithArchiveMode(config))
+
+	filters := nostr.Filters{{Kinds: []int{1}}}
+	total, limitedBy := relay.applyArchiveBudget(filters)
+	if total != 5000 || limitedBy != "" {
+		t.Fatalf("expected the unlimited filter to get the max events, got %d (%q)", total, limitedBy)
+	}
+
+	filters = nostr.Filters{{Kinds: []int{1}, Limit: 10_000}}
+	total, limitedBy = relay.applyArchiveBudget(filters)
+	if total != 5000 || limitedBy != "archive" {
+		t.Fatalf("expected the filter to be truncated by the archive limit, got %d (%q)", total, limitedBy)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	var p pacer
+	if wait := p.reserve(100, 1000); wait != 0 {
+		t.Fatalf("expected the first reservation to be immediate, got %v", wait)
+	}
+
+	wait := p.reserve(100, 1000)
+	if wait < 90*time.Millisecond || wait > 100*time.Millisecond {
+		t.Fatalf("expected to wait for the previous 100 events at 1000/s, got %v", wait)
+	}
+}
+
+func TestArchivePacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	researcher, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	config := DefaultArchiveModeConfig(researcher)
+	config.Rate = 1
+	config.ChunkSize = 1
+
+	relay := NewRelay(WithDomain("example.com"), WithArchiveMode(config))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return make([]nostr.Event, 10), nil
+	}
+	relay.Start(ctx)
+
+	// the archive REQs are paced at one event per second, across all the REQs of the researcher
+	researcherClient := &client{relay: relay, pubkey: researcher, responses: make(chan response, 100), done: make(chan struct{})}
+	for i := range relay.processor.maxWorkers {
+		request := reqRequest{id: fmt.Sprintf("sub%d", i), ctx: ctx, client: researcherClient, Filters: nostr.Filters{{}}, archive: true}
+		if err := relay.tryProcess(request); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+	}
+
+	writer := &client{relay: relay, responses: make(chan response, 10)}
+	if err := relay.tryProcess(eventRequest{client: writer, ctx: ctx, Event: &nostr.Event{ID: "abc", Kind: 1}, receivedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	select {
+	case response := <-writer.responses:
+		if ok, isOK := response.(okResponse); !isOK || !ok.Saved {
+			t.Fatalf("expected a successful OK response, got %+v", response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the paced archive REQs must not starve the workers")
+	}
+}
+
+func TestArchiveModeValidation(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected the relay to panic with an invalid archive mode")
+		}
+	}()
+
+	NewRelay(WithDomain("example.com"), WithArchiveMode(DefaultArchiveModeConfig("invalid")))
+}
@@ -0,0 +1,107 @@
+package rely
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxAssetSize is the maximum size in bytes of an [Asset].
+const MaxAssetSize = 5 << 20
+
+// assetExtensions are the extensions of the images looked up by [FindAsset], in order of preference.
+var assetExtensions = []string{".png", ".jpg", ".jpeg", ".webp", ".gif", ".svg"}
+
+// Asset is an image served by the relay, like the icon and the banner referenced in its NIP-11 document,
+// so that small operators don't need to host them elsewhere.
+// The file is read once, and served with its ETag so that clients can cache it.
+//
+// Example:
+//
+//	icon, err := rely.NewAsset("/data/icon.png")
+//	if err != nil {
+//		panic(err)
+//	}
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/", relay)
+//	mux.Handle("GET /icon", icon)
+//
+//	info := relay.Info()
+//	info.Icon = "https://relay.example.com/icon"
+//	relay.SetInfo(info)
+type Asset struct {
+	name        string
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+}
+
+// NewAsset reads the image at the path. It returns an error if the file is not an image,
+// or if it's bigger than [MaxAssetSize].
+func NewAsset(path string) (*Asset, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the asset: %w", err)
+	}
+	if stat.IsDir() {
+		return nil, fmt.Errorf("the asset %s is a directory", path)
+	}
+	if stat.Size() > MaxAssetSize {
+		return nil, fmt.Errorf("the asset %s is too big: %d bytes, the maximum is %d", path, stat.Size(), MaxAssetSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the asset: %w", err)
+	}
+
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("the asset %s is not an image: %s", path, contentType)
+	}
+
+	hash := sha256.Sum256(data)
+	return &Asset{
+		name:        filepath.Base(path),
+		data:        data,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(hash[:8]) + `"`,
+		modTime:     stat.ModTime(),
+	}, nil
+}
+
+// FindAsset returns the path of the image with the name (without extension) in the directory,
+// e.g. "icon" finds "icon.png" or "icon.svg". It returns an empty path if there's none.
+func FindAsset(dir, name string) string {
+	for _, ext := range assetExtensions {
+		path := filepath.Join(dir, name+ext)
+		if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of the image, e.g. "image/png".
+func (a *Asset) ContentType() string { return a.contentType }
+
+// ServeHTTP serves the image, answering the conditional requests of clients that cached it.
+func (a *Asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", a.etag)
+	http.ServeContent(w, r, a.name, a.modTime, bytes.NewReader(a.data))
+}
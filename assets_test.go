@@ -0,0 +1,104 @@
+package rely
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// png is the header of a PNG image, enough for the content type to be detected.
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestNewAsset(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		valid       bool
+	}{
+		{name: "png", path: write("icon.png", png), contentType: "image/png", valid: true},
+		{name: "sniffed", path: write("icon.bin", png), contentType: "image/png", valid: true},
+		{name: "svg", path: write("banner.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)), contentType: "image/svg+xml", valid: true},
+		{name: "not an image", path: write("notes.txt", []byte("hello")), valid: false},
+		{name: "missing", path: filepath.Join(dir, "missing.png"), valid: false},
+		{name: "directory", path: dir, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asset, err := NewAsset(test.path)
+			if !test.valid {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if asset.ContentType() != test.contentType {
+				t.Fatalf("expected content type %s, got %s", test.contentType, asset.ContentType())
+			}
+		})
+	}
+}
+
+func TestFindAsset(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "icon.svg"), []byte("<svg/>"), 0o644); err != nil {
+		t.Fatalf("failed to write the icon: %v", err)
+	}
+
+	if path := FindAsset(dir, "icon"); path != filepath.Join(dir, "icon.svg") {
+		t.Fatalf("expected the icon.svg, got %q", path)
+	}
+	if path := FindAsset(dir, "banner"); path != "" {
+		t.Fatalf("expected no banner, got %q", path)
+	}
+}
+
+func TestAssetServeHTTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "icon.png")
+	if err := os.WriteFile(path, png, 0o644); err != nil {
+		t.Fatalf("failed to write the icon: %v", err)
+	}
+
+	asset, err := NewAsset(path)
+	if err != nil {
+		t.Fatalf("failed to read the icon: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	asset.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/icon", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(png) {
+		t.Fatalf("expected the icon, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected image/png, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/icon", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	asset.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected %d for a cached icon, got %d", http.StatusNotModified, rec.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package rely
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authResendInterval is the minimum time between two sends of the same AUTH challenge,
+// so that a client retrying restricted requests doesn't receive a flood of challenges.
+const authResendInterval = 10 * time.Second
+
+var ErrKindAuth = fmt.Errorf("%w: you must be authenticated to use these kinds", ErrAuthRequired)
+
+// RequireAuthReq returns a Reject.Req or Reject.Count hook that rejects with [ErrKindAuth] the requests
+// of unauthenticated clients having a filter for any of the kinds. The rejection sends the AUTH challenge,
+// so that the client can authenticate and retry, as most clients do.
+//
+// Example:
+//
+//	relay.Reject.Req = append(relay.Reject.Req, RequireAuthReq(nostr.KindEncryptedDirectMessage))
+func RequireAuthReq(kinds ...int) func(context.Context, Client, nostr.Filters) error {
+	return func(ctx context.Context, c Client, filters nostr.Filters) error {
+		if c.Pubkey() != "" {
+			return nil
+		}
+
+		for _, filter := range filters {
+			for _, kind := range filter.Kinds {
+				if slices.Contains(kinds, kind) {
+					return ErrKindAuth
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// RequireAuthEvent returns a Reject.Event hook that rejects with [ErrKindAuth] the events of any of the kinds
+// published by unauthenticated clients. The rejection sends the AUTH challenge, so that the client
+// can authenticate and retry.
+func RequireAuthEvent(kinds ...int) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if c.Pubkey() == "" && slices.Contains(kinds, e.Kind) {
+			return ErrKindAuth
+		}
+		return nil
+	}
+}
+
+// challengeIfRequired sends the AUTH challenge if the rejection requires authentication.
+func (c *client) challengeIfRequired(err error) {
+	if err != nil && Reason(err) == ErrAuthRequired {
+		c.challengeAuth()
+	}
+}
+
+// challengeAuth sends the AUTH challenge of the connection, generating it if none was sent or if it expired.
+// Unlike [client.SendAuth], it keeps the authenticated pubkey and the challenge, so that a client
+// answering a previous challenge, or authenticating with multiple pubkeys, is not invalidated.
+func (c *client) challengeAuth() {
+	if c.relay.authDisabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expired := c.challengeExpired()
+	if c.challenge != "" && !expired && time.Since(c.challengedAt) < authResendInterval {
+		return
+	}
+
+	if c.challenge == "" || expired {
+		bytes := make([]byte, authChallengeBytes)
+		rand.Read(bytes)
+		c.challenge = hex.EncodeToString(bytes)
+		c.issuedAt = time.Now()
+	}
+
+	c.challengedAt = time.Now()
+	c.send(authResponse{Challenge: c.challenge})
+}
+
+// revalidate runs the Reject.Req hooks on the open subscriptions, closing those they reject.
+// It's called when the client switches pubkey, since the subscriptions allowed to the previous one
+// (e.g. to its direct messages) may not be allowed to the new one.
+// Hooks with side effects, such as rate limits, are run again.
+func (c *client) revalidate() {
+	for _, sub := range c.Subscriptions() {
+		if err := c.rejectFilters(c.messageContext(), c.relay.Reject.Req, sub.Filters()); err != nil {
+			c.CloseSubWithReason(sub.ID(), reasonMessage(err))
+		}
+	}
+}
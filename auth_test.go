@@ -0,0 +1,173 @@
+package rely
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRequireAuth(t *testing.T) {
+	anonymous := &client{}
+	authed := &client{pubkey: pk}
+
+	rejectReq := RequireAuthReq(nostr.KindEncryptedDirectMessage)
+	if err := rejectReq(context.Background(), anonymous, nostr.Filters{{Kinds: []int{1}}, {Kinds: []int{4}}}); err != ErrKindAuth {
+		t.Errorf("expected %v, got %v", ErrKindAuth, err)
+	}
+	if err := rejectReq(context.Background(), anonymous, nostr.Filters{{Kinds: []int{1}}, {}}); err != nil {
+		t.Errorf("expected the filters without the kinds to be accepted, got %v", err)
+	}
+	if err := rejectReq(context.Background(), authed, nostr.Filters{{Kinds: []int{4}}}); err != nil {
+		t.Errorf("expected the authenticated client to be accepted, got %v", err)
+	}
+
+	rejectEvent := RequireAuthEvent(nostr.KindEncryptedDirectMessage)
+	if err := rejectEvent(context.Background(), anonymous, &nostr.Event{Kind: 4}); err != ErrKindAuth {
+		t.Errorf("expected %v, got %v", ErrKindAuth, err)
+	}
+	if err := rejectEvent(context.Background(), anonymous, &nostr.Event{Kind: 1}); err != nil {
+		t.Errorf("expected the event of another kind to be accepted, got %v", err)
+	}
+}
+
+func TestAuthChallengeFlow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) { return nil, nil }
+	relay.Reject.Req = append(relay.Reject.Req,
+		RequireAuthReq(nostr.KindEncryptedDirectMessage),
+		func(ctx context.Context, c Client, filters nostr.Filters) error {
+			for _, f := range filters {
+				if slices.Contains(f.Kinds, nostr.KindEncryptedDirectMessage) && !slices.Equal(f.Authors, []string{c.Pubkey()}) {
+					return errors.New("restricted: you can only request your own DMs")
+				}
+			}
+			return nil
+		},
+	)
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	alice, bob := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	alicePK, _ := nostr.GetPublicKey(alice)
+	dms := nostr.Filters{{Kinds: []int{nostr.KindEncryptedDirectMessage}, Authors: []string{alicePK}}}
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "dms", Filters: dms})
+	label, msg := readMessage(t, conn)
+	if label != "AUTH" {
+		t.Fatalf("expected an AUTH challenge, got %s", label)
+	}
+
+	var challenge string
+	json.Unmarshal(msg[1], &challenge)
+
+	if label, msg := readMessage(t, conn); label != "CLOSED" || !strings.Contains(string(msg[2]), "auth-required:") {
+		t.Fatalf("expected the REQ to be closed as auth-required, got %s %s", label, msg)
+	}
+
+	// the challenge is not sent again to a client retrying
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "dms", Filters: dms})
+	if label, _ := readMessage(t, conn); label != "CLOSED" {
+		t.Fatalf("expected the REQ to be closed without a new challenge, got %s", label)
+	}
+
+	send(t, conn, authEnvelope(t, alice, challenge))
+	if label, msg := readMessage(t, conn); label != "OK" || string(msg[2]) != "true" {
+		t.Fatalf("expected the AUTH to be accepted, got %s %s", label, msg)
+	}
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "dms", Filters: dms})
+	if label, _ := readMessage(t, conn); label != "EOSE" {
+		t.Fatalf("expected the REQ to be accepted, got %s", label)
+	}
+
+	// switching pubkey closes the subscriptions no longer allowed
+	send(t, conn, authEnvelope(t, bob, challenge))
+	if label, msg := readMessage(t, conn); label != "OK" || string(msg[2]) != "true" {
+		t.Fatalf("expected the AUTH to be accepted, got %s %s", label, msg)
+	}
+
+	if label, msg := readMessage(t, conn); label != "CLOSED" || !strings.Contains(string(msg[2]), "restricted:") {
+		t.Fatalf("expected the subscription to be closed after the pubkey switch, got %s %s", label, msg)
+	}
+}
+
+func authEnvelope(t *testing.T, sk, challenge string) nostr.AuthEnvelope {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      nostr.KindClientAuthentication,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"relay", "wss://example.com"}, {"challenge", challenge}},
+	}
+
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return nostr.AuthEnvelope{Event: event}
+}
+
+func send(t *testing.T, conn *ws.Conn, envelope any) {
+	t.Helper()
+	if err := conn.WriteJSON(envelope); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+}
+
+// readMessage reads the next message, returning its label and elements.
+func readMessage(t *testing.T, conn *ws.Conn) (string, []json.RawMessage) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	var msg []json.RawMessage
+	var label string
+	if err := json.Unmarshal(data, &msg); err != nil || len(msg) == 0 || json.Unmarshal(msg[0], &label) != nil {
+		t.Fatalf("invalid message %s", data)
+	}
+	return label, msg
+}
+
+func TestChallengeAuthExpiration(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithAuthChallengeTTL(time.Minute))
+	issuedAt := time.Now().Add(-50 * time.Second)
+
+	// the resent challenge keeps its expiration
+	c := &client{relay: relay, responses: make(chan response, 10), challenge: "challenge", issuedAt: issuedAt, challengedAt: issuedAt}
+	c.challengeAuth()
+
+	if auth, ok := (<-c.responses).(authResponse); !ok || auth.Challenge != "challenge" || !c.issuedAt.Equal(issuedAt) {
+		t.Fatalf("expected the challenge to be resent as issued, got %+v issued at %v", auth, c.issuedAt)
+	}
+
+	// once expired, it's rotated even though it was resent recently
+	c.issuedAt = time.Now().Add(-2 * time.Minute)
+	c.challengedAt = time.Now().Add(-20 * time.Second)
+	c.challengeAuth()
+
+	if auth, ok := (<-c.responses).(authResponse); !ok || auth.Challenge == "challenge" || time.Since(c.issuedAt) > time.Second {
+		t.Fatalf("expected a new challenge, got %+v issued at %v", auth, c.issuedAt)
+	}
+}
@@ -0,0 +1,74 @@
+package rely
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usedAuths remembers the IDs of the accepted AUTH events, so that each of them authenticates once:
+// a captured AUTH event can't be replayed, not even on the connection that sent it. The IDs are forgotten
+// once their created_at can no longer be within the tolerance. The zero value is ready to use.
+type usedAuths struct {
+	mu     sync.Mutex
+	ids    map[string]time.Time // the ID of the AUTH event -> when it can be forgotten
+	pruned time.Time
+}
+
+// use marks the AUTH event as used, returning false if it already was.
+func (u *usedAuths) use(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if u.ids == nil {
+		u.ids = make(map[string]time.Time)
+	}
+
+	if now.Sub(u.pruned) > authTimeTolerance {
+		for id, until := range u.ids {
+			if now.After(until) {
+				delete(u.ids, id)
+			}
+		}
+		u.pruned = now
+	}
+
+	if until, ok := u.ids[id]; ok && now.Before(until) {
+		return false
+	}
+
+	// the created_at of the event is at most the tolerance in the future, and it's rejected
+	// once it's more than the tolerance in the past
+	u.ids[id] = now.Add(2 * authTimeTolerance)
+	return true
+}
+
+// isAuthRelay reports whether the relay tag of an AUTH event is a URL of the relay: its host must be the domain
+// of the relay, or one of the allowed hosts (see [WithAllowedHosts]) under which it's served behind reverse proxies.
+// The scheme (ws or wss), the port, the path and the case are ignored, as proxies and clients vary them.
+func (r *Relay) isAuthRelay(tag string) bool {
+	if r.domain == "" || tag == "" {
+		return false
+	}
+
+	if !strings.Contains(tag, "://") {
+		// some clients send the bare host
+		tag = "wss://" + tag
+	}
+
+	u, err := url.Parse(tag)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return false
+	}
+
+	host := strings.TrimSuffix(hostname(u.Host), ".")
+	return host == hostname(r.domain) || matchesHost(host, r.hosts)
+}
+
+// challengeExpired reports whether the AUTH challenge of the client expired, see [WithAuthChallengeTTL].
+// The TTL runs from when the challenge was generated, not resent. It must be called with the lock of the client held.
+func (c *client) challengeExpired() bool {
+	return c.relay.authChallengeTTL > 0 && time.Since(c.issuedAt) > c.relay.authChallengeTTL
+}
@@ -0,0 +1,211 @@
+package rely
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrBandwidthCap = fmt.Errorf("%w: you have exceeded your daily bandwidth, please try again tomorrow", ErrRateLimited)
+
+// BandwidthConfig configures the [Bandwidth] caps.
+type BandwidthConfig struct {
+	// PubkeyCap is the maximum bytes sent and received per day by an authenticated pubkey,
+	// and IPCap the maximum per day by an IP of unauthenticated clients.
+	// The IP of authenticated clients is capped at the larger of the two, so that authenticating
+	// with new pubkeys doesn't grant an IP a new cap. Zero disables the cap, only accounting the traffic.
+	PubkeyCap int64
+	IPCap     int64
+}
+
+// Usage is the traffic of a pubkey or IP during the current day.
+type Usage struct {
+	Key      string `json:"key"` // the pubkey or the IP
+	Sent     int64  `json:"sent"`
+	Received int64  `json:"received"`
+}
+
+// Total returns the bytes sent and received.
+func (u Usage) Total() int64 { return u.Sent + u.Received }
+
+type usage struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// Bandwidth accounts the bytes sent to and received from the clients, by IP and by pubkey if they are
+// authenticated, and enforces daily caps on them with [ErrBandwidthCap] and by withholding the events.
+// Usage resets at midnight UTC. It's essential for operators on metered bandwidth.
+// The bytes are those of the websocket messages, excluding the framing and compression.
+//
+// Example:
+//
+//	bandwidth := NewBandwidth(BandwidthConfig{PubkeyCap: 1 << 30, IPCap: 100 << 20})
+//	relay := NewRelay(WithBandwidth(bandwidth))
+//	relay.Reject.Event = append(relay.Reject.Event, bandwidth.RejectEvent)
+//	relay.Reject.Req = append(relay.Reject.Req, bandwidth.RejectReq)
+//	relay.On.Deliver = ChainDeliver(relay.On.Deliver, bandwidth.Deliver)
+//	go bandwidth.Run(ctx)
+type Bandwidth struct {
+	config BandwidthConfig
+
+	mu    sync.RWMutex
+	day   int64
+	usage map[string]*usage
+
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// NewBandwidth returns a [Bandwidth] using the provided config.
+func NewBandwidth(config BandwidthConfig) *Bandwidth {
+	return &Bandwidth{
+		config: config,
+		day:    today(),
+		usage:  make(map[string]*usage),
+	}
+}
+
+func today() int64 { return time.Now().Unix() / 86400 }
+
+// add the bytes of a message to the usage of the IP of the client, and of its pubkey if it's authenticated.
+func (b *Bandwidth) add(c Client, dir Direction, n int64) {
+	b.charge(b.usageOf(c.IP()), dir, n)
+	if pubkey := c.Pubkey(); pubkey != "" {
+		b.charge(b.usageOf(pubkey), dir, n)
+	}
+
+	if dir == Inbound {
+		b.received.Add(n)
+	} else {
+		b.sent.Add(n)
+	}
+}
+
+// usageOf returns the usage of the pubkey or IP, creating it if needed.
+func (b *Bandwidth) usageOf(key string) *usage {
+	b.mu.RLock()
+	u, ok := b.usage[key]
+	b.mu.RUnlock()
+
+	if !ok {
+		b.mu.Lock()
+		u, ok = b.usage[key]
+		if !ok {
+			u = &usage{}
+			b.usage[key] = u
+		}
+		b.mu.Unlock()
+	}
+	return u
+}
+
+// charge the bytes of a message to the usage.
+func (b *Bandwidth) charge(u *usage, dir Direction, n int64) {
+	if dir == Inbound {
+		u.received.Add(n)
+	} else {
+		u.sent.Add(n)
+	}
+}
+
+// Usage returns the traffic of the pubkey or IP during the current day.
+func (b *Bandwidth) Usage(key string) Usage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	u, ok := b.usage[key]
+	if !ok {
+		return Usage{Key: key}
+	}
+	return Usage{Key: key, Sent: u.sent.Load(), Received: u.received.Load()}
+}
+
+// Top returns the n pubkeys and IPs with the most traffic during the current day, in descending order.
+func (b *Bandwidth) Top(n int) []Usage {
+	b.mu.RLock()
+	talkers := make([]Usage, 0, len(b.usage))
+	for key, u := range b.usage {
+		talkers = append(talkers, Usage{Key: key, Sent: u.sent.Load(), Received: u.received.Load()})
+	}
+	b.mu.RUnlock()
+
+	slices.SortFunc(talkers, func(a, b Usage) int { return cmp.Compare(b.Total(), a.Total()) })
+	return talkers[:min(n, len(talkers))]
+}
+
+// Sent returns the total bytes sent to the clients.
+func (b *Bandwidth) Sent() int64 { return b.sent.Load() }
+
+// Received returns the total bytes received from the clients.
+func (b *Bandwidth) Received() int64 { return b.received.Load() }
+
+// exceeded reports whether the client has exceeded the daily cap of its IP or of its pubkey.
+func (b *Bandwidth) exceeded(c Client) bool {
+	ipCap := b.config.IPCap
+	pubkey := c.Pubkey()
+
+	if pubkey != "" {
+		if b.config.PubkeyCap > 0 && b.Usage(pubkey).Total() > b.config.PubkeyCap {
+			return true
+		}
+		if ipCap > 0 {
+			ipCap = max(ipCap, b.config.PubkeyCap)
+		}
+	}
+	return ipCap > 0 && b.Usage(c.IP()).Total() > ipCap
+}
+
+// RejectEvent is a Reject.Event hook that rejects the events of the clients over their daily cap.
+func (b *Bandwidth) RejectEvent(ctx context.Context, c Client, _ *nostr.Event) error {
+	if b.exceeded(c) {
+		return ErrBandwidthCap
+	}
+	return nil
+}
+
+// RejectReq is a Reject.Req or Reject.Count hook that rejects the requests of the clients over their daily cap.
+func (b *Bandwidth) RejectReq(ctx context.Context, c Client, _ nostr.Filters) error {
+	if b.exceeded(c) {
+		return ErrBandwidthCap
+	}
+	return nil
+}
+
+// Deliver is an On.Deliver hook that withholds the events from the clients over their daily cap,
+// so that their open subscriptions stop streaming.
+func (b *Bandwidth) Deliver(c Client, _ string, _ *nostr.Event) bool {
+	return !b.exceeded(c)
+}
+
+// Run resets the usage at the start of every day, until the context is cancelled.
+func (b *Bandwidth) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.reset(today())
+		}
+	}
+}
+
+// reset the usage if the day has changed.
+func (b *Bandwidth) reset(day int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if day != b.day {
+		b.day = day
+		b.usage = make(map[string]*usage)
+	}
+}
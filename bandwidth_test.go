@@ -0,0 +1,63 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBandwidthCaps(t *testing.T) {
+	bandwidth := NewBandwidth(BandwidthConfig{PubkeyCap: 1000, IPCap: 100})
+	anonymous := &client{ip: "1.2.3.4"}
+	authed := &client{ip: "1.2.3.4", pubkey: pk}
+
+	bandwidth.add(anonymous, Inbound, 60)
+	bandwidth.add(anonymous, Outbound, 60)
+	bandwidth.add(authed, Outbound, 500)
+
+	if err := bandwidth.RejectReq(context.Background(), anonymous, nostr.Filters{{}}); err != ErrBandwidthCap {
+		t.Fatalf("expected %v, got %v", ErrBandwidthCap, err)
+	}
+
+	if err := bandwidth.RejectEvent(context.Background(), authed, &nostr.Event{}); err != nil {
+		t.Fatalf("the pubkey is under its cap, got %v", err)
+	}
+
+	top := bandwidth.Top(2)
+	if len(top) != 2 || top[0].Key != "1.2.3.4" || top[0].Total() != 620 || top[1].Key != pk || top[1].Sent != 500 {
+		t.Fatalf("expected the IP and then the pubkey as the top talkers, got %+v", top)
+	}
+
+	if bandwidth.Sent() != 560 || bandwidth.Received() != 60 {
+		t.Fatalf("expected 560 bytes sent and 60 received, got %d and %d", bandwidth.Sent(), bandwidth.Received())
+	}
+
+	bandwidth.reset(bandwidth.day + 1)
+	if err := bandwidth.RejectReq(context.Background(), anonymous, nostr.Filters{{}}); err != nil {
+		t.Fatalf("the usage must reset every day, got %v", err)
+	}
+}
+
+func TestBandwidthThrowawayPubkey(t *testing.T) {
+	bandwidth := NewBandwidth(BandwidthConfig{PubkeyCap: 1000, IPCap: 100})
+	anonymous := &client{ip: "1.2.3.4"}
+	bandwidth.add(anonymous, Outbound, 200)
+
+	// the IP over its cap gets the pubkey cap by authenticating, not a fresh one for each pubkey
+	first := &client{ip: "1.2.3.4", pubkey: pk}
+	if !bandwidth.Deliver(first, "sub", &nostr.Event{}) {
+		t.Fatal("the IP is under the pubkey cap, the event must be delivered")
+	}
+
+	bandwidth.add(first, Outbound, 900)
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	throwaway := &client{ip: "1.2.3.4", pubkey: other}
+	if err := bandwidth.RejectReq(context.Background(), throwaway, nostr.Filters{{}}); err != ErrBandwidthCap {
+		t.Fatalf("expected %v for the throwaway pubkey, got %v", ErrBandwidthCap, err)
+	}
+
+	if bandwidth.Deliver(first, "sub", &nostr.Event{}) {
+		t.Fatal("the events must be withheld from the subscriptions of the clients over their cap")
+	}
+}
@@ -0,0 +1,158 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrBanned = fmt.Errorf("%w: you are banned from this relay", ErrBlocked)
+
+// Banlist holds the banned and the allowed pubkeys and IPs, each until a time.
+// Allowed pubkeys and IPs (e.g. of the operators or of trusted peers) are exempted from the bans.
+// To share it between multiple relay instances, use a [Cluster]. All methods are safe for concurrent use.
+//
+// Example:
+//
+//	bans := NewBanlist()
+//	relay.Reject.Connection = append(relay.Reject.Connection, bans.RejectConnection)
+//	relay.Reject.Event = append(relay.Reject.Event, bans.RejectEvent)
+//	relay.Reject.Req = append(relay.Reject.Req, bans.RejectReq)
+//	bans.Ban(pubkey, time.Now().Add(24 * time.Hour))
+type Banlist struct {
+	mu      sync.RWMutex
+	bans    map[string]time.Time // pubkey or IP -> until
+	allowed map[string]time.Time // pubkey or IP -> until
+}
+
+// NewBanlist returns an empty [Banlist].
+func NewBanlist() *Banlist {
+	return &Banlist{
+		bans:    make(map[string]time.Time, 1000),
+		allowed: make(map[string]time.Time, 100),
+	}
+}
+
+// Ban the pubkey or IP until the provided time. If it's already banned for longer, the ban is kept.
+func (b *Banlist) Ban(key string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.bans[key]) {
+		b.bans[key] = until
+	}
+}
+
+// Allow the pubkey or IP until the provided time, exempting it from the bans.
+// If it's already allowed for longer, the exemption is kept.
+func (b *Banlist) Allow(key string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.allowed[key]) {
+		b.allowed[key] = until
+	}
+}
+
+// Lift the ban and the exemption of the pubkey or IP, returning until when they would have lasted.
+func (b *Banlist) Lift(key string) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until := b.bans[key]
+	if b.allowed[key].After(until) {
+		until = b.allowed[key]
+	}
+
+	delete(b.bans, key)
+	delete(b.allowed, key)
+	return until
+}
+
+// Banned reports whether the pubkey or IP is currently banned and not allowed.
+func (b *Banlist) Banned(key string) bool {
+	_, banned := b.bannedUntil(key)
+	return banned
+}
+
+// bannedUntil returns when the ban of the pubkey or IP ends, and whether it's currently banned and not allowed.
+func (b *Banlist) bannedUntil(key string) (time.Time, bool) {
+	if key == "" {
+		return time.Time{}, false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	if now.Before(b.allowed[key]) {
+		return time.Time{}, false
+	}
+
+	until := b.bans[key]
+	return until, now.Before(until)
+}
+
+// Bans returns the active bans, by pubkey or IP.
+func (b *Banlist) Bans() map[string]time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	bans := make(map[string]time.Time, len(b.bans))
+	for key, until := range b.bans {
+		if now.Before(until) {
+			bans[key] = until
+		}
+	}
+	return bans
+}
+
+// Prune removes the expired bans and exemptions.
+func (b *Banlist) Prune() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for key, until := range b.bans {
+		if now.After(until) {
+			delete(b.bans, key)
+		}
+	}
+
+	for key, until := range b.allowed {
+		if now.After(until) {
+			delete(b.allowed, key)
+		}
+	}
+}
+
+// RejectConnection is a Reject.Connection hook that refuses the websocket upgrade of the banned IPs,
+// telling them to retry when their ban ends.
+func (b *Banlist) RejectConnection(s Stats, r *http.Request) error {
+	if until, banned := b.bannedUntil(IP(r)); banned {
+		return RetryAfter(ErrBanned, time.Until(until))
+	}
+	return nil
+}
+
+// RejectEvent is a Reject.Event hook that rejects the events of banned authors,
+// and those sent by banned IPs or authenticated pubkeys.
+func (b *Banlist) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if b.Banned(e.PubKey) || b.Banned(c.IP()) || b.Banned(c.Pubkey()) {
+		return ErrBanned
+	}
+	return nil
+}
+
+// RejectReq is a Reject.Req hook that rejects the REQs of banned IPs or authenticated pubkeys.
+func (b *Banlist) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	if b.Banned(c.IP()) || b.Banned(c.Pubkey()) {
+		return ErrBanned
+	}
+	return nil
+}
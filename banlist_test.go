@@ -0,0 +1,47 @@
+package rely
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBanlist(t *testing.T) {
+	bans := NewBanlist()
+	bans.Ban(pk, time.Now().Add(time.Hour))
+	bans.Ban("1.2.3.4", time.Now().Add(-time.Second))
+
+	if !bans.Banned(pk) {
+		t.Error("expected the pubkey to be banned")
+	}
+	if bans.Banned("1.2.3.4") {
+		t.Error("expected the expired ban to be ignored")
+	}
+
+	client := &client{ip: "5.6.7.8"}
+	if err := bans.RejectEvent(context.Background(), client, &nostr.Event{PubKey: pk}); err != ErrBanned {
+		t.Errorf("expected %v for the event of the banned author, got %v", ErrBanned, err)
+	}
+
+	bans.Allow(pk, time.Now().Add(time.Minute))
+	if bans.Banned(pk) {
+		t.Error("expected the allowed pubkey not to be banned")
+	}
+
+	bans.Ban(client.ip, time.Now().Add(time.Hour))
+	if err := bans.RejectReq(context.Background(), client, nil); err != ErrBanned {
+		t.Errorf("expected %v for the REQ of the banned IP, got %v", ErrBanned, err)
+	}
+
+	bans.Lift(client.ip)
+	if err := bans.RejectReq(context.Background(), client, nil); err != nil {
+		t.Errorf("expected the lifted IP to be accepted, got %v", err)
+	}
+
+	bans.Prune()
+	if got := bans.Bans(); len(got) != 1 {
+		t.Errorf("expected 1 active ban, got %v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package rely
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// batchConn is a [net.Conn] that can coalesce multiple websocket frames into a single write.
+// While batching, writes are buffered until [batchConn.Flush] is called, reducing syscalls
+// when many small frames are sent to the same client in a burst.
+type batchConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	batching bool
+	buf      bytes.Buffer
+}
+
+func (b *batchConn) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.batching {
+		return b.Conn.Write(p)
+	}
+	return b.buf.Write(p)
+}
+
+// Batch starts buffering the writes, until the next [batchConn.Flush].
+func (b *batchConn) Batch() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batching = true
+}
+
+// Flush writes the buffered frames with a single write, and stops buffering.
+func (b *batchConn) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batching = false
+	if b.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := b.Conn.Write(b.buf.Bytes())
+	b.buf.Reset()
+	return err
+}
+
+// Buffered returns the number of bytes waiting to be flushed.
+func (b *batchConn) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+// batchHijacker wraps the [http.ResponseWriter] so that the connection hijacked
+// by the websocket upgrader is a [batchConn].
+type batchHijacker struct {
+	http.ResponseWriter
+	conn *batchConn
+}
+
+func (h *batchHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(h.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.conn = &batchConn{Conn: conn}
+	return h.conn, rw, nil
+}
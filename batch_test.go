@@ -0,0 +1,43 @@
+package rely
+
+import (
+	"net"
+	"testing"
+)
+
+type countingConn struct {
+	net.Conn
+	writes int
+	data   []byte
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	c.data = append(c.data, p...)
+	return len(p), nil
+}
+
+func TestBatchConn(t *testing.T) {
+	counter := &countingConn{}
+	conn := &batchConn{Conn: counter}
+
+	conn.Write([]byte("a"))
+	if counter.writes != 1 {
+		t.Fatalf("writes must not be buffered outside of a batch, got %d writes", counter.writes)
+	}
+
+	conn.Batch()
+	conn.Write([]byte("b"))
+	conn.Write([]byte("c"))
+	if counter.writes != 1 || conn.Buffered() != 2 {
+		t.Fatalf("expected the batch to be buffered, got %d writes and %d buffered", counter.writes, conn.Buffered())
+	}
+
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+
+	if counter.writes != 2 || string(counter.data) != "abc" {
+		t.Fatalf("expected the batch in a single write, got %d writes of %q", counter.writes, counter.data)
+	}
+}
@@ -0,0 +1,126 @@
+package rely
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// BudgetMode defines how the number of stored events returned to a client is limited.
+// In all modes, the events returned by a REQ never exceed the remaining capacity of the
+// client's response buffer (see [WithClientResponseLimit]), unless [WithResponseChunking] is used.
+type BudgetMode int
+
+const (
+	// BudgetPerREQ caps the sum of the filters' limits of each REQ to the budget.
+	// This is the default, with a budget equal to the client response limit.
+	BudgetPerREQ BudgetMode = iota
+
+	// BudgetPerFilter caps the limit of each filter to the budget.
+	BudgetPerFilter
+
+	// BudgetPerSecond allows each connection to receive a budget of stored events per second,
+	// with bursts up to the same amount.
+	BudgetPerSecond
+)
+
+func (m BudgetMode) String() string {
+	switch m {
+	case BudgetPerREQ:
+		return "per-req"
+	case BudgetPerFilter:
+		return "per-filter"
+	case BudgetPerSecond:
+		return "per-second"
+	default:
+		return fmt.Sprintf("BudgetMode(%d)", int(m))
+	}
+}
+
+// budgetBucket is the per-second budget of stored events of a client.
+type budgetBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// available refills the bucket with the rate, up to a burst of the same amount, and returns the tokens.
+func (b *budgetBucket) available(rate int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(rate)
+	} else {
+		b.tokens = min(float64(rate), b.tokens+float64(rate)*now.Sub(b.last).Seconds())
+	}
+
+	b.last = now
+	return int(b.tokens)
+}
+
+// spend removes n tokens from the bucket.
+func (b *budgetBucket) spend(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = max(0, b.tokens-float64(n))
+}
+
+// applyBudget adjusts the limits of the filters in-place based on the relay's [BudgetMode]
+// and the memory budget. It returns the total limit and, if any explicit filter limit was reduced
+// or the memory budget was exceeded, the name of the limit responsible (e.g. "per-req" or "memory").
+func (r *Relay) applyBudget(c *client, filters nostr.Filters) (total int, limitedBy string) {
+	requested := make([]int, len(filters))
+	for i := range filters {
+		requested[i] = filters[i].Limit
+	}
+
+	capacity := c.RemainingCapacity()
+	if r.chunkSize > 0 {
+		// the events are sent at the pace of the client, see [WithResponseChunking]
+		capacity = math.MaxInt
+	}
+
+	allowance := r.memoryAllowance()
+	memoryBound := allowance < min(capacity, r.budget)
+	capacity = min(capacity, allowance)
+
+	switch r.budgetMode {
+	case BudgetPerREQ:
+		ApplyBudget(min(capacity, r.budget), filters...)
+
+	case BudgetPerFilter:
+		for i := range filters {
+			if !filters[i].LimitZero && (filters[i].Limit < 1 || filters[i].Limit > r.budget) {
+				filters[i].Limit = r.budget
+			}
+		}
+		ApplyBudget(capacity, filters...)
+
+	case BudgetPerSecond:
+		ApplyBudget(min(capacity, c.budget.available(r.budget)), filters...)
+	}
+
+	var truncated bool
+	for i := range filters {
+		total += filters[i].Limit
+		if requested[i] > filters[i].Limit || (filters[i].Limit == 0 && !filters[i].LimitZero) {
+			// an unspecified limit is truncated when the budget leaves no events
+			truncated = true
+		}
+	}
+
+	switch {
+	case memoryBound && (truncated || total == 0):
+		r.stats.shedReqs.Add(1)
+		return total, "memory"
+	case truncated:
+		return total, r.budgetMode.String()
+	default:
+		return total, ""
+	}
+}
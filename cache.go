@@ -0,0 +1,369 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CacheConfig configures the [Cache].
+type CacheConfig struct {
+	// Upstreams are the websocket URLs of the relays the cache is in front of.
+	Upstreams []string
+
+	// Save stores an event fetched from the upstreams, typically the SaveEvent method of the storage.
+	Save func(context.Context, *nostr.Event) error
+
+	// Reject are the policies of the fetched events, invoked with the client of the REQ before they are saved.
+	// Returning a non-nil error drops the event. Typically the Reject.Event hooks of the relay
+	// that don't depend on who published the event, e.g. [MaxEventSize] or [ExpiredEvent].
+	Reject []func(context.Context, Client, *nostr.Event) error
+
+	// TTL is how long the events of a filter fetched from the upstreams are served from the local storage
+	// alone, before the filter is fetched again.
+	TTL time.Duration
+
+	// Timeout is the deadline of the fetches from the upstreams, and of the forwarding of each event.
+	Timeout time.Duration
+
+	// MaxFilters is the maximum number of fetched filters remembered for the TTL. Once reached,
+	// the new filters are fetched every time until the oldest expire.
+	MaxFilters int
+
+	// MaxFetches is the maximum number of filters fetched from the upstreams at once.
+	// The filters missed beyond it are served from the local storage alone.
+	MaxFetches int
+
+	// Forward publishes the events saved by the relay to the upstreams.
+	Forward bool
+
+	// QueueSize is the number of events waiting to be forwarded, beyond which the new ones are dropped.
+	QueueSize int
+}
+
+// DefaultCacheConfig returns a [CacheConfig] with sane defaults, caching the upstreams into the save.
+func DefaultCacheConfig(save func(context.Context, *nostr.Event) error, upstreams ...string) CacheConfig {
+	return CacheConfig{
+		Upstreams:  upstreams,
+		Save:       save,
+		TTL:        5 * time.Minute,
+		Timeout:    3 * time.Second,
+		MaxFilters: 100_000,
+		MaxFetches: 64,
+		Forward:    true,
+		QueueSize:  1000,
+	}
+}
+
+// Cache makes the relay a caching proxy in front of upstream relays, to be deployed at the edge near the users.
+// The REQs are served from the local storage when possible: a filter whose IDs are all stored, or with at least
+// as many stored events as its limit, is a hit. The stored events are returned right away, and the other filters
+// are fetched from the upstreams in the background: the fetched events are verified, checked by the Reject policies,
+// saved and broadcast to the matching subscriptions. The fetched filters are not fetched again for the TTL.
+// The events published to the relay are saved locally and forwarded to the upstreams by [Cache.Run].
+//
+// If the upstreams require authentication, the cache authenticates with the identity of the relay (see [WithIdentity]).
+//
+// Example:
+//
+//	cache, err := NewCache(relay, DefaultCacheConfig(storage.SaveEvent, "wss://relay.example.com"))
+//	relay.On.Req = cache.Query(storage.QueryEvents)
+//	relay.On.Event = cache.Save(storage.SaveEvent)
+//	go cache.Run(ctx)
+type Cache struct {
+	relay  *Relay
+	config CacheConfig
+	pool   *nostr.SimplePool
+	queue  chan *nostr.Event
+
+	mu       sync.Mutex
+	fetched  map[string]time.Time // the filters fetched from the upstreams, with their expiration
+	fetching map[string]struct{}  // the filters being fetched from the upstreams
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	forwarded atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewCache returns a [Cache] of the relay, or an error if the config is invalid.
+func NewCache(relay *Relay, config CacheConfig) (*Cache, error) {
+	if relay == nil {
+		return nil, errors.New("relay must not be nil")
+	}
+
+	if len(config.Upstreams) == 0 {
+		return nil, errors.New("at least one upstream is required")
+	}
+
+	for _, url := range config.Upstreams {
+		if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+			return nil, fmt.Errorf("the upstreams must be websocket URLs, got %q", url)
+		}
+	}
+
+	if config.Save == nil {
+		return nil, errors.New("the save function is required")
+	}
+
+	if config.TTL <= 0 || config.Timeout <= 0 {
+		return nil, errors.New("the TTL and the timeout must be positive")
+	}
+
+	if config.MaxFilters < 1 || config.MaxFetches < 1 || config.QueueSize < 1 {
+		return nil, errors.New("the max filters, the max fetches and the queue size must be greater than 1")
+	}
+
+	c := &Cache{
+		relay:    relay,
+		config:   config,
+		queue:    make(chan *nostr.Event, config.QueueSize),
+		fetched:  make(map[string]time.Time),
+		fetching: make(map[string]struct{}),
+	}
+
+	c.pool = nostr.NewSimplePool(context.Background(), nostr.WithAuthHandler(func(ctx context.Context, e nostr.RelayEvent) error {
+		identity := relay.Identity()
+		if identity == nil {
+			return errors.New("the relay has no identity to authenticate with")
+		}
+		return identity.Sign(e.Event)
+	}))
+	return c, nil
+}
+
+// Hits returns the number of filters served from the local storage alone.
+func (c *Cache) Hits() int64 { return c.hits.Load() }
+
+// Misses returns the number of filters fetched from the upstreams.
+func (c *Cache) Misses() int64 { return c.misses.Load() }
+
+// Forwarded returns the number of events forwarded to at least one upstream.
+func (c *Cache) Forwarded() int64 { return c.forwarded.Load() }
+
+// Failed returns the number of events that couldn't be forwarded to any upstream, or were dropped.
+func (c *Cache) Failed() int64 { return c.failed.Load() }
+
+// Query wraps the On.Req hook, returning the stored events and fetching in the background the filters
+// they don't serve. The fetched events are broadcast, reaching the subscription of the REQ if it's still open.
+func (c *Cache) Query(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)) func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+	return func(ctx context.Context, client Client, filters nostr.Filters) ([]nostr.Event, error) {
+		events, err := query(ctx, client, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range c.missed(filters, events) {
+			go c.fetch(client, f)
+		}
+		return events, nil
+	}
+}
+
+// missed returns the filters that must be fetched from the upstreams, because they were not fetched
+// recently and the stored events don't fully serve them. They are marked as being fetched, up to the max fetches.
+func (c *Cache) missed(filters nostr.Filters, events []nostr.Event) nostr.Filters {
+	var missed nostr.Filters
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range filters {
+		if f.LimitZero {
+			continue
+		}
+
+		key := f.String()
+		if expiration, ok := c.fetched[key]; (ok && now.Before(expiration)) || served(f, events) {
+			c.hits.Add(1)
+			continue
+		}
+
+		c.misses.Add(1)
+		if _, ok := c.fetching[key]; ok || len(c.fetching) >= c.config.MaxFetches {
+			continue
+		}
+
+		c.fetching[key] = struct{}{}
+		missed = append(missed, f)
+	}
+	return missed
+}
+
+// served reports whether the events fully serve the filter: all of its IDs are there,
+// or at least as many matching events as its limit.
+func served(f nostr.Filter, events []nostr.Event) bool {
+	if len(f.IDs) > 0 {
+		found := make(map[string]struct{}, len(f.IDs))
+		for _, e := range events {
+			if f.Matches(&e) {
+				found[e.ID] = struct{}{}
+			}
+		}
+		return len(found) >= len(f.IDs)
+	}
+
+	if f.Limit <= 0 {
+		return false
+	}
+
+	matched := 0
+	for _, e := range events {
+		if f.Matches(&e) {
+			matched++
+		}
+	}
+	return matched >= f.Limit
+}
+
+// fetch fetches the filter from the upstreams, saving and broadcasting the valid events that are not rejected.
+// The filter is remembered for the TTL if fully fetched before the timeout.
+func (c *Cache) fetch(client Client, f nostr.Filter) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	defer c.done(f)
+
+	for re := range c.pool.FetchMany(ctx, c.config.Upstreams, f) {
+		e := re.Event
+		if !e.CheckID() || !f.Matches(e) {
+			continue
+		}
+
+		if ok, err := e.CheckSignature(); !ok || err != nil {
+			continue
+		}
+
+		if err := c.reject(ctx, client, e); err != nil {
+			c.relay.log.Debug("cache rejected the fetched event", "id", e.ID, "error", err)
+			continue
+		}
+
+		err := c.config.Save(ctx, e)
+		switch {
+		case errors.Is(err, ErrDuplicate):
+			continue
+
+		case err != nil:
+			c.relay.log.Warn("cache failed to save the fetched event", "id", e.ID, "error", err)
+		}
+
+		c.relay.Broadcast(e)
+	}
+
+	if ctx.Err() == nil {
+		c.remember(f)
+	}
+}
+
+// reject applies the Reject policies to the fetched event.
+func (c *Cache) reject(ctx context.Context, client Client, e *nostr.Event) error {
+	for _, reject := range c.config.Reject {
+		if err := reject(ctx, client, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// done marks the filter as no longer being fetched.
+func (c *Cache) done(f nostr.Filter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fetching, f.String())
+}
+
+// remember marks the filter as fetched for the TTL, unless too many filters are remembered.
+func (c *Cache) remember(f nostr.Filter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.fetched) < c.config.MaxFilters {
+		c.fetched[f.String()] = time.Now().Add(c.config.TTL)
+	}
+}
+
+// Save wraps the On.Event hook, queueing the events it saves successfully to be forwarded
+// to the upstreams by [Cache.Run], without blocking.
+func (c *Cache) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, client Client, e *nostr.Event) error {
+		if err := save(ctx, client, e); err != nil {
+			return err
+		}
+
+		if !c.config.Forward {
+			return nil
+		}
+
+		select {
+		case c.queue <- e:
+		default:
+			c.failed.Add(1)
+			c.relay.log.Warn("cache forward queue is full, dropping event", "id", e.ID)
+		}
+		return nil
+	}
+}
+
+// Run forwards the queued events to the upstreams and expires the fetched filters,
+// until the context is cancelled. Then it closes the connections to the upstreams.
+func (c *Cache) Run(ctx context.Context) {
+	defer c.pool.Close("cache stopped")
+
+	ticker := time.NewTicker(c.config.TTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case e := <-c.queue:
+			c.forward(ctx, e)
+
+		case <-ticker.C:
+			c.expire()
+		}
+	}
+}
+
+// forward publishes the event to the upstreams, counting it as forwarded if at least one accepted it.
+func (c *Cache) forward(ctx context.Context, e *nostr.Event) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	accepted := false
+	for result := range c.pool.PublishMany(ctx, c.config.Upstreams, *e) {
+		if result.Error == nil {
+			accepted = true
+			continue
+		}
+		c.relay.log.Debug("cache failed to forward the event", "id", e.ID, "upstream", result.RelayURL, "error", result.Error)
+	}
+
+	if accepted {
+		c.forwarded.Add(1)
+	} else {
+		c.failed.Add(1)
+	}
+}
+
+// expire forgets the fetched filters whose TTL is over.
+func (c *Cache) expire() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, expiration := range c.fetched {
+		if now.After(expiration) {
+			delete(c.fetched, key)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestServed(t *testing.T) {
+	events := []nostr.Event{{ID: "a", Kind: 1}, {ID: "b", Kind: 1}}
+
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		served bool
+	}{
+		{name: "all IDs stored", filter: nostr.Filter{IDs: []string{"a", "b"}}, served: true},
+		{name: "missing ID", filter: nostr.Filter{IDs: []string{"a", "c"}}},
+		{name: "limit reached", filter: nostr.Filter{Kinds: []int{1}, Limit: 2}, served: true},
+		{name: "limit not reached", filter: nostr.Filter{Kinds: []int{1}, Limit: 3}},
+		{name: "without limit", filter: nostr.Filter{Kinds: []int{1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := served(test.filter, events); got != test.served {
+				t.Fatalf("expected served %v, got %v", test.served, got)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upstreamEvent := signedNote(t, nostr.Now()-10)
+	published := make(chan string, 1)
+
+	upstream := NewRelay(WithDomain("example.com"))
+	upstream.On.Req = memoryQuery(upstreamEvent)
+	upstream.On.Event = func(_ context.Context, _ Client, e *nostr.Event) error {
+		published <- e.ID
+		return nil
+	}
+	upstream.Start(ctx)
+
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var local []nostr.Event
+	save := func(_ context.Context, e *nostr.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		local = append(local, *e)
+		return nil
+	}
+
+	query := func(ctx context.Context, c Client, filters nostr.Filters) ([]nostr.Event, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return memoryQuery(local...)(ctx, c, filters)
+	}
+
+	cache, err := NewCache(NewRelay(WithDomain("example.com")), DefaultCacheConfig(save, "ws"+strings.TrimPrefix(server.URL, "http")))
+	if err != nil {
+		t.Fatalf("failed to create the cache: %v", err)
+	}
+	go cache.Run(ctx)
+
+	// the first REQ misses and is served from the storage while fetched from the upstream
+	filters := nostr.Filters{{Kinds: []int{1}, Limit: 10}}
+	events, err := cache.Query(query)(ctx, nil, filters)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no stored events, got %v", events)
+	}
+
+	waitFetched(t, cache, filters[0])
+	if len(local) != 1 {
+		t.Fatalf("expected the fetched event to be saved, got %d events", len(local))
+	}
+
+	// the second REQ is served locally
+	events, err = cache.Query(query)(ctx, nil, filters)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != upstreamEvent.ID {
+		t.Fatalf("expected the upstream event, got %v", events)
+	}
+
+	if cache.Misses() != 1 || cache.Hits() != 1 {
+		t.Fatalf("expected 1 miss and 1 hit, got %d and %d", cache.Misses(), cache.Hits())
+	}
+
+	event := signedNote(t, nostr.Now())
+	if err := cache.Save(func(context.Context, Client, *nostr.Event) error { return nil })(ctx, nil, &event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case id := <-published:
+		if id != event.ID {
+			t.Fatalf("expected the event %s to be forwarded, got %s", event.ID, id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the event was not forwarded")
+	}
+}
+
+func TestCacheRejects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	valid := signedNote(t, nostr.Now()-10)
+	rejected := signedNote(t, nostr.Now()-20)
+	tampered := signedNote(t, nostr.Now()-30)
+	tampered.ID = strings.Repeat("0", 64) // the signature of the content is still valid
+
+	upstream := NewRelay(WithDomain("example.com"))
+	upstream.On.Req = memoryQuery(valid, rejected, tampered)
+	upstream.Start(ctx)
+
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var saved []string
+	config := DefaultCacheConfig(func(_ context.Context, e *nostr.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, e.ID)
+		return nil
+	}, "ws"+strings.TrimPrefix(server.URL, "http"))
+
+	config.Reject = append(config.Reject, func(_ context.Context, _ Client, e *nostr.Event) error {
+		if e.ID == rejected.ID {
+			return ErrRestricted
+		}
+		return nil
+	})
+
+	cache, err := NewCache(NewRelay(WithDomain("example.com")), config)
+	if err != nil {
+		t.Fatalf("failed to create the cache: %v", err)
+	}
+
+	filters := nostr.Filters{{Kinds: []int{1}, Limit: 10}}
+	if _, err := cache.Query(memoryQuery())(ctx, nil, filters); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFetched(t, cache, filters[0])
+	mu.Lock()
+	defer mu.Unlock()
+	if len(saved) != 1 || saved[0] != valid.ID {
+		t.Fatalf("expected only the valid event to be saved, got %v", saved)
+	}
+}
+
+func TestCacheMaxFetches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the upstream doesn't answer, so the fetches last until the timeout
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	upstream := NewRelay(WithDomain("example.com"))
+	upstream.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		<-blocked
+		return nil, nil
+	}
+	upstream.Start(ctx)
+
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	config := DefaultCacheConfig(func(context.Context, *nostr.Event) error { return nil }, "ws"+strings.TrimPrefix(server.URL, "http"))
+	config.MaxFetches = 1
+	cache, err := NewCache(NewRelay(WithDomain("example.com")), config)
+	if err != nil {
+		t.Fatalf("failed to create the cache: %v", err)
+	}
+
+	start := time.Now()
+	filters := nostr.Filters{{Kinds: []int{1}}, {Kinds: []int{1}}, {Kinds: []int{2}}}
+	if _, err := cache.Query(memoryQuery())(ctx, nil, filters); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > config.Timeout/2 {
+		t.Fatalf("expected the query not to wait for the fetches, took %v", elapsed)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.fetching) != 1 {
+		t.Fatalf("expected 1 fetch at once, got %d", len(cache.fetching))
+	}
+}
+
+// waitFetched waits until the cache remembers the filter as fetched.
+func waitFetched(t *testing.T, cache *Cache, f nostr.Filter) {
+	t.Helper()
+	for range 200 {
+		cache.mu.Lock()
+		_, ok := cache.fetched[f.String()]
+		cache.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the filter %v was not fetched", f)
+}
@@ -1,13 +1,13 @@
 package rely
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"slices"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +29,8 @@ var (
 	ErrInvalidAuthKind      = errors.New(`invalid AUTH kind`)
 	ErrInvalidAuthChallenge = errors.New(`invalid AUTH challenge`)
 	ErrInvalidAuthRelay     = errors.New(`invalid AUTH relay`)
+	ErrAuthChallengeExpired = errors.New(`AUTH challenge expired, answer the new one`)
+	ErrAuthReplayed         = errors.New(`AUTH event already used`)
 )
 
 // Client represents the nostr client connected to the relay. All methods are safe for concurrent use.
@@ -40,8 +42,17 @@ type Client interface {
 	// IP address of the client.
 	IP() string
 
+	// Country of the IP address of the client as an ISO 3166-1 alpha-2 code (e.g. "DE"),
+	// or an empty string if it's unknown or the relay has no [GeoIP] (see [WithGeoIP]).
+	Country() string
+
+	// RTT is the round-trip time of the last websocket ping answered by the client,
+	// or zero if it answered none yet.
+	RTT() time.Duration
+
 	// Pubkey the client used to authenticate with NIP-42, or an empty string if it didn't.
-	// To initiate the authentication, call [Client.SendAuth].
+	// To initiate the authentication, call [Client.SendAuth], or reject its EVENT, REQ or COUNT
+	// with an "auth-required:" error, which sends it the AUTH challenge of the connection.
 	Pubkey() string
 
 	// ConnectedAt returns the time when the client connected.
@@ -57,9 +68,13 @@ type Client interface {
 	// SendNotice to the client, useful for greetings, warnings and other informational messages.
 	SendNotice(msg string)
 
+	// SendMessage sends the raw message to the client, which must be a valid JSON array,
+	// for example the response to a custom verb (see [WithVerb]).
+	SendMessage(msg []byte)
+
 	// SendAuth sends the client a newly generated AUTH challenge.
 	// This resets the authentication state: any previously authenticated pubkey is cleared,
-	// and a new challenge is generated and sent.
+	// and a new challenge is generated and sent. It does nothing if the relay uses [WithoutAuth].
 	SendAuth()
 
 	// Disconnect the client, closing its websocket connection with a [websocket.CloseNormalClosure]
@@ -74,6 +89,9 @@ type Client interface {
 	// available in the client's response buffer. Useful for implementing
 	// backpressure or flow-control strategies.
 	RemainingCapacity() int
+
+	// Stats returns a snapshot of the statistics of the connection, see [ClientStats].
+	Stats() ClientStats
 }
 
 // client is a middleman between the websocket connection and the [Relay].
@@ -95,16 +113,43 @@ type Client interface {
 // - read errors in the [client.read] (automatic)
 // - the call to [client.Disconnect] (automatic or manual)
 type client struct {
-	mu        sync.Mutex
-	subs      map[string]subscription
-	pubkey    string
-	challenge string
+	mu           sync.Mutex
+	subs         map[string]subscription
+	pubkey       string
+	challenge    string
+	issuedAt     time.Time // when the challenge was generated, for its expiration
+	challengedAt time.Time // when the challenge was last sent
 
 	uid              string
 	ip               string
+	country          string
 	invalidMessages  int
 	connectedAt      time.Time
+	pingSentAt       atomic.Int64 // unix nanoseconds of the ping waiting for its pong, zero if none
+	rtt              atomic.Int64
 	droppedResponses atomic.Int64
+	budget           budgetBucket
+	pace             pacer // the pace of the archive REQs, see [WithArchiveMode]
+	notices          noticeLimiter
+	scope            *scope // nil if the connection has no scope
+	recorded         bool   // whether the frames are recorded, see [WithRecorder]
+
+	// bytes of the event responses in the send queue, accounted in the memory budget
+	queuedBytes atomic.Int64
+
+	// bytes of the messages written to and read from the connection
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	rejections       atomic.Int64
+	lastActivity     atomic.Int64 // unix nanoseconds of the last message read
+
+	eventsPublished     atomic.Int64
+	eventsAccepted      atomic.Int64
+	eventsRejected      atomic.Int64
+	subscriptionsOpened atomic.Int64
 
 	// pointer to parent relay, which must only be used for:
 	//	- reading settings/hooks
@@ -112,19 +157,28 @@ type client struct {
 	// 	- incrementing atomic counters
 	relay     *Relay
 	conn      *ws.Conn
+	batch     *batchConn // nil if write batching is disabled
 	responses chan response
 
 	isUnregistering atomic.Bool
 	done            chan struct{}
+	disconnection   atomic.Pointer[disconnection] // why the client was disconnected
+
+	// the context of the connection, cancelled when the client disconnects
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (c *client) UID() string            { return c.uid }
 func (c *client) IP() string             { return c.ip }
+func (c *client) Country() string        { return c.country }
+func (c *client) RTT() time.Duration     { return time.Duration(c.rtt.Load()) }
 func (c *client) ConnectedAt() time.Time { return c.connectedAt }
 func (c *client) Age() time.Duration     { return time.Since(c.connectedAt) }
 func (c *client) DroppedResponses() int  { return int(c.droppedResponses.Load()) }
 func (c *client) RemainingCapacity() int { return cap(c.responses) - len(c.responses) }
-func (c *client) SendNotice(msg string)  { c.send(noticeResponse{Message: msg}) }
+func (c *client) SendNotice(msg string)  { c.notice(msg) }
+func (c *client) SendMessage(msg []byte) { c.send(rawResponse(msg)) }
 
 func (c *client) SetPubkey(pk string) {
 	c.mu.Lock()
@@ -139,6 +193,10 @@ func (c *client) Pubkey() string {
 }
 
 func (c *client) SendAuth() {
+	if c.relay.authDisabled {
+		return
+	}
+
 	bytes := make([]byte, authChallengeBytes)
 	rand.Read(bytes)
 	challenge := hex.EncodeToString(bytes)
@@ -148,17 +206,11 @@ func (c *client) SendAuth() {
 
 	c.pubkey = ""
 	c.challenge = challenge
+	c.issuedAt = time.Now()
+	c.challengedAt = c.issuedAt
 	c.send(authResponse{Challenge: challenge})
 }
 
-func (c *client) Disconnect() {
-	if c.isUnregistering.CompareAndSwap(false, true) {
-		close(c.done)
-		c.relay.unregister <- c
-		c.CloseAllSubs()
-	}
-}
-
 // Open or overwrite a subscription.
 func (c *client) Open(s subscription) {
 	c.mu.Lock()
@@ -205,6 +257,21 @@ func (c *client) CloseSubWithReason(id, reason string) {
 	}
 }
 
+// expire closes the subscription and sends a CLOSED message with the provided reason,
+// unless it has already been closed or replaced by another REQ with the same id.
+func (c *client) expire(s subscription, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, exists := c.subs[s.id]
+	if exists && sub.createdAt.Equal(s.createdAt) {
+		sub.cancel()
+		delete(c.subs, s.id)
+		c.relay.unindex(sub)
+		c.send(closedResponse{ID: s.id, Reason: reason})
+	}
+}
+
 // CloseAllSubs closes all subscriptions of the client.
 func (c *client) CloseAllSubs() {
 	c.mu.Lock()
@@ -232,16 +299,34 @@ func (c *client) Subscriptions() []Subscription {
 // It manages creation and cancellation of subscriptions, and sends the request to the [Relay] to be processed.
 func (c *client) read() {
 	defer func() {
-		c.Disconnect()
+		c.disconnectWith(DisconnectError, nil)
 		c.relay.wg.Done()
 	}()
 
 	c.conn.SetReadLimit(c.relay.maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(c.relay.pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(c.relay.pongWait)); return nil })
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.relay.pongWait))
+		c.pong()
+		return nil
+	})
+
+	// the buffer of the current message, returned to the pool before waiting for the next one.
+	var buf *bytes.Buffer
+	defer func() {
+		if buf != nil {
+			putBuffer(buf)
+		}
+	}()
 
 	for {
+		if buf != nil {
+			putBuffer(buf)
+			buf = nil
+		}
+
 		if c.invalidMessages >= 5 {
+			c.setDisconnection(DisconnectKicked, ErrTooManyInvalid)
 			return
 		}
 
@@ -250,68 +335,102 @@ func (c *client) read() {
 			if isUnexpectedClose(err) {
 				c.relay.log.Debug("unexpected close error from IP %s: %v", c.ip, err)
 			}
+			c.setDisconnection(readError(err), err)
 			return
 		}
 
 		if messageType != ws.TextMessage {
 			c.invalidMessages++
-			c.send(noticeResponse{Message: fmt.Sprintf("%v: received binary message", ErrGeneric)})
+			c.notice(fmt.Sprintf("%v: received binary message", ErrGeneric))
 			continue
 		}
 
-		decoder := json.NewDecoder(reader)
+		buf = getBuffer()
+		if _, err := buf.ReadFrom(reader); err != nil {
+			c.setDisconnection(readError(err), err)
+			return
+		}
+
+		data := buf.Bytes()
+		c.account(Inbound, len(data))
+		if c.recorded {
+			c.record(Inbound, bytes.Clone(data))
+		}
+
+		// EVENTs dominate the ingest, so they are parsed without the streaming decoder.
+		// Everything else, including malformed EVENTs, falls back to it.
+		if event, ok := parseEventFast(data); ok {
+			c.eventsPublished.Add(1)
+			if err := c.handleEvent(event); err != nil {
+				c.challengeIfRequired(err.Err)
+				c.sendEventOK(okResponse{ID: err.ID, Saved: false, Reason: reasonMessage(err.Err)})
+				putEvent(event.Event)
+			}
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
 		label, err := parseLabel(decoder)
 		if err != nil {
 			c.invalidMessages++
-			c.send(noticeResponse{Message: fmt.Sprintf("%v: %v", ErrGeneric, err)})
+			c.notice(fmt.Sprintf("%v: %v", ErrGeneric, err))
 			continue
 		}
 
 		switch label {
 		case "EVENT":
+			c.eventsPublished.Add(1)
 			event, err := parseEvent(decoder)
 			if err != nil {
 				c.invalidMessages++
-				c.send(okResponse{ID: err.ID, Saved: false, Reason: err.Error()})
+				c.sendEventOK(okResponse{ID: err.ID, Saved: false, Reason: reasonMessage(err.Err)})
 				continue
 			}
 
 			err = c.handleEvent(event)
 			if err != nil {
-				c.send(okResponse{ID: err.ID, Saved: false, Reason: err.Error()})
+				c.challengeIfRequired(err.Err)
+				c.sendEventOK(okResponse{ID: err.ID, Saved: false, Reason: reasonMessage(err.Err)})
+				putEvent(event.Event)
 			}
 
 		case "REQ":
 			req, err := parseReq(decoder)
 			if err != nil {
 				c.invalidMessages++
-				c.send(closedResponse{ID: err.ID, Reason: err.Error()})
+				c.rejections.Add(1)
+				c.send(closedResponse{ID: err.ID, Reason: reasonMessage(err.Err)})
 				continue
 			}
 
 			err = c.handleReq(req)
 			if err != nil {
-				c.send(closedResponse{ID: err.ID, Reason: err.Error()})
+				c.challengeIfRequired(err.Err)
+				c.rejections.Add(1)
+				c.send(closedResponse{ID: err.ID, Reason: reasonMessage(err.Err)})
 			}
 
 		case "COUNT":
 			count, err := parseCount(decoder)
 			if err != nil {
 				c.invalidMessages++
-				c.send(closedResponse{ID: err.ID, Reason: err.Error()})
+				c.rejections.Add(1)
+				c.send(closedResponse{ID: err.ID, Reason: reasonMessage(err.Err)})
 				continue
 			}
 
 			err = c.handleCount(count)
 			if err != nil {
-				c.send(closedResponse{ID: err.ID, Reason: err.Error()})
+				c.challengeIfRequired(err.Err)
+				c.rejections.Add(1)
+				c.send(closedResponse{ID: err.ID, Reason: reasonMessage(err.Err)})
 			}
 
 		case "CLOSE":
 			close, err := parseClose(decoder)
 			if err != nil {
 				c.invalidMessages++
-				c.send(noticeResponse{Message: err.Error()})
+				c.notice(err.Error())
 				continue
 			}
 
@@ -321,26 +440,50 @@ func (c *client) read() {
 			auth, err := parseAuth(decoder)
 			if err != nil {
 				c.invalidMessages++
-				c.send(okResponse{ID: err.ID, Saved: false, Reason: err.Error()})
+				c.send(okResponse{ID: err.ID, Saved: false, Reason: reasonMessage(err.Err)})
+				continue
+			}
+
+			if c.relay.authDisabled {
+				c.send(okResponse{ID: auth.ID, Saved: false, Reason: reasonMessage(ErrUnsupportedNIP42)})
 				continue
 			}
 
 			if err := c.ValidateAuth(auth); err != nil {
-				c.send(okResponse{ID: err.ID, Saved: false, Reason: err.Error()})
+				c.send(okResponse{ID: err.ID, Saved: false, Reason: reasonMessage(err.Err)})
+				if errors.Is(err.Err, ErrAuthChallengeExpired) {
+					c.challengeAuth()
+				}
 				continue
 			}
 
+			previous := c.Pubkey()
 			c.SetPubkey(auth.PubKey)
 			c.send(okResponse{ID: auth.ID, Saved: true})
 			c.relay.On.Auth(c)
 
+			if previous != "" && previous != auth.PubKey {
+				c.revalidate()
+			}
+
 		default:
-			c.invalidMessages++
-			c.send(noticeResponse{Message: ErrUnsupportedType.Error()})
+			if !c.handleVerb(label, data) {
+				c.handleUnknown(data)
+			}
 		}
 	}
 }
 
+// sendEventOK sends the OK response to an EVENT, counting the event as accepted or rejected.
+func (c *client) sendEventOK(ok okResponse) {
+	if ok.Saved {
+		c.eventsAccepted.Add(1)
+	} else {
+		c.eventsRejected.Add(1)
+	}
+	c.send(ok)
+}
+
 // send a [response] to the client in a non-blocking way.
 // It prevents sending if the client is unregistering.
 func (c *client) send(r response) {
@@ -348,19 +491,87 @@ func (c *client) send(r response) {
 		return
 	}
 
+	if ok, isOK := r.(okResponse); isOK && !ok.Saved {
+		c.rejections.Add(1)
+	}
+
+	var size int64
+	if e, ok := r.(eventResponse); ok && c.relay.memoryLimit > 0 {
+		size = eventSize(e.Event)
+		if c.queuedBytes.Add(size) < 0 {
+			// the writer has exited, nobody will write or release the event
+			return
+		}
+
+		e.size = size
+		r = e
+		c.relay.reserve(size)
+	}
+
 	select {
 	case c.responses <- r:
 	default:
+		c.dequeued(size)
 		c.droppedResponses.Add(1)
 		c.relay.When.GreedyClient(c)
 	}
 }
 
+// dequeued releases the bytes of an event response no longer in the send queue.
+func (c *client) dequeued(size int64) {
+	if size != 0 {
+		c.queuedBytes.Add(-size)
+		c.relay.release(size)
+	}
+}
+
+// responseSize returns the bytes accounted for the response in the memory budget.
+func responseSize(r response) int64 {
+	if e, ok := r.(eventResponse); ok {
+		return e.size
+	}
+	return 0
+}
+
+// waitCapacity waits until the client's response buffer has room for n responses.
+// It returns false if the context is cancelled, the client disconnects, or the timeout expires first.
+func (c *client) waitCapacity(ctx context.Context, n int, timeout time.Duration) bool {
+	if c.RemainingCapacity() >= n {
+		return true
+	}
+
+	ticker := time.NewTicker(chunkPollInterval)
+	defer ticker.Stop()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.done:
+			return false
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+			if c.isUnregistering.Load() {
+				return false
+			}
+			if c.RemainingCapacity() >= n {
+				return true
+			}
+		}
+	}
+}
+
 // The client writes to the websocket whatever [response] it receives in its channel.
 // Periodically it writes [websocket.PingMessage]s.
 func (c *client) write() {
 	ticker := time.NewTicker(c.relay.pingPeriod)
 	defer func() {
+		// the responses left in the queue will never be written
+		c.relay.release(max(0, c.queuedBytes.Swap(writerClosed)))
 		c.conn.Close()
 		ticker.Stop()
 		c.relay.wg.Done()
@@ -381,15 +592,19 @@ func (c *client) write() {
 			return
 
 		case response := <-c.responses:
-			bytes, err := response.MarshalJSON()
-			if err != nil {
-				c.relay.log.Error("failed to marshal response", "response", response, "error", err)
+			var err error
+			if c.batch != nil {
+				err = c.writeBatch(response)
+			} else {
+				err = c.writeResponse(response)
 			}
+			c.dequeued(responseSize(response))
 
-			if err := c.writeMessage(bytes); err != nil {
+			if err != nil {
 				if isUnexpectedClose(err) {
 					c.relay.log.Debug("unexpected error when attemping to write to the IP %s: %v", c.ip, err)
 				}
+				c.setDisconnection(DisconnectError, err)
 				return
 			}
 
@@ -398,30 +613,133 @@ func (c *client) write() {
 				if isUnexpectedClose(err) {
 					c.relay.log.Debug("unexpected error when attemping to ping the IP %s: %v", c.ip, err)
 				}
+				c.setDisconnection(DisconnectError, err)
 				return
 			}
 		}
 	}
 }
 
+// writeResponse marshals the response and writes it to the websocket.
+func (c *client) writeResponse(response response) error {
+	bytes, err := response.MarshalJSON()
+	if err != nil {
+		c.relay.log.Error("failed to marshal response", "response", response, "error", err)
+	}
+
+	c.account(Outbound, len(bytes))
+	if c.recorded {
+		c.record(Outbound, bytes)
+	}
+
+	return c.writeMessage(bytes)
+}
+
+// writeBatch writes the response together with the ones queued within the batch window,
+// up to the maximum number of frames, coalescing them into a single write to the connection.
+func (c *client) writeBatch(first response) error {
+	c.batch.Batch()
+	if err := c.writeResponse(first); err != nil {
+		c.batch.Flush()
+		return err
+	}
+
+	// the batched events are in memory until flushed
+	var batched int64
+	defer func() { c.dequeued(batched) }()
+
+	var window <-chan time.Time
+	if c.relay.batchWindow > 0 {
+		timer := time.NewTimer(c.relay.batchWindow)
+		defer timer.Stop()
+		window = timer.C
+	}
+
+batching:
+	for n := 1; n < c.relay.batchFrames && c.batch.Buffered() < maxBatchBytes; n++ {
+		var response response
+		select {
+		case response = <-c.responses:
+		default:
+			if window == nil {
+				break batching
+			}
+
+			select {
+			case response = <-c.responses:
+			case <-window:
+				break batching
+			case <-c.done:
+				break batching
+			}
+		}
+
+		batched += responseSize(response)
+		if err := c.writeResponse(response); err != nil {
+			c.batch.Flush()
+			return err
+		}
+	}
+
+	return c.batch.Flush()
+}
+
+// account the bytes of a message to the client and, if set, to the relay's [Bandwidth].
+func (c *client) account(dir Direction, n int) {
+	if dir == Inbound {
+		c.messagesReceived.Add(1)
+		c.bytesReceived.Add(int64(n))
+		c.lastActivity.Store(time.Now().UnixNano())
+	} else {
+		c.messagesSent.Add(1)
+		c.bytesSent.Add(int64(n))
+	}
+
+	if c.relay.bandwidth != nil {
+		c.relay.bandwidth.add(c, dir, int64(n))
+	}
+}
+
+// record the frame with the relay's [Recorder].
+func (c *client) record(dir Direction, data []byte) {
+	c.relay.recorder.Record(Frame{
+		Time:      time.Now(),
+		ClientUID: c.uid,
+		IP:        c.ip,
+		Direction: dir,
+		Data:      data,
+	})
+}
+
 func (c *client) handleEvent(e eventRequest) *requestError {
-	for _, reject := range c.relay.Reject.Event {
-		if err := reject(c, e.Event); err != nil {
+	if c.scope != nil {
+		if err := c.scope.checkEvent(e.Event); err != nil {
 			return &requestError{ID: e.Event.ID, Err: err}
 		}
 	}
 
+	e.ctx = c.messageContext()
+	if err := c.rejectEvent(e.ctx, e.Event); err != nil {
+		return &requestError{ID: e.Event.ID, Err: err}
+	}
+
 	e.client = c
+	e.receivedAt = time.Now()
 	return c.relay.tryProcess(e)
 }
 
 func (c *client) handleReq(req reqRequest) *requestError {
-	for _, reject := range c.relay.Reject.Req {
-		if err := reject(c, req.Filters); err != nil {
+	if c.scope != nil {
+		if err := c.scope.narrow(req.Filters); err != nil {
 			return &requestError{ID: req.id, Err: err}
 		}
 	}
 
+	ctx := context.WithValue(c.messageContext(), subscriptionKey{}, req.id)
+	if err := c.rejectFilters(ctx, c.relay.Reject.Req, req.Filters); err != nil {
+		return &requestError{ID: req.id, Err: err}
+	}
+
 	sub := subscription{
 		uid:       join(c.uid, req.id),
 		id:        req.id,
@@ -430,14 +748,34 @@ func (c *client) handleReq(req reqRequest) *requestError {
 		client:    c,
 	}
 
-	req.ctx, sub.cancel = context.WithCancel(context.Background())
+	req.ctx, sub.cancel = context.WithCancel(ctx)
 	req.client = c
+	req.receivedAt = time.Now()
+	req.archive = c.relay.IsArchiveReq(c, req.Filters)
+
+	sub.delivered = &atomic.Int64{}
+	req.delivered = sub.delivered
+
+	if c.relay.replaceableUpdates && hasIDs(req.Filters) {
+		sub.followed = &followedSet{}
+		req.followed = sub.followed
+	}
 
 	if err := c.relay.tryProcess(req); err != nil {
 		return err
 	}
 
+	if c.relay.maxSubLifetime > 0 && !req.archive {
+		s, cancel := sub, sub.cancel
+		timer := time.AfterFunc(c.relay.maxSubLifetime, func() { c.expire(s, ErrSubscriptionLifetime.Error()) })
+		sub.cancel = func() {
+			cancel()
+			timer.Stop()
+		}
+	}
+
 	c.Open(sub)
+	c.subscriptionsOpened.Add(1)
 	return nil
 }
 
@@ -447,16 +785,72 @@ func (c *client) handleCount(count countRequest) *requestError {
 		return &requestError{ID: count.id, Err: ErrUnsupportedNIP45}
 	}
 
-	for _, reject := range c.relay.Reject.Count {
-		if err := reject(c, count.Filters); err != nil {
+	if c.scope != nil {
+		if err := c.scope.narrow(count.Filters); err != nil {
 			return &requestError{ID: count.id, Err: err}
 		}
 	}
 
+	count.ctx = c.messageContext()
+	if err := c.rejectFilters(count.ctx, c.relay.Reject.Count, count.Filters); err != nil {
+		return &requestError{ID: count.id, Err: err}
+	}
+
 	count.client = c
 	return c.relay.tryProcess(count)
 }
 
+// rejectEvent applies the Reject.Event hooks to the event, within the hook timeout.
+func (c *client) rejectEvent(ctx context.Context, e *nostr.Event) error {
+	ctx, cancel := c.relay.hookContext(ctx)
+	defer cancel()
+
+	for _, reject := range c.relay.Reject.Event {
+		if err := reject(ctx, c, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectFilters applies the rejects hooks to the filters, within the hook timeout.
+func (c *client) rejectFilters(ctx context.Context, rejects []func(context.Context, Client, nostr.Filters) error, filters nostr.Filters) error {
+	ctx, cancel := c.relay.hookContext(ctx)
+	defer cancel()
+
+	for _, reject := range rejects {
+		if err := reject(ctx, c, filters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectReq applies the connection scope of the client (narrowing the filters) and the Reject.Req hooks
+// to the filters, as they are applied to the REQs. Custom verbs reading events, such as the SAMPLE of
+// [Sampling], use it so that they can't bypass the read policies of the relay.
+func (r *Relay) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	cl, ok := c.(*client)
+	if !ok {
+		ctx, cancel := r.hookContext(ctx)
+		defer cancel()
+
+		for _, reject := range r.Reject.Req {
+			if err := reject(ctx, c, filters); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if cl.scope != nil {
+		if err := cl.scope.narrow(filters); err != nil {
+			return err
+		}
+	}
+	return cl.rejectFilters(ctx, r.Reject.Req, filters)
+}
+
 // ValidateAuth returns the appropriate error if the auth is invalid, otherwise returns nil.
 func (c *client) ValidateAuth(auth authRequest) *requestError {
 	if auth.Event.Kind != nostr.KindClientAuthentication {
@@ -467,7 +861,7 @@ func (c *client) ValidateAuth(auth authRequest) *requestError {
 		return &requestError{ID: auth.ID, Err: ErrInvalidTimestamp}
 	}
 
-	if !strings.Contains(auth.Relay(), c.relay.domain) {
+	if !c.relay.isAuthRelay(auth.Relay()) {
 		return &requestError{ID: auth.ID, Err: ErrInvalidAuthRelay}
 	}
 
@@ -486,6 +880,14 @@ func (c *client) ValidateAuth(auth authRequest) *requestError {
 	if c.challenge == "" || auth.Challenge() != c.challenge {
 		return &requestError{ID: auth.ID, Err: ErrInvalidAuthChallenge}
 	}
+
+	if c.challengeExpired() {
+		return &requestError{ID: auth.ID, Err: ErrAuthChallengeExpired}
+	}
+
+	if !c.relay.usedAuths.use(auth.ID) {
+		return &requestError{ID: auth.ID, Err: ErrAuthReplayed}
+	}
 	return nil
 }
 
@@ -518,7 +920,20 @@ func (c *client) writeCloseTryLater() error {
 	)
 }
 
+// pong measures the round-trip time of the ping the client answered.
+func (c *client) pong() {
+	sent := c.pingSentAt.Swap(0)
+	if sent == 0 {
+		return // unsolicited pong
+	}
+
+	rtt := time.Since(time.Unix(0, sent))
+	c.rtt.Store(int64(rtt))
+	c.relay.observeRTT(c.country, rtt)
+}
+
 func (c *client) writePing() error {
+	c.pingSentAt.Store(time.Now().UnixNano())
 	return c.conn.WriteControl(
 		ws.PingMessage,
 		nil,
@@ -0,0 +1,338 @@
+package rely
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The kinds of the [ClusterEntry].
+const (
+	EntryBan     = "ban"     // bans the key until the time
+	EntryAllow   = "allow"   // exempts the key from the bans until the time
+	EntryLift    = "lift"    // lifts the ban and the exemption of the key
+	EntryPenalty = "penalty" // lowers the reputation of the IP by the amount
+)
+
+// ClusterEntry is a change of the state shared by the relay instances of a [Cluster].
+type ClusterEntry struct {
+	ID     string    // random ID of the entry
+	Origin string    // instance that published the entry
+	Kind   string    // one of EntryBan, EntryAllow, EntryLift and EntryPenalty
+	Key    string    // pubkey or IP
+	Amount float64   // the penalty of EntryPenalty
+	Until  time.Time // when the entry stops being relevant
+
+	// CreatedAt is set by the [ClusterState] when storing the entry, so that all instances
+	// observe the same clock.
+	CreatedAt time.Time
+}
+
+// ClusterState stores the entries shared by the relay instances, e.g. a database table.
+type ClusterState interface {
+	// SaveClusterEntries stores the entries, setting their creation time.
+	SaveClusterEntries(ctx context.Context, entries []ClusterEntry) error
+
+	// ClusterEntries returns the entries created after the time whose Until hasn't passed,
+	// ordered by creation time.
+	ClusterEntries(ctx context.Context, since time.Time) ([]ClusterEntry, error)
+}
+
+// ClusterConfig configures the [Cluster].
+type ClusterConfig struct {
+	// Instance is the name of the relay instance, unique in the cluster. If empty, a random one is used.
+	Instance string
+
+	// Interval is how often the entries are exchanged with the [ClusterState].
+	Interval time.Duration
+
+	// Overlap is how far back each exchange looks before the last entry seen, so that entries
+	// becoming visible late in the state are not missed.
+	Overlap time.Duration
+
+	// QueueSize is the maximum number of entries waiting to be published. When full, new entries are only applied locally.
+	QueueSize int
+}
+
+// DefaultClusterConfig returns a [ClusterConfig] with sane defaults.
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		Interval:  2 * time.Second,
+		Overlap:   5 * time.Second,
+		QueueSize: 10_000,
+	}
+}
+
+// Cluster replicates the [Banlist] and the rejection penalties of the [Reputation] across
+// multiple relay instances, through a [ClusterState] they share, so that a spammer banned
+// or penalized on one instance is banned or penalized on all of them within seconds.
+//
+// Example:
+//
+//	cluster, err := NewCluster(storage, bans, reputation, DefaultClusterConfig())
+//	relay.Reject.Event = cluster.TrackAll(relay.Reject.Event)
+//	cluster.Ban(pubkey, 24 * time.Hour)
+//	go cluster.Run(ctx)
+type Cluster struct {
+	state      ClusterState
+	banlist    *Banlist
+	reputation *Reputation // nil if the penalties are not replicated
+	config     ClusterConfig
+
+	mu        sync.Mutex
+	pending   []ClusterEntry
+	penalties map[string]float64   // IP -> penalty accumulated since the last exchange
+	seen      map[string]time.Time // ID -> creation of the entries applied
+	last      time.Time            // creation of the last entry seen
+
+	failures atomic.Int64
+}
+
+// NewCluster returns a [Cluster] replicating the banlist and the penalties of the reputation (if not nil)
+// through the state, or an error if the config is invalid.
+func NewCluster(state ClusterState, banlist *Banlist, reputation *Reputation, config ClusterConfig) (*Cluster, error) {
+	if state == nil || banlist == nil {
+		return nil, errors.New("the cluster needs a state and a banlist")
+	}
+
+	if config.Interval <= 0 || config.Overlap < 0 || config.QueueSize <= 0 {
+		return nil, errors.New("the cluster interval and queue size must be positive")
+	}
+
+	if config.Instance == "" {
+		bytes := make([]byte, 8)
+		rand.Read(bytes)
+		config.Instance = hex.EncodeToString(bytes)
+	}
+
+	return &Cluster{
+		state:      state,
+		banlist:    banlist,
+		reputation: reputation,
+		config:     config,
+		penalties:  make(map[string]float64),
+		seen:       make(map[string]time.Time),
+	}, nil
+}
+
+// Instance returns the name of the relay instance in the cluster.
+func (c *Cluster) Instance() string { return c.config.Instance }
+
+// Banlist returns the [Banlist] replicated by the cluster.
+func (c *Cluster) Banlist() *Banlist { return c.banlist }
+
+// Failures returns the number of failed exchanges with the state.
+func (c *Cluster) Failures() int64 { return c.failures.Load() }
+
+// Ban the pubkey or IP for the duration on all instances.
+func (c *Cluster) Ban(key string, duration time.Duration) {
+	until := time.Now().Add(duration)
+	c.banlist.Ban(key, until)
+	c.publish(ClusterEntry{Kind: EntryBan, Key: key, Until: until})
+}
+
+// Allow the pubkey or IP for the duration on all instances, exempting it from the bans.
+func (c *Cluster) Allow(key string, duration time.Duration) {
+	until := time.Now().Add(duration)
+	c.banlist.Allow(key, until)
+	c.publish(ClusterEntry{Kind: EntryAllow, Key: key, Until: until})
+}
+
+// Lift the ban and the exemption of the pubkey or IP on all instances.
+func (c *Cluster) Lift(key string) {
+	until := c.banlist.Lift(key)
+	if until.Before(time.Now()) {
+		// the lift must be kept as long as what it lifts, which may have been loaded later
+		until = time.Now().Add(c.config.Overlap + c.config.Interval)
+	}
+	c.publish(ClusterEntry{Kind: EntryLift, Key: key, Until: until})
+}
+
+// Penalize lowers the reputation of the IP by the amount on all instances.
+// The penalties of the same IP are published once per exchange.
+func (c *Cluster) Penalize(ip string, amount float64) {
+	if c.reputation == nil {
+		return
+	}
+
+	c.reputation.Penalize(ip, amount)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.penalties[ip]; ok || len(c.penalties) < c.config.QueueSize {
+		c.penalties[ip] += amount
+	}
+}
+
+// Track wraps the Reject.Event hook, penalizing the client's IP on all instances every time it rejects an event.
+// It replaces [Reputation.Track].
+func (c *Cluster) Track(reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, client Client, e *nostr.Event) error {
+		err := reject(ctx, client, e)
+		if err != nil && c.reputation != nil {
+			c.Penalize(client.IP(), c.reputation.config.RejectionPenalty)
+		}
+		return err
+	}
+}
+
+// TrackAll wraps all the Reject.Event hooks with [Cluster.Track].
+func (c *Cluster) TrackAll(rejects []func(context.Context, Client, *nostr.Event) error) []func(context.Context, Client, *nostr.Event) error {
+	tracked := make([]func(context.Context, Client, *nostr.Event) error, len(rejects))
+	for i, reject := range rejects {
+		tracked[i] = c.Track(reject)
+	}
+	return tracked
+}
+
+// Run exchanges the entries with the state every interval, and prunes the banlist,
+// until the context is cancelled. The first exchange loads the state shared by the other instances.
+func (c *Cluster) Run(ctx context.Context) {
+	c.exchange(ctx)
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	pruning := time.NewTicker(time.Minute)
+	defer pruning.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// publish the last changes before leaving
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			c.flush(ctx)
+			cancel()
+			return
+
+		case <-ticker.C:
+			c.exchange(ctx)
+
+		case <-pruning.C:
+			c.banlist.Prune()
+		}
+	}
+}
+
+// publish queues the entry to be published with the next exchange.
+func (c *Cluster) publish(entry ClusterEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) < c.config.QueueSize {
+		c.pending = append(c.pending, entry)
+	}
+}
+
+// exchange publishes the pending entries, and applies those published by the other instances.
+func (c *Cluster) exchange(ctx context.Context) {
+	c.flush(ctx)
+
+	c.mu.Lock()
+	since := c.last
+	c.mu.Unlock()
+
+	if !since.IsZero() {
+		since = since.Add(-c.config.Overlap)
+	}
+
+	entries, err := c.state.ClusterEntries(ctx, since)
+	if err != nil {
+		c.failures.Add(1)
+		return
+	}
+	c.apply(entries)
+}
+
+// flush publishes the pending entries and penalties, keeping them for the next exchange if it fails.
+func (c *Cluster) flush(ctx context.Context) {
+	c.mu.Lock()
+	entries := c.pending
+	penalties := c.penalties
+	c.pending = nil
+	c.penalties = make(map[string]float64)
+	c.mu.Unlock()
+
+	if c.reputation != nil && len(penalties) > 0 {
+		// the penalties are irrelevant once decayed, which takes a few half-lives
+		until := time.Now().Add(10 * c.reputation.config.HalfLife)
+		for ip, amount := range penalties {
+			entries = append(entries, ClusterEntry{Kind: EntryPenalty, Key: ip, Amount: amount, Until: until})
+		}
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	for i := range entries {
+		if entries[i].ID == "" {
+			bytes := make([]byte, 16)
+			rand.Read(bytes)
+			entries[i].ID = hex.EncodeToString(bytes)
+			entries[i].Origin = c.config.Instance
+		}
+	}
+
+	if err := c.state.SaveClusterEntries(ctx, entries); err != nil {
+		c.failures.Add(1)
+
+		c.mu.Lock()
+		room := max(c.config.QueueSize-len(c.pending), 0)
+		c.pending = append(c.pending, entries[:min(room, len(entries))]...)
+		c.mu.Unlock()
+	}
+}
+
+// apply applies the entries of the other instances not applied yet, in order of creation.
+func (c *Cluster) apply(entries []ClusterEntry) {
+	slices.SortStableFunc(entries, func(a, b ClusterEntry) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.CreatedAt.After(c.last) {
+			c.last = entry.CreatedAt
+		}
+
+		if _, ok := c.seen[entry.ID]; ok || entry.Origin == c.config.Instance {
+			continue
+		}
+		c.seen[entry.ID] = entry.CreatedAt
+
+		switch entry.Kind {
+		case EntryBan:
+			c.banlist.Ban(entry.Key, entry.Until)
+
+		case EntryAllow:
+			c.banlist.Allow(entry.Key, entry.Until)
+
+		case EntryLift:
+			c.banlist.Lift(entry.Key)
+
+		case EntryPenalty:
+			if c.reputation != nil {
+				// the penalty has decayed since it was published, as it would have on its instance
+				p := penalty{value: entry.Amount, updated: entry.CreatedAt}
+				c.reputation.Penalize(entry.Key, c.reputation.decay(p, now))
+			}
+		}
+	}
+
+	// the entries older than the overlap are never returned again
+	for id, created := range c.seen {
+		if created.Before(c.last.Add(-2 * c.config.Overlap)) {
+			delete(c.seen, id)
+		}
+	}
+}
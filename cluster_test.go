@@ -0,0 +1,109 @@
+package rely
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryState is a [ClusterState] in memory.
+type memoryState struct {
+	mu      sync.Mutex
+	entries []ClusterEntry
+}
+
+func (s *memoryState) SaveClusterEntries(ctx context.Context, entries []ClusterEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		entry.CreatedAt = time.Now()
+		s.entries = append(s.entries, entry)
+	}
+	return nil
+}
+
+func (s *memoryState) ClusterEntries(ctx context.Context, since time.Time) ([]ClusterEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []ClusterEntry
+	for _, entry := range s.entries {
+		if entry.CreatedAt.After(since) && entry.Until.After(time.Now()) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	state := &memoryState{}
+
+	newInstance := func(name string) (*Cluster, *Banlist, *Reputation) {
+		bans := NewBanlist()
+		reputation, err := NewReputation(DefaultReputationConfig())
+		if err != nil {
+			t.Fatalf("failed to create reputation: %v", err)
+		}
+
+		config := DefaultClusterConfig()
+		config.Instance = name
+
+		cluster, err := NewCluster(state, bans, reputation, config)
+		if err != nil {
+			t.Fatalf("failed to create cluster: %v", err)
+		}
+		return cluster, bans, reputation
+	}
+
+	a, bansA, _ := newInstance("a")
+	b, bansB, repB := newInstance("b")
+
+	a.Ban(pk, time.Hour)
+	a.Penalize("1.2.3.4", 10)
+	a.Penalize("1.2.3.4", 5)
+	a.exchange(ctx)
+
+	if bansB.Banned(pk) {
+		t.Fatal("expected the ban not to be replicated before the exchange")
+	}
+
+	b.exchange(ctx)
+	if !bansB.Banned(pk) {
+		t.Error("expected the ban to be replicated")
+	}
+
+	if penalty := repB.penalty("1.2.3.4"); math.Abs(penalty-15) > 0.1 {
+		t.Errorf("expected the penalties to be replicated as 15, got %f", penalty)
+	}
+
+	// applying the same entries again changes nothing
+	b.exchange(ctx)
+	if penalty := repB.penalty("1.2.3.4"); math.Abs(penalty-15) > 0.1 {
+		t.Errorf("expected the penalties to be applied once, got %f", penalty)
+	}
+
+	b.Lift(pk)
+	b.exchange(ctx)
+	a.exchange(ctx)
+	if bansA.Banned(pk) {
+		t.Error("expected the lift to be replicated")
+	}
+
+	// a new instance loads the state
+	c, bansC, _ := newInstance("c")
+	c.Ban("5.6.7.8", time.Minute)
+	c.exchange(ctx)
+
+	if bansC.Banned(pk) {
+		t.Error("expected the lifted ban not to be loaded")
+	}
+
+	a.exchange(ctx)
+	if !bansA.Banned("5.6.7.8") {
+		t.Error("expected the ban of the new instance to be replicated")
+	}
+}
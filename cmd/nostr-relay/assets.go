@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+)
+
+// serveAssets serves the configured icon and banner at /icon and /banner,
+// and advertises their URLs in the NIP-11 document of the relay.
+func serveAssets(mux *http.ServeMux, relay *rely.Relay, cfg config.ServerConfig) error {
+	info := relay.Info()
+	assets := []struct {
+		name string
+		path string
+		url  *string
+	}{
+		{name: "icon", path: cfg.Icon, url: &info.Icon},
+		{name: "banner", path: cfg.Banner, url: &info.Banner},
+	}
+
+	served := 0
+	for _, a := range assets {
+		path := a.path
+		if path == "" && cfg.AssetsDir != "" {
+			path = rely.FindAsset(cfg.AssetsDir, a.name)
+		}
+		if path == "" {
+			continue
+		}
+
+		asset, err := rely.NewAsset(path)
+		if err != nil {
+			return err
+		}
+
+		mux.Handle("GET /"+a.name, asset)
+		*a.url = fmt.Sprintf("https://%s/%s", cfg.Domain, a.name)
+		log.Printf("Serving the %s %s (%s)", a.name, path, asset.ContentType())
+		served++
+	}
+
+	if served == 0 {
+		return nil
+	}
+	return relay.SetInfo(info)
+}
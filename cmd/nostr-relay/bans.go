@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nostr-net/rely"
+)
+
+// registerBans serves the management API of the bans shared by the relay instances, requiring the management token.
+func registerBans(mux *http.ServeMux, token string, cluster *rely.Cluster) {
+	mux.Handle("GET /bans", requireManagement(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cluster.Banlist().Bans())
+	})))
+	mux.Handle("POST /bans", requireManagement(token, banHandler(cluster)))
+	mux.Handle("DELETE /bans/{key}", requireManagement(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster.Lift(r.PathValue("key"))
+		w.WriteHeader(http.StatusNoContent)
+	})))
+}
+
+type banRequest struct {
+	Key      string `json:"key"`      // Pubkey or IP
+	Duration string `json:"duration"` // e.g. "24h"
+	Allow    bool   `json:"allow"`    // Exempt the key from the bans instead of banning it
+}
+
+// banHandler bans the pubkey or IP in the body for the duration, or allows it.
+func banHandler(cluster *rely.Cluster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request banRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil || request.Key == "" {
+			http.Error(w, `the body must be {"key": "<pubkey or IP>", "duration": "24h", "allow": false}`, http.StatusBadRequest)
+			return
+		}
+
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "the duration must be positive, e.g. 24h", http.StatusBadRequest)
+			return
+		}
+
+		if request.Allow {
+			cluster.Allow(request.Key, duration)
+		} else {
+			cluster.Ban(request.Key, duration)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,40 +16,540 @@ type Config struct {
 	Server     ServerConfig     `yaml:"server"`
 	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
 	Monitoring MonitoringConfig `yaml:"monitoring"`
+	Runtime    RuntimeConfig    `yaml:"runtime"`
+	Features   FeaturesConfig   `yaml:"features"`
 	Limits     LimitsConfig     `yaml:"limits"`
+	AntiSpam   AntiSpamConfig   `yaml:"antispam"`
+	Register   RegisterConfig   `yaml:"registration"`
+	GiftWraps  GiftWrapsConfig  `yaml:"giftwraps"`
+	Federation FederationConfig `yaml:"federation"`
+	Firehose   FirehoseConfig   `yaml:"firehose"`
+	Rules      RulesConfig      `yaml:"rules"`
+	Bandwidth  BandwidthConfig  `yaml:"bandwidth"`
+	GeoIP      GeoIPConfig      `yaml:"geoip"`
+	Access     AccessConfig     `yaml:"access"`
+	Cluster    ClusterConfig    `yaml:"cluster"`
+	Archive    ArchiveConfig    `yaml:"archive"`
+	Research   ResearchConfig   `yaml:"research"`
+	Sampling   SamplingConfig   `yaml:"sampling"`
+	Standby    StandbyConfig    `yaml:"standby"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Mutes      MutesConfig      `yaml:"mutes"`
+	NIP46      NIP46Config      `yaml:"nip46"`
+	NIP66      NIP66Config      `yaml:"nip66"`
+	NIP03      NIP03Config      `yaml:"nip03"`
+	NIP05      NIP05Config      `yaml:"nip05"`
+	NIP94      NIP94Config      `yaml:"nip94"`
+	NIP72      NIP72Config      `yaml:"nip72"`
+	Jobs       JobsConfig       `yaml:"jobs"`
+	Debug      DebugConfig      `yaml:"debug"`
 }
 
 // ServerConfig holds relay server configuration
 type ServerConfig struct {
 	Listen              string `yaml:"listen"`
 	Domain              string `yaml:"domain"`
+	TLSCert             string `yaml:"tls_cert"` // PEM certificate file to serve over TLS (empty serves plain HTTP)
+	TLSKey              string `yaml:"tls_key"`  // PEM private key file of the certificate
 	QueueCapacity       int    `yaml:"queue_capacity"`
 	MaxProcessors       int    `yaml:"max_processors"`
 	ClientResponseLimit int    `yaml:"client_response_limit"`
+	ResponseBudgetMode  string `yaml:"response_budget_mode"` // per-req, per-filter or per-second
+	ResponseBudget      int    `yaml:"response_budget"`      // Events allowed by the budget mode (0 uses client_response_limit)
+
+	ResponseChunkSize  int           `yaml:"response_chunk_size"`  // Stored events sent before waiting for the client to read them (0 disables)
+	ResponseChunkPause time.Duration `yaml:"response_chunk_pause"` // Maximum wait for the client to read a chunk
+
+	WriteBatchFrames int           `yaml:"write_batch_frames"` // Outgoing messages coalesced into a single write (0 disables)
+	WriteBatchWindow time.Duration `yaml:"write_batch_window"` // How long to wait for more messages to batch
+
+	MemoryBudget int64 `yaml:"memory_budget"` // Maximum bytes of buffered events before shedding load (0 disables)
+
+	MaxSubscriptionLifetime time.Duration `yaml:"max_subscription_lifetime"` // Subscriptions are closed after this long (0 disables)
+	MaxSubscriptionEvents   int           `yaml:"max_subscription_events"`   // Subscriptions are closed after delivering this many events (0 disables)
+
+	NoticeRate  float64       `yaml:"notice_rate"`  // NOTICEs sent to a client per second (0 disables the limit)
+	NoticeBurst int           `yaml:"notice_burst"` // NOTICEs sent to a client in a burst
+	NoticeDedup time.Duration `yaml:"notice_dedup"` // Identical NOTICEs within this window are suppressed (0 disables)
+
+	DrainPeriod time.Duration `yaml:"drain_period"` // How long to keep serving after SIGTERM while /ready fails
+
+	AllowedOrigins []string `yaml:"allowed_origins"` // Origins of the browser clients allowed to connect (empty allows all)
+	AllowedHosts   []string `yaml:"allowed_hosts"`   // Host headers accepted on the websocket upgrade (empty allows all)
+	TrustedProxies []string `yaml:"trusted_proxies"` // CIDR ranges of the proxies whose X-Forwarded-For is trusted (empty trusts the loopback)
+
+	ConnectionScopes bool `yaml:"connection_scopes"` // Let clients constrain their connection with ?kinds=...&authors=...
+
+	AuthChallengeTTL time.Duration `yaml:"auth_challenge_ttl"` // How long a NIP-42 challenge can be answered (0 disables the expiry)
+
+	UnknownMessages string `yaml:"unknown_messages"` // notice, ignore or disconnect
+
+	HookTimeout time.Duration `yaml:"hook_timeout"` // Deadline of the policies and storage calls of each message (0 disables)
+
+	SecretKey string `yaml:"secret_key"` // Hex secret key of the relay's own keypair (empty uses key_file)
+	KeyFile   string `yaml:"key_file"`   // File holding the secret key, generated if missing (empty disables)
+
+	Icon      string `yaml:"icon"`       // Image served at /icon and advertised in NIP-11 (empty looks for icon.* in assets_dir)
+	Banner    string `yaml:"banner"`     // Image served at /banner and advertised in NIP-11 (empty looks for banner.* in assets_dir)
+	AssetsDir string `yaml:"assets_dir"` // Directory of the icon and banner images (empty disables the lookup)
 }
 
 // ClickHouseConfig holds ClickHouse database configuration
 type ClickHouseConfig struct {
 	DSN           string        `yaml:"dsn"`
+	DSNFile       string        `yaml:"dsn_file"` // File holding the DSN, e.g. a mounted secret (overrides dsn)
 	BatchSize     int           `yaml:"batch_size"`
 	FlushInterval time.Duration `yaml:"flush_interval"`
 	MaxOpenConns  int           `yaml:"max_open_conns"`
 	MaxIdleConns  int           `yaml:"max_idle_conns"`
+
+	QueryLogSampleRate float64 `yaml:"query_log_sample_rate"` // Fraction of queries recorded in the query_log table (0 disables)
+	BlobThreshold      int     `yaml:"blob_threshold"`        // Contents larger than this are stored in the event_blobs table (0 disables)
+
+	Compression  CompressionConfig `yaml:"compression"`
+	Partitioning string            `yaml:"partitioning"` // Partitioning of the event tables: month, kind, month_kind or none
+
+	PlannerInterval time.Duration     `yaml:"planner_interval"` // How often the query planner statistics are collected (0 disables)
+	PlannerHints    map[string]string `yaml:"planner_hints"`    // Tables forced for the filters of a given shape
+
+	CoalesceWindow time.Duration `yaml:"coalesce_window"` // Window within which compatible filters are merged into one query (0 disables)
+
+	NegativeCacheTTL  time.Duration `yaml:"negative_cache_ttl"`  // How long empty results are cached (0 disables)
+	NegativeCacheSize int           `yaml:"negative_cache_size"` // Maximum number of cached empty results
+
+	SessionRetention time.Duration `yaml:"session_retention"` // How long connection sessions are kept for abuse analysis (0 disables)
+
+	ReadMode string `yaml:"read_mode"` // How queries deduplicate unmerged rows: final or dedup
+
+	QueryWindow time.Duration `yaml:"query_window"` // Implicit time window of the filters without since, until and ids (0 disables)
+
+	RejectStaleVersions bool `yaml:"reject_stale_versions"` // Reject replaceable events older than the stored version
+	VersionCacheSize    int  `yaml:"version_cache_size"`    // Addresses whose newest version is remembered
+
+	QueryLimits QueryLimitsConfig `yaml:"query_limits"`
+
+	TagColumns []string `yaml:"tag_columns"` // Tags with a column of the events table besides x, e.g. i, L and l
+}
+
+// QueryLimitsConfig holds the limits of each REQ and COUNT query, applied as ClickHouse settings (0 disables each)
+type QueryLimitsConfig struct {
+	MaxMemoryUsage   uint64        `yaml:"max_memory_usage"`   // Bytes of memory used by a query
+	MaxRowsToRead    uint64        `yaml:"max_rows_to_read"`   // Rows read by a query, before filtering
+	MaxExecutionTime time.Duration `yaml:"max_execution_time"` // Duration of a query
+}
+
+// CompressionConfig holds the column codecs of the event tables, applied to new schemas
+// by the init command and to existing ones by the optimize command
+type CompressionConfig struct {
+	ContentLevel       int  `yaml:"content_level"`        // ZSTD level of content and tags (0 keeps LZ4)
+	DeltaTimestamps    bool `yaml:"delta_timestamps"`     // Delta-encode created_at before compressing it
+	LowCardinalityKind bool `yaml:"low_cardinality_kind"` // Store kind as LowCardinality (new schemas only)
 }
 
 // MonitoringConfig holds monitoring and observability configuration
 type MonitoringConfig struct {
-	StatsInterval   time.Duration `yaml:"stats_interval"`
-	HealthCheckPort int           `yaml:"health_check_port"`
-	EnableMetrics   bool          `yaml:"enable_metrics"`
+	StatsInterval       time.Duration `yaml:"stats_interval"`
+	HealthCheckPort     int           `yaml:"health_check_port"`
+	EnableMetrics       bool          `yaml:"enable_metrics"`
+	Diagnostics         bool          `yaml:"diagnostics"`           // Serve pprof, goroutine dumps and traces under /debug/
+	ManagementToken     string        `yaml:"management_token"`      // Bearer token required by the management endpoints
+	ManagementTokenFile string        `yaml:"management_token_file"` // File holding the management token (overrides management_token)
+	ReadyQueueLoad      float64       `yaml:"ready_queue_load"`      // Queue load above which /ready reports not ready
+
+	StorageMetricsInterval time.Duration `yaml:"storage_metrics_interval"` // How often the ClickHouse system tables are read for /metrics (0 disables)
+}
+
+// RuntimeConfig holds the Go runtime settings. When unset, they are derived from
+// the cgroup limits of the container
+type RuntimeConfig struct {
+	MaxProcs         int     `yaml:"max_procs"`          // GOMAXPROCS (0 uses the container CPU quota)
+	MemoryLimit      int64   `yaml:"memory_limit"`       // Soft memory limit in bytes (0 uses the container memory limit)
+	MemoryLimitRatio float64 `yaml:"memory_limit_ratio"` // Fraction of the container memory used as soft limit
+}
+
+// FeaturesConfig toggles the optional NIPs. Disabled features are removed from the NIP-11 document
+type FeaturesConfig struct {
+	Search     bool `yaml:"search"`     // NIP-50 full-text search
+	Count      bool `yaml:"count"`      // NIP-45 COUNT
+	Auth       bool `yaml:"auth"`       // NIP-42 authentication
+	Deletion   bool `yaml:"deletion"`   // NIP-09 deletion requests (kind 5)
+	Expiration bool `yaml:"expiration"` // NIP-40 expiration, hiding and refusing expired events
+	Pins       bool `yaml:"pins"`       // Operator-pinned events, returned first to the REQs they match
+	Negentropy bool `yaml:"negentropy"` // NIP-77 negentropy set reconciliation, for syncing with clients and relays
+
+	ReplaceableUpdates bool `yaml:"replaceable_updates"` // Push new versions of replaceable events to subscriptions by ID
 }
 
 // LimitsConfig holds rate limiting and resource limits
 type LimitsConfig struct {
-	MaxEventSize      int `yaml:"max_event_size"`
-	MaxSubscriptions  int `yaml:"max_subscriptions"`
-	MaxFiltersPerSub  int `yaml:"max_filters_per_sub"`
-	ConnectionTimeout int `yaml:"connection_timeout"`
+	MaxEventSize         int `yaml:"max_event_size"`
+	LongFormMaxEventSize int `yaml:"long_form_max_event_size"` // Maximum size of the NIP-23 articles and drafts
+	MaxSubscriptions     int `yaml:"max_subscriptions"`        // Weighted subscriptions per client, in broad filters (0 disables)
+	MaxFiltersPerSub     int `yaml:"max_filters_per_sub"`
+	ConnectionTimeout    int `yaml:"connection_timeout"`
+
+	MaxConnections       int           `yaml:"max_connections"`        // Maximum connected clients (0 disables)
+	ReservedConnections  int           `yaml:"reserved_connections"`   // Connections reserved to the priority users
+	ReserveGrace         time.Duration `yaml:"reserve_grace"`          // How long a client in the reserve has to authenticate as a priority user
+	Priority             string        `yaml:"priority"`               // Priority users: authenticated or registered
+	ConnectionRejection  string        `yaml:"connection_rejection"`   // lifo or fair
+	ConnectionRetryAfter time.Duration `yaml:"connection_retry_after"` // When the refused clients are told to reconnect
+
+	QueryBudget QueryBudgetConfig `yaml:"query_budget"`
+}
+
+// QueryBudgetConfig holds the per-client budgets of the estimated cost of the queries,
+// measured in rows scanned by ClickHouse
+type QueryBudgetConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	MaxCost float64 `yaml:"max_cost"` // Maximum cost of a single REQ or COUNT (0 disables)
+	Rate    float64 `yaml:"rate"`     // Cost a client can spend per second
+	Burst   float64 `yaml:"burst"`    // Maximum cost a client can spend at once
+}
+
+// AntiSpamConfig holds the adaptive anti-spam configuration, which tightens PoW and
+// per-IP rate limits when the relay is under load
+type AntiSpamConfig struct {
+	Adaptive      bool          `yaml:"adaptive"`
+	Interval      time.Duration `yaml:"interval"`
+	HighQueueLoad float64       `yaml:"high_queue_load"`
+	LowQueueLoad  float64       `yaml:"low_queue_load"`
+	HighEventRate float64       `yaml:"high_event_rate"`
+	LowEventRate  float64       `yaml:"low_event_rate"`
+	MaxLevel      int           `yaml:"max_level"`
+	BasePow       int           `yaml:"base_pow"`
+	PowStep       int           `yaml:"pow_step"`
+	IPRate        float64       `yaml:"ip_rate"`
+	IPBurst       float64       `yaml:"ip_burst"`
+
+	Duplicates      DuplicatesConfig      `yaml:"duplicates"`
+	Reputation      ReputationConfig      `yaml:"reputation"`
+	Labels          LabelsConfig          `yaml:"labels"`
+	RecentDeletions RecentDeletionsConfig `yaml:"recent_deletions"`
+	URLBlocklist    URLBlocklistConfig    `yaml:"url_blocklist"`
+}
+
+// URLBlocklistConfig holds the rejection of the events whose content links to blocked domains or URLs
+type URLBlocklistConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Domains  []string      `yaml:"domains"`  // Blocked domains, including their subdomains
+	Patterns []string      `yaml:"patterns"` // Regular expressions of the blocked URLs
+	Source   string        `yaml:"source"`   // URL of a blocklist of domains refreshed periodically (empty disables it)
+	Refresh  time.Duration `yaml:"refresh"`  // How often the blocklist is downloaded from the source
+	Timeout  time.Duration `yaml:"timeout"`  // Deadline of the download
+}
+
+// RecentDeletionsConfig holds the rejection of the events re-published soon after being deleted
+type RecentDeletionsConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Window  time.Duration `yaml:"window"` // How long the deleted events are remembered
+}
+
+// LabelsConfig holds the NIP-32 labels published for the events flagged by the moderation policies
+type LabelsConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Namespace string        `yaml:"namespace"` // Namespace of the labels (the "L" tag)
+	Cooldown  time.Duration `yaml:"cooldown"`  // Minimum time between two labels of the same pubkey
+}
+
+// ReputationConfig holds the IP reputation configuration
+type ReputationConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	RejectionPenalty float64       `yaml:"rejection_penalty"` // Score lost for each rejected event
+	HalfLife         time.Duration `yaml:"half_life"`         // Time for half of the penalties to be forgiven
+	DNSBLs           []string      `yaml:"dnsbls"`            // DNS blocklist zones, e.g. zen.spamhaus.org
+	DNSBLPenalty     float64       `yaml:"dnsbl_penalty"`     // Score lost for each DNSBL listing the IP
+	Networks         []string      `yaml:"networks"`          // CIDR ranges of distrusted networks (e.g. ASN prefixes)
+	NetworkPenalty   float64       `yaml:"network_penalty"`   // Score lost for IPs inside the networks
+	RefuseBelow      float64       `yaml:"refuse_below"`      // Refuse connections below this score
+	RestrictBelow    float64       `yaml:"restrict_below"`    // Rate limit events below this score
+	RestrictedRate   float64       `yaml:"restricted_rate"`   // Events per second allowed to restricted IPs
+}
+
+// DuplicatesConfig holds the duplicate-content spam detection configuration
+type DuplicatesConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Window      time.Duration `yaml:"window"`       // How long contents are remembered
+	MaxRepeats  int           `yaml:"max_repeats"`  // Near-duplicates allowed per pubkey within the window
+	MaxDistance int           `yaml:"max_distance"` // Max simhash bit difference to be considered near-duplicate
+	MinLength   int           `yaml:"min_length"`   // Contents shorter than this are not checked
+	Kinds       []int         `yaml:"kinds"`        // Kinds to check (empty for all)
+}
+
+// RegisterConfig holds the configuration of the /register flow, which grants
+// time-limited write permission to pubkeys after a captcha or a Lightning payment
+type RegisterConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	GrantDuration  time.Duration `yaml:"grant_duration"`  // How long the write permission lasts
+	ReloadInterval time.Duration `yaml:"reload_interval"` // How often grants issued by other instances are loaded
+	Captcha        string        `yaml:"captcha"`         // Captcha provider: builtin, turnstile, hcaptcha or none
+	SiteKey        string        `yaml:"site_key"`        // Public key of the captcha provider
+	Secret         string        `yaml:"secret"`          // Secret of the captcha provider, or signing key of the builtin captcha
+	SecretFile     string        `yaml:"secret_file"`     // File holding the secret (overrides secret)
+
+	Lightning LightningConfig `yaml:"lightning"`
+}
+
+// LightningConfig holds the configuration of the paid registration, backed by an LNbits wallet
+type LightningConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	URL        string `yaml:"url"`          // Base URL of the LNbits instance
+	APIKey     string `yaml:"api_key"`      // Invoice/read key of the wallet
+	APIKeyFile string `yaml:"api_key_file"` // File holding the API key (overrides api_key)
+	Amount     int64  `yaml:"amount"`       // Price of a grant in sats
+}
+
+// GiftWrapsConfig holds the NIP-59 gift wrap policies, needed by NIP-17 DM relays
+type GiftWrapsConfig struct {
+	Enabled        bool    `yaml:"enabled"`
+	RecipientRate  float64 `yaml:"recipient_rate"`  // Gift wraps per second a recipient can receive
+	RecipientBurst float64 `yaml:"recipient_burst"` // Gift wraps a recipient can receive in a burst
+	RestrictReads  bool    `yaml:"restrict_reads"`  // Only the authenticated recipient can read its gift wraps
+}
+
+// FederationConfig holds the trust rules for events forwarded by other relays and aggregators
+type FederationConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Peers     []string `yaml:"peers"`      // Pubkeys (hex) forwarding relays authenticate with (NIP-42)
+	Networks  []string `yaml:"networks"`   // CIDR ranges of forwarding relays, trusted without authentication
+	Denied    []string `yaml:"denied"`     // Pubkeys (hex) of relays whose forwarded events are refused
+	PeerRate  float64  `yaml:"peer_rate"`  // Events per second each peer can forward
+	PeerBurst float64  `yaml:"peer_burst"` // Events each peer can forward in a burst
+}
+
+// FirehoseConfig holds the policy of the REQs matching all events
+type FirehoseConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Mode    string   `yaml:"mode"`    // deny, auth or rate-limit
+	Allowed []string `yaml:"allowed"` // Pubkeys (hex) always allowed once authenticated, e.g. archivers
+	Rate    float64  `yaml:"rate"`    // Firehose REQs per second an IP can open in rate-limit mode
+	Burst   float64  `yaml:"burst"`   // Firehose REQs an IP can open in a burst
+}
+
+// RulesConfig holds the routing rules of the events, declared in a separate file reloaded on changes
+type RulesConfig struct {
+	File           string        `yaml:"file"`            // YAML file of the rules (empty disables)
+	ReloadInterval time.Duration `yaml:"reload_interval"` // How often the file is checked for changes (0 reloads only on SIGHUP)
+	Workers        int           `yaml:"workers"`         // Events forwarded or posted concurrently
+	QueueSize      int           `yaml:"queue_size"`      // Events waiting to be forwarded or posted
+	Timeout        time.Duration `yaml:"timeout"`         // Timeout of each forward or webhook
+
+	// Allow the webhooks resolving to loopback and private addresses, refused by default
+	PrivateWebhooks bool `yaml:"private_webhooks"`
+}
+
+// BandwidthConfig holds the accounting of the traffic and its daily caps
+type BandwidthConfig struct {
+	Enabled   bool  `yaml:"enabled"`
+	PubkeyCap int64 `yaml:"pubkey_cap"` // Bytes per day an authenticated pubkey can send and receive (0 disables)
+	IPCap     int64 `yaml:"ip_cap"`     // Bytes per day an IP of unauthenticated clients can send and receive (0 disables)
+}
+
+// GeoIPConfig holds the GeoIP lookup of the client IPs and the per-country connection policy
+type GeoIPConfig struct {
+	Database     string   `yaml:"database"`      // Path of a MaxMind country database, e.g. GeoLite2-Country.mmdb (empty disables)
+	Allowed      []string `yaml:"allowed"`       // Only these countries (ISO codes) can connect, if any
+	Denied       []string `yaml:"denied"`        // These countries (ISO codes) can't connect
+	AllowUnknown bool     `yaml:"allow_unknown"` // IPs of unknown country can connect even if allowed is set
+}
+
+// AccessConfig holds the authentication of the clients when they connect, for private relays
+// whose clients can't rely on NIP-42
+type AccessConfig struct {
+	Required   bool                  `yaml:"required"`    // Refuse the connections without credentials
+	TokenParam string                `yaml:"token_param"` // Query parameter of the tokens
+	Tokens     map[string]string     `yaml:"tokens"`      // Pubkeys (hex) by static token
+	Users      map[string]AccessUser `yaml:"users"`       // HTTP basic auth users by username
+
+	ClientCA     string            `yaml:"client_ca"`    // PEM file of the authorities of the client certificates (mTLS)
+	Certificates map[string]string `yaml:"certificates"` // Pubkeys (hex) by common name of the client certificates
+}
+
+// AccessUser is an HTTP basic auth user of the relay
+type AccessUser struct {
+	Password string `yaml:"password"`
+	Pubkey   string `yaml:"pubkey"` // Pubkey (hex) the user is authenticated as
+}
+
+// ClusterConfig holds the bans and reputation penalties shared by the relay instances through ClickHouse
+type ClusterConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Instance string        `yaml:"instance"` // Name of the instance, unique in the cluster (empty uses a random one)
+	Interval time.Duration `yaml:"interval"` // How often the state is exchanged with the other instances
+}
+
+// ArchiveConfig holds the tee of the stored events to compressed daily files, a recovery path
+// independent of ClickHouse
+type ArchiveConfig struct {
+	Dir           string        `yaml:"dir"`            // Directory of the archive files (empty disables)
+	FlushInterval time.Duration `yaml:"flush_interval"` // How often the events are flushed to disk
+}
+
+// ResearchConfig holds the archive query mode of the researchers, who crawl the history with REQs
+// bounded by since and until in time buckets, without the limits of the other clients
+type ResearchConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Researchers []string      `yaml:"researchers"` // Pubkeys (hex) served in archive mode once authenticated
+	Bucket      time.Duration `yaml:"bucket"`      // Maximum span between since and until of each filter
+	MaxEvents   int           `yaml:"max_events"`  // Events returned by an archive REQ
+	Rate        int           `yaml:"rate"`        // Events per second sent to each researcher (0 for the pace of the researcher)
+	ChunkSize   int           `yaml:"chunk_size"`  // Events sent before waiting for the researcher to read them
+	MaxPause    time.Duration `yaml:"max_pause"`   // Maximum wait for the researcher to read a chunk
+}
+
+// SamplingConfig holds the SAMPLE extension, which returns a uniform random sample of the matching events
+type SamplingConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MaxEvents     int           `yaml:"max_events"`     // Events of a sample
+	MaxConcurrent int           `yaml:"max_concurrent"` // Samples computed at once
+	Timeout       time.Duration `yaml:"timeout"`        // Deadline of each sample
+	RequireAuth   bool          `yaml:"require_auth"`   // Only authenticated clients can request samples
+}
+
+// StandbyConfig holds the hot standby mode, in which the relay replicates the events of a primary relay
+// and rejects the events of the clients until it's promoted, manually or when the primary fails its health check
+type StandbyConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Primary       string        `yaml:"primary"`        // Websocket URL of the primary relay
+	Overlap       time.Duration `yaml:"overlap"`        // Replication resumes this long before the last replicated event
+	PageSize      int           `yaml:"page_size"`      // Events of each page of the backfill, at most the primary's response limit
+	RetryInterval time.Duration `yaml:"retry_interval"` // Wait before reconnecting to the primary
+	HealthURL     string        `yaml:"health_url"`     // Health check of the primary, e.g. its /ready (empty disables the automatic failover)
+	FailoverAfter time.Duration `yaml:"failover_after"` // Promote once the primary has been failing for this long
+	CheckInterval time.Duration `yaml:"check_interval"` // How often the health of the primary is checked
+}
+
+// CacheConfig holds the cache mode, in which the relay is a caching proxy in front of upstream relays:
+// the REQs it can't serve from its storage are fetched upstream and cached, and the events are forwarded upstream
+type CacheConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Upstreams  []string      `yaml:"upstreams"`   // Websocket URLs of the upstream relays
+	TTL        time.Duration `yaml:"ttl"`         // A fetched filter is served from the storage alone for this long
+	Timeout    time.Duration `yaml:"timeout"`     // Deadline of the upstream fetches and of each forward
+	MaxFilters int           `yaml:"max_filters"` // Fetched filters remembered for the TTL
+	MaxFetches int           `yaml:"max_fetches"` // Filters fetched at once, beyond which the misses are served locally
+	Forward    bool          `yaml:"forward"`     // Forward the published events to the upstreams
+	QueueSize  int           `yaml:"queue_size"`  // Events waiting to be forwarded, beyond which they are dropped
+}
+
+// MutesConfig holds the delivery honoring the NIP-51 mute lists (kind 10000) of the authenticated users
+type MutesConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Threads bool          `yaml:"threads"` // Also withhold the muted threads and the replies to them
+	Timeout time.Duration `yaml:"timeout"` // Deadline of the loading of each mute list
+}
+
+// NIP46Config holds the options that make the relay a good transport for NIP-46 remote signing
+type NIP46Config struct {
+	FastPath    bool `yaml:"fast_path"`    // Deliver kind 24133 messages before other requests, without storing them
+	RequireAuth bool `yaml:"require_auth"` // Only authenticated parties can publish and read kind 24133 messages
+}
+
+// NIP66Config holds the publication of the NIP-66 relay discovery events
+type NIP66Config struct {
+	Enabled  bool          `yaml:"enabled"`
+	URL      string        `yaml:"url"`      // Public websocket URL of the relay (empty uses wss://<server.domain>)
+	Relays   []string      `yaml:"relays"`   // Monitor relays where the events are published
+	Interval time.Duration `yaml:"interval"` // How often the relay is checked and its discovery event published
+	Timeout  time.Duration `yaml:"timeout"`  // Timeout of each check and publication
+	Network  string        `yaml:"network"`  // clearnet, tor, i2p or loki
+	Geohash  string        `yaml:"geohash"`  // Optional location of the relay
+
+	ClientRTTs bool `yaml:"client_rtts"` // Publish the median round-trip times of the clients by country
+}
+
+// NIP03Config holds the NIP-03 OpenTimestamps attestations of the stored events
+type NIP03Config struct {
+	Enabled         bool          `yaml:"enabled"`
+	Calendar        string        `yaml:"calendar"`         // URL of the OpenTimestamps calendar server
+	Kinds           []int         `yaml:"kinds"`            // Kinds to timestamp (empty for all)
+	Interval        time.Duration `yaml:"interval"`         // How often the stored events are submitted to the calendar
+	UpgradeInterval time.Duration `yaml:"upgrade_interval"` // How often the Bitcoin attestations are requested
+	MaxAge          time.Duration `yaml:"max_age"`          // How long to wait for the Bitcoin attestations
+}
+
+// NIP05Config holds the verification of the nip05 identifiers of the stored profiles
+type NIP05Config struct {
+	Enabled    bool          `yaml:"enabled"`
+	Timeout    time.Duration `yaml:"timeout"`     // Timeout of each request of the well-known JSON
+	TTL        time.Duration `yaml:"ttl"`         // How long a successful verification is cached
+	FailureTTL time.Duration `yaml:"failure_ttl"` // How long a failed verification is cached
+	Workers    int           `yaml:"workers"`     // Concurrent verifications
+}
+
+// NIP94Config holds the validation of the NIP-94 file metadata events (kind 1063)
+type NIP94Config struct {
+	Enabled  bool          `yaml:"enabled"`
+	CheckURL bool          `yaml:"check_url"` // Reject the events whose file URL is not reachable
+	Timeout  time.Duration `yaml:"timeout"`   // Timeout of each request checking a URL
+}
+
+// NIP72Config holds the moderated communities: their definitions (kind 34550) and the approvals of their posts (kind 4550)
+type NIP72Config struct {
+	Enabled         bool `yaml:"enabled"`
+	HideUnapproved  bool `yaml:"hide_unapproved"`  // Serve only the approved posts in the community feeds
+	VerifyApprovals bool `yaml:"verify_approvals"` // Reject the approvals of authors who don't moderate the community
+}
+
+// JobsConfig holds the scheduled background jobs
+type JobsConfig struct {
+	History    int                 `yaml:"history"` // Runs kept in the history of each job
+	Trending   TrendingJobConfig   `yaml:"trending"`
+	Compaction CompactionJobConfig `yaml:"compaction"`
+
+	ReplaceableHistory ReplaceableHistoryJobConfig `yaml:"replaceable_history"`
+	Reconciliation     ReconciliationJobConfig     `yaml:"reconciliation"`
+	Verification       VerificationJobConfig       `yaml:"verification"`
+}
+
+// JobConfig holds the schedule of a background job
+type JobConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // How often the job runs
+	Jitter   time.Duration `yaml:"jitter"`   // Random delay added to every interval, so that instances don't run in lockstep
+}
+
+// TrendingJobConfig holds the refresh of the hot_posts analytics table
+type TrendingJobConfig struct {
+	JobConfig `yaml:",inline"`
+	Hours     int `yaml:"hours"` // Hours of posts ranked
+}
+
+// CompactionJobConfig holds the merge of the partitions of the event tables with duplicates
+type CompactionJobConfig struct {
+	JobConfig     `yaml:",inline"`
+	MinDuplicates float64       `yaml:"min_duplicates"` // Tables are merged when their duplicate ratio is above this
+	Pause         time.Duration `yaml:"pause"`          // Pause between partitions, to limit the impact on queries
+	MaxQueueLoad  float64       `yaml:"max_queue_load"` // The job is skipped when the queue load is above this
+}
+
+// ReplaceableHistoryJobConfig holds the pruning of the previous versions of the replaceable and addressable events
+type ReplaceableHistoryJobConfig struct {
+	JobConfig `yaml:",inline"`
+	Keep      int `yaml:"keep"` // Previous versions kept for each address, besides the latest one
+}
+
+// ReconciliationJobConfig holds the copy to the projections of the recent rows of the events table they miss
+type ReconciliationJobConfig struct {
+	JobConfig `yaml:",inline"`
+	Window    time.Duration `yaml:"window"` // Rows written within this window are checked, it should exceed the interval
+	Lag       time.Duration `yaml:"lag"`    // Rows written more recently are not checked yet, as their views may be in flight
+}
+
+// VerificationJobConfig holds the check that the projections contain samples of the older events
+type VerificationJobConfig struct {
+	JobConfig `yaml:",inline"`
+	Sample    int           `yaml:"sample"` // Events of a random partition of the events table checked by each run
+	Lag       time.Duration `yaml:"lag"`    // Rows written more recently are left to the reconciliation
+}
+
+// DebugConfig holds options useful for diagnosing client interoperability bugs
+type DebugConfig struct {
+	RecordDir        string  `yaml:"record_dir"`         // Directory for wire traffic recordings (empty disables recording)
+	RecordTable      bool    `yaml:"record_table"`       // Record the wire traffic in the ClickHouse frames table instead of files
+	RecordMaxSize    int64   `yaml:"record_max_size"`    // Size in bytes after which the recording file is rotated
+	RecordSampleRate float64 `yaml:"record_sample_rate"` // Fraction of connections to record, between 0 and 1
+	RecordRedact     bool    `yaml:"record_redact"`      // Replace pubkeys in recorded frames with fingerprints
 }
 
 // Default returns a Config with sensible defaults
@@ -58,6 +561,16 @@ func Default() *Config {
 			QueueCapacity:       2048,
 			MaxProcessors:       8,
 			ClientResponseLimit: 500,
+			ResponseBudgetMode:  "per-req",
+			UnknownMessages:     "notice",
+			ResponseChunkPause:  10 * time.Second,
+			WriteBatchWindow:    time.Millisecond,
+			DrainPeriod:         15 * time.Second,
+			KeyFile:             "relay.key",
+			NoticeRate:          1,
+			NoticeBurst:         10,
+			NoticeDedup:         10 * time.Second,
+			AuthChallengeTTL:    10 * time.Minute,
 		},
 		ClickHouse: ClickHouseConfig{
 			DSN:           "clickhouse://localhost:9000/nostr",
@@ -65,17 +578,230 @@ func Default() *Config {
 			FlushInterval: 1 * time.Second,
 			MaxOpenConns:  10,
 			MaxIdleConns:  5,
+			Compression: CompressionConfig{
+				ContentLevel:    3,
+				DeltaTimestamps: true,
+			},
+			Partitioning:      "month",
+			NegativeCacheSize: 10000,
+			ReadMode:          "final",
+
+			RejectStaleVersions: true,
+			VersionCacheSize:    100000,
 		},
 		Monitoring: MonitoringConfig{
 			StatsInterval:   30 * time.Second,
 			HealthCheckPort: 8080,
 			EnableMetrics:   true,
+			ReadyQueueLoad:  0.9,
+
+			StorageMetricsInterval: 30 * time.Second,
+		},
+		Runtime: RuntimeConfig{
+			MemoryLimitRatio: 0.9,
+		},
+		Features: FeaturesConfig{
+			Search:     true,
+			Count:      true,
+			Auth:       true,
+			Deletion:   true,
+			Expiration: true,
 		},
 		Limits: LimitsConfig{
-			MaxEventSize:      64 * 1024, // 64KB
-			MaxSubscriptions:  20,
-			MaxFiltersPerSub:  10,
-			ConnectionTimeout: 300, // 5 minutes
+			MaxEventSize:         64 * 1024,  // 64KB
+			LongFormMaxEventSize: 256 * 1024, // 256KB
+			MaxSubscriptions:     20,
+			MaxFiltersPerSub:     10,
+			ConnectionTimeout:    300, // 5 minutes
+			ReserveGrace:         15 * time.Second,
+			Priority:             "authenticated",
+			ConnectionRejection:  "lifo",
+			ConnectionRetryAfter: 30 * time.Second,
+			QueryBudget: QueryBudgetConfig{
+				MaxCost: 50_000_000,
+				Rate:    5_000_000,
+				Burst:   100_000_000,
+			},
+		},
+		AntiSpam: AntiSpamConfig{
+			Adaptive:      false,
+			Interval:      5 * time.Second,
+			HighQueueLoad: 0.7,
+			LowQueueLoad:  0.3,
+			HighEventRate: 2000,
+			LowEventRate:  500,
+			MaxLevel:      4,
+			BasePow:       0,
+			PowStep:       4,
+			IPRate:        10,
+			IPBurst:       50,
+			Duplicates: DuplicatesConfig{
+				Enabled:     false,
+				Window:      time.Hour,
+				MaxRepeats:  3,
+				MaxDistance: 3,
+				MinLength:   20,
+				Kinds:       []int{1},
+			},
+			RecentDeletions: RecentDeletionsConfig{
+				Enabled: false,
+				Window:  24 * time.Hour,
+			},
+			URLBlocklist: URLBlocklistConfig{
+				Refresh: time.Hour,
+				Timeout: 30 * time.Second,
+			},
+			Labels: LabelsConfig{
+				Enabled:   false,
+				Namespace: "moderation",
+				Cooldown:  time.Hour,
+			},
+			Reputation: ReputationConfig{
+				Enabled:          false,
+				RejectionPenalty: 2,
+				HalfLife:         time.Hour,
+				DNSBLPenalty:     40,
+				NetworkPenalty:   50,
+				RefuseBelow:      20,
+				RestrictBelow:    60,
+				RestrictedRate:   0.5,
+			},
+		},
+		Register: RegisterConfig{
+			Enabled:        false,
+			GrantDuration:  30 * 24 * time.Hour,
+			ReloadInterval: time.Minute,
+			Captcha:        "builtin",
+			Lightning: LightningConfig{
+				Amount: 1000,
+			},
+		},
+		GiftWraps: GiftWrapsConfig{
+			Enabled:        false,
+			RecipientRate:  1,
+			RecipientBurst: 30,
+			RestrictReads:  true,
+		},
+		Federation: FederationConfig{
+			Enabled:   false,
+			PeerRate:  100,
+			PeerBurst: 1000,
+		},
+		Firehose: FirehoseConfig{
+			Enabled: false,
+			Mode:    "rate-limit",
+			Rate:    1.0 / 60,
+			Burst:   2,
+		},
+		Rules: RulesConfig{
+			ReloadInterval: 30 * time.Second,
+			Workers:        4,
+			QueueSize:      10_000,
+			Timeout:        10 * time.Second,
+		},
+		Archive: ArchiveConfig{
+			FlushInterval: time.Second,
+		},
+		Standby: StandbyConfig{
+			Overlap:       time.Minute,
+			PageSize:      500,
+			RetryInterval: 5 * time.Second,
+			FailoverAfter: 30 * time.Second,
+			CheckInterval: 5 * time.Second,
+		},
+		Cache: CacheConfig{
+			TTL:        5 * time.Minute,
+			Timeout:    3 * time.Second,
+			MaxFilters: 100_000,
+			MaxFetches: 64,
+			Forward:    true,
+			QueueSize:  1000,
+		},
+		Mutes: MutesConfig{
+			Threads: true,
+			Timeout: 5 * time.Second,
+		},
+		Sampling: SamplingConfig{
+			MaxEvents:     1000,
+			MaxConcurrent: 4,
+			Timeout:       30 * time.Second,
+		},
+		Research: ResearchConfig{
+			Bucket:    24 * time.Hour,
+			MaxEvents: 100_000,
+			Rate:      5000,
+			ChunkSize: 500,
+			MaxPause:  time.Minute,
+		},
+		Cluster: ClusterConfig{
+			Interval: 2 * time.Second,
+		},
+		GeoIP: GeoIPConfig{
+			AllowUnknown: true,
+		},
+		NIP46: NIP46Config{
+			FastPath:    true,
+			RequireAuth: false,
+		},
+		NIP66: NIP66Config{
+			Enabled:  false,
+			Interval: time.Hour,
+			Timeout:  10 * time.Second,
+			Network:  "clearnet",
+		},
+		NIP03: NIP03Config{
+			Enabled:         false,
+			Calendar:        "https://alice.btc.calendar.opentimestamps.org",
+			Interval:        10 * time.Minute,
+			UpgradeInterval: time.Hour,
+			MaxAge:          72 * time.Hour,
+		},
+		NIP05: NIP05Config{
+			Enabled:    false,
+			Timeout:    5 * time.Second,
+			TTL:        24 * time.Hour,
+			FailureTTL: time.Hour,
+			Workers:    4,
+		},
+		NIP94: NIP94Config{
+			Enabled: true,
+			Timeout: 5 * time.Second,
+		},
+		NIP72: NIP72Config{
+			Enabled:         true,
+			VerifyApprovals: true,
+		},
+		Jobs: JobsConfig{
+			History: 20,
+			Trending: TrendingJobConfig{
+				JobConfig: JobConfig{Enabled: false, Interval: 30 * time.Minute, Jitter: time.Minute},
+				Hours:     48,
+			},
+			Compaction: CompactionJobConfig{
+				JobConfig:     JobConfig{Enabled: false, Interval: 6 * time.Hour, Jitter: 10 * time.Minute},
+				MinDuplicates: 0.01,
+				Pause:         10 * time.Second,
+				MaxQueueLoad:  0.5,
+			},
+			ReplaceableHistory: ReplaceableHistoryJobConfig{
+				JobConfig: JobConfig{Enabled: false, Interval: time.Hour, Jitter: 5 * time.Minute},
+				Keep:      10,
+			},
+			Reconciliation: ReconciliationJobConfig{
+				JobConfig: JobConfig{Enabled: true, Interval: 10 * time.Minute, Jitter: time.Minute},
+				Window:    time.Hour,
+				Lag:       time.Minute,
+			},
+			Verification: VerificationJobConfig{
+				JobConfig: JobConfig{Enabled: true, Interval: 30 * time.Minute, Jitter: 5 * time.Minute},
+				Sample:    1000,
+				Lag:       time.Hour,
+			},
+		},
+		Debug: DebugConfig{
+			RecordMaxSize:    100 << 20, // 100MB
+			RecordSampleRate: 1,
+			RecordRedact:     true,
 		},
 	}
 }
@@ -104,22 +830,54 @@ func Load() (*Config, error) {
 	}
 
 	// Override with environment variables
-	cfg.applyEnvOverrides()
+	if err := cfg.applyEnvOverrides(); err != nil {
+		return nil, err
+	}
+
+	// Read the secrets from their files and providers
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to resolve the secrets: %w", err)
+	}
 
 	return cfg, nil
 }
 
-// applyEnvOverrides applies environment variable overrides
-func (c *Config) applyEnvOverrides() {
+// applyEnvOverrides applies environment variable overrides.
+// The secrets can also be read from the file named by their _FILE variant (e.g. CLICKHOUSE_DSN_FILE).
+func (c *Config) applyEnvOverrides() error {
 	if listen := os.Getenv("LISTEN"); listen != "" {
 		c.Server.Listen = listen
 	}
 	if domain := os.Getenv("DOMAIN"); domain != "" {
 		c.Server.Domain = domain
 	}
-	if dsn := os.Getenv("CLICKHOUSE_DSN"); dsn != "" {
-		c.ClickHouse.DSN = dsn
+
+	secrets := []struct {
+		env   string
+		value *string
+		file  *string
+	}{
+		{env: "CLICKHOUSE_DSN", value: &c.ClickHouse.DSN, file: &c.ClickHouse.DSNFile},
+		{env: "MANAGEMENT_TOKEN", value: &c.Monitoring.ManagementToken, file: &c.Monitoring.ManagementTokenFile},
+		{env: "RELAY_SECRET_KEY", value: &c.Server.SecretKey},
+		{env: "REGISTRATION_SECRET", value: &c.Register.Secret, file: &c.Register.SecretFile},
+		{env: "LIGHTNING_API_KEY", value: &c.Register.Lightning.APIKey, file: &c.Register.Lightning.APIKeyFile},
 	}
+
+	for _, s := range secrets {
+		value, ok, err := lookupEnvSecret(s.env)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*s.value = value
+			if s.file != nil {
+				// the environment overrides the file of the config
+				*s.file = ""
+			}
+		}
+	}
+	return nil
 }
 
 // Validate validates the configuration
@@ -145,5 +903,342 @@ func (c *Config) Validate() error {
 	if c.Server.MaxProcessors <= 0 {
 		return fmt.Errorf("server.max_processors must be positive")
 	}
+	for _, cidr := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			return fmt.Errorf("server.trusted_proxies has an invalid network %q", cidr)
+		}
+	}
+	switch c.Server.ResponseBudgetMode {
+	case "per-req", "per-filter", "per-second":
+	default:
+		return fmt.Errorf("server.response_budget_mode must be one of per-req, per-filter or per-second")
+	}
+	switch c.Server.UnknownMessages {
+	case "notice", "ignore", "disconnect":
+	default:
+		return fmt.Errorf("server.unknown_messages must be one of notice, ignore or disconnect")
+	}
+	if c.Server.HookTimeout < 0 {
+		return fmt.Errorf("server.hook_timeout must not be negative")
+	}
+	if c.Server.AuthChallengeTTL < 0 {
+		return fmt.Errorf("server.auth_challenge_ttl must not be negative")
+	}
+	if c.Server.ResponseBudget < 0 {
+		return fmt.Errorf("server.response_budget must not be negative")
+	}
+	if c.AntiSpam.Adaptive {
+		if c.AntiSpam.Interval <= 0 {
+			return fmt.Errorf("antispam.interval must be positive")
+		}
+		if c.AntiSpam.LowQueueLoad > c.AntiSpam.HighQueueLoad {
+			return fmt.Errorf("antispam.low_queue_load must not exceed antispam.high_queue_load")
+		}
+		if c.AntiSpam.LowEventRate > c.AntiSpam.HighEventRate {
+			return fmt.Errorf("antispam.low_event_rate must not exceed antispam.high_event_rate")
+		}
+	}
+	if c.AntiSpam.Duplicates.Enabled && c.AntiSpam.Duplicates.Window <= 0 {
+		return fmt.Errorf("antispam.duplicates.window must be positive")
+	}
+	if c.AntiSpam.Duplicates.Enabled && c.AntiSpam.Duplicates.MaxRepeats < 1 {
+		return fmt.Errorf("antispam.duplicates.max_repeats must be positive")
+	}
+	if c.AntiSpam.RecentDeletions.Enabled && c.AntiSpam.RecentDeletions.Window <= 0 {
+		return fmt.Errorf("antispam.recent_deletions.window must be positive")
+	}
+	if c.AntiSpam.URLBlocklist.Enabled && c.AntiSpam.URLBlocklist.Source != "" && (c.AntiSpam.URLBlocklist.Refresh <= 0 || c.AntiSpam.URLBlocklist.Timeout <= 0) {
+		return fmt.Errorf("antispam.url_blocklist.refresh and antispam.url_blocklist.timeout must be positive")
+	}
+	if c.Limits.MaxEventSize <= 0 || c.Limits.LongFormMaxEventSize <= 0 {
+		return fmt.Errorf("limits.max_event_size and limits.long_form_max_event_size must be positive")
+	}
+	if c.Limits.MaxSubscriptions < 0 {
+		return fmt.Errorf("limits.max_subscriptions must not be negative")
+	}
+	if c.Limits.MaxConnections < 0 {
+		return fmt.Errorf("limits.max_connections must not be negative")
+	}
+	if c.Limits.MaxConnections > 0 {
+		if c.Limits.ReservedConnections < 0 || c.Limits.ReservedConnections >= c.Limits.MaxConnections {
+			return fmt.Errorf("limits.reserved_connections must be between 0 and max_connections")
+		}
+		if c.Limits.ReservedConnections > 0 && c.Limits.ReserveGrace <= 0 {
+			return fmt.Errorf("limits.reserve_grace must be positive")
+		}
+		switch c.Limits.Priority {
+		case "authenticated":
+		case "registered":
+			if !c.Register.Enabled {
+				return fmt.Errorf("limits.priority registered requires registration.enabled")
+			}
+		default:
+			return fmt.Errorf("limits.priority must be authenticated or registered")
+		}
+		if c.Limits.ConnectionRejection != "lifo" && c.Limits.ConnectionRejection != "fair" {
+			return fmt.Errorf("limits.connection_rejection must be lifo or fair")
+		}
+		if c.Limits.ConnectionRetryAfter < 0 {
+			return fmt.Errorf("limits.connection_retry_after must not be negative")
+		}
+	}
+	if c.Limits.QueryBudget.Enabled && (c.Limits.QueryBudget.Rate <= 0 || c.Limits.QueryBudget.Burst <= 0) {
+		return fmt.Errorf("limits.query_budget.rate and burst must be positive")
+	}
+	if c.Limits.QueryBudget.Enabled && c.Limits.QueryBudget.MaxCost > c.Limits.QueryBudget.Burst {
+		return fmt.Errorf("limits.query_budget.max_cost must not exceed burst")
+	}
+	if c.Limits.QueryBudget.Enabled && c.ClickHouse.PlannerInterval <= 0 {
+		return fmt.Errorf("limits.query_budget requires clickhouse.planner_interval, whose statistics estimate the costs")
+	}
+	if c.AntiSpam.Labels.Enabled && c.AntiSpam.Labels.Namespace == "" {
+		return fmt.Errorf("antispam.labels.namespace is required")
+	}
+	if c.AntiSpam.Labels.Enabled && c.Server.SecretKey == "" && c.Server.KeyFile == "" {
+		return fmt.Errorf("antispam.labels needs the relay keypair, set server.secret_key or server.key_file")
+	}
+	if c.AntiSpam.Reputation.Enabled && c.AntiSpam.Reputation.HalfLife <= 0 {
+		return fmt.Errorf("antispam.reputation.half_life must be positive")
+	}
+	if c.Register.Enabled {
+		if c.Register.GrantDuration <= 0 {
+			return fmt.Errorf("registration.grant_duration must be positive")
+		}
+		if c.Register.ReloadInterval <= 0 {
+			return fmt.Errorf("registration.reload_interval must be positive")
+		}
+		switch c.Register.Captcha {
+		case "builtin", "none":
+		case "turnstile", "hcaptcha":
+			if c.Register.SiteKey == "" || c.Register.Secret == "" {
+				return fmt.Errorf("registration.site_key and registration.secret are required by %s", c.Register.Captcha)
+			}
+		default:
+			return fmt.Errorf("registration.captcha must be one of builtin, turnstile, hcaptcha or none")
+		}
+		if c.Register.Lightning.Enabled && (c.Register.Lightning.URL == "" || c.Register.Lightning.Amount <= 0) {
+			return fmt.Errorf("registration.lightning requires a url and a positive amount")
+		}
+		if c.Register.Captcha == "none" && !c.Register.Lightning.Enabled {
+			return fmt.Errorf("registration requires a captcha or lightning payments")
+		}
+	}
+	if c.Server.WriteBatchWindow < 0 || c.Server.WriteBatchWindow > time.Second {
+		return fmt.Errorf("server.write_batch_window must be between 0 and 1s")
+	}
+	if c.Server.ResponseChunkSize > 0 && c.Server.ResponseChunkSize >= c.Server.ClientResponseLimit {
+		return fmt.Errorf("server.response_chunk_size must be smaller than server.client_response_limit")
+	}
+	if c.Server.ResponseChunkSize > 0 && c.Server.ResponseChunkPause <= 0 {
+		return fmt.Errorf("server.response_chunk_pause must be positive")
+	}
+	if c.Server.MemoryBudget < 0 {
+		return fmt.Errorf("server.memory_budget must not be negative")
+	}
+	if c.Server.MaxSubscriptionLifetime < 0 {
+		return fmt.Errorf("server.max_subscription_lifetime must not be negative")
+	}
+	if c.Server.MaxSubscriptionEvents < 0 {
+		return fmt.Errorf("server.max_subscription_events must not be negative")
+	}
+	if c.Server.NoticeRate > 0 && c.Server.NoticeBurst < 1 {
+		return fmt.Errorf("server.notice_burst must be at least 1")
+	}
+	if c.Server.DrainPeriod < 0 {
+		return fmt.Errorf("server.drain_period must not be negative")
+	}
+	if c.Monitoring.StorageMetricsInterval < 0 {
+		return fmt.Errorf("monitoring.storage_metrics_interval must not be negative")
+	}
+	if c.Monitoring.ReadyQueueLoad <= 0 || c.Monitoring.ReadyQueueLoad > 1 {
+		return fmt.Errorf("monitoring.ready_queue_load must be between 0 and 1")
+	}
+	if !c.Features.Auth && c.NIP46.RequireAuth {
+		return fmt.Errorf("nip46.require_auth needs features.auth")
+	}
+	if c.Firehose.Enabled {
+		switch c.Firehose.Mode {
+		case "deny", "auth", "rate-limit":
+		default:
+			return fmt.Errorf("firehose.mode must be one of deny, auth or rate-limit")
+		}
+	}
+	if !c.Features.Auth && c.Firehose.Enabled && (c.Firehose.Mode == "auth" || len(c.Firehose.Allowed) > 0) {
+		return fmt.Errorf("firehose.mode auth and firehose.allowed need features.auth")
+	}
+	if c.Rules.File != "" && (c.Rules.Workers <= 0 || c.Rules.QueueSize <= 0 || c.Rules.Timeout <= 0) {
+		return fmt.Errorf("rules.workers, rules.queue_size and rules.timeout must be positive")
+	}
+	if c.Rules.ReloadInterval < 0 {
+		return fmt.Errorf("rules.reload_interval must not be negative")
+	}
+	if c.NIP66.Enabled && len(c.NIP66.Relays) == 0 {
+		return fmt.Errorf("nip66.relays is required when nip66 is enabled")
+	}
+	if c.NIP66.Enabled && c.Server.SecretKey == "" && c.Server.KeyFile == "" {
+		return fmt.Errorf("nip66 needs the relay keypair, set server.secret_key or server.key_file")
+	}
+	if c.NIP03.Enabled && c.Server.SecretKey == "" && c.Server.KeyFile == "" {
+		return fmt.Errorf("nip03 needs the relay keypair, set server.secret_key or server.key_file")
+	}
+	if c.NIP03.Enabled && (c.NIP03.Interval <= 0 || c.NIP03.UpgradeInterval <= 0) {
+		return fmt.Errorf("nip03.interval and nip03.upgrade_interval must be positive")
+	}
+	if c.NIP05.Enabled && c.Server.SecretKey == "" && c.Server.KeyFile == "" {
+		return fmt.Errorf("nip05 needs the relay keypair, set server.secret_key or server.key_file")
+	}
+	if c.NIP94.Enabled && c.NIP94.CheckURL && c.NIP94.Timeout <= 0 {
+		return fmt.Errorf("nip94.timeout must be positive when check_url is enabled")
+	}
+	if c.NIP05.Enabled && (c.NIP05.Timeout <= 0 || c.NIP05.Workers <= 0) {
+		return fmt.Errorf("nip05.timeout and nip05.workers must be positive")
+	}
+	if c.Bandwidth.PubkeyCap < 0 || c.Bandwidth.IPCap < 0 {
+		return fmt.Errorf("bandwidth.pubkey_cap and bandwidth.ip_cap must not be negative")
+	}
+	if c.GeoIP.Database == "" && (len(c.GeoIP.Allowed) > 0 || len(c.GeoIP.Denied) > 0) {
+		return fmt.Errorf("geoip.allowed and geoip.denied need geoip.database")
+	}
+	if c.Archive.Dir != "" && c.Archive.FlushInterval <= 0 {
+		return fmt.Errorf("archive.flush_interval must be positive")
+	}
+	if c.Research.Enabled {
+		if len(c.Research.Researchers) == 0 {
+			return fmt.Errorf("research.researchers must not be empty")
+		}
+		if c.Research.Bucket <= 0 || c.Research.MaxEvents <= 0 || c.Research.ChunkSize <= 0 || c.Research.MaxPause <= 0 {
+			return fmt.Errorf("research.bucket, research.max_events, research.chunk_size and research.max_pause must be positive")
+		}
+		if c.Research.Rate < 0 {
+			return fmt.Errorf("research.rate must not be negative")
+		}
+		if c.Research.ChunkSize >= c.Server.ClientResponseLimit {
+			return fmt.Errorf("research.chunk_size must be smaller than server.client_response_limit")
+		}
+	}
+	if c.Sampling.Enabled && (c.Sampling.MaxEvents <= 0 || c.Sampling.MaxConcurrent <= 0 || c.Sampling.Timeout <= 0) {
+		return fmt.Errorf("sampling.max_events, sampling.max_concurrent and sampling.timeout must be positive")
+	}
+	if c.Standby.Enabled {
+		if c.Standby.Primary == "" {
+			return fmt.Errorf("standby.primary is required")
+		}
+		if c.Standby.PageSize <= 0 || c.Standby.RetryInterval <= 0 || c.Standby.Overlap < 0 {
+			return fmt.Errorf("standby.page_size and standby.retry_interval must be positive, and standby.overlap not negative")
+		}
+		if c.Standby.HealthURL != "" && (c.Standby.FailoverAfter <= 0 || c.Standby.CheckInterval <= 0) {
+			return fmt.Errorf("standby.failover_after and standby.check_interval must be positive")
+		}
+		if c.Standby.PageSize > c.Server.ClientResponseLimit {
+			return fmt.Errorf("standby.page_size must not exceed server.client_response_limit")
+		}
+	}
+	if c.Cache.Enabled {
+		if len(c.Cache.Upstreams) == 0 {
+			return fmt.Errorf("cache.upstreams is required")
+		}
+		if c.Cache.TTL <= 0 || c.Cache.Timeout <= 0 || c.Cache.MaxFilters <= 0 || c.Cache.MaxFetches <= 0 || c.Cache.QueueSize <= 0 {
+			return fmt.Errorf("cache.ttl, cache.timeout, cache.max_filters, cache.max_fetches and cache.queue_size must be positive")
+		}
+	}
+	if c.Mutes.Enabled && c.Mutes.Timeout <= 0 {
+		return fmt.Errorf("mutes.timeout must be positive")
+	}
+	if c.Cluster.Enabled && c.Cluster.Interval <= 0 {
+		return fmt.Errorf("cluster.interval must be positive")
+	}
+	if c.Access.Required && len(c.Access.Tokens) == 0 && len(c.Access.Users) == 0 && len(c.Access.Certificates) == 0 {
+		return fmt.Errorf("access.required needs access.tokens, access.users or access.certificates")
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		return fmt.Errorf("server.tls_cert and server.tls_key must be set together")
+	}
+	if len(c.Access.Certificates) > 0 && (c.Access.ClientCA == "" || c.Server.TLSCert == "") {
+		return fmt.Errorf("access.certificates needs access.client_ca and server.tls_cert")
+	}
+	if !c.Features.Auth && c.GiftWraps.Enabled && c.GiftWraps.RestrictReads {
+		return fmt.Errorf("giftwraps.restrict_reads needs features.auth")
+	}
+	if c.Jobs.History <= 0 {
+		return fmt.Errorf("jobs.history must be positive")
+	}
+	if c.Jobs.Trending.Enabled && (c.Jobs.Trending.Interval <= 0 || c.Jobs.Trending.Hours <= 0) {
+		return fmt.Errorf("jobs.trending.interval and jobs.trending.hours must be positive")
+	}
+	if c.Jobs.ReplaceableHistory.Enabled && (c.Jobs.ReplaceableHistory.Interval <= 0 || c.Jobs.ReplaceableHistory.Keep < 0) {
+		return fmt.Errorf("jobs.replaceable_history.interval must be positive and keep not negative")
+	}
+	if r := c.Jobs.Reconciliation; r.Enabled && (r.Interval <= 0 || r.Lag < 0 || r.Window <= r.Interval) {
+		return fmt.Errorf("jobs.reconciliation.interval must be positive, lag not negative and window longer than the interval")
+	}
+	if v := c.Jobs.Verification; v.Enabled && (v.Interval <= 0 || v.Sample < 1 || v.Lag < 0) {
+		return fmt.Errorf("jobs.verification.interval and sample must be positive, and lag not negative")
+	}
+	if c.Jobs.Compaction.Enabled && c.Jobs.Compaction.Interval <= 0 {
+		return fmt.Errorf("jobs.compaction.interval must be positive")
+	}
+	if c.Features.Pins && c.Monitoring.ManagementToken == "" {
+		return fmt.Errorf("monitoring.management_token is required when features.pins is enabled")
+	}
+	if c.Monitoring.Diagnostics && c.Monitoring.ManagementToken == "" {
+		return fmt.Errorf("monitoring.management_token is required when diagnostics are enabled")
+	}
+	if c.Runtime.MaxProcs < 0 || c.Runtime.MemoryLimit < 0 {
+		return fmt.Errorf("runtime.max_procs and runtime.memory_limit must not be negative")
+	}
+	if c.Runtime.MemoryLimitRatio <= 0 || c.Runtime.MemoryLimitRatio > 1 {
+		return fmt.Errorf("runtime.memory_limit_ratio must be between 0 and 1")
+	}
+	if c.ClickHouse.QueryLogSampleRate < 0 || c.ClickHouse.QueryLogSampleRate > 1 {
+		return fmt.Errorf("clickhouse.query_log_sample_rate must be between 0 and 1")
+	}
+	if c.ClickHouse.BlobThreshold < 0 {
+		return fmt.Errorf("clickhouse.blob_threshold must not be negative")
+	}
+	if c.ClickHouse.Compression.ContentLevel < 0 || c.ClickHouse.Compression.ContentLevel > 22 {
+		return fmt.Errorf("clickhouse.compression.content_level must be between 0 and 22")
+	}
+	if c.ClickHouse.PlannerInterval < 0 {
+		return fmt.Errorf("clickhouse.planner_interval must not be negative")
+	}
+	if c.ClickHouse.CoalesceWindow < 0 {
+		return fmt.Errorf("clickhouse.coalesce_window must not be negative")
+	}
+	if c.ClickHouse.NegativeCacheTTL < 0 || c.ClickHouse.NegativeCacheSize < 0 {
+		return fmt.Errorf("clickhouse.negative_cache_ttl and negative_cache_size must not be negative")
+	}
+	if c.ClickHouse.SessionRetention < 0 {
+		return fmt.Errorf("clickhouse.session_retention must not be negative")
+	}
+	if c.ClickHouse.QueryWindow < 0 {
+		return fmt.Errorf("clickhouse.query_window must not be negative")
+	}
+	if c.ClickHouse.VersionCacheSize < 0 {
+		return fmt.Errorf("clickhouse.version_cache_size must not be negative")
+	}
+	for _, tag := range c.ClickHouse.TagColumns {
+		if len(tag) != 1 || strings.Contains("epatdgr", tag) || !unicode.IsLetter(rune(tag[0])) {
+			return fmt.Errorf("clickhouse.tag_columns must be single letters other than e, p, a, t, d, g and r")
+		}
+	}
+	if c.ClickHouse.QueryLimits.MaxExecutionTime < 0 {
+		return fmt.Errorf("clickhouse.query_limits.max_execution_time must not be negative")
+	}
+	switch c.ClickHouse.Partitioning {
+	case "month", "kind", "month_kind", "none":
+	default:
+		return fmt.Errorf("clickhouse.partitioning must be one of month, kind, month_kind or none")
+	}
+	switch c.ClickHouse.ReadMode {
+	case "final", "dedup":
+	default:
+		return fmt.Errorf("clickhouse.read_mode must be final or dedup")
+	}
+	if c.Debug.RecordSampleRate < 0 || c.Debug.RecordSampleRate > 1 {
+		return fmt.Errorf("debug.record_sample_rate must be between 0 and 1")
+	}
+	if c.Debug.RecordTable && c.Debug.RecordDir != "" {
+		return fmt.Errorf("debug.record_table and debug.record_dir are mutually exclusive")
+	}
 	return nil
 }
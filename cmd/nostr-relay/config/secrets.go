@@ -0,0 +1,264 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider resolves the secret references of its scheme. A reference has the form ${<scheme>:<ref>},
+// e.g. "${vault:secret/data/relay#dsn}", and can be the whole value of a secret or part of it, e.g.
+// "clickhouse://relay:${env:CLICKHOUSE_PASSWORD}@localhost:9000/nostr".
+//
+// The env, file and vault schemes are built in; other stores (e.g. AWS Secrets Manager) are added
+// with [RegisterSecretProvider].
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to a [SecretProvider].
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+func (f SecretProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]SecretProvider{
+		"env":   SecretProviderFunc(envSecret),
+		"file":  SecretProviderFunc(fileSecret),
+		"vault": SecretProviderFunc(vaultSecret),
+	}
+)
+
+// RegisterSecretProvider registers the provider of the secret references of the scheme,
+// replacing the previous one. It must be called before [Load].
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[scheme] = p
+}
+
+// secretRef matches the secret references, e.g. ${file:/run/secrets/dsn}.
+var secretRef = regexp.MustCompile(`\$\{([a-z][a-z0-9]*):([^}]+)\}`)
+
+// secretsTimeout is the deadline of the resolution of all the secrets.
+const secretsTimeout = 30 * time.Second
+
+// secret is a sensitive value of the config, with the path of the file holding it, if any.
+type secret struct {
+	name  string
+	value *string
+	file  string
+}
+
+// secrets returns the sensitive values of the config.
+func (c *Config) secrets() []secret {
+	return []secret{
+		{name: "server.secret_key", value: &c.Server.SecretKey},
+		{name: "clickhouse.dsn", value: &c.ClickHouse.DSN, file: c.ClickHouse.DSNFile},
+		{name: "monitoring.management_token", value: &c.Monitoring.ManagementToken, file: c.Monitoring.ManagementTokenFile},
+		{name: "registration.secret", value: &c.Register.Secret, file: c.Register.SecretFile},
+		{name: "registration.lightning.api_key", value: &c.Register.Lightning.APIKey, file: c.Register.Lightning.APIKeyFile},
+	}
+}
+
+// resolveSecrets reads the secrets from their *_file variants, and replaces the secret references
+// in them and in the access credentials with the values of their providers.
+func (c *Config) resolveSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+
+	for _, s := range c.secrets() {
+		if s.file != "" {
+			value, err := fileSecret(ctx, s.file)
+			if err != nil {
+				return fmt.Errorf("%s_file: %w", s.name, err)
+			}
+			*s.value = value
+		}
+
+		value, err := resolveRefs(ctx, *s.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+		*s.value = value
+	}
+
+	if len(c.Access.Tokens) > 0 {
+		tokens := make(map[string]string, len(c.Access.Tokens))
+		for token, pubkey := range c.Access.Tokens {
+			resolved, err := resolveRefs(ctx, token)
+			if err != nil {
+				return fmt.Errorf("access.tokens: %w", err)
+			}
+			tokens[resolved] = pubkey
+		}
+		c.Access.Tokens = tokens
+	}
+
+	for name, user := range c.Access.Users {
+		password, err := resolveRefs(ctx, user.Password)
+		if err != nil {
+			return fmt.Errorf("access.users.%s.password: %w", name, err)
+		}
+		user.Password = password
+		c.Access.Users[name] = user
+	}
+	return nil
+}
+
+// resolveRefs replaces the secret references in the value with the secrets of their providers.
+func resolveRefs(ctx context.Context, value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var err error
+	resolved := secretRef.ReplaceAllStringFunc(value, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		parts := secretRef.FindStringSubmatch(match)
+		scheme, ref := parts[1], parts[2]
+
+		providersMu.RLock()
+		provider, ok := providers[scheme]
+		providersMu.RUnlock()
+		if !ok {
+			err = fmt.Errorf("unknown secret provider %q", scheme)
+			return match
+		}
+
+		var secret string
+		secret, err = provider.Secret(ctx, ref)
+		if err != nil {
+			err = fmt.Errorf("failed to resolve the %s secret %q: %w", scheme, ref, err)
+		}
+		return secret
+	})
+	return resolved, err
+}
+
+// envSecret returns the value of the environment variable.
+func envSecret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// fileSecret returns the content of the file, without the surrounding whitespace,
+// e.g. of the Docker and Kubernetes secrets mounted in /run/secrets.
+func fileSecret(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// lookupEnvSecret returns the value of the environment variable, or the content of the
+// file named by its _FILE variant (e.g. CLICKHOUSE_DSN_FILE), and whether either is set.
+func lookupEnvSecret(name string) (string, bool, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, true, nil
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+
+	value, err := fileSecret(context.Background(), path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return value, true, nil
+}
+
+// vaultSecret reads a field of a secret from HashiCorp Vault, with the reference "<path>#<field>"
+// (e.g. "secret/data/relay#dsn"), authenticated with the VAULT_ADDR, VAULT_TOKEN and the
+// optional VAULT_NAMESPACE environment variables. KV version 1 and 2 secrets are supported.
+func vaultSecret(ctx context.Context, ref string) (string, error) {
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", fmt.Errorf("the reference must have the form <path>#<field>")
+	}
+
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+
+	endpoint, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return "", fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode the vault response: %w", err)
+	}
+
+	// KV version 2 nests the fields of the secret in data.data
+	data := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &fields); err == nil {
+			data = fields
+		}
+	}
+
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("the secret has no field %q", field)
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("the field %q is not a string", field)
+	}
+	return value, nil
+}
+
+// RedactDSN returns the DSN with its password masked, for logging.
+func RedactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "<invalid DSN>"
+	}
+	return u.Redacted()
+}
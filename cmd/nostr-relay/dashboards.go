@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runDashboards implements the dashboards command, which exports a Grafana dashboard
+// and Prometheus alerting rules generated from the metrics catalog.
+func runDashboards(args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return fmt.Errorf("usage: nostr-relay dashboards export [flags]")
+	}
+
+	flags := flag.NewFlagSet("dashboards export", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory where the files are exported")
+	job := flags.String("job", "nostr-relay", "Prometheus job scraping the relay")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay dashboards export [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Exports grafana-dashboard.json and prometheus-alerts.yaml for the relay metrics.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	dashboard, err := json.MarshalIndent(grafanaDashboard(*job), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var alerts bytes.Buffer
+	encoder := yaml.NewEncoder(&alerts)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(alertRules(*job)); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{name: "grafana-dashboard.json", data: dashboard},
+		{name: "prometheus-alerts.yaml", data: alerts.Bytes()},
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(*dir, file.name)
+		if err := os.WriteFile(path, file.data, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("  created %s\n", path)
+	}
+	return nil
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Datasource  *datasource  `json:"datasource,omitempty"`
+	GridPos     gridPos      `json:"gridPos"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+	Targets     []target     `json:"targets,omitempty"`
+	Collapsed   *bool        `json:"collapsed,omitempty"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+var prometheus = &datasource{Type: "prometheus", UID: "${datasource}"}
+
+// grafanaDashboard returns the dashboard model, with a row for each group of metrics
+// and a time series panel for each metric.
+func grafanaDashboard(job string) map[string]any {
+	var panels []panel
+	var group string
+	var x, y int
+
+	for _, m := range metrics {
+		if m.Group != group {
+			if x > 0 {
+				x, y = 0, y+8
+			}
+
+			collapsed := false
+			group = m.Group
+			panels = append(panels, panel{
+				ID:        len(panels) + 1,
+				Type:      "row",
+				Title:     group,
+				GridPos:   gridPos{H: 1, W: 24, X: 0, Y: y},
+				Collapsed: &collapsed,
+			})
+			y++
+		}
+
+		p := panel{
+			ID:          len(panels) + 1,
+			Type:        "timeseries",
+			Title:       m.Name,
+			Description: m.Help,
+			Datasource:  prometheus,
+			GridPos:     gridPos{H: 8, W: 8, X: x, Y: y},
+			FieldConfig: &fieldConfig{},
+			Targets:     metricTargets(m),
+		}
+		p.FieldConfig.Defaults.Unit = m.Unit
+		panels = append(panels, p)
+
+		if x += 8; x >= 24 {
+			x, y = 0, y+8
+		}
+	}
+
+	return map[string]any{
+		"uid":           "nostr-relay",
+		"title":         "Nostr Relay",
+		"tags":          []string{"nostr", "rely"},
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]any{
+				{"name": "datasource", "type": "datasource", "query": "prometheus"},
+				{
+					"name":       "job",
+					"type":       "query",
+					"datasource": prometheus,
+					"query":      fmt.Sprintf("label_values(%s, job)", clientsMetric.Name),
+					"current":    map[string]string{"text": job, "value": job},
+					"refresh":    1,
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+// metricTargets returns the queries of the panel of the metric:
+// counters are shown as rates, summaries by their label (e.g. the operation) and quantile.
+func metricTargets(m metric) []target {
+	selector := `{job="$job"}`
+	switch m.Type {
+	case "counter":
+		return []target{{Expr: fmt.Sprintf("rate(%s%s[$__rate_interval])", m.Name, selector), LegendFormat: "{{instance}}", RefID: "A"}}
+
+	case "summary":
+		label := m.Label
+		if label == "" {
+			label = "op"
+		}
+		return []target{
+			{Expr: fmt.Sprintf(`max by (%s) (%s{job="$job",quantile="0.5"})`, label, m.Name), LegendFormat: "{{" + label + "}} p50", RefID: "A"},
+			{Expr: fmt.Sprintf(`max by (%s) (%s{job="$job",quantile="0.99"})`, label, m.Name), LegendFormat: "{{" + label + "}} p99", RefID: "B"},
+		}
+
+	default:
+		return []target{{Expr: m.Name + selector, LegendFormat: "{{instance}}", RefID: "A"}}
+	}
+}
+
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+func newRule(name, expr, duration, severity, summary string) rule {
+	return rule{
+		Alert:       name,
+		Expr:        expr,
+		For:         duration,
+		Labels:      map[string]string{"severity": severity},
+		Annotations: map[string]string{"summary": summary},
+	}
+}
+
+// alertRules returns the Prometheus alerting rules for the relay metrics.
+func alertRules(job string) ruleGroups {
+	s := fmt.Sprintf(`{job=%q}`, job)
+	return ruleGroups{Groups: []ruleGroup{{
+		Name: "nostr-relay",
+		Rules: []rule{
+			newRule("RelayDown",
+				fmt.Sprintf("up%s == 0", s), "2m", "critical",
+				"The relay {{ $labels.instance }} is not reachable"),
+
+			newRule("RelayQueueSaturated",
+				fmt.Sprintf("%s%s > 0.8", queueLoadMetric.Name, s), "5m", "warning",
+				"The processing queue of {{ $labels.instance }} is above 80%"),
+
+			newRule("RelayMemoryBudgetNearlyExhausted",
+				fmt.Sprintf("%s%s / (%s%s > 0) > 0.9", bufferedMetric.Name, s, memoryBudgetMetric.Name, s), "5m", "warning",
+				"The buffered events of {{ $labels.instance }} are above 90% of the memory budget"),
+
+			newRule("RelaySheddingLoad",
+				fmt.Sprintf("rate(%s%s[5m]) > 0", shedEventsMetric.Name, s), "10m", "critical",
+				"The relay {{ $labels.instance }} is rejecting events because the memory budget is exceeded"),
+
+			newRule("RelaySlowEvents",
+				fmt.Sprintf(`%s{job=%q,op="event",quantile="0.99"} > 1`, latencyMetric.Name, job), "10m", "warning",
+				"The p99 latency of EVENTs on {{ $labels.instance }} is above 1s"),
+
+			newRule("RelayHeapNearMemoryLimit",
+				fmt.Sprintf("%s%s / %s%s > 0.9", heapInuseMetric.Name, s, memoryLimitMetric.Name, s), "10m", "warning",
+				"The heap of {{ $labels.instance }} is above 90% of the Go memory limit"),
+
+			newRule("ClickHouseTooManyParts",
+				fmt.Sprintf("%s%s > 300", clickhousePartsMetric.Name, s), "15m", "warning",
+				"The table {{ $labels.table }} has more than 300 active parts, ClickHouse will soon throttle the inserts"),
+
+			newRule("ClickHouseDiskNearlyFull",
+				fmt.Sprintf("%s%s / %s%s < 0.1", clickhouseDiskFreeMetric.Name, s, clickhouseDiskTotalMetric.Name, s), "10m", "critical",
+				"The disk {{ $labels.disk }} of ClickHouse has less than 10% of free space"),
+
+			newRule("ClickHouseProjectionDiverged",
+				fmt.Sprintf("%s%s > 0.01", projectionDivergenceMetric.Name, s), "1h", "warning",
+				"More than 1% of the events sampled are missing from the projection {{ $labels.projection }}"),
+
+			newRule("RelayUnderSpamAttack",
+				fmt.Sprintf("%s%s > 0", antispamLevelMetric.Name, s), "30m", "info",
+				"The adaptive anti-spam of {{ $labels.instance }} has been tightened for 30 minutes"),
+		},
+	}}}
+}
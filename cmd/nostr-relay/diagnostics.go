@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+	"strings"
+)
+
+// registerDiagnostics serves the pprof profiles, goroutine dumps and execution traces
+// under /debug/, guarded by the management token.
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/debug/goroutines
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/debug/pprof/profile?seconds=30 > cpu.out
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/debug/pprof/trace?seconds=5 > trace.out
+func registerDiagnostics(mux *http.ServeMux, token string) {
+	mux.Handle("/debug/pprof/", requireManagement(token, http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", requireManagement(token, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", requireManagement(token, http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", requireManagement(token, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", requireManagement(token, http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/debug/goroutines", requireManagement(token, http.HandlerFunc(goroutinesHandler)))
+}
+
+// goroutinesHandler writes the stack traces of all goroutines, in the same format
+// of an unrecovered panic, which is the fastest way to understand a stall.
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// requireManagement only allows the requests carrying the management token as a bearer token.
+func requireManagement(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="management"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// runExplain implements the explain command, which prints how the storage queries a filter:
+// the table it's routed to, its SQL, the rows ClickHouse estimates to read and, with -run, its timing.
+func runExplain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	raw := flags.String("filter", "", `the filter as JSON, e.g. '{"kinds":[1],"limit":100}' (required)`)
+	run := flags.Bool("run", false, "also run the query and report its timing")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay explain -filter <json> [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Prints the table and SQL the filter is queried with, and the parts, marks and rows\n")
+		fmt.Fprintf(flags.Output(), "ClickHouse estimates to read (EXPLAIN ESTIMATE).\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *raw == "" {
+		flags.Usage()
+		return errors.New("the -filter is required")
+	}
+
+	var filter nostr.Filter
+	if err := json.Unmarshal([]byte(*raw), &filter); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	_, storage, err := maintenanceStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	ex, err := storage.Explain(ctx, filter, *run)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("table:     %s\n", ex.Table)
+	if ex.Windowed {
+		fmt.Printf("           unbounded, queried within the implicit window first (escalated if it doesn't fill the limit)\n")
+	}
+	fmt.Printf("estimate:  %d rows in %d parts (%d marks), planner cost %.0f\n", ex.Rows, ex.Parts, ex.Marks, ex.Cost)
+	if ex.Ran {
+		fmt.Printf("run:       %d events in %s\n", ex.Events, ex.Duration.Round(time.Microsecond))
+	}
+
+	fmt.Printf("\n%s\n", ex.Query)
+	if len(ex.Args) > 0 {
+		fmt.Printf("\nargs: %v\n", ex.Args)
+	}
+	return nil
+}
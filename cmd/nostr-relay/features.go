@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+)
+
+var errDeletionDisabled = fmt.Errorf("%w: NIP-09 deletion requests are not accepted", rely.ErrBlocked)
+
+// relayInfo returns the NIP-11 document. Its supported NIPs are computed by the relay
+// from the enabled features, see [rely.Relay.Supports].
+func relayInfo() nip11.RelayInformationDocument {
+	return nip11.RelayInformationDocument{
+		Software: software,
+		Version:  version,
+	}
+}
+
+// applyFeatures turns off the subsystems of the disabled features, and declares the NIPs of the enabled ones.
+// NIP-42 is turned off with [rely.WithoutAuth], and the NIP-77 verbs registered with [rely.WithVerb], when creating the relay.
+func applyFeatures(relay *rely.Relay, features config.FeaturesConfig, rejections *rely.Rejections) {
+	if !features.Count {
+		relay.On.Count = nil
+	}
+
+	if features.Search {
+		relay.Supports(50)
+	} else {
+		relay.Reject.Req = append(relay.Reject.Req, rely.UnsupportedSearch)
+		relay.Reject.Count = append(relay.Reject.Count, rely.UnsupportedSearch)
+	}
+
+	if features.Deletion {
+		relay.Supports(9)
+	} else {
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("deletion", func(ctx context.Context, _ rely.Client, e *nostr.Event) error {
+			if e.Kind == nostr.KindDeletion {
+				return errDeletionDisabled
+			}
+			return nil
+		}))
+	}
+
+	if features.Negentropy {
+		relay.Supports(77)
+	}
+
+	if features.Expiration {
+		relay.Supports(40)
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("expiration", rely.ExpiredEvent))
+
+		query := relay.On.Req
+		relay.On.Req = func(ctx context.Context, c rely.Client, filters nostr.Filters) ([]nostr.Event, error) {
+			events, err := query(ctx, c, filters)
+			return rely.DropExpired(events), err
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// countryConnections counts the connected clients by country.
+type countryConnections struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newCountryConnections() *countryConnections {
+	return &countryConnections{counts: make(map[string]int)}
+}
+
+// add the delta to the connections of the country, "unknown" if empty.
+func (c *countryConnections) add(country string, delta int) {
+	if country == "" {
+		country = "unknown"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[country] += delta
+	if c.counts[country] <= 0 {
+		delete(c.counts, country)
+	}
+}
+
+// metrics writes the connections of each country.
+func (c *countryConnections) metrics(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := connectionsByCountryMetric
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+	for _, country := range slices.Sorted(maps.Keys(c.counts)) {
+		fmt.Fprintf(w, "%s{country=%q} %d\n", m.Name, country, c.counts[country])
+	}
+}
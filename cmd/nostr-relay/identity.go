@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+)
+
+// loadIdentity returns the keypair of the relay, from the configured secret key or from the key file,
+// which is generated with a new keypair if it doesn't exist. It returns nil if neither is configured.
+func loadIdentity(cfg config.ServerConfig) (*rely.Identity, error) {
+	if cfg.SecretKey != "" {
+		return rely.NewIdentity(cfg.SecretKey)
+	}
+
+	if cfg.KeyFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(cfg.KeyFile)
+	switch {
+	case err == nil:
+		id, err := rely.NewIdentity(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", cfg.KeyFile, err)
+		}
+		return id, nil
+
+	case errors.Is(err, os.ErrNotExist):
+		id := rely.GenerateIdentity()
+		if err := os.WriteFile(cfg.KeyFile, []byte(id.SecretKey()+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to save the generated key: %w", err)
+		}
+		return id, nil
+
+	default:
+		return nil, fmt.Errorf("failed to read the key file: %w", err)
+	}
+}
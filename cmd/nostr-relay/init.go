@@ -0,0 +1,145 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+//go:embed config.yaml.example
+var configExample string
+
+// composeTemplate wires ClickHouse and the relay together. The schema directory
+// is mounted where the ClickHouse image executes the SQL files on first start.
+const composeTemplate = `services:
+  clickhouse:
+    image: clickhouse/clickhouse-server:latest
+    volumes:
+      - clickhouse-data:/var/lib/clickhouse
+      - ./schema:/docker-entrypoint-initdb.d:ro
+    environment:
+      CLICKHOUSE_DB: nostr
+      CLICKHOUSE_USER: default
+      CLICKHOUSE_PASSWORD: ""
+    ulimits:
+      nofile:
+        soft: 262144
+        hard: 262144
+    healthcheck:
+      test: ["CMD", "clickhouse-client", "--query", "SELECT 1"]
+      interval: 5s
+      timeout: 5s
+      retries: 20
+    restart: unless-stopped
+
+  relay:
+    # Build it from the repository root with:
+    # docker build -f cmd/nostr-relay/Dockerfile -t %[1]s .
+    image: %[1]s
+    ports:
+      - "3334:3334"  # WebSocket
+      - "8080:8080"  # Health check and metrics
+    depends_on:
+      clickhouse:
+        condition: service_healthy
+    environment:
+      CONFIG_FILE: /app/config.yaml
+    volumes:
+      - ./config.yaml:/app/config.yaml:ro
+    restart: unless-stopped
+
+volumes:
+  clickhouse-data:
+`
+
+// runInit implements the init command, which generates the files needed to run
+// the relay with docker compose: config.yaml, docker-compose.yaml and the schema.
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory where the files are generated")
+	domain := flags.String("domain", "localhost", "domain of the relay")
+	image := flags.String("image", "nostr-relay:latest", "docker image of the relay")
+	partitioning := flags.String("partitioning", "month", "partitioning of the event tables: month, kind, month_kind or none")
+	force := flags.Bool("force", false, "overwrite existing files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay init [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Generates config.yaml, docker-compose.yaml and the ClickHouse schema.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	partitions := clickhouse.Partitioning(*partitioning)
+	if err := partitions.Validate(); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"config.yaml":         initConfig(*domain, partitions),
+		"docker-compose.yaml": fmt.Sprintf(composeTemplate, *image),
+	}
+
+	// the schema uses the default codecs, the ones of the generated config.yaml
+	codecs := compression(config.Default().ClickHouse.Compression)
+	err := fs.WalkDir(clickhouse.Migrations, "migrations", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(clickhouse.Migrations, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.Join("schema", d.Name())] = partitions.Schema(codecs.Schema(string(data)))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read the schema: %w", err)
+	}
+
+	if !*force {
+		for name := range files {
+			if _, err := os.Stat(filepath.Join(*dir, name)); err == nil {
+				return fmt.Errorf("%s already exists (use -force to overwrite)", name)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(*dir, "schema"), 0o755); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := os.WriteFile(filepath.Join(*dir, name), []byte(files[name]), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("  created %s\n", filepath.Join(*dir, name))
+	}
+
+	fmt.Printf("\nStart the relay with:\n\n  cd %s && docker compose up -d\n\n", *dir)
+	fmt.Printf("It will accept connections at ws://%s:3334\n", *domain)
+	return nil
+}
+
+// initConfig returns the example configuration, pointed to the compose ClickHouse service
+// and with the partitioning of the generated schema.
+func initConfig(domain string, partitioning clickhouse.Partitioning) string {
+	replacer := strings.NewReplacer(
+		`domain: "relay.example.com"`, fmt.Sprintf("domain: %q", domain),
+		`partitioning: month`, fmt.Sprintf("partitioning: %s", partitioning),
+		`dsn: "clickhouse://localhost:9000/nostr"`, `dsn: "clickhouse://clickhouse:9000/nostr"`,
+	)
+
+	config := replacer.Replace(configExample)
+	config = strings.Replace(config, "# Copy this file to config.yaml and customize for your deployment",
+		"# Generated by nostr-relay init, customize it for your deployment", 1)
+	return config
+}
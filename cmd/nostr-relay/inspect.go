@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// kindNames are the names of the common kinds, printed next to their number.
+var kindNames = map[int]string{
+	0:     "profile metadata",
+	1:     "text note",
+	3:     "follow list",
+	4:     "encrypted direct message",
+	5:     "deletion request",
+	6:     "repost",
+	7:     "reaction",
+	13:    "seal",
+	14:    "chat message",
+	16:    "generic repost",
+	1059:  "gift wrap",
+	1063:  "file metadata",
+	1984:  "report",
+	9734:  "zap request",
+	9735:  "zap receipt",
+	10000: "mute list",
+	10002: "relay list",
+	22242: "client authentication",
+	24133: "nostr connect",
+	27235: "HTTP auth",
+	30023: "long-form article",
+	30078: "application data",
+}
+
+// runInspect implements the inspect command, which prints the details of an event: the validity of
+// its ID and signature, the meaning of its tags and the tables of the storage containing it.
+func runInspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "don't look up the event in the storage")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay inspect [flags] <event-id | event-json | ->\n\n")
+		fmt.Fprintf(flags.Output(), "Prints the details of an event, fetched from the storage by ID or parsed from JSON\n")
+		fmt.Fprintf(flags.Output(), "(read from stdin with - or without arguments).\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	input := strings.TrimSpace(flags.Arg(0))
+	if input == "" || input == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		input = strings.TrimSpace(string(data))
+	}
+
+	var event *nostr.Event
+	switch {
+	case strings.HasPrefix(input, "{"):
+		event = &nostr.Event{}
+		if err := json.Unmarshal([]byte(input), event); err != nil {
+			return fmt.Errorf("failed to parse the event: %w", err)
+		}
+
+	case nostr.IsValid32ByteHex(input):
+		if *offline {
+			return errors.New("an event ID can't be inspected offline")
+		}
+
+	default:
+		flags.Usage()
+		return errors.New("the argument must be an event ID in hex or the JSON of an event")
+	}
+
+	var loc *clickhouse.Location
+	if !*offline {
+		id := input
+		if event != nil {
+			id = event.ID
+		}
+
+		located, err := locate(id)
+		switch {
+		case err != nil && event == nil:
+			return err
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "storage unavailable: %v\n\n", err)
+		default:
+			loc = &located
+		}
+
+		if event == nil {
+			if located.Event == nil {
+				return fmt.Errorf("the event %s is not stored", id)
+			}
+			event = located.Event
+		}
+	}
+
+	printEvent(os.Stdout, event, loc)
+	return nil
+}
+
+// locate returns where the event is stored.
+func locate(id string) (clickhouse.Location, error) {
+	_, storage, err := maintenanceStorage()
+	if err != nil {
+		return clickhouse.Location{}, err
+	}
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return storage.Locate(ctx, id)
+}
+
+// printEvent prints the details of the event, and its location in the storage if not nil.
+func printEvent(w io.Writer, e *nostr.Event, loc *clickhouse.Location) {
+	row := func(name, format string, args ...any) {
+		fmt.Fprintf(w, "%-10s %s\n", name, fmt.Sprintf(format, args...))
+	}
+
+	id := "valid"
+	if computed := e.GetID(); computed != e.ID {
+		id = "MISMATCH, the content hashes to " + computed
+	}
+	row("id", "%s (%s)", e.ID, id)
+
+	sig := "valid"
+	if ok, err := e.CheckSignature(); err != nil {
+		sig = "INVALID: " + err.Error()
+	} else if !ok {
+		sig = "INVALID"
+	}
+	row("signature", "%s", sig)
+	row("pubkey", "%s", e.PubKey)
+	row("kind", "%d (%s)", e.Kind, kindName(e.Kind))
+	row("created", "%s (%s)", e.CreatedAt.Time().UTC().Format(time.RFC3339), ago(e.CreatedAt.Time()))
+	if e.Tags.Find("nonce") != nil {
+		row("pow", "%d leading zero bits", nip13.Difficulty(e.ID))
+	}
+
+	if loc != nil && loc.Event != nil {
+		row("received", "%s (%s)", loc.ReceivedAt.UTC().Format(time.RFC3339), ago(loc.ReceivedAt))
+	}
+
+	fmt.Fprintf(w, "\ncontent (%d bytes)\n", len(e.Content))
+	if e.Content != "" {
+		for _, line := range strings.Split(e.Content, "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if len(e.Tags) > 0 {
+		fmt.Fprintf(w, "\ntags (%d)\n", len(e.Tags))
+		for _, tag := range e.Tags {
+			fmt.Fprintf(w, "  %s\n", describeTag(tag))
+		}
+	}
+
+	if loc == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "\nstorage\n")
+	switch {
+	case loc.Event == nil:
+		fmt.Fprintf(w, "  not stored\n")
+	case loc.Deleted:
+		fmt.Fprintf(w, "  soft-deleted, hidden from the queries\n")
+	}
+	if loc.Tombstoned {
+		fmt.Fprintf(w, "  tombstoned, it can't be saved again\n")
+	}
+	for _, table := range loc.Tables {
+		fmt.Fprintf(w, "  %-18s %d rows (%d deleted)\n", table.Table, table.Rows, table.Deleted)
+	}
+}
+
+// kindName returns the name of the kind, or its range (regular, replaceable...) if unknown.
+func kindName(kind int) string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+
+	switch {
+	case nostr.IsEphemeralKind(kind):
+		return "ephemeral"
+	case nostr.IsReplaceableKind(kind):
+		return "replaceable"
+	case nostr.IsAddressableKind(kind):
+		return "addressable"
+	default:
+		return "regular"
+	}
+}
+
+// describeTag returns the tag followed by its meaning, for the tags of the common NIPs.
+func describeTag(tag nostr.Tag) string {
+	raw, _ := json.Marshal(tag)
+	if len(tag) == 1 && tag[0] == "-" {
+		return fmt.Sprintf("%s  protected, only its author can publish it", raw)
+	}
+	if len(tag) < 2 {
+		return string(raw)
+	}
+
+	value := tag[1]
+	var meaning string
+	switch tag[0] {
+	case "e":
+		meaning = "references the event " + value
+		if len(tag) > 3 && tag[3] != "" {
+			meaning = fmt.Sprintf("%s (%s)", meaning, tag[3]) // NIP-10 marker, e.g. root or reply
+		}
+	case "p":
+		meaning = "mentions the pubkey " + value
+	case "q":
+		meaning = "quotes " + value
+	case "a":
+		meaning = "references the address " + value
+	case "k":
+		meaning = "refers to the kind " + value
+	case "t":
+		meaning = "hashtag #" + value
+	case "d":
+		meaning = fmt.Sprintf("identifier %q of the addressable event", value)
+	case "r":
+		meaning = "references the URL " + value
+	case "expiration":
+		meaning = "expires " + timestamp(value)
+	case "published_at":
+		meaning = "published " + timestamp(value)
+	case "nonce":
+		if len(tag) > 2 {
+			meaning = "proof of work with target difficulty " + tag[2]
+		}
+	case "subject", "title":
+		meaning = fmt.Sprintf("%s %q", tag[0], value)
+	case "alt":
+		meaning = "description for clients not supporting the kind"
+	case "client":
+		meaning = "published with " + value
+	case "relay":
+		meaning = "relay " + value
+	case "challenge":
+		meaning = "authentication challenge"
+	case "bolt11":
+		meaning = "lightning invoice"
+	case "amount":
+		meaning = value + " millisats"
+	}
+
+	if meaning == "" {
+		return string(raw)
+	}
+	return fmt.Sprintf("%s  %s", raw, meaning)
+}
+
+// timestamp formats the unix timestamp of a tag value.
+func timestamp(value string) string {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "at an invalid timestamp"
+	}
+	t := time.Unix(ts, 0)
+	return fmt.Sprintf("at %s (%s)", t.UTC().Format(time.RFC3339), ago(t))
+}
+
+// ago returns how long ago the time was, or how long until it if it's in the future.
+func ago(t time.Time) string {
+	d := time.Since(t).Round(time.Second)
+	if d < 0 {
+		return "in " + (-d).String()
+	}
+	return d.String() + " ago"
+}
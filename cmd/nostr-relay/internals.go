@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// storageInternals holds the last ClickHouse internals read from the system tables,
+// so that /metrics doesn't query them on every scrape.
+type storageInternals struct {
+	storage  *clickhouse.Storage
+	last     atomic.Pointer[clickhouse.Internals]
+	failures atomic.Int64
+}
+
+// refresh reads the internals from the system tables. It's run as a scheduled job.
+func (s *storageInternals) refresh(ctx context.Context) error {
+	internals, err := s.storage.Internals(ctx)
+	if err != nil {
+		s.failures.Add(1)
+		return err
+	}
+
+	s.last.Store(&internals)
+	return nil
+}
+
+// metrics writes the last internals read, labeled by table and disk.
+func (s *storageInternals) metrics(w io.Writer) {
+	clickhouseScrapeFailuresMetric.write(w, float64(s.failures.Load()))
+
+	internals := s.last.Load()
+	if internals == nil {
+		return
+	}
+
+	tables := []struct {
+		metric metric
+		value  func(clickhouse.TableInternals) float64
+	}{
+		{clickhousePartsMetric, func(t clickhouse.TableInternals) float64 { return float64(t.Parts) }},
+		{clickhouseRowsMetric, func(t clickhouse.TableInternals) float64 { return float64(t.Rows) }},
+		{clickhouseBytesMetric, func(t clickhouse.TableInternals) float64 { return float64(t.BytesOnDisk) }},
+		{clickhouseMergesMetric, func(t clickhouse.TableInternals) float64 { return float64(t.Merges) }},
+		{clickhouseReplicationDelayMetric, func(t clickhouse.TableInternals) float64 { return t.ReplicationDelay.Seconds() }},
+		{clickhouseReplicationQueueMetric, func(t clickhouse.TableInternals) float64 { return float64(t.ReplicationQueue) }},
+	}
+
+	for _, m := range tables {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.metric.Name, m.metric.Help, m.metric.Name, m.metric.Type)
+		for _, t := range internals.Tables {
+			fmt.Fprintf(w, "%s{table=%q} %g\n", m.metric.Name, t.Table, m.value(t))
+		}
+	}
+
+	disks := []struct {
+		metric metric
+		value  func(clickhouse.DiskInternals) float64
+	}{
+		{clickhouseDiskFreeMetric, func(d clickhouse.DiskInternals) float64 { return float64(d.FreeBytes) }},
+		{clickhouseDiskTotalMetric, func(d clickhouse.DiskInternals) float64 { return float64(d.TotalBytes) }},
+	}
+
+	for _, m := range disks {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.metric.Name, m.metric.Help, m.metric.Name, m.metric.Type)
+		for _, d := range internals.Disks {
+			fmt.Fprintf(w, "%s{disk=%q} %g\n", m.metric.Name, d.Disk, m.value(d))
+		}
+	}
+}
@@ -2,15 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/nostr-net/rely"
 	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/maxmind"
 	"github.com/nostr-net/rely/storage/clickhouse"
 )
 
@@ -29,13 +41,105 @@ const banner = `
 ╚═══════════════════════════════════════════════════════════════╝
 `
 
+// budgetModes maps the configured response budget modes to the relay's
+var budgetModes = map[string]rely.BudgetMode{
+	"per-req":    rely.BudgetPerREQ,
+	"per-filter": rely.BudgetPerFilter,
+	"per-second": rely.BudgetPerSecond,
+}
+
+// unknownModes maps the configured handling of unknown messages to the relay's
+var unknownModes = map[string]rely.UnknownMode{
+	"notice":     rely.UnknownNotice,
+	"ignore":     rely.UnknownIgnore,
+	"disconnect": rely.UnknownDisconnect,
+}
+
+const software = "https://github.com/nostr-net/rely"
+
 var (
 	version   = "1.0.0"
 	buildTime = "unknown"
 	gitCommit = "unknown"
 )
 
+// buildInfo describes the running binary, from the build variables set with -ldflags.
+type buildInfo struct {
+	Software  string `json:"software"`
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+	GitCommit string `json:"git_commit"`
+	GoVersion string `json:"go_version"`
+}
+
+func currentBuild() buildInfo {
+	return buildInfo{
+		Software:  software,
+		Version:   version,
+		BuildTime: buildTime,
+		GitCommit: gitCommit,
+		GoVersion: runtime.Version(),
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			if err := runInit(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to initialize: %v", err)
+			}
+			return
+
+		case "dashboards":
+			if err := runDashboards(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to export the dashboards: %v", err)
+			}
+			return
+
+		case "optimize":
+			if err := runOptimize(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to optimize the tables: %v", err)
+			}
+			return
+
+		case "projections":
+			if err := runProjections(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to manage the projections: %v", err)
+			}
+			return
+
+		case "restore":
+			if err := runRestore(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to restore the events: %v", err)
+			}
+			return
+
+		case "explain":
+			if err := runExplain(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to explain the filter: %v", err)
+			}
+			return
+
+		case "inspect":
+			if err := runInspect(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to inspect the event: %v", err)
+			}
+			return
+
+		case "tail":
+			if err := runTail(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to tail the relay: %v", err)
+			}
+			return
+
+		case "version", "--version", "-version":
+			info := currentBuild()
+			fmt.Printf("nostr-relay %s (build: %s, commit: %s, %s)\n", info.Version, info.BuildTime, info.GitCommit, info.GoVersion)
+			return
+		}
+	}
+
 	// Print banner
 	fmt.Print(banner)
 	log.Printf("Version: %s | Build: %s | Commit: %s\n", version, buildTime, gitCommit)
@@ -54,7 +158,10 @@ func main() {
 	log.Printf("Configuration loaded successfully")
 	log.Printf("  Listen: %s", cfg.Server.Listen)
 	log.Printf("  Domain: %s", cfg.Server.Domain)
-	log.Printf("  ClickHouse: %s", cfg.ClickHouse.DSN)
+	log.Printf("  ClickHouse: %s", config.RedactDSN(cfg.ClickHouse.DSN))
+
+	// Fit the Go runtime to the container limits
+	configureRuntime(cfg.Runtime)
 
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -63,12 +170,31 @@ func main() {
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// While draining, /ready fails but the relay keeps serving clients
+	var draining atomic.Bool
 	go func() {
 		sig := <-sigChan
-		log.Printf("Received signal %s, initiating graceful shutdown...", sig)
+		if cfg.Server.DrainPeriod > 0 {
+			log.Printf("Received signal %s, draining for %s...", sig, cfg.Server.DrainPeriod)
+			draining.Store(true)
+
+			select {
+			case <-time.After(cfg.Server.DrainPeriod):
+			case sig = <-sigChan:
+				log.Printf("Received signal %s, skipping the drain", sig)
+			}
+		}
+
+		log.Printf("Initiating graceful shutdown...")
 		cancel()
 	}()
 
+	// The frames are recorded in ClickHouse only if it's the configured sink of the wire traffic
+	var frameSampleRate float64
+	if cfg.Debug.RecordTable {
+		frameSampleRate = cfg.Debug.RecordSampleRate
+	}
+
 	// Initialize ClickHouse storage
 	log.Println("Initializing ClickHouse storage...")
 	storage, err := clickhouse.NewStorage(clickhouse.Config{
@@ -77,6 +203,29 @@ func main() {
 		FlushInterval: cfg.ClickHouse.FlushInterval,
 		MaxOpenConns:  cfg.ClickHouse.MaxOpenConns,
 		MaxIdleConns:  cfg.ClickHouse.MaxIdleConns,
+
+		QueryLogSampleRate: cfg.ClickHouse.QueryLogSampleRate,
+		BlobThreshold:      cfg.ClickHouse.BlobThreshold,
+		PlannerInterval:    cfg.ClickHouse.PlannerInterval,
+		PlannerHints:       cfg.ClickHouse.PlannerHints,
+		CoalesceWindow:     cfg.ClickHouse.CoalesceWindow,
+		NegativeCacheTTL:   cfg.ClickHouse.NegativeCacheTTL,
+		NegativeCacheSize:  cfg.ClickHouse.NegativeCacheSize,
+		SessionRetention:   cfg.ClickHouse.SessionRetention,
+		FrameSampleRate:    frameSampleRate,
+		RedactFrames:       cfg.Debug.RecordRedact,
+		ReadMode:           clickhouse.ReadMode(cfg.ClickHouse.ReadMode),
+		QueryWindow:        cfg.ClickHouse.QueryWindow,
+
+		RejectStaleVersions: cfg.ClickHouse.RejectStaleVersions,
+		VersionCacheSize:    cfg.ClickHouse.VersionCacheSize,
+
+		QueryLimits: clickhouse.QueryLimits{
+			MaxMemoryUsage:   cfg.ClickHouse.QueryLimits.MaxMemoryUsage,
+			MaxRowsToRead:    cfg.ClickHouse.QueryLimits.MaxRowsToRead,
+			MaxExecutionTime: cfg.ClickHouse.QueryLimits.MaxExecutionTime,
+		},
+		TagColumns: cfg.ClickHouse.TagColumns,
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize ClickHouse storage: %v", err)
@@ -107,85 +256,1188 @@ func main() {
 
 	// Create relay with configuration
 	log.Println("Initializing Nostr relay...")
-	relay := rely.NewRelay(
+	opts := []rely.Option{
 		rely.WithDomain(cfg.Server.Domain),
 		rely.WithQueueCapacity(cfg.Server.QueueCapacity),
 		rely.WithMaxProcessors(cfg.Server.MaxProcessors),
 		rely.WithClientResponseLimit(cfg.Server.ClientResponseLimit),
-	)
+		rely.WithInfo(relayInfo()),
+	}
+
+	if !cfg.Features.Auth {
+		opts = append(opts, rely.WithoutAuth())
+	}
+	opts = append(opts, rely.WithAuthChallengeTTL(cfg.Server.AuthChallengeTTL))
+
+	// Push the new versions of replaceable events to the subscriptions by ID
+	if cfg.Features.ReplaceableUpdates {
+		opts = append(opts, rely.WithReplaceableUpdates())
+	}
+
+	// Limit the stored events returned to clients
+	if cfg.Server.ResponseBudget > 0 || cfg.Server.ResponseBudgetMode != "per-req" {
+		budget := cfg.Server.ResponseBudget
+		if budget == 0 {
+			budget = cfg.Server.ClientResponseLimit
+		}
+		opts = append(opts, rely.WithResponseBudget(budgetModes[cfg.Server.ResponseBudgetMode], budget))
+	}
+
+	// Send large responses at the pace of the client
+	if cfg.Server.ResponseChunkSize > 0 {
+		opts = append(opts, rely.WithResponseChunking(cfg.Server.ResponseChunkSize, cfg.Server.ResponseChunkPause))
+	}
+
+	// Shed load when the buffered events exceed the memory budget
+	if cfg.Server.MemoryBudget > 0 {
+		opts = append(opts, rely.WithMemoryBudget(cfg.Server.MemoryBudget))
+	}
+
+	// Reject browser-based attacks and misrouted traffic before the upgrade
+	if len(cfg.Server.AllowedOrigins) > 0 {
+		opts = append(opts, rely.WithAllowedOrigins(cfg.Server.AllowedOrigins...))
+	}
+	if len(cfg.Server.AllowedHosts) > 0 {
+		opts = append(opts, rely.WithAllowedHosts(cfg.Server.AllowedHosts...))
+	}
+	if len(cfg.Server.TrustedProxies) > 0 {
+		opts = append(opts, rely.WithTrustedProxies(cfg.Server.TrustedProxies...))
+	}
+
+	// Let mobile clients cut their bandwidth by scoping their connection
+	if cfg.Server.ConnectionScopes {
+		opts = append(opts, rely.WithConnectionScopes())
+	}
+
+	// Don't let subscriptions be held open forever
+	if cfg.Server.MaxSubscriptionLifetime > 0 || cfg.Server.MaxSubscriptionEvents > 0 {
+		opts = append(opts, rely.WithSubscriptionLimits(cfg.Server.MaxSubscriptionLifetime, cfg.Server.MaxSubscriptionEvents))
+	}
+
+	// Let the researchers crawl the history in time buckets
+	if cfg.Research.Enabled {
+		opts = append(opts, rely.WithArchiveMode(rely.ArchiveModeConfig{
+			Researchers: cfg.Research.Researchers,
+			Bucket:      cfg.Research.Bucket,
+			MaxEvents:   cfg.Research.MaxEvents,
+			Rate:        cfg.Research.Rate,
+			ChunkSize:   cfg.Research.ChunkSize,
+			MaxPause:    cfg.Research.MaxPause,
+		}))
+		log.Printf("Archive mode enabled (%d researchers, bucket %s)", len(cfg.Research.Researchers), cfg.Research.Bucket)
+	}
+
+	// Don't echo thousands of identical errors to misbehaving clients
+	opts = append(opts, rely.WithNoticeLimits(cfg.Server.NoticeRate, cfg.Server.NoticeBurst, cfg.Server.NoticeDedup))
+
+	// Handle the messages of future NIPs
+	if mode := unknownModes[cfg.Server.UnknownMessages]; mode != rely.UnknownNotice {
+		opts = append(opts, rely.WithUnknownMessages(mode))
+	}
+
+	if cfg.Server.HookTimeout > 0 {
+		opts = append(opts, rely.WithHookTimeout(cfg.Server.HookTimeout))
+	}
+
+	// Coalesce outgoing messages into fewer writes
+	if cfg.Server.WriteBatchFrames > 1 {
+		opts = append(opts, rely.WithWriteBatching(cfg.Server.WriteBatchFrames, cfg.Server.WriteBatchWindow))
+	}
+
+	// The websocket messages must fit the biggest events, with the envelope, beyond the default 0.5MB
+	if size := int64(max(cfg.Limits.MaxEventSize, cfg.Limits.LongFormMaxEventSize)) + 1024; size > 500_000 {
+		opts = append(opts, rely.WithMaxMessageSize(size))
+	}
+
+	// Low-latency path for NIP-46 remote signing messages
+	if cfg.NIP46.FastPath {
+		opts = append(opts, rely.WithFastKinds(nostr.KindNostrConnect))
+	}
+
+	// Record the wire traffic if configured
+	if cfg.Debug.RecordDir != "" {
+		recorder := rely.NewFileRecorder(cfg.Debug.RecordDir)
+		recorder.MaxSize = cfg.Debug.RecordMaxSize
+		recorder.SampleRate = cfg.Debug.RecordSampleRate
+		recorder.RedactPubkeys = cfg.Debug.RecordRedact
+		defer recorder.Close()
+
+		opts = append(opts, rely.WithRecorder(recorder))
+		log.Printf("Recording wire traffic to %s (sample rate: %.2f)", cfg.Debug.RecordDir, cfg.Debug.RecordSampleRate)
+	}
+
+	if cfg.Debug.RecordTable {
+		opts = append(opts, rely.WithRecorder(storage))
+		log.Printf("Recording wire traffic to the frames table (sample rate: %.2f)", cfg.Debug.RecordSampleRate)
+	}
+
+	// Resolve the country of the clients
+	var geo *maxmind.DB
+	if cfg.GeoIP.Database != "" {
+		geo, err = maxmind.Open(cfg.GeoIP.Database)
+		if err != nil {
+			log.Fatalf("Failed to open the GeoIP database: %v", err)
+		}
+		opts = append(opts, rely.WithGeoIP(geo))
+	}
+
+	// Authenticate the clients with tokens, basic auth or certificates when they connect
+	if len(cfg.Access.Tokens) > 0 || len(cfg.Access.Users) > 0 || len(cfg.Access.Certificates) > 0 {
+		authenticators, err := accessAuthenticators(cfg.Access)
+		if err != nil {
+			log.Fatalf("Failed to configure the access: %v", err)
+		}
+		opts = append(opts, rely.WithAuthenticators(cfg.Access.Required, authenticators...))
+		log.Printf("Access authentication enabled (required: %v)", cfg.Access.Required)
+	}
+
+	// Account the traffic by pubkey and IP
+	var bandwidth *rely.Bandwidth
+	if cfg.Bandwidth.Enabled {
+		bandwidth = rely.NewBandwidth(rely.BandwidthConfig{
+			PubkeyCap: cfg.Bandwidth.PubkeyCap,
+			IPCap:     cfg.Bandwidth.IPCap,
+		})
+		opts = append(opts, rely.WithBandwidth(bandwidth))
+	}
+
+	// Serve uniform random samples of the events to the analytics clients.
+	// Their filters go through the connection scopes and the Reject.Req hooks of the relay, created below,
+	// and their events through its On.Deliver hook and the post-filters of the REQs.
+	var relay *rely.Relay
+	var sampling *rely.Sampling
+	if cfg.Sampling.Enabled {
+		sample := storage.Sample
+		if cfg.Features.Expiration {
+			sample = func(ctx context.Context, filter nostr.Filter, rate float64) ([]nostr.Event, error) {
+				events, err := storage.Sample(ctx, filter, rate)
+				return rely.DropExpired(events), err
+			}
+		}
+
+		sampling, err = rely.NewSampling(rely.SamplingConfig{
+			Sample:        sample,
+			MaxEvents:     cfg.Sampling.MaxEvents,
+			MaxConcurrent: cfg.Sampling.MaxConcurrent,
+			Timeout:       cfg.Sampling.Timeout,
+			RequireAuth:   cfg.Sampling.RequireAuth,
+			Reject: func(ctx context.Context, c rely.Client, filters nostr.Filters) error {
+				return relay.RejectReq(ctx, c, filters)
+			},
+			Deliver: func(c rely.Client, sample string, e *nostr.Event) bool {
+				return relay.On.Deliver == nil || relay.On.Deliver(c, sample, e)
+			},
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the sampling: %v", err)
+		}
+		opts = append(opts, rely.WithVerb("SAMPLE", sampling.HandleSample))
+	}
+
+	// Reconcile the events with the clients (NIP-77), with the same read policies as the REQs
+	var neg *rely.Negentropy
+	if cfg.Features.Negentropy {
+		config := rely.DefaultNegentropyConfig(func(ctx context.Context, c rely.Client, filters nostr.Filters) ([]nostr.Event, error) {
+			return relay.On.Req(ctx, c, filters)
+		})
+		config.Reject = func(ctx context.Context, c rely.Client, filters nostr.Filters) error {
+			return relay.RejectReq(ctx, c, filters)
+		}
+		config.Deliver = func(c rely.Client, sub string, e *nostr.Event) bool {
+			return relay.On.Deliver == nil || relay.On.Deliver(c, sub, e)
+		}
+
+		neg, err = rely.NewNegentropy(config)
+		if err != nil {
+			log.Fatalf("Failed to create the negentropy: %v", err)
+		}
+		opts = append(opts,
+			rely.WithVerb("NEG-OPEN", neg.HandleOpen),
+			rely.WithVerb("NEG-MSG", neg.HandleMessage),
+			rely.WithVerb("NEG-CLOSE", neg.HandleClose),
+		)
+	}
+
+	// Give the relay its own keypair
+	identity, err := loadIdentity(cfg.Server)
+	if err != nil {
+		log.Fatalf("Failed to load the relay identity: %v", err)
+	}
+	if identity != nil {
+		opts = append(opts, rely.WithIdentity(identity))
+		log.Printf("Relay pubkey: %s", identity.PublicKey())
+	}
+
+	relay = rely.NewRelay(opts...)
+
+	// Accounting of the rejected events by policy and reason, starting with the validation of the IDs and signatures
+	rejections := rely.NewRejections()
+	for i, reject := range relay.Reject.Event {
+		relay.Reject.Event[i] = rejections.Event("validation", reject)
+	}
 
 	// Hook up storage
 	relay.On.Event = storage.SaveEvent
+	if cfg.Features.Deletion {
+		// deleted events leave tombstones, so they can't be re-broadcast to the relay
+		relay.On.Event = rely.SaveWithDeletions(storage)
+	}
 	relay.On.Req = storage.QueryEvents
 	relay.On.Count = storage.CountEvents
+	applyFeatures(relay, cfg.Features, rejections)
+
+	// NIP-23 long-form content: bigger articles, sorted by the date they were first published as blog clients expect
+	relay.Supports(23)
+	size := rely.MaxEventSize(cfg.Limits.MaxEventSize, map[int]int{
+		rely.KindLongForm:      cfg.Limits.LongFormMaxEventSize,
+		rely.KindLongFormDraft: cfg.Limits.LongFormMaxEventSize,
+	})
+	relay.Reject.Event = append(relay.Reject.Event, rejections.Event("size", size))
+
+	// Caching proxy in front of the upstream relays, fetching the filters the storage can't serve
+	collectors := []metricsCollector{runtimeMetrics}
+	if cfg.Cache.Enabled {
+		// the fetched events must pass the policies that don't depend on who published them
+		rejects := []func(context.Context, rely.Client, *nostr.Event) error{size, rely.InvalidReferences}
+		if cfg.Features.Expiration {
+			rejects = append(rejects, rely.ExpiredEvent)
+		}
+
+		cache, err := rely.NewCache(relay, rely.CacheConfig{
+			Upstreams:  cfg.Cache.Upstreams,
+			Save:       func(ctx context.Context, e *nostr.Event) error { return storage.SaveEvent(ctx, nil, e) },
+			Reject:     rejects,
+			TTL:        cfg.Cache.TTL,
+			Timeout:    cfg.Cache.Timeout,
+			MaxFilters: cfg.Cache.MaxFilters,
+			MaxFetches: cfg.Cache.MaxFetches,
+			Forward:    cfg.Cache.Forward,
+			QueueSize:  cfg.Cache.QueueSize,
+		})
+		if err != nil {
+			log.Fatalf("Invalid cache configuration: %v", err)
+		}
+
+		relay.On.Req = cache.Query(relay.On.Req)
+		relay.On.Event = cache.Save(relay.On.Event)
+		collectors = append(collectors, func(w io.Writer) {
+			cacheHitsMetric.write(w, float64(cache.Hits()))
+			cacheMissesMetric.write(w, float64(cache.Misses()))
+			cacheForwardedMetric.write(w, float64(cache.Forwarded()))
+			cacheFailedMetric.write(w, float64(cache.Failed()))
+		})
+		go cache.Run(ctx)
+		log.Printf("Cache of %v (forwarding events: %v)", cfg.Cache.Upstreams, cfg.Cache.Forward)
+	}
+
+	// The previous versions of the replaceable events are only served by the /history management endpoint
+	query := relay.On.Req
+	relay.On.Req = func(ctx context.Context, c rely.Client, filters nostr.Filters) ([]nostr.Event, error) {
+		events, err := query(ctx, c, filters)
+		events = rely.LatestVersions(events)
+		rely.SortLongForm(filters, events)
+		return events, err
+	}
+
+	// NIP-51 lists and NIP-58 badges must reference valid addresses, looked up with the tag_a index (migration 013)
+	relay.Supports(51, 58)
+	relay.Reject.Event = append(relay.Reject.Event, rejections.Event("references", rely.InvalidReferences))
+
+	// Tee the stored events to the archive files
+	if cfg.Archive.Dir != "" {
+		archive, err := rely.NewArchive(rely.ArchiveConfig{
+			Dir:           cfg.Archive.Dir,
+			FlushInterval: cfg.Archive.FlushInterval,
+			QueueSize:     100_000,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the archive: %v", err)
+		}
+
+		relay.On.Event = archive.Save(relay.On.Event)
+		collectors = append(collectors, func(w io.Writer) {
+			archivedMetric.write(w, float64(archive.Archived()))
+			archiveDroppedMetric.write(w, float64(archive.Dropped()))
+			archiveFailedMetric.write(w, float64(archive.Failed()))
+		})
+		go archive.Run(ctx)
+		log.Printf("Archive enabled in %s", cfg.Archive.Dir)
+	}
+
+	// Operator-pinned events, returned first to the REQs they match
+	var pins *rely.Pins
+	if cfg.Features.Pins {
+		pinned, err := storage.PinnedEvents(ctx)
+		if err != nil {
+			log.Fatalf("Failed to load the pinned events: %v", err)
+		}
+
+		pins = rely.NewPins()
+		pins.Load(pinned)
+		relay.On.Req = pins.Query(relay.On.Req)
+		log.Printf("Event pinning enabled (%d pinned events)", len(pinned))
+	}
+
+	if cfg.NIP46.RequireAuth {
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("nip46", rely.UnauthedNostrConnect))
+		relay.Reject.Req = append(relay.Reject.Req, rely.UnauthedNostrConnectReq)
+		relay.On.Deliver = rely.ChainDeliver(relay.On.Deliver, rely.UnauthedNostrConnectDeliver)
+	}
+
+	// Withhold the events muted by the authenticated users, loading their mute lists on AUTH
+	var mutes *rely.Mutes
+	if cfg.Mutes.Enabled {
+		mutes, err = rely.NewMutes(rely.MutesConfig{
+			Query:   storage.QueryEvents,
+			Threads: cfg.Mutes.Threads,
+			Timeout: cfg.Mutes.Timeout,
+		})
+		if err != nil {
+			log.Fatalf("Invalid mutes configuration: %v", err)
+		}
+
+		relay.On.Event = mutes.Save(relay.On.Event)
+		relay.On.Deliver = rely.ChainDeliver(relay.On.Deliver, mutes.Deliver)
+		collectors = append(collectors, func(w io.Writer) {
+			mutesUsersMetric.write(w, float64(mutes.Users()))
+			mutesSuppressedMetric.write(w, float64(mutes.Suppressed()))
+		})
+		log.Printf("Mute lists honored (threads: %v)", cfg.Mutes.Threads)
+	}
+
+	// Cap the connections, reserving headroom for the priority users
+	var admission *rely.Admission
+	var registered *rely.WritePermissions // set if the registration is enabled
+	if cfg.Limits.MaxConnections > 0 {
+		mode := rely.AdmissionLIFO
+		if cfg.Limits.ConnectionRejection == "fair" {
+			mode = rely.AdmissionFair
+		}
+
+		admission, err = rely.NewAdmission(rely.AdmissionConfig{
+			MaxConnections: cfg.Limits.MaxConnections,
+			Reserved:       cfg.Limits.ReservedConnections,
+			Grace:          cfg.Limits.ReserveGrace,
+			Mode:           mode,
+			RetryAfter:     cfg.Limits.ConnectionRetryAfter,
+			Priority: func(c rely.Client) bool {
+				pubkey := c.Pubkey()
+				if cfg.Limits.Priority == "registered" {
+					return pubkey != "" && registered != nil && registered.Allowed(pubkey)
+				}
+				return pubkey != ""
+			},
+		})
+		if err != nil {
+			log.Fatalf("Invalid admission configuration: %v", err)
+		}
+
+		relay.Reject.Connection = append(relay.Reject.Connection, admission.RejectConnection)
+		collectors = append(collectors, func(w io.Writer) {
+			connectionsRefusedMetric.write(w, float64(admission.Refused()))
+			connectionsEvictedMetric.write(w, float64(admission.Evicted()))
+			connectionsExpelledMetric.write(w, float64(admission.Expelled()))
+		})
+		log.Printf("Max connections: %d (%d reserved to %s users, %s rejection)",
+			cfg.Limits.MaxConnections, cfg.Limits.ReservedConnections, cfg.Limits.Priority, mode)
+	}
 
 	// Connection lifecycle hooks
+	countries := newCountryConnections()
 	relay.On.Connect = func(c rely.Client) {
 		log.Printf("Client connected: %s", c.IP())
+		countries.add(c.Country(), 1)
+		if admission != nil {
+			admission.OnConnect(c)
+		}
 	}
 
-	relay.On.Disconnect = func(c rely.Client) {
+	disconnections := newDisconnections()
+	collectors = append(collectors, disconnections.metrics)
+	relay.On.Disconnect = func(c rely.Client, reason rely.DisconnectReason, err error) {
 		duration := time.Since(c.ConnectedAt())
-		log.Printf("Client disconnected: %s (duration: %s)", c.IP(), duration)
+		if err != nil && reason != rely.DisconnectClosed {
+			log.Printf("Client disconnected: %s (%s: %v, duration: %s)", c.IP(), reason, err, duration)
+		} else {
+			log.Printf("Client disconnected: %s (%s, duration: %s)", c.IP(), reason, duration)
+		}
+		disconnections.add(reason)
+		countries.add(c.Country(), -1)
+		storage.LogSession(c)
+		if mutes != nil {
+			mutes.OnDisconnect(c, reason, err)
+		}
+		if neg != nil {
+			neg.OnDisconnect(c, reason, err)
+		}
+		if admission != nil {
+			admission.OnDisconnect(c, reason, err)
+		}
 	}
 
 	// Authentication hook (NIP-42)
 	relay.On.Auth = func(c rely.Client) {
 		log.Printf("Client authenticated: %s (pubkey: %s)", c.IP(), c.Pubkey())
+		if mutes != nil {
+			mutes.OnAuth(c)
+		}
+		if admission != nil {
+			admission.OnAuth(c)
+		}
+	}
+
+	// Metrics exposed by optional components
+	if cfg.ClickHouse.CoalesceWindow > 0 {
+		collectors = append(collectors, func(w io.Writer) {
+			coalescedQueriesMetric.write(w, float64(storage.CoalescedQueries()))
+		})
+		log.Printf("Read coalescing enabled (window %s)", cfg.ClickHouse.CoalesceWindow)
+	}
+	if cfg.ClickHouse.NegativeCacheTTL > 0 {
+		collectors = append(collectors, func(w io.Writer) {
+			negativeCacheHitsMetric.write(w, float64(storage.NegativeCacheHits()))
+		})
+		log.Printf("Negative cache enabled (ttl %s, size %d)", cfg.ClickHouse.NegativeCacheTTL, cfg.ClickHouse.NegativeCacheSize)
+	}
+	if cfg.ClickHouse.QueryWindow > 0 {
+		collectors = append(collectors, func(w io.Writer) {
+			windowEscalationsMetric.write(w, float64(storage.WindowEscalations()))
+		})
+		log.Printf("Implicit query window enabled (%s)", cfg.ClickHouse.QueryWindow)
 	}
 
-	// Start periodic statistics reporting
+	if cfg.Limits.MaxSubscriptions > 0 {
+		quota, err := rely.NewSubscriptionQuota(rely.SubscriptionQuotaConfig{
+			Weight:    rely.FilterWeight,
+			MaxWeight: float64(cfg.Limits.MaxSubscriptions),
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the subscription quota: %v", err)
+		}
+		relay.Reject.Req = append(relay.Reject.Req, quota.RejectReq)
+		log.Printf("Subscription quota enabled (%d broad subscriptions per client)", cfg.Limits.MaxSubscriptions)
+	}
+
+	if cfg.Limits.QueryBudget.Enabled {
+		budget, err := rely.NewQueryBudget(rely.QueryBudgetConfig{
+			Cost:    storage.EstimateCost,
+			MaxCost: cfg.Limits.QueryBudget.MaxCost,
+			Rate:    cfg.Limits.QueryBudget.Rate,
+			Burst:   cfg.Limits.QueryBudget.Burst,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create the query budget: %v", err)
+		}
+
+		relay.Reject.Req = append(relay.Reject.Req, exceptArchive(relay, budget.RejectReq))
+		relay.Reject.Count = append(relay.Reject.Count, budget.RejectReq)
+		collectors = append(collectors, func(w io.Writer) {
+			queriesTooExpensiveMetric.write(w, float64(budget.Rejected()))
+		})
+		go budget.Run(ctx)
+		log.Printf("Query budget enabled (max cost %.0f, %.0f/s)", cfg.Limits.QueryBudget.MaxCost, cfg.Limits.QueryBudget.Rate)
+	}
+
+	if sampling != nil {
+		collectors = append(collectors, func(w io.Writer) {
+			samplesMetric.write(w, float64(sampling.Served()))
+			samplesRejectedMetric.write(w, float64(sampling.Rejected()))
+		})
+		log.Printf("Sampling enabled (max %d events, %d concurrent)", cfg.Sampling.MaxEvents, cfg.Sampling.MaxConcurrent)
+	}
+
+	if bandwidth != nil {
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("bandwidth", bandwidth.RejectEvent))
+		relay.Reject.Req = append(relay.Reject.Req, bandwidth.RejectReq)
+		relay.Reject.Count = append(relay.Reject.Count, bandwidth.RejectReq)
+		relay.On.Deliver = rely.ChainDeliver(relay.On.Deliver, bandwidth.Deliver)
+		collectors = append(collectors, bandwidthMetrics(bandwidth))
+		go bandwidth.Run(ctx)
+		log.Printf("Bandwidth accounting enabled (pubkey cap: %d, IP cap: %d)", cfg.Bandwidth.PubkeyCap, cfg.Bandwidth.IPCap)
+	}
+
+	if geo != nil {
+		collectors = append(collectors, countries.metrics)
+		if len(cfg.GeoIP.Allowed) > 0 || len(cfg.GeoIP.Denied) > 0 {
+			policy, err := rely.NewCountryPolicy(geo, rely.CountryPolicyConfig{
+				Allowed:      cfg.GeoIP.Allowed,
+				Denied:       cfg.GeoIP.Denied,
+				AllowUnknown: cfg.GeoIP.AllowUnknown,
+			})
+			if err != nil {
+				log.Fatalf("Invalid GeoIP configuration: %v", err)
+			}
+			relay.Reject.Connection = append(relay.Reject.Connection, policy.RejectConnection)
+		}
+		log.Printf("GeoIP enabled (%d allowed, %d denied countries)", len(cfg.GeoIP.Allowed), len(cfg.GeoIP.Denied))
+	}
+
+	// Additional HTTP endpoints served alongside the relay
+	mux := http.NewServeMux()
+	mux.Handle("/", relay)
+
+	if err := serveAssets(mux, relay, cfg.Server); err != nil {
+		log.Fatalf("Invalid icon or banner: %v", err)
+	}
+
+	// Events forwarded by peer relays skip the policies meant for end users
+	skip := func(reject func(context.Context, rely.Client, *nostr.Event) error) func(context.Context, rely.Client, *nostr.Event) error {
+		return reject
+	}
+
+	if cfg.Federation.Enabled {
+		federation, err := rely.NewFederation(rely.FederationConfig{
+			Peers:     cfg.Federation.Peers,
+			Networks:  cfg.Federation.Networks,
+			Denied:    cfg.Federation.Denied,
+			PeerRate:  cfg.Federation.PeerRate,
+			PeerBurst: cfg.Federation.PeerBurst,
+		})
+		if err != nil {
+			log.Fatalf("Invalid federation configuration: %v", err)
+		}
+
+		skip = federation.Skip
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("federation", federation.RejectEvent))
+		collectors = append(collectors, func(w io.Writer) {
+			federationForwardedMetric.write(w, float64(federation.Forwarded()))
+		})
+		go federation.Run(ctx)
+		log.Printf("Federation enabled (%d peers, %d networks)", len(cfg.Federation.Peers), len(cfg.Federation.Networks))
+	}
+
+	// Gift wraps are signed by ephemeral keys, so the policies about the author must skip them
+	exempt := func(reject func(context.Context, rely.Client, *nostr.Event) error) func(context.Context, rely.Client, *nostr.Event) error {
+		return reject
+	}
+
+	if cfg.GiftWraps.Enabled {
+		wraps := rely.NewGiftWraps(rely.GiftWrapConfig{
+			RecipientRate:  cfg.GiftWraps.RecipientRate,
+			RecipientBurst: cfg.GiftWraps.RecipientBurst,
+			RestrictReads:  cfg.GiftWraps.RestrictReads,
+		})
+
+		exempt = wraps.Exempt
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("giftwraps", wraps.RejectEvent))
+		relay.Reject.Req = append(relay.Reject.Req, wraps.RejectReq)
+		relay.Reject.Count = append(relay.Reject.Count, wraps.RejectReq)
+
+		// the filters without kinds are allowed, but the gift wraps they match are withheld and not counted
+		relay.On.Count = wraps.Count(relay.On.Count)
+		relay.On.Deliver = rely.ChainDeliver(relay.On.Deliver, wraps.Deliver)
+		relay.Supports(59)
+		go wraps.Run(ctx)
+		log.Println("Gift wrap policies enabled")
+	}
+
+	// Explicit policy for the REQs subscribing to all events
+	if cfg.Firehose.Enabled {
+		firehose, err := rely.NewFirehose(rely.FirehoseConfig{
+			Mode:    rely.FirehoseMode(cfg.Firehose.Mode),
+			Allowed: cfg.Firehose.Allowed,
+			Rate:    cfg.Firehose.Rate,
+			Burst:   cfg.Firehose.Burst,
+		})
+		if err != nil {
+			log.Fatalf("Invalid firehose configuration: %v", err)
+		}
+
+		relay.Reject.Req = append(relay.Reject.Req, exceptArchive(relay, firehose.RejectReq))
+		go firehose.Run(ctx)
+		log.Printf("Firehose policy enabled (mode: %s, %d allowed)", cfg.Firehose.Mode, len(cfg.Firehose.Allowed))
+	}
+
+	// Publish the NIP-66 relay discovery events
+	if cfg.NIP66.Enabled {
+		url := cfg.NIP66.URL
+		if url == "" {
+			url = "wss://" + cfg.Server.Domain
+		}
+
+		monitor, err := rely.NewMonitor(relay, rely.MonitorConfig{
+			URL:      url,
+			Relays:   cfg.NIP66.Relays,
+			Interval: cfg.NIP66.Interval,
+			Timeout:  cfg.NIP66.Timeout,
+			Network:  cfg.NIP66.Network,
+			Geohash:  cfg.NIP66.Geohash,
+
+			ClientRTTs: cfg.NIP66.ClientRTTs,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-66 configuration: %v", err)
+		}
+
+		collectors = append(collectors, func(w io.Writer) {
+			nip66PublishedMetric.write(w, float64(monitor.Published()))
+			nip66FailedMetric.write(w, float64(monitor.Failed()))
+		})
+		go monitor.Run(ctx)
+		log.Printf("NIP-66 discovery enabled (%d monitor relays, every %s)", len(cfg.NIP66.Relays), cfg.NIP66.Interval)
+	}
+
+	// Timestamp the stored events and publish their NIP-03 attestations
+	if cfg.NIP03.Enabled {
+		notary, err := rely.NewNotary(relay, rely.NotaryConfig{
+			Calendar:        cfg.NIP03.Calendar,
+			Kinds:           cfg.NIP03.Kinds,
+			Interval:        cfg.NIP03.Interval,
+			UpgradeInterval: cfg.NIP03.UpgradeInterval,
+			MaxAge:          cfg.NIP03.MaxAge,
+			QueueSize:       100_000,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-03 configuration: %v", err)
+		}
+
+		relay.On.Event = notary.Save(relay.On.Event)
+		collectors = append(collectors, func(w io.Writer) {
+			nip03PendingMetric.write(w, float64(notary.Pending()))
+			nip03PublishedMetric.write(w, float64(notary.Published()))
+		})
+		go notary.Run(ctx)
+		log.Printf("NIP-03 timestamps enabled (calendar: %s)", cfg.NIP03.Calendar)
+	}
+
+	// Verify the nip05 identifiers of the stored profiles, labeling the verified ones
+	if cfg.NIP05.Enabled {
+		labeler, err := rely.NewLabeler(relay, rely.LabelerConfig{
+			Namespace: "nip05",
+			Cooldown:  cfg.NIP05.TTL,
+			QueueSize: 1000,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-05 configuration: %v", err)
+		}
+
+		verifier, err := rely.NewNIP05Verifier(labeler, rely.NIP05Config{
+			Timeout:    cfg.NIP05.Timeout,
+			TTL:        cfg.NIP05.TTL,
+			FailureTTL: cfg.NIP05.FailureTTL,
+			Workers:    cfg.NIP05.Workers,
+			QueueSize:  10_000,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-05 configuration: %v", err)
+		}
+
+		relay.On.Event = verifier.Save(relay.On.Event)
+		go labeler.Run(ctx)
+		go verifier.Run(ctx)
+		log.Println("NIP-05 verification enabled")
+	}
+
+	// Validate the file metadata events, which clients look up by content hash with "#x" filters
+	if cfg.NIP94.Enabled {
+		files, err := rely.NewFileMetadata(rely.FileMetadataConfig{
+			CheckURL: cfg.NIP94.CheckURL,
+			Timeout:  cfg.NIP94.Timeout,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-94 configuration: %v", err)
+		}
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("file_metadata", files.RejectEvent))
+		relay.Supports(94)
+		log.Printf("NIP-94 file metadata enabled (check URLs: %v)", cfg.NIP94.CheckURL)
+	}
+
+	// Moderated communities, whose feeds can be restricted to the posts approved by the moderators
+	if cfg.NIP72.Enabled {
+		communities, err := rely.NewCommunities(storage.QueryEvents, rely.CommunitiesConfig{
+			HideUnapproved:  cfg.NIP72.HideUnapproved,
+			VerifyApprovals: cfg.NIP72.VerifyApprovals,
+		})
+		if err != nil {
+			log.Fatalf("Invalid NIP-72 configuration: %v", err)
+		}
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("communities", communities.RejectEvent))
+		relay.On.Req = communities.Query(relay.On.Req)
+		relay.Supports(72)
+		log.Printf("NIP-72 communities enabled (hide unapproved posts: %v)", cfg.NIP72.HideUnapproved)
+	}
+
+	// Registration flow granting time-limited write permission
+	if cfg.Register.Enabled {
+		perms := rely.NewWritePermissions()
+		perms.Message = fmt.Sprintf("restricted: register at https://%s/register to publish", cfg.Server.Domain)
+		registered = perms
+
+		registration := newRegistration(cfg.Register, perms, storage)
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("registration", exempt(perms.Reject)))
+		mux.Handle("/register", registration)
+		go registration.Load(ctx)
+		log.Printf("Registration enabled (captcha: %s, lightning: %t)", cfg.Register.Captcha, cfg.Register.Lightning.Enabled)
+	}
+
+	// Adaptive anti-spam
+	if cfg.AntiSpam.Adaptive {
+		defense := rely.NewAdaptiveDefense(rely.AdaptiveConfig{
+			Interval:      cfg.AntiSpam.Interval,
+			HighQueueLoad: cfg.AntiSpam.HighQueueLoad,
+			LowQueueLoad:  cfg.AntiSpam.LowQueueLoad,
+			HighEventRate: cfg.AntiSpam.HighEventRate,
+			LowEventRate:  cfg.AntiSpam.LowEventRate,
+			MaxLevel:      cfg.AntiSpam.MaxLevel,
+			BasePow:       cfg.AntiSpam.BasePow,
+			PowStep:       cfg.AntiSpam.PowStep,
+			IPRate:        cfg.AntiSpam.IPRate,
+			IPBurst:       cfg.AntiSpam.IPBurst,
+		})
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("adaptive", skip(defense.Reject)))
+		collectors = append(collectors, adaptiveMetrics(defense))
+		go defense.Run(ctx, relay)
+		log.Println("Adaptive anti-spam enabled")
+	}
+
+	// NIP-32 labels of the flagged pubkeys
+	var labeler *rely.Labeler
+	if cfg.AntiSpam.Labels.Enabled {
+		labeler, err = rely.NewLabeler(relay, rely.LabelerConfig{
+			Namespace: cfg.AntiSpam.Labels.Namespace,
+			Cooldown:  cfg.AntiSpam.Labels.Cooldown,
+			QueueSize: 1000,
+		})
+		if err != nil {
+			log.Fatalf("Invalid labels configuration: %v", err)
+		}
+
+		go labeler.Run(ctx)
+		log.Printf("Moderation labels enabled (namespace: %s)", cfg.AntiSpam.Labels.Namespace)
+	}
+
+	// Routing rules forwarding, posting, dropping or labeling the events
+	if cfg.Rules.File != "" {
+		rules, err := loadRules(cfg.Rules.File)
+		if err != nil {
+			log.Fatalf("Invalid routing rules: %v", err)
+		}
+
+		router, err := rely.NewRouter(relay, rely.RouterConfig{
+			Rules:           rules,
+			Labeler:         labeler,
+			QueueSize:       cfg.Rules.QueueSize,
+			Workers:         cfg.Rules.Workers,
+			Timeout:         cfg.Rules.Timeout,
+			PrivateWebhooks: cfg.Rules.PrivateWebhooks,
+		})
+		if err != nil {
+			log.Fatalf("Invalid routing rules: %v", err)
+		}
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("rules", router.RejectEvent))
+		relay.On.Event = router.Save(relay.On.Event)
+		collectors = append(collectors, func(w io.Writer) {
+			rulesRoutedMetric.write(w, float64(router.Routed()))
+			rulesDroppedMetric.write(w, float64(router.Dropped()))
+			rulesFailedMetric.write(w, float64(router.Failed()))
+		})
+		go router.Run(ctx)
+		go watchRules(ctx, router, cfg.Rules.File, cfg.Rules.ReloadInterval)
+		log.Printf("Routing rules enabled (%d rules from %s)", len(rules), cfg.Rules.File)
+	}
+
+	// Duplicate-content spam detection
+	if cfg.AntiSpam.Duplicates.Enabled {
+		detector, err := rely.NewDuplicateDetector(rely.DuplicateConfig{
+			Window:      cfg.AntiSpam.Duplicates.Window,
+			MaxRepeats:  cfg.AntiSpam.Duplicates.MaxRepeats,
+			MaxDistance: cfg.AntiSpam.Duplicates.MaxDistance,
+			MinLength:   cfg.AntiSpam.Duplicates.MinLength,
+			Kinds:       cfg.AntiSpam.Duplicates.Kinds,
+			MaxHistory:  max(64, cfg.AntiSpam.Duplicates.MaxRepeats),
+		})
+		if err != nil {
+			log.Fatalf("Invalid duplicates configuration: %v", err)
+		}
+
+		reject := detector.Reject
+		if labeler != nil {
+			reject = labeler.Flag("spam", reject)
+		}
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("duplicates", exempt(reject)))
+		relay.On.Event = detector.Save(relay.On.Event)
+		go detector.Run(ctx)
+		log.Println("Duplicate-content detection enabled")
+	}
+
+	// Rejection of the events linking to blocked domains or URLs
+	if cfg.AntiSpam.URLBlocklist.Enabled {
+		blocklist, err := rely.NewURLBlocklist(rely.URLBlocklistConfig{
+			Domains:  cfg.AntiSpam.URLBlocklist.Domains,
+			Patterns: cfg.AntiSpam.URLBlocklist.Patterns,
+			Source:   cfg.AntiSpam.URLBlocklist.Source,
+			Refresh:  cfg.AntiSpam.URLBlocklist.Refresh,
+			Timeout:  cfg.AntiSpam.URLBlocklist.Timeout,
+			MaxSize:  32 << 20,
+		})
+		if err != nil {
+			log.Fatalf("Invalid URL blocklist: %v", err)
+		}
+
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("url_blocklist", exempt(blocklist.RejectEvent)))
+		collectors = append(collectors, func(w io.Writer) {
+			urlBlockedMetric.write(w, float64(blocklist.Blocked()))
+			urlBlocklistSizeMetric.write(w, float64(blocklist.Domains()))
+			urlBlocklistFailedMetric.write(w, float64(blocklist.Failures()))
+		})
+		go blocklist.Run(ctx)
+		log.Printf("URL blocklist enabled (%d domains, %d patterns, source: %q)", len(cfg.AntiSpam.URLBlocklist.Domains), len(cfg.AntiSpam.URLBlocklist.Patterns), cfg.AntiSpam.URLBlocklist.Source)
+	}
+
+	// Rejection of the events re-published soon after being deleted
+	if cfg.Features.Deletion && cfg.AntiSpam.RecentDeletions.Enabled {
+		deletions := rely.NewRecentDeletions(cfg.AntiSpam.RecentDeletions.Window)
+		relay.Reject.Event = append(relay.Reject.Event, rejections.Event("recent_deletions", deletions.Reject))
+		relay.On.Event = deletions.Save(relay.On.Event)
+		go deletions.Run(ctx)
+		log.Println("Recent deletions enabled")
+	}
+
+	// IP reputation must be configured last, as it tracks the rejections of all other event policies
+	var reputation *rely.Reputation
+	if cfg.AntiSpam.Reputation.Enabled {
+		reputation, err = rely.NewReputation(rely.ReputationConfig{
+			RejectionPenalty: cfg.AntiSpam.Reputation.RejectionPenalty,
+			HalfLife:         cfg.AntiSpam.Reputation.HalfLife,
+			DNSBLs:           cfg.AntiSpam.Reputation.DNSBLs,
+			DNSBLPenalty:     cfg.AntiSpam.Reputation.DNSBLPenalty,
+			DNSBLTimeout:     time.Second,
+			DNSBLCacheTTL:    time.Hour,
+			Networks:         cfg.AntiSpam.Reputation.Networks,
+			NetworkPenalty:   cfg.AntiSpam.Reputation.NetworkPenalty,
+			RefuseBelow:      cfg.AntiSpam.Reputation.RefuseBelow,
+			RestrictBelow:    cfg.AntiSpam.Reputation.RestrictBelow,
+			RestrictedRate:   cfg.AntiSpam.Reputation.RestrictedRate,
+		})
+		if err != nil {
+			log.Fatalf("Invalid reputation configuration: %v", err)
+		}
+
+	}
+
+	// Bans and reputation penalties shared by all relay instances through ClickHouse
+	var cluster *rely.Cluster
+	if cfg.Cluster.Enabled {
+		bans := rely.NewBanlist()
+		cluster, err = rely.NewCluster(storage, bans, reputation, rely.ClusterConfig{
+			Instance:  cfg.Cluster.Instance,
+			Interval:  cfg.Cluster.Interval,
+			Overlap:   5 * time.Second,
+			QueueSize: 10_000,
+		})
+		if err != nil {
+			log.Fatalf("Invalid cluster configuration: %v", err)
+		}
+
+		// banned clients are refused before any other policy, and are not penalized further
+		relay.Reject.Connection = append([]func(rely.Stats, *http.Request) error{bans.RejectConnection}, relay.Reject.Connection...)
+		relay.Reject.Event = append([]func(context.Context, rely.Client, *nostr.Event) error{rejections.Event("bans", bans.RejectEvent)}, relay.Reject.Event...)
+		relay.Reject.Req = append([]func(context.Context, rely.Client, nostr.Filters) error{bans.RejectReq}, relay.Reject.Req...)
+		collectors = append(collectors, func(w io.Writer) {
+			clusterBansMetric.write(w, float64(len(bans.Bans())))
+			clusterFailuresMetric.write(w, float64(cluster.Failures()))
+		})
+		go cluster.Run(ctx)
+		log.Printf("Cluster state enabled (instance %s)", cluster.Instance())
+	}
+
+	if reputation != nil {
+		track := reputation.TrackAll
+		if cluster != nil {
+			track = cluster.TrackAll
+		}
+
+		relay.Reject.Connection = append(relay.Reject.Connection, reputation.RejectConnection)
+		relay.Reject.Event = append(track(relay.Reject.Event), rejections.Event("reputation", skip(reputation.RateLimit)))
+		collectors = append(collectors, func(w io.Writer) {
+			reputationRefusedMetric.write(w, float64(reputation.Refused()))
+		})
+		go reputation.Run(ctx)
+		log.Println("IP reputation enabled")
+	}
+
+	// Hot standby of a primary relay, rejecting the events until it's promoted
+	var standby *rely.Standby
+	if cfg.Standby.Enabled {
+		var since nostr.Timestamp
+		if stats, err := storage.Stats(); err == nil {
+			since = nostr.Timestamp(stats.NewestEvent)
+		}
+
+		standby, err = rely.NewStandby(relay, rely.StandbyConfig{
+			Primary:       cfg.Standby.Primary,
+			Save:          func(ctx context.Context, e *nostr.Event) error { return storage.SaveEvent(ctx, nil, e) },
+			Since:         since,
+			Overlap:       cfg.Standby.Overlap,
+			PageSize:      cfg.Standby.PageSize,
+			RetryInterval: cfg.Standby.RetryInterval,
+			HealthURL:     cfg.Standby.HealthURL,
+			FailoverAfter: cfg.Standby.FailoverAfter,
+			CheckInterval: cfg.Standby.CheckInterval,
+		})
+		if err != nil {
+			log.Fatalf("Invalid standby configuration: %v", err)
+		}
+
+		relay.Reject.Event = append([]func(context.Context, rely.Client, *nostr.Event) error{rejections.Event("standby", standby.RejectEvent)}, relay.Reject.Event...)
+		collectors = append(collectors, func(w io.Writer) {
+			standbyReplicatedMetric.write(w, float64(standby.Replicated()))
+			standbyFailedMetric.write(w, float64(standby.Failed()))
+			promoted := 0.0
+			if standby.Promoted() {
+				promoted = 1
+			}
+			standbyPromotedMetric.write(w, promoted)
+		})
+		go standby.Run(ctx)
+		log.Printf("Standby of %s (automatic failover: %v)", cfg.Standby.Primary, cfg.Standby.HealthURL != "")
+	}
+
+	// The events the storage rejects, mostly duplicates
+	relay.On.Event = rejections.Event("storage", relay.On.Event)
+	collectors = append(collectors, func(w io.Writer) { writeRejections(w, rejections) })
+
+	// Scheduled background jobs
+	jobs := newScheduler(cfg.Jobs.History)
 	if cfg.Monitoring.StatsInterval > 0 {
-		go periodicStats(ctx, relay, storage, cfg.Monitoring.StatsInterval)
+		jobs.add(job{name: "stats", interval: cfg.Monitoring.StatsInterval, run: logStats(relay, storage, rejections)})
+	}
+	if cfg.Monitoring.EnableMetrics && cfg.Monitoring.StorageMetricsInterval > 0 {
+		internals := &storageInternals{storage: storage}
+		jobs.add(job{name: "storage_metrics", interval: cfg.Monitoring.StorageMetricsInterval, run: internals.refresh})
+		collectors = append(collectors, internals.metrics)
+	}
+	if cfg.Jobs.Trending.Enabled {
+		analytics := storage.Analytics()
+		hours := cfg.Jobs.Trending.Hours
+		jobs.add(job{
+			name:     "trending",
+			interval: cfg.Jobs.Trending.Interval,
+			jitter:   cfg.Jobs.Trending.Jitter,
+			run:      func(ctx context.Context) error { return analytics.RefreshHotPosts(ctx, hours) },
+		})
+	}
+	if cfg.Jobs.Compaction.Enabled {
+		jobs.add(job{
+			name:     "compaction",
+			interval: cfg.Jobs.Compaction.Interval,
+			jitter:   cfg.Jobs.Compaction.Jitter,
+			run:      compactionJob(relay, storage, cfg.Jobs.Compaction),
+		})
+	}
+	if cfg.Jobs.ReplaceableHistory.Enabled {
+		keep := cfg.Jobs.ReplaceableHistory.Keep
+		jobs.add(job{
+			name:     "replaceable_history",
+			interval: cfg.Jobs.ReplaceableHistory.Interval,
+			jitter:   cfg.Jobs.ReplaceableHistory.Jitter,
+			run:      func(ctx context.Context) error { return storage.PruneHistory(ctx, keep) },
+		})
+	}
+	if cfg.Jobs.Reconciliation.Enabled || cfg.Jobs.Verification.Enabled {
+		reconciler := newReconciler(storage)
+		if cfg.Jobs.Reconciliation.Enabled {
+			jobs.add(job{
+				name:     "reconciliation",
+				interval: cfg.Jobs.Reconciliation.Interval,
+				jitter:   cfg.Jobs.Reconciliation.Jitter,
+				run:      reconciler.reconcile(cfg.Jobs.Reconciliation),
+			})
+		}
+		if cfg.Jobs.Verification.Enabled {
+			jobs.add(job{
+				name:     "verification",
+				interval: cfg.Jobs.Verification.Interval,
+				jitter:   cfg.Jobs.Verification.Jitter,
+				run:      reconciler.verify(cfg.Jobs.Verification),
+			})
+		}
+		collectors = append(collectors, reconciler.metrics)
 	}
+	go jobs.Run(ctx)
 
-	// Start HTTP health check endpoint if configured
+	// Start HTTP health check and metrics endpoints if configured
 	if cfg.Monitoring.HealthCheckPort > 0 {
-		go startHealthCheck(ctx, cfg.Monitoring.HealthCheckPort, storage)
+		go startMonitoring(ctx, cfg.Monitoring, relay, storage, bandwidth, pins, cluster, standby, jobs, &draining, collectors...)
 	}
 
 	// Start relay server
 	log.Printf("Starting Nostr relay on %s...", cfg.Server.Listen)
 	log.Println("Ready to accept connections ✓")
 
-	if err := relay.StartAndServe(ctx, cfg.Server.Listen); err != nil {
+	server := &http.Server{Addr: cfg.Server.Listen, Handler: mux}
+	if cfg.Access.ClientCA != "" {
+		if server.TLSConfig, err = clientCertificates(cfg.Access.ClientCA); err != nil {
+			log.Fatalf("Failed to configure the client certificates: %v", err)
+		}
+	}
+
+	if err := serve(ctx, relay, server, cfg.Server.TLSCert, cfg.Server.TLSKey); err != nil {
 		log.Fatalf("Relay error: %v", err)
 	}
 
 	log.Println("Relay stopped gracefully")
 }
 
-// periodicStats reports relay statistics at regular intervals
-func periodicStats(ctx context.Context, relay *rely.Relay, storage *clickhouse.Storage, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// serve starts the relay and the server until the context is cancelled, over TLS if the certificate is set.
+// It's equivalent to [rely.Relay.StartAndServe], but allows serving other endpoints next to the relay.
+func serve(ctx context.Context, relay *rely.Relay, server *http.Server, certFile, keyFile string) error {
+	relay.Start(ctx)
+	exitErr := make(chan error, 1)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			stats, err := storage.Stats()
-			if err != nil {
-				log.Printf("Failed to get storage stats: %v", err)
-				continue
+	go func() {
+		var err error
+		if certFile != "" {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
+			exitErr <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(ctx)
+		relay.Wait()
+		return err
+
+	case err := <-exitErr:
+		return err
+	}
+}
+
+// compactionJob returns the job merging the partitions of the event tables with duplicates,
+// skipped while the relay is busy.
+func compactionJob(relay *rely.Relay, storage *clickhouse.Storage, cfg config.CompactionJobConfig) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if load := relay.QueueLoad(); load > cfg.MaxQueueLoad {
+			return fmt.Errorf("skipped: the queue load %.2f is above %.2f", load, cfg.MaxQueueLoad)
+		}
+		return compact(ctx, storage, clickhouse.EventTables, cfg.MinDuplicates, cfg.Pause, func(format string, args ...any) {
+			log.Printf("Compaction: "+format, args...)
+		})
+	}
+}
+
+// logStats returns the job logging the relay statistics.
+func logStats(relay *rely.Relay, storage *clickhouse.Storage, rejections *rely.Rejections) func(context.Context) error {
+	return func(context.Context) error {
+		stats, err := storage.Stats()
+		if err != nil {
+			return fmt.Errorf("failed to get storage stats: %w", err)
+		}
+
+		log.Printf("Relay Statistics:")
+		log.Printf("  Connected clients: %d", relay.Clients())
+		log.Printf("  Active subscriptions: %d", relay.Subscriptions())
+		log.Printf("  Queue load: %.1f%%", relay.QueueLoad()*100)
+
+		if clients, err := relay.ListClients(); err == nil {
+			var traffic rely.ClientStats
+			for _, c := range clients {
+				stats := c.Stats()
+				traffic.MessagesReceived += stats.MessagesReceived
+				traffic.MessagesSent += stats.MessagesSent
+				traffic.EventsAccepted += stats.EventsAccepted
+				traffic.EventsRejected += stats.EventsRejected
 			}
+			log.Printf("  Connected clients traffic: %d messages in, %d out, %d events accepted, %d rejected",
+				traffic.MessagesReceived, traffic.MessagesSent, traffic.EventsAccepted, traffic.EventsRejected)
+		}
 
-			log.Printf("Relay Statistics:")
-			log.Printf("  Connected clients: %d", relay.Clients())
-			log.Printf("  Active subscriptions: %d", relay.Subscriptions())
-			log.Printf("  Queue load: %.1f%%", relay.QueueLoad()*100)
-			log.Printf("  Storage events: %d (%.2f GB)",
-				stats.TotalEvents,
-				float64(stats.TotalBytes)/(1<<30),
-			)
+		if reasons := rejections.ByReason(); len(reasons) > 0 {
+			counts := make([]string, 0, len(reasons))
+			for _, reason := range slices.Sorted(maps.Keys(reasons)) {
+				counts = append(counts, fmt.Sprintf("%s %d", reason, reasons[reason]))
+			}
+			log.Printf("  Rejected events: %s", strings.Join(counts, ", "))
 		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		log.Printf("  Memory: heap %.2f MB, sys %.2f MB, %d GC cycles, %d goroutines",
+			float64(mem.HeapAlloc)/(1<<20), float64(mem.Sys)/(1<<20), mem.NumGC, runtime.NumGoroutine())
+
+		latencies := relay.Latencies()
+		log.Printf("  EVENT latency: p50=%s p95=%s p99=%s",
+			latencies.Event.P50, latencies.Event.P95, latencies.Event.P99)
+		log.Printf("  REQ-to-EOSE latency: p50=%s p95=%s p99=%s",
+			latencies.ReqToEOSE.P50, latencies.ReqToEOSE.P95, latencies.ReqToEOSE.P99)
+		log.Printf("  Storage events: %d (%.2f GB)",
+			stats.TotalEvents,
+			float64(stats.TotalBytes)/(1<<30),
+		)
+		return nil
 	}
 }
 
-// startHealthCheck starts a simple HTTP health check endpoint
-func startHealthCheck(ctx context.Context, port int, storage *clickhouse.Storage) {
-	// TODO: Implement HTTP health check endpoint
-	// This would expose /health, /metrics endpoints
-	log.Printf("Health check endpoint would start on port %d (not yet implemented)", port)
+// exceptArchive wraps a Reject.Req hook so that it's skipped for the archive REQs of the researchers,
+// whose bulk exports would otherwise be rejected as abusive.
+func exceptArchive(relay *rely.Relay, hook func(context.Context, rely.Client, nostr.Filters) error) func(context.Context, rely.Client, nostr.Filters) error {
+	return func(ctx context.Context, c rely.Client, filters nostr.Filters) error {
+		if relay.IsArchiveReq(c, filters) {
+			return nil
+		}
+		return hook(ctx, c, filters)
+	}
+}
+
+// accessAuthenticators returns the authenticators of the configured tokens, basic auth users and client certificates.
+func accessAuthenticators(access config.AccessConfig) ([]rely.Authenticator, error) {
+	var authenticators []rely.Authenticator
+	if len(access.Tokens) > 0 {
+		auth, err := rely.NewTokenAuth(access.TokenParam, access.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+
+	if len(access.Users) > 0 {
+		users := make(map[string]rely.BasicUser, len(access.Users))
+		for name, user := range access.Users {
+			users[name] = rely.BasicUser{Password: user.Password, Pubkey: user.Pubkey}
+		}
+
+		auth, err := rely.NewBasicAuth("relay", users)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+
+	if len(access.Certificates) > 0 {
+		auth, err := rely.NewCertAuth(access.Certificates)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+	return authenticators, nil
+}
+
+// clientCertificates returns the TLS config requesting the client certificates issued by the authorities of the PEM file,
+// which are then verified by the server for [rely.CertAuth].
+func clientCertificates(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client authorities: %w", err)
+	}
+
+	authorities := x509.NewCertPool()
+	if !authorities.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: authorities}, nil
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nostr-net/rely"
+)
+
+// metric describes a metric exposed at /metrics. All metrics are declared below, so that the
+// dashboards and alerts generated by "nostr-relay dashboards export" stay in sync with them.
+type metric struct {
+	Name  string
+	Help  string
+	Type  string // gauge, counter or summary
+	Unit  string // Grafana unit of the dashboard panel
+	Group string // Dashboard row
+	Label string // Label distinguishing the series of a summary (op if empty)
+}
+
+// metrics is the catalog of all the metrics, in the order of the dashboard.
+var metrics []metric
+
+func newMetric(m metric) metric {
+	metrics = append(metrics, m)
+	return m
+}
+
+var (
+	clientsMetric       = newMetric(metric{Name: "rely_clients", Help: "Number of connected clients.", Type: "gauge", Unit: "short", Group: "Relay"})
+	subscriptionsMetric = newMetric(metric{Name: "rely_subscriptions", Help: "Number of active subscriptions.", Type: "gauge", Unit: "short", Group: "Relay"})
+	filtersMetric       = newMetric(metric{Name: "rely_filters", Help: "Number of active filters.", Type: "gauge", Unit: "short", Group: "Relay"})
+	queueLoadMetric     = newMetric(metric{Name: "rely_queue_load", Help: "Ratio of queued requests to the queue capacity.", Type: "gauge", Unit: "percentunit", Group: "Relay"})
+	connectionsMetric   = newMetric(metric{Name: "rely_connections_total", Help: "Total connections since startup.", Type: "counter", Unit: "cps", Group: "Relay"})
+	disconnectsMetric   = newMetric(metric{Name: "rely_disconnections_total", Help: "Disconnections by reason: closed, idle, kicked, error or shutdown.", Type: "counter", Unit: "cps", Group: "Relay"})
+	rejectedMetric      = newMetric(metric{Name: "rely_rejected_events_total", Help: "Events rejected by policy and reason: pow, rate-limited, blocked, invalid, duplicate, too-large, auth-required, etc.", Type: "counter", Unit: "ops", Group: "Relay"})
+	latencyMetric       = newMetric(metric{Name: "rely_latency_seconds", Help: "Latency of relay operations in the last minutes, and their count since startup.", Type: "summary", Unit: "s", Group: "Relay"})
+	clientRTTMetric     = newMetric(metric{Name: "rely_client_rtt_seconds", Help: "Round-trip time of the client connections in the last minutes, by country of their IP.", Type: "summary", Unit: "s", Group: "Relay", Label: "country"})
+
+	bufferedMetric          = newMetric(metric{Name: "rely_buffered_bytes", Help: "Bytes of events buffered in the relay.", Type: "gauge", Unit: "bytes", Group: "Memory"})
+	memoryBudgetMetric      = newMetric(metric{Name: "rely_memory_budget_bytes", Help: "Maximum bytes of buffered events (0 if unlimited).", Type: "gauge", Unit: "bytes", Group: "Memory"})
+	shedEventsMetric        = newMetric(metric{Name: "rely_shed_events_total", Help: "EVENTs rejected because the memory budget was exceeded.", Type: "counter", Unit: "ops", Group: "Memory"})
+	shedReqsMetric          = newMetric(metric{Name: "rely_shed_reqs_total", Help: "REQs truncated because the memory budget was exceeded.", Type: "counter", Unit: "ops", Group: "Memory"})
+	suppressedNoticesMetric = newMetric(metric{Name: "rely_suppressed_notices_total", Help: "NOTICEs not sent to the clients because they were rate limited or duplicates.", Type: "counter", Unit: "ops", Group: "Relay"})
+
+	goroutinesMetric  = newMetric(metric{Name: "rely_go_goroutines", Help: "Number of goroutines.", Type: "gauge", Unit: "short", Group: "Go runtime"})
+	maxProcsMetric    = newMetric(metric{Name: "rely_go_maxprocs", Help: "Value of GOMAXPROCS.", Type: "gauge", Unit: "short", Group: "Go runtime"})
+	memoryLimitMetric = newMetric(metric{Name: "rely_go_memory_limit_bytes", Help: "Soft memory limit of the runtime.", Type: "gauge", Unit: "bytes", Group: "Go runtime"})
+	heapAllocMetric   = newMetric(metric{Name: "rely_go_heap_alloc_bytes", Help: "Bytes of allocated heap objects.", Type: "gauge", Unit: "bytes", Group: "Go runtime"})
+	heapInuseMetric   = newMetric(metric{Name: "rely_go_heap_inuse_bytes", Help: "Bytes in in-use heap spans.", Type: "gauge", Unit: "bytes", Group: "Go runtime"})
+	sysMetric         = newMetric(metric{Name: "rely_go_sys_bytes", Help: "Bytes of memory obtained from the OS.", Type: "gauge", Unit: "bytes", Group: "Go runtime"})
+	gcMetric          = newMetric(metric{Name: "rely_go_gc_total", Help: "Completed GC cycles.", Type: "counter", Unit: "ops", Group: "Go runtime"})
+	gcPauseMetric     = newMetric(metric{Name: "rely_go_gc_pause_seconds_total", Help: "Cumulative GC stop-the-world pause time.", Type: "counter", Unit: "s", Group: "Go runtime"})
+
+	antispamLevelMetric        = newMetric(metric{Name: "rely_antispam_level", Help: "Current tightening level of the adaptive anti-spam.", Type: "gauge", Unit: "short", Group: "Anti-spam"})
+	antispamPowMetric          = newMetric(metric{Name: "rely_antispam_min_pow", Help: "Minimum PoW difficulty currently required.", Type: "gauge", Unit: "short", Group: "Anti-spam"})
+	antispamEventRateMetric    = newMetric(metric{Name: "rely_antispam_event_rate", Help: "Events per second received during the last interval.", Type: "gauge", Unit: "ops", Group: "Anti-spam"})
+	antispamPowRejectedMetric  = newMetric(metric{Name: "rely_antispam_pow_rejected_total", Help: "Events rejected for insufficient PoW.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	antispamRateRejectedMetric = newMetric(metric{Name: "rely_antispam_rate_rejected_total", Help: "Events rejected by the per-IP rate limit.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	reputationRefusedMetric    = newMetric(metric{Name: "rely_reputation_refused_total", Help: "Connections refused for low IP reputation.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	rulesRoutedMetric          = newMetric(metric{Name: "rely_rules_routed_total", Help: "Events forwarded or posted by the routing rules.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	rulesDroppedMetric         = newMetric(metric{Name: "rely_rules_dropped_total", Help: "Events rejected by the drop rules.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	rulesFailedMetric          = newMetric(metric{Name: "rely_rules_failed_total", Help: "Events the routing rules failed to forward or post.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	federationForwardedMetric  = newMetric(metric{Name: "rely_federation_forwarded_total", Help: "Events accepted from peer relays.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+	clusterBansMetric          = newMetric(metric{Name: "rely_cluster_bans", Help: "Active bans shared by the relay instances.", Type: "gauge", Unit: "short", Group: "Anti-spam"})
+	clusterFailuresMetric      = newMetric(metric{Name: "rely_cluster_failures_total", Help: "Failed exchanges of the cluster state with ClickHouse.", Type: "counter", Unit: "ops", Group: "Anti-spam"})
+
+	connectionsByCountryMetric = newMetric(metric{Name: "rely_connections_by_country", Help: "Connected clients by country of their IP.", Type: "gauge", Unit: "short", Group: "Relay"})
+
+	bandwidthSentMetric     = newMetric(metric{Name: "rely_bandwidth_sent_bytes_total", Help: "Bytes of the messages sent to the clients.", Type: "counter", Unit: "bytes", Group: "Relay"})
+	bandwidthReceivedMetric = newMetric(metric{Name: "rely_bandwidth_received_bytes_total", Help: "Bytes of the messages received from the clients.", Type: "counter", Unit: "bytes", Group: "Relay"})
+
+	nip66PublishedMetric = newMetric(metric{Name: "rely_nip66_published_total", Help: "NIP-66 events published to the monitor relays.", Type: "counter", Unit: "ops", Group: "Relay"})
+	nip66FailedMetric    = newMetric(metric{Name: "rely_nip66_failed_total", Help: "Failed publications of NIP-66 events to the monitor relays.", Type: "counter", Unit: "ops", Group: "Relay"})
+	nip03PendingMetric   = newMetric(metric{Name: "rely_nip03_pending", Help: "Events submitted to the calendar, waiting for their Bitcoin attestation.", Type: "gauge", Unit: "short", Group: "Relay"})
+	nip03PublishedMetric = newMetric(metric{Name: "rely_nip03_published_total", Help: "NIP-03 attestations published.", Type: "counter", Unit: "ops", Group: "Relay"})
+
+	coalescedQueriesMetric    = newMetric(metric{Name: "rely_storage_coalesced_queries_total", Help: "Filters served by the merged query of other filters.", Type: "counter", Unit: "ops", Group: "Storage"})
+	negativeCacheHitsMetric   = newMetric(metric{Name: "rely_storage_negative_cache_hits_total", Help: "Filters answered as empty without querying.", Type: "counter", Unit: "ops", Group: "Storage"})
+	queriesTooExpensiveMetric = newMetric(metric{Name: "rely_queries_too_expensive_total", Help: "REQs and COUNTs rejected as too expensive by the query budget.", Type: "counter", Unit: "ops", Group: "Storage"})
+	windowEscalationsMetric   = newMetric(metric{Name: "rely_storage_window_escalations_total", Help: "Unbounded filters queried beyond the implicit window.", Type: "counter", Unit: "ops", Group: "Storage"})
+	samplesMetric             = newMetric(metric{Name: "rely_samples_total", Help: "Random samples of the events sent to the clients.", Type: "counter", Unit: "ops", Group: "Storage"})
+	samplesRejectedMetric     = newMetric(metric{Name: "rely_samples_rejected_total", Help: "Samples rejected because too many were in progress.", Type: "counter", Unit: "ops", Group: "Storage"})
+
+	standbyReplicatedMetric = newMetric(metric{Name: "rely_standby_replicated_total", Help: "Events replicated from the primary.", Type: "counter", Unit: "ops", Group: "Storage"})
+	standbyFailedMetric     = newMetric(metric{Name: "rely_standby_failed_total", Help: "Replicated events that couldn't be verified or saved.", Type: "counter", Unit: "ops", Group: "Storage"})
+	standbyPromotedMetric   = newMetric(metric{Name: "rely_standby_promoted", Help: "Whether the standby has been promoted (1) or is replicating the primary (0).", Type: "gauge", Unit: "short", Group: "Storage"})
+
+	projectionRepairsMetric    = newMetric(metric{Name: "rely_projection_repaired_rows_total", Help: "Rows of the events table missing from the projections, copied by the reconciliation and the verification.", Type: "counter", Unit: "short", Group: "Storage"})
+	projectionSampledMetric    = newMetric(metric{Name: "rely_projection_sampled_rows_total", Help: "Rows of the sampled events checked in the projections by the verification.", Type: "counter", Unit: "short", Group: "Storage"})
+	projectionMissingMetric    = newMetric(metric{Name: "rely_projection_missing_rows_total", Help: "Rows of the sampled events found missing from the projections by the verification.", Type: "counter", Unit: "short", Group: "Storage"})
+	projectionDivergenceMetric = newMetric(metric{Name: "rely_projection_divergence", Help: "Ratio of the sampled rows missing from the projections in the last verification.", Type: "gauge", Unit: "percentunit", Group: "Storage"})
+
+	cacheHitsMetric      = newMetric(metric{Name: "rely_cache_hits_total", Help: "Filters served from the storage alone in cache mode.", Type: "counter", Unit: "ops", Group: "Storage"})
+	cacheMissesMetric    = newMetric(metric{Name: "rely_cache_misses_total", Help: "Filters fetched from the upstreams in cache mode.", Type: "counter", Unit: "ops", Group: "Storage"})
+	cacheForwardedMetric = newMetric(metric{Name: "rely_cache_forwarded_total", Help: "Events forwarded to the upstreams in cache mode.", Type: "counter", Unit: "ops", Group: "Storage"})
+	cacheFailedMetric    = newMetric(metric{Name: "rely_cache_forward_failed_total", Help: "Events that couldn't be forwarded to any upstream, or were dropped.", Type: "counter", Unit: "ops", Group: "Storage"})
+
+	mutesUsersMetric      = newMetric(metric{Name: "rely_mutes_users", Help: "Authenticated users whose mute list is kept.", Type: "gauge", Unit: "short", Group: "Relay"})
+	mutesSuppressedMetric = newMetric(metric{Name: "rely_mutes_suppressed_total", Help: "Events withheld from the clients because their user muted them.", Type: "counter", Unit: "ops", Group: "Relay"})
+
+	connectionsRefusedMetric  = newMetric(metric{Name: "rely_connections_refused_total", Help: "Connections refused because the relay was at max_connections.", Type: "counter", Unit: "ops", Group: "Relay"})
+	connectionsEvictedMetric  = newMetric(metric{Name: "rely_connections_evicted_total", Help: "Clients disconnected to make room for the connections of other IPs.", Type: "counter", Unit: "ops", Group: "Relay"})
+	connectionsExpelledMetric = newMetric(metric{Name: "rely_connections_expelled_total", Help: "Clients disconnected from the reserved connections because they had no priority.", Type: "counter", Unit: "ops", Group: "Relay"})
+
+	urlBlockedMetric         = newMetric(metric{Name: "rely_url_blocked_total", Help: "Events rejected because they link to a blocked URL.", Type: "counter", Unit: "ops", Group: "Relay"})
+	urlBlocklistSizeMetric   = newMetric(metric{Name: "rely_url_blocklist_domains", Help: "Blocked domains, configured and downloaded.", Type: "gauge", Unit: "short", Group: "Relay"})
+	urlBlocklistFailedMetric = newMetric(metric{Name: "rely_url_blocklist_refresh_failures_total", Help: "Failed downloads of the URL blocklist.", Type: "counter", Unit: "ops", Group: "Relay"})
+
+	archivedMetric       = newMetric(metric{Name: "rely_archive_events_total", Help: "Events written to the archive files.", Type: "counter", Unit: "ops", Group: "Storage"})
+	archiveDroppedMetric = newMetric(metric{Name: "rely_archive_dropped_total", Help: "Events not archived because the archive queue was full.", Type: "counter", Unit: "ops", Group: "Storage"})
+	archiveFailedMetric  = newMetric(metric{Name: "rely_archive_failed_total", Help: "Events not archived because of file errors.", Type: "counter", Unit: "ops", Group: "Storage"})
+
+	clickhousePartsMetric            = newMetric(metric{Name: "rely_clickhouse_parts", Help: "Active parts of the relay tables.", Type: "gauge", Unit: "short", Group: "ClickHouse"})
+	clickhouseRowsMetric             = newMetric(metric{Name: "rely_clickhouse_rows", Help: "Rows in the active parts of the relay tables.", Type: "gauge", Unit: "short", Group: "ClickHouse"})
+	clickhouseBytesMetric            = newMetric(metric{Name: "rely_clickhouse_bytes_on_disk", Help: "Bytes on disk of the relay tables.", Type: "gauge", Unit: "bytes", Group: "ClickHouse"})
+	clickhouseMergesMetric           = newMetric(metric{Name: "rely_clickhouse_merges", Help: "Merges in progress of the relay tables.", Type: "gauge", Unit: "short", Group: "ClickHouse"})
+	clickhouseReplicationDelayMetric = newMetric(metric{Name: "rely_clickhouse_replication_delay_seconds", Help: "Replication delay of the replicated relay tables.", Type: "gauge", Unit: "s", Group: "ClickHouse"})
+	clickhouseReplicationQueueMetric = newMetric(metric{Name: "rely_clickhouse_replication_queue", Help: "Tasks in the replication queue of the replicated relay tables.", Type: "gauge", Unit: "short", Group: "ClickHouse"})
+	clickhouseDiskFreeMetric         = newMetric(metric{Name: "rely_clickhouse_disk_free_bytes", Help: "Free space of the disks of the ClickHouse server.", Type: "gauge", Unit: "bytes", Group: "ClickHouse"})
+	clickhouseDiskTotalMetric        = newMetric(metric{Name: "rely_clickhouse_disk_total_bytes", Help: "Total space of the disks of the ClickHouse server.", Type: "gauge", Unit: "bytes", Group: "ClickHouse"})
+	clickhouseScrapeFailuresMetric   = newMetric(metric{Name: "rely_clickhouse_scrape_failures_total", Help: "Failed reads of the ClickHouse system tables.", Type: "counter", Unit: "ops", Group: "ClickHouse"})
+)
+
+// latencyOps are the values of the "op" label of the latency metric.
+var latencyOps = []string{"event", "req_to_eose", "on_event", "on_req", "on_count"}
+
+// write the metric with its value in the Prometheus text format.
+func (m metric) write(w io.Writer, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.Name, m.Help, m.Name, m.Type, m.Name, value)
+}
+
+// disconnections counts the disconnections of the clients by reason.
+type disconnections struct {
+	counts [rely.DisconnectShutdown + 1]atomic.Int64
+}
+
+func newDisconnections() *disconnections { return &disconnections{} }
+
+func (d *disconnections) add(reason rely.DisconnectReason) {
+	if reason >= 0 && int(reason) < len(d.counts) {
+		d.counts[reason].Add(1)
+	}
+}
+
+// metrics writes the disconnections of each reason.
+func (d *disconnections) metrics(w io.Writer) {
+	m := disconnectsMetric
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+	for i := range d.counts {
+		fmt.Fprintf(w, "%s{reason=%q} %d\n", m.Name, rely.DisconnectReason(i), d.counts[i].Load())
+	}
+}
+
+// writeRejections writes the rejected events of each policy and reason.
+func writeRejections(w io.Writer, rejections *rely.Rejections) {
+	m := rejectedMetric
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+
+	counts := rejections.Counts()
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b rely.Rejection) int {
+		return cmp.Or(strings.Compare(a.Policy, b.Policy), strings.Compare(a.Reason, b.Reason))
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{policy=%q,reason=%q} %d\n", m.Name, key.Policy, key.Reason, counts[key])
+	}
+}
+
+// writeClientRTTs writes the round-trip times of the clients by country, "unknown" if empty.
+func writeClientRTTs(w io.Writer, rtts map[string]rely.Latency) {
+	m := clientRTTMetric
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+	for _, country := range slices.Sorted(maps.Keys(rtts)) {
+		rtt := rtts[country]
+		if country == "" {
+			country = "unknown"
+		}
+		fmt.Fprintf(w, "%s{country=%q,quantile=\"0.5\"} %g\n", m.Name, country, rtt.P50.Seconds())
+		fmt.Fprintf(w, "%s{country=%q,quantile=\"0.95\"} %g\n", m.Name, country, rtt.P95.Seconds())
+		fmt.Fprintf(w, "%s{country=%q,quantile=\"0.99\"} %g\n", m.Name, country, rtt.P99.Seconds())
+		fmt.Fprintf(w, "%s_count{country=%q} %d\n", m.Name, country, rtt.Count)
+	}
+}
+
+// writeLatencies writes the latency summary of the relay operations.
+func writeLatencies(w io.Writer, latencies rely.Latencies) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", latencyMetric.Name, latencyMetric.Help, latencyMetric.Name)
+	for i, l := range []rely.Latency{latencies.Event, latencies.ReqToEOSE, latencies.OnEvent, latencies.OnReq, latencies.OnCount} {
+		op := latencyOps[i]
+		fmt.Fprintf(w, "%s{op=%q,quantile=\"0.5\"} %g\n", latencyMetric.Name, op, l.P50.Seconds())
+		fmt.Fprintf(w, "%s{op=%q,quantile=\"0.95\"} %g\n", latencyMetric.Name, op, l.P95.Seconds())
+		fmt.Fprintf(w, "%s{op=%q,quantile=\"0.99\"} %g\n", latencyMetric.Name, op, l.P99.Seconds())
+		fmt.Fprintf(w, "%s_count{op=%q} %d\n", latencyMetric.Name, op, l.Count)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// metricsCollector writes the metrics of an optional component in the Prometheus text format.
+type metricsCollector func(io.Writer)
+
+// startMonitoring serves the /health, /ready, /version and /metrics endpoints on the monitoring port
+// until the context is cancelled, together with the diagnostics, the bandwidth usage, the
+// operator notices and announcements, the connected clients and subscriptions, the pinned events, the cluster bans and the scheduled jobs if enabled.
+func startMonitoring(ctx context.Context, cfg config.MonitoringConfig, relay *rely.Relay, storage *clickhouse.Storage, bandwidth *rely.Bandwidth, pins *rely.Pins, cluster *rely.Cluster, standby *rely.Standby, jobs *scheduler, draining *atomic.Bool, collectors ...metricsCollector) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler(storage, time.Now()))
+	mux.HandleFunc("/ready", readyHandler(relay, storage, cfg.ReadyQueueLoad, draining))
+	mux.HandleFunc("/version", versionHandler)
+	if cfg.EnableMetrics {
+		mux.HandleFunc("/metrics", metricsHandler(relay, collectors))
+	}
+	if storage.QueryLogEnabled() {
+		mux.HandleFunc("/queries", queriesHandler(storage))
+	}
+	if cfg.Diagnostics {
+		registerDiagnostics(mux, cfg.ManagementToken)
+	}
+	if bandwidth != nil && cfg.ManagementToken != "" {
+		mux.Handle("/bandwidth", requireManagement(cfg.ManagementToken, bandwidthHandler(bandwidth)))
+	}
+	if cfg.ManagementToken != "" {
+		mux.Handle("POST /notice", requireManagement(cfg.ManagementToken, noticeHandler(relay)))
+		mux.Handle("GET /subscriptions", requireManagement(cfg.ManagementToken, subscriptionsHandler(relay)))
+		mux.Handle("GET /clients", requireManagement(cfg.ManagementToken, clientsHandler(relay)))
+		mux.Handle("GET /history", requireManagement(cfg.ManagementToken, historyHandler(storage)))
+	}
+	if relay.Identity() != nil && cfg.ManagementToken != "" {
+		mux.Handle("POST /announce", requireManagement(cfg.ManagementToken, announceHandler(relay)))
+	}
+	if pins != nil && cfg.ManagementToken != "" {
+		registerPins(mux, cfg.ManagementToken, pins, storage)
+	}
+	if cluster != nil && cfg.ManagementToken != "" {
+		registerBans(mux, cfg.ManagementToken, cluster)
+	}
+	if standby != nil && cfg.ManagementToken != "" {
+		mux.Handle("POST /promote", requireManagement(cfg.ManagementToken, promoteHandler(standby)))
+	}
+	if cfg.ManagementToken != "" {
+		registerJobs(mux, cfg.ManagementToken, jobs)
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.HealthCheckPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("Monitoring endpoint listening on port %d", cfg.HealthCheckPort)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Monitoring endpoint error: %v", err)
+	}
+}
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Storage string `json:"storage"`
+	Uptime  string `json:"uptime"`
+	Error   string `json:"error,omitempty"`
+}
+
+// versionHandler reports the version and build of the running binary.
+func versionHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, currentBuild())
+}
+
+// healthHandler reports whether the storage is reachable.
+func healthHandler(storage *clickhouse.Storage, start time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		response := healthResponse{
+			Status:  "healthy",
+			Storage: "connected",
+			Uptime:  time.Since(start).Round(time.Second).String(),
+		}
+		status := http.StatusOK
+
+		if err := storage.Ping(ctx); err != nil {
+			response.Status = "unhealthy"
+			response.Storage = "unreachable"
+			response.Error = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, status, response)
+	}
+}
+
+type readyResponse struct {
+	Ready     bool    `json:"ready"`
+	Draining  bool    `json:"draining"`
+	QueueLoad float64 `json:"queue_load"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// readyHandler reports whether the relay should receive new clients: the storage must be
+// reachable, the queue load below the threshold, and the relay must not be draining.
+// Unlike /health, a failure here means "route clients elsewhere", not "restart me".
+func readyHandler(relay *rely.Relay, storage *clickhouse.Storage, maxLoad float64, draining *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := readyResponse{
+			Ready:     true,
+			Draining:  draining.Load(),
+			QueueLoad: relay.QueueLoad(),
+		}
+
+		switch {
+		case response.Draining:
+			response.Reason = "draining"
+
+		case response.QueueLoad >= maxLoad:
+			response.Reason = fmt.Sprintf("queue load above %.2f", maxLoad)
+
+		default:
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+
+			if err := storage.Ping(ctx); err != nil {
+				response.Reason = "storage unreachable: " + err.Error()
+			}
+		}
+
+		status := http.StatusOK
+		if response.Reason != "" {
+			response.Ready = false
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, response)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// metricsHandler exposes the relay statistics in the Prometheus text format.
+func metricsHandler(relay *rely.Relay, collectors []metricsCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		clientsMetric.write(w, float64(relay.Clients()))
+		subscriptionsMetric.write(w, float64(relay.Subscriptions()))
+		filtersMetric.write(w, float64(relay.Filters()))
+		queueLoadMetric.write(w, relay.QueueLoad())
+		connectionsMetric.write(w, float64(relay.TotalConnections()))
+
+		buffered, limit := relay.MemoryUsage()
+		shedEvents, shedReqs := relay.Shed()
+		bufferedMetric.write(w, float64(buffered))
+		memoryBudgetMetric.write(w, float64(limit))
+		shedEventsMetric.write(w, float64(shedEvents))
+		shedReqsMetric.write(w, float64(shedReqs))
+		suppressedNoticesMetric.write(w, float64(relay.SuppressedNotices()))
+
+		writeLatencies(w, relay.Latencies())
+		writeClientRTTs(w, relay.RTTs())
+
+		for _, collect := range collectors {
+			collect(w)
+		}
+	}
+}
+
+// queriesHandler reports the dominant query patterns sampled in the query log.
+// The period (default 1h) and the number of patterns (default 20) can be set with
+// the "period" and "limit" query parameters.
+func queriesHandler(storage *clickhouse.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		period, err := time.ParseDuration(r.URL.Query().Get("period"))
+		if err != nil || period <= 0 {
+			period = time.Hour
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+
+		patterns, err := storage.QueryPatterns(r.Context(), period, limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, patterns)
+	}
+}
+
+// bandwidthHandler returns the pubkeys and IPs with the most traffic of the day.
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/bandwidth?limit=50
+func bandwidthHandler(bandwidth *rely.Bandwidth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		writeJSON(w, http.StatusOK, bandwidth.Top(limit))
+	}
+}
+
+// subscriptionsHandler lists the open subscriptions, oldest first, with their filters and the events
+// delivered to them, to see what's driving the load right now.
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/subscriptions?limit=100
+func subscriptionsHandler(relay *rely.Relay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subs, err := relay.ListSubscriptions()
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+
+		total := len(subs)
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err == nil && limit > 0 && limit < total {
+			subs = subs[:limit]
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"total": total, "subscriptions": subs})
+	}
+}
+
+// clientInfo is a connected client listed by the clientsHandler.
+type clientInfo struct {
+	UID         string           `json:"uid"`
+	IP          string           `json:"ip"`
+	Pubkey      string           `json:"pubkey,omitempty"`
+	Country     string           `json:"country,omitempty"`
+	ConnectedAt time.Time        `json:"connected_at"`
+	Stats       rely.ClientStats `json:"stats"`
+}
+
+// clientsHandler lists the connected clients with the statistics of their connection,
+// the busiest (by messages received) first.
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://host:8080/clients?limit=100
+func clientsHandler(relay *rely.Relay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients, err := relay.ListClients()
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+
+		infos := make([]clientInfo, len(clients))
+		for i, c := range clients {
+			infos[i] = clientInfo{
+				UID:         c.UID(),
+				IP:          c.IP(),
+				Pubkey:      c.Pubkey(),
+				Country:     c.Country(),
+				ConnectedAt: c.ConnectedAt(),
+				Stats:       c.Stats(),
+			}
+		}
+		slices.SortStableFunc(infos, func(a, b clientInfo) int {
+			return cmp.Compare(b.Stats.MessagesReceived, a.Stats.MessagesReceived)
+		})
+
+		total := len(infos)
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err == nil && limit > 0 && limit < total {
+			infos = infos[:limit]
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"total": total, "clients": infos})
+	}
+}
+
+// historyHandler lists the stored versions of the replaceable or addressable event of the kind, pubkey and d
+// query parameters, newest first, up to the limit (default 20).
+func historyHandler(storage *clickhouse.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		kind, err := strconv.Atoi(query.Get("kind"))
+		if err != nil || !nostr.IsValidPublicKey(query.Get("pubkey")) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the kind and the hex pubkey are required"})
+			return
+		}
+		if !nostr.IsReplaceableKind(kind) && !nostr.IsAddressableKind(kind) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the kind must be replaceable or addressable"})
+			return
+		}
+
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+
+		versions, err := storage.History(r.Context(), kind, query.Get("pubkey"), query.Get("d"), min(limit, 1000))
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+	}
+}
+
+// noticeHandler sends the text of the request body as a NOTICE to all connected clients,
+// e.g. to warn them of a maintenance.
+func noticeHandler(relay *rely.Relay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, ok := readText(w, r)
+		if !ok {
+			return
+		}
+
+		if err := relay.NotifyAll(content); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"clients": relay.Clients()})
+	}
+}
+
+// announceHandler publishes the text of the request body as a note signed by the relay,
+// for operator announcements like scheduled maintenance.
+func announceHandler(relay *rely.Relay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, ok := readText(w, r)
+		if !ok {
+			return
+		}
+
+		event := &nostr.Event{Kind: nostr.KindTextNote, Content: content}
+		if err := relay.Publish(event); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, event)
+	}
+}
+
+// promoteHandler promotes the standby, so that it accepts the events of the clients routed to it.
+// Promoting it again does nothing, which the "changed" field of the response reports.
+func promoteHandler(standby *rely.Standby) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changed := standby.Promote()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"changed":    changed,
+			"last_event": standby.Last(),
+			"replicated": standby.Replicated(),
+		})
+	}
+}
+
+// readText reads the text of the request body, responding with an error if it's empty.
+func readText(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "failed to read the body", http.StatusBadRequest)
+		return "", false
+	}
+
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		http.Error(w, "the body is empty", http.StatusBadRequest)
+		return "", false
+	}
+	return text, true
+}
+
+// bandwidthMetrics exposes the bytes exchanged with the clients.
+func bandwidthMetrics(bandwidth *rely.Bandwidth) metricsCollector {
+	return func(w io.Writer) {
+		bandwidthSentMetric.write(w, float64(bandwidth.Sent()))
+		bandwidthReceivedMetric.write(w, float64(bandwidth.Received()))
+	}
+}
+
+// adaptiveMetrics exposes the state of the adaptive anti-spam defense.
+func adaptiveMetrics(defense *rely.AdaptiveDefense) metricsCollector {
+	return func(w io.Writer) {
+		pow, rateLimited := defense.Rejected()
+		antispamLevelMetric.write(w, float64(defense.Level()))
+		antispamPowMetric.write(w, float64(defense.MinPow()))
+		antispamEventRateMetric.write(w, defense.EventRate())
+		antispamPowRejectedMetric.write(w, float64(pow))
+		antispamRateRejectedMetric.write(w, float64(rateLimited))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// runOptimize implements the optimize command, which applies the configured codecs
+// to the event tables and rewrites their parts to use them, or with -dedup merges
+// the partitions holding duplicates.
+func runOptimize(args []string) error {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	tables := flags.String("tables", strings.Join(clickhouse.EventTables, ","), "comma-separated tables to rewrite")
+	dedup := flags.Bool("dedup", false, "only merge the unmerged partitions of the tables with duplicates, without changing the codecs")
+	minDuplicates := flags.Float64("min-duplicates", 0, "with -dedup, skip the tables whose duplicate ratio is not above this")
+	pause := flags.Duration("pause", 0, "with -dedup, pause between partitions to limit the impact on queries")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay optimize [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Applies clickhouse.compression to the event tables and rewrites their parts.\n")
+		fmt.Fprintf(flags.Output(), "With -dedup, runs OPTIMIZE FINAL on the partitions with unmerged parts instead.\n")
+		fmt.Fprintf(flags.Output(), "It's an expensive operation, run it when the relay is not busy.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	selected := strings.Split(*tables, ",")
+	for _, table := range selected {
+		if !slices.Contains(clickhouse.EventTables, table) {
+			return fmt.Errorf("unknown table %q, must be one of %v", table, clickhouse.EventTables)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, storage, err := maintenanceStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if *dedup {
+		return compact(ctx, storage, selected, *minDuplicates, *pause, func(format string, args ...any) {
+			fmt.Printf("  "+format+"\n", args...)
+		})
+	}
+
+	codecs := compression(cfg.ClickHouse.Compression)
+	if err := storage.ApplyCompression(ctx, codecs); err != nil {
+		return err
+	}
+	fmt.Printf("  applied the codecs (content ZSTD level %d, delta timestamps %t)\n", codecs.ContentLevel, codecs.DeltaTimestamps)
+	if codecs.LowCardinalityKind {
+		fmt.Println("  skipped low_cardinality_kind: kind is part of the sorting keys, it only applies to new schemas")
+	}
+
+	for _, table := range selected {
+		start := time.Now()
+		if err := storage.Optimize(ctx, table); err != nil {
+			return err
+		}
+		fmt.Printf("  rewrote %s in %s\n", table, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// compact merges the unmerged partitions of the tables whose duplicate ratio is above the minimum,
+// pausing between partitions, so that queries see fewer duplicates and FINAL has less work to do.
+func compact(ctx context.Context, storage *clickhouse.Storage, tables []string, minDuplicates float64, pause time.Duration, logf func(string, ...any)) error {
+	for _, table := range tables {
+		state, err := storage.CompactionState(ctx, table)
+		if err != nil {
+			return err
+		}
+
+		ratio := state.DuplicateRatio()
+		if len(state.Unmerged) == 0 || ratio <= minDuplicates {
+			logf("skipped %s (%.2f%% duplicates, %d unmerged partitions)", table, ratio*100, len(state.Unmerged))
+			continue
+		}
+
+		start := time.Now()
+		if err := storage.OptimizePartitions(ctx, table, state.Unmerged, pause, nil); err != nil {
+			return err
+		}
+		logf("merged %d partitions of %s (%.2f%% duplicates) in %s", len(state.Unmerged), table, ratio*100, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// maintenanceStorage loads the configuration and connects to ClickHouse, for the
+// commands maintaining the tables. The storage must be closed by the caller.
+func maintenanceStorage() (*config.Config, *clickhouse.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	storage, err := clickhouse.NewStorage(clickhouse.Config{
+		DSN:           cfg.ClickHouse.DSN,
+		BatchSize:     1,
+		FlushInterval: time.Second,
+		MaxOpenConns:  1,
+		MaxIdleConns:  1,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, storage, nil
+}
+
+// compression returns the codecs of the event tables from the configuration.
+func compression(c config.CompressionConfig) clickhouse.Compression {
+	return clickhouse.Compression{
+		ContentLevel:       c.ContentLevel,
+		DeltaTimestamps:    c.DeltaTimestamps,
+		LowCardinalityKind: c.LowCardinalityKind,
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// registerPins serves the management API of the pinned events, requiring the management token.
+func registerPins(mux *http.ServeMux, token string, pins *rely.Pins, storage *clickhouse.Storage) {
+	mux.Handle("GET /pins", requireManagement(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, pins.Events())
+	})))
+	mux.Handle("POST /pins", requireManagement(token, pinHandler(pins, storage)))
+	mux.Handle("DELETE /pins/{id}", requireManagement(token, unpinHandler(pins, storage)))
+}
+
+// pinHandler pins the stored event whose ID is in the body as {"id": "<hex>"},
+// or the signed event in the body, which doesn't need to be stored.
+func pinHandler(pins *rely.Pins, storage *clickhouse.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 512*1024))
+		if err != nil {
+			http.Error(w, "failed to read the body", http.StatusBadRequest)
+			return
+		}
+
+		var event nostr.Event
+		if err := json.Unmarshal(body, &event); err != nil || !nostr.IsValid32ByteHex(event.ID) {
+			http.Error(w, `the body must be {"id": "<hex>"} or a signed event`, http.StatusBadRequest)
+			return
+		}
+
+		if event.Sig == "" {
+			events, err := storage.QueryEvents(r.Context(), nil, nostr.Filters{{IDs: []string{event.ID}}})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(events) == 0 {
+				http.Error(w, "event not found", http.StatusNotFound)
+				return
+			}
+			event = events[0]
+		}
+
+		if ok, _ := event.CheckSignature(); !ok || event.GetID() != event.ID {
+			http.Error(w, "invalid event signature or ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := storage.PinEvent(r.Context(), event); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		pins.Pin(event)
+		writeJSON(w, http.StatusOK, event)
+	}
+}
+
+// unpinHandler unpins the event with the ID in the path.
+func unpinHandler(pins *rely.Pins, storage *clickhouse.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !pins.IsPinned(id) {
+			http.Error(w, "event not pinned", http.StatusNotFound)
+			return
+		}
+
+		if err := storage.UnpinEvent(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		pins.Unpin(id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// runProjections implements the projections command, which manages the tables copying
+// the events table with a different sorting key (events_by_author, events_by_kind...).
+func runProjections(args []string) error {
+	usage := fmt.Sprintf("usage: nostr-relay projections list|add|drop|rebuild [%s] | reconcile [window]",
+		strings.Join(clickhouse.ProjectionNames, "|"))
+
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	command := args[0]
+	var name string
+	window := 24 * time.Hour
+
+	switch command {
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("%s", usage)
+		}
+
+	case "add", "drop", "rebuild":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", usage)
+		}
+
+		name = args[1]
+		if !slices.Contains(clickhouse.ProjectionNames, name) {
+			return fmt.Errorf("unknown projection %q, must be one of %v", name, clickhouse.ProjectionNames)
+		}
+
+	case "reconcile":
+		if len(args) > 2 {
+			return fmt.Errorf("%s", usage)
+		}
+
+		if len(args) == 2 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid window %q, must be a positive duration such as 24h", args[1])
+			}
+			window = d
+		}
+
+	default:
+		return fmt.Errorf("%s", usage)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, storage, err := maintenanceStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	start := time.Now()
+	progress := func(p clickhouse.Progress) {
+		fmt.Printf("  backfilled partition %s (%d/%d) in %s\n",
+			p.Partition, p.Done, p.Total, time.Since(start).Round(time.Millisecond))
+	}
+
+	switch command {
+	case "list":
+		projections, err := storage.Projections(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATUS\tROWS\tSIZE")
+		for _, p := range projections {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%.1f MB\n", p.Name, status(p), p.Rows, float64(p.Bytes)/(1<<20))
+		}
+		return w.Flush()
+
+	case "add":
+		partitioning := clickhouse.Partitioning(cfg.ClickHouse.Partitioning)
+		if err := storage.AddProjection(ctx, name, compression(cfg.ClickHouse.Compression).Schema, partitioning.Schema); err != nil {
+			return err
+		}
+
+		fmt.Printf("  created %s, backfilling it from the events table...\n", name)
+		if err := storage.Backfill(ctx, name, progress); err != nil {
+			return err
+		}
+		fmt.Println("\nRestart the relays to route the queries to it.")
+
+	case "drop":
+		if err := storage.DropProjection(ctx, name); err != nil {
+			return err
+		}
+		fmt.Printf("  dropped %s\n", name)
+		fmt.Println("\nRestart the relays to route its queries to the events table.")
+
+	case "rebuild":
+		fmt.Printf("  rebuilding %s from the events table...\n", name)
+		if err := storage.RebuildProjection(ctx, name, progress); err != nil {
+			return err
+		}
+
+	case "reconcile":
+		fmt.Printf("  reconciling the projections with the rows of the events table written in the last %s...\n", window)
+		reconciliations, err := storage.Reconcile(ctx, start.Add(-window), start)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range reconciliations {
+			fmt.Printf("  %s: %d missing rows copied\n", r.Projection, r.Missing)
+		}
+	}
+	return nil
+}
+
+// status returns a short description of the state of the projection.
+func status(p clickhouse.Projection) string {
+	switch {
+	case p.Exists && p.Active:
+		return "active"
+	case p.Exists:
+		return "stale (no materialized view)"
+	default:
+		return "missing"
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// reconciler runs the jobs copying to the projections the rows of the events table they miss: the reconciliation
+// of the recent rows, and the verification of samples of the older ones. It counts the rows of each projection
+// that were sampled, found missing and repaired.
+type reconciler struct {
+	storage *clickhouse.Storage
+
+	mu         sync.Mutex
+	repaired   map[string]uint64  // by projection
+	sampled    map[string]uint64  // by projection
+	missing    map[string]uint64  // by projection, among the sampled rows
+	divergence map[string]float64 // by projection, the ratio of sampled rows missing in the last verification
+}
+
+func newReconciler(storage *clickhouse.Storage) *reconciler {
+	return &reconciler{
+		storage:    storage,
+		repaired:   make(map[string]uint64),
+		sampled:    make(map[string]uint64),
+		missing:    make(map[string]uint64),
+		divergence: make(map[string]float64),
+	}
+}
+
+// reconcile returns the job reconciling the projections with the rows of the events table written within
+// the window, except the last ones.
+func (r *reconciler) reconcile(cfg config.ReconciliationJobConfig) func(context.Context) error {
+	return func(ctx context.Context) error {
+		until := time.Now().Add(-cfg.Lag)
+		reconciliations, err := r.storage.Reconcile(ctx, until.Add(-cfg.Window), until)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		for _, rec := range reconciliations {
+			if rec.Missing > 0 {
+				log.Printf("Reconciliation: copied %d rows missing from %s", rec.Missing, rec.Projection)
+			}
+			r.repaired[rec.Projection] += rec.Missing
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to reconcile the projections: %w", err)
+		}
+		return nil
+	}
+}
+
+// verify returns the job checking that the projections contain a sample of the events written before the lag.
+func (r *reconciler) verify(cfg config.VerificationJobConfig) func(context.Context) error {
+	return func(ctx context.Context) error {
+		verifications, err := r.storage.Verify(ctx, cfg.Sample, time.Now().Add(-cfg.Lag))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		for _, v := range verifications {
+			if v.Missing > 0 {
+				log.Printf("Verification: copied %d of %d sampled rows missing from %s (partition %s)",
+					v.Missing, v.Sampled, v.Projection, v.Partition)
+			}
+
+			r.sampled[v.Projection] += v.Sampled
+			r.missing[v.Projection] += v.Missing
+			r.repaired[v.Projection] += v.Missing
+			if v.Sampled > 0 {
+				r.divergence[v.Projection] = float64(v.Missing) / float64(v.Sampled)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to verify the projections: %w", err)
+		}
+		return nil
+	}
+}
+
+// metrics writes the rows of each projection that were repaired, sampled and found missing.
+func (r *reconciler) metrics(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, counts := range []struct {
+		metric metric
+		values map[string]uint64
+	}{
+		{projectionRepairsMetric, r.repaired},
+		{projectionSampledMetric, r.sampled},
+		{projectionMissingMetric, r.missing},
+	} {
+		m := counts.metric
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+		for _, projection := range slices.Sorted(maps.Keys(counts.values)) {
+			fmt.Fprintf(w, "%s{projection=%q} %d\n", m.Name, projection, counts.values[projection])
+		}
+	}
+
+	m := projectionDivergenceMetric
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+	for _, projection := range slices.Sorted(maps.Keys(r.divergence)) {
+		fmt.Fprintf(w, "%s{projection=%q} %g\n", m.Name, projection, r.divergence[projection])
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+const challengeTTL = 10 * time.Minute
+
+var (
+	ErrInvalidPubkey  = errors.New("invalid pubkey: use the 64 characters hex format")
+	ErrCaptchaFailed  = errors.New("captcha verification failed, please try again")
+	ErrPaymentPending = errors.New("the invoice has not been paid yet")
+)
+
+// registration serves the /register page, which grants time-limited write
+// permission to pubkeys after they solve a captcha or pay a Lightning invoice.
+type registration struct {
+	cfg     config.RegisterConfig
+	perms   *rely.WritePermissions
+	storage *clickhouse.Storage
+	secret  []byte
+	client  *http.Client
+
+	mu       sync.Mutex
+	invoices map[string]pendingInvoice // payment hash -> invoice
+	answered map[string]time.Time      // nonce of the answered challenges -> expiration
+}
+
+type pendingInvoice struct {
+	pubkey    string
+	expiresAt time.Time
+}
+
+func newRegistration(cfg config.RegisterConfig, perms *rely.WritePermissions, storage *clickhouse.Storage) *registration {
+	secret := []byte(cfg.Secret)
+	if cfg.Captcha == "builtin" && len(secret) == 0 {
+		// challenges are signed with a random key, so they don't survive restarts
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	return &registration{
+		cfg:      cfg,
+		perms:    perms,
+		storage:  storage,
+		secret:   secret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		invoices: make(map[string]pendingInvoice),
+		answered: make(map[string]time.Time),
+	}
+}
+
+// Load the active grants from the storage at regular intervals, so that grants
+// issued by other relay instances are honored too. It blocks until the context is cancelled.
+func (r *registration) Load(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		grants, err := r.storage.ActiveGrants(ctx)
+		if err != nil {
+			log.Printf("Failed to load write grants: %v", err)
+		}
+
+		for _, g := range grants {
+			r.perms.Grant(g.Pubkey, g.ExpiresAt)
+		}
+		r.perms.Prune()
+		r.pruneInvoices()
+		r.pruneAnswered()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *registration) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if hash := req.URL.Query().Get("payment_hash"); hash != "" {
+			r.checkPayment(w, req, hash)
+			return
+		}
+		r.render(w, http.StatusOK, page{})
+
+	case http.MethodPost:
+		r.register(w, req)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *registration) register(w http.ResponseWriter, req *http.Request) {
+	pubkey, err := parsePubkey(req.FormValue("pubkey"))
+	if err != nil {
+		r.render(w, http.StatusBadRequest, page{Error: err.Error()})
+		return
+	}
+
+	if req.FormValue("method") == "lightning" && r.cfg.Lightning.Enabled {
+		r.createInvoice(w, req, pubkey)
+		return
+	}
+
+	if r.cfg.Captcha == "none" {
+		r.render(w, http.StatusBadRequest, page{Error: "registration requires a Lightning payment"})
+		return
+	}
+
+	if r.cfg.Captcha == "builtin" && req.FormValue("nonce") == "" {
+		// the challenge is bound to the pubkey, so it's issued once the pubkey is known
+		r.render(w, http.StatusOK, page{Pubkey: pubkey})
+		return
+	}
+
+	if err := r.verifyCaptcha(req, pubkey); err != nil {
+		r.render(w, http.StatusForbidden, page{Error: err.Error(), Pubkey: pubkey})
+		return
+	}
+
+	until, err := r.grant(req.Context(), pubkey, "captcha")
+	if err != nil {
+		log.Printf("Failed to save write grant for %s: %v", pubkey, err)
+		r.render(w, http.StatusInternalServerError, page{Error: "failed to save the registration, please try again later"})
+		return
+	}
+
+	r.render(w, http.StatusOK, page{Granted: until})
+}
+
+// grant write permission to the pubkey, storing it so that it's shared by all relay instances.
+func (r *registration) grant(ctx context.Context, pubkey, method string) (time.Time, error) {
+	now := time.Now()
+	until := now.Add(r.cfg.GrantDuration)
+
+	err := r.storage.SaveGrant(ctx, clickhouse.Grant{
+		Pubkey:    pubkey,
+		Method:    method,
+		ExpiresAt: until,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	r.perms.Grant(pubkey, until)
+	return until, nil
+}
+
+// parsePubkey validates the hex pubkey, returning it in lowercase.
+func parsePubkey(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !nostr.IsValid32ByteHex(s) {
+		return "", ErrInvalidPubkey
+	}
+	return strings.ToLower(s), nil
+}
+
+// challenge is the builtin captcha: a small sum drawn in an image, whose answer is bound to the pubkey,
+// a random nonce and an expiration time by an HMAC signature. The token signs the same values without
+// the answer, so that the nonce is authenticated before the answer is checked: each challenge can be
+// answered once, right or wrong, and the nonces are only remembered until they expire.
+type challenge struct {
+	Image     template.URL
+	Nonce     string
+	ExpiresAt int64
+	Token     string
+	Signature string
+}
+
+func (r *registration) newChallenge(pubkey string) challenge {
+	a, _ := rand.Int(rand.Reader, big.NewInt(50))
+	b, _ := rand.Int(rand.Reader, big.NewInt(50))
+	expiresAt := time.Now().Add(challengeTTL).Unix()
+	answer := a.Int64() + b.Int64()
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	c := challenge{
+		Image:     captchaImage(fmt.Sprintf("%d + %d", a.Int64(), b.Int64())),
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: expiresAt,
+	}
+	c.Token = r.sign(c.Nonce, pubkey, expiresAt)
+	c.Signature = r.sign(c.Nonce, pubkey, expiresAt, answer)
+	return c
+}
+
+// sign returns the hex HMAC of the values of a challenge.
+func (r *registration) sign(nonce, pubkey string, expiresAt int64, answer ...int64) string {
+	mac := hmac.New(sha256.New, r.secret)
+	fmt.Fprintf(mac, "%s:%s:%d", nonce, pubkey, expiresAt)
+	for _, a := range answer {
+		fmt.Fprintf(mac, ":%d", a)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// answer marks the nonce of a challenge as answered until it expires,
+// returning false if it was already answered.
+func (r *registration) answer(nonce string, expiresAt int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.answered[nonce]; ok {
+		return false
+	}
+	r.answered[nonce] = time.Unix(expiresAt, 0)
+	return true
+}
+
+func (r *registration) pruneAnswered() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for nonce, expiresAt := range r.answered {
+		if now.After(expiresAt) {
+			delete(r.answered, nonce)
+		}
+	}
+}
+
+func (r *registration) verifyCaptcha(req *http.Request, pubkey string) error {
+	switch r.cfg.Captcha {
+	case "builtin":
+		nonce := req.FormValue("nonce")
+		expiresAt, err := strconv.ParseInt(req.FormValue("expires_at"), 10, 64)
+		if err != nil || time.Now().Unix() > expiresAt {
+			return ErrCaptchaFailed
+		}
+
+		if !hmac.Equal([]byte(r.sign(nonce, pubkey, expiresAt)), []byte(req.FormValue("token"))) {
+			return ErrCaptchaFailed
+		}
+
+		if !r.answer(nonce, expiresAt) {
+			return ErrCaptchaFailed
+		}
+
+		answer, err := strconv.ParseInt(strings.TrimSpace(req.FormValue("answer")), 10, 64)
+		if err != nil {
+			return ErrCaptchaFailed
+		}
+
+		if !hmac.Equal([]byte(r.sign(nonce, pubkey, expiresAt, answer)), []byte(req.FormValue("signature"))) {
+			return ErrCaptchaFailed
+		}
+		return nil
+
+	case "turnstile":
+		return r.siteVerify(req, "https://challenges.cloudflare.com/turnstile/v0/siteverify", "cf-turnstile-response")
+
+	case "hcaptcha":
+		return r.siteVerify(req, "https://api.hcaptcha.com/siteverify", "h-captcha-response")
+
+	default:
+		return ErrCaptchaFailed
+	}
+}
+
+// siteVerify validates the captcha token with the provider, using the
+// siteverify API shared by Cloudflare Turnstile and hCaptcha.
+func (r *registration) siteVerify(req *http.Request, endpoint, field string) error {
+	token := req.FormValue(field)
+	if token == "" {
+		return ErrCaptchaFailed
+	}
+
+	form := url.Values{
+		"secret":   {r.cfg.Secret},
+		"response": {token},
+		"remoteip": {rely.IP(req)},
+	}
+
+	resp, err := r.client.PostForm(endpoint, form)
+	if err != nil {
+		return fmt.Errorf("failed to verify the captcha: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Success {
+		return ErrCaptchaFailed
+	}
+	return nil
+}
+
+// createInvoice requests an invoice from the LNbits wallet, and remembers
+// which pubkey to grant when it gets paid.
+func (r *registration) createInvoice(w http.ResponseWriter, req *http.Request, pubkey string) {
+	body, _ := json.Marshal(map[string]any{
+		"out":    false,
+		"amount": r.cfg.Lightning.Amount,
+		"memo":   "Write access to the relay for " + pubkey,
+		"expiry": int(challengeTTL.Seconds()),
+	})
+
+	var invoice struct {
+		PaymentHash    string `json:"payment_hash"`
+		PaymentRequest string `json:"payment_request"`
+		Bolt11         string `json:"bolt11"`
+	}
+
+	if err := r.lnbits(req.Context(), http.MethodPost, "/api/v1/payments", body, &invoice); err != nil {
+		log.Printf("Failed to create the registration invoice: %v", err)
+		r.render(w, http.StatusBadGateway, page{Error: "failed to create the invoice, please try again later"})
+		return
+	}
+
+	if invoice.PaymentRequest == "" {
+		invoice.PaymentRequest = invoice.Bolt11
+	}
+
+	r.mu.Lock()
+	r.invoices[invoice.PaymentHash] = pendingInvoice{pubkey: pubkey, expiresAt: time.Now().Add(challengeTTL)}
+	r.mu.Unlock()
+
+	r.render(w, http.StatusOK, page{
+		Invoice:     invoice.PaymentRequest,
+		PaymentHash: invoice.PaymentHash,
+	})
+}
+
+// checkPayment grants write permission once the invoice with the payment hash has been paid.
+func (r *registration) checkPayment(w http.ResponseWriter, req *http.Request, hash string) {
+	r.mu.Lock()
+	invoice, ok := r.invoices[hash]
+	r.mu.Unlock()
+
+	if !ok {
+		r.render(w, http.StatusNotFound, page{Error: "unknown or expired invoice"})
+		return
+	}
+
+	var status struct {
+		Paid bool `json:"paid"`
+	}
+
+	if err := r.lnbits(req.Context(), http.MethodGet, "/api/v1/payments/"+url.PathEscape(hash), nil, &status); err != nil {
+		log.Printf("Failed to check the registration invoice %s: %v", hash, err)
+		r.render(w, http.StatusBadGateway, page{Error: "failed to check the payment, please try again later"})
+		return
+	}
+
+	if !status.Paid {
+		r.render(w, http.StatusOK, page{Error: ErrPaymentPending.Error(), PaymentHash: hash})
+		return
+	}
+
+	until, err := r.grant(req.Context(), invoice.pubkey, "lightning")
+	if err != nil {
+		log.Printf("Failed to save write grant for %s: %v", invoice.pubkey, err)
+		r.render(w, http.StatusInternalServerError, page{Error: "failed to save the registration, please try again later"})
+		return
+	}
+
+	r.mu.Lock()
+	delete(r.invoices, hash)
+	r.mu.Unlock()
+
+	r.render(w, http.StatusOK, page{Granted: until})
+}
+
+func (r *registration) lnbits(ctx context.Context, method, path string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(r.cfg.Lightning.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Api-Key", r.cfg.Lightning.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (r *registration) pruneInvoices() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for hash, invoice := range r.invoices {
+		if now.After(invoice.expiresAt) {
+			delete(r.invoices, hash)
+		}
+	}
+}
+
+type page struct {
+	Error       string
+	Granted     time.Time
+	Invoice     string
+	PaymentHash string
+
+	Pubkey    string
+	Captcha   string
+	SiteKey   string
+	Challenge challenge
+	Lightning bool
+	Amount    int64
+}
+
+func (r *registration) render(w http.ResponseWriter, status int, p page) {
+	p.Captcha = r.cfg.Captcha
+	p.SiteKey = r.cfg.SiteKey
+	p.Lightning = r.cfg.Lightning.Enabled
+	p.Amount = r.cfg.Lightning.Amount
+	if p.Captcha == "builtin" && p.Pubkey != "" {
+		p.Challenge = r.newChallenge(p.Pubkey)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := registerTemplate.Execute(w, p); err != nil {
+		log.Printf("Failed to render the registration page: %v", err)
+	}
+}
+
+var registerTemplate = template.Must(template.New("register").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Register</title>
+{{if eq .Captcha "turnstile"}}<script src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer></script>{{end}}
+{{if eq .Captcha "hcaptcha"}}<script src="https://js.hcaptcha.com/1/api.js" async defer></script>{{end}}
+</head>
+<body>
+<h1>Register to publish on this relay</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if not .Granted.IsZero}}
+<p>Your pubkey can publish until {{.Granted.Format "2006-01-02 15:04 MST"}}.</p>
+{{else if .Invoice}}
+<p>Pay this invoice, then check the payment:</p>
+<pre>{{.Invoice}}</pre>
+<p><a href="?payment_hash={{.PaymentHash}}">I have paid</a></p>
+{{else if .PaymentHash}}
+<p><a href="?payment_hash={{.PaymentHash}}">Check again</a></p>
+{{else}}
+{{if ne .Captcha "none"}}
+<form method="post">
+<label>Pubkey (hex) <input name="pubkey" value="{{.Pubkey}}" required></label>
+{{if eq .Captcha "builtin"}}
+{{if .Challenge.Nonce}}
+<p><img src="{{.Challenge.Image}}" alt="captcha"></p>
+<label>How much is the sum in the image? <input name="answer" autocomplete="off" required></label>
+<input type="hidden" name="nonce" value="{{.Challenge.Nonce}}">
+<input type="hidden" name="expires_at" value="{{.Challenge.ExpiresAt}}">
+<input type="hidden" name="token" value="{{.Challenge.Token}}">
+<input type="hidden" name="signature" value="{{.Challenge.Signature}}">
+{{end}}
+{{else if eq .Captcha "turnstile"}}
+<div class="cf-turnstile" data-sitekey="{{.SiteKey}}"></div>
+{{else if eq .Captcha "hcaptcha"}}
+<div class="h-captcha" data-sitekey="{{.SiteKey}}"></div>
+{{end}}
+<button type="submit">Register</button>
+</form>
+{{end}}
+{{if .Lightning}}
+<form method="post">
+<input type="hidden" name="method" value="lightning">
+<label>Pubkey (hex) <input name="pubkey" required></label>
+<button type="submit">Pay {{.Amount}} sats</button>
+</form>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
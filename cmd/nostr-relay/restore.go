@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/storage/clickhouse"
+)
+
+// maxRestoreLine is the maximum size of an event in the files being restored.
+const maxRestoreLine = 16 * 1024 * 1024
+
+// runRestore implements the restore command, which inserts the events of the archive files
+// (or any backup of JSON lines) that are missing from ClickHouse.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := flags.String("from", "", "file or directory of .jsonl or .jsonl.gz files to restore (required)")
+	checkpoint := flags.String("checkpoint", "", "file recording the progress, to resume an interrupted restore (default <from>.checkpoint)")
+	batchSize := flags.Int("batch", 1000, "events inserted per batch")
+	signatures := flags.Bool("signatures", false, "also verify the signatures of the events, not only their IDs")
+	dryRun := flags.Bool("dry-run", false, "only report how many events would be inserted")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay restore -from <path> [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Inserts the events of the archive files that are missing from ClickHouse,\n")
+		fmt.Fprintf(flags.Output(), "skipping those whose ID doesn't match their content, those already stored and those deleted.\n")
+		fmt.Fprintf(flags.Output(), "The files are restored in order of name, and an interrupted restore resumes from the checkpoint.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *from == "" {
+		flags.Usage()
+		return errors.New("the -from path is required")
+	}
+	if *batchSize <= 0 {
+		return errors.New("the -batch size must be positive")
+	}
+	if *checkpoint == "" {
+		*checkpoint = filepath.Clean(*from) + ".checkpoint"
+	}
+
+	files, err := restoreFiles(*from)
+	if err != nil {
+		return err
+	}
+
+	progress, err := loadCheckpoint(*checkpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	_, storage, err := maintenanceStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	r := &restorer{
+		storage:    storage,
+		checkpoint: *checkpoint,
+		progress:   progress,
+		batchSize:  *batchSize,
+		signatures: *signatures,
+		dryRun:     *dryRun,
+	}
+	if *dryRun {
+		r.seen = make(map[string]struct{})
+	}
+
+	start := time.Now()
+	for _, file := range files {
+		if err := r.restore(ctx, file); err != nil {
+			return err
+		}
+	}
+
+	verb := "inserted"
+	if *dryRun {
+		verb = "would insert"
+	}
+	fmt.Printf("  %s %d events in %s (%d read, %d invalid, %d duplicates, %d deleted)\n",
+		verb, r.total.Inserted, time.Since(start).Round(time.Millisecond), r.read, r.invalid, r.total.Duplicates, r.total.Deleted)
+	return nil
+}
+
+// restoreFiles returns the file, or the .jsonl and .jsonl.gz files of the directory in order of name.
+func restoreFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz")) {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .jsonl or .jsonl.gz files in %s", path)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// restoreCheckpoint is the progress of a restore, by file name.
+type restoreCheckpoint struct {
+	Lines map[string]int64 `json:"lines"` // lines restored
+}
+
+func loadCheckpoint(path string) (*restoreCheckpoint, error) {
+	progress := &restoreCheckpoint{Lines: make(map[string]int64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if progress.Lines == nil {
+		progress.Lines = make(map[string]int64)
+	}
+	return progress, nil
+}
+
+// save writes the checkpoint atomically, so that it's never left half written.
+func (c *restoreCheckpoint) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write the checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+type restorer struct {
+	storage    *clickhouse.Storage
+	checkpoint string
+	progress   *restoreCheckpoint
+	batchSize  int
+	signatures bool
+	dryRun     bool
+
+	// seen holds the IDs counted by a dry run, which doesn't insert them
+	seen map[string]struct{}
+
+	read    int64
+	invalid int64
+	total   clickhouse.Restored
+}
+
+// restore inserts the events of the file, skipping the lines restored by a previous run.
+// Since the archive files are append-only, the events appended after that run are restored.
+func (r *restorer) restore(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	name := filepath.Base(path)
+	skip := r.progress.Lines[name]
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxRestoreLine)
+
+	var line int64
+	batch := make([]*nostr.Event, 0, r.batchSize)
+	flush := func() error {
+		if err := r.insert(ctx, batch); err != nil {
+			return fmt.Errorf("failed to restore %s up to line %d: %w", path, line, err)
+		}
+		batch = batch[:0]
+
+		if !r.dryRun {
+			r.progress.Lines[name] = line
+			return r.progress.save(r.checkpoint)
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line++
+		if line <= skip {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.read++
+		e, err := r.parse(scanner.Bytes())
+		if err != nil {
+			r.invalid++
+			fmt.Printf("  %s:%d: %v\n", name, line, err)
+			continue
+		}
+
+		batch = append(batch, e)
+		if len(batch) == r.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		// the last flush of a crashed relay, the complete lines before it are restored
+		fmt.Printf("  %s is truncated after line %d\n", name, line)
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if line > skip {
+		fmt.Printf("  restored %s (%d lines)\n", name, line-skip)
+	}
+	return nil
+}
+
+// parse returns the event of the line, or an error if its ID (or signature) doesn't match its content.
+func (r *restorer) parse(line []byte) (*nostr.Event, error) {
+	e := &nostr.Event{}
+	if err := json.Unmarshal(line, e); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+
+	if !e.CheckID() {
+		return nil, fmt.Errorf("event %s: the ID doesn't match the content", e.ID)
+	}
+
+	if r.signatures {
+		if ok, _ := e.CheckSignature(); !ok {
+			return nil, fmt.Errorf("event %s: invalid signature", e.ID)
+		}
+	}
+	return e, nil
+}
+
+func (r *restorer) insert(ctx context.Context, batch []*nostr.Event) error {
+	if r.dryRun {
+		// the events counted by previous batches are not stored, so they are skipped here
+		kept := batch[:0]
+		for _, e := range batch {
+			if _, ok := r.seen[e.ID]; ok {
+				r.total.Duplicates++
+				continue
+			}
+			r.seen[e.ID] = struct{}{}
+			kept = append(kept, e)
+		}
+		batch = kept
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	restored, err := r.storage.RestoreEvents(ctx, batch, r.dryRun)
+	if err != nil {
+		return err
+	}
+
+	r.total.Inserted += restored.Inserted
+	r.total.Duplicates += restored.Duplicates
+	r.total.Deleted += restored.Deleted
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFile is the format of the routing rules file.
+type rulesFile struct {
+	Rules []ruleSpec `yaml:"rules"`
+}
+
+// ruleSpec is a routing rule of the rules file.
+type ruleSpec struct {
+	Name    string              `yaml:"name"`
+	Kinds   []int               `yaml:"kinds"`
+	Authors []string            `yaml:"authors"`
+	Tags    map[string][]string `yaml:"tags"`   // e.g. {t: [bitcoin]}, the event must have one of the values of every tag
+	Action  string              `yaml:"action"` // forward, webhook, drop or label
+	Target  string              `yaml:"target"` // relay URL, webhook URL, reason or label
+}
+
+// loadRules returns the routing rules of the file.
+func loadRules(path string) ([]rely.Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the rules: %w", err)
+	}
+
+	var file rulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse the rules: %w", err)
+	}
+
+	rules := make([]rely.Rule, len(file.Rules))
+	for i, spec := range file.Rules {
+		for _, author := range spec.Authors {
+			if !nostr.IsValid32ByteHex(author) {
+				return nil, fmt.Errorf("rule %d (%s): invalid author %q", i+1, spec.Name, author)
+			}
+		}
+
+		filter := nostr.Filter{Kinds: spec.Kinds, Authors: spec.Authors}
+		if len(spec.Tags) > 0 {
+			filter.Tags = nostr.TagMap(spec.Tags)
+		}
+
+		name := spec.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		rules[i] = rely.Rule{Name: name, Filter: filter, Action: rely.RuleAction(spec.Action), Target: spec.Target}
+	}
+	return rules, nil
+}
+
+// watchRules reloads the rules of the router when their file changes, checked every interval
+// (if positive), and on SIGHUP, until the context is cancelled. Invalid rules are logged,
+// and the previous ones kept.
+func watchRules(ctx context.Context, router *rely.Router, path string, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	modified := modTime(path)
+	reload := func() {
+		rules, err := loadRules(path)
+		if err == nil {
+			err = router.SetRules(rules)
+		}
+
+		if err != nil {
+			log.Printf("Failed to reload the routing rules, keeping the previous ones: %v", err)
+			return
+		}
+		log.Printf("Reloaded %d routing rules from %s", len(rules), path)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hangup:
+			modified = modTime(path)
+			reload()
+
+		case <-tick:
+			if latest := modTime(path); !latest.Equal(modified) {
+				modified = latest
+				reload()
+			}
+		}
+	}
+}
+
+// modTime returns the modification time of the file, or the zero time if it can't be read.
+func modTime(path string) time.Time {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
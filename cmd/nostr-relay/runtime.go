@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+)
+
+// configureRuntime sets GOMAXPROCS and the soft memory limit from the configuration,
+// or from the cgroup limits of the container when they are not configured.
+// The GOMAXPROCS and GOMEMLIMIT environment variables take precedence over both.
+func configureRuntime(cfg config.RuntimeConfig) {
+	if os.Getenv("GOMAXPROCS") == "" {
+		procs := cfg.MaxProcs
+		if procs == 0 {
+			if quota, ok := cgroupCPUQuota(); ok {
+				procs = max(1, int(math.Ceil(quota)))
+			}
+		}
+
+		if procs > 0 && procs != runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(procs)
+			log.Printf("GOMAXPROCS set to %d", procs)
+		}
+	}
+
+	if os.Getenv("GOMEMLIMIT") == "" {
+		limit := cfg.MemoryLimit
+		if limit == 0 {
+			if memory, ok := cgroupMemoryLimit(); ok {
+				limit = int64(float64(memory) * cfg.MemoryLimitRatio)
+			}
+		}
+
+		if limit > 0 {
+			debug.SetMemoryLimit(limit)
+			log.Printf("Memory limit set to %.2f MB", float64(limit)/(1<<20))
+		}
+	}
+}
+
+// cgroupCPUQuota returns the number of CPUs the container is allowed to use,
+// reading the cgroup v2 cpu.max or the cgroup v1 CFS quota.
+func cgroupCPUQuota() (float64, bool) {
+	if data, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return ratio(fields[0], fields[1])
+	}
+
+	quota, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return 0, false
+	}
+
+	period, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return 0, false
+	}
+	return ratio(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func ratio(quota, period string) (float64, bool) {
+	q, err1 := strconv.ParseFloat(quota, 64)
+	p, err2 := strconv.ParseFloat(period, 64)
+	if err := errors.Join(err1, err2); err != nil || q <= 0 || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// cgroupMemoryLimit returns the memory limit of the container in bytes,
+// reading the cgroup v2 memory.max or the cgroup v1 memory.limit_in_bytes.
+func cgroupMemoryLimit() (int64, bool) {
+	data, err := os.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		data, err = os.ReadFile("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+		if err != nil {
+			return 0, false
+		}
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, false
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 || limit >= 1<<60 {
+		// cgroup v1 reports a huge number when unlimited
+		return 0, false
+	}
+	return limit, true
+}
+
+// runtimeMetrics exposes the Go runtime memory and GC statistics.
+func runtimeMetrics(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	goroutinesMetric.write(w, float64(runtime.NumGoroutine()))
+	maxProcsMetric.write(w, float64(runtime.GOMAXPROCS(0)))
+	memoryLimitMetric.write(w, float64(debug.SetMemoryLimit(-1)))
+	heapAllocMetric.write(w, float64(stats.HeapAlloc))
+	heapInuseMetric.write(w, float64(stats.HeapInuse))
+	sysMetric.write(w, float64(stats.Sys))
+	gcMetric.write(w, float64(stats.NumGC))
+	gcPauseMetric.write(w, float64(stats.PauseTotalNs)/1e9)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errUnknownJob = errors.New("unknown job")
+	errJobRunning = errors.New("the job is already running")
+)
+
+// job is a task run periodically by the [scheduler].
+type job struct {
+	name     string
+	interval time.Duration
+	jitter   time.Duration // random delay added to every interval, so that instances don't run jobs in lockstep
+	run      func(ctx context.Context) error
+}
+
+// jobRun is a run of a job, kept in its history.
+type jobRun struct {
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Manual   bool      `json:"manual"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// jobStatus is the state of a job, served by the management endpoint.
+type jobStatus struct {
+	Name     string   `json:"name"`
+	Interval string   `json:"interval"`
+	Running  bool     `json:"running"`
+	History  []jobRun `json:"history"` // most recent first
+}
+
+type scheduledJob struct {
+	job
+	running atomic.Bool
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	history []jobRun
+}
+
+// scheduler is a minimal cron-like scheduler of the background jobs (e.g. the analytics refresh),
+// which records their recent runs and allows to trigger them manually.
+// Runs of the same job never overlap.
+type scheduler struct {
+	jobs    map[string]*scheduledJob
+	history int
+}
+
+// newScheduler returns a scheduler keeping the last runs of each job in their history.
+func newScheduler(history int) *scheduler {
+	return &scheduler{jobs: make(map[string]*scheduledJob), history: history}
+}
+
+// add the job to the scheduler. It must be called before [scheduler.Run].
+func (s *scheduler) add(j job) {
+	s.jobs[j.name] = &scheduledJob{job: j, trigger: make(chan struct{}, 1)}
+	log.Printf("Scheduled job %s (every %s)", j.name, j.interval)
+}
+
+// Run the jobs at their intervals, and when triggered, until the context is cancelled.
+func (s *scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *scheduler) loop(ctx context.Context, j *scheduledJob) {
+	timer := time.NewTimer(j.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			s.execute(ctx, j, false)
+			timer.Reset(j.next())
+
+		case <-j.trigger:
+			s.execute(ctx, j, true)
+		}
+	}
+}
+
+// next returns the delay until the next run of the job.
+func (j *scheduledJob) next() time.Duration {
+	if j.jitter <= 0 {
+		return j.interval
+	}
+	return j.interval + rand.N(j.jitter)
+}
+
+func (s *scheduler) execute(ctx context.Context, j *scheduledJob, manual bool) {
+	if !j.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer j.running.Store(false)
+
+	start := time.Now()
+	err := j.run(ctx)
+
+	run := jobRun{Started: start, Duration: time.Since(start).String(), Manual: manual}
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Job %s failed: %v", j.name, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.history = slices.Insert(j.history, 0, run)
+	if len(j.history) > s.history {
+		j.history = j.history[:s.history]
+	}
+}
+
+// trigger a run of the job, without waiting for it.
+func (s *scheduler) trigger(name string) error {
+	j, ok := s.jobs[name]
+	if !ok {
+		return errUnknownJob
+	}
+
+	if j.running.Load() {
+		return errJobRunning
+	}
+
+	select {
+	case j.trigger <- struct{}{}:
+		return nil
+	default:
+		return errJobRunning
+	}
+}
+
+// status returns the state of the jobs, sorted by name.
+func (s *scheduler) status() []jobStatus {
+	status := make([]jobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		status = append(status, jobStatus{
+			Name:     j.name,
+			Interval: j.interval.String(),
+			Running:  j.running.Load(),
+			History:  slices.Clone(j.history),
+		})
+		j.mu.Unlock()
+	}
+
+	slices.SortFunc(status, func(a, b jobStatus) int { return cmp.Compare(a.Name, b.Name) })
+	return status
+}
+
+// registerJobs serves the management API of the scheduled jobs, requiring the management token:
+// GET /jobs lists the jobs with their recent runs, and POST /jobs/{name}/run triggers one.
+func registerJobs(mux *http.ServeMux, token string, s *scheduler) {
+	mux.Handle("GET /jobs", requireManagement(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.status())
+	})))
+
+	mux.Handle("POST /jobs/{name}/run", requireManagement(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch err := s.trigger(r.PathValue("name")); {
+		case errors.Is(err, errUnknownJob):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/cmd/nostr-relay/config"
+)
+
+// ANSI escape codes of the tail output.
+const (
+	colorReset   = "\033[0m"
+	colorDim     = "\033[2m"
+	colorRed     = "\033[31m"
+	colorGreen   = "\033[32m"
+	colorYellow  = "\033[33m"
+	colorBlue    = "\033[34m"
+	colorMagenta = "\033[35m"
+	colorCyan    = "\033[36m"
+)
+
+// kindColors are the colors of the common kinds; the others are colored by their number.
+var kindColors = map[int]string{
+	nostr.KindProfileMetadata: colorCyan,
+	nostr.KindTextNote:        colorGreen,
+	nostr.KindFollowList:      colorBlue,
+	nostr.KindDeletion:        colorRed,
+	nostr.KindRepost:          colorMagenta,
+	nostr.KindReaction:        colorMagenta,
+	nostr.KindZap:             colorYellow,
+}
+
+var kindPalette = []string{colorCyan, colorGreen, colorBlue, colorMagenta, colorYellow}
+
+// runTail implements the tail command, which subscribes to the relay and prints the new events
+// matching the flags as they arrive, like tcpdump for the relay.
+func runTail(args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	url := flags.String("url", "", "websocket URL of the relay (default the server.listen address of the configuration)")
+	kinds := flags.String("kinds", "", "comma-separated kinds of the events to print (default all)")
+	authors := flags.String("authors", "", "comma-separated hex pubkeys of the authors of the events to print (default all)")
+	width := flags.Int("width", 120, "maximum characters of content printed per event (0 prints it all)")
+	noColor := flags.Bool("no-color", false, "disable the colors, which are also disabled when the output is not a terminal or NO_COLOR is set")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: nostr-relay tail [flags]\n\n")
+		fmt.Fprintf(flags.Output(), "Prints the new events of the relay matching the flags in real time, until interrupted.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	filter := nostr.Filter{LimitZero: true} // only the new events
+	if *kinds != "" {
+		for _, k := range strings.Split(*kinds, ",") {
+			kind, err := strconv.Atoi(strings.TrimSpace(k))
+			if err != nil || kind < 0 || kind > 65535 {
+				return fmt.Errorf("invalid kind %q", k)
+			}
+			filter.Kinds = append(filter.Kinds, kind)
+		}
+	}
+	if *authors != "" {
+		for _, pk := range strings.Split(*authors, ",") {
+			pk = strings.TrimSpace(pk)
+			if !nostr.IsValid32ByteHex(pk) {
+				return fmt.Errorf("invalid author %q: the pubkey must be in hex", pk)
+			}
+			filter.Authors = append(filter.Authors, pk)
+		}
+	}
+
+	if *url == "" {
+		*url = localURL()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, *url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", *url, err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	req, err := json.Marshal(nostr.ReqEnvelope{SubscriptionID: "tail", Filters: nostr.Filters{filter}})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(ws.TextMessage, req); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	p := &tailPrinter{
+		out:   os.Stdout,
+		width: *width,
+		color: !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+	}
+
+	fmt.Fprintf(os.Stderr, "tailing %s (press Ctrl+C to stop)\n", *url)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("connection lost: %w", err)
+		}
+
+		switch envelope := nostr.ParseMessage(string(data)).(type) {
+		case *nostr.EventEnvelope:
+			p.print(&envelope.Event)
+
+		case *nostr.ClosedEnvelope:
+			return fmt.Errorf("the relay closed the subscription: %s", envelope.Reason)
+
+		case *nostr.NoticeEnvelope:
+			fmt.Fprintf(os.Stderr, "NOTICE: %s\n", string(*envelope))
+		}
+	}
+}
+
+// localURL returns the websocket URL of the relay of the configuration, falling back to the default address.
+func localURL() string {
+	listen := config.Default().Server.Listen
+	if cfg, err := config.Load(); err == nil && cfg.Server.Listen != "" {
+		listen = cfg.Server.Listen
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "ws://" + listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "ws://" + net.JoinHostPort(host, port)
+}
+
+// isTerminal returns whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// tailPrinter prints the events on a single line each: time, kind, author and content.
+type tailPrinter struct {
+	out   io.Writer
+	width int
+	color bool
+}
+
+func (p *tailPrinter) print(e *nostr.Event) {
+	content := strings.Join(strings.Fields(e.Content), " ")
+	if runes := []rune(content); p.width > 0 && len(runes) > p.width {
+		content = string(runes[:p.width]) + "…"
+	}
+
+	created := e.CreatedAt.Time().Format(time.TimeOnly)
+	kind := fmt.Sprintf("%5d", e.Kind)
+	author := shortKey(e.PubKey)
+	if p.color {
+		created = colorDim + created + colorReset
+		kind = kindColor(e.Kind) + kind + colorReset
+		author = colorDim + author + colorReset
+	}
+	fmt.Fprintf(p.out, "%s %s %s %s\n", created, kind, author, content)
+}
+
+// kindColor returns the color of the kind.
+func kindColor(kind int) string {
+	if color, ok := kindColors[kind]; ok {
+		return color
+	}
+	return kindPalette[kind%len(kindPalette)]
+}
+
+// shortKey abbreviates the hex key to its first and last characters.
+func shortKey(key string) string {
+	if len(key) <= 16 {
+		return key
+	}
+	return key[:8] + "…" + key[len(key)-4:]
+}
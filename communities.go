@@ -0,0 +1,272 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The kinds of the NIP-72 moderated communities.
+// See https://github.com/nostr-protocol/nips/blob/master/72.md
+const (
+	KindCommunity         = 34550
+	KindCommunityApproval = 4550
+)
+
+var ErrNotModerator = fmt.Errorf("%w: only the moderators of the community can approve its posts", ErrRestricted)
+
+// CommunitiesConfig configures the [Communities].
+type CommunitiesConfig struct {
+	// HideUnapproved removes from the community feeds (the REQs with "#a" tags of communities) the posts
+	// that no moderator approved, unless the client moderates the feed itself by requesting the approvals
+	// in the same REQ, or it's authenticated as a moderator of the community. The feeds of the communities
+	// whose definition is not stored are served unchanged, since their approvals can't be verified.
+	HideUnapproved bool
+
+	// VerifyApprovals rejects the approvals whose author is not a moderator of the community,
+	// when its definition is stored.
+	VerifyApprovals bool
+}
+
+// DefaultCommunitiesConfig returns a [CommunitiesConfig] verifying the approvals, without hiding the posts.
+func DefaultCommunitiesConfig() CommunitiesConfig {
+	return CommunitiesConfig{VerifyApprovals: true}
+}
+
+// Communities implements the relay-side behavior of the NIP-72 moderated communities: it validates
+// the community definitions (kind 34550) and the approvals of their posts (kind 4550), and optionally
+// serves only the approved posts in the community feeds, as the clients of community-focused relays expect.
+// The definitions and the approvals are looked up with the query, typically the On.Req hook of the storage.
+//
+// Example:
+//
+//	communities, err := NewCommunities(storage.QueryEvents, CommunitiesConfig{HideUnapproved: true, VerifyApprovals: true})
+//	relay.Reject.Event = append(relay.Reject.Event, communities.RejectEvent)
+//	relay.On.Req = communities.Query(relay.On.Req)
+type Communities struct {
+	config CommunitiesConfig
+	query  func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)
+}
+
+// NewCommunities returns [Communities] looking up the definitions and approvals with the query,
+// or an error if the query is nil.
+func NewCommunities(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error), config CommunitiesConfig) (*Communities, error) {
+	if query == nil {
+		return nil, errors.New("the communities need a query to look up the definitions and approvals")
+	}
+	return &Communities{config: config, query: query}, nil
+}
+
+// RejectEvent is a Reject.Event hook rejecting the invalid community definitions and approvals.
+func (cm *Communities) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	switch e.Kind {
+	case KindCommunity:
+		if e.Tags.GetD() == "" {
+			return fmt.Errorf("%w: the community must have a d tag", ErrInvalid)
+		}
+		return nil
+
+	case KindCommunityApproval:
+		community, ok := communityOf(e)
+		if !ok {
+			return fmt.Errorf("%w: the approval must have the a tag of a community", ErrInvalid)
+		}
+		if e.Tags.Find("e") == nil && !slices.ContainsFunc(e.Tags, func(t nostr.Tag) bool {
+			return len(t) > 1 && t[0] == "a" && !isCommunity(t[1])
+		}) {
+			return fmt.Errorf("%w: the approval must have the e or a tag of the post", ErrInvalid)
+		}
+
+		if !cm.config.VerifyApprovals {
+			return nil
+		}
+
+		moderators, err := cm.moderators(ctx, c, []string{community})
+		if err != nil {
+			return fmt.Errorf("%w: failed to look up the community: %v", ErrError, err)
+		}
+		if mods, ok := moderators[community]; ok && !slices.Contains(mods, e.PubKey) {
+			return ErrNotModerator
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+// Query wraps the On.Req hook, removing the unapproved posts from the community feeds
+// if [CommunitiesConfig.HideUnapproved] is set.
+func (cm *Communities) Query(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)) func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+	return func(ctx context.Context, c Client, filters nostr.Filters) ([]nostr.Event, error) {
+		events, err := query(ctx, c, filters)
+		if err != nil || !cm.config.HideUnapproved || len(events) == 0 {
+			return events, err
+		}
+
+		feeds := communityFeeds(filters)
+		if len(feeds) == 0 {
+			return events, nil
+		}
+		return cm.approved(ctx, c, feeds, events)
+	}
+}
+
+// approved returns the events that are not posts of the feeds, or that a moderator of their community approved.
+func (cm *Communities) approved(ctx context.Context, c Client, feeds []string, events []nostr.Event) ([]nostr.Event, error) {
+	moderators, err := cm.moderators(ctx, c, feeds)
+	if err != nil {
+		return nil, err
+	}
+
+	// the approvals of the communities without a stored definition can't be verified, and
+	// the moderators see the posts waiting for their approval
+	var hidden []string
+	for _, feed := range feeds {
+		if mods, ok := moderators[feed]; ok && !slices.Contains(mods, c.Pubkey()) {
+			hidden = append(hidden, feed)
+		}
+	}
+	if len(hidden) == 0 {
+		return events, nil
+	}
+
+	var ids, addresses []string
+	for i := range events {
+		if communityPost(&events[i], hidden) {
+			ids = append(ids, events[i].ID)
+			if a := address(&events[i]); a != "" {
+				addresses = append(addresses, a)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return events, nil
+	}
+
+	filters := nostr.Filters{{Kinds: []int{KindCommunityApproval}, Tags: nostr.TagMap{"e": ids}, Limit: 10 * len(ids)}}
+	if len(addresses) > 0 {
+		filters = append(filters, nostr.Filter{Kinds: []int{KindCommunityApproval}, Tags: nostr.TagMap{"a": addresses}, Limit: 10 * len(addresses)})
+	}
+
+	approvals, err := cm.query(ctx, c, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the approvals: %w", err)
+	}
+
+	// the approved posts and addresses, by community
+	approved := make(map[string]bool)
+	for _, approval := range approvals {
+		community, ok := communityOf(&approval)
+		if !ok || !slices.Contains(moderators[community], approval.PubKey) {
+			continue
+		}
+
+		for _, tag := range approval.Tags {
+			if len(tag) > 1 && (tag[0] == "e" || tag[0] == "a") {
+				approved[community+" "+tag[1]] = true
+			}
+		}
+	}
+
+	return slices.DeleteFunc(events, func(e nostr.Event) bool {
+		if !communityPost(&e, hidden) {
+			return false
+		}
+
+		for _, tag := range e.Tags {
+			if len(tag) > 1 && tag[0] == "a" && slices.Contains(hidden, tag[1]) &&
+				(approved[tag[1]+" "+e.ID] || approved[tag[1]+" "+address(&e)]) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// communityPost reports whether the event is a post of any of the communities.
+func communityPost(e *nostr.Event, communities []string) bool {
+	if e.Kind == KindCommunity || e.Kind == KindCommunityApproval {
+		return false
+	}
+	return slices.ContainsFunc(e.Tags, func(t nostr.Tag) bool {
+		return len(t) > 1 && t[0] == "a" && slices.Contains(communities, t[1])
+	})
+}
+
+// moderators returns the moderators of the communities whose definition is stored, including their owner.
+func (cm *Communities) moderators(ctx context.Context, c Client, communities []string) (map[string][]string, error) {
+	filters := make(nostr.Filters, 0, len(communities))
+	for _, community := range communities {
+		_, pubkey, d, _ := parseAddress(community)
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{KindCommunity},
+			Authors: []string{pubkey},
+			Tags:    nostr.TagMap{"d": {d}},
+			Limit:   1,
+		})
+	}
+
+	definitions, err := cm.query(ctx, c, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// the latest definition of each community
+	latest := make(map[string]*nostr.Event)
+	for i := range definitions {
+		a := address(&definitions[i])
+		if current, ok := latest[a]; !ok || definitions[i].CreatedAt > current.CreatedAt {
+			latest[a] = &definitions[i]
+		}
+	}
+
+	moderators := make(map[string][]string, len(latest))
+	for a, definition := range latest {
+		mods := []string{definition.PubKey}
+		for _, tag := range definition.Tags {
+			if len(tag) > 3 && tag[0] == "p" && tag[3] == "moderator" {
+				mods = append(mods, tag[1])
+			}
+		}
+		moderators[a] = mods
+	}
+	return moderators, nil
+}
+
+// communityFeeds returns the communities of the "#a" tags of the filters, unless a filter requests
+// the approvals, in which case the client moderates the feeds itself.
+func communityFeeds(filters nostr.Filters) []string {
+	var feeds []string
+	for _, filter := range filters {
+		if slices.Contains(filter.Kinds, KindCommunityApproval) {
+			return nil
+		}
+
+		for _, a := range filter.Tags["a"] {
+			if isCommunity(a) && !slices.Contains(feeds, a) {
+				feeds = append(feeds, a)
+			}
+		}
+	}
+	return feeds
+}
+
+// communityOf returns the community of the first "a" tag of the event referencing one.
+func communityOf(e *nostr.Event) (string, bool) {
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == "a" && isCommunity(tag[1]) {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// isCommunity reports whether the address is the one of a community definition.
+func isCommunity(address string) bool {
+	kind, _, _, ok := parseAddress(address)
+	return ok && kind == KindCommunity
+}
@@ -0,0 +1,89 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryQuery returns a query of the events matching the filters.
+func memoryQuery(events ...nostr.Event) func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+	return func(_ context.Context, _ Client, filters nostr.Filters) ([]nostr.Event, error) {
+		var matched []nostr.Event
+		for _, e := range events {
+			if filters.Match(&e) {
+				matched = append(matched, e)
+			}
+		}
+		return matched, nil
+	}
+}
+
+func TestCommunities(t *testing.T) {
+	owner := nostr.GeneratePrivateKey()
+	ownerPK, _ := nostr.GetPublicKey(owner)
+	moderatorPK, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	userPK, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	community := "34550:" + ownerPK + ":rust"
+	definition := nostr.Event{
+		Kind:   KindCommunity,
+		PubKey: ownerPK,
+		Tags:   nostr.Tags{{"d", "rust"}, {"p", moderatorPK, "", "moderator"}},
+	}
+
+	approvedPost := nostr.Event{ID: "approved", Kind: 1111, PubKey: userPK, Tags: nostr.Tags{{"a", community}}}
+	pendingPost := nostr.Event{ID: "pending", Kind: 1111, PubKey: userPK, Tags: nostr.Tags{{"a", community}}}
+	fakeApproval := nostr.Event{Kind: KindCommunityApproval, PubKey: userPK, Tags: nostr.Tags{{"a", community}, {"e", "pending"}}}
+	approval := nostr.Event{Kind: KindCommunityApproval, PubKey: moderatorPK, Tags: nostr.Tags{{"a", community}, {"e", "approved"}}}
+
+	communities, err := NewCommunities(
+		memoryQuery(definition, approvedPost, pendingPost, approval, fakeApproval),
+		CommunitiesConfig{HideUnapproved: true, VerifyApprovals: true},
+	)
+	if err != nil {
+		t.Fatalf("failed to create the communities: %v", err)
+	}
+
+	t.Run("approvals", func(t *testing.T) {
+		ctx := context.Background()
+		if err := communities.RejectEvent(ctx, nil, &approval); err != nil {
+			t.Errorf("expected the approval of the moderator to be accepted, got %v", err)
+		}
+		if err := communities.RejectEvent(ctx, nil, &fakeApproval); !errors.Is(err, ErrNotModerator) {
+			t.Errorf("expected %v, got %v", ErrNotModerator, err)
+		}
+		if err := communities.RejectEvent(ctx, nil, &nostr.Event{Kind: KindCommunityApproval, Tags: nostr.Tags{{"e", "approved"}}}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected the approval without community to be invalid, got %v", err)
+		}
+		if err := communities.RejectEvent(ctx, nil, &nostr.Event{Kind: KindCommunity}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected the community without d tag to be invalid, got %v", err)
+		}
+	})
+
+	t.Run("feed", func(t *testing.T) {
+		query := communities.Query(memoryQuery(approvedPost, pendingPost))
+		feed := nostr.Filters{{Kinds: []int{1111}, Tags: nostr.TagMap{"a": {community}}}}
+
+		events, err := query(context.Background(), &client{pubkey: userPK}, feed)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(events) != 1 || events[0].ID != "approved" {
+			t.Fatalf("expected only the approved post, got %v", events)
+		}
+
+		events, _ = query(context.Background(), &client{pubkey: moderatorPK}, feed)
+		if len(events) != 2 {
+			t.Fatalf("expected the moderator to see the pending post, got %d events", len(events))
+		}
+
+		withApprovals := append(feed, nostr.Filter{Kinds: []int{KindCommunityApproval}, Tags: nostr.TagMap{"a": {community}}})
+		events, _ = query(context.Background(), &client{}, withApprovals)
+		if len(events) != 2 {
+			t.Fatalf("expected the client requesting the approvals to see all the posts, got %d events", len(events))
+		}
+	})
+}
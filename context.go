@@ -0,0 +1,50 @@
+package rely
+
+import (
+	"context"
+	"strconv"
+)
+
+type (
+	traceKey        struct{}
+	subscriptionKey struct{}
+)
+
+// TraceID returns the ID of the client message being handled, set in the context passed to the hooks,
+// or an empty string if the context is not one of a message. It's unique within the relay's lifetime,
+// which makes it useful to correlate the logs of the hooks, storage calls and webhooks of a message.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// SubscriptionID returns the ID of the subscription of the REQ being handled, set in the context passed to
+// the Reject.Req and On.Req hooks, or an empty string if the context is not one of a REQ. A REQ with the ID
+// of an open subscription replaces it.
+func SubscriptionID(ctx context.Context) string {
+	id, _ := ctx.Value(subscriptionKey{}).(string)
+	return id
+}
+
+// messageContext returns the context of a new message of the client, which is cancelled when the client
+// disconnects and carries the [TraceID] of the message.
+func (c *client) messageContext() context.Context {
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithValue(parent, traceKey{}, join(c.uid, strconv.FormatInt(c.messagesReceived.Load(), 10)))
+}
+
+// hookContext returns the context passed to the hooks, derived from the message's context
+// with the relay's hook timeout (if any). See [WithHookTimeout].
+func (r *Relay) hookContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if r.hookTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.hookTimeout)
+}
@@ -0,0 +1,109 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHookContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	traces := make(chan string, 1)
+	subscriptions := make(chan string, 1)
+	cancelled := make(chan error, 1)
+
+	relay := NewRelay(WithDomain("example.com"))
+	relay.On.Req = func(ctx context.Context, c Client, f nostr.Filters) ([]nostr.Event, error) {
+		traces <- TraceID(ctx)
+		subscriptions <- SubscriptionID(ctx)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "sub", Filters: nostr.Filters{{Kinds: []int{1}}}})
+
+	select {
+	case trace := <-traces:
+		if trace == "" {
+			t.Fatal("expected the context to carry a trace ID")
+		}
+		if id := <-subscriptions; id != "sub" {
+			t.Fatalf("expected the context to carry the subscription ID, got %q", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the On.Req hook was not invoked")
+	}
+
+	// the client disconnects mid-request
+	conn.Close()
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the context was not cancelled when the client disconnected")
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"), WithHookTimeout(50*time.Millisecond))
+	relay.On.Req = func(ctx context.Context, c Client, f nostr.Filters) ([]nostr.Event, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "sub", Filters: nostr.Filters{{Kinds: []int{1}}}})
+	label, msg := readMessage(t, conn)
+	if label != "CLOSED" || string(msg[2]) != `"error: context deadline exceeded"` {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	if id := TraceID(context.Background()); id != "" {
+		t.Fatalf("expected no trace ID, got %q", id)
+	}
+
+	c := &client{uid: "client"}
+	first := TraceID(c.messageContext())
+	c.messagesReceived.Add(1)
+	second := TraceID(c.messageContext())
+
+	if first == "" || first == second {
+		t.Fatalf("expected distinct trace IDs, got %q and %q", first, second)
+	}
+}
@@ -0,0 +1,179 @@
+package rely
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestTokenAuth(t *testing.T) {
+	auth, err := NewTokenAuth("", map[string]string{"s3cr3t": pk})
+	if err != nil {
+		t.Fatalf("failed to create token auth: %v", err)
+	}
+
+	tests := []struct {
+		url    string
+		pubkey string
+		err    error
+	}{
+		{url: "/", err: ErrNoCredentials},
+		{url: "/?token=s3cr3t", pubkey: pk},
+		{url: "/?token=wrong", err: ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		pubkey, err := auth.Authenticate(httptest.NewRequest(http.MethodGet, test.url, nil))
+		if pubkey != test.pubkey || err != test.err {
+			t.Errorf("%s: expected (%q, %v), got (%q, %v)", test.url, test.pubkey, test.err, pubkey, err)
+		}
+	}
+
+	if _, err := NewTokenAuth("token", map[string]string{"s3cr3t": "invalid"}); err == nil {
+		t.Error("expected an error for the invalid pubkey")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	auth, err := NewBasicAuth("", map[string]BasicUser{"alice": {Password: "s3cr3t", Pubkey: pk}})
+	if err != nil {
+		t.Fatalf("failed to create basic auth: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		pubkey   string
+		err      error
+	}{
+		{name: "no credentials", err: ErrNoCredentials},
+		{name: "valid", user: "alice", password: "s3cr3t", pubkey: pk},
+		{name: "wrong password", user: "alice", password: "wrong", err: ErrInvalidCredentials},
+		{name: "unknown user", user: "bob", password: "s3cr3t", err: ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+
+			pubkey, err := auth.Authenticate(request)
+			if pubkey != test.pubkey || err != test.err {
+				t.Errorf("expected (%q, %v), got (%q, %v)", test.pubkey, test.err, pubkey, err)
+			}
+		})
+	}
+}
+
+func TestCertAuth(t *testing.T) {
+	auth, err := NewCertAuth(map[string]string{"alice": pk})
+	if err != nil {
+		t.Fatalf("failed to create cert auth: %v", err)
+	}
+
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	bob := &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}}
+
+	tests := []struct {
+		name   string
+		state  *tls.ConnectionState
+		pubkey string
+		err    error
+	}{
+		{name: "plain http", err: ErrNoCredentials},
+		{name: "no certificate", state: &tls.ConnectionState{}, err: ErrNoCredentials},
+		{name: "unverified", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{alice}}, err: ErrInvalidCredentials},
+		{name: "verified", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{alice}, VerifiedChains: [][]*x509.Certificate{{alice}}}, pubkey: pk},
+		{name: "unknown", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bob}, VerifiedChains: [][]*x509.Certificate{{bob}}}, err: ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.TLS = test.state
+
+			pubkey, err := auth.Authenticate(request)
+			if pubkey != test.pubkey || err != test.err {
+				t.Errorf("expected (%q, %v), got (%q, %v)", test.pubkey, test.err, pubkey, err)
+			}
+		})
+	}
+}
+
+func TestWithAuthenticators(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	auth, err := NewTokenAuth("token", map[string]string{"s3cr3t": pk})
+	if err != nil {
+		t.Fatalf("failed to create token auth: %v", err)
+	}
+
+	connected := make(chan string, 1)
+	relay := NewRelay(WithAuthenticators(true, auth))
+	relay.On.Connect = func(c Client) { connected <- c.Pubkey() }
+
+	authed := make(chan string, 1)
+	relay.On.Auth = func(c Client) { authed <- c.Pubkey() }
+
+	upgraded := make(chan string, 3)
+	relay.Reject.Connection = append(relay.Reject.Connection, func(_ Stats, r *http.Request) error {
+		upgraded <- UpgradePubkey(r)
+		return nil
+	})
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, query := range []string{"", "?token=wrong"} {
+		_, response, err := ws.DefaultDialer.DialContext(ctx, url+query, nil)
+		if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%q: expected the upgrade to be refused with 401, got %v", query, err)
+		}
+	}
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url+"?token=s3cr3t", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case pubkey := <-connected:
+		if pubkey != pk {
+			t.Errorf("expected the client to be authenticated as %s, got %q", pk, pubkey)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the client was not registered")
+	}
+
+	// the hooks of the authenticated clients run for the upgrade credentials too
+	select {
+	case pubkey := <-authed:
+		if pubkey != pk {
+			t.Errorf("expected the Auth hook with %s, got %q", pk, pubkey)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the Auth hook didn't run")
+	}
+
+	// the Reject.Connection hooks know the pubkey of the credentials before the upgrade
+	for _, expected := range []string{"", "", pk} {
+		if pubkey := <-upgraded; pubkey != expected {
+			t.Errorf("expected the upgrade pubkey %q, got %q", expected, pubkey)
+		}
+	}
+}
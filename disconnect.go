@@ -0,0 +1,102 @@
+package rely
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	ws "github.com/gorilla/websocket"
+)
+
+var ErrTooManyInvalid = fmt.Errorf("%w: too many invalid messages", ErrInvalid)
+
+// DisconnectReason is why a client was disconnected, passed to the On.Disconnect hook
+// together with the error that caused it (if any), to distinguish the causes of churn.
+type DisconnectReason int
+
+const (
+	// DisconnectClosed means the client closed the connection. The error is the [websocket.CloseError]
+	// with the close code sent by the client (1006 if it closed it without a close message).
+	DisconnectClosed DisconnectReason = iota
+
+	// DisconnectIdle means the client stopped answering the pings within the pong wait (see [WithPongWait]).
+	DisconnectIdle
+
+	// DisconnectKicked means the relay disconnected the client, for example with [Client.Disconnect] from
+	// a policy, or because it sent too many invalid messages ([ErrTooManyInvalid]).
+	DisconnectKicked
+
+	// DisconnectError means reading from or writing to the connection failed, for example because
+	// the message was too big or the connection was reset.
+	DisconnectError
+
+	// DisconnectShutdown means the relay is shutting down. The error is [ErrShuttingDown].
+	DisconnectShutdown
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClosed:
+		return "closed"
+	case DisconnectIdle:
+		return "idle"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectError:
+		return "error"
+	case DisconnectShutdown:
+		return "shutdown"
+	default:
+		return fmt.Sprintf("DisconnectReason(%d)", int(r))
+	}
+}
+
+// disconnection records why the client was disconnected.
+type disconnection struct {
+	reason DisconnectReason
+	err    error
+}
+
+// setDisconnection records why the client is being disconnected, unless it was already recorded:
+// the first cause wins, since the others (e.g. failed writes on a closed connection) are its consequences.
+func (c *client) setDisconnection(reason DisconnectReason, err error) {
+	c.disconnection.CompareAndSwap(nil, &disconnection{reason: reason, err: err})
+}
+
+func (c *client) Disconnect() { c.disconnectWith(DisconnectKicked, nil) }
+
+// disconnectWith records why the client is being disconnected, and disconnects it.
+func (c *client) disconnectWith(reason DisconnectReason, err error) {
+	c.setDisconnection(reason, err)
+	if c.isUnregistering.CompareAndSwap(false, true) {
+		close(c.done)
+		if c.cancel != nil {
+			c.cancel()
+		}
+		c.relay.unregister <- c
+		c.CloseAllSubs()
+	}
+}
+
+// disconnected returns why the client was disconnected, and the error that caused it.
+func (c *client) disconnected() (DisconnectReason, error) {
+	d := c.disconnection.Load()
+	if d == nil {
+		return DisconnectKicked, nil
+	}
+	return d.reason, d.err
+}
+
+// readError returns the reason of the disconnection caused by the error of reading the connection.
+func readError(err error) DisconnectReason {
+	var closeErr *ws.CloseError
+	if errors.As(err, &closeErr) {
+		return DisconnectClosed
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DisconnectIdle
+	}
+	return DisconnectError
+}
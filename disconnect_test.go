@@ -0,0 +1,115 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReadError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected DisconnectReason
+	}{
+		{err: &ws.CloseError{Code: ws.CloseNormalClosure}, expected: DisconnectClosed},
+		{err: os.ErrDeadlineExceeded, expected: DisconnectIdle},
+		{err: ws.ErrReadLimit, expected: DisconnectError},
+	}
+
+	for _, test := range tests {
+		if reason := readError(test.err); reason != test.expected {
+			t.Errorf("%v: expected %s, got %s", test.err, test.expected, reason)
+		}
+	}
+}
+
+func TestDisconnectReasons(t *testing.T) {
+	type disconnection struct {
+		reason DisconnectReason
+		err    error
+	}
+
+	tests := []struct {
+		name       string
+		disconnect func(conn *ws.Conn, cancel context.CancelFunc)
+		expected   DisconnectReason
+	}{
+		{
+			name: "closed",
+			disconnect: func(conn *ws.Conn, _ context.CancelFunc) {
+				conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, "bye"))
+			},
+			expected: DisconnectClosed,
+		},
+		{
+			name: "kicked",
+			disconnect: func(conn *ws.Conn, _ context.CancelFunc) {
+				send(t, conn, nostr.ReqEnvelope{SubscriptionID: "kick", Filters: nostr.Filters{{Kinds: []int{666}}}})
+			},
+			expected: DisconnectKicked,
+		},
+		{
+			name:       "shutdown",
+			disconnect: func(_ *ws.Conn, cancel context.CancelFunc) { cancel() },
+			expected:   DisconnectShutdown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			connected := make(chan struct{}, 1)
+			disconnected := make(chan disconnection, 1)
+
+			relay := NewRelay(WithDomain("example.com"))
+			relay.Reject.Req = append(relay.Reject.Req, func(_ context.Context, c Client, f nostr.Filters) error {
+				c.Disconnect()
+				return ErrBlocked
+			})
+			relay.On.Connect = func(Client) { connected <- struct{}{} }
+			relay.On.Disconnect = func(c Client, reason DisconnectReason, err error) {
+				disconnected <- disconnection{reason: reason, err: err}
+			}
+			relay.Start(ctx)
+
+			server := httptest.NewServer(relay)
+			defer server.Close()
+			url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+			conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			select {
+			case <-connected:
+			case <-time.After(time.Second):
+				t.Fatal("the client was not registered")
+			}
+
+			test.disconnect(conn, cancel)
+
+			select {
+			case d := <-disconnected:
+				if d.reason != test.expected {
+					t.Fatalf("expected reason %s, got %s (%v)", test.expected, d.reason, d.err)
+				}
+				if d.reason == DisconnectShutdown && !errors.Is(d.err, ErrShuttingDown) {
+					t.Fatalf("expected %v, got %v", ErrShuttingDown, d.err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the On.Disconnect hook was not invoked")
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ type dispatcher struct {
 	byTag         map[string]*smallset.Ordered[sID]
 	byKind        map[int]*smallset.Ordered[sID]
 	byTime        *timeIndex
+	byAddress     map[string]*smallset.Ordered[sID] // addresses of the replaceable events followed by ID
 
 	updates   chan update
 	broadcast chan *nostr.Event
@@ -43,14 +44,16 @@ type operation int
 const (
 	index operation = iota
 	unindex
+	follow
 )
 
-// update represent either an indexing or unindexing of a subscription.
+// update represent either an indexing or unindexing of a subscription, or the indexing of
+// the new addresses it follows (see [WithReplaceableUpdates]).
 // Both operations must be placed in the same channel to serialize them.
 // For example, imagine a subscription being replaced with another (same ID, different filters).
 // The dispatcher must unindex the old, and index the new, in this order.
 type update struct {
-	operation operation // either [index] or [unindex]
+	operation operation // either [index], [unindex] or [follow]
 	sub       subscription
 }
 
@@ -62,6 +65,7 @@ func newDispatcher(relay *Relay) *dispatcher {
 		byTag:         make(map[string]*smallset.Ordered[sID], 3000),
 		byKind:        make(map[int]*smallset.Ordered[sID], 3000),
 		byTime:        newTimeIndex(600),
+		byAddress:     make(map[string]*smallset.Ordered[sID]),
 		updates:       make(chan update, 256),
 		broadcast:     make(chan *nostr.Event, 256),
 		relay:         relay,
@@ -84,6 +88,8 @@ func (d *dispatcher) Run() {
 				d.Index(update.sub)
 			case unindex:
 				d.Unindex(update.sub)
+			case follow:
+				d.Follow(update.sub)
 			}
 
 		case event := <-d.broadcast:
@@ -112,12 +118,35 @@ func (d *dispatcher) Broadcast(e *nostr.Event) error {
 		return fmt.Errorf("failed to marshal event %w", err)
 	}
 
+	addr := address(e)
 	for _, id := range candidates {
 		sub := d.subscriptions[id]
-		if sub.Matches(e) {
+		if !sub.Matches(e) && !sub.follows(addr) {
+			continue
+		}
+
+		if d.relay.On.Deliver != nil && !d.relay.On.Deliver(sub.client, sub.id, e) {
+			continue
+		}
+
+		if sub.delivered == nil {
+			response.ID = sub.id
+			sub.client.send(response)
+			continue
+		}
+
+		n := sub.delivered.Add(1)
+		if d.relay.maxSubEvents <= 0 || n <= int64(d.relay.maxSubEvents) {
 			response.ID = sub.id
 			sub.client.send(response)
 		}
+
+		if d.relay.maxSubEvents > 0 && n >= int64(d.relay.maxSubEvents) {
+			// unindexing right away, so that the subscription is no longer matched and is expired once.
+			// Closing asynchronously, because it unindexes the subscription through the dispatcher too
+			d.Unindex(sub)
+			go sub.client.expire(sub, ErrSubscriptionEvents.Error())
+		}
 	}
 	return nil
 }
@@ -138,6 +167,12 @@ func (d *dispatcher) Candidates(e *nostr.Event) []sID {
 		candidates = append(candidates, subs)
 	}
 
+	if len(d.byAddress) > 0 {
+		if subs, ok := d.byAddress[address(e)]; ok {
+			candidates = append(candidates, subs)
+		}
+	}
+
 	for _, t := range e.Tags {
 		if len(t) >= 2 && isLetter(t[0]) {
 			kv := join(t[0], t[1])
@@ -158,6 +193,7 @@ func (d *dispatcher) Clear() {
 	d.byKind = nil
 	d.byTag = nil
 	d.byTime = nil
+	d.byAddress = nil
 	d.relay.stats.subscriptions.Store(0)
 	d.relay.stats.filters.Store(0)
 }
@@ -223,6 +259,30 @@ func (d *dispatcher) Index(s subscription) {
 			d.byTime.Add(f, sid)
 		}
 	}
+
+	if s.followed != nil {
+		d.indexAddresses(sid, s.followed.items())
+	}
+}
+
+// Follow indexes the addresses followed by the subscription, if it's indexed.
+// Otherwise they are indexed along with the subscription, or it has already been unindexed.
+func (d *dispatcher) Follow(s subscription) {
+	sid := sID(s.uid)
+	if sub, ok := d.subscriptions[sid]; ok && sub.followed != nil {
+		d.indexAddresses(sid, sub.followed.items())
+	}
+}
+
+func (d *dispatcher) indexAddresses(sid sID, addresses []string) {
+	for _, a := range addresses {
+		subs, ok := d.byAddress[a]
+		if !ok {
+			d.byAddress[a] = smallset.NewFrom(sid)
+		} else {
+			subs.Add(sid)
+		}
+	}
 }
 
 // Remove the subscription from the dispatcher indexes, one filter at the time.
@@ -230,6 +290,11 @@ func (d *dispatcher) Index(s subscription) {
 // to allow the map's bucket to be reused.
 func (d *dispatcher) Unindex(s subscription) {
 	sid := sID(s.uid)
+	if _, ok := d.subscriptions[sid]; !ok {
+		// already unindexed, e.g. when it reached the max events, see [WithSubscriptionLimits]
+		return
+	}
+
 	delete(d.subscriptions, sid)
 	d.relay.stats.subscriptions.Add(-1)
 	d.relay.stats.filters.Add(-int64(len(s.filters)))
@@ -299,6 +364,20 @@ func (d *dispatcher) Unindex(s subscription) {
 			d.byTime.Remove(f, sid)
 		}
 	}
+
+	if s.followed != nil {
+		for _, a := range s.followed.items() {
+			subs, ok := d.byAddress[a]
+			if !ok {
+				continue
+			}
+
+			subs.Remove(sid)
+			if subs.IsEmpty() {
+				delete(d.byAddress, a)
+			}
+		}
+	}
 }
 
 // isLetter returns whether the string is a single letter (a-z or A-Z).
@@ -20,6 +20,7 @@ func init() {
 	for i := range testSize {
 		id := strconv.Itoa(i)
 		sub := subscription{
+			uid:     join(id, id),
 			id:      id,
 			filters: tests.RandomFilters(),
 			client:  &client{uid: id},
@@ -75,6 +76,50 @@ func TestUnindex(t *testing.T) {
 	}
 }
 
+func TestBroadcastSubscriptionEvents(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithSubscriptionLimits(0, 3))
+	client := &client{relay: relay, responses: make(chan response, 100), subs: make(map[string]subscription)}
+	if err := client.handleReq(reqRequest{id: "sub", Filters: nostr.Filters{{Kinds: []int{1}}}}); err != nil {
+		t.Fatalf("failed to handle the REQ: %v", err)
+	}
+
+	d := relay.dispatcher
+	d.Index((<-d.updates).sub)
+
+	for range 10 {
+		if err := d.Broadcast(&nostr.Event{Kind: 1}); err != nil {
+			t.Fatalf("failed to broadcast: %v", err)
+		}
+	}
+
+	if len(d.subscriptions) != 0 || relay.Subscriptions() != 0 {
+		t.Fatalf("expected the subscription to be unindexed, got %d", len(d.subscriptions))
+	}
+
+	// the unindexing by the expiration is a no-op
+	d.Unindex((<-d.updates).sub)
+	if relay.Subscriptions() != 0 || relay.Filters() != 0 {
+		t.Fatalf("expected no subscriptions and filters, got %d and %d", relay.Subscriptions(), relay.Filters())
+	}
+
+	var events, closed int
+	for range 4 {
+		switch response := (<-client.responses).(type) {
+		case rawEventResponse:
+			events++
+		case closedResponse:
+			if response.Reason != ErrSubscriptionEvents.Error() {
+				t.Fatalf("expected reason %q, got %q", ErrSubscriptionEvents, response.Reason)
+			}
+			closed++
+		}
+	}
+
+	if events != 3 || closed != 1 || len(client.responses) > 0 {
+		t.Fatalf("expected 3 events and 1 CLOSED, got %d and %d, and %d more", events, closed, len(client.responses))
+	}
+}
+
 func TestIndexingSymmetry(t *testing.T) {
 	i := newDispatcher(&Relay{})
 	for _, sub := range testSubs {
@@ -0,0 +1,223 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrDuplicateContent = fmt.Errorf("%w: the same content was posted too many times", ErrBlocked)
+
+// DuplicateConfig configures the [DuplicateDetector].
+type DuplicateConfig struct {
+	// Window is how long the content of an event is remembered.
+	Window time.Duration
+
+	// MaxRepeats is the number of near-duplicate events a pubkey can publish within the window.
+	// The next one is rejected.
+	MaxRepeats int
+
+	// MaxDistance is the maximum hamming distance between two content simhashes for them
+	// to be considered near-duplicates. Zero means only identical contents (after normalization).
+	MaxDistance int
+
+	// MinLength is the minimum content length for an event to be checked.
+	// Short contents like reactions ("+") are legitimately repeated.
+	MinLength int
+
+	// Kinds of the events to check. If empty, all kinds are checked.
+	Kinds []int
+
+	// MaxHistory is the maximum number of contents remembered per pubkey, at least MaxRepeats.
+	MaxHistory int
+}
+
+// DefaultDuplicateConfig returns a [DuplicateConfig] with sane defaults.
+func DefaultDuplicateConfig() DuplicateConfig {
+	return DuplicateConfig{
+		Window:      time.Hour,
+		MaxRepeats:  3,
+		MaxDistance: 3,
+		MinLength:   20,
+		Kinds:       []int{nostr.KindTextNote},
+		MaxHistory:  64,
+	}
+}
+
+// DuplicateDetector rejects events from pubkeys that repeatedly publish the same
+// (or nearly the same) content within a time window, a pattern that validation of
+// single events can't catch. Near-duplicates are detected comparing simhashes of the contents.
+// Only the contents of the events saved by the relay are remembered, so that the rejected ones
+// (by this or any other policy) don't count towards the repeats.
+//
+// Example:
+//
+//	detector, err := NewDuplicateDetector(DefaultDuplicateConfig())
+//	relay.Reject.Event = append(relay.Reject.Event, detector.Reject)
+//	relay.On.Event = detector.Save(relay.On.Event)
+//	go detector.Run(ctx)
+type DuplicateDetector struct {
+	config DuplicateConfig
+
+	mu      sync.Mutex
+	history map[string][]fingerprint
+}
+
+type fingerprint struct {
+	hash uint64
+	seen time.Time
+}
+
+// NewDuplicateDetector returns a [DuplicateDetector] with an empty history, or an error if the config is invalid.
+func NewDuplicateDetector(config DuplicateConfig) (*DuplicateDetector, error) {
+	if config.Window <= 0 {
+		return nil, errors.New("the window must be positive")
+	}
+
+	if config.MaxRepeats < 1 || config.MaxHistory < 1 {
+		return nil, errors.New("the max repeats and the max history must be positive")
+	}
+
+	if config.MaxHistory < config.MaxRepeats {
+		return nil, errors.New("the max history must be at least the max repeats, or the repeats are never rejected")
+	}
+
+	return &DuplicateDetector{
+		config:  config,
+		history: make(map[string][]fingerprint, 1000),
+	}, nil
+}
+
+// checks reports whether the content of the event is checked for duplicates.
+func (d *DuplicateDetector) checks(e *nostr.Event) bool {
+	if len(e.Content) < d.config.MinLength {
+		return false
+	}
+	return len(d.config.Kinds) == 0 || slices.Contains(d.config.Kinds, e.Kind)
+}
+
+// Reject is a Reject.Event hook that returns [ErrDuplicateContent] if the event's author
+// has already published MaxRepeats near-duplicates of its content within the window.
+func (d *DuplicateDetector) Reject(ctx context.Context, c Client, e *nostr.Event) error {
+	if !d.checks(e) {
+		return nil
+	}
+
+	hash := Simhash(e.Content)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	repeats := 0
+	for _, f := range expire(d.history[e.PubKey], now.Add(-d.config.Window)) {
+		if bits.OnesCount64(f.hash^hash) <= d.config.MaxDistance {
+			repeats++
+		}
+	}
+
+	if repeats >= d.config.MaxRepeats {
+		return ErrDuplicateContent
+	}
+	return nil
+}
+
+// Save wraps the On.Event hook, remembering the contents of the events it saves successfully.
+func (d *DuplicateDetector) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		if d.checks(e) {
+			d.remember(e.PubKey, Simhash(e.Content), time.Now())
+		}
+		return nil
+	}
+}
+
+// remember adds the fingerprint of a content of the pubkey to its history, forgetting the oldest if full.
+func (d *DuplicateDetector) remember(pubkey string, hash uint64, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	history := expire(d.history[pubkey], now.Add(-d.config.Window))
+	if len(history) >= d.config.MaxHistory {
+		history = history[1:]
+	}
+	d.history[pubkey] = append(history, fingerprint{hash: hash, seen: now})
+}
+
+// Run periodically removes the expired contents until the context is cancelled.
+func (d *DuplicateDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			d.prune(now.Add(-d.config.Window))
+		}
+	}
+}
+
+func (d *DuplicateDetector) prune(cutoff time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for pubkey, history := range d.history {
+		history = expire(history, cutoff)
+		if len(history) == 0 {
+			delete(d.history, pubkey)
+			continue
+		}
+		d.history[pubkey] = history
+	}
+}
+
+// expire removes the fingerprints seen before the cutoff, assuming they are sorted by time.
+func expire(history []fingerprint, cutoff time.Time) []fingerprint {
+	i := 0
+	for i < len(history) && history[i].seen.Before(cutoff) {
+		i++
+	}
+	return history[i:]
+}
+
+// Simhash returns the 64-bit simhash of the text, computed over its lowercase words.
+// Texts that differ by a few words have simhashes that differ by a few bits.
+func Simhash(text string) uint64 {
+	var weights [64]int
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		hash := h.Sum64()
+
+		for i := range weights {
+			if hash&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var simhash uint64
+	for i, w := range weights {
+		if w > 0 {
+			simhash |= 1 << i
+		}
+	}
+	return simhash
+}
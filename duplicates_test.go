@@ -0,0 +1,89 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSimhash(t *testing.T) {
+	base := "buy cheap bitcoin now at the best price on the internet, limited offer"
+	similar := "buy cheap bitcoin now at the best price on the internet, limited offers"
+	different := "just had a great coffee with friends this morning, feeling good"
+
+	if d := bits.OnesCount64(Simhash(base) ^ Simhash(similar)); d > 8 {
+		t.Fatalf("expected similar texts to have close simhashes, got distance %d", d)
+	}
+
+	if d := bits.OnesCount64(Simhash(base) ^ Simhash(different)); d < 8 {
+		t.Fatalf("expected different texts to have distant simhashes, got distance %d", d)
+	}
+}
+
+func TestDuplicateDetector(t *testing.T) {
+	config := DefaultDuplicateConfig()
+	config.MaxRepeats = 2
+	detector, err := NewDuplicateDetector(config)
+	if err != nil {
+		t.Fatalf("failed to create the detector: %v", err)
+	}
+
+	spam := &nostr.Event{PubKey: "spammer", Kind: 1, Content: "follow me for free sats, the best giveaway in town"}
+	other := &nostr.Event{PubKey: "honest", Kind: 1, Content: spam.Content}
+	reaction := &nostr.Event{PubKey: "spammer", Kind: 7, Content: spam.Content}
+
+	saved := detector.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+	failed := detector.Save(func(context.Context, Client, *nostr.Event) error { return ErrRestricted })
+
+	// the events that are not saved don't count towards the repeats
+	for range 3 {
+		if err := detector.Reject(context.Background(), nil, spam); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		failed(context.Background(), nil, spam)
+	}
+
+	for i := range 2 {
+		if err := detector.Reject(context.Background(), nil, spam); err != nil {
+			t.Fatalf("event %d: expected nil, got %v", i, err)
+		}
+		saved(context.Background(), nil, spam)
+	}
+
+	if err := detector.Reject(context.Background(), nil, spam); !errors.Is(err, ErrDuplicateContent) {
+		t.Fatalf("expected %v, got %v", ErrDuplicateContent, err)
+	}
+
+	if err := detector.Reject(context.Background(), nil, other); err != nil {
+		t.Fatalf("other pubkeys should not be affected, got %v", err)
+	}
+
+	if err := detector.Reject(context.Background(), nil, reaction); err != nil {
+		t.Fatalf("unchecked kinds should not be affected, got %v", err)
+	}
+}
+
+func TestDuplicateConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*DuplicateConfig)
+	}{
+		{name: "zero window", modify: func(c *DuplicateConfig) { c.Window = 0 }},
+		{name: "zero max repeats", modify: func(c *DuplicateConfig) { c.MaxRepeats = 0 }},
+		{name: "zero max history", modify: func(c *DuplicateConfig) { c.MaxHistory = 0 }},
+		{name: "history shorter than the repeats", modify: func(c *DuplicateConfig) { c.MaxRepeats = 65 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultDuplicateConfig()
+			test.modify(&config)
+			if _, err := NewDuplicateDetector(config); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nostr-net/rely"
@@ -27,7 +27,7 @@ func main() {
 }
 
 // TooMany rejects the REQ if the client has too many open filters.
-func TooMany(client rely.Client, filters nostr.Filters) error {
+func TooMany(ctx context.Context, client rely.Client, filters nostr.Filters) error {
 	total := len(filters)
 	for _, sub := range client.Subscriptions() {
 		total += len(sub.Filters())
@@ -35,7 +35,7 @@ func TooMany(client rely.Client, filters nostr.Filters) error {
 
 	if total > 10 {
 		client.Disconnect()
-		return errors.New("rate-limited: too many open filters")
+		return fmt.Errorf("%w: too many open filters", rely.ErrRateLimited)
 	}
 	return nil
 }
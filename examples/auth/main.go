@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"slices"
@@ -27,7 +26,6 @@ func main() {
 		WithDomain("example.com"), // the domain must be set to correctly validate NIP-42
 	)
 
-	relay.On.Connect = func(c Client) { c.SendAuth() }
 	relay.On.Auth = func(c Client) { log.Printf("client authed with pubkey %s", c.Pubkey()) }
 	relay.Reject.Req = append(relay.Reject.Req, AuthedOnDMs)
 
@@ -36,7 +34,7 @@ func main() {
 	}
 }
 
-func AuthedOnDMs(client rely.Client, filters nostr.Filters) error {
+func AuthedOnDMs(ctx context.Context, client rely.Client, filters nostr.Filters) error {
 	for _, filter := range filters {
 		if !slices.Contains(filter.Kinds, 4) {
 			continue
@@ -44,8 +42,9 @@ func AuthedOnDMs(client rely.Client, filters nostr.Filters) error {
 
 		pubkey := client.Pubkey()
 		if pubkey == "" {
-			// the client is not authenticated, so it can't request DMs
-			return errors.New("auth-required: you must be authenticated to query for DMs")
+			// the client is not authenticated, so it can't request DMs.
+			// The auth-required rejection sends it the AUTH challenge.
+			return fmt.Errorf("%w: you must be authenticated to query for DMs", rely.ErrAuthRequired)
 		}
 
 		if len(filter.Authors) != 1 || filter.Authors[0] != pubkey {
@@ -40,7 +40,7 @@ func BadIP(s rely.Stats, req *http.Request) error {
 	return nil
 }
 
-func Kind666(client rely.Client, event *nostr.Event) error {
+func Kind666(ctx context.Context, client rely.Client, event *nostr.Event) error {
 	if event.Kind == 666 {
 		// disconnect the client and return an error
 		blacklist = append(blacklist, client.IP())
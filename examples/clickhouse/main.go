@@ -89,8 +89,8 @@ func main() {
 		log.Printf("Client connected: %s", c.IP())
 	}
 
-	relay.On.Disconnect = func(c rely.Client) {
-		log.Printf("Client disconnected: %s (duration: %s)", c.IP(), time.Since(c.ConnectedAt()))
+	relay.On.Disconnect = func(c rely.Client, reason rely.DisconnectReason, err error) {
+		log.Printf("Client disconnected: %s (%s, duration: %s)", c.IP(), reason, time.Since(c.ConnectedAt()))
 	}
 
 	// Optional: Add authentication handler
@@ -39,7 +39,7 @@ func main() {
 	}
 }
 
-func Process(client rely.Client, request *nostr.Event) error {
+func Process(ctx context.Context, client rely.Client, request *nostr.Event) error {
 	switch request.Kind {
 	case 5500:
 		// malware scanning DVM
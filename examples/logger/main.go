@@ -35,7 +35,7 @@ func main() {
 	}
 }
 
-func Save(c rely.Client, e *nostr.Event) error {
+func Save(ctx context.Context, c rely.Client, e *nostr.Event) error {
 	logger.Info("received event", "event", e)
 	return nil
 }
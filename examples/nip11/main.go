@@ -38,7 +38,7 @@ func main() {
 	}
 }
 
-func Save(c rely.Client, e *nostr.Event) error {
+func Save(ctx context.Context, c rely.Client, e *nostr.Event) error {
 	log.Printf("received event: %v", e)
 	return nil
 }
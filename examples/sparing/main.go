@@ -34,14 +34,14 @@ func main() {
 	}
 }
 
-func TooGreedy(client rely.Client, filters nostr.Filters) error {
+func TooGreedy(ctx context.Context, client rely.Client, filters nostr.Filters) error {
 	if client.RemainingCapacity() < 10 {
 		return errors.New("slow down there chief")
 	}
 	return nil
 }
 
-func Save(c rely.Client, e *nostr.Event) error {
+func Save(ctx context.Context, c rely.Client, e *nostr.Event) error {
 	log.Printf("received event: %v", e)
 	return nil
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -34,8 +34,7 @@ const ipTokens = 100
 var cache *RankCache
 var limiter *Limiter
 
-var ErrAuthRequired = errors.New("auth-required:")
-var ErrRateLimited = errors.New("rate-limited: please try again in a few hours")
+var ErrTryLater = fmt.Errorf("%w: please try again in a few hours", ErrRateLimited)
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -54,13 +53,13 @@ func main() {
 		if limiter.Allow(rely.IP(r), ipRefill) {
 			return nil
 		}
-		return ErrRateLimited
+		return ErrTryLater
 	})
 
 	// send an AUTH challenge as soon as the client connects
 	relay.On.Connect = func(c rely.Client) { c.SendAuth() }
 
-	relay.On.Event = func(c rely.Client, e *nostr.Event) error {
+	relay.On.Event = func(ctx context.Context, c rely.Client, e *nostr.Event) error {
 		pubkey := c.Pubkey()
 		if pubkey == "" {
 			return ErrAuthRequired
@@ -72,14 +71,14 @@ func main() {
 			// otherwise we disconnect the client as this is probably an attacker trying to waste our backend budget.
 			if !limiter.Allow(c.IP(), ipRefill) {
 				c.Disconnect()
-				return ErrRateLimited
+				return ErrTryLater
 			}
 
 			cache.refresh <- pubkey
 		}
 
 		if !limiter.Allow(pubkey, pkRefill(rank)) {
-			return ErrRateLimited
+			return ErrTryLater
 		}
 
 		return Save(e)
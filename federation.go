@@ -0,0 +1,175 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	ErrFederationDenied    = fmt.Errorf("%w: events forwarded by this relay are not accepted", ErrBlocked)
+	ErrFederationRateLimit = fmt.Errorf("%w: too many forwarded events", ErrRateLimited)
+)
+
+// FederationConfig configures the [Federation] trust rules.
+type FederationConfig struct {
+	// Peers are the pubkeys other relays and aggregators authenticate with (NIP-42)
+	// when forwarding events.
+	Peers []string
+
+	// Networks are CIDR ranges of the forwarding relays, trusted without authentication.
+	// They are matched against [Client.IP], which the relay reads from the X-Forwarded-For headers
+	// only of its trusted proxies (see [WithTrustedProxies]), so that clients can't claim them.
+	Networks []string
+
+	// Denied are the pubkeys of relays and aggregators whose forwarded events are refused.
+	Denied []string
+
+	// PeerRate is the number of events per second each peer can forward,
+	// and PeerBurst the maximum number in a burst.
+	PeerRate  float64
+	PeerBurst float64
+}
+
+// DefaultFederationConfig returns a [FederationConfig] with sane limits and no peers.
+func DefaultFederationConfig() FederationConfig {
+	return FederationConfig{
+		PeerRate:  100,
+		PeerBurst: 1000,
+	}
+}
+
+// Federation detects the clients forwarding events on behalf of other relays, either by the pubkey
+// they authenticated with or by their network, and applies separate trust rules to them:
+// the policies meant for end users (e.g. PoW or per-IP rate limits) can be skipped with
+// [Federation.Skip], while peers are rate-limited by [Federation.RejectEvent] instead.
+// This allows the relay to act as an aggregator tier.
+//
+// Example:
+//
+//	fed, err := NewFederation(config)
+//	relay.Reject.Event = append(relay.Reject.Event, fed.RejectEvent, fed.Skip(defense.Reject))
+//	go fed.Run(ctx)
+type Federation struct {
+	config   FederationConfig
+	peers    map[string]struct{}
+	denied   map[string]struct{}
+	networks []*net.IPNet
+	limiter  *RateLimiter
+
+	forwarded atomic.Int64
+}
+
+// NewFederation returns a [Federation], or an error if any of the pubkeys or networks is invalid.
+func NewFederation(config FederationConfig) (*Federation, error) {
+	f := &Federation{
+		config:  config,
+		peers:   make(map[string]struct{}, len(config.Peers)),
+		denied:  make(map[string]struct{}, len(config.Denied)),
+		limiter: NewRateLimiter(),
+	}
+
+	for _, pubkey := range config.Peers {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			return nil, fmt.Errorf("invalid peer pubkey %q", pubkey)
+		}
+		f.peers[pubkey] = struct{}{}
+	}
+
+	for _, pubkey := range config.Denied {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			return nil, fmt.Errorf("invalid denied pubkey %q", pubkey)
+		}
+		f.denied[pubkey] = struct{}{}
+	}
+
+	for _, cidr := range config.Networks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		f.networks = append(f.networks, network)
+	}
+	return f, nil
+}
+
+// Forwarded returns the number of events accepted from peers.
+func (f *Federation) Forwarded() int64 { return f.forwarded.Load() }
+
+// IsPeer reports whether the client is a trusted relay, because it authenticated
+// with one of the peer pubkeys or it connected from one of the peer networks.
+func (f *Federation) IsPeer(c Client) bool {
+	if _, ok := f.peers[c.Pubkey()]; ok {
+		return true
+	}
+
+	ip := net.ParseIP(c.IP())
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range f.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerKey returns the key peers are rate-limited by.
+func peerKey(c Client) string {
+	if pubkey := c.Pubkey(); pubkey != "" {
+		return pubkey
+	}
+	return c.IP()
+}
+
+// Skip wraps a Reject.Event hook so that it's skipped for events forwarded by peers.
+// Use it for policies meant for end users, like PoW or per-IP rate limits.
+func (f *Federation) Skip(reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if f.IsPeer(c) {
+			return nil
+		}
+		return reject(ctx, c, e)
+	}
+}
+
+// RejectEvent is a Reject.Event hook that refuses the events forwarded by denied relays,
+// and rate-limits those forwarded by peers. Events of other clients are not checked.
+func (f *Federation) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if _, ok := f.denied[c.Pubkey()]; ok {
+		return ErrFederationDenied
+	}
+
+	if !f.IsPeer(c) {
+		return nil
+	}
+
+	if !f.limiter.Allow(peerKey(c), f.config.PeerRate, f.config.PeerBurst) {
+		return ErrFederationRateLimit
+	}
+
+	f.forwarded.Add(1)
+	return nil
+}
+
+// Run periodically removes the rate limits of idle peers, until the context is cancelled.
+func (f *Federation) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.limiter.Prune(10 * time.Minute)
+		}
+	}
+}
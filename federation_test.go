@@ -0,0 +1,78 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func newTestFederation(t *testing.T, denied string) *Federation {
+	fed, err := NewFederation(FederationConfig{
+		Peers:     []string{pk},
+		Networks:  []string{"10.0.0.0/8"},
+		Denied:    []string{denied},
+		PeerRate:  0,
+		PeerBurst: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fed
+}
+
+func TestFederationIsPeer(t *testing.T) {
+	fed := newTestFederation(t, "0"+pk[1:])
+
+	if !fed.IsPeer(&client{pubkey: pk, ip: "1.2.3.4"}) {
+		t.Error("expected the client authenticated as a peer to be a peer")
+	}
+
+	if !fed.IsPeer(&client{ip: "10.1.2.3"}) {
+		t.Error("expected the client of a peer network to be a peer")
+	}
+
+	if fed.IsPeer(&client{ip: "1.2.3.4"}) {
+		t.Error("expected the user not to be a peer")
+	}
+}
+
+func TestFederationSkip(t *testing.T) {
+	fed := newTestFederation(t, "0"+pk[1:])
+	errPow := errors.New("pow: difficulty too low")
+	pow := fed.Skip(func(context.Context, Client, *nostr.Event) error { return errPow })
+
+	if err := pow(context.Background(), &client{ip: "10.1.2.3"}, &nostr.Event{Kind: 1}); err != nil {
+		t.Fatalf("the hook should have been skipped for the peer, got %v", err)
+	}
+
+	if err := pow(context.Background(), &client{ip: "1.2.3.4"}, &nostr.Event{Kind: 1}); err != errPow {
+		t.Fatalf("expected %v, got %v", errPow, err)
+	}
+}
+
+func TestFederationRejectEvent(t *testing.T) {
+	denied := "0" + pk[1:]
+	fed := newTestFederation(t, denied)
+	event := &nostr.Event{Kind: 1}
+
+	peer := &client{pubkey: pk}
+	for i := range 2 {
+		if err := fed.RejectEvent(context.Background(), peer, event); err != nil {
+			t.Fatalf("event %d should have been accepted, got %v", i, err)
+		}
+	}
+
+	if err := fed.RejectEvent(context.Background(), peer, event); err != ErrFederationRateLimit {
+		t.Fatalf("expected %v, got %v", ErrFederationRateLimit, err)
+	}
+
+	if err := fed.RejectEvent(context.Background(), &client{pubkey: denied}, event); err != ErrFederationDenied {
+		t.Fatalf("expected %v, got %v", ErrFederationDenied, err)
+	}
+
+	if err := fed.RejectEvent(context.Background(), &client{ip: "1.2.3.4"}, event); err != nil {
+		t.Fatalf("users should not be checked, got %v", err)
+	}
+}
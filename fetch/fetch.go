@@ -0,0 +1,345 @@
+// Package fetch provides an HTTP client hardened for the outbound requests whose URLs come from
+// untrusted input (e.g. the domain of a NIP-05 identifier), protecting the relay from server-side
+// request forgery: the hosts are resolved once and the connections pinned to the resolved addresses,
+// which must be public, and the requests are bounded in time, size and rate per host.
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBlockedAddress = errors.New("fetch: the host resolves to a blocked address")
+	ErrInvalidURL     = errors.New("fetch: only http and https URLs are allowed")
+	ErrRateLimited    = errors.New("fetch: too many requests to the host")
+	ErrTooLarge       = errors.New("fetch: the response body is too large")
+	ErrTooManyHops    = errors.New("fetch: too many redirects")
+)
+
+// StatusError is returned when the server responds with a status other than 200 OK.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetch: the server responded with status %d", e.Code)
+}
+
+// Config configures the [Fetcher].
+type Config struct {
+	// Timeout of each request, including the connection, the redirects and reading the body.
+	Timeout time.Duration
+
+	// MaxBodySize is the maximum size of the response bodies, in bytes.
+	MaxBodySize int64
+
+	// MaxRedirects is the number of redirects followed. Zero doesn't follow them.
+	MaxRedirects int
+
+	// HostRate is the requests per second allowed to each host, with bursts of up to HostBurst.
+	HostRate  float64
+	HostBurst float64
+
+	// CacheTTL is how long the responses of successful GETs are cached (default: 0, disabled),
+	// and CacheSize the maximum number of responses cached.
+	CacheTTL  time.Duration
+	CacheSize int
+
+	// AllowPrivate allows the hosts resolving to loopback, private, link-local and other
+	// non-public addresses. It's meant for tests and trusted networks only.
+	AllowPrivate bool
+
+	// RootCAs are the certificate authorities trusted by the client. Nil uses the system ones.
+	RootCAs *x509.CertPool
+
+	// UserAgent of the requests.
+	UserAgent string
+}
+
+// DefaultConfig returns a [Config] with sane defaults.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      10 * time.Second,
+		MaxBodySize:  1 << 20,
+		MaxRedirects: 0,
+		HostRate:     1,
+		HostBurst:    10,
+		CacheSize:    10_000,
+		UserAgent:    "rely",
+	}
+}
+
+// Fetcher is an HTTP client with SSRF protections. It's safe for concurrent use.
+//
+// Example:
+//
+//	fetcher := fetch.New(fetch.DefaultConfig())
+//	body, err := fetcher.Get(ctx, "https://example.com/.well-known/nostr.json?name=alice")
+type Fetcher struct {
+	config   Config
+	client   *http.Client
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	cache   map[string]cached
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type cached struct {
+	body    []byte
+	expires time.Time
+}
+
+// New returns a [Fetcher] with the config.
+func New(config Config) *Fetcher {
+	f := &Fetcher{
+		config:   config,
+		resolver: net.DefaultResolver,
+		buckets:  make(map[string]*bucket),
+		cache:    make(map[string]cached),
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	transport := &http.Transport{
+		DialContext:           f.dialer(dialer),
+		TLSClientConfig:       &tls.Config{RootCAs: config.RootCAs},
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       time.Minute,
+	}
+
+	f.client = &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if config.MaxRedirects == 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > config.MaxRedirects {
+				return ErrTooManyHops
+			}
+			return f.check(r.URL)
+		},
+	}
+	return f
+}
+
+// Get returns the body of the response to the GET request of the URL, from the cache if enabled.
+// It returns a [*StatusError] if the status of the response is not 200 OK.
+func (f *Fetcher) Get(ctx context.Context, rawURL string) ([]byte, error) {
+	if body, ok := f.cached(rawURL); ok {
+		return body, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := f.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	f.store(rawURL, body)
+	return body, nil
+}
+
+// Post returns the body of the response to the POST request of the URL with the body, which is never cached.
+// It returns a [*StatusError] if the status of the response is not 200 OK.
+func (f *Fetcher) Post(ctx context.Context, rawURL, contentType string, body io.Reader) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	return f.Do(request)
+}
+
+// Do sends the request, returning the body of the response.
+// It returns a [*StatusError] if the status of the response is not 200 OK.
+func (f *Fetcher) Do(request *http.Request) ([]byte, error) {
+	if err := f.check(request.URL); err != nil {
+		return nil, err
+	}
+
+	if request.Header.Get("User-Agent") == "" && f.config.UserAgent != "" {
+		request.Header.Set("User-Agent", f.config.UserAgent)
+	}
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: response.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, f.config.MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > f.config.MaxBodySize {
+		return nil, ErrTooLarge
+	}
+	return body, nil
+}
+
+// check returns an error if the URL is not http or https, or its host is over the rate limit.
+func (f *Fetcher) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+
+	if !f.allow(strings.ToLower(u.Hostname()), time.Now()) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// dialer returns a DialContext function that resolves the host once, and connects to the first
+// of its addresses, after checking that none of them is blocked, so that a DNS response
+// can't be changed between the check and the connection.
+func (f *Fetcher) dialer(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := f.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("fetch: no addresses for %s", host)
+		}
+
+		for _, addr := range addrs {
+			if !f.config.AllowPrivate && Blocked(addr.IP) {
+				return nil, fmt.Errorf("%w: %s", ErrBlockedAddress, addr.IP)
+			}
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+	}
+}
+
+// Blocked reports whether the IP is not a public unicast address: loopback, private,
+// link-local, multicast, unspecified, carrier-grade NAT or IPv4-mapped versions of them.
+func Blocked(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598).
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// allow reports whether the host can make a request, refilling its bucket.
+func (f *Fetcher) allow(host string, now time.Time) bool {
+	if f.config.HostRate <= 0 {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, ok := f.buckets[host]
+	if !ok {
+		if len(f.buckets) >= 100_000 {
+			f.prune(now)
+		}
+
+		b = &bucket{tokens: f.config.HostBurst, last: now}
+		f.buckets[host] = b
+	}
+
+	b.tokens = min(f.config.HostBurst, b.tokens+now.Sub(b.last).Seconds()*f.config.HostRate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune removes the full buckets and the expired responses.
+func (f *Fetcher) prune(now time.Time) {
+	for host, b := range f.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*f.config.HostRate >= f.config.HostBurst {
+			delete(f.buckets, host)
+		}
+	}
+
+	for key, c := range f.cache {
+		if now.After(c.expires) {
+			delete(f.cache, key)
+		}
+	}
+}
+
+func (f *Fetcher) cached(key string) ([]byte, bool) {
+	if f.config.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.cache[key]
+	if !ok || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return c.body, true
+}
+
+func (f *Fetcher) store(key string, body []byte) {
+	if f.config.CacheTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.cache) >= f.config.CacheSize {
+		f.prune(now)
+	}
+
+	if len(f.cache) >= f.config.CacheSize {
+		return
+	}
+	f.cache[key] = cached{body: body, expires: now.Add(f.config.CacheTTL)}
+}
@@ -0,0 +1,144 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBlocked(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{ip: "127.0.0.1", blocked: true},
+		{ip: "10.1.2.3", blocked: true},
+		{ip: "172.16.0.1", blocked: true},
+		{ip: "192.168.1.1", blocked: true},
+		{ip: "169.254.169.254", blocked: true},
+		{ip: "100.64.0.1", blocked: true},
+		{ip: "0.0.0.0", blocked: true},
+		{ip: "224.0.0.1", blocked: true},
+		{ip: "::1", blocked: true},
+		{ip: "fc00::1", blocked: true},
+		{ip: "fe80::1", blocked: true},
+		{ip: "::ffff:127.0.0.1", blocked: true},
+		{ip: "1.1.1.1"},
+		{ip: "100.128.0.1"},
+		{ip: "2606:4700:4700::1111"},
+	}
+
+	for _, test := range tests {
+		if blocked := Blocked(net.ParseIP(test.ip)); blocked != test.blocked {
+			t.Errorf("%s: expected blocked %v, got %v", test.ip, test.blocked, blocked)
+		}
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("ok"))
+		case "/large":
+			w.Write([]byte(strings.Repeat("a", 100)))
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	t.Run("private address", func(t *testing.T) {
+		fetcher := New(DefaultConfig())
+		if _, err := fetcher.Get(ctx, server.URL+"/ok"); !errors.Is(err, ErrBlockedAddress) {
+			t.Fatalf("expected %v, got %v", ErrBlockedAddress, err)
+		}
+	})
+
+	t.Run("invalid scheme", func(t *testing.T) {
+		fetcher := New(DefaultConfig())
+		if _, err := fetcher.Get(ctx, "file:///etc/passwd"); !errors.Is(err, ErrInvalidURL) {
+			t.Fatalf("expected %v, got %v", ErrInvalidURL, err)
+		}
+	})
+
+	config := DefaultConfig()
+	config.AllowPrivate = true
+	config.MaxBodySize = 50
+	config.HostRate = 0
+
+	t.Run("status", func(t *testing.T) {
+		fetcher := New(config)
+		var status *StatusError
+		if _, err := fetcher.Get(ctx, server.URL+"/missing"); !errors.As(err, &status) || status.Code != http.StatusNotFound {
+			t.Fatalf("expected a 404 status error, got %v", err)
+		}
+
+		if _, err := fetcher.Get(ctx, server.URL+"/redirect"); !errors.As(err, &status) || status.Code != http.StatusFound {
+			t.Fatalf("expected the redirect not to be followed, got %v", err)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		fetcher := New(config)
+		if _, err := fetcher.Get(ctx, server.URL+"/large"); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("expected %v, got %v", ErrTooLarge, err)
+		}
+	})
+
+	t.Run("redirects", func(t *testing.T) {
+		config := config
+		config.MaxRedirects = 1
+
+		fetcher := New(config)
+		if body, err := fetcher.Get(ctx, server.URL+"/redirect"); err != nil || string(body) != "ok" {
+			t.Fatalf("expected the redirect to be followed, got %q, %v", body, err)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		config := config
+		config.CacheTTL = time.Minute
+
+		fetcher := New(config)
+		before := requests.Load()
+		for range 3 {
+			if body, err := fetcher.Get(ctx, server.URL+"/ok"); err != nil || string(body) != "ok" {
+				t.Fatalf("unexpected response %q, %v", body, err)
+			}
+		}
+
+		if requests.Load()-before != 1 {
+			t.Fatalf("expected a single request, got %d", requests.Load()-before)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		config := config
+		config.HostRate = 0.001
+		config.HostBurst = 2
+
+		fetcher := New(config)
+		for i := range 2 {
+			if _, err := fetcher.Get(ctx, server.URL+"/ok"); err != nil {
+				t.Fatalf("request %d: unexpected error %v", i, err)
+			}
+		}
+
+		if _, err := fetcher.Get(ctx, server.URL+"/ok"); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("expected %v, got %v", ErrRateLimited, err)
+		}
+	})
+}
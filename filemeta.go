@@ -0,0 +1,156 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+const KindFileMetadata = 1063
+
+var (
+	ErrInvalidFileMetadata = fmt.Errorf("%w: file metadata", ErrInvalid)
+	ErrUnreachableFile     = fmt.Errorf("%w: the file URL is not reachable", ErrInvalid)
+)
+
+// ValidateFileMetadata returns an error wrapping [ErrInvalidFileMetadata] if the NIP-94 file metadata event
+// (kind 1063) has invalid tags: the "url" must be an http or https URL, the "m" a MIME type, the "x" and the
+// optional "ox" the lowercase hex SHA-256 of the file, the optional "size" its bytes and "dim" its dimensions
+// (e.g. "800x600"). Events of other kinds are always valid.
+// See https://github.com/nostr-protocol/nips/blob/master/94.md
+func ValidateFileMetadata(e *nostr.Event) error {
+	if e.Kind != KindFileMetadata {
+		return nil
+	}
+
+	u, err := url.Parse(tagValue(e, "url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: the url tag must be an http or https URL", ErrInvalidFileMetadata)
+	}
+
+	if m := tagValue(e, "m"); !strings.Contains(m, "/") || strings.ToLower(m) != m {
+		return fmt.Errorf("%w: the m tag must be a lowercase MIME type", ErrInvalidFileMetadata)
+	}
+
+	if !isSHA256(tagValue(e, "x")) {
+		return fmt.Errorf("%w: the x tag must be the lowercase hex SHA-256 of the file", ErrInvalidFileMetadata)
+	}
+
+	if ox := e.Tags.Find("ox"); ox != nil && (len(ox) < 2 || !isSHA256(ox[1])) {
+		return fmt.Errorf("%w: the ox tag must be the lowercase hex SHA-256 of the original file", ErrInvalidFileMetadata)
+	}
+
+	if size := e.Tags.Find("size"); size != nil {
+		if len(size) < 2 {
+			return fmt.Errorf("%w: the size tag must be the bytes of the file", ErrInvalidFileMetadata)
+		}
+		if n, err := strconv.ParseInt(size[1], 10, 64); err != nil || n < 0 {
+			return fmt.Errorf("%w: the size tag must be the bytes of the file", ErrInvalidFileMetadata)
+		}
+	}
+
+	if dim := e.Tags.Find("dim"); dim != nil && (len(dim) < 2 || !isDimension(dim[1])) {
+		return fmt.Errorf("%w: the dim tag must be the dimensions of the file, e.g. 800x600", ErrInvalidFileMetadata)
+	}
+	return nil
+}
+
+// tagValue returns the value of the first tag with the key, or an empty string if there's none.
+func tagValue(e *nostr.Event, key string) string {
+	if tag := e.Tags.Find(key); len(tag) > 1 {
+		return tag[1]
+	}
+	return ""
+}
+
+// isSHA256 reports whether the string is a lowercase hex SHA-256 hash.
+func isSHA256(s string) bool {
+	return len(s) == 64 && nostr.IsValid32ByteHex(s) && strings.ToLower(s) == s
+}
+
+// isDimension reports whether the string is the dimensions of a file in pixels, e.g. "800x600".
+func isDimension(s string) bool {
+	width, height, ok := strings.Cut(s, "x")
+	if !ok {
+		return false
+	}
+
+	w, err := strconv.Atoi(width)
+	if err != nil || w <= 0 {
+		return false
+	}
+	h, err := strconv.Atoi(height)
+	return err == nil && h > 0
+}
+
+// FileMetadataConfig configures the [FileMetadata] policy.
+type FileMetadataConfig struct {
+	// CheckURL rejects the events whose file URL doesn't answer a HEAD request with 200 OK.
+	CheckURL bool
+
+	// Timeout of each request checking a URL.
+	Timeout time.Duration
+}
+
+// DefaultFileMetadataConfig returns a [FileMetadataConfig] that doesn't check the URLs.
+func DefaultFileMetadataConfig() FileMetadataConfig {
+	return FileMetadataConfig{Timeout: 5 * time.Second}
+}
+
+// FileMetadata rejects the invalid NIP-94 file metadata events (kind 1063), see [ValidateFileMetadata],
+// and optionally the ones whose file is not reachable. Once stored, the files can be looked up by content
+// hash with the "#x" filters.
+//
+// Example:
+//
+//	files, err := NewFileMetadata(DefaultFileMetadataConfig())
+//	relay.Reject.Event = append(relay.Reject.Event, files.RejectEvent)
+type FileMetadata struct {
+	config  FileMetadataConfig
+	fetcher *fetch.Fetcher
+}
+
+// NewFileMetadata returns a [FileMetadata] policy, or an error if the config is invalid.
+func NewFileMetadata(config FileMetadataConfig) (*FileMetadata, error) {
+	if config.CheckURL && config.Timeout <= 0 {
+		return nil, errors.New("the file metadata timeout must be positive")
+	}
+
+	// the files are often served through redirects, e.g. from a CDN, and most of them by a few
+	// popular hosts, which must not be rate limited. The HEAD responses have no body.
+	fetching := fetch.DefaultConfig()
+	fetching.Timeout = config.Timeout
+	fetching.MaxRedirects = 3
+	fetching.MaxBodySize = 0
+	fetching.HostRate = 0
+
+	return &FileMetadata{
+		config:  config,
+		fetcher: fetch.New(fetching),
+	}, nil
+}
+
+// RejectEvent is a Reject.Event hook rejecting the invalid file metadata events.
+func (f *FileMetadata) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if err := ValidateFileMetadata(e); err != nil || e.Kind != KindFileMetadata || !f.config.CheckURL {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, tagValue(e, "url"), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachableFile, err)
+	}
+
+	if _, err := f.fetcher.Do(request); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachableFile, err)
+	}
+	return nil
+}
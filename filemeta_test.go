@@ -0,0 +1,77 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const fileHash = "5d2899290e0e69bcd809949ee516a4a1597205390878f780c098707a7f18e3df"
+
+func fileMetadata(tags ...nostr.Tag) *nostr.Event {
+	return &nostr.Event{
+		Kind: KindFileMetadata,
+		Tags: append(nostr.Tags{
+			{"url", "https://files.example.com/cat.png"},
+			{"m", "image/png"},
+			{"x", fileHash},
+		}, tags...),
+	}
+}
+
+func TestValidateFileMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		valid bool
+	}{
+		{name: "minimal", event: fileMetadata(), valid: true},
+		{name: "complete", event: fileMetadata(nostr.Tag{"ox", fileHash}, nostr.Tag{"size", "1024"}, nostr.Tag{"dim", "800x600"}), valid: true},
+		{name: "other kind", event: &nostr.Event{Kind: 1}, valid: true},
+		{name: "no url", event: &nostr.Event{Kind: KindFileMetadata, Tags: nostr.Tags{{"m", "image/png"}, {"x", fileHash}}}, valid: false},
+		{name: "ftp url", event: &nostr.Event{Kind: KindFileMetadata, Tags: nostr.Tags{{"url", "ftp://example.com/a"}, {"m", "image/png"}, {"x", fileHash}}}, valid: false},
+		{name: "uppercase hash", event: &nostr.Event{Kind: KindFileMetadata, Tags: nostr.Tags{{"url", "https://example.com/a"}, {"m", "image/png"}, {"x", "5D2899290E0E69BCD809949EE516A4A1597205390878F780C098707A7F18E3DF"}}}, valid: false},
+		{name: "short hash", event: &nostr.Event{Kind: KindFileMetadata, Tags: nostr.Tags{{"url", "https://example.com/a"}, {"m", "image/png"}, {"x", "abc"}}}, valid: false},
+		{name: "invalid mime", event: &nostr.Event{Kind: KindFileMetadata, Tags: nostr.Tags{{"url", "https://example.com/a"}, {"m", "png"}, {"x", fileHash}}}, valid: false},
+		{name: "negative size", event: fileMetadata(nostr.Tag{"size", "-1"}), valid: false},
+		{name: "invalid dim", event: fileMetadata(nostr.Tag{"dim", "800"}), valid: false},
+		{name: "invalid ox", event: fileMetadata(nostr.Tag{"ox", "abc"}), valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateFileMetadata(test.event)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidFileMetadata) {
+				t.Fatalf("expected %v, got %v", ErrInvalidFileMetadata, err)
+			}
+		})
+	}
+}
+
+func TestFileMetadataCheckURL(t *testing.T) {
+	config := DefaultFileMetadataConfig()
+	config.CheckURL = true
+
+	files, err := NewFileMetadata(config)
+	if err != nil {
+		t.Fatalf("failed to create the policy: %v", err)
+	}
+
+	// private addresses are never fetched
+	event := fileMetadata()
+	event.Tags[0] = nostr.Tag{"url", "http://127.0.0.1:1/cat.png"}
+
+	if err := files.RejectEvent(context.Background(), nil, event); !errors.Is(err, ErrUnreachableFile) {
+		t.Fatalf("expected %v, got %v", ErrUnreachableFile, err)
+	}
+
+	config.Timeout = 0
+	if _, err := NewFileMetadata(config); err == nil {
+		t.Fatal("expected an error for a zero timeout")
+	}
+}
@@ -0,0 +1,154 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	ErrFirehoseDenied    = fmt.Errorf("%w: subscriptions matching all events are not allowed", ErrBlocked)
+	ErrFirehoseAuth      = fmt.Errorf("%w: you must be authenticated to subscribe to all events", ErrAuthRequired)
+	ErrFirehoseRestrict  = fmt.Errorf("%w: this pubkey is not allowed to subscribe to all events", ErrRestricted)
+	ErrFirehoseRateLimit = fmt.Errorf("%w: too many subscriptions matching all events", ErrRateLimited)
+)
+
+// FirehoseMode is the policy applied to the firehose subscriptions of clients
+// that are not in the [FirehoseConfig] Allowed list.
+type FirehoseMode string
+
+const (
+	// FirehoseDeny rejects all firehose subscriptions.
+	FirehoseDeny FirehoseMode = "deny"
+
+	// FirehoseAuthenticated accepts the firehose subscriptions of authenticated clients only (NIP-42),
+	// or only of the Allowed pubkeys, if any.
+	FirehoseAuthenticated FirehoseMode = "auth"
+
+	// FirehoseRateLimited accepts the firehose subscriptions of any client, rate-limited by IP.
+	FirehoseRateLimited FirehoseMode = "rate-limit"
+)
+
+// FirehoseConfig configures the [Firehose] policy.
+type FirehoseConfig struct {
+	Mode FirehoseMode
+
+	// Allowed are the pubkeys (e.g. of archivers) whose firehose subscriptions are always accepted,
+	// once authenticated. They bypass the mode and its rate limits.
+	Allowed []string
+
+	// Rate is the number of firehose subscriptions per second an IP can open in [FirehoseRateLimited] mode,
+	// and Burst the maximum number in a burst.
+	Rate  float64
+	Burst float64
+}
+
+// DefaultFirehoseConfig returns a [FirehoseConfig] that accepts one firehose subscription per minute per IP.
+func DefaultFirehoseConfig() FirehoseConfig {
+	return FirehoseConfig{
+		Mode:  FirehoseRateLimited,
+		Rate:  1.0 / 60,
+		Burst: 2,
+	}
+}
+
+// Firehose applies an explicit policy to the filterless or match-all REQs, which subscribe to
+// every event the relay receives. Firehose consumers are both valuable (e.g. archivers) and
+// dangerous, since a single subscription can consume the bandwidth of thousands of regular ones.
+// Combine it with [WithSubscriptionLimits] to bound the subscriptions that are accepted.
+//
+// Example:
+//
+//	firehose, err := NewFirehose(DefaultFirehoseConfig())
+//	relay.Reject.Req = append(relay.Reject.Req, firehose.RejectReq)
+//	go firehose.Run(ctx)
+type Firehose struct {
+	config  FirehoseConfig
+	allowed map[string]struct{}
+	limiter *RateLimiter
+}
+
+// NewFirehose returns a [Firehose], or an error if the mode or any of the pubkeys is invalid.
+func NewFirehose(config FirehoseConfig) (*Firehose, error) {
+	switch config.Mode {
+	case FirehoseDeny, FirehoseAuthenticated, FirehoseRateLimited:
+	default:
+		return nil, fmt.Errorf("unknown firehose mode %q, must be one of deny, auth or rate-limit", config.Mode)
+	}
+
+	f := &Firehose{
+		config:  config,
+		allowed: make(map[string]struct{}, len(config.Allowed)),
+		limiter: NewRateLimiter(),
+	}
+
+	for _, pubkey := range config.Allowed {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			return nil, fmt.Errorf("invalid allowed pubkey %q", pubkey)
+		}
+		f.allowed[pubkey] = struct{}{}
+	}
+	return f, nil
+}
+
+// RejectReq is a Reject.Req hook that applies the policy to the REQs with at least one firehose filter.
+func (f *Firehose) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	if !isFirehose(filters) {
+		return nil
+	}
+
+	if _, ok := f.allowed[c.Pubkey()]; ok {
+		return nil
+	}
+
+	switch f.config.Mode {
+	case FirehoseAuthenticated:
+		if c.Pubkey() == "" {
+			return ErrFirehoseAuth
+		}
+
+		if len(f.allowed) > 0 {
+			return ErrFirehoseRestrict
+		}
+		return nil
+
+	case FirehoseRateLimited:
+		if !f.limiter.Allow(c.IP(), f.config.Rate, f.config.Burst) {
+			return ErrFirehoseRateLimit
+		}
+		return nil
+
+	default:
+		if len(f.allowed) > 0 && c.Pubkey() == "" {
+			return ErrFirehoseAuth
+		}
+		return ErrFirehoseDenied
+	}
+}
+
+// isFirehose reports whether any of the filters matches all events, ignoring their time range and limit.
+func isFirehose(filters nostr.Filters) bool {
+	for _, f := range filters {
+		if len(f.IDs) == 0 && len(f.Authors) == 0 && len(f.Kinds) == 0 && len(f.Tags) == 0 && f.Search == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Run periodically removes the rate limits of idle IPs, until the context is cancelled.
+func (f *Firehose) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.limiter.Prune(10 * time.Minute)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsFirehose(t *testing.T) {
+	since := nostr.Now()
+	tests := []struct {
+		name     string
+		filters  nostr.Filters
+		expected bool
+	}{
+		{name: "empty filter", filters: nostr.Filters{{}}, expected: true},
+		{name: "time range and limit", filters: nostr.Filters{{Since: &since, Limit: 100}}, expected: true},
+		{name: "kinds", filters: nostr.Filters{{Kinds: []int{1}}}, expected: false},
+		{name: "one of many", filters: nostr.Filters{{Authors: []string{pk}}, {}}, expected: true},
+		{name: "search", filters: nostr.Filters{{Search: "nostr"}}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if isFirehose(test.filters) != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, !test.expected)
+			}
+		})
+	}
+}
+
+func TestFirehoseModes(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+	anonymous := &client{relay: relay, ip: "1.2.3.4", responses: make(chan response, 10)}
+	archiver := &client{relay: relay, ip: "1.2.3.4", pubkey: pk, responses: make(chan response, 10)}
+	firehose := nostr.Filters{{}}
+
+	tests := []struct {
+		name     string
+		config   FirehoseConfig
+		client   *client
+		expected []error
+	}{
+		{name: "deny", config: FirehoseConfig{Mode: FirehoseDeny}, client: archiver, expected: []error{ErrFirehoseDenied}},
+		{name: "deny, allowed", config: FirehoseConfig{Mode: FirehoseDeny, Allowed: []string{pk}}, client: archiver, expected: []error{nil}},
+		{name: "deny, anonymous", config: FirehoseConfig{Mode: FirehoseDeny, Allowed: []string{pk}}, client: anonymous, expected: []error{ErrFirehoseAuth}},
+		{name: "auth", config: FirehoseConfig{Mode: FirehoseAuthenticated}, client: archiver, expected: []error{nil}},
+		{name: "auth, anonymous", config: FirehoseConfig{Mode: FirehoseAuthenticated}, client: anonymous, expected: []error{ErrFirehoseAuth}},
+		{name: "rate limit", config: FirehoseConfig{Mode: FirehoseRateLimited, Burst: 2}, client: anonymous, expected: []error{nil, nil, ErrFirehoseRateLimit}},
+		{name: "rate limit, allowed", config: FirehoseConfig{Mode: FirehoseRateLimited, Burst: 1, Allowed: []string{pk}}, client: archiver, expected: []error{nil, nil, nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewFirehose(test.config)
+			if err != nil {
+				t.Fatalf("failed to create the firehose policy: %v", err)
+			}
+
+			for i, expected := range test.expected {
+				if err := f.RejectReq(context.Background(), test.client, firehose); err != expected {
+					t.Fatalf("REQ %d: expected %v, got %v", i, expected, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package rely
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrCountryDenied = fmt.Errorf("%w: connections from your country are not allowed", ErrBlocked)
+
+// GeoIP resolves the country of IP addresses, e.g. with a MaxMind GeoIP2 or GeoLite2 database.
+// Implementations must be safe for concurrent use.
+type GeoIP interface {
+	// Country returns the ISO 3166-1 alpha-2 code (e.g. "DE") of the country of the IP,
+	// or an empty string if it's unknown.
+	Country(ip string) string
+}
+
+// CountryPolicyConfig configures the [CountryPolicy]. Countries are ISO 3166-1 alpha-2 codes.
+type CountryPolicyConfig struct {
+	// Allowed are the only countries whose connections are accepted. If empty, all countries are.
+	Allowed []string
+
+	// Denied are the countries whose connections are refused.
+	Denied []string
+
+	// AllowUnknown accepts the connections of IPs whose country is unknown (e.g. private networks)
+	// even if Allowed is not empty.
+	AllowUnknown bool
+}
+
+// CountryPolicy refuses the websocket upgrade of the IPs of some countries, resolved with a [GeoIP].
+//
+// Example:
+//
+//	policy, err := NewCountryPolicy(geo, CountryPolicyConfig{Denied: []string{"XX"}})
+//	relay.Reject.Connection = append(relay.Reject.Connection, policy.RejectConnection)
+type CountryPolicy struct {
+	geo          GeoIP
+	allowed      map[string]struct{}
+	denied       map[string]struct{}
+	allowUnknown bool
+}
+
+// NewCountryPolicy returns a [CountryPolicy], or an error if any of the country codes is invalid.
+func NewCountryPolicy(geo GeoIP, config CountryPolicyConfig) (*CountryPolicy, error) {
+	p := &CountryPolicy{
+		geo:          geo,
+		allowed:      make(map[string]struct{}, len(config.Allowed)),
+		denied:       make(map[string]struct{}, len(config.Denied)),
+		allowUnknown: config.AllowUnknown,
+	}
+
+	for _, country := range config.Allowed {
+		if len(country) != 2 {
+			return nil, fmt.Errorf("invalid allowed country %q: must be an ISO 3166-1 alpha-2 code", country)
+		}
+		p.allowed[strings.ToUpper(country)] = struct{}{}
+	}
+
+	for _, country := range config.Denied {
+		if len(country) != 2 {
+			return nil, fmt.Errorf("invalid denied country %q: must be an ISO 3166-1 alpha-2 code", country)
+		}
+		p.denied[strings.ToUpper(country)] = struct{}{}
+	}
+	return p, nil
+}
+
+// RejectConnection is a Reject.Connection hook that refuses the connections from denied countries,
+// or from countries that are not allowed.
+func (p *CountryPolicy) RejectConnection(_ Stats, r *http.Request) error {
+	if !p.Allow(p.geo.Country(IP(r))) {
+		return ErrCountryDenied
+	}
+	return nil
+}
+
+// Allow reports whether the connections from the country are accepted.
+func (p *CountryPolicy) Allow(country string) bool {
+	if _, ok := p.denied[country]; ok {
+		return false
+	}
+
+	if len(p.allowed) == 0 || (country == "" && p.allowUnknown) {
+		return true
+	}
+
+	_, ok := p.allowed[country]
+	return ok
+}
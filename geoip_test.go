@@ -0,0 +1,47 @@
+package rely
+
+import (
+	"net/http"
+	"testing"
+)
+
+type countries map[string]string
+
+func (c countries) Country(ip string) string { return c[ip] }
+
+func TestCountryPolicy(t *testing.T) {
+	geo := countries{"1.1.1.1": "DE", "2.2.2.2": "US", "3.3.3.3": "XX"}
+
+	tests := []struct {
+		name     string
+		config   CountryPolicyConfig
+		ip       string
+		expected error
+	}{
+		{name: "no lists", config: CountryPolicyConfig{}, ip: "1.1.1.1", expected: nil},
+		{name: "denied", config: CountryPolicyConfig{Denied: []string{"xx"}}, ip: "3.3.3.3", expected: ErrCountryDenied},
+		{name: "not denied", config: CountryPolicyConfig{Denied: []string{"XX"}}, ip: "2.2.2.2", expected: nil},
+		{name: "allowed", config: CountryPolicyConfig{Allowed: []string{"DE"}}, ip: "1.1.1.1", expected: nil},
+		{name: "not allowed", config: CountryPolicyConfig{Allowed: []string{"DE"}}, ip: "2.2.2.2", expected: ErrCountryDenied},
+		{name: "unknown", config: CountryPolicyConfig{Allowed: []string{"DE"}}, ip: "10.0.0.1", expected: ErrCountryDenied},
+		{name: "unknown allowed", config: CountryPolicyConfig{Allowed: []string{"DE"}, AllowUnknown: true}, ip: "10.0.0.1", expected: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := NewCountryPolicy(geo, test.config)
+			if err != nil {
+				t.Fatalf("failed to create the policy: %v", err)
+			}
+
+			request := &http.Request{RemoteAddr: test.ip + ":1234", Header: http.Header{}}
+			if err := policy.RejectConnection(nil, request); err != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	if _, err := NewCountryPolicy(geo, CountryPolicyConfig{Denied: []string{"Germany"}}); err == nil {
+		t.Fatal("expected an error for an invalid country code")
+	}
+}
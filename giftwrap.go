@@ -0,0 +1,210 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	ErrGiftWrapRecipient = fmt.Errorf("%w: gift wraps must tag exactly one recipient", ErrInvalid)
+	ErrGiftWrapRateLimit = fmt.Errorf("%w: too many gift wraps for this recipient", ErrRateLimited)
+	ErrGiftWrapAuth      = fmt.Errorf("%w: you must be authenticated to read gift wraps", ErrAuthRequired)
+)
+
+// GiftWrapConfig configures the [GiftWraps] policies.
+type GiftWrapConfig struct {
+	// RecipientRate is the number of gift wraps per second a recipient can receive,
+	// and RecipientBurst the maximum number in a burst.
+	RecipientRate  float64
+	RecipientBurst float64
+
+	// RestrictReads allows only the authenticated recipient to read its gift wraps.
+	RestrictReads bool
+}
+
+// DefaultGiftWrapConfig returns a [GiftWrapConfig] with sane defaults.
+func DefaultGiftWrapConfig() GiftWrapConfig {
+	return GiftWrapConfig{
+		RecipientRate:  1,
+		RecipientBurst: 30,
+		RestrictReads:  true,
+	}
+}
+
+// GiftWraps implements the policies NIP-17 DM relays need for NIP-59 gift wraps (kind 1059).
+// Gift wraps are signed by ephemeral keys, so policies about their author are meaningless:
+// they are skipped with [GiftWraps.Exempt], and gift wraps are instead rate-limited by recipient.
+//
+// Example:
+//
+//	wraps := NewGiftWraps(DefaultGiftWrapConfig())
+//	relay.Reject.Event = append(relay.Reject.Event, wraps.RejectEvent, wraps.Exempt(whitelist))
+//	relay.Reject.Req = append(relay.Reject.Req, wraps.RejectReq)
+//	relay.Reject.Count = append(relay.Reject.Count, wraps.RejectReq)
+//	relay.On.Count = wraps.Count(relay.On.Count)
+//	relay.On.Deliver = ChainDeliver(relay.On.Deliver, wraps.Deliver)
+//	go wraps.Run(ctx)
+type GiftWraps struct {
+	config  GiftWrapConfig
+	limiter *RateLimiter
+}
+
+// NewGiftWraps returns a [GiftWraps] using the provided config.
+func NewGiftWraps(config GiftWrapConfig) *GiftWraps {
+	return &GiftWraps{
+		config:  config,
+		limiter: NewRateLimiter(),
+	}
+}
+
+// Exempt wraps a Reject.Event hook so that it's skipped for gift wraps.
+// Use it for policies about the author, like whitelists or write permissions.
+func (g *GiftWraps) Exempt(reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if e.Kind == nostr.KindGiftWrap {
+			return nil
+		}
+		return reject(ctx, c, e)
+	}
+}
+
+// RejectEvent is a Reject.Event hook that rate-limits gift wraps by their recipient.
+// Events of other kinds are not checked.
+func (g *GiftWraps) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if e.Kind != nostr.KindGiftWrap {
+		return nil
+	}
+
+	recipients := e.Tags.GetAll([]string{"p"})
+	if len(recipients) != 1 || !nostr.IsValid32ByteHex(recipients[0].Value()) {
+		return ErrGiftWrapRecipient
+	}
+
+	if !g.limiter.Allow(recipients[0].Value(), g.config.RecipientRate, g.config.RecipientBurst) {
+		return ErrGiftWrapRateLimit
+	}
+	return nil
+}
+
+// RejectReq is a Reject.Req (or Reject.Count) hook that, if reads are restricted, allows only
+// authenticated clients to request gift wraps, and only those addressed to them.
+// Filters without kinds can match gift wraps too, but they are allowed: the gift wraps
+// they match are withheld by [GiftWraps.Deliver] and not counted by [GiftWraps.Count] instead.
+func (g *GiftWraps) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	if !g.config.RestrictReads {
+		return nil
+	}
+
+	for _, filter := range filters {
+		if !matchesGiftWraps(filter) {
+			continue
+		}
+
+		pubkey := c.Pubkey()
+		if pubkey == "" {
+			return ErrGiftWrapAuth
+		}
+
+		recipients := filter.Tags["p"]
+		if len(recipients) != 1 || recipients[0] != pubkey {
+			return fmt.Errorf("restricted: you can only request the gift wraps of the pubkey %s", pubkey)
+		}
+	}
+	return nil
+}
+
+// matchesGiftWraps reports whether the filter requests gift wraps.
+func matchesGiftWraps(f nostr.Filter) bool {
+	return slices.Contains(f.Kinds, nostr.KindGiftWrap)
+}
+
+// Deliver is an On.Deliver hook that, if reads are restricted, withholds the gift wraps
+// from the clients that are not authenticated as their recipient.
+func (g *GiftWraps) Deliver(c Client, _ string, e *nostr.Event) bool {
+	if !g.config.RestrictReads || e.Kind != nostr.KindGiftWrap {
+		return true
+	}
+
+	pubkey := c.Pubkey()
+	return pubkey != "" && e.Tags.ContainsAny("p", []string{pubkey})
+}
+
+// Count wraps the On.Count hook so that, if reads are restricted, the gift wraps matched by the filters
+// without kinds are not counted, unless they are addressed to the authenticated client.
+func (g *GiftWraps) Count(count func(context.Context, Client, nostr.Filters) (int64, bool, error)) func(context.Context, Client, nostr.Filters) (int64, bool, error) {
+	return func(ctx context.Context, c Client, filters nostr.Filters) (int64, bool, error) {
+		total, approx, err := count(ctx, c, filters)
+		if err != nil || !g.config.RestrictReads {
+			return total, approx, err
+		}
+
+		matched, owned := g.countable(c, filters)
+		if len(matched) == 0 {
+			return total, approx, nil
+		}
+
+		wraps, approxWraps, err := count(ctx, c, matched)
+		if err != nil {
+			return 0, false, err
+		}
+
+		var own int64
+		var approxOwn bool
+		if len(owned) > 0 {
+			if own, approxOwn, err = count(ctx, c, owned); err != nil {
+				return 0, false, err
+			}
+		}
+		return max(0, total-wraps+own), approx || approxWraps || approxOwn, nil
+	}
+}
+
+// countable returns the filters of the gift wraps matched by the filters without kinds,
+// and of those among them addressed to the client.
+func (g *GiftWraps) countable(c Client, filters nostr.Filters) (matched, owned nostr.Filters) {
+	pubkey := c.Pubkey()
+	for _, f := range filters {
+		if len(f.Kinds) > 0 {
+			continue
+		}
+
+		wraps := f.Clone()
+		wraps.Kinds = []int{nostr.KindGiftWrap}
+		matched = append(matched, wraps)
+
+		if pubkey == "" {
+			continue
+		}
+
+		if recipients, ok := f.Tags["p"]; ok && !slices.Contains(recipients, pubkey) {
+			continue
+		}
+
+		own := wraps.Clone()
+		if own.Tags == nil {
+			own.Tags = nostr.TagMap{}
+		}
+		own.Tags["p"] = []string{pubkey}
+		owned = append(owned, own)
+	}
+	return matched, owned
+}
+
+// Run periodically removes the rate limits of idle recipients, until the context is cancelled.
+func (g *GiftWraps) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.limiter.Prune(10 * time.Minute)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestGiftWrapRecipientLimit(t *testing.T) {
+	wraps := NewGiftWraps(GiftWrapConfig{RecipientRate: 0, RecipientBurst: 2})
+	wrap := &nostr.Event{Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", pk}}}
+
+	for i := range 2 {
+		if err := wraps.RejectEvent(context.Background(), nil, wrap); err != nil {
+			t.Fatalf("gift wrap %d should have been accepted, got %v", i, err)
+		}
+	}
+
+	if err := wraps.RejectEvent(context.Background(), nil, wrap); err != ErrGiftWrapRateLimit {
+		t.Fatalf("expected %v, got %v", ErrGiftWrapRateLimit, err)
+	}
+
+	wrap.Tags = nostr.Tags{{"p", pk}, {"p", pk}}
+	if err := wraps.RejectEvent(context.Background(), nil, wrap); err != ErrGiftWrapRecipient {
+		t.Fatalf("expected %v, got %v", ErrGiftWrapRecipient, err)
+	}
+}
+
+func TestMatchesGiftWraps(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   nostr.Filter
+		expected bool
+	}{
+		{name: "gift wrap kind", filter: nostr.Filter{Kinds: []int{1, 1059}}, expected: true},
+		{name: "other kinds", filter: nostr.Filter{Kinds: []int{1, 7}}, expected: false},
+		{name: "no kinds", filter: nostr.Filter{Authors: []string{pk}}, expected: false},
+		{name: "no kinds, ids lookup", filter: nostr.Filter{IDs: []string{pk}}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matchesGiftWraps(test.filter) != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, !test.expected)
+			}
+		})
+	}
+}
+
+func TestGiftWrapDeliver(t *testing.T) {
+	wraps := NewGiftWraps(DefaultGiftWrapConfig())
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	wrap := &nostr.Event{Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", recipient}}}
+	note := &nostr.Event{Kind: 1, Tags: nostr.Tags{{"p", recipient}}}
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		event    *nostr.Event
+		expected bool
+	}{
+		{name: "recipient", pubkey: recipient, event: wrap, expected: true},
+		{name: "unauthenticated", event: wrap},
+		{name: "another pubkey", pubkey: pk, event: wrap},
+		{name: "other kinds", event: note, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := wraps.Deliver(&client{pubkey: test.pubkey}, "sub", test.event); got != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestGiftWrapCount(t *testing.T) {
+	wraps := NewGiftWraps(DefaultGiftWrapConfig())
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	events := []nostr.Event{
+		{Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", recipient}}},
+		{Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", pk}}},
+		{Kind: 1, Tags: nostr.Tags{{"p", recipient}}},
+	}
+
+	count := wraps.Count(func(ctx context.Context, c Client, filters nostr.Filters) (int64, bool, error) {
+		var n int64
+		for _, f := range filters {
+			for _, e := range events {
+				if f.Matches(&e) {
+					n++
+				}
+			}
+		}
+		return n, false, nil
+	})
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		filter   nostr.Filter
+		expected int64
+	}{
+		{name: "unauthenticated", filter: nostr.Filter{}, expected: 1},
+		{name: "victim", filter: nostr.Filter{Tags: nostr.TagMap{"p": {recipient}}}, expected: 1},
+		{name: "recipient", pubkey: recipient, filter: nostr.Filter{}, expected: 2},
+		{name: "recipient, another p tag", pubkey: recipient, filter: nostr.Filter{Tags: nostr.TagMap{"p": {pk}}}, expected: 0},
+		{name: "recipient, its p tag", pubkey: recipient, filter: nostr.Filter{Tags: nostr.TagMap{"p": {recipient, pk}}}, expected: 2},
+		{name: "other kinds", filter: nostr.Filter{Kinds: []int{1}}, expected: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := count(context.Background(), &client{pubkey: test.pubkey}, nostr.Filters{test.filter})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, got)
+			}
+		})
+	}
+}
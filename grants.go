@@ -0,0 +1,94 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrWriteRestricted = fmt.Errorf("%w: this pubkey has no write permission on this relay", ErrRestricted)
+
+// WritePermissions holds time-limited write permissions granted to pubkeys,
+// for example after solving a captcha or paying a Lightning invoice.
+// All methods are safe for concurrent use.
+//
+// Example:
+//
+//	perms := NewWritePermissions()
+//	relay.Reject.Event = append(relay.Reject.Event, perms.Reject)
+//	perms.Grant(pubkey, time.Now().Add(30 * 24 * time.Hour))
+type WritePermissions struct {
+	mu     sync.RWMutex
+	grants map[string]time.Time
+
+	// Message is the reason returned to clients without write permission.
+	// Useful to point them to the registration page. If empty, [ErrWriteRestricted] is used.
+	Message string
+}
+
+// NewWritePermissions returns an empty [WritePermissions].
+func NewWritePermissions() *WritePermissions {
+	return &WritePermissions{grants: make(map[string]time.Time, 1000)}
+}
+
+// Grant write permission to the pubkey until the provided time.
+// If the pubkey already has a longer grant, it's kept.
+func (w *WritePermissions) Grant(pubkey string, until time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if until.After(w.grants[pubkey]) {
+		w.grants[pubkey] = until
+	}
+}
+
+// Revoke the write permission of the pubkey.
+func (w *WritePermissions) Revoke(pubkey string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.grants, pubkey)
+}
+
+// Expiry returns when the write permission of the pubkey expires, and whether it's currently valid.
+func (w *WritePermissions) Expiry(pubkey string) (time.Time, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	until, ok := w.grants[pubkey]
+	return until, ok && time.Now().Before(until)
+}
+
+// Allowed reports whether the pubkey currently has write permission.
+func (w *WritePermissions) Allowed(pubkey string) bool {
+	_, ok := w.Expiry(pubkey)
+	return ok
+}
+
+// Prune removes the expired grants.
+func (w *WritePermissions) Prune() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for pubkey, until := range w.grants {
+		if now.After(until) {
+			delete(w.grants, pubkey)
+		}
+	}
+}
+
+// Reject is a Reject.Event hook that rejects events whose author has no write permission.
+func (w *WritePermissions) Reject(ctx context.Context, c Client, e *nostr.Event) error {
+	if w.Allowed(e.PubKey) {
+		return nil
+	}
+
+	if w.Message != "" {
+		return errors.New(w.Message)
+	}
+	return ErrWriteRestricted
+}
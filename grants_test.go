@@ -0,0 +1,34 @@
+package rely
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestWritePermissions(t *testing.T) {
+	perms := NewWritePermissions()
+	event := &nostr.Event{PubKey: pk}
+
+	if err := perms.Reject(context.Background(), nil, event); err == nil {
+		t.Fatal("expected unregistered pubkey to be rejected")
+	}
+
+	perms.Grant(pk, time.Now().Add(time.Hour))
+	if err := perms.Reject(context.Background(), nil, event); err != nil {
+		t.Fatalf("expected registered pubkey to be accepted, got %v", err)
+	}
+
+	// a shorter grant must not reduce the existing one
+	perms.Grant(pk, time.Now().Add(-time.Minute))
+	if !perms.Allowed(pk) {
+		t.Fatal("shorter grant reduced the existing one")
+	}
+
+	perms.Revoke(pk)
+	if perms.Allowed(pk) {
+		t.Fatal("expected revoked pubkey to be rejected")
+	}
+}
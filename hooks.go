@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -42,6 +43,15 @@ func DefaultHooks() Hooks {
 //
 // These hooks are useful for enforcing access policies, validating input,
 // or applying rate limits before the relay performs further processing.
+//
+// The errors are sent to the clients in the OK and CLOSED messages, prefixed with their
+// machine-readable reason. To choose it, wrap one of [ErrBlocked], [ErrRateLimited], [ErrInvalid],
+// [ErrRestricted], [ErrAuthRequired], [ErrPow], [ErrDuplicate], [ErrMute] and [ErrError]:
+//
+//	return fmt.Errorf("%w: too many events", ErrRateLimited)
+//
+// The context passed to the hooks of the messages is cancelled when the client disconnects, or when the
+// hook timeout expires (see [WithHookTimeout]), and carries the [TraceID] of the message.
 type RejectHooks struct {
 	// Connection is invoked before establishing a new client connection.
 	// Returning a non-nil error rejects the connection.
@@ -49,21 +59,22 @@ type RejectHooks struct {
 
 	// Event is invoked before processing an EVENT message.
 	// Returning a non-nil error rejects the event.
-	Event []func(Client, *nostr.Event) error
+	// Rejected events are reused by the relay, so the hooks must not retain the event pointer.
+	Event []func(context.Context, Client, *nostr.Event) error
 
 	// Req is invoked before processing a REQ message.
 	// Returning a non-nil error rejects the request.
-	Req []func(Client, nostr.Filters) error
+	Req []func(context.Context, Client, nostr.Filters) error
 
 	// Count is invoked before processing a NIP-45 COUNT request.
 	// Returning a non-nil error rejects the request.
-	Count []func(Client, nostr.Filters) error
+	Count []func(context.Context, Client, nostr.Filters) error
 }
 
 func DefaultRejectHooks() RejectHooks {
 	return RejectHooks{
 		Connection: []func(Stats, *http.Request) error{RegistrationFailWithin(3 * time.Second)},
-		Event:      []func(Client, *nostr.Event) error{InvalidID, InvalidSignature},
+		Event:      []func(context.Context, Client, *nostr.Event) error{InvalidID, InvalidSignature},
 	}
 }
 
@@ -86,38 +97,59 @@ type OnHooks struct {
 	//   }
 	Connect func(Client)
 
-	// Disconnect runs immediately after a client has been unregistered and disconnected.
-	// It is guaranteed to run after the Connect hook of the same client.
+	// Disconnect runs immediately after a client has been unregistered and disconnected,
+	// with the reason of the disconnection and the error that caused it (if any), see [DisconnectReason].
+	// It is guaranteed to run after the Connect hook of the same client, including when the relay shuts down.
 	// This callback must be very fast to avoid blocking the hot path.
 	// For longer operations, use goroutines.
 	//
 	// Example:
-	//   relay.On.Disconnect = func(c Client) {
-	//       go longOperation(c)
+	//   relay.On.Disconnect = func(c Client, reason DisconnectReason, err error) {
+	//       log.Printf("client %s disconnected (%s): %v", c.IP(), reason, err)
 	//   }
-	Disconnect func(Client)
+	Disconnect func(c Client, reason DisconnectReason, err error)
 
-	// Auth is called immediately after a client successfully authenticates.
+	// Auth is called immediately after a client successfully authenticates, with NIP-42 or, right after
+	// the Connect hook, with the credentials of its websocket upgrade (see [WithAuthenticators]).
 	// It can be used to load resources tied to the client’s public key or adjust rate limits.
 	Auth func(Client)
 
 	// Event defines how the relay processes an EVENT, for example by storing it in a database.
-	Event func(Client, *nostr.Event) error
+	Event func(context.Context, Client, *nostr.Event) error
 
 	// Req defines how the relay processes a REQ containing one or more filters,
 	// for example by querying the database for matching events.
-	// The provided context is canceled if the client sends the corresponding CLOSE message.
+	// The provided context is canceled if the client sends the corresponding CLOSE message, or disconnects.
 	Req func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)
 
 	// Count defines how the relay processes NIP-45 COUNT requests.
 	// This hook is optional (= nil). If unset, COUNT requests are rejected with [ErrUnsupportedNIP45].
-	Count func(Client, nostr.Filters) (count int64, approx bool, err error)
+	Count func(context.Context, Client, nostr.Filters) (count int64, approx bool, err error)
+
+	// Deliver is evaluated before each event is sent on a subscription, both the stored events of the REQ
+	// and the live ones broadcast to it, with the id of the subscription. Returning false withholds the event
+	// from the client, e.g. to honor the mute list of the authenticated user without altering the stored data.
+	// The withheld stored events don't count towards the maximum events of the subscription.
+	// This hook is optional (= nil). It runs on the hot path of the broadcasts, so it must be very fast,
+	// and must not modify the event, which is shared by all the subscriptions.
+	//
+	// Example:
+	//   relay.On.Deliver = func(c Client, sub string, e *nostr.Event) bool {
+	//       return !muted(c.Pubkey(), e.PubKey)
+	//   }
+	Deliver func(c Client, sub string, e *nostr.Event) bool
+
+	// Unknown is invoked with the raw JSON of the messages whose type is not recognized by the relay,
+	// nor registered with [WithVerb], for example to prototype the messages of a new NIP. The relay then responds according to
+	// its [UnknownMode] (see [WithUnknownMessages]). This hook is optional (= nil).
+	// The raw message is reused after the hook returns, so it must be copied to be retained.
+	Unknown func(ctx context.Context, c Client, raw []byte)
 }
 
 func DefaultOnHooks() OnHooks {
 	return OnHooks{
 		Connect:    func(Client) {},
-		Disconnect: func(Client) {},
+		Disconnect: func(Client, DisconnectReason, error) {},
 		Auth:       func(c Client) {},
 		Event:      logEvent,
 		Req:        logFilters,
@@ -153,7 +185,7 @@ func DefaultWhenHooks() WhenHooks {
 }
 
 // InvalidID returns an error if the event's ID is invalid
-func InvalidID(c Client, e *nostr.Event) error {
+func InvalidID(ctx context.Context, c Client, e *nostr.Event) error {
 	if !e.CheckID() {
 		return ErrInvalidEventID
 	}
@@ -161,7 +193,7 @@ func InvalidID(c Client, e *nostr.Event) error {
 }
 
 // InvalidSignature returns an error if the event's signature is invalid.
-func InvalidSignature(c Client, e *nostr.Event) error {
+func InvalidSignature(ctx context.Context, c Client, e *nostr.Event) error {
 	match, err := e.CheckSignature()
 	if err != nil {
 		return fmt.Errorf("%w: %s", ErrInvalidEventSignature, err.Error())
@@ -173,12 +205,32 @@ func InvalidSignature(c Client, e *nostr.Event) error {
 	return nil
 }
 
+// UnsupportedSearch returns an error if any of the filters has a NIP-50 search.
+// Use it for Reject.Req and Reject.Count when the storage doesn't support search.
+func UnsupportedSearch(ctx context.Context, c Client, filters nostr.Filters) error {
+	for _, filter := range filters {
+		if filter.Search != "" {
+			return ErrUnsupportedNIP50
+		}
+	}
+	return nil
+}
+
+// ExpiredEvent returns an error if the event has a NIP-40 expiration in the past.
+func ExpiredEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if IsExpired(e, nostr.Now()) {
+		return ErrEventExpired
+	}
+	return nil
+}
+
 // RegistrationFailWithin returns a Reject.Connection function that errs
-// if a client registration has failed within the given duration.
+// if a client registration has failed within the given duration, telling the client
+// to retry once the duration has passed.
 func RegistrationFailWithin(d time.Duration) func(Stats, *http.Request) error {
 	return func(s Stats, r *http.Request) error {
-		if time.Since(s.LastRegistrationFail()) < d {
-			return ErrOverloaded
+		if since := time.Since(s.LastRegistrationFail()); since < d {
+			return RetryAfter(ErrOverloaded, d-since)
 		}
 		return nil
 	}
@@ -193,3 +245,21 @@ func DisconnectOnDrops(maxDropped int) func(c Client) {
 		}
 	}
 }
+
+// ChainDeliver returns an On.Deliver function that delivers an event only if all the hooks do,
+// skipping the nil ones. It combines the Deliver hooks of several policies, which would otherwise overwrite each other.
+//
+// Example:
+//
+//	relay.On.Deliver = ChainDeliver(relay.On.Deliver, mutes.Deliver, wraps.Deliver)
+func ChainDeliver(hooks ...func(Client, string, *nostr.Event) bool) func(Client, string, *nostr.Event) bool {
+	hooks = slices.DeleteFunc(hooks, func(hook func(Client, string, *nostr.Event) bool) bool { return hook == nil })
+	return func(c Client, sub string, e *nostr.Event) bool {
+		for _, deliver := range hooks {
+			if !deliver(c, sub, e) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,83 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrNoIdentity = errors.New("the relay has no identity keypair, see WithIdentity")
+
+// Identity is the Nostr keypair of the relay itself, used to sign the events it publishes,
+// like operator announcements, NIP-66 monitoring events and moderation labels.
+type Identity struct {
+	secretKey string
+	publicKey string
+}
+
+// NewIdentity returns the [Identity] of the hex-encoded secret key, or an error if it's invalid.
+func NewIdentity(secretKey string) (*Identity, error) {
+	if !nostr.IsValid32ByteHex(secretKey) {
+		return nil, errors.New("invalid secret key: must be 64 hex characters")
+	}
+
+	publicKey, err := nostr.GetPublicKey(secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	return &Identity{secretKey: secretKey, publicKey: publicKey}, nil
+}
+
+// GenerateIdentity returns an [Identity] with a new random keypair.
+func GenerateIdentity() *Identity {
+	id, err := NewIdentity(nostr.GeneratePrivateKey())
+	if err != nil {
+		panic("failed to generate the relay identity: " + err.Error())
+	}
+	return id
+}
+
+// SecretKey returns the hex-encoded secret key, e.g. to persist a generated identity.
+func (i *Identity) SecretKey() string { return i.secretKey }
+
+// PublicKey returns the hex-encoded public key of the relay.
+func (i *Identity) PublicKey() string { return i.publicKey }
+
+// Sign the event with the relay's secret key, setting its pubkey, ID and signature.
+// The creation time is set to now if it's zero.
+func (i *Identity) Sign(e *nostr.Event) error {
+	if e.CreatedAt == 0 {
+		e.CreatedAt = nostr.Now()
+	}
+	if e.Tags == nil {
+		e.Tags = nostr.Tags{}
+	}
+	return e.Sign(i.secretKey)
+}
+
+// Identity returns the keypair of the relay, or nil if it has none. See [WithIdentity].
+func (r *Relay) Identity() *Identity {
+	return r.identity
+}
+
+// Publish signs the event with the relay's [Identity], stores it with the On.Event hook
+// (called with a nil [Client]), and broadcasts it to the matching subscriptions.
+// Ephemeral events, and those of the fast kinds, are only broadcast.
+func (r *Relay) Publish(e *nostr.Event) error {
+	if r.identity == nil {
+		return ErrNoIdentity
+	}
+
+	if err := r.identity.Sign(e); err != nil {
+		return fmt.Errorf("failed to sign the event: %w", err)
+	}
+
+	if !nostr.IsEphemeralKind(e.Kind) && !r.isFast(e.Kind) {
+		if err := r.On.Event(context.Background(), nil, e); err != nil {
+			return err
+		}
+	}
+	return r.Broadcast(e)
+}
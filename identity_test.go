@@ -0,0 +1,67 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublish(t *testing.T) {
+	id := GenerateIdentity()
+	relay := NewRelay(WithDomain("example.com"), WithIdentity(id))
+
+	if pubkey := relay.Info().PubKey; pubkey != id.PublicKey() {
+		t.Fatalf("expected NIP-11 pubkey %s, got %s", id.PublicKey(), pubkey)
+	}
+
+	var stored *nostr.Event
+	relay.On.Event = func(ctx context.Context, c Client, e *nostr.Event) error {
+		if c != nil {
+			t.Fatalf("expected a nil client, got %v", c)
+		}
+		stored = e
+		return nil
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "scheduled maintenance tonight"}
+	if err := relay.Publish(event); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	if stored != event {
+		t.Fatalf("expected the event to be stored")
+	}
+
+	if event.PubKey != id.PublicKey() || event.CreatedAt == 0 {
+		t.Fatalf("expected the event to be signed by the relay, got %v", event)
+	}
+
+	if ok, err := event.CheckSignature(); !ok {
+		t.Fatalf("invalid signature: %v", err)
+	}
+
+	if broadcast := <-relay.dispatcher.broadcast; broadcast != event {
+		t.Fatalf("expected the event to be broadcast, got %v", broadcast)
+	}
+}
+
+func TestNewIdentity(t *testing.T) {
+	if _, err := NewIdentity("not a key"); err == nil {
+		t.Fatal("expected an error for an invalid secret key")
+	}
+
+	id := GenerateIdentity()
+	same, err := NewIdentity(id.SecretKey())
+	if err != nil {
+		t.Fatalf("failed to load the identity: %v", err)
+	}
+
+	if same.PublicKey() != id.PublicKey() {
+		t.Fatalf("expected pubkey %s, got %s", id.PublicKey(), same.PublicKey())
+	}
+
+	if err := NewRelay(WithDomain("example.com")).Publish(&nostr.Event{}); err != ErrNoIdentity {
+		t.Fatalf("expected %v, got %v", ErrNoIdentity, err)
+	}
+}
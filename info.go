@@ -0,0 +1,208 @@
+package rely
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/goccy/go-json"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// relayInfo holds the NIP-11 document together with its json encoding,
+// so that the encoding is done once per update and not once per request.
+type relayInfo struct {
+	doc  nip11.RelayInformationDocument
+	json []byte
+	auto bool // whether the supported NIPs are computed by the relay
+}
+
+func newRelayInfo() *relayInfo {
+	info, err := encodeInfo(nip11.RelayInformationDocument{
+		Software:      "https://github.com/nostr-net/rely",
+		SupportedNIPs: []any{1, 11, 42},
+	})
+
+	if err != nil {
+		panic("failed to marshal default NIP-11 document: " + err.Error())
+	}
+	info.auto = true
+	return info
+}
+
+func encodeInfo(doc nip11.RelayInformationDocument) (*relayInfo, error) {
+	json, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal NIP-11 document: %w", err)
+	}
+	return &relayInfo{doc: doc, json: json}, nil
+}
+
+// Info returns a copy of the NIP-11 relay information document currently served.
+func (r *Relay) Info() nip11.RelayInformationDocument {
+	return cloneInfo(r.info.Load().doc)
+}
+
+// SetInfo replaces the NIP-11 relay information document served by the relay.
+// It's safe to call at runtime, for example after a configuration reload.
+// If the document has no pubkey, the one of the relay's [Identity] is used.
+// If it has no supported NIPs, or the ones computed by the relay (see [Relay.Supports]), they keep being computed.
+// The limits it doesn't specify are filled with the ones enforced by the relay settings.
+func (r *Relay) SetInfo(info nip11.RelayInformationDocument) error {
+	info = cloneInfo(info)
+	if info.Limitation == nil {
+		info.Limitation = &nip11.RelayLimitationDocument{}
+	}
+	r.settingsLimits(info.Limitation)
+	return r.setInfo(info)
+}
+
+// setInfo replaces the NIP-11 document as it is, apart from its pubkey and supported NIPs.
+func (r *Relay) setInfo(info nip11.RelayInformationDocument) error {
+	if info.PubKey == "" && r.identity != nil {
+		info.PubKey = r.identity.PublicKey()
+	}
+
+	r.infoMu.Lock()
+	defer r.infoMu.Unlock()
+
+	current := r.info.Load()
+	auto := info.SupportedNIPs == nil || (current.auto && slices.Equal(info.SupportedNIPs, current.doc.SupportedNIPs))
+	if auto {
+		info.SupportedNIPs = r.supportedNIPs()
+	}
+
+	encoded, err := encodeInfo(cloneInfo(info))
+	if err != nil {
+		return err
+	}
+
+	encoded.auto = auto
+	r.info.Store(encoded)
+	return nil
+}
+
+// Supports declares that the relay supports the NIPs, for example NIP-50 when the storage implements search.
+//
+// Unless they are set explicitly with [WithInfo] or [Relay.SetInfo], the supported NIPs advertised in the
+// NIP-11 document are computed from the features of the relay, so that they don't drift from what's enabled:
+// NIP-01 and NIP-11, NIP-42 unless [WithoutAuth] is used, NIP-45 if the On.Count hook is set when the relay
+// starts, and the NIPs declared with this method.
+//
+// Example:
+//
+//	relay := rely.NewRelay()
+//	relay.On.Req = storage.Query
+//	relay.Supports(9, 50) // deletion requests and search
+func (r *Relay) Supports(nips ...int) {
+	r.infoMu.Lock()
+	for _, nip := range nips {
+		if !slices.Contains(r.nips, nip) {
+			r.nips = append(r.nips, nip)
+		}
+	}
+	r.infoMu.Unlock()
+	r.refreshNIPs()
+}
+
+// supportedNIPs returns the NIPs supported by the relay, sorted. It must be called holding the infoMu.
+func (r *Relay) supportedNIPs() []any {
+	nips := append([]int{1, 11}, r.nips...)
+	if !r.authDisabled {
+		nips = append(nips, 42)
+	}
+	if r.On.Count != nil {
+		nips = append(nips, 45)
+	}
+
+	slices.Sort(nips)
+	nips = slices.Compact(nips)
+
+	supported := make([]any, len(nips))
+	for i, nip := range nips {
+		supported[i] = nip
+	}
+	return supported
+}
+
+// refreshNIPs recomputes the supported NIPs of the NIP-11 document, unless they were set explicitly.
+func (r *Relay) refreshNIPs() {
+	r.infoMu.Lock()
+	defer r.infoMu.Unlock()
+
+	current := r.info.Load()
+	if !current.auto {
+		return
+	}
+
+	doc := cloneInfo(current.doc)
+	doc.SupportedNIPs = r.supportedNIPs()
+	encoded, err := encodeInfo(doc)
+	if err != nil {
+		r.log.Error("failed to update the supported NIPs", "error", err)
+		return
+	}
+
+	encoded.auto = true
+	r.info.Store(encoded)
+}
+
+// UpdateLimits applies the update function to the limitation section of the NIP-11 document,
+// which is regenerated automatically. It's safe to call at runtime, which is useful when limits
+// are computed dynamically (e.g. the minimum PoW difficulty is raised during a spam attack).
+//
+// Example:
+//
+//	relay.UpdateLimits(func(l *nip11.RelayLimitationDocument) {
+//	    l.MinPowDifficulty = 24
+//	})
+func (r *Relay) UpdateLimits(update func(*nip11.RelayLimitationDocument)) error {
+	r.infoMu.Lock()
+	defer r.infoMu.Unlock()
+
+	doc := cloneInfo(r.info.Load().doc)
+	if doc.Limitation == nil {
+		doc.Limitation = &nip11.RelayLimitationDocument{}
+	}
+	update(doc.Limitation)
+
+	encoded, err := encodeInfo(doc)
+	if err != nil {
+		return err
+	}
+
+	encoded.auto = r.info.Load().auto
+	r.info.Store(encoded)
+	return nil
+}
+
+// settingsLimits fills the unspecified limits with the ones enforced by the relay settings.
+func (r *Relay) settingsLimits(l *nip11.RelayLimitationDocument) {
+	if l.MaxMessageLength == 0 {
+		l.MaxMessageLength = int(r.maxMessageSize)
+	}
+	if l.MaxLimit == 0 {
+		l.MaxLimit = r.responseLimit
+	}
+	if l.MaxSubidLength == 0 {
+		l.MaxSubidLength = 64
+	}
+}
+
+// cloneInfo returns a copy of the document that shares no mutable state with the original.
+func cloneInfo(doc nip11.RelayInformationDocument) nip11.RelayInformationDocument {
+	doc.SupportedNIPs = slices.Clone(doc.SupportedNIPs)
+	doc.RelayCountries = slices.Clone(doc.RelayCountries)
+	doc.LanguageTags = slices.Clone(doc.LanguageTags)
+	doc.Tags = slices.Clone(doc.Tags)
+	doc.Retention = slices.Clone(doc.Retention)
+
+	if doc.Limitation != nil {
+		limitation := *doc.Limitation
+		doc.Limitation = &limitation
+	}
+	if doc.Fees != nil {
+		fees := *doc.Fees
+		doc.Fees = &fees
+	}
+	return doc
+}
@@ -0,0 +1,138 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+func TestUpdateLimits(t *testing.T) {
+	relay := NewRelay(
+		WithDomain("example.com"),
+		WithInfo(nip11.RelayInformationDocument{Name: "test"}),
+	)
+
+	relay.UpdateLimits(func(l *nip11.RelayLimitationDocument) {
+		l.MinPowDifficulty = 24
+	})
+
+	w := httptest.NewRecorder()
+	relay.ServeNIP11(w)
+
+	var served nip11.RelayInformationDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("failed to unmarshal served document: %v", err)
+	}
+
+	if served.Name != "test" {
+		t.Fatalf("expected name %q, got %q", "test", served.Name)
+	}
+
+	if served.Limitation == nil {
+		t.Fatal("expected limitation document, got nil")
+	}
+
+	if served.Limitation.MinPowDifficulty != 24 {
+		t.Fatalf("expected min pow difficulty 24, got %d", served.Limitation.MinPowDifficulty)
+	}
+
+	if served.Limitation.MaxMessageLength != int(maxMessageSize) {
+		t.Fatalf("expected max message length %d, got %d", maxMessageSize, served.Limitation.MaxMessageLength)
+	}
+}
+
+func TestSetInfoLimits(t *testing.T) {
+	relay := NewRelay(
+		WithDomain("example.com"),
+		WithInfo(nip11.RelayInformationDocument{Name: "test"}),
+		WithMaxMessageSize(1000),
+		WithClientResponseLimit(100),
+	)
+
+	if l := relay.Info().Limitation; l.MaxMessageLength != 1000 || l.MaxLimit != 100 {
+		t.Fatalf("expected the limits of the settings, got %+v", l)
+	}
+
+	// the document replaced at runtime keeps advertising the limits it doesn't specify
+	if err := relay.SetInfo(nip11.RelayInformationDocument{Name: "reloaded"}); err != nil {
+		t.Fatalf("failed to set the info: %v", err)
+	}
+
+	if l := relay.Info().Limitation; l == nil || l.MaxMessageLength != 1000 || l.MaxLimit != 100 || l.MaxSubidLength != 64 {
+		t.Fatalf("expected the limits of the settings, got %+v", l)
+	}
+
+	limitation := &nip11.RelayLimitationDocument{MaxLimit: 10, MinPowDifficulty: 20}
+	if err := relay.SetInfo(nip11.RelayInformationDocument{Limitation: limitation}); err != nil {
+		t.Fatalf("failed to set the info: %v", err)
+	}
+
+	if l := relay.Info().Limitation; l.MaxLimit != 10 || l.MinPowDifficulty != 20 || l.MaxMessageLength != 1000 {
+		t.Fatalf("expected the specified limits with the ones of the settings, got %+v", l)
+	}
+}
+
+func TestInfoIsCopy(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+
+	info := relay.Info()
+	info.Limitation.MaxLimit = 1
+	info.SupportedNIPs[0] = 69
+
+	if relay.Info().Limitation.MaxLimit == 1 {
+		t.Fatal("modifying the returned limitation changed the relay info")
+	}
+
+	if relay.Info().SupportedNIPs[0] == 69 {
+		t.Fatal("modifying the returned supported nips changed the relay info")
+	}
+}
+
+func TestSupportedNIPs(t *testing.T) {
+	nips := func(r *Relay) []any { return r.Info().SupportedNIPs }
+
+	relay := NewRelay()
+	if expected := []any{1, 11, 42}; !slices.Equal(nips(relay), expected) {
+		t.Fatalf("expected %v, got %v", expected, nips(relay))
+	}
+
+	relay = NewRelay(WithoutAuth())
+	relay.On.Count = func(context.Context, Client, nostr.Filters) (int64, bool, error) { return 0, false, nil }
+	relay.Supports(50, 9, 50)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay.Start(ctx)
+
+	if expected := []any{1, 9, 11, 45, 50}; !slices.Equal(nips(relay), expected) {
+		t.Fatalf("expected %v, got %v", expected, nips(relay))
+	}
+
+	// updating other fields keeps the NIPs computed
+	info := relay.Info()
+	info.Name = "test"
+	if err := relay.SetInfo(info); err != nil {
+		t.Fatalf("failed to set the info: %v", err)
+	}
+
+	relay.Supports(40)
+	if expected := []any{1, 9, 11, 40, 45, 50}; !slices.Equal(nips(relay), expected) {
+		t.Fatalf("expected %v, got %v", expected, nips(relay))
+	}
+
+	// explicit NIPs are never overwritten
+	info.SupportedNIPs = []any{1}
+	if err := relay.SetInfo(info); err != nil {
+		t.Fatalf("failed to set the info: %v", err)
+	}
+
+	relay.Supports(70)
+	if expected := []any{1}; !slices.Equal(nips(relay), expected) {
+		t.Fatalf("expected %v, got %v", expected, nips(relay))
+	}
+}
@@ -0,0 +1,38 @@
+package rely
+
+import (
+	"context"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// OnKind registers a handler for the EVENTs of the kind, to attach specialized logic (validation, side effects)
+// to specific kinds without a switch inside the On.Event hook. The handlers of the kind run in order of registration,
+// after the Reject.Event hooks and before On.Event. Returning a non-nil error rejects the event, without running
+// the following handlers nor On.Event. Events of the fast kinds (see [WithFastKinds]) bypass the handlers.
+//
+// OnKind must be called before the relay starts serving clients.
+//
+// Example:
+//
+//	relay.OnKind(nostr.KindZap, func(ctx context.Context, c Client, e *nostr.Event) error {
+//		if !validZapReceipt(e) {
+//			return fmt.Errorf("%w: invalid zap receipt", ErrInvalid)
+//		}
+//		return nil
+//	})
+func (r *Relay) OnKind(kind int, handler func(context.Context, Client, *nostr.Event) error) {
+	if r.kindHandlers == nil {
+		r.kindHandlers = make(map[int][]func(context.Context, Client, *nostr.Event) error)
+	}
+	r.kindHandlers[kind] = append(r.kindHandlers[kind], handler)
+}
+
+// handleKind runs the handlers of the event's kind, returning the first error.
+func (r *Relay) handleKind(ctx context.Context, c Client, e *nostr.Event) error {
+	for _, handler := range r.kindHandlers[e.Kind] {
+		if err := handler(ctx, c, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOnKind(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+
+	var stored, zaps int
+	relay.On.Event = func(context.Context, Client, *nostr.Event) error { stored++; return nil }
+	relay.OnKind(nostr.KindZap, func(ctx context.Context, c Client, e *nostr.Event) error { zaps++; return nil })
+	relay.OnKind(nostr.KindZap, func(ctx context.Context, c Client, e *nostr.Event) error {
+		if e.Content != "" {
+			return fmt.Errorf("%w: zap receipts must have no content", ErrInvalid)
+		}
+		return nil
+	})
+
+	client := &client{relay: relay, responses: make(chan response, 10)}
+	events := []*nostr.Event{
+		{ID: "note", Kind: nostr.KindTextNote, Content: "hello"},
+		{ID: "zap", Kind: nostr.KindZap},
+		{ID: "invalid", Kind: nostr.KindZap, Content: "hello"},
+	}
+
+	for _, event := range events {
+		relay.processor.Process(eventRequest{client: client, Event: event, receivedAt: time.Now()})
+	}
+
+	expected := []okResponse{
+		{ID: "note", Saved: true},
+		{ID: "zap", Saved: true},
+		{ID: "invalid", Saved: false, Reason: "invalid: zap receipts must have no content"},
+	}
+
+	for _, exp := range expected {
+		if ok := (<-client.responses).(okResponse); ok != exp {
+			t.Fatalf("expected %+v, got %+v", exp, ok)
+		}
+	}
+
+	if stored != 2 || zaps != 2 {
+		t.Fatalf("expected 2 stored events and 2 zaps handled, got %d and %d", stored, zaps)
+	}
+}
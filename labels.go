@@ -0,0 +1,167 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const KindLabel = 1985
+
+// LabelerConfig configures the [Labeler].
+type LabelerConfig struct {
+	// Namespace of the labels (the "L" tag), e.g. "com.example.moderation".
+	Namespace string
+
+	// Cooldown is the minimum time between two labels of the same target with the same label,
+	// so that a flood of flagged events doesn't become a flood of labels.
+	Cooldown time.Duration
+
+	// QueueSize is the number of labels waiting to be published. When full, new labels are dropped.
+	QueueSize int
+}
+
+// DefaultLabelerConfig returns a [LabelerConfig] with sane defaults.
+func DefaultLabelerConfig() LabelerConfig {
+	return LabelerConfig{
+		Namespace: "moderation",
+		Cooldown:  time.Hour,
+		QueueSize: 1000,
+	}
+}
+
+// Labeler turns the outputs of the moderation policies into NIP-32 label events (kind 1985),
+// signed with the relay's [Identity], stored with the On.Event hook and broadcast to the subscribers,
+// so that clients can query and respect the relay-level moderation.
+// See https://github.com/nostr-protocol/nips/blob/master/32.md
+//
+// Example:
+//
+//	labeler, err := NewLabeler(relay, DefaultLabelerConfig())
+//	relay.Reject.Event = append(relay.Reject.Event, labeler.Flag("spam", detector.Reject))
+//	go labeler.Run(ctx)
+type Labeler struct {
+	relay  *Relay
+	config LabelerConfig
+	queue  chan *nostr.Event
+
+	mu   sync.Mutex
+	last map[string]time.Time // target and label -> last labeled
+}
+
+// NewLabeler returns a [Labeler] of the relay, or an error if the relay has no [Identity] or the config is invalid.
+func NewLabeler(relay *Relay, config LabelerConfig) (*Labeler, error) {
+	if relay.Identity() == nil {
+		return nil, ErrNoIdentity
+	}
+
+	if config.Namespace == "" {
+		return nil, errors.New("the labels namespace is required")
+	}
+
+	if config.QueueSize <= 0 {
+		return nil, errors.New("the labels queue size must be positive")
+	}
+
+	return &Labeler{
+		relay:  relay,
+		config: config,
+		queue:  make(chan *nostr.Event, config.QueueSize),
+		last:   make(map[string]time.Time),
+	}, nil
+}
+
+// Flag wraps the Reject.Event hook, labeling the author of every event it rejects with the label.
+// The reason of the label is the rejection error.
+func (l *Labeler) Flag(label string, reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		err := reject(ctx, c, e)
+		if err != nil {
+			l.LabelPubkey(e.PubKey, label, err.Error())
+		}
+		return err
+	}
+}
+
+// LabelEvent labels the event and its author, without blocking. The reason is the content of the label event.
+// It reports whether the label was queued, which is false during the cooldown or when the queue is full.
+func (l *Labeler) LabelEvent(e *nostr.Event, label, reason string) bool {
+	targets := nostr.Tags{{"e", e.ID}, {"p", e.PubKey}}
+	return l.label(e.ID, targets, label, reason)
+}
+
+// LabelPubkey labels the pubkey, without blocking. The reason is the content of the label event.
+// It reports whether the label was queued, which is false during the cooldown or when the queue is full.
+func (l *Labeler) LabelPubkey(pubkey, label, reason string) bool {
+	return l.label(pubkey, nostr.Tags{{"p", pubkey}}, label, reason)
+}
+
+func (l *Labeler) label(target string, targets nostr.Tags, label, reason string) bool {
+	if !l.cooled(target+":"+label, time.Now()) {
+		return false
+	}
+
+	tags := nostr.Tags{{"L", l.config.Namespace}, {"l", label, l.config.Namespace}}
+	event := &nostr.Event{
+		Kind:      KindLabel,
+		CreatedAt: nostr.Now(),
+		Tags:      append(tags, targets...),
+		Content:   reason,
+	}
+
+	select {
+	case l.queue <- event:
+		return true
+	default:
+		l.relay.log.Warn("labels queue is full, dropping label", "target", target, "label", label)
+		return false
+	}
+}
+
+// cooled reports whether the key is out of its cooldown, starting a new one if so.
+func (l *Labeler) cooled(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.config.Cooldown {
+		return false
+	}
+	l.last[key] = now
+	return true
+}
+
+// Run publishes the queued labels with [Relay.Publish], and periodically forgets the expired cooldowns,
+// until the context is cancelled.
+func (l *Labeler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event := <-l.queue:
+			if err := l.relay.Publish(event); err != nil {
+				l.relay.log.Error("failed to publish label", "error", err)
+			}
+
+		case now := <-ticker.C:
+			l.prune(now)
+		}
+	}
+}
+
+func (l *Labeler) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, last := range l.last {
+		if now.Sub(last) >= l.config.Cooldown {
+			delete(l.last, key)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestLabelerFlag(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithIdentity(GenerateIdentity()))
+	labeler, err := NewLabeler(relay, DefaultLabelerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errSpam := errors.New("blocked: spam")
+	reject := labeler.Flag("spam", func(ctx context.Context, _ Client, e *nostr.Event) error {
+		if e.Content == "spam" {
+			return errSpam
+		}
+		return nil
+	})
+
+	if err := reject(context.Background(), &client{}, &nostr.Event{PubKey: pk, Content: "hello"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(labeler.queue) != 0 {
+		t.Fatalf("expected no labels, got %d", len(labeler.queue))
+	}
+
+	for range 3 {
+		if err := reject(context.Background(), &client{}, &nostr.Event{PubKey: pk, Content: "spam"}); err != errSpam {
+			t.Fatalf("expected %v, got %v", errSpam, err)
+		}
+	}
+
+	if len(labeler.queue) != 1 {
+		t.Fatalf("expected 1 label because of the cooldown, got %d", len(labeler.queue))
+	}
+
+	label := <-labeler.queue
+	if label.Kind != KindLabel || label.Content != errSpam.Error() {
+		t.Fatalf("unexpected label %v", label)
+	}
+
+	if p := label.Tags.Find("p"); p == nil || p[1] != pk {
+		t.Fatalf("expected the label to target %s, got %v", pk, label.Tags)
+	}
+
+	if l := label.Tags.Find("l"); l == nil || l[1] != "spam" || l[2] != "moderation" {
+		t.Fatalf("expected the spam label, got %v", label.Tags)
+	}
+}
+
+func TestLabelerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"), WithIdentity(GenerateIdentity()))
+	stored := make(chan *nostr.Event, 1)
+	relay.On.Event = func(ctx context.Context, _ Client, e *nostr.Event) error {
+		stored <- e
+		return nil
+	}
+
+	labeler, err := NewLabeler(relay, DefaultLabelerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go labeler.Run(ctx)
+
+	event := &nostr.Event{ID: "abc", PubKey: pk}
+	if !labeler.LabelEvent(event, "nsfw", "") {
+		t.Fatal("expected the label to be queued")
+	}
+
+	select {
+	case label := <-stored:
+		if label.PubKey != relay.Identity().PublicKey() {
+			t.Fatalf("expected the label to be signed by the relay, got %s", label.PubKey)
+		}
+		if e := label.Tags.Find("e"); e == nil || e[1] != event.ID {
+			t.Fatalf("expected the label to target the event, got %v", label.Tags)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("the label was not stored")
+	}
+}
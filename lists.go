@@ -0,0 +1,145 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The kinds of the NIP-58 badges.
+// See https://github.com/nostr-protocol/nips/blob/master/58.md
+const (
+	KindBadgeAward      = 8
+	KindProfileBadges   = 30008
+	KindBadgeDefinition = 30009
+)
+
+var (
+	ErrInvalidList  = fmt.Errorf("%w: list", ErrInvalid)
+	ErrInvalidBadge = fmt.Errorf("%w: badge", ErrInvalid)
+)
+
+// listKinds are the kinds of the NIP-51 standard lists (replaceable) and sets (addressable).
+// See https://github.com/nostr-protocol/nips/blob/master/51.md
+var listKinds = []int{
+	10000, 10001, 10003, 10004, 10005, 10006, 10007, 10009, 10012, 10015, 10020, 10030, 10050, 10101, 10102,
+	30000, 30002, 30003, 30004, 30005, 30007, 30015, 30030, 39089, 39092,
+}
+
+// IsList reports whether the kind is a NIP-51 list or set.
+func IsList(kind int) bool {
+	return slices.Contains(listKinds, kind)
+}
+
+// InvalidReferences is a Reject.Event hook rejecting the NIP-51 lists and the NIP-58 badges
+// that are invalid, see [ValidateList] and [ValidateBadge].
+func InvalidReferences(ctx context.Context, c Client, e *nostr.Event) error {
+	if err := ValidateList(e); err != nil {
+		return err
+	}
+	return ValidateBadge(e)
+}
+
+// ValidateList returns an error wrapping [ErrInvalidList] if the NIP-51 list or set has an invalid "a" tag,
+// which must be the address of a replaceable or addressable event ("<kind>:<pubkey>:<d>"), or if the set
+// doesn't have a d tag. Events of other kinds are always valid.
+func ValidateList(e *nostr.Event) error {
+	if !IsList(e.Kind) {
+		return nil
+	}
+
+	if nostr.IsAddressableKind(e.Kind) && e.Tags.GetD() == "" {
+		return fmt.Errorf("%w: the set must have a d tag", ErrInvalidList)
+	}
+
+	for _, tag := range e.Tags {
+		if len(tag) > 0 && tag[0] == "a" && !validAddress(tag) {
+			return fmt.Errorf("%w: invalid a tag %q", ErrInvalidList, tag)
+		}
+	}
+	return nil
+}
+
+// ValidateBadge returns an error wrapping [ErrInvalidBadge] if the NIP-58 badge event is invalid:
+//   - the badge definitions (kind 30009) must have a d tag.
+//   - the badge awards (kind 8) must have the "a" tag of a badge definition of their author, and the "p" tags
+//     of the awarded pubkeys.
+//   - the profile badges (kind 30008) must have the "profile_badges" d tag, and their "a" tags
+//     must be the addresses of badge definitions, each followed by the "e" tag of its award.
+//
+// Events of other kinds are always valid.
+func ValidateBadge(e *nostr.Event) error {
+	switch e.Kind {
+	case KindBadgeDefinition:
+		if e.Tags.GetD() == "" {
+			return fmt.Errorf("%w: the badge definition must have a d tag", ErrInvalidBadge)
+		}
+
+	case KindBadgeAward:
+		definition := e.Tags.Find("a")
+		if !validAddress(definition) {
+			return fmt.Errorf("%w: the award must have the a tag of the badge definition", ErrInvalidBadge)
+		}
+
+		kind, pubkey, _, _ := parseAddress(definition[1])
+		if kind != KindBadgeDefinition || pubkey != e.PubKey {
+			return fmt.Errorf("%w: the award must reference a badge definition of its author", ErrInvalidBadge)
+		}
+
+		awarded := 0
+		for _, tag := range e.Tags {
+			if len(tag) > 0 && tag[0] == "p" {
+				if len(tag) < 2 || !nostr.IsValidPublicKey(tag[1]) {
+					return fmt.Errorf("%w: invalid p tag %q", ErrInvalidBadge, tag)
+				}
+				awarded++
+			}
+		}
+
+		if awarded == 0 {
+			return fmt.Errorf("%w: the award must have the p tags of the awarded pubkeys", ErrInvalidBadge)
+		}
+
+	case KindProfileBadges:
+		if e.Tags.GetD() != "profile_badges" {
+			return fmt.Errorf("%w: the profile badges must have the profile_badges d tag", ErrInvalidBadge)
+		}
+
+		for i, tag := range e.Tags {
+			if len(tag) == 0 || tag[0] != "a" {
+				continue
+			}
+
+			if !validAddress(tag) {
+				return fmt.Errorf("%w: invalid a tag %q", ErrInvalidBadge, tag)
+			}
+			if kind, _, _, _ := parseAddress(tag[1]); kind != KindBadgeDefinition {
+				return fmt.Errorf("%w: the a tag %q is not a badge definition", ErrInvalidBadge, tag[1])
+			}
+
+			if i+1 >= len(e.Tags) || len(e.Tags[i+1]) < 2 || e.Tags[i+1][0] != "e" || !nostr.IsValid32ByteHex(e.Tags[i+1][1]) {
+				return fmt.Errorf("%w: the a tag %q must be followed by the e tag of the award", ErrInvalidBadge, tag[1])
+			}
+		}
+	}
+	return nil
+}
+
+// validAddress reports whether the "a" tag is the address of a replaceable or addressable event.
+func validAddress(tag nostr.Tag) bool {
+	if len(tag) < 2 {
+		return false
+	}
+
+	kind, _, d, ok := parseAddress(tag[1])
+	switch {
+	case !ok:
+		return false
+	case nostr.IsReplaceableKind(kind):
+		return d == ""
+	default:
+		return nostr.IsAddressableKind(kind)
+	}
+}
@@ -0,0 +1,50 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestInvalidReferences(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	badge := "30009:" + pk + ":bravery"
+	award := "5d2899290e0e69bcd809949ee516a4a1597205390878f780c098707a7f18e3df"
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		expected error
+	}{
+		{name: "note", event: &nostr.Event{Kind: 1, Tags: nostr.Tags{{"a", "invalid"}}}},
+		{name: "bookmarks", event: &nostr.Event{Kind: 10003, Tags: nostr.Tags{{"a", "30023:" + pk + ":hello"}, {"e", award}}}},
+		{name: "bookmarks with invalid address", event: &nostr.Event{Kind: 10003, Tags: nostr.Tags{{"a", "30023:abc:hello"}}}, expected: ErrInvalidList},
+		{name: "replaceable address with d", event: &nostr.Event{Kind: 10003, Tags: nostr.Tags{{"a", "10002:" + pk + ":x"}}}, expected: ErrInvalidList},
+		{name: "regular kind address", event: &nostr.Event{Kind: 10003, Tags: nostr.Tags{{"a", "1:" + pk + ":"}}}, expected: ErrInvalidList},
+		{name: "follow set", event: &nostr.Event{Kind: 30000, Tags: nostr.Tags{{"d", "friends"}, {"p", pk}}}},
+		{name: "follow set without d", event: &nostr.Event{Kind: 30000, Tags: nostr.Tags{{"p", pk}}}, expected: ErrInvalidList},
+		{name: "definition", event: &nostr.Event{Kind: KindBadgeDefinition, Tags: nostr.Tags{{"d", "bravery"}}}},
+		{name: "definition without d", event: &nostr.Event{Kind: KindBadgeDefinition}, expected: ErrInvalidBadge},
+		{name: "award", event: &nostr.Event{Kind: KindBadgeAward, PubKey: pk, Tags: nostr.Tags{{"a", badge}, {"p", other}}}},
+		{name: "award of another author", event: &nostr.Event{Kind: KindBadgeAward, PubKey: other, Tags: nostr.Tags{{"a", badge}, {"p", pk}}}, expected: ErrInvalidBadge},
+		{name: "award without p", event: &nostr.Event{Kind: KindBadgeAward, PubKey: pk, Tags: nostr.Tags{{"a", badge}}}, expected: ErrInvalidBadge},
+		{name: "profile badges", event: &nostr.Event{Kind: KindProfileBadges, Tags: nostr.Tags{{"d", "profile_badges"}, {"a", badge}, {"e", award}}}},
+		{name: "profile badges without award", event: &nostr.Event{Kind: KindProfileBadges, Tags: nostr.Tags{{"d", "profile_badges"}, {"a", badge}}}, expected: ErrInvalidBadge},
+		{name: "profile badges with another d", event: &nostr.Event{Kind: KindProfileBadges, Tags: nostr.Tags{{"d", "badges"}}}, expected: ErrInvalidBadge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := InvalidReferences(context.Background(), nil, test.event)
+			if test.expected == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expected != nil && !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
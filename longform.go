@@ -0,0 +1,79 @@
+package rely
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The kinds of the NIP-23 long-form content.
+// See https://github.com/nostr-protocol/nips/blob/master/23.md
+const (
+	KindLongForm      = 30023
+	KindLongFormDraft = 30024
+)
+
+// IsLongForm reports whether the kind is a NIP-23 long-form article or draft.
+func IsLongForm(kind int) bool {
+	return kind == KindLongForm || kind == KindLongFormDraft
+}
+
+// PublishedAt returns the time of the "published_at" tag of the long-form article, which its edits keep,
+// or its created_at if the tag is missing or invalid.
+func PublishedAt(e *nostr.Event) nostr.Timestamp {
+	published, err := strconv.ParseInt(tagValue(e, "published_at"), 10, 64)
+	if err != nil || published <= 0 {
+		return e.CreatedAt
+	}
+	return nostr.Timestamp(published)
+}
+
+// SortLongForm sorts in-place the events by [PublishedAt], newest first, if all the filters only request
+// long-form articles and drafts, as blog clients expect. Otherwise it leaves them in the storage order.
+// Use it in On.Req, where the results of the filters are merged.
+func SortLongForm(filters nostr.Filters, events []nostr.Event) {
+	for _, filter := range filters {
+		if len(filter.Kinds) == 0 || slices.ContainsFunc(filter.Kinds, func(k int) bool { return !IsLongForm(k) }) {
+			return
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b nostr.Event) int {
+		if c := cmp.Compare(PublishedAt(&b), PublishedAt(&a)); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.CreatedAt, a.CreatedAt)
+	})
+}
+
+// MaxEventSize returns a Reject.Event hook rejecting the events whose JSON is bigger than the size in bytes,
+// or than the size of their kind if it's in the kinds, e.g. to accept bigger long-form articles:
+//
+//	relay.Reject.Event = append(relay.Reject.Event, MaxEventSize(64<<10, map[int]int{
+//		KindLongForm:      256 << 10,
+//		KindLongFormDraft: 256 << 10,
+//	}))
+//
+// The size of the events is also bounded by the maximum size of the messages, see [WithMaxMessageSize].
+func MaxEventSize(size int, kinds map[int]int) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		limit, ok := kinds[e.Kind]
+		if !ok {
+			limit = size
+		}
+
+		data, err := e.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+
+		if len(data) > limit {
+			return fmt.Errorf("%w (%d bytes), the maximum for kind %d is %d bytes", ErrEventTooLarge, len(data), e.Kind, limit)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSortLongForm(t *testing.T) {
+	events := []nostr.Event{
+		{ID: "edited", Kind: KindLongForm, CreatedAt: 300, Tags: nostr.Tags{{"published_at", "100"}}},
+		{ID: "recent", Kind: KindLongForm, CreatedAt: 200, Tags: nostr.Tags{{"published_at", "200"}}},
+		{ID: "untagged", Kind: KindLongForm, CreatedAt: 150},
+	}
+
+	SortLongForm(nostr.Filters{{Kinds: []int{KindLongForm, 1}}}, events)
+	if events[0].ID != "edited" {
+		t.Fatalf("expected the events of mixed kinds to be unchanged, got %s first", events[0].ID)
+	}
+
+	SortLongForm(nostr.Filters{{Kinds: []int{KindLongForm}, Authors: []string{"abc"}}}, events)
+	for i, id := range []string{"recent", "untagged", "edited"} {
+		if events[i].ID != id {
+			t.Errorf("expected %s at %d, got %s", id, i, events[i].ID)
+		}
+	}
+}
+
+func TestMaxEventSize(t *testing.T) {
+	reject := MaxEventSize(1000, map[int]int{KindLongForm: 10_000})
+	content := strings.Repeat("a", 5000)
+
+	err := reject(context.Background(), nil, &nostr.Event{Kind: 1, Content: content})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected the note to be too large, got %v", err)
+	}
+
+	if err := reject(context.Background(), nil, &nostr.Event{Kind: KindLongForm, Content: content}); err != nil {
+		t.Fatalf("expected the article to be accepted, got %v", err)
+	}
+
+	if err := reject(context.Background(), nil, &nostr.Event{Kind: 1, Content: "hello"}); err != nil {
+		t.Fatalf("expected the small note to be accepted, got %v", err)
+	}
+}
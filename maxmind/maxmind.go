@@ -0,0 +1,346 @@
+// Package maxmind reads the country of IP addresses from MaxMind DB files (e.g. GeoLite2-Country.mmdb),
+// for [github.com/nostr-net/rely.WithGeoIP]. The files are untrusted input, so the reader validates
+// the structure of the file when opening it, and the lookups of corrupt records return an unknown
+// country instead of failing.
+//
+// See https://maxmind.github.io/MaxMind-DB/
+package maxmind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata section at the end of MaxMind DB files.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxDepth is the maximum nesting of the decoded values, including the pointers followed, and maxValues
+// the maximum number of values decoded at once, so that corrupt files can't recurse or expand without end.
+const (
+	maxDepth  = 32
+	maxValues = 1 << 16
+)
+
+// DB is a minimal reader of MaxMind DB files (e.g. GeoLite2-Country.mmdb),
+// implementing [github.com/nostr-net/rely.GeoIP] with the country of the IPs.
+type DB struct {
+	tree       []byte // the binary search tree
+	section    []byte // the data section
+	nodeCount  uint
+	recordSize uint
+	ipv4Start  uint // node where the IPv4 addresses start in an IPv6 tree
+	ipVersion  uint
+}
+
+// Open reads the MaxMind DB file in memory.
+func Open(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the GeoIP database: %w", err)
+	}
+	return parse(data)
+}
+
+// parse the content of a MaxMind DB file, validating the metadata and the size of the search tree.
+func parse(data []byte) (*DB, error) {
+	i := bytes.LastIndex(data, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("invalid GeoIP database: metadata not found")
+	}
+
+	db := &DB{}
+	metadata, _, err := db.decode(data[i+len(metadataMarker):], 0, 0, new(int))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GeoIP database metadata: %w", err)
+	}
+
+	fields, ok := metadata.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid GeoIP database metadata: not a map")
+	}
+
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("invalid GeoIP database: unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("invalid GeoIP database: unsupported IP version %d", ipVersion)
+	}
+
+	db.nodeCount = uint(nodeCount)
+	db.recordSize = uint(recordSize)
+	db.ipVersion = uint(ipVersion)
+
+	// the node count is checked before computing the size, which could overflow
+	if nodeCount > uint64(i)/uint64(db.recordSize/4) || db.nodeCount*db.recordSize/4+16 > uint(i) {
+		return nil, errors.New("invalid GeoIP database: truncated search tree")
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+
+	db.tree = data[:treeSize]
+	db.section = data[treeSize+16 : i]
+
+	if db.ipVersion == 6 {
+		// IPv4 addresses are mapped to ::a.b.c.d, after 96 zero bits
+		node := uint(0)
+		for range 96 {
+			if node >= db.nodeCount {
+				break
+			}
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Country returns the ISO code of the country of the IP, or of the country where
+// its network is registered, or an empty string if unknown.
+func (db *DB) Country(ip string) string {
+	record, err := db.lookup(net.ParseIP(ip))
+	if err != nil || record == nil {
+		return ""
+	}
+
+	fields, _ := record.(map[string]any)
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]any)
+		if code, ok := country["iso_code"].(string); ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// lookup returns the record of the IP, or nil if it's not in the database.
+func (db *DB) lookup(ip net.IP) (any, error) {
+	if ip == nil {
+		return nil, errors.New("invalid IP")
+	}
+
+	node := uint(0)
+	bits := net.IP(ip.To16())
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+
+	case node > db.nodeCount:
+		offset := node - db.nodeCount - 16
+		if offset >= uint(len(db.section)) {
+			return nil, errors.New("invalid data pointer")
+		}
+		value, _, err := db.decode(db.section, offset, 0, new(int))
+		return value, err
+
+	default:
+		return nil, errors.New("invalid search tree")
+	}
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the node.
+func (db *DB) record(node, bit uint) uint {
+	size := db.recordSize / 4 // bytes per node
+	b := db.tree[node*size : (node+1)*size]
+
+	switch db.recordSize {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+
+	default:
+		if bit == 0 {
+			return uint(binary.BigEndian.Uint32(b[0:4]))
+		}
+		return uint(binary.BigEndian.Uint32(b[4:8]))
+	}
+}
+
+var (
+	errTruncated = errors.New("truncated data")
+	errTooLarge  = errors.New("too deeply nested or too many values")
+)
+
+// decode the value at the offset of the section, nested at the depth, returning it with the offset following it.
+// The values decoded are counted, since the start of the decoding.
+func (db *DB) decode(section []byte, offset uint, depth int, values *int) (any, uint, error) {
+	if offset >= uint(len(section)) {
+		return nil, 0, errTruncated
+	}
+
+	*values++
+	if depth > maxDepth || *values > maxValues {
+		return nil, 0, errTooLarge
+	}
+
+	control := section[offset]
+	offset++
+
+	kind := uint(control >> 5)
+	if kind == 1 {
+		// pointers into the data section, followed once
+		pointer, next, err := db.pointer(section, offset, uint(control&0x1f))
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := db.decode(section, pointer, depth+1, values)
+		return value, next, err
+	}
+
+	if kind == 0 {
+		if offset >= uint(len(section)) {
+			return nil, 0, errTruncated
+		}
+		kind = 7 + uint(section[offset])
+		offset++
+	}
+
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return nil, 0, errTruncated
+		}
+
+		var extra uint
+		for _, b := range section[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	// the sizes of the maps and arrays are bounded by the remaining bytes, each item taking at least one
+	remaining := uint(len(section)) - offset
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]any, min(size, remaining))
+		for range size {
+			key, next, err := db.decode(section, offset, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			value, next, err := db.decode(section, next, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			k, _ := key.(string)
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+
+	case 11: // array
+		array := make([]any, 0, min(size, remaining))
+		for range size {
+			value, next, err := db.decode(section, offset, depth+1, values)
+			if err != nil {
+				return nil, 0, err
+			}
+			array = append(array, value)
+			offset = next
+		}
+		return array, offset, nil
+
+	case 14: // boolean, whose value is the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(section)) {
+		return nil, 0, errTruncated
+	}
+	payload := section[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2: // UTF-8 string
+		return string(payload), offset, nil
+
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+
+	case 5, 6, 8, 9, 10: // unsigned integers and int32
+		var n uint64
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+
+	default: // bytes and unused types
+		return payload, offset, nil
+	}
+}
+
+// pointer returns the offset the pointer points to, and the offset following the pointer.
+func (db *DB) pointer(section []byte, offset, size uint) (uint, uint, error) {
+	n := (size>>3)&0x3 + 1
+	if offset+n > uint(len(section)) {
+		return 0, 0, errTruncated
+	}
+
+	var value uint
+	for _, b := range section[offset : offset+n] {
+		value = value<<8 | uint(b)
+	}
+
+	switch n {
+	case 1:
+		value |= (size & 0x7) << 8
+	case 2:
+		value |= (size & 0x7) << 16
+		value += 2048
+	case 3:
+		value |= (size & 0x7) << 24
+		value += 526336
+	}
+	return value, offset + n, nil
+}
@@ -0,0 +1,311 @@
+package maxmind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures are written by a minimal MaxMind DB writer, mapping networks to encoded records.
+
+// encodeString encodes a UTF-8 string shorter than 29 bytes.
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// encodeUint encodes a uint32.
+func encodeUint(n uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, n)
+	return append([]byte{6<<5 | 4}, b...)
+}
+
+// encodeMap encodes a map of less than 29 entries, whose keys and values are already encoded.
+func encodeMap(entries ...[]byte) []byte {
+	m := []byte{byte(len(entries) / 2), 0} // extended type 7
+	for _, entry := range entries {
+		m = append(m, entry...)
+	}
+	return m
+}
+
+// encodePointer encodes a pointer to an offset of the data section below 2048.
+func encodePointer(offset uint) []byte {
+	return []byte{1<<5 | byte(offset>>8), byte(offset)}
+}
+
+// country returns the record of a country with the ISO code under the key.
+func country(key, code string) []byte {
+	return encodeMap(encodeString(key), encodeMap(encodeString("iso_code"), encodeString(code)))
+}
+
+// network is a prefix of the search tree, and the offset of its record in the data section.
+type network struct {
+	cidr   string
+	offset uint
+}
+
+// writeDB returns a MaxMind DB file with the networks, using the IP version and record size.
+func writeDB(t *testing.T, ipVersion, recordSize uint32, section []byte, networks ...network) []byte {
+	t.Helper()
+
+	// the nodes of the tree, with their records: -1 for no data, -2 - offset for data, or the next node
+	nodes := [][2]int{{-1, -1}}
+	for _, n := range networks {
+		_, prefix, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatalf("invalid network %s: %v", n.cidr, err)
+		}
+
+		// the IPv4 networks are mapped to ::a.b.c.d in the IPv6 trees
+		ip := prefix.IP.To16()
+		ones, _ := prefix.Mask.Size()
+		if ip4 := prefix.IP.To4(); ip4 != nil && ipVersion == 6 {
+			ip = append(make(net.IP, 12), ip4...)
+			ones += 96
+		} else if ipVersion == 4 {
+			ip = prefix.IP.To4()
+		}
+
+		node := 0
+		for i := range ones {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - int(n.offset)
+				break
+			}
+
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	count := len(nodes)
+	value := func(record int) uint32 {
+		switch {
+		case record == -1:
+			return uint32(count)
+		case record < -1:
+			return uint32(count + 16 + (-2 - record))
+		default:
+			return uint32(record)
+		}
+	}
+
+	var tree []byte
+	for _, node := range nodes {
+		left, right := value(node[0]), value(node[1])
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>24&0x0f)<<4|byte(right>>24&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+
+	metadata := encodeMap(
+		encodeString("node_count"), encodeUint(uint32(count)),
+		encodeString("record_size"), encodeUint(recordSize),
+		encodeString("ip_version"), encodeUint(ipVersion),
+	)
+
+	var file bytes.Buffer
+	file.Write(tree)
+	file.Write(make([]byte, 16))
+	file.Write(section)
+	file.Write(metadataMarker)
+	file.Write(metadata)
+	return file.Bytes()
+}
+
+// fixture returns a database where 1.0.0.0/8 is in AU, 2.0.0.0/8 is registered in FR,
+// and 2001:db8::/32 is in DE, with the code of AU behind a pointer.
+func fixture(t *testing.T, ipVersion, recordSize uint32) []byte {
+	t.Helper()
+
+	au := encodeString("AU")
+	australia := encodeMap(encodeString("country"), encodeMap(encodeString("iso_code"), encodePointer(0)))
+	france := country("registered_country", "FR")
+	germany := country("country", "DE")
+
+	section := append(append(append(au, australia...), france...), germany...)
+	networks := []network{
+		{cidr: "1.0.0.0/8", offset: uint(len(au))},
+		{cidr: "2.0.0.0/8", offset: uint(len(au) + len(australia))},
+	}
+	if ipVersion == 6 {
+		networks = append(networks, network{cidr: "2001:db8::/32", offset: uint(len(au) + len(australia) + len(france))})
+	}
+	return writeDB(t, ipVersion, recordSize, section, networks...)
+}
+
+func TestCountry(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipVersion  uint32
+		recordSize uint32
+	}{
+		{name: "IPv4 with 24-bit records", ipVersion: 4, recordSize: 24},
+		{name: "IPv6 with 28-bit records", ipVersion: 6, recordSize: 28},
+		{name: "IPv6 with 32-bit records", ipVersion: 6, recordSize: 32},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := parse(fixture(t, test.ipVersion, test.recordSize))
+			if err != nil {
+				t.Fatalf("failed to parse the database: %v", err)
+			}
+
+			countries := map[string]string{
+				"1.2.3.4":        "AU",
+				"2.255.0.1":      "FR",
+				"3.0.0.1":        "",
+				"not an IP":      "",
+				"2001:db8::1":    "DE",
+				"2001:db9::1":    "",
+				"::ffff:1.2.3.4": "AU",
+			}
+			if test.ipVersion == 4 {
+				countries["2001:db8::1"] = ""
+			}
+
+			for ip, expected := range countries {
+				if got := db.Country(ip); got != expected {
+					t.Errorf("expected the country of %s to be %q, got %q", ip, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, fixture(t, 6, 24), 0o644); err != nil {
+		t.Fatalf("failed to write the database: %v", err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	if got := db.Country("1.2.3.4"); got != "AU" {
+		t.Fatalf("expected AU, got %q", got)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Fatal("expected an error for the missing file")
+	}
+}
+
+func TestInvalidMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "no metadata", data: make([]byte, 100)},
+		{name: "metadata not a map", data: append(append([]byte{}, metadataMarker...), encodeString("map")...)},
+		{name: "unsupported record size", data: writeDB(t, 4, 16, nil)},
+		{name: "unsupported IP version", data: writeDB(t, 5, 24, nil)},
+		{
+			name: "node count larger than the file",
+			data: append(append(make([]byte, 16), metadataMarker...), encodeMap(
+				encodeString("node_count"), encodeUint(1<<31),
+				encodeString("record_size"), encodeUint(32),
+				encodeString("ip_version"), encodeUint(4),
+			)...),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parse(test.data); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := fixture(t, 6, 28)
+	for n := range len(data) {
+		// the truncated files must be refused, or resolve the countries without panicking
+		db, err := parse(data[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, ip := range []string{"1.2.3.4", "2.0.0.1", "2001:db8::1"} {
+			db.Country(ip)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	data := fixture(t, 4, 24)
+	for i := range len(data) {
+		// the bytes of zero sizes and pointers, maximum sizes and pointers, and extended types
+		for _, b := range []byte{0x00, 0xff, 0x20, 0x3f, 0xe0} {
+			corrupt := bytes.Clone(data)
+			corrupt[i] = b
+
+			db, err := parse(corrupt)
+			if err != nil {
+				continue
+			}
+			for _, ip := range []string{"1.2.3.4", "2.0.0.1"} {
+				db.Country(ip)
+			}
+		}
+	}
+}
+
+func TestPointerLoop(t *testing.T) {
+	// a map whose value points back to the map
+	loop := encodeMap(encodeString("country"), encodePointer(0))
+	db, err := parse(writeDB(t, 4, 24, loop, network{cidr: "1.0.0.0/8", offset: 0}))
+	if err != nil {
+		t.Fatalf("failed to parse the database: %v", err)
+	}
+
+	if _, err := db.lookup(net.ParseIP("1.2.3.4")); !errors.Is(err, errTooLarge) {
+		t.Fatalf("expected %v, got %v", errTooLarge, err)
+	}
+	if got := db.Country("1.2.3.4"); got != "" {
+		t.Fatalf("expected no country, got %q", got)
+	}
+}
+
+func TestExpansion(t *testing.T) {
+	// each level is a map of 16 pointers to the previous level, 16^5 values if they were all decoded
+	section := encodeString("x")
+	levels := []uint{0}
+	for range 5 {
+		offset := uint(len(section))
+		entries := make([][]byte, 0, 32)
+		for range 16 {
+			entries = append(entries, encodeString("k"), encodePointer(levels[len(levels)-1]))
+		}
+		section = append(section, encodeMap(entries...)...)
+		levels = append(levels, offset)
+	}
+
+	db, err := parse(writeDB(t, 4, 24, section, network{cidr: "1.0.0.0/8", offset: levels[len(levels)-1]}))
+	if err != nil {
+		t.Fatalf("failed to parse the database: %v", err)
+	}
+
+	if _, err := db.lookup(net.ParseIP("1.2.3.4")); !errors.Is(err, errTooLarge) {
+		t.Fatalf("expected %v, got %v", errTooLarge, err)
+	}
+}
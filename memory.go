@@ -0,0 +1,75 @@
+package rely
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrMemoryBudget = fmt.Errorf("%w: the relay is out of memory for new events, please try again later", ErrRateLimited)
+
+const (
+	// eventOverhead approximates the memory of an event besides its strings.
+	eventOverhead = 128
+
+	// estimatedEventSize is used to convert the available memory into a number of events
+	// when truncating REQ responses.
+	estimatedEventSize = 1024
+
+	// writerClosed is swapped into the queued bytes of a client when its writer exits,
+	// so that late sends are not accounted. See [client.send].
+	writerClosed = math.MinInt64 / 2
+)
+
+// eventSize approximates the bytes of memory used by the event.
+func eventSize(e *nostr.Event) int64 {
+	size := eventOverhead + len(e.ID) + len(e.PubKey) + len(e.Sig) + len(e.Content)
+	for _, tag := range e.Tags {
+		for _, value := range tag {
+			size += len(value) + 16
+		}
+	}
+	return int64(size)
+}
+
+// MemoryUsage returns the bytes of the events buffered in the relay (ingest queue, client
+// send queues and batch buffers), and the limit set with [WithMemoryBudget] (0 if none).
+func (r *Relay) MemoryUsage() (buffered, limit int64) {
+	return r.stats.bufferedBytes.Load(), r.memoryLimit
+}
+
+// Shed returns the number of EVENTs rejected and REQs truncated because
+// the memory budget was exceeded. See [WithMemoryBudget].
+func (r *Relay) Shed() (events, reqs int64) {
+	return r.stats.shedEvents.Load(), r.stats.shedReqs.Load()
+}
+
+// reserve accounts n bytes as buffered.
+func (r *Relay) reserve(n int64) {
+	if n != 0 {
+		r.stats.bufferedBytes.Add(n)
+	}
+}
+
+// release accounts n bytes as no longer buffered.
+func (r *Relay) release(n int64) {
+	if n != 0 {
+		r.stats.bufferedBytes.Add(-n)
+	}
+}
+
+// overMemoryBudget reports whether the buffered events exceed the memory budget.
+func (r *Relay) overMemoryBudget() bool {
+	return r.memoryLimit > 0 && r.stats.bufferedBytes.Load() >= r.memoryLimit
+}
+
+// memoryAllowance returns how many more events can be buffered within the memory budget.
+func (r *Relay) memoryAllowance() int {
+	if r.memoryLimit <= 0 {
+		return math.MaxInt
+	}
+
+	available := r.memoryLimit - r.stats.bufferedBytes.Load()
+	return int(max(0, available/estimatedEventSize))
+}
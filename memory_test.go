@@ -0,0 +1,63 @@
+package rely
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMemoryBudgetIngest(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithMemoryBudget(2000))
+	client := &client{relay: relay, responses: make(chan response, 10)}
+	event := &nostr.Event{ID: "abc", Content: strings.Repeat("a", 1000)}
+
+	for i := range 2 {
+		if err := relay.tryProcess(eventRequest{client: client, Event: event, receivedAt: time.Now()}); err != nil {
+			t.Fatalf("event %d should have been accepted, got %v", i, err)
+		}
+	}
+
+	err := relay.tryProcess(eventRequest{client: client, Event: event, receivedAt: time.Now()})
+	if err == nil || err.Err != ErrMemoryBudget {
+		t.Fatalf("expected %v, got %v", ErrMemoryBudget, err)
+	}
+
+	for range 2 {
+		relay.releaseRequest(<-relay.processor.queue)
+	}
+
+	if buffered, _ := relay.MemoryUsage(); buffered != 0 {
+		t.Fatalf("expected all memory to be released, got %d bytes", buffered)
+	}
+
+	if events, _ := relay.Shed(); events != 1 {
+		t.Fatalf("expected 1 shed event, got %d", events)
+	}
+}
+
+func TestMemoryBudgetSendQueue(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithMemoryBudget(1<<20))
+	client := &client{relay: relay, responses: make(chan response, 1)}
+	event := &nostr.Event{ID: "abc", Content: "hello"}
+
+	client.send(eventResponse{ID: "sub", Event: event})
+	client.send(eventResponse{ID: "sub", Event: event}) // dropped
+
+	if buffered, _ := relay.MemoryUsage(); buffered != eventSize(event) {
+		t.Fatalf("expected %d buffered bytes, got %d", eventSize(event), buffered)
+	}
+
+	client.dequeued(responseSize(<-client.responses))
+	if buffered, _ := relay.MemoryUsage(); buffered != 0 {
+		t.Fatalf("expected all memory to be released, got %d bytes", buffered)
+	}
+
+	// after the writer exits, the events are not accounted
+	client.queuedBytes.Swap(writerClosed)
+	client.send(eventResponse{ID: "sub", Event: event})
+	if buffered, _ := relay.MemoryUsage(); buffered != 0 {
+		t.Fatalf("expected no buffered bytes, got %d", buffered)
+	}
+}
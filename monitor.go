@@ -0,0 +1,355 @@
+package rely
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KindMonitorAnnouncement = 10166
+	KindRelayDiscovery      = 30166
+)
+
+// MonitorConfig configures the [Monitor].
+type MonitorConfig struct {
+	// URL is the public websocket URL of the relay (e.g. "wss://relay.example.com"),
+	// which is checked and identifies it in the discovery events.
+	URL string
+
+	// Relays are the monitor relays where the events are published.
+	Relays []string
+
+	// Interval is how often the relay is checked and its discovery event published.
+	Interval time.Duration
+
+	// Timeout is the maximum duration of each check and of each publication.
+	Timeout time.Duration
+
+	// Network is the network of the relay: clearnet, tor, i2p or loki.
+	Network string
+
+	// Geohash is the optional location of the relay.
+	Geohash string
+
+	// ClientRTTs publishes the median round-trip times of the clients by country (see [Relay.RTTs]) in
+	// the "rtt-clients" tags of the discovery events, e.g. ["rtt-clients", "DE", "42"] for 42 milliseconds.
+	// Only the countries with at least [MinClientRTTs] measures, and some in the last minutes, are published.
+	ClientRTTs bool
+}
+
+// MinClientRTTs is the minimum number of round-trip times of the clients of a country
+// for their median to be published by the [Monitor].
+const MinClientRTTs = 10
+
+// DefaultMonitorConfig returns a [MonitorConfig] publishing every hour on the clearnet.
+func DefaultMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		Interval: time.Hour,
+		Timeout:  10 * time.Second,
+		Network:  "clearnet",
+	}
+}
+
+// Monitor makes the relay appear in relay discovery tools by publishing NIP-66 events, signed with its [Identity],
+// to the monitor relays: a kind 10166 announcement of the checks it performs, and periodically
+// a kind 30166 discovery event with its round-trip times, supported NIPs, requirements and NIP-11 document
+// (which includes the software and its version).
+// See https://github.com/nostr-protocol/nips/blob/master/66.md
+//
+// Example:
+//
+//	monitor, err := NewMonitor(relay, config)
+//	go monitor.Run(ctx)
+type Monitor struct {
+	relay  *Relay
+	config MonitorConfig
+	url    string
+
+	// conns are the connections to the monitor relays, kept open between the publications.
+	// They are only used by the goroutine of [Monitor.Run].
+	conns map[string]*ws.Conn
+
+	published atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewMonitor returns a [Monitor] of the relay, or an error if the relay has no [Identity] or the config is invalid.
+func NewMonitor(relay *Relay, config MonitorConfig) (*Monitor, error) {
+	if relay.Identity() == nil {
+		return nil, ErrNoIdentity
+	}
+
+	url := nostr.NormalizeURL(config.URL)
+	if url == "" {
+		return nil, fmt.Errorf("invalid relay URL %q", config.URL)
+	}
+
+	if len(config.Relays) == 0 {
+		return nil, errors.New("at least one monitor relay is required")
+	}
+
+	for _, r := range config.Relays {
+		if !nostr.IsValidRelayURL(r) {
+			return nil, fmt.Errorf("invalid monitor relay %q", r)
+		}
+	}
+
+	if config.Interval <= 0 || config.Timeout <= 0 {
+		return nil, errors.New("the interval and timeout must be positive")
+	}
+
+	switch config.Network {
+	case "clearnet", "tor", "i2p", "loki":
+	default:
+		return nil, fmt.Errorf("invalid network %q: must be clearnet, tor, i2p or loki", config.Network)
+	}
+	return &Monitor{relay: relay, config: config, url: url, conns: make(map[string]*ws.Conn)}, nil
+}
+
+// Published returns the number of events published to the monitor relays.
+func (m *Monitor) Published() int64 { return m.published.Load() }
+
+// Failed returns the number of failed publications to the monitor relays.
+func (m *Monitor) Failed() int64 { return m.failed.Load() }
+
+// Run publishes the announcement, and the discovery events every interval, until the context is cancelled.
+// The connections to the monitor relays are kept open between the publications, and closed once it returns.
+func (m *Monitor) Run(ctx context.Context) {
+	defer m.close()
+
+	m.publish(ctx, m.announcement())
+	m.publish(ctx, m.discovery(m.check(ctx)))
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.publish(ctx, m.discovery(m.check(ctx)))
+		}
+	}
+}
+
+// rtt holds the round-trip times of the checks, zero if they failed.
+type rtt struct {
+	open time.Duration
+	read time.Duration
+}
+
+// check connects to the relay through its public URL, measuring the round-trip times
+// of opening the connection and of reading an event (until the EOSE).
+func (m *Monitor) check(ctx context.Context) rtt {
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	var result rtt
+	start := time.Now()
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, m.url, nil)
+	if err != nil {
+		m.relay.log.Warn("NIP-66 monitor failed to connect to the relay", "url", m.url, "error", err)
+		return result
+	}
+	defer conn.Close()
+	result.open = time.Since(start)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	start = time.Now()
+	req, _ := json.Marshal(nostr.ReqEnvelope{SubscriptionID: "monitor", Filters: nostr.Filters{{Limit: 1}}})
+	if err := conn.WriteMessage(ws.TextMessage, req); err != nil {
+		return result
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return result
+		}
+
+		switch nostr.ParseMessage(string(data)).(type) {
+		case *nostr.EOSEEnvelope:
+			result.read = time.Since(start)
+			return result
+		case *nostr.ClosedEnvelope:
+			return result
+		}
+	}
+}
+
+// announcement returns the kind 10166 event announcing the checks of the monitor.
+func (m *Monitor) announcement() *nostr.Event {
+	tags := nostr.Tags{
+		{"frequency", strconv.Itoa(int(m.config.Interval.Seconds()))},
+		{"timeout", "open", strconv.FormatInt(m.config.Timeout.Milliseconds(), 10)},
+		{"timeout", "read", strconv.FormatInt(m.config.Timeout.Milliseconds(), 10)},
+		{"c", "open"},
+		{"c", "read"},
+		{"c", "nip11"},
+	}
+
+	if m.config.Geohash != "" {
+		tags = append(tags, nostr.Tag{"g", m.config.Geohash})
+	}
+
+	if m.config.ClientRTTs {
+		tags = append(tags, m.clientRTTs()...)
+	}
+	return &nostr.Event{Kind: KindMonitorAnnouncement, Tags: tags}
+}
+
+// discovery returns the kind 30166 event describing the relay, with the round-trip times of the checks.
+func (m *Monitor) discovery(rtt rtt) *nostr.Event {
+	info := m.relay.Info()
+	tags := nostr.Tags{
+		{"d", m.url},
+		{"n", m.config.Network},
+	}
+
+	if rtt.open > 0 {
+		tags = append(tags, nostr.Tag{"rtt-open", strconv.FormatInt(rtt.open.Milliseconds(), 10)})
+	}
+	if rtt.read > 0 {
+		tags = append(tags, nostr.Tag{"rtt-read", strconv.FormatInt(rtt.read.Milliseconds(), 10)})
+	}
+
+	for _, nip := range info.SupportedNIPs {
+		tags = append(tags, nostr.Tag{"N", fmt.Sprint(nip)})
+	}
+
+	if limits := info.Limitation; limits != nil {
+		tags = append(tags, requirement("auth", limits.AuthRequired))
+		tags = append(tags, requirement("payment", limits.PaymentRequired))
+		tags = append(tags, requirement("writes", limits.RestrictedWrites))
+		tags = append(tags, requirement("pow", limits.MinPowDifficulty > 0))
+	}
+
+	if m.config.Geohash != "" {
+		tags = append(tags, nostr.Tag{"g", m.config.Geohash})
+	}
+
+	content, _ := json.Marshal(info) // already validated by SetInfo
+	return &nostr.Event{Kind: KindRelayDiscovery, Tags: tags, Content: string(content)}
+}
+
+// clientRTTs returns the "rtt-clients" tags with the median round-trip times of the clients
+// of the countries with enough measures, sorted by country.
+func (m *Monitor) clientRTTs() nostr.Tags {
+	rtts := m.relay.RTTs()
+	var tags nostr.Tags
+	for _, country := range slices.Sorted(maps.Keys(rtts)) {
+		rtt := rtts[country]
+		if country == "" || rtt.Count < MinClientRTTs || rtt.P50 == 0 {
+			continue
+		}
+		tags = append(tags, nostr.Tag{"rtt-clients", country, strconv.FormatInt(rtt.P50.Milliseconds(), 10)})
+	}
+	return tags
+}
+
+// requirement returns the "R" tag of the requirement, negated with "!" if it's not required.
+func requirement(name string, required bool) nostr.Tag {
+	if required {
+		return nostr.Tag{"R", name}
+	}
+	return nostr.Tag{"R", "!" + name}
+}
+
+// publish signs the event and sends it to all the monitor relays.
+func (m *Monitor) publish(ctx context.Context, e *nostr.Event) {
+	if err := m.relay.Identity().Sign(e); err != nil {
+		m.relay.log.Error("NIP-66 monitor failed to sign the event", "error", err)
+		return
+	}
+
+	for _, url := range m.config.Relays {
+		if err := m.send(ctx, url, e); err != nil {
+			m.failed.Add(1)
+			m.relay.log.Warn("NIP-66 monitor failed to publish", "relay", url, "kind", e.Kind, "error", err)
+			continue
+		}
+		m.published.Add(1)
+	}
+}
+
+// send publishes the event to the monitor relay, reusing its connection. A connection that fails
+// (e.g. closed by the relay while idle) is replaced by a new one, which is tried once.
+func (m *Monitor) send(ctx context.Context, url string, e *nostr.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	for {
+		conn, reused := m.conns[url]
+		if !reused {
+			var err error
+			if conn, _, err = ws.DefaultDialer.DialContext(ctx, url, nil); err != nil {
+				return err
+			}
+			m.conns[url] = conn
+		}
+
+		err := publishOn(ctx, conn, e)
+		if err == nil {
+			return nil
+		}
+
+		conn.Close()
+		delete(m.conns, url)
+		if !reused || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// publishOn sends the event on the connection, and waits for its OK.
+func publishOn(ctx context.Context, conn *ws.Conn, e *nostr.Event) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		conn.SetReadDeadline(deadline)
+	}
+
+	msg, err := json.Marshal(nostr.EventEnvelope{Event: *e})
+	if err != nil {
+		return err
+	}
+
+	if err := conn.WriteMessage(ws.TextMessage, msg); err != nil {
+		return err
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if ok, isOK := nostr.ParseMessage(string(data)).(*nostr.OKEnvelope); isOK && ok.EventID == e.ID {
+			if !ok.OK {
+				return fmt.Errorf("the event was rejected: %s", ok.Reason)
+			}
+			return nil
+		}
+	}
+}
+
+// close closes the connections to the monitor relays.
+func (m *Monitor) close() {
+	for url, conn := range m.conns {
+		conn.Close()
+		delete(m.conns, url)
+	}
+}
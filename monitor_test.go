@@ -0,0 +1,148 @@
+package rely
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan nostr.Event, 10)
+	monitorRelay := NewRelay(WithDomain("example.com"))
+	monitorRelay.On.Event = func(ctx context.Context, _ Client, e *nostr.Event) error {
+		received <- *e
+		return nil
+	}
+	monitorRelay.Start(ctx)
+
+	server := httptest.NewServer(monitorRelay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	relay := NewRelay(WithDomain("example.com"), WithIdentity(GenerateIdentity()))
+
+	config := DefaultMonitorConfig()
+	config.URL = url
+	config.Relays = []string{url}
+	config.Timeout = time.Second
+
+	monitor, err := NewMonitor(relay, config)
+	if err != nil {
+		t.Fatalf("failed to create the monitor: %v", err)
+	}
+	go monitor.Run(ctx)
+
+	announcement := <-received
+	if announcement.Kind != KindMonitorAnnouncement || announcement.PubKey != relay.Identity().PublicKey() {
+		t.Fatalf("expected the monitor announcement, got %v", announcement)
+	}
+
+	discovery := <-received
+	if discovery.Kind != KindRelayDiscovery {
+		t.Fatalf("expected the discovery event, got %v", discovery)
+	}
+
+	if d := discovery.Tags.GetD(); d != nostr.NormalizeURL(url) {
+		t.Fatalf("expected the d tag %s, got %s", nostr.NormalizeURL(url), d)
+	}
+
+	for _, tag := range []nostr.Tag{{"n", "clearnet"}, {"N", "42"}, {"R", "!payment"}} {
+		if !slices.ContainsFunc(discovery.Tags, func(t nostr.Tag) bool { return slices.Equal(t, tag) }) {
+			t.Fatalf("expected the tag %v, got %v", tag, discovery.Tags)
+		}
+	}
+
+	if discovery.Tags.Find("rtt-open") == nil {
+		t.Fatalf("expected the rtt-open tag, got %v", discovery.Tags)
+	}
+}
+
+func TestMonitorConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var connections atomic.Int64
+	received := make(chan nostr.Event, 10)
+	monitorRelay := NewRelay(WithDomain("example.com"))
+	monitorRelay.Reject.Connection = append(monitorRelay.Reject.Connection, func(Stats, *http.Request) error {
+		connections.Add(1)
+		return nil
+	})
+	monitorRelay.On.Event = func(ctx context.Context, _ Client, e *nostr.Event) error {
+		received <- *e
+		return nil
+	}
+	monitorRelay.Start(ctx)
+
+	server := httptest.NewServer(monitorRelay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	config := DefaultMonitorConfig()
+	config.URL = "wss://relay.example.com"
+	config.Relays = []string{url}
+	config.Timeout = time.Second
+
+	monitor, err := NewMonitor(NewRelay(WithDomain("example.com"), WithIdentity(GenerateIdentity())), config)
+	if err != nil {
+		t.Fatalf("failed to create the monitor: %v", err)
+	}
+	defer monitor.close()
+
+	for range 2 {
+		monitor.publish(ctx, monitor.announcement())
+		<-received
+	}
+
+	if monitor.Published() != 2 || connections.Load() != 1 {
+		t.Fatalf("expected 2 events published on 1 connection, got %d on %d", monitor.Published(), connections.Load())
+	}
+}
+
+func TestNewMonitor(t *testing.T) {
+	config := DefaultMonitorConfig()
+	config.URL = "wss://relay.example.com"
+	config.Relays = []string{"wss://monitor.example.com"}
+
+	if _, err := NewMonitor(NewRelay(WithDomain("example.com")), config); err != ErrNoIdentity {
+		t.Fatalf("expected %v, got %v", ErrNoIdentity, err)
+	}
+
+	relay := NewRelay(WithDomain("example.com"), WithIdentity(GenerateIdentity()))
+	if _, err := NewMonitor(relay, config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.Network = "internet"
+	if _, err := NewMonitor(relay, config); err == nil {
+		t.Fatal("expected an error for an invalid network")
+	}
+}
+
+func TestMonitorClientRTTs(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+	for range MinClientRTTs {
+		relay.observeRTT("DE", 30*time.Millisecond)
+		relay.observeRTT("", 30*time.Millisecond)
+	}
+	relay.observeRTT("US", 100*time.Millisecond) // too few measures
+
+	monitor := &Monitor{relay: relay, config: MonitorConfig{ClientRTTs: true}}
+	tags := monitor.clientRTTs()
+
+	// percentiles are the upper bounds of the histogram buckets: 30ms falls in the one up to 51.2ms
+	expected := nostr.Tags{{"rtt-clients", "DE", "51"}}
+	if !slices.EqualFunc(tags, expected, func(a, b nostr.Tag) bool { return slices.Equal(a, b) }) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+}
@@ -0,0 +1,249 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// MutesConfig configures the [Mutes].
+type MutesConfig struct {
+	// Query returns the stored events matching the filters, typically the QueryEvents method of the storage.
+	// It's used to load the mute lists of the authenticated users.
+	Query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)
+
+	// Threads suppresses the muted threads too: the events whose ID is in the "e" tags of the mute list,
+	// and the events referencing them (e.g. the replies).
+	Threads bool
+
+	// Timeout is the deadline of the loading of each mute list.
+	Timeout time.Duration
+}
+
+// DefaultMutesConfig returns a [MutesConfig] with sane defaults, loading the mute lists with the query.
+func DefaultMutesConfig(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)) MutesConfig {
+	return MutesConfig{
+		Query:   query,
+		Threads: true,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Mutes honors the NIP-51 mute lists (kind 10000) of the authenticated users when delivering the events,
+// without altering the stored data: the events of the muted pubkeys, and optionally of the muted threads,
+// are withheld from all the subscriptions of the user. The mute list is loaded in the background when the user
+// authenticates, so the first events may be delivered before it's loaded, and it's updated when the user
+// publishes a new one. Only the public items of the list are honored, since the private ones are encrypted.
+//
+// See https://github.com/nostr-protocol/nips/blob/master/51.md
+//
+// Example:
+//
+//	mutes, err := NewMutes(DefaultMutesConfig(storage.QueryEvents))
+//	relay.On.Auth = mutes.OnAuth
+//	relay.On.Disconnect = mutes.OnDisconnect
+//	relay.On.Event = mutes.Save(relay.On.Event)
+//	relay.On.Deliver = ChainDeliver(relay.On.Deliver, mutes.Deliver)
+type Mutes struct {
+	config MutesConfig
+
+	mu      sync.RWMutex
+	lists   map[string]*muteList // by the pubkey of the user
+	clients map[string]string    // the pubkey of each authenticated client, by UID
+
+	suppressed atomic.Int64
+}
+
+// muteList is the mute list of a user, kept while at least one of their clients is connected.
+type muteList struct {
+	clients   int
+	createdAt nostr.Timestamp
+	pubkeys   map[string]struct{}
+	threads   map[string]struct{}
+}
+
+// NewMutes returns a [Mutes], or an error if the config is invalid.
+func NewMutes(config MutesConfig) (*Mutes, error) {
+	if config.Query == nil {
+		return nil, errors.New("the query function is required")
+	}
+
+	if config.Timeout <= 0 {
+		return nil, errors.New("the mute list timeout must be positive")
+	}
+	return &Mutes{config: config, lists: make(map[string]*muteList), clients: make(map[string]string)}, nil
+}
+
+// Users returns the number of users whose mute list is kept.
+func (m *Mutes) Users() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.lists)
+}
+
+// Suppressed returns the number of events withheld because they were muted.
+func (m *Mutes) Suppressed() int64 { return m.suppressed.Load() }
+
+// OnAuth is an On.Auth hook loading the mute list of the user in the background,
+// unless it's already kept for another of their clients.
+func (m *Mutes) OnAuth(c Client) {
+	pubkey := c.Pubkey()
+	if pubkey == "" {
+		return
+	}
+
+	m.mu.Lock()
+	// the client may authenticate again, possibly as another user
+	m.release(c.UID())
+	m.clients[c.UID()] = pubkey
+
+	list, ok := m.lists[pubkey]
+	if !ok {
+		list = &muteList{}
+		m.lists[pubkey] = list
+	}
+	list.clients++
+	m.mu.Unlock()
+
+	if !ok {
+		go m.load(c, pubkey)
+	}
+}
+
+// OnDisconnect is an On.Disconnect hook forgetting the mute list of the user once all of their clients disconnected.
+func (m *Mutes) OnDisconnect(c Client, _ DisconnectReason, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release(c.UID())
+}
+
+// release forgets the pubkey of the client, and the mute list of the user if it was their last client.
+// It must be called with the lock held.
+func (m *Mutes) release(uid string) {
+	pubkey, ok := m.clients[uid]
+	if !ok {
+		return
+	}
+	delete(m.clients, uid)
+
+	if list, ok := m.lists[pubkey]; ok {
+		list.clients--
+		if list.clients <= 0 {
+			delete(m.lists, pubkey)
+		}
+	}
+}
+
+// load queries the mute list of the user and keeps it, if the user is still connected.
+func (m *Mutes) load(c Client, pubkey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	defer cancel()
+
+	events, err := m.config.Query(ctx, c, nostr.Filters{{Kinds: []int{nostr.KindMuteList}, Authors: []string{pubkey}, Limit: 1}})
+	if err != nil {
+		return
+	}
+
+	for i := range events {
+		m.update(&events[i])
+	}
+}
+
+// Save wraps the On.Event hook, updating the kept mute list of the user when they publish a new one.
+func (m *Mutes) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		if e.Kind == nostr.KindMuteList {
+			m.update(e)
+		}
+		return nil
+	}
+}
+
+// update replaces the kept mute list of the author of the event, if the event is newer.
+func (m *Mutes) update(e *nostr.Event) {
+	if e.Kind != nostr.KindMuteList {
+		return
+	}
+
+	pubkeys := make(map[string]struct{})
+	threads := make(map[string]struct{})
+	for _, tag := range e.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "p":
+			pubkeys[tag[1]] = struct{}{}
+		case "e":
+			threads[tag[1]] = struct{}{}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list, ok := m.lists[e.PubKey]
+	if !ok || e.CreatedAt < list.createdAt {
+		return
+	}
+
+	list.createdAt = e.CreatedAt
+	list.pubkeys = pubkeys
+	list.threads = threads
+}
+
+// Deliver is an On.Deliver hook withholding from the client the events muted by its user.
+// The events of the user themselves are always delivered.
+func (m *Mutes) Deliver(c Client, _ string, e *nostr.Event) bool {
+	pubkey := c.Pubkey()
+	if pubkey == "" || e.PubKey == pubkey {
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list, ok := m.lists[pubkey]
+	if !ok {
+		return true
+	}
+
+	if m.muted(list, e) {
+		m.suppressed.Add(1)
+		return false
+	}
+	return true
+}
+
+// muted reports whether the event is muted by the list.
+func (m *Mutes) muted(list *muteList, e *nostr.Event) bool {
+	if _, ok := list.pubkeys[e.PubKey]; ok {
+		return true
+	}
+
+	if !m.config.Threads || len(list.threads) == 0 {
+		return false
+	}
+
+	if _, ok := list.threads[e.ID]; ok {
+		return true
+	}
+
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && tag[0] == "e" {
+			if _, ok := list.threads[tag[1]]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
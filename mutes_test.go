@@ -0,0 +1,62 @@
+package rely
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMutes(t *testing.T) {
+	list := nostr.Event{ID: "list", Kind: nostr.KindMuteList, PubKey: "user", CreatedAt: 10, Tags: nostr.Tags{{"p", "spammer"}, {"e", "thread"}}}
+	mutes, err := NewMutes(DefaultMutesConfig(memoryQuery(list)))
+	if err != nil {
+		t.Fatalf("failed to create the mutes: %v", err)
+	}
+
+	user := &client{uid: "1", pubkey: "user"}
+	mutes.OnAuth(user)
+
+	spam := &nostr.Event{ID: "spam", PubKey: "spammer"}
+	deadline := time.Now().Add(time.Second)
+	for mutes.Deliver(user, "sub", spam) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name    string
+		client  *client
+		event   *nostr.Event
+		deliver bool
+	}{
+		{name: "muted pubkey", client: user, event: spam},
+		{name: "muted thread", client: user, event: &nostr.Event{ID: "thread", PubKey: "author"}},
+		{name: "reply to the muted thread", client: user, event: &nostr.Event{ID: "reply", PubKey: "author", Tags: nostr.Tags{{"e", "thread"}}}},
+		{name: "other event", client: user, event: &nostr.Event{ID: "other", PubKey: "author"}, deliver: true},
+		{name: "unauthenticated client", client: &client{uid: "2"}, event: spam, deliver: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mutes.Deliver(test.client, "sub", test.event); got != test.deliver {
+				t.Fatalf("expected deliver %v, got %v", test.deliver, got)
+			}
+		})
+	}
+
+	// a newer mute list replaces the kept one
+	save := mutes.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+	updated := &nostr.Event{Kind: nostr.KindMuteList, PubKey: "user", CreatedAt: 20}
+	if err := save(context.Background(), user, updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !mutes.Deliver(user, "sub", spam) {
+		t.Fatal("expected the unmuted pubkey to be delivered")
+	}
+
+	mutes.OnDisconnect(user, DisconnectClosed, nil)
+	if mutes.Users() != 0 {
+		t.Fatalf("expected the mute list to be forgotten, got %d users", mutes.Users())
+	}
+}
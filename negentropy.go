@@ -0,0 +1,370 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+)
+
+var (
+	ErrNegentropyTooBig       = fmt.Errorf("%w: the filter matches too many events, please narrow it", ErrBlocked)
+	ErrTooManyReconciliations = fmt.Errorf("%w: too many reconciliations open, please close some", ErrRateLimited)
+)
+
+// NegentropyConfig configures the [Negentropy].
+type NegentropyConfig struct {
+	// Query returns the events matching the filters, typically the On.Req hook of the relay, whose IDs are
+	// reconciled with those of the client. It must return up to the limit of the filters, at least MaxEvents.
+	Query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)
+
+	// MaxEvents is the maximum number of events of a reconciliation. The filters matching more are
+	// refused with [ErrNegentropyTooBig], so that the clients reconcile them in smaller time ranges.
+	MaxEvents int
+
+	// MaxSessions is the maximum number of reconciliations open at once by each client.
+	MaxSessions int
+
+	// FrameSize is the maximum size in bytes of the reconciliation messages sent to the clients,
+	// before the hex encoding. It must be at least 4096.
+	FrameSize int
+
+	// Timeout is the deadline of the query of each reconciliation.
+	Timeout time.Duration
+
+	// Reject is applied to the filters of the reconciliations before they are queried, for example [Relay.RejectReq],
+	// so that the reconciliations enforce the same read policies as the REQs. If nil, the filters are not checked.
+	Reject func(context.Context, Client, nostr.Filters) error
+
+	// Deliver is applied to the queried events, for example the On.Deliver hook of the relay, so that the IDs of
+	// the events withheld from the REQs are not revealed. If nil, the IDs of all the queried events are reconciled.
+	Deliver func(c Client, sub string, e *nostr.Event) bool
+}
+
+// DefaultNegentropyConfig returns a [NegentropyConfig] with sane defaults, reconciling the events of the query.
+func DefaultNegentropyConfig(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)) NegentropyConfig {
+	return NegentropyConfig{
+		Query:       query,
+		MaxEvents:   5000,
+		MaxSessions: 4,
+		FrameSize:   60_000,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// Negentropy implements the NIP-77 set reconciliation, which lets the clients find the events they
+// are missing, and the relay is missing, exchanging only fingerprints of ranges of IDs:
+//
+//	["NEG-OPEN", <id>, <filter>, <message>]
+//	["NEG-MSG", <id>, <message>]
+//	["NEG-CLOSE", <id>]
+//
+// The relay responds with ["NEG-MSG", <id>, <message>], or ["NEG-ERR", <id>, <reason>] if the
+// reconciliation is refused or fails. The events are then fetched and published with REQs and EVENTs.
+//
+// See https://github.com/nostr-protocol/nips/blob/master/77.md
+//
+// Example:
+//
+//	config := DefaultNegentropyConfig(func(ctx context.Context, c Client, filters nostr.Filters) ([]nostr.Event, error) {
+//		return relay.On.Req(ctx, c, filters)
+//	})
+//	neg, err := NewNegentropy(config)
+//	relay = NewRelay(
+//		WithVerb("NEG-OPEN", neg.HandleOpen),
+//		WithVerb("NEG-MSG", neg.HandleMessage),
+//		WithVerb("NEG-CLOSE", neg.HandleClose),
+//	)
+//	relay.On.Disconnect = neg.OnDisconnect
+//	relay.Supports(77)
+type Negentropy struct {
+	config NegentropyConfig
+
+	mu       sync.Mutex
+	sessions map[string]map[string]*negSession // by the UID of the client and the ID of the reconciliation
+
+	opened atomic.Int64
+}
+
+// negSession is an open reconciliation, whose negentropy is nil until the events are queried.
+type negSession struct {
+	neg *negentropy.Negentropy
+}
+
+// NewNegentropy returns a [Negentropy], or an error if the config is invalid.
+func NewNegentropy(config NegentropyConfig) (*Negentropy, error) {
+	if config.Query == nil {
+		return nil, errors.New("the query function is required")
+	}
+
+	if config.MaxEvents < 1 || config.MaxSessions < 1 {
+		return nil, errors.New("the negentropy max events and max sessions must be positive")
+	}
+
+	if config.FrameSize < 4096 {
+		return nil, errors.New("the negentropy frame size must be at least 4096")
+	}
+
+	if config.Timeout <= 0 {
+		return nil, errors.New("the negentropy timeout must be positive")
+	}
+	return &Negentropy{config: config, sessions: make(map[string]map[string]*negSession)}, nil
+}
+
+// Opened returns the number of reconciliations opened by the clients.
+func (n *Negentropy) Opened() int64 { return n.opened.Load() }
+
+// negRequest is a parsed NEG-OPEN, NEG-MSG or NEG-CLOSE message.
+type negRequest struct {
+	ID      string
+	Filter  nostr.Filter
+	Message string
+}
+
+// parseNeg parses the raw message, made of the ID of the reconciliation, the filter if withFilter,
+// and the hex message if withMessage.
+func parseNeg(raw []byte, withFilter, withMessage bool) (negRequest, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(raw, &elements); err != nil {
+		return negRequest{}, fmt.Errorf("%w: malformed message: %v", ErrInvalid, err)
+	}
+
+	var request negRequest
+	if len(elements) < 2 {
+		return request, fmt.Errorf("%w: the message must have an id", ErrInvalid)
+	}
+
+	if err := json.Unmarshal(elements[1], &request.ID); err != nil || request.ID == "" {
+		return request, fmt.Errorf("%w: invalid id", ErrInvalid)
+	}
+
+	elements = elements[2:]
+	if withFilter {
+		if len(elements) < 1 {
+			return request, fmt.Errorf("%w: the message must have a filter", ErrInvalid)
+		}
+		if err := json.Unmarshal(elements[0], &request.Filter); err != nil {
+			return request, fmt.Errorf("%w: invalid filter: %v", ErrInvalid, err)
+		}
+		elements = elements[1:]
+	}
+
+	if withMessage {
+		if len(elements) < 1 {
+			return request, fmt.Errorf("%w: the message must have a negentropy message", ErrInvalid)
+		}
+		if err := json.Unmarshal(elements[0], &request.Message); err != nil {
+			return request, fmt.Errorf("%w: invalid negentropy message", ErrInvalid)
+		}
+	}
+	return request, nil
+}
+
+// HandleOpen is the handler of the NEG-OPEN verb, see [WithVerb]. The events are queried in the background,
+// and the first message is sent to the client when they are ready. A reconciliation with the same ID replaces
+// the open one.
+func (n *Negentropy) HandleOpen(ctx context.Context, c Client, raw []byte) error {
+	request, err := parseNeg(raw, true, true)
+	if err != nil {
+		if request.ID == "" {
+			return err
+		}
+		n.fail(c, request.ID, err)
+		return nil
+	}
+
+	if n.config.Reject != nil {
+		if err := n.config.Reject(ctx, c, nostr.Filters{request.Filter}); err != nil {
+			n.fail(c, request.ID, err)
+			return nil
+		}
+	}
+
+	session, ok := n.open(c, request.ID)
+	if !ok {
+		n.fail(c, request.ID, ErrTooManyReconciliations)
+		return nil
+	}
+	n.opened.Add(1)
+
+	// the context of the handler is cancelled when it returns
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), n.config.Timeout)
+	go func() {
+		defer cancel()
+
+		neg, err := n.query(ctx, c, request)
+		if err != nil {
+			n.close(c, request.ID, session)
+			n.fail(c, request.ID, err)
+			return
+		}
+
+		// the negentropy is visible to the NEG-MSGs only after the first reconciliation
+		response, err := neg.Reconcile(request.Message)
+		if err != nil {
+			n.close(c, request.ID, session)
+			n.fail(c, request.ID, fmt.Errorf("%w: %v", ErrInvalid, err))
+			return
+		}
+
+		n.mu.Lock()
+		open := n.sessions[c.UID()][request.ID] == session
+		if open {
+			session.neg = neg
+		}
+		n.mu.Unlock()
+
+		if open {
+			n.send(c, request.ID, response)
+		}
+	}()
+	return nil
+}
+
+// HandleMessage is the handler of the NEG-MSG verb, see [WithVerb].
+func (n *Negentropy) HandleMessage(ctx context.Context, c Client, raw []byte) error {
+	request, err := parseNeg(raw, false, true)
+	if err != nil {
+		if request.ID == "" {
+			return err
+		}
+		n.fail(c, request.ID, err)
+		return nil
+	}
+
+	n.mu.Lock()
+	session := n.sessions[c.UID()][request.ID]
+	var neg *negentropy.Negentropy
+	if session != nil {
+		neg = session.neg
+	}
+	n.mu.Unlock()
+
+	if neg == nil {
+		n.fail(c, request.ID, fmt.Errorf("%w: the reconciliation is not open", ErrInvalid))
+		return nil
+	}
+
+	response, err := neg.Reconcile(request.Message)
+	if err != nil {
+		n.close(c, request.ID, session)
+		n.fail(c, request.ID, fmt.Errorf("%w: %v", ErrInvalid, err))
+		return nil
+	}
+
+	n.send(c, request.ID, response)
+	return nil
+}
+
+// HandleClose is the handler of the NEG-CLOSE verb, see [WithVerb].
+func (n *Negentropy) HandleClose(ctx context.Context, c Client, raw []byte) error {
+	request, err := parseNeg(raw, false, false)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.sessions[c.UID()], request.ID)
+	if len(n.sessions[c.UID()]) == 0 {
+		delete(n.sessions, c.UID())
+	}
+	return nil
+}
+
+// OnDisconnect is an On.Disconnect hook forgetting the reconciliations of the client.
+func (n *Negentropy) OnDisconnect(c Client, _ DisconnectReason, _ error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.sessions, c.UID())
+}
+
+// open a new session with the ID, replacing the one already open. It returns false if the client
+// has too many sessions open.
+func (n *Negentropy) open(c Client, id string) (*negSession, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sessions, ok := n.sessions[c.UID()]
+	if !ok {
+		sessions = make(map[string]*negSession, n.config.MaxSessions)
+		n.sessions[c.UID()] = sessions
+	}
+
+	if _, replaced := sessions[id]; !replaced && len(sessions) >= n.config.MaxSessions {
+		return nil, false
+	}
+
+	session := &negSession{}
+	sessions[id] = session
+	return session, true
+}
+
+// close the session, if it's still the one open with the ID.
+func (n *Negentropy) close(c Client, id string, session *negSession) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sessions := n.sessions[c.UID()]
+	if sessions[id] == session {
+		delete(sessions, id)
+	}
+	if len(sessions) == 0 {
+		delete(n.sessions, c.UID())
+	}
+}
+
+// query returns the negentropy of the deliverable events matching the filter of the request.
+func (n *Negentropy) query(ctx context.Context, c Client, request negRequest) (*negentropy.Negentropy, error) {
+	filter := request.Filter
+	limited := filter.Limit > 0 && filter.Limit < n.config.MaxEvents
+	if !limited {
+		filter.Limit = n.config.MaxEvents
+	}
+
+	var events []nostr.Event
+	if !filter.LimitZero {
+		var err error
+		events, err = n.config.Query(ctx, c, nostr.Filters{filter})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to query the events: %v", ErrError, err)
+		}
+	}
+
+	if !limited && len(events) >= n.config.MaxEvents {
+		return nil, ErrNegentropyTooBig
+	}
+
+	if n.config.Deliver != nil {
+		events = deliverable(n.config.Deliver, c, request.ID, events)
+	}
+
+	items := vector.New()
+	for _, e := range events {
+		if len(e.ID) == 64 {
+			items.Insert(e.CreatedAt, e.ID)
+		}
+	}
+	items.Seal()
+	return negentropy.New(items, n.config.FrameSize), nil
+}
+
+// send the NEG-MSG message of the reconciliation.
+func (n *Negentropy) send(c Client, id, message string) {
+	msg, _ := json.Marshal([]any{"NEG-MSG", id, message})
+	c.SendMessage(msg)
+}
+
+// fail sends the NEG-ERR message of the reconciliation with the reason of the error.
+func (n *Negentropy) fail(c Client, id string, err error) {
+	msg, _ := json.Marshal([]any{"NEG-ERR", id, reasonMessage(err)})
+	c.SendMessage(msg)
+}
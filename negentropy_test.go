@@ -0,0 +1,173 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+)
+
+// negentropyRelay returns the connection to a relay reconciling the events with the config.
+func negentropyRelay(t *testing.T, ctx context.Context, config NegentropyConfig) *ws.Conn {
+	t.Helper()
+	neg, err := NewNegentropy(config)
+	if err != nil {
+		t.Fatalf("failed to create the negentropy: %v", err)
+	}
+
+	relay := NewRelay(
+		WithDomain("example.com"),
+		WithVerb("NEG-OPEN", neg.HandleOpen),
+		WithVerb("NEG-MSG", neg.HandleMessage),
+		WithVerb("NEG-CLOSE", neg.HandleClose),
+	)
+	relay.On.Disconnect = neg.OnDisconnect
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readNeg reads the next NEG-MSG or NEG-ERR, skipping the AUTH challenge.
+func readNeg(t *testing.T, conn *ws.Conn) (string, string) {
+	t.Helper()
+	label, msg := readMessage(t, conn)
+	if label == "AUTH" {
+		label, msg = readMessage(t, conn)
+	}
+
+	var payload string
+	if len(msg) != 3 || json.Unmarshal(msg[2], &payload) != nil {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+	return label, payload
+}
+
+func TestNegentropy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relayOnly := signedNote(t, nostr.Now()-30)
+	shared := signedNote(t, nostr.Now()-20)
+	clientOnly := signedNote(t, nostr.Now()-10)
+
+	conn := negentropyRelay(t, ctx, DefaultNegentropyConfig(memoryQuery(relayOnly, shared)))
+
+	items := vector.New()
+	items.Insert(shared.CreatedAt, shared.ID)
+	items.Insert(clientOnly.CreatedAt, clientOnly.ID)
+	items.Seal()
+	client := negentropy.New(items, 0)
+
+	var haves, haveNots []string
+	done := make(chan struct{})
+	go func() {
+		for id := range client.Haves {
+			haves = append(haves, id)
+		}
+		for id := range client.HaveNots {
+			haveNots = append(haveNots, id)
+		}
+		close(done)
+	}()
+
+	send(t, conn, []any{"NEG-OPEN", "n", nostr.Filter{Kinds: []int{1}}, client.Start()})
+	for {
+		label, message := readNeg(t, conn)
+		if label != "NEG-MSG" {
+			t.Fatalf("expected a NEG-MSG, got %s %s", label, message)
+		}
+
+		next, err := client.Reconcile(message)
+		if err != nil {
+			t.Fatalf("failed to reconcile: %v", err)
+		}
+		if next == "" {
+			break
+		}
+		send(t, conn, []any{"NEG-MSG", "n", next})
+	}
+	send(t, conn, []any{"NEG-CLOSE", "n"})
+	<-done
+
+	if len(haves) != 1 || haves[0] != clientOnly.ID {
+		t.Fatalf("expected the relay to miss the event %s, got %v", clientOnly.ID, haves)
+	}
+	if len(haveNots) != 1 || haveNots[0] != relayOnly.ID {
+		t.Fatalf("expected the client to miss the event %s, got %v", relayOnly.ID, haveNots)
+	}
+}
+
+func TestNegentropyErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := DefaultNegentropyConfig(memoryQuery(signedNote(t, nostr.Now()-20), signedNote(t, nostr.Now()-10)))
+	config.MaxEvents = 2
+	config.MaxSessions = 1
+	conn := negentropyRelay(t, ctx, config)
+
+	start := negentropy.New(vector.New(), 0).Start()
+
+	// the filter matches as many events as the maximum
+	send(t, conn, []any{"NEG-OPEN", "big", nostr.Filter{}, start})
+	if label, reason := readNeg(t, conn); label != "NEG-ERR" || !strings.HasPrefix(reason, "blocked:") {
+		t.Fatalf("expected the reconciliation to be blocked, got %s %s", label, reason)
+	}
+
+	// the limit of the filter caps the events to reconcile
+	send(t, conn, []any{"NEG-OPEN", "a", nostr.Filter{Limit: 1}, start})
+	if label, message := readNeg(t, conn); label != "NEG-MSG" {
+		t.Fatalf("expected a NEG-MSG, got %s %s", label, message)
+	}
+
+	send(t, conn, []any{"NEG-OPEN", "b", nostr.Filter{Limit: 1}, start})
+	if label, reason := readNeg(t, conn); label != "NEG-ERR" || !strings.HasPrefix(reason, "rate-limited:") {
+		t.Fatalf("expected too many reconciliations, got %s %s", label, reason)
+	}
+
+	send(t, conn, []any{"NEG-CLOSE", "a"})
+	send(t, conn, []any{"NEG-MSG", "a", start})
+	if label, reason := readNeg(t, conn); label != "NEG-ERR" || !strings.HasPrefix(reason, "invalid:") {
+		t.Fatalf("expected the closed reconciliation to be invalid, got %s %s", label, reason)
+	}
+}
+
+func TestNegentropyDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	withheld := signedNote(t, nostr.Now()-10)
+	config := DefaultNegentropyConfig(memoryQuery(withheld))
+	config.Deliver = func(_ Client, _ string, e *nostr.Event) bool { return e.ID != withheld.ID }
+	conn := negentropyRelay(t, ctx, config)
+
+	client := negentropy.New(vector.New(), 0)
+	go func() {
+		for range client.Haves {
+		}
+	}()
+
+	send(t, conn, []any{"NEG-OPEN", "n", nostr.Filter{}, client.Start()})
+	_, message := readNeg(t, conn)
+	if _, err := client.Reconcile(message); err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+
+	for id := range client.HaveNots {
+		t.Fatalf("expected the withheld event to be left out of the reconciliation, got %s", id)
+	}
+}
@@ -0,0 +1,255 @@
+package rely
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+// NIP05Config configures the [NIP05Verifier].
+type NIP05Config struct {
+	// Timeout of each request of the well-known JSON.
+	Timeout time.Duration
+
+	// TTL is how long a successful verification is cached, and FailureTTL a failed one.
+	TTL        time.Duration
+	FailureTTL time.Duration
+
+	// Workers is the number of concurrent verifications.
+	Workers int
+
+	// QueueSize is the number of profiles waiting to be verified. When full, new profiles are not verified.
+	QueueSize int
+}
+
+// DefaultNIP05Config returns a [NIP05Config] with sane defaults.
+func DefaultNIP05Config() NIP05Config {
+	return NIP05Config{
+		Timeout:    5 * time.Second,
+		TTL:        24 * time.Hour,
+		FailureTTL: time.Hour,
+		Workers:    4,
+		QueueSize:  10_000,
+	}
+}
+
+// NIP05Verifier verifies the nip05 identifiers of the stored profiles (kind 0), fetching the well-known
+// JSON of their domain. The verified profiles are labeled "verified" with the [Labeler] (if not nil),
+// so that clients can query the labels to show only the verified profiles:
+//
+//	{"kinds": [1985], "#L": ["<namespace>"], "#l": ["verified"]}
+//
+// See https://github.com/nostr-protocol/nips/blob/master/05.md
+//
+// Example:
+//
+//	labeler, err := NewLabeler(relay, LabelerConfig{Namespace: "nip05", Cooldown: 24 * time.Hour, QueueSize: 1000})
+//	verifier, err := NewNIP05Verifier(labeler, DefaultNIP05Config())
+//	relay.On.Event = verifier.Save(relay.On.Event)
+//	go labeler.Run(ctx)
+//	go verifier.Run(ctx)
+type NIP05Verifier struct {
+	config  NIP05Config
+	labeler *Labeler
+	fetcher *fetch.Fetcher
+	queue   chan *nostr.Event
+
+	mu       sync.Mutex
+	cache    map[string]verification // by identifier
+	verified map[string]string       // pubkey -> identifier
+}
+
+type verification struct {
+	pubkey  string // the pubkey of the identifier, empty if it failed
+	expires time.Time
+}
+
+// NewNIP05Verifier returns a [NIP05Verifier] labeling the verified profiles with the labeler,
+// or an error if the config is invalid.
+func NewNIP05Verifier(labeler *Labeler, config NIP05Config) (*NIP05Verifier, error) {
+	if config.Timeout <= 0 {
+		return nil, errors.New("the NIP-05 timeout must be positive")
+	}
+
+	if config.Workers <= 0 || config.QueueSize <= 0 {
+		return nil, errors.New("the NIP-05 workers and queue size must be positive")
+	}
+
+	// the domains come from the profiles, and redirects must be ignored, as the domain vouches for the identifier
+	fetching := fetch.DefaultConfig()
+	fetching.Timeout = config.Timeout
+	fetching.MaxBodySize = 256 * 1024
+	fetching.MaxRedirects = 0
+
+	return &NIP05Verifier{
+		config:   config,
+		labeler:  labeler,
+		fetcher:  fetch.New(fetching),
+		queue:    make(chan *nostr.Event, config.QueueSize),
+		cache:    make(map[string]verification),
+		verified: make(map[string]string),
+	}, nil
+}
+
+// Verified returns the verified nip05 identifier of the pubkey, if any.
+func (v *NIP05Verifier) Verified(pubkey string) (identifier string, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	identifier, ok = v.verified[pubkey]
+	return identifier, ok
+}
+
+// Save wraps the On.Event hook, queueing the profiles with a nip05 identifier it saves successfully
+// to be verified, without blocking.
+func (v *NIP05Verifier) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		if e.Kind != nostr.KindProfileMetadata || !strings.Contains(e.Content, "nip05") {
+			return nil
+		}
+
+		select {
+		case v.queue <- e:
+		default:
+		}
+		return nil
+	}
+}
+
+// Run verifies the queued profiles with the configured number of workers, and periodically forgets
+// the expired verifications, until the context is cancelled.
+func (v *NIP05Verifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range v.config.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-v.queue:
+					v.verify(ctx, e)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+
+		case now := <-ticker.C:
+			v.prune(now)
+		}
+	}
+}
+
+// verify verifies the nip05 identifier of the profile, labeling it if verified.
+func (v *NIP05Verifier) verify(ctx context.Context, e *nostr.Event) {
+	var profile struct {
+		NIP05 string `json:"nip05"`
+	}
+
+	if err := json.Unmarshal([]byte(e.Content), &profile); err != nil || profile.NIP05 == "" {
+		return
+	}
+
+	identifier := strings.ToLower(strings.TrimSpace(profile.NIP05))
+	pubkey, err := v.resolve(ctx, identifier)
+	if err != nil || pubkey != e.PubKey {
+		v.mu.Lock()
+		if v.verified[e.PubKey] == identifier {
+			delete(v.verified, e.PubKey)
+		}
+		v.mu.Unlock()
+		return
+	}
+
+	v.mu.Lock()
+	v.verified[e.PubKey] = identifier
+	v.mu.Unlock()
+
+	if v.labeler != nil {
+		v.labeler.LabelEvent(e, "verified", identifier)
+	}
+}
+
+// resolve returns the pubkey of the identifier, from the cache or its well-known JSON.
+func (v *NIP05Verifier) resolve(ctx context.Context, identifier string) (string, error) {
+	now := time.Now()
+
+	v.mu.Lock()
+	cached, ok := v.cache[identifier]
+	v.mu.Unlock()
+
+	if ok && now.Before(cached.expires) {
+		return cached.pubkey, nil
+	}
+
+	pubkey, err := v.fetch(ctx, identifier)
+	ttl := v.config.TTL
+	if err != nil || pubkey == "" {
+		ttl = v.config.FailureTTL
+	}
+
+	v.mu.Lock()
+	v.cache[identifier] = verification{pubkey: pubkey, expires: now.Add(ttl)}
+	v.mu.Unlock()
+	return pubkey, err
+}
+
+// fetch returns the pubkey of the identifier in the well-known JSON of its domain, or an empty string if it has none.
+func (v *NIP05Verifier) fetch(ctx context.Context, identifier string) (string, error) {
+	name, domain, found := strings.Cut(identifier, "@")
+	if !found {
+		name, domain = "_", identifier
+	}
+
+	if name == "" || domain == "" || strings.ContainsAny(domain, "/?#@") {
+		return "", fmt.Errorf("invalid nip05 identifier %q", identifier)
+	}
+
+	endpoint := "https://" + domain + "/.well-known/nostr.json?name=" + url.QueryEscape(name)
+	body, err := v.fetcher.Get(ctx, endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	var document struct {
+		Names map[string]string `json:"names"`
+	}
+
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", fmt.Errorf("invalid well-known JSON of %s: %w", domain, err)
+	}
+	return document.Names[name], nil
+}
+
+func (v *NIP05Verifier) prune(now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for identifier, cached := range v.cache {
+		if now.After(cached.expires) {
+			delete(v.cache, identifier)
+		}
+	}
+}
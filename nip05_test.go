@@ -0,0 +1,85 @@
+package rely
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+func TestNIP05Verifier(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/.well-known/nostr.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"names": {"alice": %q}}`, pk)
+	}))
+	defer server.Close()
+
+	relay := NewRelay(WithIdentity(GenerateIdentity()))
+	labeler, err := NewLabeler(relay, LabelerConfig{Namespace: "nip05", QueueSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewNIP05Verifier(labeler, DefaultNIP05Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	fetching := fetch.DefaultConfig()
+	fetching.AllowPrivate = true
+	fetching.RootCAs = roots
+	verifier.fetcher = fetch.New(fetching)
+
+	domain := strings.TrimPrefix(server.URL, "https://")
+	other := "0" + pk[1:]
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		nip05    string
+		verified bool
+	}{
+		{name: "verified", pubkey: pk, nip05: "Alice@" + domain, verified: true},
+		{name: "other pubkey", pubkey: other, nip05: "alice@" + domain},
+		{name: "unknown name", pubkey: pk, nip05: "bob@" + domain},
+		{name: "invalid identifier", pubkey: pk, nip05: "alice@" + domain + "/path"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier.verified = make(map[string]string)
+			labeled := len(labeler.queue)
+
+			profile := &nostr.Event{PubKey: test.pubkey, Kind: 0, Content: fmt.Sprintf(`{"nip05": %q}`, test.nip05)}
+			verifier.verify(context.Background(), profile)
+
+			if _, verified := verifier.Verified(test.pubkey); verified != test.verified {
+				t.Errorf("expected verified %v, got %v", test.verified, verified)
+			}
+
+			if test.verified && len(labeler.queue) != labeled+1 {
+				t.Errorf("expected the profile to be labeled")
+			}
+		})
+	}
+
+	before := requests.Load()
+	verifier.verify(context.Background(), &nostr.Event{PubKey: pk, Kind: 0, Content: `{"nip05": "alice@` + domain + `"}`})
+	if requests.Load() != before {
+		t.Errorf("expected the verification to be cached")
+	}
+}
@@ -0,0 +1,64 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrNostrConnectAuth = fmt.Errorf("%w: you must be authenticated to use NIP-46 remote signing", ErrAuthRequired)
+
+// UnauthedNostrConnect is a Reject.Event hook that allows NIP-46 messages (kind 24133)
+// only from clients authenticated with the pubkey of the event's author.
+// Useful when the relay is a private transport for a remote signer.
+func UnauthedNostrConnect(ctx context.Context, c Client, e *nostr.Event) error {
+	if e.Kind != nostr.KindNostrConnect {
+		return nil
+	}
+
+	pubkey := c.Pubkey()
+	if pubkey == "" {
+		return ErrNostrConnectAuth
+	}
+
+	if pubkey != e.PubKey {
+		return fmt.Errorf("restricted: you can only publish NIP-46 messages as the pubkey %s", pubkey)
+	}
+	return nil
+}
+
+// UnauthedNostrConnectReq is a Reject.Req hook that allows only authenticated clients
+// to subscribe to NIP-46 messages (kind 24133), and only to those addressed to them.
+// Filters without kinds can match NIP-46 messages too, but they are allowed: the messages
+// they match are withheld by [UnauthedNostrConnectDeliver] instead.
+func UnauthedNostrConnectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	for _, filter := range filters {
+		if !slices.Contains(filter.Kinds, nostr.KindNostrConnect) {
+			continue
+		}
+
+		pubkey := c.Pubkey()
+		if pubkey == "" {
+			return ErrNostrConnectAuth
+		}
+
+		recipients := filter.Tags["p"]
+		if len(recipients) != 1 || recipients[0] != pubkey {
+			return fmt.Errorf("restricted: you can only request the NIP-46 messages of the pubkey %s", pubkey)
+		}
+	}
+	return nil
+}
+
+// UnauthedNostrConnectDeliver is an On.Deliver hook that withholds the NIP-46 messages (kind 24133)
+// from the clients that are not authenticated as their recipient.
+func UnauthedNostrConnectDeliver(c Client, _ string, e *nostr.Event) bool {
+	if e.Kind != nostr.KindNostrConnect {
+		return true
+	}
+
+	pubkey := c.Pubkey()
+	return pubkey != "" && e.Tags.ContainsAny("p", []string{pubkey})
+}
@@ -0,0 +1,68 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestUnauthedNostrConnectReq(t *testing.T) {
+	signer, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	tests := []struct {
+		name    string
+		pubkey  string
+		filter  nostr.Filter
+		allowed bool
+	}{
+		{name: "other kinds", filter: nostr.Filter{Kinds: []int{1}}, allowed: true},
+		{name: "IDs without kinds", filter: nostr.Filter{IDs: []string{"abc"}}, allowed: true},
+		{name: "unauthenticated", filter: nostr.Filter{Kinds: []int{nostr.KindNostrConnect}, Tags: nostr.TagMap{"p": {signer}}}},
+		{name: "unauthenticated without kinds", filter: nostr.Filter{Tags: nostr.TagMap{"p": {signer}}}, allowed: true},
+		{name: "unauthenticated authors", filter: nostr.Filter{Authors: []string{signer}}, allowed: true},
+		{name: "unauthenticated empty filter", filter: nostr.Filter{}, allowed: true},
+		{name: "addressed to another pubkey", pubkey: signer, filter: nostr.Filter{Kinds: []int{nostr.KindNostrConnect}, Tags: nostr.TagMap{"p": {other}}}},
+		{name: "another pubkey without kinds", pubkey: signer, filter: nostr.Filter{Tags: nostr.TagMap{"p": {other}}}, allowed: true},
+		{name: "addressed to the client", pubkey: signer, filter: nostr.Filter{Kinds: []int{nostr.KindNostrConnect}, Tags: nostr.TagMap{"p": {signer}}}, allowed: true},
+		{name: "the client without kinds", pubkey: signer, filter: nostr.Filter{Tags: nostr.TagMap{"p": {signer}}}, allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &client{pubkey: test.pubkey}
+			err := UnauthedNostrConnectReq(context.Background(), c, nostr.Filters{test.filter})
+			if allowed := err == nil; allowed != test.allowed {
+				t.Fatalf("expected allowed %v, got %v (%v)", test.allowed, allowed, err)
+			}
+		})
+	}
+}
+
+func TestUnauthedNostrConnectDeliver(t *testing.T) {
+	signer, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	message := &nostr.Event{Kind: nostr.KindNostrConnect, Tags: nostr.Tags{{"p", signer}}}
+	note := &nostr.Event{Kind: 1, Tags: nostr.Tags{{"p", signer}}}
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		event    *nostr.Event
+		expected bool
+	}{
+		{name: "recipient", pubkey: signer, event: message, expected: true},
+		{name: "unauthenticated", event: message},
+		{name: "another pubkey", pubkey: other, event: message},
+		{name: "other kinds", event: note, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := UnauthedNostrConnectDeliver(&client{pubkey: test.pubkey}, "sub", test.event); got != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
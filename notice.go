@@ -0,0 +1,93 @@
+package rely
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxRecentNotices is the maximum number of distinct messages remembered for deduplication per client.
+const maxRecentNotices = 64
+
+// noticeLimiter rate limits and deduplicates the NOTICEs sent to a client, so that a client
+// spamming invalid messages doesn't make the relay waste bandwidth echoing the same errors.
+// See [WithNoticeLimits].
+type noticeLimiter struct {
+	mu         sync.Mutex
+	tokens     float64
+	last       time.Time            // last refill of the tokens
+	recent     map[string]time.Time // message -> when it was last sent
+	suppressed int                  // notices suppressed since the last one sent
+}
+
+// allow reports whether the message can be sent now, and the number of notices suppressed before it.
+func (l *noticeLimiter) allow(msg string, rate float64, burst int, dedup time.Duration, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if dedup > 0 {
+		if sent, ok := l.recent[msg]; ok && now.Sub(sent) < dedup {
+			l.suppressed++
+			return false, 0
+		}
+	}
+
+	if rate > 0 {
+		if l.last.IsZero() {
+			l.tokens = float64(burst)
+		} else {
+			l.tokens = min(float64(burst), l.tokens+rate*now.Sub(l.last).Seconds())
+		}
+		l.last = now
+
+		if l.tokens < 1 {
+			l.suppressed++
+			return false, 0
+		}
+		l.tokens--
+	}
+
+	if dedup > 0 {
+		if l.recent == nil {
+			l.recent = make(map[string]time.Time, 8)
+		}
+
+		if len(l.recent) >= maxRecentNotices {
+			for m, sent := range l.recent {
+				if now.Sub(sent) >= dedup {
+					delete(l.recent, m)
+				}
+			}
+		}
+
+		if len(l.recent) < maxRecentNotices {
+			l.recent[msg] = now
+		}
+	}
+
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// notice sends the NOTICE to the client unless it's rate limited or a duplicate of a recent one.
+// The first NOTICE sent after some were suppressed reports how many.
+func (c *client) notice(msg string) {
+	r := c.relay
+	ok, suppressed := c.notices.allow(msg, r.noticeRate, r.noticeBurst, r.noticeDedup, time.Now())
+	if !ok {
+		r.stats.suppressedNotices.Add(1)
+		return
+	}
+
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d notices suppressed)", msg, suppressed)
+	}
+	c.send(noticeResponse{Message: msg})
+}
+
+// SuppressedNotices returns the number of NOTICEs not sent to the clients because they were rate limited
+// or duplicates of recent ones. See [WithNoticeLimits].
+func (r *Relay) SuppressedNotices() int64 {
+	return r.stats.suppressedNotices.Load()
+}
@@ -0,0 +1,70 @@
+package rely
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNoticeLimiter(t *testing.T) {
+	limiter := &noticeLimiter{}
+	now := time.Now()
+
+	if ok, _ := limiter.allow("a", 1, 2, time.Minute, now); !ok {
+		t.Fatal("the first notice should be allowed")
+	}
+
+	if ok, _ := limiter.allow("a", 1, 2, time.Minute, now); ok {
+		t.Fatal("the duplicate notice should be suppressed")
+	}
+
+	if ok, suppressed := limiter.allow("b", 1, 2, time.Minute, now); !ok || suppressed != 1 {
+		t.Fatalf("expected the second notice to be allowed after 1 suppressed, got %v and %d", ok, suppressed)
+	}
+
+	if ok, _ := limiter.allow("c", 1, 2, time.Minute, now); ok {
+		t.Fatal("the third notice should exceed the burst")
+	}
+
+	ok, suppressed := limiter.allow("c", 1, 2, time.Minute, now.Add(time.Second))
+	if !ok || suppressed != 1 {
+		t.Fatalf("expected the notice to be allowed after 1 suppressed, got %v and %d", ok, suppressed)
+	}
+
+	if ok, _ := limiter.allow("a", 1, 2, time.Minute, now.Add(2*time.Minute)); !ok {
+		t.Fatal("the notice should be allowed after the dedup window")
+	}
+}
+
+func TestNoticeLimiterDisabled(t *testing.T) {
+	limiter := &noticeLimiter{}
+	for range 100 {
+		if ok, _ := limiter.allow("a", 0, 0, 0, time.Now()); !ok {
+			t.Fatal("all notices should be allowed")
+		}
+	}
+}
+
+func TestClientNotice(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithNoticeLimits(1, 10, time.Minute))
+	client := &client{relay: relay, responses: make(chan response, 10)}
+
+	for range 5 {
+		client.SendNotice("error: invalid message")
+	}
+
+	if len(client.responses) != 1 {
+		t.Fatalf("expected 1 notice, got %d", len(client.responses))
+	}
+
+	client.SendNotice("error: another message")
+	<-client.responses
+	notice := (<-client.responses).(noticeResponse)
+
+	if notice.Message != "error: another message (4 notices suppressed)" {
+		t.Fatalf("unexpected notice %q", notice.Message)
+	}
+
+	if suppressed := relay.SuppressedNotices(); suppressed != 4 {
+		t.Fatalf("expected 4 suppressed notices, got %d", suppressed)
+	}
+}
@@ -1,13 +1,14 @@
 package rely
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/goccy/go-json"
-
 	ws "github.com/gorilla/websocket"
 	"github.com/nbd-wtf/go-nostr/nip11"
 )
@@ -18,6 +19,7 @@ const (
 	pingPeriod     time.Duration = 45 * time.Second
 	maxMessageSize int64         = 500000 // 0.5MB
 	bufferSize     int           = 1024   // 1KB
+	maxBatchBytes  int           = 65536  // 64KB
 )
 
 type Option func(*Relay)
@@ -31,15 +33,13 @@ func WithDomain(d string) Option {
 
 // WithInfo sets a custom NIP-11 (Relay Information Document) JSON body returned
 // when a request includes `Accept: application/nostr+json`.
-// If not set, a default document is used.
+// If not set, a default document is used. To change it at runtime, use [Relay.SetInfo].
 func WithInfo(info nip11.RelayInformationDocument) Option {
 	return func(r *Relay) {
-		json, err := json.Marshal(info)
-		if err != nil {
-			panic("failed to marshal NIP-11 document: " + err.Error())
+		// the limits of the settings are filled once all the options are applied
+		if err := r.setInfo(info); err != nil {
+			panic(err.Error())
 		}
-
-		r.info = json
 	}
 }
 
@@ -77,6 +77,104 @@ func WithClientResponseLimit(n int) Option {
 	return func(r *Relay) { r.responseLimit = n }
 }
 
+// WithResponseBudget sets how the number of stored events returned to a client is limited,
+// and the budget of events for the chosen [BudgetMode]:
+//   - [BudgetPerREQ]: the sum of the filters' limits of each REQ is at most the budget.
+//   - [BudgetPerFilter]: the limit of each filter is at most the budget.
+//   - [BudgetPerSecond]: each connection receives at most the budget of stored events per second.
+//
+// In all modes, the client response limit still applies. When a filter's limit is reduced
+// and its results are truncated, the client is informed with a NOTICE.
+// Budget must be greater than 0.
+func WithResponseBudget(mode BudgetMode, budget int) Option {
+	return func(r *Relay) {
+		r.budgetMode = mode
+		r.budget = budget
+	}
+}
+
+// WithWriteBatching coalesces up to maxFrames outgoing websocket frames destined for the same client
+// into a single write to the connection, reducing syscall overhead when a client receives
+// many small messages in a burst (e.g. many subscriptions matching the same events).
+// Frames already queued are always batched; window is how long to wait for more before flushing.
+// Keep it small (e.g. 1ms), as it delays the delivery of the first frame of the batch.
+// A maxFrames <= 1 disables batching, which is the default.
+func WithWriteBatching(maxFrames int, window time.Duration) Option {
+	return func(r *Relay) {
+		r.batchFrames = maxFrames
+		r.batchWindow = window
+	}
+}
+
+// WithResponseChunking sends the stored events of a REQ in chunks of the given size, pausing
+// between chunks until the client's response buffer has room for the next one, instead of
+// dropping the responses that don't fit. The pause is at most maxPause per chunk, after which
+// the subscription is closed because the client is reading too slowly.
+//
+// When enabled, the events returned by a REQ are no longer capped by the remaining capacity
+// of the client's response buffer, only by the [BudgetMode], so archive-crawling clients can be
+// served large responses (see [WithResponseBudget]) at the pace they can read them.
+// The chunk size must be smaller than the client response limit. A size <= 0 disables chunking, which is the default.
+func WithResponseChunking(size int, maxPause time.Duration) Option {
+	return func(r *Relay) {
+		r.chunkSize = size
+		r.chunkPause = maxPause
+	}
+}
+
+// WithMemoryBudget sets a hard cap on the bytes of the events buffered in the relay:
+// the ingest queue, the send queues of the clients and their write batches.
+// Once exceeded, load is shed deterministically instead of risking OOM kills:
+// new EVENTs are rejected with [ErrMemoryBudget], and REQ responses are truncated to fit
+// the remaining budget. Events broadcast to many clients are accounted once per client.
+// A budget <= 0 disables the cap, which is the default.
+func WithMemoryBudget(bytes int64) Option {
+	return func(r *Relay) { r.memoryLimit = bytes }
+}
+
+// WithSubscriptionLimits sets the maximum lifetime of a subscription and the maximum number of events
+// delivered to it, stored and live combined. Once either is exceeded, the subscription is closed with
+// [ErrSubscriptionLifetime] or [ErrSubscriptionEvents], so that firehose-style subscriptions
+// (e.g. with an empty filter) can't be held open forever. Clients are free to open a new one.
+// Values <= 0 disable the corresponding limit, which is the default.
+func WithSubscriptionLimits(maxLifetime time.Duration, maxEvents int) Option {
+	return func(r *Relay) {
+		r.maxSubLifetime = maxLifetime
+		r.maxSubEvents = maxEvents
+	}
+}
+
+// WithBandwidth accounts the bytes sent to and received from the clients in the provided [Bandwidth],
+// by pubkey if they are authenticated or by IP otherwise. Use its hooks to enforce the daily caps.
+func WithBandwidth(b *Bandwidth) Option {
+	return func(r *Relay) { r.bandwidth = b }
+}
+
+// WithAuthenticators authenticates the clients when they connect, with the credentials of the websocket
+// upgrade request (e.g. [TokenAuth], [BasicAuth], [CertAuth]), tried in order. The client is authenticated
+// as the pubkey of the first authenticator accepting its credentials, exposed by [Client.Pubkey] as if
+// it had authenticated with NIP-42, which remains available unless disabled with [WithoutAuth].
+//
+// Requests with invalid credentials are refused with 401 Unauthorized, and so are those without
+// credentials if required is true. Note that [Client.SendAuth] clears the authenticated pubkey.
+func WithAuthenticators(required bool, authenticators ...Authenticator) Option {
+	return func(r *Relay) {
+		r.authRequired = required
+		r.authenticators = authenticators
+	}
+}
+
+// WithGeoIP resolves the country of the clients when they connect, exposed by [Client.Country].
+func WithGeoIP(geo GeoIP) Option {
+	return func(r *Relay) { r.geoip = geo }
+}
+
+// WithIdentity sets the keypair of the relay, whose public key is advertised in the NIP-11 document
+// and which signs the events published with [Relay.Publish].
+func WithIdentity(id *Identity) Option {
+	return func(r *Relay) { r.identity = id }
+}
+
 // WithReadBufferSize sets the read buffer size (in bytes) for the underlying websocket connection upgrader.
 func WithReadBufferSize(s int) Option {
 	return func(r *Relay) { r.upgrader.ReadBufferSize = s }
@@ -111,6 +209,162 @@ func WithMaxMessageSize(s int64) Option {
 	return func(r *Relay) { r.maxMessageSize = s }
 }
 
+// WithAllowedOrigins refuses the websocket upgrade of browser requests whose Origin header doesn't
+// match any of the origins, protecting browser clients from cross-site websocket hijacking.
+// Origins can be full (e.g. "https://app.example.com"), hosts valid for any scheme (e.g. "app.example.com"),
+// or have a leading wildcard label (e.g. "https://*.example.com"). Requests without an Origin header
+// don't come from browsers, so they are always accepted. If unset, all origins are accepted.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(r *Relay) { r.origins = origins }
+}
+
+// WithAllowedHosts refuses the websocket upgrade of requests whose Host header doesn't match any of the hosts,
+// with a 421 Misdirected Request. It protects against DNS rebinding and rejects misrouted traffic.
+// Hosts can have a leading wildcard label (e.g. "*.example.com"). If unset, all hosts are accepted.
+func WithAllowedHosts(hosts ...string) Option {
+	return func(r *Relay) { r.hosts = hosts }
+}
+
+// WithTrustedProxies sets the CIDR ranges of the reverse proxies in front of the relay, the only ones whose
+// X-Real-IP and X-Forwarded-For headers are trusted to resolve the IP of the clients (see [TrustedIP]), since any
+// client can set them. The IP resolved by the relay is the one returned by [IP] in the hooks, and by [Client.IP].
+// The default trusts only the loopback addresses. It panics if any of the networks is invalid.
+func WithTrustedProxies(networks ...string) Option {
+	return func(r *Relay) {
+		r.proxies = make([]*net.IPNet, 0, len(networks))
+		for _, cidr := range networks {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				panic(fmt.Sprintf("invalid trusted proxy network %q: %v", cidr, err))
+			}
+			r.proxies = append(r.proxies, network)
+		}
+	}
+}
+
+// WithRecorder sets a [Recorder] that receives every websocket message exchanged with the sampled clients.
+// Useful for diagnosing client interoperability bugs. See [FileRecorder] for a ready-made implementation.
+func WithRecorder(rec Recorder) Option {
+	return func(r *Relay) { r.recorder = rec }
+}
+
+// WithoutAuth disables NIP-42 authentication: AUTH messages are rejected with [ErrUnsupportedNIP42]
+// and [Client.SendAuth] sends no challenge, so no client can ever authenticate.
+func WithoutAuth() Option {
+	return func(r *Relay) { r.authDisabled = true }
+}
+
+// WithAuthChallengeTTL sets for how long a NIP-42 challenge can be answered after it's sent. AUTH events
+// answering an expired challenge are rejected with [ErrAuthChallengeExpired], and a fresh challenge is sent.
+// A zero duration disables the expiry. The default is 10 minutes.
+func WithAuthChallengeTTL(d time.Duration) Option {
+	return func(r *Relay) { r.authChallengeTTL = d }
+}
+
+// WithConnectionScopes lets the clients constrain everything on their connection to some kinds
+// and authors, with the query parameters of the connection URL, a pattern some mobile clients
+// use to cut bandwidth:
+//
+//	wss://relay.example.com/?kinds=1,7&authors=<pubkey>
+//
+// The filters of the REQs and COUNTs without kinds or authors are narrowed to those of the scope,
+// while the filters and the EVENTs outside of it are rejected with [ErrOutOfScope], before the Reject hooks.
+// Connections with an invalid scope are refused with [ErrInvalidScope].
+func WithConnectionScopes() Option {
+	return func(r *Relay) { r.connectionScopes = true }
+}
+
+// WithFastKinds sets the event kinds that take a low-latency path: they are processed
+// before any other request and bypass the On.Event hook entirely, being acknowledged and
+// broadcasted to the matching subscriptions without being stored.
+// Useful for ephemeral messages that need to be delivered fast, like NIP-46 remote signing (kind 24133).
+// Reject.Event hooks still apply.
+func WithFastKinds(kinds ...int) Option {
+	return func(r *Relay) { r.fastKinds = kinds }
+}
+
+// WithReplaceableUpdates pushes the new versions of replaceable and addressable events (e.g. profiles,
+// follow lists and articles) to the subscriptions that received a previous version for a filter by IDs,
+// which the new versions never match. Clients tracking profiles this way get updates without re-sending their REQ.
+func WithReplaceableUpdates() Option {
+	return func(r *Relay) { r.replaceableUpdates = true }
+}
+
+// WithNoticeLimits limits the NOTICEs sent to each client to a rate per second, with the burst,
+// and suppresses those identical to one sent within the dedup window, so that a client spamming
+// invalid messages doesn't make the relay echo thousands of identical errors.
+// The first NOTICE sent after some were suppressed reports how many. OK and CLOSED messages are never suppressed.
+// A rate <= 0 disables the rate limit, and a dedup <= 0 the deduplication.
+// By default, the rate is 1/s with a burst of 10, and the dedup window is 10s.
+func WithNoticeLimits(rate float64, burst int, dedup time.Duration) Option {
+	return func(r *Relay) {
+		r.noticeRate = rate
+		r.noticeBurst = burst
+		r.noticeDedup = dedup
+	}
+}
+
+// WithUnknownMessages sets how the relay responds to the messages with an unrecognized type,
+// after invoking the On.Unknown hook (if set). See [UnknownMode] for the available modes.
+// By default, the relay responds with a NOTICE.
+func WithUnknownMessages(mode UnknownMode) Option {
+	return func(r *Relay) { r.unknownMode = mode }
+}
+
+// WithVerb registers the handler of the messages with a custom verb, e.g. ["STATS"] or ["SYNC", ...],
+// for relay-specific extensions of the protocol. The handler receives the raw JSON of the message,
+// which is reused after it returns, so it must be copied to be retained. It runs on the read loop of
+// the connection, so long operations should be run in a goroutine. Responses are sent with
+// [Client.SendMessage], and the errors returned are sent to the client as a NOTICE.
+// It panics if the verb is one of those of the protocol (EVENT, REQ, COUNT, CLOSE and AUTH).
+//
+// Example:
+//
+//	relay := NewRelay(WithVerb("STATS", func(ctx context.Context, c Client, raw []byte) error {
+//		c.SendMessage([]byte(`["STATS",{"clients":10}]`))
+//		return nil
+//	}))
+func WithVerb(verb string, handler func(ctx context.Context, c Client, raw []byte) error) Option {
+	return func(r *Relay) {
+		switch verb {
+		case "", "EVENT", "REQ", "COUNT", "CLOSE", "AUTH":
+			panic(fmt.Sprintf("the verb %q can't be registered", verb))
+		}
+
+		if r.verbs == nil {
+			r.verbs = make(map[string]func(context.Context, Client, []byte) error)
+		}
+		r.verbs[verb] = handler
+	}
+}
+
+// WithHookTimeout sets the deadline of the context passed to the hooks of each message, so that slow
+// storage calls, policies and webhooks are cancelled instead of piling up. The deadline applies separately to
+// the Reject hooks, run when the message is read, and to the On hooks, run when it's processed.
+// A timeout <= 0 disables the deadline, which is the default. In all cases, the context is cancelled
+// when the client disconnects.
+func WithHookTimeout(d time.Duration) Option {
+	return func(r *Relay) { r.hookTimeout = d }
+}
+
+// WithArchiveMode serves the REQs of the authenticated researchers that crawl the history in time buckets
+// (see [Relay.IsArchiveReq]) in archive mode, so that bulk exports are not treated as abusive:
+//   - the events returned are capped by [ArchiveModeConfig.MaxEvents] instead of the response budget
+//     and the remaining capacity of the client's response buffer.
+//   - the subscription limits (see [WithSubscriptionLimits]) don't apply.
+//   - the events are sent in chunks at the pace of the researcher, and at most at [ArchiveModeConfig.Rate].
+//
+// The memory budget (see [WithMemoryBudget]) still applies. It panics if the config is invalid.
+func WithArchiveMode(config ArchiveModeConfig) Option {
+	return func(r *Relay) {
+		researchers := make(map[string]struct{}, len(config.Researchers))
+		for _, pubkey := range config.Researchers {
+			researchers[pubkey] = struct{}{}
+		}
+		r.archive = &archiveMode{config: config, researchers: researchers}
+	}
+}
+
 type systemSettings struct {
 	// the maximum number of responses sent to a client at once.
 	// To specify it, use [WithClientResponseLimit].
@@ -124,41 +378,111 @@ type systemSettings struct {
 	// and sent to the client, enforcing per-client backpressure and preventing overproduction of responses.
 	responseLimit int
 
+	// how the stored events returned to a client are limited, and the budget of the mode.
+	// To specify them, use [WithResponseBudget]. If unset, the budget is the responseLimit per REQ.
+	budgetMode BudgetMode
+	budget     int
+
 	// the relay domain name (e.g., "example.com") used to validate the NIP-42 "relay" tag.
 	// It should be explicitly set with [WithDomain]; if unset, a warning will be logged and NIP-42 will fail.
 	domain string
 
-	// the NIP-11 relay info document json. To specify it, use [WithInfo].
-	info []byte
+	// the number of stored events sent before waiting for the client's response buffer to drain,
+	// and the maximum wait. To specify them, use [WithResponseChunking].
+	chunkSize  int
+	chunkPause time.Duration
+
+	// whether NIP-42 authentication is disabled. To disable it, use [WithoutAuth].
+	authDisabled bool
+
+	// for how long a NIP-42 challenge can be answered. To specify it, use [WithAuthChallengeTTL].
+	authChallengeTTL time.Duration
+
+	// the authenticators of the upgrade requests, and whether authentication is required.
+	// To specify them, use [WithAuthenticators].
+	authenticators []Authenticator
+	authRequired   bool
+
+	// the maximum bytes of buffered events. To specify it, use [WithMemoryBudget].
+	memoryLimit int64
+
+	// the optional recorder of the wire traffic. To specify it, use [WithRecorder].
+	recorder Recorder
+
+	// the maximum lifetime of a subscription and the maximum events delivered to it.
+	// To specify them, use [WithSubscriptionLimits].
+	maxSubLifetime time.Duration
+	maxSubEvents   int
+
+	// the optional accounting of the bytes sent and received by pubkey and IP.
+	// To specify it, use [WithBandwidth].
+	bandwidth *Bandwidth
+
+	// the optional resolver of the country of the clients. To specify it, use [WithGeoIP].
+	geoip GeoIP
+
+	// the optional keypair of the relay. To specify it, use [WithIdentity].
+	identity *Identity
+
+	// whether the clients can scope their connection with URL query parameters.
+	// To enable it, use [WithConnectionScopes].
+	connectionScopes bool
+
+	// the kinds of events that bypass the On.Event hook and the processing queue.
+	// To specify them, use [WithFastKinds].
+	fastKinds []int
+
+	// whether the new versions of replaceable events are pushed to the subscriptions that
+	// received the previous ones by ID. To enable it, use [WithReplaceableUpdates].
+	replaceableUpdates bool
+
+	// the rate and burst of the NOTICEs sent to each client, and the window in which
+	// identical ones are suppressed. To specify them, use [WithNoticeLimits].
+	noticeRate  float64
+	noticeBurst int
+	noticeDedup time.Duration
+
+	// how the messages with an unrecognized type are handled. To specify it, use [WithUnknownMessages].
+	unknownMode UnknownMode
+
+	// the handlers of the custom verbs. To register them, use [WithVerb].
+	verbs map[string]func(context.Context, Client, []byte) error
+
+	// the deadline of the context passed to the hooks. To specify it, use [WithHookTimeout].
+	hookTimeout time.Duration
+
+	// the optional archive query mode of the researchers. To enable it, use [WithArchiveMode].
+	archive *archiveMode
 }
 
 func newSystemSettings() systemSettings {
 	return systemSettings{
 		responseLimit: 1000,
-		info:          newRelayInfo(),
-	}
-}
+		noticeRate:    1,
+		noticeBurst:   10,
+		noticeDedup:   10 * time.Second,
 
-func newRelayInfo() []byte {
-	info := nip11.RelayInformationDocument{
-		Software:      "https://github.com/nostr-net/rely",
-		SupportedNIPs: []any{1, 11, 42},
+		authChallengeTTL: 10 * time.Minute,
 	}
-
-	json, err := json.Marshal(info)
-	if err != nil {
-		panic("failed to marshal default NIP-11 document: " + err.Error())
-	}
-
-	return json
 }
 
 type websocketSettings struct {
 	upgrader       ws.Upgrader
+	batchFrames    int
+	batchWindow    time.Duration
 	writeWait      time.Duration
 	pongWait       time.Duration
 	pingPeriod     time.Duration
 	maxMessageSize int64
+
+	// the allowlists of the Host and Origin headers of the websocket upgrade.
+	// To specify them, use [WithAllowedHosts] and [WithAllowedOrigins].
+	hosts   []string
+	origins []string
+
+	// the networks of the proxies whose X-Real-IP and X-Forwarded-For headers are trusted.
+	// To specify them, use [WithTrustedProxies].
+	proxies []*net.IPNet
 }
 
 func newWebsocketSettings() websocketSettings {
@@ -172,6 +496,7 @@ func newWebsocketSettings() websocketSettings {
 		pongWait:       pongWait,
 		pingPeriod:     pingPeriod,
 		maxMessageSize: maxMessageSize,
+		proxies:        loopbackNetworks,
 	}
 }
 
@@ -190,6 +515,10 @@ func (r *Relay) validate() {
 		panic("write wait must be greater than 1s to function reliably")
 	}
 
+	if r.batchWindow < 0 || r.batchWindow > time.Second {
+		panic("write batching window must be between 0 and 1s to not delay responses excessively")
+	}
+
 	if r.maxMessageSize < 512 {
 		panic("max message size must be greater than 512 bytes to accept nostr events")
 	}
@@ -202,6 +531,39 @@ func (r *Relay) validate() {
 		panic("client response limit must be greater than 1 to allow responses to be sent")
 	}
 
+	if r.budget == 0 {
+		r.budget = r.responseLimit
+	}
+
+	if r.budget < 1 {
+		panic("response budget must be greater than 1 to allow events to be sent")
+	}
+
+	if r.chunkSize > 0 && r.chunkSize >= r.responseLimit {
+		panic("response chunk size must be smaller than the client response limit to fit in the response buffer")
+	}
+
+	if r.chunkSize > 0 && r.chunkPause <= 0 {
+		panic("response chunk pause must be positive to allow the client to read")
+	}
+
+	if r.authChallengeTTL < 0 {
+		panic("auth challenge TTL must not be negative, use zero to disable the expiry")
+	}
+
+	if r.archive != nil {
+		if err := r.archive.config.validate(); err != nil {
+			panic(err.Error())
+		}
+		if r.archive.config.ChunkSize >= r.responseLimit {
+			panic("archive chunk size must be smaller than the client response limit to fit in the response buffer")
+		}
+	}
+
+	if r.noticeRate > 0 && r.noticeBurst < 1 {
+		panic("notice burst must be greater than 1 to allow notices to be sent")
+	}
+
 	if r.domain == "" {
 		r.log.Warn("you must set the relay's domain to validate NIP-42 auth")
 	}
@@ -0,0 +1,86 @@
+package rely
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrOriginNotAllowed = errors.New("the origin of the request is not allowed")
+	ErrHostNotAllowed   = errors.New("the host of the request is not served by this relay")
+)
+
+// checkRequest validates the Host and Origin headers of the websocket upgrade against the allowlists
+// set with [WithAllowedHosts] and [WithAllowedOrigins], returning the error and the HTTP status to respond with.
+// It protects browser clients from DNS rebinding and cross-site websocket hijacking, and rejects misrouted traffic.
+func (r *Relay) checkRequest(req *http.Request) (int, error) {
+	if len(r.hosts) > 0 && !matchesHost(hostname(req.Host), r.hosts) {
+		return http.StatusMisdirectedRequest, ErrHostNotAllowed
+	}
+
+	if len(r.origins) > 0 && !allowedOrigin(req.Header.Get("Origin"), r.origins) {
+		return http.StatusForbidden, ErrOriginNotAllowed
+	}
+	return 0, nil
+}
+
+// allowedOrigin reports whether the Origin header matches any of the allowed origins.
+// Requests without an Origin don't come from browsers, so they are always allowed.
+func allowedOrigin(origin string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		// opaque origins (e.g. "null" from sandboxed iframes) are never allowed
+		return false
+	}
+
+	for _, a := range allowed {
+		if a == "*" {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(a, "://")
+		if !ok {
+			// the allowed origin is a host, valid for any scheme
+			scheme, host = u.Scheme, a
+		}
+
+		if strings.EqualFold(scheme, u.Scheme) && matchesHost(strings.ToLower(u.Host), []string{host}) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHost reports whether the lowercase host matches any of the patterns,
+// which can have a leading wildcard label, e.g. "*.example.com".
+func matchesHost(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// hostname returns the lowercase host, without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
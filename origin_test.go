@@ -0,0 +1,65 @@
+package rely
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllowedOrigin(t *testing.T) {
+	allowed := []string{"https://app.example.com", "*.nostr.com", "http://localhost:3000"}
+	tests := []struct {
+		origin   string
+		expected bool
+	}{
+		{origin: "", expected: true},
+		{origin: "https://app.example.com", expected: true},
+		{origin: "https://APP.example.com", expected: true},
+		{origin: "http://app.example.com", expected: false},
+		{origin: "https://evil.com", expected: false},
+		{origin: "https://client.nostr.com", expected: true},
+		{origin: "http://client.nostr.com", expected: true},
+		{origin: "https://nostr.com.evil.com", expected: false},
+		{origin: "http://localhost:3000", expected: true},
+		{origin: "http://localhost:4000", expected: false},
+		{origin: "null", expected: false},
+	}
+
+	for _, test := range tests {
+		if got := allowedOrigin(test.origin, allowed); got != test.expected {
+			t.Errorf("origin %q: expected %v, got %v", test.origin, test.expected, got)
+		}
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	relay := NewRelay(
+		WithDomain("relay.example.com"),
+		WithAllowedHosts("relay.example.com"),
+		WithAllowedOrigins("https://app.example.com"),
+	)
+
+	tests := []struct {
+		name     string
+		host     string
+		origin   string
+		expected int
+	}{
+		{name: "valid", host: "relay.example.com:443", origin: "https://app.example.com", expected: 0},
+		{name: "no origin", host: "RELAY.example.com", expected: 0},
+		{name: "rebinding", host: "attacker.com", origin: "https://attacker.com", expected: http.StatusMisdirectedRequest},
+		{name: "cross-site", host: "relay.example.com", origin: "https://attacker.com", expected: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &http.Request{Host: test.host, Header: http.Header{}}
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			if status, _ := relay.checkRequest(req); status != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, status)
+			}
+		})
+	}
+}
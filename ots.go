@@ -0,0 +1,534 @@
+package rely
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+// NotaryConfig configures the [Notary].
+type NotaryConfig struct {
+	// Calendar is the URL of the OpenTimestamps calendar server.
+	Calendar string
+
+	// Kinds of the events to timestamp. If empty, all kinds are timestamped.
+	Kinds []int
+
+	// Interval between the submissions of the batches of stored events to the calendar.
+	// Each batch is aggregated in a merkle tree, whose root is the only digest submitted.
+	Interval time.Duration
+
+	// UpgradeInterval between the requests of the Bitcoin attestations of the submitted batches,
+	// which are available a few hours after the submission.
+	UpgradeInterval time.Duration
+
+	// MaxAge is how long a batch waits for its Bitcoin attestation before being dropped.
+	MaxAge time.Duration
+
+	// QueueSize is the number of events waiting to be submitted. When full, new events are not timestamped.
+	QueueSize int
+}
+
+// DefaultNotaryConfig returns a [NotaryConfig] with sane defaults.
+func DefaultNotaryConfig() NotaryConfig {
+	return NotaryConfig{
+		Calendar:        "https://alice.btc.calendar.opentimestamps.org",
+		Interval:        10 * time.Minute,
+		UpgradeInterval: time.Hour,
+		MaxAge:          72 * time.Hour,
+		QueueSize:       100_000,
+	}
+}
+
+// Notary turns the relay into a notarization service: it timestamps the stored events with an
+// OpenTimestamps calendar, and publishes their NIP-03 attestations (kind 1040) once they are anchored
+// in the Bitcoin blockchain, signed with the relay's [Identity].
+// The batches waiting for their attestation are kept in memory, and lost on restart.
+// See https://github.com/nostr-protocol/nips/blob/master/03.md
+//
+// Example:
+//
+//	notary, err := NewNotary(relay, DefaultNotaryConfig())
+//	relay.On.Event = notary.Save(relay.On.Event)
+//	go notary.Run(ctx)
+type Notary struct {
+	relay   *Relay
+	config  NotaryConfig
+	fetcher *fetch.Fetcher
+	queue   chan leaf
+
+	mu      sync.Mutex
+	batches []*otsBatch
+
+	published atomic.Int64
+}
+
+// leaf is an event to timestamp.
+type leaf struct {
+	id   string
+	kind int
+	path []byte // serialized operations from the ID to the root of the batch
+}
+
+// otsBatch is a batch of events submitted to the calendar, waiting for its Bitcoin attestation.
+type otsBatch struct {
+	leaves     []leaf
+	calendar   []byte // serialized operations from the root to the commitment of the calendar
+	commitment []byte
+	submitted  time.Time
+}
+
+// NewNotary returns a [Notary] of the relay, or an error if the relay has no [Identity] or the config is invalid.
+func NewNotary(relay *Relay, config NotaryConfig) (*Notary, error) {
+	if relay.Identity() == nil {
+		return nil, ErrNoIdentity
+	}
+
+	if config.Calendar == "" {
+		return nil, errors.New("the calendar URL is required")
+	}
+
+	if config.Interval <= 0 || config.UpgradeInterval <= 0 {
+		return nil, errors.New("the notary intervals must be positive")
+	}
+
+	if config.QueueSize <= 0 {
+		return nil, errors.New("the notary queue size must be positive")
+	}
+
+	// the calendar is chosen by the operator, and can be self-hosted in a private network
+	fetching := fetch.DefaultConfig()
+	fetching.Timeout = 30 * time.Second
+	fetching.MaxBodySize = 64 * 1024
+	fetching.HostRate = 0
+	fetching.AllowPrivate = true
+
+	return &Notary{
+		relay:   relay,
+		config:  config,
+		fetcher: fetch.New(fetching),
+		queue:   make(chan leaf, config.QueueSize),
+	}, nil
+}
+
+// Pending returns the number of events submitted to the calendar, waiting for their Bitcoin attestation.
+func (n *Notary) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	pending := 0
+	for _, batch := range n.batches {
+		pending += len(batch.leaves)
+	}
+	return pending
+}
+
+// Published returns the number of attestations published.
+func (n *Notary) Published() int64 { return n.published.Load() }
+
+// Save wraps the On.Event hook, queueing the events it saves successfully to be timestamped, without blocking.
+// The attestations themselves are not timestamped.
+func (n *Notary) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		if e.Kind == nostr.KindOpenTimestamps {
+			return nil
+		}
+
+		if len(n.config.Kinds) > 0 && !slices.Contains(n.config.Kinds, e.Kind) {
+			return nil
+		}
+
+		select {
+		case n.queue <- leaf{id: e.ID, kind: e.Kind}:
+		default:
+			n.relay.log.Warn("notary queue is full, the event won't be timestamped", "id", e.ID)
+		}
+		return nil
+	}
+}
+
+// Run submits the queued events to the calendar every interval, and publishes the attestations
+// of the submitted batches when available, until the context is cancelled.
+func (n *Notary) Run(ctx context.Context) {
+	submit := time.NewTicker(n.config.Interval)
+	defer submit.Stop()
+
+	upgrade := time.NewTicker(n.config.UpgradeInterval)
+	defer upgrade.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-submit.C:
+			if err := n.submit(ctx); err != nil {
+				n.relay.log.Error("failed to submit the events to the calendar", "error", err)
+			}
+
+		case <-upgrade.C:
+			n.upgrade(ctx)
+		}
+	}
+}
+
+// submit aggregates the queued events in a merkle tree, and submits its root to the calendar.
+func (n *Notary) submit(ctx context.Context) error {
+	var leaves []leaf
+	for len(n.queue) > 0 {
+		leaves = append(leaves, <-n.queue)
+	}
+
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	root, err := merkle(leaves)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.config.Calendar+"/digest", bytes.NewReader(root))
+	if err != nil {
+		return err
+	}
+
+	response, err := n.fetch(request)
+	if err != nil {
+		return err
+	}
+
+	ops, commitment, err := pendingCommitment(response, root)
+	if err != nil {
+		return fmt.Errorf("invalid calendar timestamp: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.batches = append(n.batches, &otsBatch{
+		leaves:     leaves,
+		calendar:   ops,
+		commitment: commitment,
+		submitted:  time.Now(),
+	})
+	return nil
+}
+
+// upgrade requests the Bitcoin attestations of the submitted batches, publishing those available
+// and dropping the batches older than the maximum age.
+func (n *Notary) upgrade(ctx context.Context) {
+	n.mu.Lock()
+	batches := n.batches
+	n.batches = nil
+	n.mu.Unlock()
+
+	var waiting []*otsBatch
+	for _, batch := range batches {
+		attestation, err := n.attestation(ctx, batch.commitment)
+		if err != nil {
+			n.relay.log.Error("failed to upgrade the timestamp", "commitment", hex.EncodeToString(batch.commitment), "error", err)
+		}
+
+		switch {
+		case attestation != nil:
+			n.publish(batch, attestation)
+
+		case time.Since(batch.submitted) < n.config.MaxAge:
+			waiting = append(waiting, batch)
+
+		default:
+			n.relay.log.Warn("dropping the timestamps without a Bitcoin attestation", "events", len(batch.leaves))
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.batches = append(waiting, n.batches...)
+}
+
+// attestation returns the timestamp of the commitment anchored in Bitcoin, or nil if it's not available yet.
+func (n *Notary) attestation(ctx context.Context, commitment []byte) ([]byte, error) {
+	url := n.config.Calendar + "/timestamp/" + hex.EncodeToString(commitment)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := n.fetch(request)
+	var status *fetch.StatusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	anchored, err := bitcoinAttested(bytes.NewReader(response))
+	if err != nil || !anchored {
+		return nil, err
+	}
+	return response, nil
+}
+
+// fetch performs the request to the calendar, returning the body of the response.
+func (n *Notary) fetch(request *http.Request) ([]byte, error) {
+	request.Header.Set("Accept", "application/vnd.opentimestamps.v1")
+	return n.fetcher.Do(request)
+}
+
+// publish publishes the attestation of each event of the batch.
+func (n *Notary) publish(batch *otsBatch, attestation []byte) {
+	for _, leaf := range batch.leaves {
+		id, _ := hex.DecodeString(leaf.id)
+
+		proof := otsHeader()
+		proof = append(proof, opSHA256)
+		proof = append(proof, id...)
+		proof = append(proof, leaf.path...)
+		proof = append(proof, batch.calendar...)
+		proof = append(proof, attestation...)
+
+		event := &nostr.Event{
+			Kind:      nostr.KindOpenTimestamps,
+			CreatedAt: nostr.Now(),
+			Tags:      nostr.Tags{{"e", leaf.id}, {"k", strconv.Itoa(leaf.kind)}},
+			Content:   base64.StdEncoding.EncodeToString(proof),
+		}
+
+		if err := n.relay.Publish(event); err != nil {
+			n.relay.log.Error("failed to publish the attestation", "id", leaf.id, "error", err)
+			continue
+		}
+		n.published.Add(1)
+	}
+}
+
+// The serialization of OpenTimestamps proofs.
+// See https://github.com/opentimestamps/python-opentimestamps
+const (
+	opAttestation = 0x00
+	opSHA256      = 0x08
+	opAppend      = 0xf0
+	opPrepend     = 0xf1
+	opFork        = 0xff
+)
+
+var (
+	otsMagic         = []byte("\x00OpenTimestamps\x00\x00Proof\x00\xbf\x89\xe2\xe8\x84\xe8\x92\x94")
+	pendingTag       = []byte{0x83, 0xdf, 0xe3, 0x0d, 0x2e, 0xf9, 0x0c, 0x8e}
+	bitcoinTag       = []byte{0x05, 0x88, 0x96, 0x0d, 0x73, 0xd7, 0x19, 0x01}
+	errUnsupportedOp = errors.New("unsupported operation")
+)
+
+// otsHeader returns the header of a detached timestamp file, version 1.
+func otsHeader() []byte {
+	return append(bytes.Clone(otsMagic), 0x01)
+}
+
+// merkle computes the merkle tree of the leaves, setting their paths to its root, which it returns.
+func merkle(leaves []leaf) ([]byte, error) {
+	type node struct {
+		digest []byte
+		leaves []int // indexes of the leaves below the node
+	}
+
+	level := make([]node, len(leaves))
+	for i, l := range leaves {
+		digest, err := hex.DecodeString(l.id)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid event ID %q", l.id)
+		}
+		level[i] = node{digest: digest, leaves: []int{i}}
+	}
+
+	for len(level) > 1 {
+		var next []node
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			left, right := level[i], level[i+1]
+			for _, j := range left.leaves {
+				leaves[j].path = appendOp(leaves[j].path, opAppend, right.digest)
+			}
+			for _, j := range right.leaves {
+				leaves[j].path = appendOp(leaves[j].path, opPrepend, left.digest)
+			}
+
+			digest := sha256.Sum256(append(bytes.Clone(left.digest), right.digest...))
+			next = append(next, node{digest: digest[:], leaves: slices.Concat(left.leaves, right.leaves)})
+		}
+		level = next
+	}
+	return level[0].digest, nil
+}
+
+// appendOp appends the binary operation with the argument, followed by a sha256.
+func appendOp(ops []byte, op byte, arg []byte) []byte {
+	ops = append(ops, op)
+	ops = appendVarBytes(ops, arg)
+	return append(ops, opSHA256)
+}
+
+// pendingCommitment executes the operations of the linear timestamp returned by the calendar for the digest,
+// returning them without the final pending attestation, and the commitment the attestation refers to.
+func pendingCommitment(timestamp, digest []byte) (ops, commitment []byte, err error) {
+	r := bytes.NewReader(timestamp)
+	msg := bytes.Clone(digest)
+
+	for {
+		offset := len(timestamp) - r.Len()
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch op {
+		case opAttestation:
+			tag := make([]byte, 8)
+			if _, err := io.ReadFull(r, tag); err != nil {
+				return nil, nil, err
+			}
+			if !bytes.Equal(tag, pendingTag) {
+				return nil, nil, errors.New("expected a pending attestation")
+			}
+			return timestamp[:offset], msg, nil
+
+		case opSHA256:
+			sum := sha256.Sum256(msg)
+			msg = sum[:]
+
+		case opAppend, opPrepend:
+			arg, err := readVarBytes(r)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if op == opAppend {
+				msg = append(msg, arg...)
+			} else {
+				msg = append(arg, msg...)
+			}
+
+		default:
+			return nil, nil, fmt.Errorf("%w 0x%02x", errUnsupportedOp, op)
+		}
+	}
+}
+
+// bitcoinAttested parses the timestamp, reporting whether it has a Bitcoin attestation.
+func bitcoinAttested(r *bytes.Reader) (bool, error) {
+	attested := false
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+
+		fork := op == opFork
+		if fork {
+			if op, err = r.ReadByte(); err != nil {
+				return false, err
+			}
+		}
+
+		found, err := bitcoinAttestedItem(r, op)
+		if err != nil {
+			return false, err
+		}
+
+		attested = attested || found
+		if !fork {
+			return attested, nil
+		}
+	}
+}
+
+// bitcoinAttestedItem parses the attestation or the operation and the timestamp that follows it.
+func bitcoinAttestedItem(r *bytes.Reader, op byte) (bool, error) {
+	switch op {
+	case opAttestation:
+		tag := make([]byte, 8)
+		if _, err := io.ReadFull(r, tag); err != nil {
+			return false, err
+		}
+		if _, err := readVarBytes(r); err != nil {
+			return false, err
+		}
+		return bytes.Equal(tag, bitcoinTag), nil
+
+	case opAppend, opPrepend:
+		if _, err := readVarBytes(r); err != nil {
+			return false, err
+		}
+
+	case opSHA256, 0x02, 0x03, 0x67: // sha256, sha1, ripemd160, keccak256
+	default:
+		return false, fmt.Errorf("%w 0x%02x", errUnsupportedOp, op)
+	}
+	return bitcoinAttested(r)
+}
+
+func appendVarUint(b []byte, n uint64) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+func appendVarBytes(b, data []byte) []byte {
+	return append(appendVarUint(b, uint64(len(data))), data...)
+}
+
+func readVarUint(r *bytes.Reader) (uint64, error) {
+	var n uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		n |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("varuint overflow")
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarUint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
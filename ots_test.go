@@ -0,0 +1,175 @@
+package rely
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// calendar is a fake OpenTimestamps calendar, which attests the submitted digests once anchored.
+type calendar struct {
+	mu          sync.Mutex
+	commitments map[string]bool
+	anchored    bool
+}
+
+func (c *calendar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/digest":
+		digest, _ := io.ReadAll(r.Body)
+		nonce := []byte("calendar")
+		commitment := sha256.Sum256(append(digest, nonce...))
+		c.commitments[hex.EncodeToString(commitment[:])] = true
+
+		timestamp := appendOp(nil, opAppend, nonce)
+		timestamp = append(timestamp, opAttestation)
+		timestamp = append(timestamp, pendingTag...)
+		w.Write(appendVarBytes(timestamp, []byte("https://calendar.example.com")))
+
+	case r.Method == http.MethodGet && c.anchored && c.commitments[r.URL.Path[len("/timestamp/"):]]:
+		timestamp := []byte{opSHA256, opAttestation}
+		timestamp = append(timestamp, bitcoinTag...)
+		w.Write(appendVarBytes(timestamp, appendVarUint(nil, 800_000)))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// attested executes the operations of the linear proof, returning the message its Bitcoin attestation refers to.
+func attested(t *testing.T, proof []byte) []byte {
+	t.Helper()
+	if !bytes.HasPrefix(proof, otsHeader()) {
+		t.Fatal("expected the proof to start with the header")
+	}
+
+	r := bytes.NewReader(proof[len(otsHeader())+1:])
+	msg := make([]byte, sha256.Size)
+	io.ReadFull(r, msg)
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("unexpected end of the proof: %v", err)
+		}
+
+		switch op {
+		case opAttestation:
+			tag := make([]byte, 8)
+			io.ReadFull(r, tag)
+			if !bytes.Equal(tag, bitcoinTag) {
+				t.Fatalf("expected a Bitcoin attestation, got %x", tag)
+			}
+			return msg
+
+		case opSHA256:
+			sum := sha256.Sum256(msg)
+			msg = sum[:]
+
+		case opAppend, opPrepend:
+			arg, _ := readVarBytes(r)
+			if op == opAppend {
+				msg = append(msg, arg...)
+			} else {
+				msg = append(arg, msg...)
+			}
+		}
+	}
+}
+
+func TestNotary(t *testing.T) {
+	server := &calendar{commitments: make(map[string]bool)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	var mu sync.Mutex
+	var attestations []nostr.Event
+
+	relay := NewRelay(WithIdentity(GenerateIdentity()))
+	config := DefaultNotaryConfig()
+	config.Calendar = httpServer.URL
+
+	notary, err := NewNotary(relay, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay.On.Event = notary.Save(func(ctx context.Context, _ Client, e *nostr.Event) error {
+		if e.Kind == nostr.KindOpenTimestamps {
+			mu.Lock()
+			attestations = append(attestations, *e)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	ids := make([]string, 5)
+	for i := range ids {
+		sum := sha256.Sum256([]byte{byte(i)})
+		ids[i] = hex.EncodeToString(sum[:])
+		if err := relay.On.Event(context.Background(), nil, &nostr.Event{ID: ids[i], Kind: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if err := notary.submit(ctx); err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+
+	if notary.Pending() != len(ids) {
+		t.Fatalf("expected %d pending events, got %d", len(ids), notary.Pending())
+	}
+
+	notary.upgrade(ctx)
+	if notary.Pending() != len(ids) || notary.Published() != 0 {
+		t.Fatalf("expected the events to wait for the attestation, got %d pending and %d published", notary.Pending(), notary.Published())
+	}
+
+	server.mu.Lock()
+	server.anchored = true
+	server.mu.Unlock()
+
+	notary.upgrade(ctx)
+	if notary.Pending() != 0 || notary.Published() != int64(len(ids)) {
+		t.Fatalf("expected the attestations to be published, got %d pending and %d published", notary.Pending(), notary.Published())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var anchored []byte
+	for i, attestation := range attestations {
+		if attestation.Tags.GetFirst([]string{"e", ids[i]}) == nil || attestation.Tags.GetFirst([]string{"k", "1"}) == nil {
+			t.Errorf("attestation %d: unexpected tags %v", i, attestation.Tags)
+		}
+
+		proof, err := base64.StdEncoding.DecodeString(attestation.Content)
+		if err != nil {
+			t.Fatalf("attestation %d: invalid content: %v", i, err)
+		}
+
+		digest := proof[len(otsHeader())+1 : len(otsHeader())+1+sha256.Size]
+		if hex.EncodeToString(digest) != ids[i] {
+			t.Errorf("attestation %d: expected the proof of %s, got %x", i, ids[i], digest)
+		}
+
+		msg := attested(t, proof)
+		if anchored != nil && !bytes.Equal(msg, anchored) {
+			t.Errorf("attestation %d: expected all the proofs to lead to the same anchored message", i)
+		}
+		anchored = msg
+	}
+}
@@ -0,0 +1,98 @@
+package rely
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Pins are the events pinned by the operator, like the announcements or the rules of a community relay,
+// which are returned first to every REQ they match. They are served from memory, so they are never
+// dropped as expired or deleted, and must be persisted by the caller (see [Pins.Load]).
+//
+// Example:
+//
+//	pins := NewPins()
+//	relay.On.Req = pins.Query(relay.On.Req)
+type Pins struct {
+	mu     sync.RWMutex
+	events []nostr.Event // most recently pinned first
+}
+
+// NewPins returns [Pins] without events.
+func NewPins() *Pins {
+	return &Pins{}
+}
+
+// Load replaces the pinned events, most recently pinned first.
+func (p *Pins) Load(events []nostr.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = slices.Clone(events)
+}
+
+// Pin the event, moving it first if it was already pinned.
+func (p *Pins) Pin(e nostr.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = slices.DeleteFunc(p.events, func(pinned nostr.Event) bool { return pinned.ID == e.ID })
+	p.events = slices.Insert(p.events, 0, e)
+}
+
+// Unpin the event with the ID, reporting whether it was pinned.
+func (p *Pins) Unpin(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.events)
+	p.events = slices.DeleteFunc(p.events, func(pinned nostr.Event) bool { return pinned.ID == id })
+	return len(p.events) < n
+}
+
+// IsPinned reports whether the event with the ID is pinned.
+func (p *Pins) IsPinned(id string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.ContainsFunc(p.events, func(pinned nostr.Event) bool { return pinned.ID == id })
+}
+
+// Events returns the pinned events, most recently pinned first.
+func (p *Pins) Events() []nostr.Event {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Clone(p.events)
+}
+
+// Match returns the pinned events matching any of the filters.
+func (p *Pins) Match(filters nostr.Filters) []nostr.Event {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var matched []nostr.Event
+	for _, e := range p.events {
+		if filters.Match(&e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// Query wraps the On.Req hook, returning the pinned events matching the filters before the events
+// returned by the hook, which are deduplicated against them.
+func (p *Pins) Query(query func(context.Context, Client, nostr.Filters) ([]nostr.Event, error)) func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+	return func(ctx context.Context, c Client, filters nostr.Filters) ([]nostr.Event, error) {
+		events, err := query(ctx, c, filters)
+		pinned := p.Match(filters)
+		if len(pinned) == 0 {
+			return events, err
+		}
+
+		events = slices.DeleteFunc(events, func(e nostr.Event) bool {
+			return slices.ContainsFunc(pinned, func(pin nostr.Event) bool { return pin.ID == e.ID })
+		})
+		return append(pinned, events...), err
+	}
+}
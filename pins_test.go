@@ -0,0 +1,59 @@
+package rely
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPinsQuery(t *testing.T) {
+	rules := nostr.Event{ID: "rules", Kind: 1, CreatedAt: 1}
+	profile := nostr.Event{ID: "profile", Kind: 0, CreatedAt: 2}
+	stored := []nostr.Event{{ID: "new", Kind: 1, CreatedAt: 3}, rules}
+
+	pins := NewPins()
+	pins.Pin(rules)
+	pins.Pin(profile)
+
+	query := pins.Query(func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return slices.Clone(stored), nil
+	})
+
+	tests := []struct {
+		name     string
+		filters  nostr.Filters
+		expected []string
+	}{
+		{name: "pinned first", filters: nostr.Filters{{Kinds: []int{1}}}, expected: []string{"rules", "new"}},
+		{name: "all pins", filters: nostr.Filters{{}}, expected: []string{"profile", "rules", "new"}},
+		{name: "no match", filters: nostr.Filters{{Kinds: []int{7}}}, expected: []string{"new", "rules"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := query(context.Background(), nil, test.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]string, len(events))
+			for i, e := range events {
+				ids[i] = e.ID
+			}
+
+			if !slices.Equal(ids, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+
+	if !pins.Unpin("rules") || pins.IsPinned("rules") {
+		t.Fatal("expected the rules to be unpinned")
+	}
+
+	if pins.Unpin("rules") {
+		t.Fatal("expected the rules to be already unpinned")
+	}
+}
@@ -0,0 +1,46 @@
+package rely
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxPooledBuffer is the capacity above which read buffers are not returned to the pool,
+// to avoid holding on to the memory of a few unusually large messages.
+const maxPooledBuffer = 64 * 1024
+
+var (
+	bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	eventPool  = sync.Pool{New: func() any { return new(nostr.Event) }}
+)
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns the buffer to the pool. The buffer must not be used afterwards.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// getEvent returns an empty event from the pool.
+// Its Tags might have capacity from a previous use, which the JSON decoding reuses.
+func getEvent() *nostr.Event {
+	return eventPool.Get().(*nostr.Event)
+}
+
+// putEvent returns the event to the pool. It must only be called for events
+// that were never passed to the On.Event hook nor broadcasted, as those might still be referenced.
+func putEvent(e *nostr.Event) {
+	clear(e.Tags) // drop the references to the inner tags
+	*e = nostr.Event{Tags: e.Tags[:0]}
+	eventPool.Put(e)
+}
@@ -0,0 +1,77 @@
+package rely
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPutEvent(t *testing.T) {
+	event := &nostr.Event{ID: "abc", Kind: 1, Tags: nostr.Tags{{"p", pk}, {"e", "def"}}}
+	putEvent(event)
+
+	if event.ID != "" || event.Kind != 0 || len(event.Tags) != 0 {
+		t.Fatalf("expected the event to be reset, got %+v", event)
+	}
+
+	if cap(event.Tags) != 2 {
+		t.Fatalf("expected the tags capacity to be kept, got %d", cap(event.Tags))
+	}
+}
+
+// BenchmarkIngest simulates the read → parse → validate path of 10k events that are rejected,
+// reporting the GC cycles with and without reusing the read buffers and the events.
+func BenchmarkIngest(b *testing.B) {
+	const events = 10_000
+	reject := func(e *nostr.Event) bool { return e.Kind == 1 }
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		gcs := gcCount()
+
+		for range b.N {
+			for range events {
+				buf := getBuffer()
+				buf.ReadFrom(bytes.NewReader(dataEvent))
+
+				event, ok := parseEventFast(buf.Bytes())
+				if !ok {
+					b.Fatal("failed to parse event")
+				}
+
+				if reject(event.Event) {
+					putEvent(event.Event)
+				}
+				putBuffer(buf)
+			}
+		}
+		b.ReportMetric(float64(gcCount()-gcs)/float64(b.N), "gc/op")
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		gcs := gcCount()
+
+		for range b.N {
+			for range events {
+				buf := new(bytes.Buffer)
+				buf.ReadFrom(bytes.NewReader(dataEvent))
+
+				event := new(nostr.Event)
+				if err := event.UnmarshalJSON(buf.Bytes()[10 : len(dataEvent)-1]); err != nil {
+					b.Fatal(err)
+				}
+				reject(event)
+			}
+		}
+		b.ReportMetric(float64(gcCount()-gcs)/float64(b.N), "gc/op")
+	})
+}
+
+func gcCount() uint32 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.NumGC
+}
@@ -1,9 +1,21 @@
 package rely
 
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
 type processor struct {
 	maxWorkers int
 	queue      chan request
 
+	// priority holds the events of the fast kinds, which are processed before the queue.
+	// See [WithFastKinds].
+	priority chan request
+
 	// pointer to parent relay, which must only be used for:
 	//	- reading settings/hooks
 	//	- sending to channels
@@ -15,6 +27,7 @@ func newProcessor(relay *Relay) *processor {
 	return &processor{
 		maxWorkers: 4,
 		queue:      make(chan request, 1024),
+		priority:   make(chan request, 256),
 		relay:      relay,
 	}
 }
@@ -27,27 +40,39 @@ func (p *processor) Run() {
 	semaphore := make(chan struct{}, p.maxWorkers)
 
 	for {
+		var request request
+
+		// the priority channel is checked first, so that it's never starved by the queue
 		select {
 		case <-p.relay.done:
 			return
-
-		case request := <-p.queue:
-			if request.IsExpired() {
-				continue
+		case request = <-p.priority:
+		default:
+			select {
+			case <-p.relay.done:
+				return
+			case request = <-p.priority:
+			case request = <-p.queue:
 			}
+		}
 
-			semaphore <- struct{}{}
-			p.relay.wg.Add(1)
+		if request.IsExpired() {
+			p.relay.releaseRequest(request)
+			continue
+		}
 
-			go func() {
-				defer func() {
-					<-semaphore
-					p.relay.wg.Done()
-				}()
+		semaphore <- struct{}{}
+		p.relay.wg.Add(1)
 
-				p.Process(request)
+		go func() {
+			defer func() {
+				<-semaphore
+				p.relay.wg.Done()
 			}()
-		}
+
+			p.Process(request)
+			p.relay.releaseRequest(request)
+		}()
 	}
 }
 
@@ -56,40 +81,193 @@ func (p *processor) Process(request request) {
 	ID := request.ID()
 	switch request := request.(type) {
 	case eventRequest:
-		err := p.relay.On.Event(request.client, request.Event)
+		if p.relay.isFast(request.Event.Kind) {
+			// fast kinds bypass the On.Event hook (e.g. the storage)
+			request.client.sendEventOK(okResponse{ID: ID, Saved: true})
+			p.relay.stats.eventLatency.Observe(time.Since(request.receivedAt))
+			p.relay.Broadcast(request.Event)
+			return
+		}
+
+		ctx, cancel := p.relay.hookContext(request.ctx)
+		defer cancel()
+
+		if err := p.relay.handleKind(ctx, request.client, request.Event); err != nil {
+			request.client.sendEventOK(okResponse{ID: ID, Saved: false, Reason: reasonMessage(err)})
+			p.relay.stats.eventLatency.Observe(time.Since(request.receivedAt))
+			return
+		}
+
+		start := time.Now()
+		err := p.relay.On.Event(ctx, request.client, request.Event)
+		p.relay.stats.onEventLatency.Observe(time.Since(start))
+
 		if err != nil {
-			request.client.send(okResponse{ID: ID, Saved: false, Reason: err.Error()})
+			request.client.sendEventOK(okResponse{ID: ID, Saved: false, Reason: reasonMessage(err)})
+			p.relay.stats.eventLatency.Observe(time.Since(request.receivedAt))
 			return
 		}
 
-		request.client.send(okResponse{ID: ID, Saved: true})
+		request.client.sendEventOK(okResponse{ID: ID, Saved: true})
+		p.relay.stats.eventLatency.Observe(time.Since(request.receivedAt))
 		p.relay.Broadcast(request.Event)
 
 	case reqRequest:
-		budget := request.client.RemainingCapacity()
-		ApplyBudget(budget, request.Filters...)
+		var limit int
+		var limitedBy string
+		if request.archive {
+			limit, limitedBy = p.relay.applyArchiveBudget(request.Filters)
+		} else {
+			limit, limitedBy = p.relay.applyBudget(request.client, request.Filters)
+		}
+
+		// the filters left without events (or with limit 0) are not queried, since a zero limit
+		// would mean no limit at all for the storage
+		request.Filters = slices.DeleteFunc(request.Filters, func(f nostr.Filter) bool { return f.Limit == 0 })
+		if len(request.Filters) == 0 {
+			request.client.send(eoseResponse{ID: ID})
+			if limitedBy != "" {
+				request.client.SendNotice(fmt.Sprintf("the results of the subscription %s were truncated to 0 events by the relay's %s limit", ID, limitedBy))
+			}
+			return
+		}
+
+		ctx, cancel := p.relay.hookContext(request.ctx)
+		start := time.Now()
+		events, err := p.relay.On.Req(ctx, request.client, request.Filters)
+		p.relay.stats.onReqLatency.Observe(time.Since(start))
+		cancel()
 
-		events, err := p.relay.On.Req(request.ctx, request.client, request.Filters)
 		if err != nil {
 			if request.ctx.Err() == nil {
 				// error not caused by the user's CLOSE, so we must close the subscription
-				request.client.CloseSubWithReason(ID, err.Error())
+				request.client.CloseSubWithReason(ID, reasonMessage(err))
 			}
 			return
 		}
 
-		for i := range events {
-			request.client.send(eventResponse{ID: ID, Event: &events[i]})
+		if p.relay.On.Deliver != nil {
+			events = deliverable(p.relay.On.Deliver, request.client, ID, events)
+		}
+
+		exhausted := false
+		if request.delivered != nil && !request.archive {
+			// the stored events count towards the maximum events of the subscription
+			n := request.delivered.Add(int64(len(events)))
+			if excess := n - int64(p.relay.maxSubEvents); p.relay.maxSubEvents > 0 && excess >= 0 {
+				events = events[:max(int64(len(events))-excess, 0)]
+				exhausted = true
+			}
+		}
+
+		if p.relay.budgetMode == BudgetPerSecond && !request.archive {
+			request.client.budget.spend(len(events))
+		}
+
+		if request.archive || p.relay.chunkSize > 0 {
+			// the paced sends wait for the client, so they don't hold one of the workers
+			p.relay.wg.Add(1)
+			go func() {
+				defer p.relay.wg.Done()
+				p.sendEvents(request, events, exhausted, limit, limitedBy)
+			}()
+			return
 		}
-		request.client.send(eoseResponse{ID: ID})
+		p.sendEvents(request, events, exhausted, limit, limitedBy)
 
 	case countRequest:
-		count, approx, err := p.relay.On.Count(request.client, request.Filters)
+		ctx, cancel := p.relay.hookContext(request.ctx)
+		defer cancel()
+
+		start := time.Now()
+		count, approx, err := p.relay.On.Count(ctx, request.client, request.Filters)
+		p.relay.stats.onCountLatency.Observe(time.Since(start))
+
 		if err != nil {
-			request.client.send(closedResponse{ID: ID, Reason: err.Error()})
+			request.client.send(closedResponse{ID: ID, Reason: reasonMessage(err)})
 			return
 		}
 
 		request.client.send(countResponse{ID: ID, Count: count, Approx: approx})
 	}
 }
+
+// sendEvents sends the events of the REQ followed by its EOSE, or closes the subscription if it reached
+// its maximum events. The events of the archive REQs and the chunked responses are sent at the pace
+// of the client, see [processor.sendArchive] and [processor.sendChunks].
+func (p *processor) sendEvents(request reqRequest, events []nostr.Event, exhausted bool, limit int, limitedBy string) {
+	ID := request.ID()
+	switch {
+	case request.archive:
+		if !p.sendArchive(request, events) {
+			if request.ctx.Err() == nil {
+				request.client.CloseSubWithReason(ID, "error: the client is reading too slowly")
+			}
+			return
+		}
+
+	case p.relay.chunkSize > 0:
+		if !p.sendChunks(request, events) {
+			if request.ctx.Err() == nil {
+				request.client.CloseSubWithReason(ID, "error: the client is reading too slowly")
+			}
+			return
+		}
+
+	default:
+		for i := range events {
+			request.client.send(eventResponse{ID: ID, Event: &events[i]})
+		}
+	}
+
+	if exhausted {
+		request.client.CloseSubWithReason(ID, ErrSubscriptionEvents.Error())
+		return
+	}
+	request.client.send(eoseResponse{ID: ID})
+
+	if request.followed != nil {
+		if addresses := followed(request.Filters, events); len(addresses) > 0 {
+			request.followed.add(addresses...)
+			p.relay.follow(request.UID())
+		}
+	}
+
+	if limitedBy != "" && len(events) >= limit {
+		request.client.SendNotice(fmt.Sprintf("the results of the subscription %s were truncated to %d events by the relay's %s limit", ID, limit, limitedBy))
+	}
+	p.relay.stats.reqLatency.Observe(time.Since(request.receivedAt))
+}
+
+// deliverable returns the events the deliver hook accepts for the subscription, filtered in place.
+func deliverable(deliver func(Client, string, *nostr.Event) bool, c Client, sub string, events []nostr.Event) []nostr.Event {
+	n := 0
+	for i := range events {
+		if deliver(c, sub, &events[i]) {
+			events[n] = events[i]
+			n++
+		}
+	}
+	return events[:n]
+}
+
+// chunkPollInterval is how often the client's response buffer is checked while sending chunks.
+const chunkPollInterval = 5 * time.Millisecond
+
+// sendChunks sends the events of the REQ in chunks, waiting before each chunk until the client's
+// response buffer has room for it (and the EOSE). It returns false if the client didn't make room
+// within the maximum pause, or if the subscription was closed in the meantime.
+func (p *processor) sendChunks(request reqRequest, events []nostr.Event) bool {
+	size := p.relay.chunkSize
+	for start := 0; start < len(events); start += size {
+		end := min(start+size, len(events))
+		if !request.client.waitCapacity(request.ctx, end-start+1, p.relay.chunkPause) {
+			return false
+		}
+
+		for i := start; i < end; i++ {
+			request.client.send(eventResponse{ID: request.ID(), Event: &events[i]})
+		}
+	}
+	return true
+}
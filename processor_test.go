@@ -0,0 +1,315 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestProcessFastKinds(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithFastKinds(nostr.KindNostrConnect))
+	relay.On.Event = func(context.Context, Client, *nostr.Event) error {
+		t.Fatal("fast kinds must bypass the On.Event hook")
+		return nil
+	}
+
+	client := &client{relay: relay, responses: make(chan response, 10)}
+	event := &nostr.Event{ID: "abc", Kind: nostr.KindNostrConnect}
+
+	if err := relay.tryProcess(eventRequest{client: client, Event: event, receivedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	if len(relay.processor.priority) != 1 || len(relay.processor.queue) != 0 {
+		t.Fatal("fast kinds must be enqueued in the priority queue")
+	}
+
+	relay.processor.Process(<-relay.processor.priority)
+
+	ok, isOK := (<-client.responses).(okResponse)
+	if !isOK || !ok.Saved {
+		t.Fatalf("expected a successful OK response, got %+v", ok)
+	}
+
+	if len(relay.dispatcher.broadcast) != 1 {
+		t.Fatal("fast kinds must be broadcasted")
+	}
+}
+
+func TestProcessChunks(t *testing.T) {
+	relay := NewRelay(
+		WithDomain("example.com"),
+		WithClientResponseLimit(10),
+		WithResponseBudget(BudgetPerREQ, 100),
+		WithResponseChunking(4, time.Second),
+	)
+
+	var limit int
+	relay.On.Req = func(_ context.Context, _ Client, filters nostr.Filters) ([]nostr.Event, error) {
+		limit = filters[0].Limit
+		return make([]nostr.Event, 25), nil
+	}
+
+	client := &client{relay: relay, responses: make(chan response, 10), done: make(chan struct{})}
+	request := reqRequest{id: "sub", ctx: context.Background(), client: client, Filters: nostr.Filters{{}}}
+
+	processed := make(chan struct{})
+	go func() {
+		relay.processor.Process(request)
+		close(processed)
+	}()
+
+	var events int
+	for {
+		response := <-client.responses
+		if _, ok := response.(eoseResponse); ok {
+			break
+		}
+
+		if _, ok := response.(eventResponse); !ok {
+			t.Fatalf("expected an event, got %+v", response)
+		}
+		events++
+	}
+	<-processed
+
+	if limit != 100 {
+		t.Fatalf("the limit must not be capped by the response buffer: expected 100, got %d", limit)
+	}
+	if events != 25 {
+		t.Fatalf("expected 25 events, got %d", events)
+	}
+	if dropped := client.DroppedResponses(); dropped != 0 {
+		t.Fatalf("expected no dropped responses, got %d", dropped)
+	}
+}
+
+func TestProcessSlowReaders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"), WithResponseChunking(4, time.Minute))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return make([]nostr.Event, 25), nil
+	}
+	relay.Start(ctx)
+
+	// the slow readers never read their responses, so their chunks wait for the maximum pause
+	for i := range relay.processor.maxWorkers {
+		reader := &client{relay: relay, responses: make(chan response, 5), done: make(chan struct{})}
+		request := reqRequest{id: fmt.Sprintf("sub%d", i), ctx: ctx, client: reader, Filters: nostr.Filters{{}}}
+		if err := relay.tryProcess(request); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+	}
+
+	writer := &client{relay: relay, responses: make(chan response, 10)}
+	if err := relay.tryProcess(eventRequest{client: writer, ctx: ctx, Event: &nostr.Event{ID: "abc", Kind: 1}, receivedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	select {
+	case response := <-writer.responses:
+		if ok, isOK := response.(okResponse); !isOK || !ok.Saved {
+			t.Fatalf("expected a successful OK response, got %+v", response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the slow readers must not starve the workers")
+	}
+}
+
+func TestApplyBudgetModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      BudgetMode
+		budget    int
+		limits    []int
+		expected  []int
+		truncated bool
+	}{
+		{
+			name:     "per req, within budget",
+			mode:     BudgetPerREQ,
+			budget:   100,
+			limits:   []int{10, 20},
+			expected: []int{10, 20},
+		},
+		{
+			name:      "per req, above budget",
+			mode:      BudgetPerREQ,
+			budget:    100,
+			limits:    []int{100, 100},
+			expected:  []int{50, 50},
+			truncated: true,
+		},
+		{
+			name:     "per filter, within budget",
+			mode:     BudgetPerFilter,
+			budget:   100,
+			limits:   []int{100, 100},
+			expected: []int{100, 100},
+		},
+		{
+			name:      "per filter, above budget",
+			mode:      BudgetPerFilter,
+			budget:    100,
+			limits:    []int{500, 0},
+			expected:  []int{100, 100},
+			truncated: true,
+		},
+		{
+			name:      "per second",
+			mode:      BudgetPerSecond,
+			budget:    10,
+			limits:    []int{20},
+			expected:  []int{10},
+			truncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			relay := NewRelay(WithDomain("example.com"), WithResponseBudget(test.mode, test.budget))
+			client := &client{relay: relay, responses: make(chan response, 1000)}
+
+			filters := make(nostr.Filters, len(test.limits))
+			for i, limit := range test.limits {
+				filters[i].Limit = limit
+			}
+
+			_, limitedBy := relay.applyBudget(client, filters)
+			if truncated := limitedBy != ""; truncated != test.truncated {
+				t.Fatalf("expected truncated %v, got %v", test.truncated, truncated)
+			}
+
+			for i := range filters {
+				if filters[i].Limit != test.expected[i] {
+					t.Fatalf("filter %d: expected limit %d, got %d", i, test.expected[i], filters[i].Limit)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessEmptyBudget(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithResponseBudget(BudgetPerSecond, 10))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		t.Fatal("the storage must not be queried when the budget leaves no events")
+		return nil, nil
+	}
+
+	client := &client{relay: relay, responses: make(chan response, 10)}
+	client.budget.available(10)
+	client.budget.spend(10)
+
+	request := reqRequest{id: "sub", ctx: context.Background(), client: client, Filters: nostr.Filters{{}, {Limit: 5}}}
+	relay.processor.Process(request)
+
+	if _, ok := (<-client.responses).(eoseResponse); !ok {
+		t.Fatal("expected the EOSE")
+	}
+
+	notice, ok := (<-client.responses).(noticeResponse)
+	if !ok || !strings.Contains(notice.Message, "truncated to 0 events by the relay's per-second limit") {
+		t.Fatalf("expected the notice of the per-second limit, got %+v", notice)
+	}
+}
+
+func TestProcessSubscriptionEvents(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithSubscriptionLimits(0, 10))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return make([]nostr.Event, 25), nil
+	}
+
+	client := &client{relay: relay, responses: make(chan response, 100), subs: make(map[string]subscription)}
+	if err := client.handleReq(reqRequest{id: "sub", Filters: nostr.Filters{{}}}); err != nil {
+		t.Fatalf("failed to handle the REQ: %v", err)
+	}
+
+	relay.processor.Process(<-relay.processor.queue)
+
+	var events int
+	for {
+		response := <-client.responses
+		if closed, ok := response.(closedResponse); ok {
+			if closed.Reason != ErrSubscriptionEvents.Error() {
+				t.Fatalf("expected reason %q, got %q", ErrSubscriptionEvents, closed.Reason)
+			}
+			break
+		}
+
+		if _, ok := response.(eventResponse); !ok {
+			t.Fatalf("expected an event, got %+v", response)
+		}
+		events++
+	}
+
+	if events != 10 {
+		t.Fatalf("expected 10 events, got %d", events)
+	}
+	if len(client.Subscriptions()) != 0 {
+		t.Fatal("the subscription must be closed")
+	}
+}
+
+func TestSubscriptionLifetime(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"), WithSubscriptionLimits(50*time.Millisecond, 0))
+	client := &client{relay: relay, responses: make(chan response, 10), subs: make(map[string]subscription)}
+
+	if err := client.handleReq(reqRequest{id: "sub", Filters: nostr.Filters{{}}}); err != nil {
+		t.Fatalf("failed to handle the REQ: %v", err)
+	}
+
+	select {
+	case response := <-client.responses:
+		closed, ok := response.(closedResponse)
+		if !ok || closed.Reason != ErrSubscriptionLifetime.Error() {
+			t.Fatalf("expected a CLOSED with reason %q, got %+v", ErrSubscriptionLifetime, response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the subscription must be closed after its lifetime")
+	}
+
+	if len(client.Subscriptions()) != 0 {
+		t.Fatal("the subscription must be closed")
+	}
+}
+
+func TestProcessDeliver(t *testing.T) {
+	relay := NewRelay(WithDomain("example.com"))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return []nostr.Event{{ID: "a", PubKey: "muted"}, {ID: "b", PubKey: "friend"}}, nil
+	}
+	relay.On.Deliver = func(_ Client, sub string, e *nostr.Event) bool {
+		return sub != "sub" || e.PubKey != "muted"
+	}
+
+	client := &client{relay: relay, responses: make(chan response, 10), subs: make(map[string]subscription)}
+	if err := client.handleReq(reqRequest{id: "sub", Filters: nostr.Filters{{Authors: []string{"muted", "friend"}}}}); err != nil {
+		t.Fatalf("failed to handle the REQ: %v", err)
+	}
+
+	relay.processor.Process(<-relay.processor.queue)
+
+	if event, ok := (<-client.responses).(eventResponse); !ok || event.Event.ID != "b" {
+		t.Fatalf("expected only the event of the friend, got %+v", event)
+	}
+	if _, ok := (<-client.responses).(eoseResponse); !ok {
+		t.Fatal("expected the EOSE after the delivered events")
+	}
+
+	relay.dispatcher.Index(client.subs["sub"])
+	for _, e := range []nostr.Event{{ID: "c", PubKey: "muted"}, {ID: "d", PubKey: "friend"}} {
+		if err := relay.dispatcher.Broadcast(&e); err != nil {
+			t.Fatalf("failed to broadcast: %v", err)
+		}
+	}
+
+	if event, ok := (<-client.responses).(rawEventResponse); !ok || len(client.responses) != 0 {
+		t.Fatalf("expected only the live event of the friend, got %+v", event)
+	}
+}
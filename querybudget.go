@@ -0,0 +1,112 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrQueryTooExpensive = fmt.Errorf("%w: query too expensive", ErrError)
+
+// QueryBudgetConfig configures the [QueryBudget].
+type QueryBudgetConfig struct {
+	// Cost estimates the cost of a filter before it's executed, e.g. the rows it scans.
+	// See the EstimateCost method of the ClickHouse storage.
+	Cost func(nostr.Filter) float64
+
+	// MaxCost is the maximum cost of a single REQ or COUNT. Zero disables the maximum.
+	MaxCost float64
+
+	// Rate is the cost a client can spend per second, with bursts of up to Burst.
+	// The budget is per pubkey if the client is authenticated, or per IP otherwise,
+	// so that it isn't reset by reconnecting.
+	Rate  float64
+	Burst float64
+}
+
+// QueryBudget protects the storage from pathological filters, rejecting the REQs and COUNTs
+// whose estimated cost exceeds the maximum, or the remaining budget of the client,
+// with [ErrQueryTooExpensive] (which closes the subscription with a CLOSED message).
+//
+// Example:
+//
+//	budget, err := NewQueryBudget(QueryBudgetConfig{Cost: storage.EstimateCost, MaxCost: 1e7, Rate: 1e6, Burst: 1e7})
+//	relay.Reject.Req = append(relay.Reject.Req, budget.RejectReq)
+//	relay.Reject.Count = append(relay.Reject.Count, budget.RejectReq)
+//	go budget.Run(ctx)
+type QueryBudget struct {
+	config   QueryBudgetConfig
+	limiter  *RateLimiter
+	rejected atomic.Int64
+}
+
+// NewQueryBudget returns a [QueryBudget], or an error if the config is invalid.
+func NewQueryBudget(config QueryBudgetConfig) (*QueryBudget, error) {
+	if config.Cost == nil {
+		return nil, errors.New("the cost function is required")
+	}
+
+	if config.Rate <= 0 || config.Burst <= 0 {
+		return nil, errors.New("the budget rate and burst must be positive")
+	}
+
+	if config.MaxCost > config.Burst {
+		return nil, errors.New("the maximum cost must not exceed the burst, or the queries costing more could never run")
+	}
+	return &QueryBudget{config: config, limiter: NewRateLimiter()}, nil
+}
+
+// Cost returns the estimated cost of the filters.
+func (b *QueryBudget) Cost(filters nostr.Filters) float64 {
+	var cost float64
+	for _, f := range filters {
+		cost += b.config.Cost(f)
+	}
+	return cost
+}
+
+// Rejected returns the number of requests rejected as too expensive.
+func (b *QueryBudget) Rejected() int64 { return b.rejected.Load() }
+
+// RejectReq is a Reject.Req or Reject.Count hook that rejects the requests costing more than the maximum
+// or the remaining budget of the client, spending it otherwise.
+func (b *QueryBudget) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	cost := b.Cost(filters)
+	if b.config.MaxCost > 0 && cost > b.config.MaxCost {
+		b.rejected.Add(1)
+		return ErrQueryTooExpensive
+	}
+
+	key := c.IP()
+	if pubkey := c.Pubkey(); pubkey != "" {
+		key = pubkey
+	}
+
+	if !b.limiter.AllowN(key, cost, b.config.Rate, b.config.Burst) {
+		b.rejected.Add(1)
+		return ErrQueryTooExpensive
+	}
+	return nil
+}
+
+// Run periodically forgets the budgets of the idle clients, until the context is cancelled.
+func (b *QueryBudget) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	// after this long without requests, a budget is full again
+	idle := time.Duration(b.config.Burst / b.config.Rate * float64(time.Second))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.limiter.Prune(max(idle, time.Minute))
+		}
+	}
+}
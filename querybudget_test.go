@@ -0,0 +1,54 @@
+package rely
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQueryBudget(t *testing.T) {
+	cost := func(f nostr.Filter) float64 {
+		if len(f.IDs) > 0 {
+			return float64(len(f.IDs))
+		}
+		return 100
+	}
+
+	budget, err := NewQueryBudget(QueryBudgetConfig{Cost: cost, MaxCost: 150, Rate: 1, Burst: 250})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alice := &client{ip: "1.2.3.4"}
+	if err := budget.RejectReq(context.Background(), alice, nostr.Filters{{}, {}}); err != ErrQueryTooExpensive {
+		t.Fatalf("expected the request over the maximum cost to be rejected, got %v", err)
+	}
+
+	for i := range 2 {
+		if err := budget.RejectReq(context.Background(), alice, nostr.Filters{{}}); err != nil {
+			t.Fatalf("request %d: expected the request within the budget to be accepted, got %v", i, err)
+		}
+	}
+
+	if err := budget.RejectReq(context.Background(), alice, nostr.Filters{{}}); err != ErrQueryTooExpensive {
+		t.Fatalf("expected the request over the remaining budget to be rejected, got %v", err)
+	}
+
+	if err := budget.RejectReq(context.Background(), alice, nostr.Filters{{IDs: []string{"abc"}}}); err != nil {
+		t.Fatalf("expected the cheap request to be accepted, got %v", err)
+	}
+
+	bob := &client{ip: "1.2.3.4", pubkey: pk}
+	if err := budget.RejectReq(context.Background(), bob, nostr.Filters{{}}); err != nil {
+		t.Fatalf("expected the authenticated client to have its own budget, got %v", err)
+	}
+
+	if budget.Rejected() != 2 {
+		t.Fatalf("expected 2 rejected requests, got %d", budget.Rejected())
+	}
+
+	if _, err := NewQueryBudget(QueryBudgetConfig{Cost: cost, MaxCost: 500, Rate: 1, Burst: 250}); err == nil {
+		t.Fatal("expected an error for a maximum cost over the burst")
+	}
+}
@@ -0,0 +1,100 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrSubscriptionQuota = fmt.Errorf("%w: too many subscriptions, please close some of them", ErrRateLimited)
+
+// SubscriptionQuotaConfig configures the [SubscriptionQuota].
+type SubscriptionQuotaConfig struct {
+	// Weight is the cost of keeping a subscription with the filter open, as a fraction of the cost
+	// of a broad filter. See [FilterWeight].
+	Weight func(nostr.Filter) float64
+
+	// MaxWeight is the maximum total weight of the open subscriptions of a client, the sum of the weights
+	// of their filters. With [FilterWeight], it's the number of broad subscriptions a client can hold.
+	MaxWeight float64
+}
+
+// DefaultSubscriptionQuotaConfig returns a [SubscriptionQuotaConfig] weighting the filters with [FilterWeight],
+// allowing up to 20 broad subscriptions per client.
+func DefaultSubscriptionQuotaConfig() SubscriptionQuotaConfig {
+	return SubscriptionQuotaConfig{Weight: FilterWeight, MaxWeight: 20}
+}
+
+// FilterWeight weights the filter by how broad it is, since the broad filters match more of the
+// stored events and of the live ones to broadcast:
+//   - 0.1 for the filters of IDs.
+//   - 0.2 for the filters of authors or tags.
+//   - 0.5 for the filters of kinds or search only.
+//   - 1 for the filters matching all events.
+func FilterWeight(f nostr.Filter) float64 {
+	switch {
+	case len(f.IDs) > 0:
+		return 0.1
+	case len(f.Authors) > 0 || len(f.Tags) > 0:
+		return 0.2
+	case len(f.Kinds) > 0 || f.Search != "":
+		return 0.5
+	default:
+		return 1
+	}
+}
+
+// SubscriptionQuota limits the subscriptions each client can hold open, weighted by the cost of their filters
+// instead of a flat cap, so that a client can hold many cheap subscriptions (e.g. ID lookups) or a few expensive ones.
+// The REQs exceeding the quota of the client are rejected with [ErrSubscriptionQuota]. A REQ replacing an open
+// subscription (see [SubscriptionID]) only counts the difference of their weights.
+//
+// Example:
+//
+//	quota, err := NewSubscriptionQuota(DefaultSubscriptionQuotaConfig())
+//	relay.Reject.Req = append(relay.Reject.Req, quota.RejectReq)
+type SubscriptionQuota struct {
+	config SubscriptionQuotaConfig
+}
+
+// NewSubscriptionQuota returns a [SubscriptionQuota], or an error if the config is invalid.
+func NewSubscriptionQuota(config SubscriptionQuotaConfig) (*SubscriptionQuota, error) {
+	if config.Weight == nil {
+		return nil, errors.New("the weight function is required")
+	}
+
+	if config.MaxWeight <= 0 {
+		return nil, errors.New("the maximum weight must be positive")
+	}
+	return &SubscriptionQuota{config: config}, nil
+}
+
+// Weight returns the weight of a subscription with the filters.
+func (q *SubscriptionQuota) Weight(filters nostr.Filters) float64 {
+	var weight float64
+	for _, f := range filters {
+		weight += q.config.Weight(f)
+	}
+	return weight
+}
+
+// RejectReq is a Reject.Req hook rejecting the REQs that would bring the weight of the open
+// subscriptions of the client above the maximum.
+func (q *SubscriptionQuota) RejectReq(ctx context.Context, c Client, filters nostr.Filters) error {
+	id := SubscriptionID(ctx)
+	weight := q.Weight(filters)
+
+	for _, sub := range c.Subscriptions() {
+		if sub.ID() != id {
+			weight += q.Weight(sub.Filters())
+		}
+	}
+
+	// tolerate the rounding of the sums of fractional weights
+	if weight > q.config.MaxWeight+1e-9 {
+		return ErrSubscriptionQuota
+	}
+	return nil
+}
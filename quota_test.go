@@ -0,0 +1,60 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSubscriptionQuota(t *testing.T) {
+	quota, err := NewSubscriptionQuota(SubscriptionQuotaConfig{Weight: FilterWeight, MaxWeight: 2})
+	if err != nil {
+		t.Fatalf("failed to create the quota: %v", err)
+	}
+
+	c := &client{subs: make(map[string]subscription)}
+	for i := range 19 {
+		id := fmt.Sprintf("lookup-%d", i)
+		c.subs[id] = subscription{id: id, filters: nostr.Filters{{IDs: []string{"abc"}}}}
+	}
+
+	ctx := context.WithValue(context.Background(), subscriptionKey{}, "new")
+	lookup := nostr.Filters{{IDs: []string{"def"}}}
+	broad := nostr.Filters{{Kinds: []int{1}}}
+
+	if err := quota.RejectReq(ctx, c, lookup); err != nil {
+		t.Fatalf("expected the 20th lookup to fit in the quota, got %v", err)
+	}
+	if err := quota.RejectReq(ctx, c, broad); !errors.Is(err, ErrSubscriptionQuota) {
+		t.Fatalf("expected the broad subscription to exceed the quota, got %v", err)
+	}
+
+	// the subscription being replaced doesn't count
+	c.subs["lookup-0"] = subscription{id: "lookup-0", filters: nostr.Filters{{Authors: []string{"abc"}}}}
+	ctx = context.WithValue(context.Background(), subscriptionKey{}, "lookup-0")
+	if err := quota.RejectReq(ctx, c, nostr.Filters{{Authors: []string{"def"}}}); err != nil {
+		t.Fatalf("expected the replacement to fit in the quota, got %v", err)
+	}
+}
+
+func TestFilterWeight(t *testing.T) {
+	tests := []struct {
+		filter   nostr.Filter
+		expected float64
+	}{
+		{filter: nostr.Filter{IDs: []string{"abc"}, Kinds: []int{1}}, expected: 0.1},
+		{filter: nostr.Filter{Authors: []string{"abc"}}, expected: 0.2},
+		{filter: nostr.Filter{Kinds: []int{1}, Tags: nostr.TagMap{"t": {"nostr"}}}, expected: 0.2},
+		{filter: nostr.Filter{Kinds: []int{1}}, expected: 0.5},
+		{filter: nostr.Filter{}, expected: 1},
+	}
+
+	for _, test := range tests {
+		if weight := FilterWeight(test.filter); weight != test.expected {
+			t.Errorf("expected the weight of %v to be %f, got %f", test.filter, test.expected, weight)
+		}
+	}
+}
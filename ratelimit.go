@@ -0,0 +1,75 @@
+package rely
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a collection of token buckets indexed by a key (e.g. an IP or a pubkey).
+// Rate and burst are provided on every call, so that the limits can change dynamically.
+// All methods are safe for concurrent use.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns an empty [RateLimiter].
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket, 1000)}
+}
+
+// Allow reports whether the key can spend one token, refilling its bucket
+// with the provided rate (tokens per second) up to the burst.
+func (l *RateLimiter) Allow(key string, rate, burst float64) bool {
+	return l.AllowN(key, 1, rate, burst)
+}
+
+// AllowN reports whether the key can spend n tokens, refilling its bucket
+// with the provided rate (tokens per second) up to the burst.
+func (l *RateLimiter) AllowN(key string, n, rate, burst float64) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(burst, b.tokens+rate*now.Sub(b.last).Seconds())
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+
+	b.tokens -= n
+	return true
+}
+
+// Prune removes the buckets that haven't been used for longer than the idle duration,
+// to prevent the limiter from growing unbounded.
+func (l *RateLimiter) Prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if time.Since(b.last) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of keys currently tracked.
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,88 @@
+package rely
+
+import (
+	"errors"
+	"strings"
+)
+
+// The machine-readable reasons of the OK and CLOSED messages (NIP-01). The hooks should return them,
+// wrapped with a human-readable message for the clients:
+//
+//	return fmt.Errorf("%w: too many events, please slow down", rely.ErrRateLimited)
+//
+// which is sent as "rate-limited: too many events, please slow down". The errors that wrap none of them,
+// and that are not prefixed with a reason either, are sent with the "error:" prefix.
+var (
+	ErrDuplicate    = errors.New("duplicate")
+	ErrPow          = errors.New("pow")
+	ErrBlocked      = errors.New("blocked")
+	ErrRateLimited  = errors.New("rate-limited")
+	ErrInvalid      = errors.New("invalid")
+	ErrRestricted   = errors.New("restricted")
+	ErrAuthRequired = errors.New("auth-required")
+	ErrMute         = errors.New("mute")
+	ErrError        = errors.New("error")
+)
+
+var reasons = []error{
+	ErrDuplicate,
+	ErrPow,
+	ErrBlocked,
+	ErrRateLimited,
+	ErrInvalid,
+	ErrRestricted,
+	ErrAuthRequired,
+	ErrMute,
+	ErrError,
+}
+
+// Reason returns the machine-readable reason of the error, one of [ErrDuplicate], [ErrPow], [ErrBlocked],
+// [ErrRateLimited], [ErrInvalid], [ErrRestricted], [ErrAuthRequired], [ErrMute] and [ErrError].
+// The reason is the first of them wrapped by the error, or the one its message is prefixed with.
+// It returns [ErrError] for all other errors, and nil if the error is nil.
+func Reason(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if reason := prefix(err.Error()); reason != nil {
+		return reason
+	}
+
+	for _, reason := range reasons {
+		if errors.Is(err, reason) {
+			return reason
+		}
+	}
+	return ErrError
+}
+
+// prefix returns the reason the message is prefixed with, or nil if none.
+func prefix(msg string) error {
+	label, _, found := strings.Cut(msg, ":")
+	if !found {
+		return nil
+	}
+
+	for _, reason := range reasons {
+		if label == reason.Error() {
+			return reason
+		}
+	}
+	return nil
+}
+
+// reasonMessage returns the message of the error, prefixed with its machine-readable reason, which is
+// sent to the clients in the OK and CLOSED messages.
+func reasonMessage(err error) string {
+	msg := err.Error()
+	if prefix(msg) != nil {
+		return msg
+	}
+
+	reason := Reason(err)
+	if msg == reason.Error() {
+		return msg + ":"
+	}
+	return reason.Error() + ": " + msg
+}
@@ -0,0 +1,49 @@
+package rely
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReasonMessage(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: ErrRateLimited, expected: "rate-limited:"},
+		{err: fmt.Errorf("%w: slow down", ErrRateLimited), expected: "rate-limited: slow down"},
+		{err: fmt.Errorf("failed to check: %w", ErrBlocked), expected: "blocked: failed to check: blocked"},
+		{err: errors.New("restricted: members only"), expected: "restricted: members only"},
+		{err: errors.New("something went wrong"), expected: "error: something went wrong"},
+		{err: errors.New("note: not a reason"), expected: "error: note: not a reason"},
+		{err: ErrBanned, expected: "blocked: you are banned from this relay"},
+		{err: fmt.Errorf("%w: kinds 1", ErrOutOfScope), expected: "restricted: outside the scope of the connection: kinds 1"},
+	}
+
+	for _, test := range tests {
+		if msg := reasonMessage(test.err); msg != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, msg)
+		}
+	}
+}
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{err: nil, expected: nil},
+		{err: ErrKindAuth, expected: ErrAuthRequired},
+		{err: ErrInvalidEventID, expected: ErrInvalid},
+		{err: fmt.Errorf("storage: %w", ErrDeleted), expected: ErrBlocked},
+		{err: errors.New("pow: difficulty 20 is required"), expected: ErrPow},
+		{err: errors.New("oops"), expected: ErrError},
+	}
+
+	for _, test := range tests {
+		if reason := Reason(test.err); reason != test.expected {
+			t.Errorf("%v: expected reason %v, got %v", test.err, test.expected, reason)
+		}
+	}
+}
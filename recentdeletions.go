@@ -0,0 +1,117 @@
+package rely
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RecentDeletions remembers the IDs of the events deleted by NIP-09 deletion requests within a window,
+// rejecting them with [ErrDeleted] so that spam tools can't immediately re-publish them, even when
+// the storage doesn't keep tombstones.
+//
+// Example:
+//
+//	deletions := NewRecentDeletions(24 * time.Hour)
+//	relay.Reject.Event = append(relay.Reject.Event, deletions.Reject)
+//	relay.On.Event = deletions.Save(relay.On.Event)
+//	go deletions.Run(ctx)
+type RecentDeletions struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	deleted map[string]time.Time // by "pubkey:id"
+}
+
+// NewRecentDeletions returns a [RecentDeletions] remembering the deleted events for the window.
+func NewRecentDeletions(window time.Duration) *RecentDeletions {
+	return &RecentDeletions{
+		window:  window,
+		deleted: make(map[string]time.Time, 1000),
+	}
+}
+
+// Add remembers the IDs as deleted by the pubkey. Since the ID of an event commits to its author,
+// only the events of the pubkey are rejected afterwards: a deletion request can't block the events of others.
+func (r *RecentDeletions) Add(pubkey string, ids ...string) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		r.deleted[pubkey+":"+id] = now
+	}
+}
+
+// Len returns the number of deleted events remembered.
+func (r *RecentDeletions) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.deleted)
+}
+
+// Reject is a Reject.Event hook that returns [ErrDeleted] if the event was deleted within the window.
+func (r *RecentDeletions) Reject(ctx context.Context, c Client, e *nostr.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted, ok := r.deleted[e.PubKey+":"+e.ID]
+	if ok && time.Since(deleted) < r.window {
+		return ErrDeleted
+	}
+	return nil
+}
+
+// Save wraps the On.Event hook, remembering the events referenced by the "e" tags
+// of the deletion requests (kind 5) it saves successfully.
+func (r *RecentDeletions) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		if e.Kind != nostr.KindDeletion {
+			return nil
+		}
+
+		var ids []string
+		for _, tag := range e.Tags {
+			if len(tag) >= 2 && tag[0] == "e" {
+				ids = append(ids, tag[1])
+			}
+		}
+
+		r.Add(e.PubKey, ids...)
+		return nil
+	}
+}
+
+// Run periodically forgets the events deleted before the window until the context is cancelled.
+func (r *RecentDeletions) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			r.prune(now.Add(-r.window))
+		}
+	}
+}
+
+func (r *RecentDeletions) prune(cutoff time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, deleted := range r.deleted {
+		if deleted.Before(cutoff) {
+			delete(r.deleted, key)
+		}
+	}
+}
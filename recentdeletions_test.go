@@ -0,0 +1,52 @@
+package rely
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRecentDeletions(t *testing.T) {
+	other := "0" + pk[1:]
+	deletions := NewRecentDeletions(time.Hour)
+	save := deletions.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+
+	request := &nostr.Event{
+		PubKey: pk,
+		Kind:   nostr.KindDeletion,
+		Tags:   nostr.Tags{{"e", "deleted"}, {"p", other}},
+	}
+
+	if err := save(context.Background(), nil, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		err   error
+	}{
+		{name: "deleted event", event: &nostr.Event{ID: "deleted", PubKey: pk}, err: ErrDeleted},
+		{name: "event of another pubkey", event: &nostr.Event{ID: "deleted", PubKey: other}},
+		{name: "other event", event: &nostr.Event{ID: "other", PubKey: pk}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := deletions.Reject(context.Background(), nil, test.event); err != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	deletions.prune(time.Now().Add(time.Minute))
+	if deletions.Len() != 0 {
+		t.Fatalf("expected the deletions to be forgotten, got %d", deletions.Len())
+	}
+
+	if err := deletions.Reject(context.Background(), nil, tests[0].event); err != nil {
+		t.Fatalf("expected the event to be accepted after the window, got %v", err)
+	}
+}
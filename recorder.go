@@ -0,0 +1,200 @@
+package rely
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Direction of a [Frame], as seen from the relay.
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+)
+
+// Frame is a single websocket message exchanged between the relay and a client.
+type Frame struct {
+	Time      time.Time
+	ClientUID string
+	IP        string
+	Direction Direction
+	Data      []byte
+}
+
+// Recorder receives every websocket message read from or written to the sampled clients.
+// It's meant for diagnosing interoperability bugs, and it's disabled by default.
+// Implementations must be safe for concurrent use and fast, as Record is called
+// on the hot path of the client's read and write loops.
+type Recorder interface {
+	// Sampled returns whether the connection with the provided UID is recorded.
+	// It's called once when the client connects, so the frames of the other connections are never copied.
+	Sampled(clientUID string) bool
+
+	Record(Frame)
+}
+
+// FileRecorder is a [Recorder] that appends frames as JSON lines to files inside Dir,
+// rotating to a new file every time the current one exceeds MaxSize bytes.
+type FileRecorder struct {
+	// Dir is the directory where the recording files are created.
+	Dir string
+
+	// MaxSize is the maximum size in bytes of a single file before rotating (default 100MB).
+	MaxSize int64
+
+	// SampleRate is the fraction of connections whose traffic is recorded, between 0 and 1.
+	// The sampling is done per connection, so a recorded connection is recorded in full.
+	SampleRate float64
+
+	// RedactPubkeys replaces the pubkeys found in the frames with a short fingerprint.
+	RedactPubkeys bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileRecorder returns a [FileRecorder] that records all connections in the provided directory.
+func NewFileRecorder(dir string) *FileRecorder {
+	return &FileRecorder{
+		Dir:        dir,
+		MaxSize:    100 << 20,
+		SampleRate: 1,
+	}
+}
+
+// pubkeyField matches the fields of events and filters holding pubkeys, and the "p" tags of the events.
+var pubkeyField = regexp.MustCompile(`"(pubkey|authors|#p)"\s*:\s*(\[[^\]]*\]|"[0-9a-f]{64}")|\[\s*"p"\s*,\s*"[0-9a-f]{64}"`)
+var hexKey = regexp.MustCompile(`[0-9a-f]{64}`)
+
+type recordedFrame struct {
+	Time      int64           `json:"time"`
+	ClientUID string          `json:"client"`
+	IP        string          `json:"ip"`
+	Direction Direction       `json:"dir"`
+	Frame     json.RawMessage `json:"frame,omitempty"`
+	Raw       string          `json:"raw,omitempty"`
+}
+
+// Sampled returns whether the connection with the provided UID is recorded, see [SampleConnection].
+func (r *FileRecorder) Sampled(clientUID string) bool {
+	return SampleConnection(clientUID, r.SampleRate)
+}
+
+// Record the frame. Errors are printed to stderr, as the recorder must never interfere with the relay.
+func (r *FileRecorder) Record(f Frame) {
+	data := f.Data
+	if r.RedactPubkeys {
+		data = RedactPubkeys(data)
+	}
+
+	record := recordedFrame{
+		Time:      f.Time.UnixMicro(),
+		ClientUID: f.ClientUID,
+		IP:        f.IP,
+		Direction: f.Direction,
+	}
+
+	if json.Valid(data) {
+		record.Frame = data
+	} else {
+		record.Raw = string(data)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "recorder: failed to marshal frame: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.rotate(int64(len(line))); err != nil {
+		fmt.Fprintf(os.Stderr, "recorder: %v\n", err)
+		return
+	}
+
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "recorder: failed to write frame: %v\n", err)
+	}
+}
+
+// Close the current recording file.
+func (r *FileRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// SampleConnection returns whether the connection with the provided UID is recorded at the rate,
+// the fraction of connections recorded between 0 and 1. The decision is deterministic, so that
+// every [Recorder] samples the same connections at the same rate.
+func SampleConnection(clientUID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(clientUID))
+	return float64(h.Sum32())/float64(1<<32) < rate
+}
+
+// rotate opens a new file if there is none, or if writing n more bytes would exceed the MaxSize.
+// It must be called while holding the lock.
+func (r *FileRecorder) rotate(n int64) error {
+	if r.file != nil && (r.MaxSize <= 0 || r.size+n <= r.MaxSize) {
+		return nil
+	}
+
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	name := filepath.Join(r.Dir, fmt.Sprintf("frames-%s.jsonl", time.Now().UTC().Format("20060102T150405.000000")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	r.file = file
+	r.size = 0
+	return nil
+}
+
+// RedactPubkeys replaces the pubkeys in the "pubkey", "authors" and "#p" fields and in the "p" tags
+// with a short fingerprint, so that recordings can be shared without exposing identities.
+func RedactPubkeys(data []byte) []byte {
+	return pubkeyField.ReplaceAllFunc(data, func(field []byte) []byte {
+		return hexKey.ReplaceAllFunc(field, func(pk []byte) []byte {
+			h := fnv.New64a()
+			h.Write(pk)
+			return fmt.Appendf(nil, "redacted:%016x", h.Sum64())
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const pk = "f683e87035f7ad4f44e0b98cfbd9537e16455a92cd38cefc4cb31db7557f5ef2"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "event pubkey",
+			data: `["EVENT",{"id":"abc","pubkey":"` + pk + `","kind":1}]`,
+		},
+		{
+			name: "filter authors",
+			data: `["REQ","sub",{"authors":["` + pk + `","` + pk + `"]}]`,
+		},
+		{
+			name: "filter p tags",
+			data: `["REQ","sub",{"#p":["` + pk + `"]}]`,
+		},
+		{
+			name: "event p tags",
+			data: `["EVENT",{"id":"abc","kind":1,"tags":[["e","abc"],["p","` + pk + `","wss://relay.example.com"],[ "p" , "` + pk + `"]]}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted := RedactPubkeys([]byte(test.data))
+			if strings.Contains(string(redacted), pk) {
+				t.Fatalf("pubkey was not redacted: %s", redacted)
+			}
+
+			if !json.Valid(redacted) {
+				t.Fatalf("redacted frame is invalid json: %s", redacted)
+			}
+		})
+	}
+}
+
+func TestSampleConnection(t *testing.T) {
+	var sampled int
+	for i := range 10000 {
+		if SampleConnection(join("uid", string(rune(i))), 0.5) {
+			sampled++
+		}
+	}
+
+	if sampled < 4000 || sampled > 6000 {
+		t.Fatalf("expected roughly half the connections to be sampled, got %d/10000", sampled)
+	}
+}
+
+// fixedRecorder is a [Recorder] sampling the connections with a fixed decision.
+type fixedRecorder struct {
+	sampled bool
+	frames  chan Frame
+}
+
+func (r *fixedRecorder) Sampled(string) bool { return r.sampled }
+func (r *fixedRecorder) Record(f Frame)      { r.frames <- f }
+
+func TestRecorderSampling(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		recorder := &fixedRecorder{sampled: sampled, frames: make(chan Frame, 100)}
+		relay := NewRelay(WithDomain("example.com"), WithRecorder(recorder))
+		relay.Start(ctx)
+
+		server := httptest.NewServer(relay)
+		defer server.Close()
+
+		conn, _, err := ws.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		send(t, conn, []any{"REQ", "sub", nostr.Filter{Kinds: []int{1}}})
+		if label, _ := readMessage(t, conn); label == "AUTH" {
+			readMessage(t, conn)
+		}
+
+		if recorded := len(recorder.frames) > 0; recorded != sampled {
+			t.Fatalf("expected the frames to be recorded %v, got %v", sampled, recorded)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrEventTooLarge = fmt.Errorf("%w: the event is too large", ErrInvalid)
+
+// ReasonTooLarge is the reason of the rejections of the events that are too large, see [RejectionReason].
+const ReasonTooLarge = "too-large"
+
+// RejectionReason returns the reason of the rejection for the accounting: the machine-readable reason
+// of the error (see [Reason]), or [ReasonTooLarge] for the events rejected with [ErrEventTooLarge].
+func RejectionReason(err error) string {
+	if errors.Is(err, ErrEventTooLarge) {
+		return ReasonTooLarge
+	}
+	return Reason(err).Error()
+}
+
+// Rejection identifies the counter of the events rejected by a policy for a reason.
+type Rejection struct {
+	Policy string
+	Reason string
+}
+
+// Rejections counts the rejected events by policy and reason (see [RejectionReason]), so that
+// operators can see which defenses are firing. The hooks are wrapped with [Rejections.Event]
+// with the name of their policy. All methods are safe for concurrent use.
+//
+// Example:
+//
+//	rejections := NewRejections()
+//	relay.Reject.Event = append(relay.Reject.Event, rejections.Event("size", MaxEventSize(65536, nil)))
+//	relay.On.Event = rejections.Event("storage", relay.On.Event)
+type Rejections struct {
+	mu     sync.Mutex
+	counts map[Rejection]int64
+}
+
+// NewRejections returns [Rejections] without counts.
+func NewRejections() *Rejections {
+	return &Rejections{counts: make(map[Rejection]int64)}
+}
+
+// Event wraps a Reject.Event hook or the On.Event hook, counting the events it rejects under the policy.
+func (r *Rejections) Event(policy string, reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		err := reject(ctx, c, e)
+		if err != nil {
+			r.Add(policy, err)
+		}
+		return err
+	}
+}
+
+// Add counts an event rejected by the policy with the error.
+func (r *Rejections) Add(policy string, err error) {
+	key := Rejection{Policy: policy, Reason: RejectionReason(err)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[key]++
+}
+
+// Counts returns a snapshot of the number of rejected events, by policy and reason.
+func (r *Rejections) Counts() map[Rejection]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.counts)
+}
+
+// ByReason returns a snapshot of the number of rejected events by reason, across all policies.
+func (r *Rejections) ByReason() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reasons := make(map[string]int64)
+	for key, count := range r.counts {
+		reasons[key.Reason] += count
+	}
+	return reasons
+}
@@ -0,0 +1,60 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRejectionReason(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{err: fmt.Errorf("%w: slow down", ErrRateLimited), reason: "rate-limited"},
+		{err: fmt.Errorf("%w (1000 bytes)", ErrEventTooLarge), reason: ReasonTooLarge},
+		{err: ErrInvalidEventSignature, reason: "invalid"},
+		{err: errors.New("pow: difficulty 10 is less than 20"), reason: "pow"},
+		{err: errors.New("boom"), reason: "error"},
+	}
+
+	for _, test := range tests {
+		if reason := RejectionReason(test.err); reason != test.reason {
+			t.Errorf("%v: expected reason %q, got %q", test.err, test.reason, reason)
+		}
+	}
+}
+
+func TestRejections(t *testing.T) {
+	rejections := NewRejections()
+	size := rejections.Event("size", MaxEventSize(100, nil))
+	storage := rejections.Event("storage", func(context.Context, Client, *nostr.Event) error { return ErrDuplicate })
+
+	small := &nostr.Event{Content: "hello"}
+	large := &nostr.Event{Content: string(make([]byte, 200))}
+
+	if err := size(context.Background(), nil, small); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := size(context.Background(), nil, large); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("expected the large event to be rejected, got %v", err)
+	}
+	storage(context.Background(), nil, small)
+	storage(context.Background(), nil, small)
+
+	expected := map[Rejection]int64{
+		{Policy: "size", Reason: ReasonTooLarge}: 1,
+		{Policy: "storage", Reason: "duplicate"}: 2,
+	}
+	if counts := rejections.Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+
+	if reasons := rejections.ByReason(); reasons["duplicate"] != 2 || reasons[ReasonTooLarge] != 1 {
+		t.Fatalf("unexpected counts by reason %v", reasons)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -14,7 +16,9 @@ import (
 var (
 	ErrShuttingDown     = errors.New("the relay is shutting down, please try again later")
 	ErrOverloaded       = errors.New("the relay is overloaded, please try again later")
+	ErrUnsupportedNIP42 = errors.New("NIP-42 AUTH is not supported")
 	ErrUnsupportedNIP45 = errors.New("NIP-45 COUNT is not supported")
+	ErrUnsupportedNIP50 = errors.New("NIP-50 search is not supported")
 )
 
 // Relay is the fundamental structure of the rely package, acting as an orchestrator
@@ -25,6 +29,8 @@ type Relay struct {
 	clients    map[*client]struct{}
 	register   chan *client
 	unregister chan *client
+	notices    chan string
+	listings   chan chan []*client
 
 	dispatcher *dispatcher
 	processor  *processor
@@ -32,10 +38,20 @@ type Relay struct {
 
 	log *slog.Logger
 
+	// the IDs of the accepted AUTH events, so that they can't be replayed
+	usedAuths usedAuths
+
 	Hooks
 	systemSettings
 	websocketSettings
 
+	// the handlers of the EVENTs by kind. To register them, use [Relay.OnKind].
+	kindHandlers map[int][]func(context.Context, Client, *nostr.Event) error
+
+	info   atomic.Pointer[relayInfo]
+	infoMu sync.Mutex // serializes updates of the info
+	nips   []int      // the NIPs declared with [Relay.Supports], guarded by the infoMu
+
 	wg   sync.WaitGroup
 	done chan struct{}
 }
@@ -56,6 +72,8 @@ func NewRelay(opts ...Option) *Relay {
 		clients:           make(map[*client]struct{}, 1000),
 		register:          make(chan *client, 256),
 		unregister:        make(chan *client, 256),
+		notices:           make(chan string, 16),
+		listings:          make(chan chan []*client),
 		log:               slog.Default(),
 		Hooks:             DefaultHooks(),
 		systemSettings:    newSystemSettings(),
@@ -65,12 +83,19 @@ func NewRelay(opts ...Option) *Relay {
 
 	r.dispatcher = newDispatcher(r)
 	r.processor = newProcessor(r)
+	r.info.Store(newRelayInfo())
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
 	r.validate()
+	r.refreshNIPs()
+	r.UpdateLimits(r.settingsLimits)
+	if r.identity != nil {
+		// the info was already encoded, so it's valid; this sets its pubkey
+		r.SetInfo(r.Info())
+	}
 	return r
 }
 
@@ -87,20 +112,104 @@ func (r *Relay) Broadcast(e *nostr.Event) error {
 	}
 }
 
+// NotifyAll sends the NOTICE to all connected clients, e.g. to warn them of a maintenance.
+// To send a signed announcement to the subscribed clients instead, use [Relay.Publish].
+func (r *Relay) NotifyAll(msg string) error {
+	select {
+	case r.notices <- msg:
+		return nil
+	case <-r.done:
+		return ErrShuttingDown
+	default:
+		return ErrOverloaded
+	}
+}
+
+// ListClients returns the connected clients, ordered by the time of their connection.
+// It returns [ErrShuttingDown] if the relay is shutting down.
+//
+// Example:
+//
+//	clients, err := relay.ListClients()
+//	if err != nil {
+//		return err
+//	}
+//
+//	for _, c := range clients {
+//		stats := c.Stats()
+//		log.Printf("%s: %d messages received, %d events accepted", c.IP(), stats.MessagesReceived, stats.EventsAccepted)
+//	}
+func (r *Relay) ListClients() ([]Client, error) {
+	connected, err := r.connected()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(connected, func(a, b *client) int { return a.connectedAt.Compare(b.connectedAt) })
+	clients := make([]Client, len(connected))
+	for i, c := range connected {
+		clients[i] = c
+	}
+	return clients, nil
+}
+
+// connected returns a snapshot of the connected clients, taken by the run loop that owns them.
+func (r *Relay) connected() ([]*client, error) {
+	reply := make(chan []*client, 1)
+	select {
+	case r.listings <- reply:
+	case <-r.done:
+		return nil, ErrShuttingDown
+	}
+	return <-reply, nil
+}
+
 // tryProcess tries to add the request to the processing queue of the relay.
 // If it's full, it returns [ErrOverloaded] inside the [requestError]
 func (r *Relay) tryProcess(rq request) *requestError {
+	queue := r.processor.queue
+	if e, ok := rq.(eventRequest); ok {
+		if r.isFast(e.Event.Kind) {
+			queue = r.processor.priority
+		}
+
+		if r.memoryLimit > 0 {
+			if r.overMemoryBudget() {
+				r.stats.shedEvents.Add(1)
+				return &requestError{ID: rq.ID(), Err: ErrMemoryBudget}
+			}
+
+			e.size = eventSize(e.Event)
+			r.reserve(e.size)
+			rq = e
+		}
+	}
+
 	select {
-	case r.processor.queue <- rq:
+	case queue <- rq:
 		return nil
 	case <-r.done:
+		r.releaseRequest(rq)
 		return &requestError{ID: rq.ID(), Err: ErrShuttingDown}
 	default:
+		r.releaseRequest(rq)
 		r.log.Warn("failed to enqueue request", "uid", rq.UID(), "error", ErrOverloaded)
 		return &requestError{ID: rq.ID(), Err: ErrOverloaded}
 	}
 }
 
+// releaseRequest releases the memory accounted for the request, once it leaves the queue.
+func (r *Relay) releaseRequest(rq request) {
+	if e, ok := rq.(eventRequest); ok {
+		r.release(e.size)
+	}
+}
+
+// isFast reports whether the kind is one of the fast kinds. See [WithFastKinds].
+func (r *Relay) isFast(kind int) bool {
+	return slices.Contains(r.fastKinds, kind)
+}
+
 // Index sends the indexing update of subscription to the dispatcher.
 func (r *Relay) index(s subscription) {
 	select {
@@ -121,6 +230,16 @@ func (r *Relay) unindex(s subscription) {
 	}
 }
 
+// follow sends the update of the addresses followed by the subscription to the dispatcher.
+func (r *Relay) follow(uid string) {
+	select {
+	case r.dispatcher.updates <- update{operation: follow, sub: subscription{uid: uid}}:
+		return
+	case <-r.done:
+		return
+	}
+}
+
 // StartAndServe starts the relay, listens to the provided address and handles http requests.
 //
 // It's a blocking operation, that stops only when the context gets cancelled.
@@ -159,6 +278,7 @@ func (r *Relay) StartAndServe(ctx context.Context, address string) error {
 // For a proper shutdown process, you have to call [Relay.Wait] before closing your program.
 func (r *Relay) Start(ctx context.Context) {
 	r.log.Info("starting up the relay")
+	r.refreshNIPs() // the hooks are set after the relay is created
 	r.wg.Add(3)
 	go r.run(ctx)
 	go r.dispatcher.Run()
@@ -176,6 +296,8 @@ func (r *Relay) Wait() {
 // Run syncronizes access to the clients map. It performs:
 //   - client registration
 //   - client unregistration
+//   - notices to all clients
+//   - listings of the clients
 //   - shutdown when the context is cancelled
 func (r *Relay) run(ctx context.Context) {
 	defer func() {
@@ -197,10 +319,15 @@ func (r *Relay) run(ctx context.Context) {
 			go client.write()
 			r.On.Connect(client)
 
+			// the clients authenticated by the credentials of their upgrade (see [WithAuthenticators])
+			if client.Pubkey() != "" {
+				r.On.Auth(client)
+			}
+
 		case client := <-r.unregister:
 			delete(r.clients, client)
 			r.stats.clients.Add(-1)
-			r.On.Disconnect(client)
+			r.disconnected(client)
 
 			// perform batch unregistration to prevent [client.Disconnect] from getting stuck
 			// on the channel send when many disconnections occur at the same time.
@@ -208,12 +335,30 @@ func (r *Relay) run(ctx context.Context) {
 				client = <-r.unregister
 				delete(r.clients, client)
 				r.stats.clients.Add(-1)
-				r.On.Disconnect(client)
+				r.disconnected(client)
 			}
+
+		case msg := <-r.notices:
+			for client := range r.clients {
+				client.SendNotice(msg)
+			}
+
+		case reply := <-r.listings:
+			clients := make([]*client, 0, len(r.clients))
+			for client := range r.clients {
+				clients = append(clients, client)
+			}
+			reply <- clients
 		}
 	}
 }
 
+// disconnected invokes the On.Disconnect hook with the reason of the client's disconnection.
+func (r *Relay) disconnected(c *client) {
+	reason, err := c.disconnected()
+	r.On.Disconnect(c, reason, err)
+}
+
 // Shutdown correctly unregisters all connected clients for a safe shutdown.
 func (r *Relay) shutdown() {
 	r.log.Info("shutting down the relay...")
@@ -238,9 +383,16 @@ drainRegister:
 	// Remove clients in the map not in the unregistering queue.
 	// It's important to distinguish to avoid double closing the client.done.
 	for client := range r.clients {
+		// no-op for the clients in the unregistering queue, which already recorded their reason
+		client.setDisconnection(DisconnectShutdown, ErrShuttingDown)
+
 		if client.isUnregistering.CompareAndSwap(false, true) {
 			close(client.done)
+			if client.cancel != nil {
+				client.cancel()
+			}
 			r.stats.clients.Add(-1)
+			r.disconnected(client)
 		}
 	}
 
@@ -249,8 +401,9 @@ drainRegister:
 drainUnregister:
 	for {
 		select {
-		case <-r.unregister:
+		case client := <-r.unregister:
 			r.stats.clients.Add(-1)
+			r.disconnected(client)
 		default:
 			break drainUnregister
 		}
@@ -262,15 +415,21 @@ drainUnregister:
 func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	select {
 	case <-r.done:
-		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		refuseUpgrade(w, http.StatusServiceUnavailable, RetryAfter(ErrShuttingDown, retryShuttingDown))
 		return
 	default:
 		// proceed
 	}
 
+	// the hooks read the IP resolved with the trusted proxies, and the pubkey of the credentials
+	req = req.WithContext(context.WithValue(req.Context(), ipKey{}, r.clientIP(req)))
+	if req.Header.Get("Upgrade") == "websocket" {
+		req = r.withCredentials(req)
+	}
+
 	for _, reject := range r.Reject.Connection {
 		if err := reject(r, req); err != nil {
-			http.Error(w, err.Error(), http.StatusForbidden)
+			refuseUpgrade(w, upgradeStatus(err), err)
 			return
 		}
 	}
@@ -287,8 +446,44 @@ func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// clientIP returns the IP of the client of the request, resolved with the trusted proxies of the relay.
+func (r *Relay) clientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(ipKey{}).(string); ok {
+		return ip
+	}
+	return TrustedIP(req, r.proxies)
+}
+
 // ServeWS upgrades the http request to a websocket, creates a [client], and registers it with the [Relay].
+// Requests not matching the allowed hosts or origins, or with invalid credentials or scope, are refused before the upgrade
+// with a JSON body describing the error.
 func (r *Relay) ServeWS(w http.ResponseWriter, req *http.Request) {
+	if status, err := r.checkRequest(req); err != nil {
+		refuseUpgrade(w, status, err)
+		return
+	}
+
+	pubkey, err := r.credentials(req)
+	if err != nil {
+		r.challengeHeaders(w)
+		refuseUpgrade(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var scope *scope
+	if r.connectionScopes {
+		if scope, err = parseScope(req.URL.Query()); err != nil {
+			refuseUpgrade(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var hijacker *batchHijacker
+	if r.batchFrames > 1 {
+		hijacker = &batchHijacker{ResponseWriter: w}
+		w = hijacker
+	}
+
 	conn, err := r.upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		r.log.Error("failed to upgrade to websocket", "error", err)
@@ -298,13 +493,28 @@ func (r *Relay) ServeWS(w http.ResponseWriter, req *http.Request) {
 	client := &client{
 		subs:        make(map[string]subscription, 10),
 		uid:         r.assignID(),
-		ip:          IP(req),
+		ip:          r.clientIP(req),
+		pubkey:      pubkey,
+		scope:       scope,
 		connectedAt: time.Now(),
 		relay:       r,
 		conn:        conn,
 		responses:   make(chan response, r.responseLimit),
 		done:        make(chan struct{}),
 	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+
+	if hijacker != nil {
+		client.batch = hijacker.conn
+	}
+
+	if r.geoip != nil {
+		client.country = r.geoip.Country(client.ip)
+	}
+
+	if r.recorder != nil {
+		client.recorded = r.recorder.Sampled(client.uid)
+	}
 
 	select {
 	case r.register <- client:
@@ -326,5 +536,5 @@ func (r *Relay) ServeNIP11(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/nostr+json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(r.info)
+	w.Write(r.info.Load().json)
 }
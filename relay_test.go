@@ -0,0 +1,90 @@
+package rely
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNotifyAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"))
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	notices := make(chan string, 10)
+	for range 2 {
+		conn := nostr.NewRelay(ctx, url, nostr.WithNoticeHandler(func(n string) { notices <- n }))
+		if err := conn.Connect(ctx); err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+	}
+
+	// wait for the clients to be registered
+	for relay.Clients() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := relay.NotifyAll("maintenance in 5 minutes"); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+
+	for range 2 {
+		select {
+		case notice := <-notices:
+			if notice != "maintenance in 5 minutes" {
+				t.Fatalf("unexpected notice %q", notice)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the notice was not received by all clients")
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the test server is not a trusted proxy, so the header can't be trusted
+	ips := make(chan string, 2)
+	relay := NewRelay(WithDomain("example.com"), WithTrustedProxies("10.0.0.0/8"))
+	relay.Reject.Connection = append(relay.Reject.Connection, func(_ Stats, r *http.Request) error {
+		ips <- IP(r)
+		return nil
+	})
+	relay.On.Connect = func(c Client) { ips <- c.IP() }
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, http.Header{"X-Forwarded-For": {"10.1.2.3"}})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	for range 2 {
+		select {
+		case ip := <-ips:
+			if ip != "127.0.0.1" {
+				t.Fatalf("expected the remote address 127.0.0.1, got %s", ip)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the client was not registered")
+		}
+	}
+}
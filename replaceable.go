@@ -0,0 +1,118 @@
+package rely
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// followedSet holds the addresses of the replaceable events delivered to a subscription for a filter by IDs,
+// whose new versions are pushed to it. It's shared between the subscription and its REQ.
+// See [WithReplaceableUpdates].
+type followedSet struct {
+	mu        sync.Mutex
+	addresses []string
+}
+
+// add the addresses to the set.
+func (f *followedSet) add(addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, a := range addresses {
+		if !slices.Contains(f.addresses, a) {
+			f.addresses = append(f.addresses, a)
+		}
+	}
+}
+
+// contains reports whether the address is in the set.
+func (f *followedSet) contains(address string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Contains(f.addresses, address)
+}
+
+// items returns a snapshot of the addresses in the set.
+func (f *followedSet) items() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.addresses)
+}
+
+// address returns the address of a replaceable ("<kind>:<pubkey>") or addressable ("<kind>:<pubkey>:<d>") event,
+// or an empty string if it's neither.
+func address(e *nostr.Event) string {
+	switch {
+	case nostr.IsReplaceableKind(e.Kind):
+		return join(strconv.Itoa(e.Kind), e.PubKey)
+	case nostr.IsAddressableKind(e.Kind):
+		return join(strconv.Itoa(e.Kind), e.PubKey, e.Tags.GetD())
+	default:
+		return ""
+	}
+}
+
+// followed returns the addresses of the replaceable and addressable events that match a filter by IDs,
+// whose new versions wouldn't match it.
+func followed(filters nostr.Filters, events []nostr.Event) []string {
+	var addresses []string
+	for i := range events {
+		a := address(&events[i])
+		if a == "" {
+			continue
+		}
+
+		for _, f := range filters {
+			if len(f.IDs) > 0 && f.Matches(&events[i]) {
+				addresses = append(addresses, a)
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// hasIDs reports whether any of the filters is by IDs.
+func hasIDs(filters nostr.Filters) bool {
+	return slices.ContainsFunc(filters, func(f nostr.Filter) bool { return len(f.IDs) > 0 })
+}
+
+// LatestVersions removes in-place the versions of the replaceable and addressable events superseded by a newer
+// version in the events, and returns the remaining ones. When two versions have the same created_at, the one
+// with the lowest ID is kept (NIP-01). Use it in On.Req when the storage retains the previous versions,
+// e.g. for profile-history and audit tools.
+func LatestVersions(events []nostr.Event) []nostr.Event {
+	latest := make(map[string]*nostr.Event)
+	for i := range events {
+		a := address(&events[i])
+		if a == "" {
+			continue
+		}
+
+		current, ok := latest[a]
+		if !ok || events[i].CreatedAt > current.CreatedAt ||
+			(events[i].CreatedAt == current.CreatedAt && events[i].ID < current.ID) {
+			latest[a] = &events[i]
+		}
+	}
+
+	if len(latest) == 0 {
+		return events
+	}
+
+	ids := make(map[string]struct{}, len(latest))
+	for _, e := range latest {
+		ids[e.ID] = struct{}{}
+	}
+
+	return slices.DeleteFunc(events, func(e nostr.Event) bool {
+		if address(&e) == "" {
+			return false
+		}
+		_, ok := ids[e.ID]
+		return !ok
+	})
+}
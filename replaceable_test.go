@@ -0,0 +1,122 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAddress(t *testing.T) {
+	cases := []struct {
+		event    nostr.Event
+		expected string
+	}{
+		{nostr.Event{Kind: 0, PubKey: pk}, "0:" + pk},
+		{nostr.Event{Kind: 10002, PubKey: pk}, "10002:" + pk},
+		{nostr.Event{Kind: 30023, PubKey: pk, Tags: nostr.Tags{{"d", "article"}}}, "30023:" + pk + ":article"},
+		{nostr.Event{Kind: 1, PubKey: pk}, ""},
+	}
+
+	for _, c := range cases {
+		if a := address(&c.event); a != c.expected {
+			t.Errorf("kind %d: expected %q, got %q", c.event.Kind, c.expected, a)
+		}
+	}
+}
+
+func TestLatestVersions(t *testing.T) {
+	events := []nostr.Event{
+		{ID: "profile-v2", Kind: 0, PubKey: pk, CreatedAt: 200},
+		{ID: "note", Kind: 1, PubKey: pk, CreatedAt: 150},
+		{ID: "profile-v1", Kind: 0, PubKey: pk, CreatedAt: 100},
+		{ID: "article-b", Kind: 30023, PubKey: pk, CreatedAt: 100, Tags: nostr.Tags{{"d", "hello"}}},
+		{ID: "article-a", Kind: 30023, PubKey: pk, CreatedAt: 100, Tags: nostr.Tags{{"d", "hello"}}},
+		{ID: "other-article", Kind: 30023, PubKey: pk, CreatedAt: 50, Tags: nostr.Tags{{"d", "other"}}},
+	}
+
+	events = LatestVersions(events)
+	expected := []string{"profile-v2", "note", "article-a", "other-article"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, id := range expected {
+		if events[i].ID != id {
+			t.Errorf("expected %s at %d, got %s", id, i, events[i].ID)
+		}
+	}
+}
+
+func TestReplaceableUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	old := nostr.Event{Kind: 0, CreatedAt: nostr.Now() - 60, Tags: nostr.Tags{}, Content: `{"name":"old"}`}
+	if err := old.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	relay := NewRelay(WithDomain("example.com"), WithReplaceableUpdates())
+	relay.On.Req = func(_ context.Context, _ Client, filters nostr.Filters) ([]nostr.Event, error) {
+		if filters.Match(&old) {
+			return []nostr.Event{old}, nil
+		}
+		return nil, nil
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// a raw connection, because go-nostr drops the events that don't match the filters of the subscription
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	req := nostr.ReqEnvelope{SubscriptionID: "profile", Filters: nostr.Filters{{IDs: []string{old.ID}}}}
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatalf("failed to send the REQ: %v", err)
+	}
+
+	if e := readEvent(t, conn); e.ID != old.ID {
+		t.Fatalf("expected the old profile, got %v", e)
+	}
+
+	// wait for the followed address to be indexed
+	time.Sleep(50 * time.Millisecond)
+
+	updated := nostr.Event{Kind: 0, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: `{"name":"new"}`}
+	if err := updated.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	relay.Broadcast(&updated)
+
+	if e := readEvent(t, conn); e.ID != updated.ID {
+		t.Fatalf("expected the updated profile, got %v", e)
+	}
+}
+
+// readEvent reads the messages of the connection until an EVENT, which it returns.
+func readEvent(t *testing.T, conn *ws.Conn) nostr.Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read the event: %v", err)
+		}
+
+		if env, ok := nostr.ParseMessage(string(msg)).(*nostr.EventEnvelope); ok {
+			return env.Event
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrLowReputation = fmt.Errorf("%w: your IP has a low reputation", ErrBlocked)
+
+// ReputationConfig configures the [Reputation] module.
+// Scores range from 0 (worst) to 100 (best), and every IP starts at 100.
+type ReputationConfig struct {
+	// RejectionPenalty is subtracted from the score of an IP every time one of its events is rejected.
+	RejectionPenalty float64
+
+	// HalfLife is the time after which half of the rejection penalties are forgiven.
+	HalfLife time.Duration
+
+	// DNSBLs are the DNS blocklist zones (e.g. "zen.spamhaus.org") queried for IPv4 clients.
+	// DNSBLPenalty is subtracted from the score of an IP for each zone listing it.
+	// The IPs are looked up in the background, so they are scored as not listed until the lookups complete.
+	DNSBLs        []string
+	DNSBLPenalty  float64
+	DNSBLTimeout  time.Duration
+	DNSBLCacheTTL time.Duration
+
+	// Networks are CIDR ranges (e.g. the prefixes announced by an abusive ASN).
+	// NetworkPenalty is subtracted from the score of the IPs inside any of them.
+	Networks       []string
+	NetworkPenalty float64
+
+	// IPs with a score below RefuseBelow are refused the websocket upgrade.
+	// IPs with a score below RestrictBelow have their events rate limited to RestrictedRate per second.
+	RefuseBelow    float64
+	RestrictBelow  float64
+	RestrictedRate float64
+}
+
+// DefaultReputationConfig returns a [ReputationConfig] with sane defaults and no DNSBLs or networks.
+func DefaultReputationConfig() ReputationConfig {
+	return ReputationConfig{
+		RejectionPenalty: 2,
+		HalfLife:         time.Hour,
+		DNSBLPenalty:     40,
+		DNSBLTimeout:     time.Second,
+		DNSBLCacheTTL:    time.Hour,
+		NetworkPenalty:   50,
+		RefuseBelow:      20,
+		RestrictBelow:    60,
+		RestrictedRate:   0.5,
+	}
+}
+
+// Reputation scores client IPs based on their local history of rejections, optional
+// DNSBL lookups and lists of networks, and applies stricter limits or outright refusal
+// of the websocket upgrade to low-reputation sources.
+//
+// Example:
+//
+//	rep, err := NewReputation(DefaultReputationConfig())
+//	relay.Reject.Connection = append(relay.Reject.Connection, rep.RejectConnection)
+//	relay.Reject.Event = append(relay.Reject.Event, rep.RateLimit)
+//	relay.Reject.Event = rep.TrackAll(relay.Reject.Event)
+//	go rep.Run(ctx)
+type Reputation struct {
+	config   ReputationConfig
+	networks []*net.IPNet
+	limiter  *RateLimiter
+
+	// lookupHost resolves the DNSBL queries, see [net.Resolver.LookupHost]
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu        sync.Mutex
+	penalties map[string]penalty
+	listings  map[string]listing
+	resolving map[string]struct{} // the IPs whose DNSBL lookups are in progress
+
+	refused atomic.Int64
+}
+
+// penalty is the rejection penalty of an IP at the time of the last update.
+type penalty struct {
+	value   float64
+	updated time.Time
+}
+
+// listing is the cached number of DNSBLs listing an IP.
+type listing struct {
+	count   int
+	expires time.Time
+}
+
+// NewReputation returns a [Reputation] module, or an error if the half-life is not positive
+// or any of the networks is not a valid CIDR.
+func NewReputation(config ReputationConfig) (*Reputation, error) {
+	if config.HalfLife <= 0 {
+		return nil, errors.New("the half-life must be positive")
+	}
+
+	r := &Reputation{
+		config:     config,
+		limiter:    NewRateLimiter(),
+		lookupHost: net.DefaultResolver.LookupHost,
+		penalties:  make(map[string]penalty, 1000),
+		listings:   make(map[string]listing, 1000),
+		resolving:  make(map[string]struct{}),
+	}
+
+	for _, cidr := range config.Networks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		r.networks = append(r.networks, network)
+	}
+	return r, nil
+}
+
+// Score returns the reputation of the IP, between 0 and 100. It never blocks on the DNSBLs:
+// the IPs are looked up in the background, and are penalized once their listings are known.
+func (r *Reputation) Score(ip string) float64 {
+	score := 100 - r.penalty(ip)
+
+	if r.inNetworks(ip) {
+		score -= r.config.NetworkPenalty
+	}
+
+	if len(r.config.DNSBLs) > 0 {
+		score -= float64(r.listed(ip)) * r.config.DNSBLPenalty
+	}
+	return max(score, 0)
+}
+
+// Penalize lowers the score of the IP by the amount. Penalties decay with the configured half-life.
+func (r *Reputation) Penalize(ip string, amount float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	p := r.penalties[ip]
+	r.penalties[ip] = penalty{value: r.decay(p, now) + amount, updated: now}
+}
+
+// Refused returns the number of connections refused because of low reputation.
+func (r *Reputation) Refused() int64 { return r.refused.Load() }
+
+// RejectConnection is a Reject.Connection hook that refuses the websocket upgrade
+// to IPs whose score is below RefuseBelow.
+func (r *Reputation) RejectConnection(s Stats, req *http.Request) error {
+	if r.Score(IP(req)) < r.config.RefuseBelow {
+		r.refused.Add(1)
+		return ErrLowReputation
+	}
+	return nil
+}
+
+// RateLimit is a Reject.Event hook that rate limits the events of clients whose
+// score is below RestrictBelow.
+func (r *Reputation) RateLimit(ctx context.Context, c Client, e *nostr.Event) error {
+	if r.Score(c.IP()) >= r.config.RestrictBelow {
+		return nil
+	}
+
+	if !r.limiter.Allow(c.IP(), r.config.RestrictedRate, 1) {
+		return fmt.Errorf("%w: your IP has a low reputation", ErrRateLimited)
+	}
+	return nil
+}
+
+// Track wraps the Reject.Event hook, penalizing the client's IP every time it rejects an event.
+func (r *Reputation) Track(reject func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		err := reject(ctx, c, e)
+		if err != nil {
+			r.Penalize(c.IP(), r.config.RejectionPenalty)
+		}
+		return err
+	}
+}
+
+// TrackAll wraps all the Reject.Event hooks with [Reputation.Track].
+func (r *Reputation) TrackAll(rejects []func(context.Context, Client, *nostr.Event) error) []func(context.Context, Client, *nostr.Event) error {
+	tracked := make([]func(context.Context, Client, *nostr.Event) error, len(rejects))
+	for i, reject := range rejects {
+		tracked[i] = r.Track(reject)
+	}
+	return tracked
+}
+
+// Run periodically forgets the IPs whose penalties have decayed and whose
+// DNSBL listings have expired, until the context is cancelled.
+func (r *Reputation) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.HalfLife)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			r.prune(now)
+			r.limiter.Prune(r.config.HalfLife)
+		}
+	}
+}
+
+func (r *Reputation) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for ip, p := range r.penalties {
+		if r.decay(p, now) < 1 {
+			delete(r.penalties, ip)
+		}
+	}
+
+	for ip, l := range r.listings {
+		if now.After(l.expires) {
+			delete(r.listings, ip)
+		}
+	}
+}
+
+func (r *Reputation) penalty(ip string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.decay(r.penalties[ip], time.Now())
+}
+
+// decay returns the value of the penalty at the provided time.
+func (r *Reputation) decay(p penalty, now time.Time) float64 {
+	if p.value == 0 || r.config.HalfLife <= 0 {
+		return p.value
+	}
+
+	halves := now.Sub(p.updated).Seconds() / r.config.HalfLife.Seconds()
+	return p.value * math.Pow(0.5, halves)
+}
+
+func (r *Reputation) inNetworks(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range r.networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// listed returns how many DNSBLs list the IP according to the cache, or 0 if unknown.
+// When the cached listing is missing or expired, the IP is looked up in the background.
+func (r *Reputation) listed(ip string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.listings[ip]
+	if ok && time.Now().Before(l.expires) {
+		return l.count
+	}
+
+	if _, resolving := r.resolving[ip]; !resolving {
+		if reversed, ok := reverseIPv4(ip); ok {
+			r.resolving[ip] = struct{}{}
+			go r.resolve(ip, reversed)
+		}
+	}
+	return l.count
+}
+
+// resolve queries the DNSBLs for the IP, and caches how many list it.
+// Lookup failures other than "not found" are not cached, so that the IP is looked up again
+// later, rather than considered not listed when DNS is down.
+func (r *Reputation) resolve(ip, reversed string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.DNSBLTimeout)
+	defer cancel()
+
+	count := 0
+	failed := false
+	for _, zone := range r.config.DNSBLs {
+		addrs, err := r.lookupHost(ctx, reversed+"."+zone)
+		var dnsErr *net.DNSError
+
+		switch {
+		case err == nil && len(addrs) > 0:
+			count++
+		case err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound):
+			failed = true
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.resolving, ip)
+	if !failed {
+		r.listings[ip] = listing{count: count, expires: time.Now().Add(r.config.DNSBLCacheTTL)}
+	}
+}
+
+// reverseIPv4 returns the octets of the IPv4 in reverse order, as required by DNSBL queries.
+func reverseIPv4(ip string) (string, bool) {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", parsed[3], parsed[2], parsed[1], parsed[0]), true
+}
@@ -0,0 +1,204 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReputationConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ReputationConfig)
+	}{
+		{name: "zero half-life", modify: func(c *ReputationConfig) { c.HalfLife = 0 }},
+		{name: "negative half-life", modify: func(c *ReputationConfig) { c.HalfLife = -1 }},
+		{name: "invalid network", modify: func(c *ReputationConfig) { c.Networks = []string{"10.0.0.0"} }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultReputationConfig()
+			test.modify(&config)
+			if _, err := NewReputation(config); err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		})
+	}
+
+	if _, err := NewReputation(DefaultReputationConfig()); err != nil {
+		t.Fatalf("unexpected error with the default config: %v", err)
+	}
+}
+
+func TestReputationScore(t *testing.T) {
+	config := DefaultReputationConfig()
+	config.Networks = []string{"10.0.0.0/8"}
+
+	r, err := NewReputation(config)
+	if err != nil {
+		t.Fatalf("failed to create the reputation: %v", err)
+	}
+
+	if score := r.Score("1.2.3.4"); score != 100 {
+		t.Fatalf("expected a new IP to score 100, got %f", score)
+	}
+	if score := r.Score("10.1.2.3"); score != 50 {
+		t.Fatalf("expected the IP in the networks to score 50, got %f", score)
+	}
+
+	r.Penalize("1.2.3.4", 30)
+	r.Penalize("1.2.3.4", 30)
+	if score := r.Score("1.2.3.4"); math.Abs(score-40) > 0.01 {
+		t.Fatalf("expected the penalized IP to score 40, got %f", score)
+	}
+
+	r.Penalize("10.1.2.3", 80)
+	if score := r.Score("10.1.2.3"); score != 0 {
+		t.Fatalf("expected the score to be floored at 0, got %f", score)
+	}
+}
+
+func TestReputationDecay(t *testing.T) {
+	r, err := NewReputation(DefaultReputationConfig())
+	if err != nil {
+		t.Fatalf("failed to create the reputation: %v", err)
+	}
+
+	now := time.Now()
+	r.penalties["1.2.3.4"] = penalty{value: 40, updated: now.Add(-time.Hour)}
+	r.penalties["5.6.7.8"] = penalty{value: 40, updated: now.Add(-20 * time.Hour)}
+
+	if score := r.Score("1.2.3.4"); math.Abs(score-80) > 0.01 {
+		t.Fatalf("expected half the penalty to be forgiven after the half-life, got a score of %f", score)
+	}
+
+	r.prune(now)
+	if _, ok := r.penalties["5.6.7.8"]; ok {
+		t.Fatal("expected the decayed penalty to be pruned")
+	}
+	if _, ok := r.penalties["1.2.3.4"]; !ok {
+		t.Fatal("expected the penalty to be kept")
+	}
+}
+
+func TestReputationRateLimit(t *testing.T) {
+	config := DefaultReputationConfig()
+	config.RestrictedRate = 1
+
+	r, err := NewReputation(config)
+	if err != nil {
+		t.Fatalf("failed to create the reputation: %v", err)
+	}
+
+	good := &client{ip: "1.2.3.4"}
+	bad := &client{ip: "5.6.7.8"}
+	r.Penalize(bad.ip, 50)
+
+	for range 5 {
+		if err := r.RateLimit(context.Background(), good, &nostr.Event{}); err != nil {
+			t.Fatalf("expected the good IP not to be rate limited, got %v", err)
+		}
+	}
+
+	if err := r.RateLimit(context.Background(), bad, &nostr.Event{}); err != nil {
+		t.Fatalf("expected the first event of the bad IP to be allowed, got %v", err)
+	}
+	if err := r.RateLimit(context.Background(), bad, &nostr.Event{}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected the bad IP to be rate limited, got %v", err)
+	}
+}
+
+func TestReputationDNSBL(t *testing.T) {
+	tests := []struct {
+		name   string
+		lookup func(context.Context, string) ([]string, error)
+		score  float64
+		cached bool
+	}{
+		{
+			name:   "listed",
+			lookup: func(context.Context, string) ([]string, error) { return []string{"127.0.0.2"}, nil },
+			score:  20,
+			cached: true,
+		},
+		{
+			name: "not listed",
+			lookup: func(_ context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Name: host, IsNotFound: true}
+			},
+			score:  100,
+			cached: true,
+		},
+		{
+			name: "lookup failure",
+			lookup: func(_ context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Name: host, IsTimeout: true}
+			},
+			score:  100,
+			cached: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultReputationConfig()
+			config.DNSBLs = []string{"a.example.com", "b.example.com"}
+
+			r, err := NewReputation(config)
+			if err != nil {
+				t.Fatalf("failed to create the reputation: %v", err)
+			}
+
+			var queries []string
+			release := make(chan struct{})
+			r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				<-release
+				queries = append(queries, host)
+				return test.lookup(ctx, host)
+			}
+
+			// the lookup doesn't block the score, which is unknown until it completes
+			if score := r.Score("1.2.3.4"); score != 100 {
+				t.Fatalf("expected the IP to score 100 before the lookup completes, got %f", score)
+			}
+			r.Score("1.2.3.4") // the lookup is already in progress
+			close(release)
+			waitResolved(t, r, "1.2.3.4")
+
+			if len(queries) != 2 || queries[0] != "4.3.2.1.a.example.com" {
+				t.Fatalf("expected one query per zone of the reversed IP, got %v", queries)
+			}
+			if score := r.Score("1.2.3.4"); score != test.score {
+				t.Fatalf("expected the score %f, got %f", test.score, score)
+			}
+
+			r.mu.Lock()
+			_, cached := r.listings["1.2.3.4"]
+			r.mu.Unlock()
+			if cached != test.cached {
+				t.Fatalf("expected the listing to be cached %v, got %v", test.cached, cached)
+			}
+		})
+	}
+}
+
+// waitResolved waits for the DNSBL lookups of the IP to complete.
+func waitResolved(t *testing.T, r *Reputation, ip string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		_, resolving := r.resolving[ip]
+		r.mu.Unlock()
+
+		if !resolving {
+			return
+		}
+	}
+	t.Fatalf("the DNSBL lookups of %s didn't complete", ip)
+}
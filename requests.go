@@ -1,9 +1,12 @@
 package rely
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/goccy/go-json"
 
@@ -14,13 +17,14 @@ var (
 	ErrGeneric         = errors.New(`the request must be a JSON array`)
 	ErrUnsupportedType = errors.New(`the request type must be one between 'EVENT', 'REQ', 'CLOSE', 'COUNT' and 'AUTH'`)
 
-	ErrInvalidEventRequest   = errors.New(`an EVENT request must follow this format: ['EVENT', {event_JSON}]`)
-	ErrInvalidEventID        = errors.New(`invalid event ID`)
-	ErrInvalidEventSignature = errors.New(`invalid event signature`)
+	ErrInvalidEventRequest   = fmt.Errorf(`%w: an EVENT request must follow this format: ['EVENT', {event_JSON}]`, ErrInvalid)
+	ErrInvalidEventID        = fmt.Errorf(`%w: the event ID doesn't match its content`, ErrInvalid)
+	ErrInvalidEventSignature = fmt.Errorf(`%w: the event signature doesn't match its ID`, ErrInvalid)
+	ErrEventExpired          = fmt.Errorf(`%w: the event has expired`, ErrInvalid)
 
-	ErrInvalidReqRequest     = errors.New(`a REQ request must follow this format: ['REQ', {subscription_id}, {filter1}, {filter2}, ...]`)
-	ErrInvalidCountRequest   = errors.New(`a COUNT request must follow this format: ['COUNT', {subscription_id}, {filter1}, {filter2}, ...]`)
-	ErrInvalidSubscriptionID = errors.New(`invalid subscription ID`)
+	ErrInvalidReqRequest     = fmt.Errorf(`%w: a REQ request must follow this format: ['REQ', {subscription_id}, {filter1}, {filter2}, ...]`, ErrInvalid)
+	ErrInvalidCountRequest   = fmt.Errorf(`%w: a COUNT request must follow this format: ['COUNT', {subscription_id}, {filter1}, {filter2}, ...]`, ErrInvalid)
+	ErrInvalidSubscriptionID = fmt.Errorf(`%w: the subscription ID must be between 1 and 64 characters`, ErrInvalid)
 )
 
 type request interface {
@@ -37,8 +41,11 @@ type request interface {
 }
 
 type eventRequest struct {
-	client *client
-	Event  *nostr.Event
+	client     *client
+	ctx        context.Context // will be cancelled when the client disconnects
+	receivedAt time.Time
+	size       int64 // bytes accounted in the memory budget
+	Event      *nostr.Event
 }
 
 func (e eventRequest) UID() string     { return join(e.client.uid, e.Event.ID) }
@@ -46,11 +53,16 @@ func (e eventRequest) ID() string      { return e.Event.ID }
 func (e eventRequest) IsExpired() bool { return e.client.isUnregistering.Load() }
 
 type reqRequest struct {
-	id  string
-	ctx context.Context // will be cancelled when the subscription is closed
+	id         string
+	ctx        context.Context // will be cancelled when the subscription is closed
+	receivedAt time.Time
 
 	client  *client
 	Filters nostr.Filters
+
+	delivered *atomic.Int64 // shared with the subscription, see [subscription.delivered]
+	followed  *followedSet  // shared with the subscription, see [subscription.followed]
+	archive   bool          // served in archive mode, see [Relay.IsArchiveReq]
 }
 
 func (r reqRequest) UID() string     { return join(r.client.uid, r.id) }
@@ -59,6 +71,7 @@ func (r reqRequest) IsExpired() bool { return r.ctx.Err() != nil || r.client.isU
 
 type countRequest struct {
 	id      string
+	ctx     context.Context // will be cancelled when the client disconnects
 	Filters nostr.Filters
 	client  *client
 }
@@ -131,10 +144,45 @@ func parseLabel(d *json.Decoder) (string, error) {
 	return label, nil
 }
 
-// parseEvent parses the
+// parseEventFast parses a message in the `["EVENT", {...}]` format without the streaming decoder,
+// locating the event object directly and decoding it with its generated unmarshaler.
+// It reports false if the message is not a well-formed EVENT, in which case the caller
+// should fallback to [parseLabel] and [parseEvent], which also produce the proper error.
+func parseEventFast(data []byte) (eventRequest, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '[' || data[len(data)-1] != ']' {
+		return eventRequest{}, false
+	}
+
+	data = bytes.TrimSpace(data[1 : len(data)-1])
+	data, ok := bytes.CutPrefix(data, []byte(`"EVENT"`))
+	if !ok {
+		return eventRequest{}, false
+	}
+
+	data, ok = bytes.CutPrefix(bytes.TrimSpace(data), []byte(","))
+	if !ok {
+		return eventRequest{}, false
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '{' || data[len(data)-1] != '}' {
+		return eventRequest{}, false
+	}
+
+	event := eventRequest{Event: getEvent()}
+	if err := event.Event.UnmarshalJSON(data); err != nil {
+		putEvent(event.Event)
+		return eventRequest{}, false
+	}
+	return event, true
+}
+
+// parseEvent parses the json array into an [eventRequest].
 func parseEvent(d *json.Decoder) (eventRequest, *requestError) {
-	event := eventRequest{Event: new(nostr.Event)}
+	event := eventRequest{Event: getEvent()}
 	if err := d.Decode(event.Event); err != nil {
+		putEvent(event.Event)
 		return eventRequest{}, &requestError{Err: fmt.Errorf("%w: %w", ErrInvalidEventRequest, err)}
 	}
 	return event, nil
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -14,6 +15,27 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 )
 
+func TestDropExpired(t *testing.T) {
+	now := nostr.Now()
+	events := []nostr.Event{
+		{ID: "no expiration"},
+		{ID: "expired", Tags: nostr.Tags{{"expiration", strconv.FormatInt(int64(now)-10, 10)}}},
+		{ID: "not expired", Tags: nostr.Tags{{"expiration", strconv.FormatInt(int64(now)+10, 10)}}},
+		{ID: "invalid expiration", Tags: nostr.Tags{{"expiration", "soon"}}},
+	}
+
+	events = DropExpired(events)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	for _, e := range events {
+		if e.ID == "expired" {
+			t.Fatal("the expired event should have been dropped")
+		}
+	}
+}
+
 func TestApplyBudget(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -189,6 +211,43 @@ func TestParseEvent(t *testing.T) {
 	}
 }
 
+func TestParseEventFast(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{name: "valid", data: string(dataEvent), ok: true},
+		{name: "extra whitespace", data: "\n [ \"EVENT\" ,\t" + string(dataEvent[10:len(dataEvent)-1]) + " ] ", ok: true},
+		{name: "other label", data: `["REQ", {"kinds": [1]}]`},
+		{name: "not an object", data: `["EVENT", "sdada"]`},
+		{name: "extra elements", data: `["EVENT", {"kind":1}, {"kind":2}]`},
+		{name: "unterminated", data: `["EVENT", {"kind":1}`},
+		{name: "invalid field", data: `["EVENT", {"kind":"one"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := parseEventFast([]byte(test.data))
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			d := json.NewDecoder(bytes.NewReader(dataEvent))
+			parseLabel(d)
+			expected, _ := parseEvent(d)
+
+			if !reflect.DeepEqual(event.Event, expected.Event) {
+				t.Fatalf("expected event %v, got %v", expected.Event, event.Event)
+			}
+		})
+	}
+}
+
 func TestParseReq(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -439,11 +498,36 @@ func TestValidateAuth(t *testing.T) {
 			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "example.com"}, {"challenge", "different"}}})},
 			expected: ErrInvalidAuthChallenge,
 		},
+		{
+			name:     "relay tag contains the domain",
+			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"challenge", "challenge"}, {"relay", "wss://evil.com/example.com"}}})},
+			expected: ErrInvalidAuthRelay,
+		},
+		{
+			name:     "relay tag is a subdomain of another domain",
+			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"challenge", "challenge"}, {"relay", "wss://example.com.evil.com"}}})},
+			expected: ErrInvalidAuthRelay,
+		},
+		{
+			name:     "relay tag has an invalid scheme",
+			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"challenge", "challenge"}, {"relay", "https://example.com"}}})},
+			expected: ErrInvalidAuthRelay,
+		},
 		{
 			name:     "valid",
 			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "example.com"}, {"challenge", "challenge"}}})},
 			expected: nil,
 		},
+		{
+			name:     "valid URL with port and path",
+			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "wss://Example.com:443/nostr/"}, {"challenge", "challenge"}}})},
+			expected: nil,
+		},
+		{
+			name:     "valid reverse proxy host",
+			auth:     authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "ws://relay.proxy.net:8080"}, {"challenge", "challenge"}}})},
+			expected: nil,
+		},
 	}
 
 	for _, test := range tests {
@@ -451,6 +535,7 @@ func TestValidateAuth(t *testing.T) {
 			client := &client{relay: &Relay{}}
 			client.challenge = "challenge"
 			client.relay.domain = "example.com"
+			client.relay.hosts = []string{"*.proxy.net"}
 
 			requestErr := client.ValidateAuth(test.auth)
 			var err error
@@ -465,6 +550,31 @@ func TestValidateAuth(t *testing.T) {
 	}
 }
 
+func TestValidateAuthReplay(t *testing.T) {
+	relay := &Relay{}
+	relay.domain = "example.com"
+	relay.authChallengeTTL = time.Minute
+
+	auth := authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "wss://example.com"}, {"challenge", "challenge"}}})}
+
+	first := &client{relay: relay, challenge: "challenge", issuedAt: time.Now()}
+	if err := first.ValidateAuth(auth); err != nil {
+		t.Fatalf("expected the AUTH to be valid, got %v", err.Err)
+	}
+
+	second := &client{relay: relay, challenge: "challenge", issuedAt: time.Now()}
+	if err := second.ValidateAuth(auth); err == nil || !errors.Is(err.Err, ErrAuthReplayed) {
+		t.Fatalf("expected error %v, got %v", ErrAuthReplayed, err)
+	}
+
+	// resending the challenge doesn't extend its expiration
+	expired := &client{relay: relay, challenge: "challenge", issuedAt: time.Now().Add(-2 * time.Minute), challengedAt: time.Now()}
+	auth = authRequest{Event: Signed(nostr.Event{Kind: 22242, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"relay", "wss://example.com"}, {"challenge", "challenge"}}, Content: "new"})}
+	if err := expired.ValidateAuth(auth); err == nil || !errors.Is(err.Err, ErrAuthChallengeExpired) {
+		t.Fatalf("expected error %v, got %v", ErrAuthChallengeExpired, err)
+	}
+}
+
 func BenchmarkCreateChallenge(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		challenge := make([]byte, 16)
@@ -503,6 +613,14 @@ func BenchmarkParseEvent(b *testing.B) {
 	}
 }
 
+func BenchmarkParseEventFast(b *testing.B) {
+	for range b.N {
+		if _, ok := parseEventFast(dataEvent); !ok {
+			b.Fatal("failed to parse event")
+		}
+	}
+}
+
 func BenchmarkParseReq(b *testing.B) {
 	for range b.N {
 		readerReq.Seek(0, io.SeekStart)
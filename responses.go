@@ -41,6 +41,7 @@ func (c closedResponse) MarshalJSON() ([]byte, error) {
 type eventResponse struct {
 	ID    string
 	Event *nostr.Event
+	size  int64 // bytes accounted in the memory budget
 }
 
 func (e eventResponse) MarshalJSON() ([]byte, error) {
@@ -133,3 +134,10 @@ func (c countResponse) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal([]any{"COUNT", c.ID, payload{Count: c.Count, Approx: c.Approx}})
 }
+
+// rawResponse is a message already marshalled, see [Client.SendMessage].
+type rawResponse []byte
+
+func (r rawResponse) MarshalJSON() ([]byte, error) {
+	return r, nil
+}
@@ -0,0 +1,355 @@
+package rely
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+// RuleAction is what the [Router] does with the events matching a [Rule].
+type RuleAction string
+
+const (
+	// RuleForward publishes the event to the relay at the target URL, e.g. a backup or an aggregator.
+	RuleForward RuleAction = "forward"
+
+	// RuleWebhook POSTs the event as JSON to the target URL.
+	RuleWebhook RuleAction = "webhook"
+
+	// RuleDrop rejects the event, with the target as the optional reason sent to the client.
+	RuleDrop RuleAction = "drop"
+
+	// RuleLabel labels the event and its author with the target label, see [Labeler].
+	RuleLabel RuleAction = "label"
+)
+
+// Rule routes the events matching its filter to its action.
+type Rule struct {
+	// Name identifies the rule in the logs.
+	Name string
+
+	// Filter the events must match, typically on kinds, authors and tags.
+	// The empty filter matches all events.
+	Filter nostr.Filter
+
+	Action RuleAction
+	Target string
+}
+
+// validate returns an error if the action or its target is invalid.
+func (r Rule) validate(labeler *Labeler) error {
+	switch r.Action {
+	case RuleForward:
+		if !nostr.IsValidRelayURL(r.Target) {
+			return fmt.Errorf("invalid relay URL %q", r.Target)
+		}
+
+	case RuleWebhook:
+		u, err := url.Parse(r.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q", r.Target)
+		}
+
+	case RuleDrop:
+
+	case RuleLabel:
+		if r.Target == "" {
+			return errors.New("the label is required")
+		}
+		if labeler == nil {
+			return errors.New("labeling requires a labeler")
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q, must be one of forward, webhook, drop or label", r.Action)
+	}
+	return nil
+}
+
+// RouterConfig configures the [Router].
+type RouterConfig struct {
+	Rules []Rule
+
+	// Labeler of the [RuleLabel] actions, required only if any rule labels the events.
+	Labeler *Labeler
+
+	// QueueSize is the number of events waiting to be forwarded or posted. When full, new ones are dropped.
+	QueueSize int
+
+	// Workers is the number of events forwarded or posted concurrently.
+	Workers int
+
+	// Timeout of each forward or webhook.
+	Timeout time.Duration
+
+	// PrivateWebhooks allows the webhooks resolving to loopback, private and other non-public addresses,
+	// e.g. a service next to the relay. By default they are refused, protecting from server-side request forgery.
+	PrivateWebhooks bool
+}
+
+// DefaultRouterConfig returns a [RouterConfig] without rules, with sane defaults.
+func DefaultRouterConfig() RouterConfig {
+	return RouterConfig{
+		QueueSize: 10_000,
+		Workers:   4,
+		Timeout:   10 * time.Second,
+	}
+}
+
+// Router is a rules engine routing the events to actions: forwarding them to other relays,
+// posting them to webhooks, dropping or labeling them. Every rule whose filter matches the event
+// applies, in order, unless a drop rule matches first, which rejects the event.
+// The rules can be replaced at runtime with [Router.SetRules], e.g. when their configuration is reloaded.
+//
+// Example:
+//
+//	router, err := NewRouter(relay, RouterConfig{
+//		Rules: []Rule{
+//			{Name: "backup", Filter: nostr.Filter{Kinds: []int{30023}}, Action: RuleForward, Target: "wss://backup.example.com"},
+//			{Name: "no-dms", Filter: nostr.Filter{Kinds: []int{4}}, Action: RuleDrop, Target: "DMs are not accepted"},
+//		},
+//		QueueSize: 1000,
+//		Workers:   4,
+//		Timeout:   10 * time.Second,
+//	})
+//
+//	relay.Reject.Event = append(relay.Reject.Event, router.RejectEvent)
+//	relay.On.Event = router.Save(relay.On.Event)
+//	go router.Run(ctx)
+type Router struct {
+	relay   *Relay
+	config  RouterConfig
+	rules   atomic.Pointer[[]Rule]
+	queue   chan routed
+	webhook *fetch.Fetcher
+
+	mu    sync.Mutex
+	conns map[string]*nostr.Relay // the connections to the forward targets
+
+	routed  atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// routed is an event waiting to be forwarded or posted by the rule.
+type routed struct {
+	rule  Rule
+	event *nostr.Event
+}
+
+// NewRouter returns a [Router] of the relay, or an error if the config or any of the rules is invalid.
+func NewRouter(relay *Relay, config RouterConfig) (*Router, error) {
+	if config.QueueSize <= 0 || config.Workers <= 0 {
+		return nil, errors.New("the router queue size and workers must be positive")
+	}
+
+	if config.Timeout <= 0 {
+		return nil, errors.New("the router timeout must be positive")
+	}
+
+	// the webhooks receive all the matching events, so their hosts must not be rate limited
+	fetching := fetch.DefaultConfig()
+	fetching.Timeout = config.Timeout
+	fetching.HostRate = 0
+	fetching.AllowPrivate = config.PrivateWebhooks
+
+	r := &Router{
+		relay:   relay,
+		config:  config,
+		queue:   make(chan routed, config.QueueSize),
+		webhook: fetch.New(fetching),
+		conns:   make(map[string]*nostr.Relay),
+	}
+
+	if err := r.SetRules(config.Rules); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetRules replaces the rules of the router, or returns an error leaving them unchanged if any is invalid.
+// It's safe to call at runtime.
+func (r *Router) SetRules(rules []Rule) error {
+	for i, rule := range rules {
+		if err := rule.validate(r.config.Labeler); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i+1, rule.Name, err)
+		}
+	}
+
+	rules = append([]Rule(nil), rules...)
+	r.rules.Store(&rules)
+	return nil
+}
+
+// Rules returns the current rules of the router.
+func (r *Router) Rules() []Rule { return append([]Rule(nil), *r.rules.Load()...) }
+
+// Routed returns the number of events forwarded or posted.
+func (r *Router) Routed() int64 { return r.routed.Load() }
+
+// Dropped returns the number of events rejected by the drop rules.
+func (r *Router) Dropped() int64 { return r.dropped.Load() }
+
+// Failed returns the number of events that failed to be forwarded or posted, or that didn't fit the queue.
+func (r *Router) Failed() int64 { return r.failed.Load() }
+
+// RejectEvent is a Reject.Event hook rejecting the events matching a drop rule.
+func (r *Router) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	for _, rule := range *r.rules.Load() {
+		if rule.Action != RuleDrop || !rule.Filter.Matches(e) {
+			continue
+		}
+
+		r.dropped.Add(1)
+		if rule.Target != "" {
+			return fmt.Errorf("%w: %s", ErrBlocked, rule.Target)
+		}
+		return fmt.Errorf("%w: the event is not accepted by this relay", ErrBlocked)
+	}
+	return nil
+}
+
+// Save wraps the On.Event hook, routing the events it saves successfully to the actions of the matching rules.
+// Forwards and webhooks are queued without blocking, and performed by [Router.Run].
+func (r *Router) Save(save func(context.Context, Client, *nostr.Event) error) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := save(ctx, c, e); err != nil {
+			return err
+		}
+
+		r.route(e)
+		return nil
+	}
+}
+
+// route applies the actions of the rules matching the event.
+func (r *Router) route(e *nostr.Event) {
+	for _, rule := range *r.rules.Load() {
+		if !rule.Filter.Matches(e) {
+			continue
+		}
+
+		switch rule.Action {
+		case RuleLabel:
+			r.config.Labeler.LabelEvent(e, rule.Target, "rule "+rule.Name)
+
+		case RuleForward, RuleWebhook:
+			select {
+			case r.queue <- routed{rule: rule, event: e}:
+			default:
+				r.failed.Add(1)
+				r.relay.log.Warn("router queue is full, dropping event", "rule", rule.Name, "id", e.ID)
+			}
+		}
+	}
+}
+
+// Run forwards and posts the queued events until the context is cancelled.
+func (r *Router) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range r.config.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case rt := <-r.queue:
+					r.deliver(ctx, rt)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for url, conn := range r.conns {
+		conn.Close()
+		delete(r.conns, url)
+	}
+}
+
+// deliver forwards or posts the event of the rule.
+func (r *Router) deliver(ctx context.Context, rt routed) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	var err error
+	switch rt.rule.Action {
+	case RuleForward:
+		err = r.forward(ctx, rt.rule.Target, rt.event)
+	case RuleWebhook:
+		err = r.post(ctx, rt.rule.Target, rt.event)
+	}
+
+	if err != nil {
+		r.failed.Add(1)
+		r.relay.log.Warn("router failed to deliver the event", "rule", rt.rule.Name, "target", rt.rule.Target, "id", rt.event.ID, "error", err)
+		return
+	}
+	r.routed.Add(1)
+}
+
+// forward publishes the event to the relay, reusing its connection. Failed connections are reopened on the next event.
+func (r *Router) forward(ctx context.Context, url string, e *nostr.Event) error {
+	conn, err := r.connection(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Publish(ctx, *e); err != nil {
+		if !conn.IsConnected() {
+			r.mu.Lock()
+			if r.conns[url] == conn {
+				delete(r.conns, url)
+			}
+			r.mu.Unlock()
+			conn.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// connection returns the open connection to the relay, connecting to it if needed.
+func (r *Router) connection(ctx context.Context, url string) (*nostr.Relay, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn, ok := r.conns[url]; ok && conn.IsConnected() {
+		return conn, nil
+	}
+
+	conn, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	r.conns[url] = conn
+	return conn, nil
+}
+
+// post sends the event as JSON to the webhook, which must answer with a 2xx status.
+func (r *Router) post(ctx context.Context, url string, e *nostr.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.webhook.Post(ctx, url, "application/json", bytes.NewReader(body))
+	var status *fetch.StatusError
+	if errors.As(err, &status) && status.Code >= 200 && status.Code <= 299 {
+		return nil
+	}
+	return err
+}
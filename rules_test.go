@@ -0,0 +1,206 @@
+package rely
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNewRouter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{name: "forward", rule: Rule{Action: RuleForward, Target: "wss://relay.example.com"}, valid: true},
+		{name: "forward invalid", rule: Rule{Action: RuleForward, Target: "https://relay.example.com"}, valid: false},
+		{name: "webhook", rule: Rule{Action: RuleWebhook, Target: "https://example.com/hook"}, valid: true},
+		{name: "webhook invalid", rule: Rule{Action: RuleWebhook, Target: "ftp://example.com"}, valid: false},
+		{name: "drop", rule: Rule{Action: RuleDrop}, valid: true},
+		{name: "label without labeler", rule: Rule{Action: RuleLabel, Target: "spam"}, valid: false},
+		{name: "unknown", rule: Rule{Action: "store"}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultRouterConfig()
+			config.Rules = []Rule{test.rule}
+
+			_, err := NewRouter(NewRelay(), config)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestRouterDrop(t *testing.T) {
+	config := DefaultRouterConfig()
+	config.Rules = []Rule{
+		{Name: "dms", Filter: nostr.Filter{Kinds: []int{4}}, Action: RuleDrop, Target: "DMs are not accepted"},
+	}
+
+	router, err := NewRouter(NewRelay(), config)
+	if err != nil {
+		t.Fatalf("failed to create the router: %v", err)
+	}
+
+	err = router.RejectEvent(context.Background(), nil, &nostr.Event{Kind: 4})
+	if !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), "DMs are not accepted") {
+		t.Fatalf("expected the event to be blocked, got %v", err)
+	}
+
+	if err := router.RejectEvent(context.Background(), nil, &nostr.Event{Kind: 1}); err != nil {
+		t.Fatalf("expected the event to be accepted, got %v", err)
+	}
+
+	if router.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", router.Dropped())
+	}
+
+	// replacing the rules
+	if err := router.SetRules(nil); err != nil {
+		t.Fatalf("failed to set the rules: %v", err)
+	}
+	if err := router.RejectEvent(context.Background(), nil, &nostr.Event{Kind: 4}); err != nil {
+		t.Fatalf("expected the event to be accepted without rules, got %v", err)
+	}
+}
+
+func TestRouterWebhook(t *testing.T) {
+	received := make(chan nostr.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e nostr.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- e
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	config := DefaultRouterConfig()
+	config.PrivateWebhooks = true
+	config.Rules = []Rule{
+		{Name: "bitcoin", Filter: nostr.Filter{Kinds: []int{1}, Tags: nostr.TagMap{"t": {"bitcoin"}}}, Action: RuleWebhook, Target: hook.URL},
+	}
+
+	router, err := NewRouter(NewRelay(), config)
+	if err != nil {
+		t.Fatalf("failed to create the router: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	save := router.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+	save(ctx, nil, &nostr.Event{ID: "other", Kind: 1, Tags: nostr.Tags{{"t", "nostr"}}})
+	save(ctx, nil, &nostr.Event{ID: "match", Kind: 1, Tags: nostr.Tags{{"t", "bitcoin"}}})
+
+	select {
+	case e := <-received:
+		if e.ID != "match" {
+			t.Fatalf("expected the matching event, got %s", e.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the webhook was not called")
+	}
+
+	select {
+	case e := <-received:
+		t.Fatalf("unexpected event %s", e.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRouterPrivateWebhook(t *testing.T) {
+	called := make(chan struct{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- struct{}{}
+	}))
+	defer hook.Close()
+
+	config := DefaultRouterConfig()
+	config.Rules = []Rule{{Name: "internal", Action: RuleWebhook, Target: hook.URL}}
+
+	router, err := NewRouter(NewRelay(), config)
+	if err != nil {
+		t.Fatalf("failed to create the router: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	save := router.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+	save(ctx, nil, &nostr.Event{ID: "event", Kind: 1})
+
+	for range 100 {
+		if router.Failed() > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if router.Failed() != 1 || len(called) > 0 {
+		t.Fatalf("expected the webhook on the loopback to be refused, got %d failures", router.Failed())
+	}
+}
+
+func TestRouterForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan string, 10)
+	backup := NewRelay(WithDomain("example.com"))
+	backup.On.Event = func(_ context.Context, _ Client, e *nostr.Event) error {
+		received <- e.ID
+		return nil
+	}
+	backup.Start(ctx)
+
+	server := httptest.NewServer(backup)
+	defer server.Close()
+
+	config := DefaultRouterConfig()
+	config.Rules = []Rule{
+		{Name: "backup", Action: RuleForward, Target: "ws" + strings.TrimPrefix(server.URL, "http")},
+	}
+
+	router, err := NewRouter(NewRelay(), config)
+	if err != nil {
+		t.Fatalf("failed to create the router: %v", err)
+	}
+	go router.Run(ctx)
+
+	event := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "hello"}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	save := router.Save(func(context.Context, Client, *nostr.Event) error { return nil })
+	if err := save(ctx, nil, &event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case id := <-received:
+		if id != event.ID {
+			t.Fatalf("expected the event %s, got %s", event.ID, id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the event was not forwarded")
+	}
+}
@@ -0,0 +1,238 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrTooManySamples = fmt.Errorf("%w: too many samples in progress, please try again later", ErrRateLimited)
+
+// SamplingConfig configures the [Sampling].
+type SamplingConfig struct {
+	// Sample returns a uniform random sample of the events matching the filter, each selected with
+	// the probability rate, up to the limit of the filter. See the Sample method of the ClickHouse storage.
+	Sample func(ctx context.Context, filter nostr.Filter, rate float64) ([]nostr.Event, error)
+
+	// MaxEvents is the maximum number of events of a sample, shared by its filters.
+	MaxEvents int
+
+	// MaxConcurrent is the maximum number of samples computed at once, across all clients.
+	MaxConcurrent int
+
+	// Timeout is the deadline of each sample.
+	Timeout time.Duration
+
+	// RequireAuth rejects the samples of the unauthenticated clients with an "auth-required:" reason.
+	RequireAuth bool
+
+	// Reject is applied to the filters of the samples before they are computed, for example [Relay.RejectReq],
+	// so that the samples enforce the same read policies as the REQs. If nil, the filters are not checked.
+	Reject func(context.Context, Client, nostr.Filters) error
+
+	// Deliver is applied to the sampled events before they are sent, for example the On.Deliver hook of the relay,
+	// so that the samples withhold the same events as the REQs. If nil, all the sampled events are sent.
+	Deliver func(c Client, sample string, e *nostr.Event) bool
+}
+
+// DefaultSamplingConfig returns a [SamplingConfig] with sane defaults, sampling with the function.
+func DefaultSamplingConfig(sample func(context.Context, nostr.Filter, float64) ([]nostr.Event, error)) SamplingConfig {
+	return SamplingConfig{
+		Sample:        sample,
+		MaxEvents:     1000,
+		MaxConcurrent: 4,
+		Timeout:       30 * time.Second,
+	}
+}
+
+// Sampling is an extension of the protocol for analytics clients that want representative data cheaply:
+// like a COUNT, a SAMPLE returns a single message, with a uniform random sample of the matching events
+// instead of their number.
+//
+//	["SAMPLE", <id>, <rate>, <filter1>, <filter2>, ...]
+//
+// where the rate, between 0 and 1, is the probability of each matching event to be in the sample.
+// The relay responds with the sample, capped to the maximum events and to the limits of the filters:
+//
+//	["SAMPLE", <id>, {"rate": <rate>, "events": [<event1>, <event2>, ...]}]
+//
+// or, if the sample is rejected or fails, with ["CLOSED", <id>, <reason>].
+//
+// Example:
+//
+//	config := DefaultSamplingConfig(storage.Sample)
+//	config.Reject = func(ctx context.Context, c Client, filters nostr.Filters) error {
+//		return relay.RejectReq(ctx, c, filters)
+//	}
+//	config.Deliver = func(c Client, sample string, e *nostr.Event) bool {
+//		return relay.On.Deliver == nil || relay.On.Deliver(c, sample, e)
+//	}
+//	sampling, err := NewSampling(config)
+//	relay = NewRelay(WithVerb("SAMPLE", sampling.HandleSample))
+type Sampling struct {
+	config  SamplingConfig
+	running chan struct{}
+
+	served   atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewSampling returns a [Sampling], or an error if the config is invalid.
+func NewSampling(config SamplingConfig) (*Sampling, error) {
+	if config.Sample == nil {
+		return nil, errors.New("the sample function is required")
+	}
+
+	if config.MaxEvents < 1 || config.MaxConcurrent < 1 {
+		return nil, errors.New("the sampling max events and max concurrent must be greater than 1")
+	}
+
+	if config.Timeout <= 0 {
+		return nil, errors.New("the sampling timeout must be positive")
+	}
+	return &Sampling{config: config, running: make(chan struct{}, config.MaxConcurrent)}, nil
+}
+
+// Served returns the number of samples sent to the clients.
+func (s *Sampling) Served() int64 { return s.served.Load() }
+
+// Rejected returns the number of samples rejected because too many were in progress.
+func (s *Sampling) Rejected() int64 { return s.rejected.Load() }
+
+// sampleRequest is a parsed SAMPLE message.
+type sampleRequest struct {
+	ID      string
+	Rate    float64
+	Filters nostr.Filters
+}
+
+// parseSample parses the raw SAMPLE message.
+func parseSample(raw []byte) (sampleRequest, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(raw, &elements); err != nil {
+		return sampleRequest{}, fmt.Errorf("%w: malformed SAMPLE: %v", ErrInvalid, err)
+	}
+
+	if len(elements) < 4 {
+		return sampleRequest{}, fmt.Errorf("%w: SAMPLE must have an id, a rate and at least one filter", ErrInvalid)
+	}
+
+	var request sampleRequest
+	if err := json.Unmarshal(elements[1], &request.ID); err != nil || request.ID == "" {
+		return sampleRequest{}, fmt.Errorf("%w: invalid SAMPLE id", ErrInvalid)
+	}
+
+	if err := json.Unmarshal(elements[2], &request.Rate); err != nil || request.Rate <= 0 || request.Rate > 1 {
+		return request, fmt.Errorf("%w: the rate must be a number in (0, 1]", ErrInvalid)
+	}
+
+	request.Filters = make(nostr.Filters, len(elements)-3)
+	for i, element := range elements[3:] {
+		if err := json.Unmarshal(element, &request.Filters[i]); err != nil {
+			return request, fmt.Errorf("%w: invalid filter: %v", ErrInvalid, err)
+		}
+	}
+	return request, nil
+}
+
+// HandleSample is the handler of the SAMPLE verb, see [WithVerb]. The sample is computed in
+// the background, and sent to the client when ready.
+func (s *Sampling) HandleSample(ctx context.Context, c Client, raw []byte) error {
+	request, err := parseSample(raw)
+	if err != nil {
+		if request.ID == "" {
+			return err
+		}
+		s.close(c, request.ID, err)
+		return nil
+	}
+
+	if s.config.RequireAuth && c.Pubkey() == "" {
+		s.close(c, request.ID, fmt.Errorf("%w: authenticate to request samples", ErrAuthRequired))
+		return nil
+	}
+
+	if s.config.Reject != nil {
+		if err := s.config.Reject(ctx, c, request.Filters); err != nil {
+			s.close(c, request.ID, err)
+			return nil
+		}
+	}
+
+	select {
+	case s.running <- struct{}{}:
+	default:
+		s.rejected.Add(1)
+		s.close(c, request.ID, ErrTooManySamples)
+		return nil
+	}
+
+	// the context of the handler is cancelled when it returns
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.config.Timeout)
+	go func() {
+		defer func() { <-s.running }()
+		defer cancel()
+
+		events, err := s.sample(ctx, request)
+		if err != nil {
+			s.close(c, request.ID, err)
+			return
+		}
+
+		if s.config.Deliver != nil {
+			events = deliverable(s.config.Deliver, c, request.ID, events)
+		}
+
+		type payload struct {
+			Rate   float64       `json:"rate"`
+			Events []nostr.Event `json:"events"`
+		}
+
+		msg, err := json.Marshal([]any{"SAMPLE", request.ID, payload{Rate: request.Rate, Events: events}})
+		if err != nil {
+			s.close(c, request.ID, err)
+			return
+		}
+
+		c.SendMessage(msg)
+		s.served.Add(1)
+	}()
+	return nil
+}
+
+// sample returns the sample of the events matching the filters of the request, without duplicates.
+func (s *Sampling) sample(ctx context.Context, request sampleRequest) ([]nostr.Event, error) {
+	ApplyBudget(s.config.MaxEvents, request.Filters...)
+
+	seen := make(map[string]struct{})
+	events := make([]nostr.Event, 0)
+	for _, filter := range request.Filters {
+		if filter.LimitZero {
+			continue
+		}
+
+		sampled, err := s.config.Sample(ctx, filter, request.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to sample the events: %v", ErrError, err)
+		}
+
+		for _, e := range sampled {
+			if _, ok := seen[e.ID]; !ok {
+				seen[e.ID] = struct{}{}
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+// close sends the CLOSED message of the sample with the reason of the error.
+func (s *Sampling) close(c Client, id string, err error) {
+	msg, _ := json.Marshal([]any{"CLOSED", id, reasonMessage(err)})
+	c.SendMessage(msg)
+}
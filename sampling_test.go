@@ -0,0 +1,196 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		valid bool
+	}{
+		{name: "valid", raw: `["SAMPLE","s",0.1,{"kinds":[1]}]`, valid: true},
+		{name: "without filters", raw: `["SAMPLE","s",0.1]`},
+		{name: "zero rate", raw: `["SAMPLE","s",0,{}]`},
+		{name: "rate above 1", raw: `["SAMPLE","s",1.5,{}]`},
+		{name: "invalid filter", raw: `["SAMPLE","s",0.1,"kinds"]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSample([]byte(test.raw))
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestSampling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var rates []float64
+	sampling, err := NewSampling(DefaultSamplingConfig(func(_ context.Context, filter nostr.Filter, rate float64) ([]nostr.Event, error) {
+		rates = append(rates, rate)
+		return []nostr.Event{{ID: "a", Kind: 1}, {ID: "b", Kind: 1}}, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create the sampling: %v", err)
+	}
+
+	relay := NewRelay(WithDomain("example.com"), WithVerb("SAMPLE", sampling.HandleSample))
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// the two filters sample the same events, which are sent once
+	send(t, conn, []any{"SAMPLE", "s", 0.25, nostr.Filter{Kinds: []int{1}}, nostr.Filter{Kinds: []int{1}}})
+	label, msg := readMessage(t, conn)
+	if label != "SAMPLE" || len(msg) != 3 {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+
+	var sample struct {
+		Rate   float64       `json:"rate"`
+		Events []nostr.Event `json:"events"`
+	}
+	if err := json.Unmarshal(msg[2], &sample); err != nil {
+		t.Fatalf("failed to parse the sample: %v", err)
+	}
+	if sample.Rate != 0.25 || len(sample.Events) != 2 {
+		t.Fatalf("expected the 2 sampled events at 0.25, got %d at %f", len(sample.Events), sample.Rate)
+	}
+	if len(rates) != 2 || rates[0] != 0.25 {
+		t.Fatalf("expected each filter to be sampled at 0.25, got %v", rates)
+	}
+
+	send(t, conn, []any{"SAMPLE", "s", 2, nostr.Filter{}})
+	label, msg = readMessage(t, conn)
+	if label != "CLOSED" || !strings.HasPrefix(string(msg[2]), `"invalid:`) {
+		t.Fatalf("expected the invalid rate to be closed, got %s %s", label, msg)
+	}
+}
+
+func TestSamplingReject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var relay *Relay
+	config := DefaultSamplingConfig(func(context.Context, nostr.Filter, float64) ([]nostr.Event, error) {
+		return []nostr.Event{{ID: "a", Kind: 24133}}, nil
+	})
+	config.Reject = func(ctx context.Context, c Client, filters nostr.Filters) error {
+		return relay.RejectReq(ctx, c, filters)
+	}
+
+	sampling, err := NewSampling(config)
+	if err != nil {
+		t.Fatalf("failed to create the sampling: %v", err)
+	}
+
+	relay = NewRelay(WithDomain("example.com"), WithVerb("SAMPLE", sampling.HandleSample))
+	relay.Reject.Req = append(relay.Reject.Req, UnauthedNostrConnectReq)
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// the NIP-46 messages of any pubkey can't be sampled by an unauthenticated client
+	victim := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	send(t, conn, []any{"SAMPLE", "s", 1, nostr.Filter{Kinds: []int{24133}, Tags: nostr.TagMap{"p": {victim}}}})
+
+	label, msg := readMessage(t, conn)
+	if label == "AUTH" {
+		label, msg = readMessage(t, conn)
+	}
+	if label != "CLOSED" || !strings.HasPrefix(string(msg[2]), `"auth-required:`) {
+		t.Fatalf("expected the restricted sample to be closed, got %s %s", label, msg)
+	}
+}
+
+func TestSamplingDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the filter without kinds passes the Reject.Req hook, but the gift wrap must be withheld
+	wraps := NewGiftWraps(DefaultGiftWrapConfig())
+	victim := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+
+	var relay *Relay
+	config := DefaultSamplingConfig(func(context.Context, nostr.Filter, float64) ([]nostr.Event, error) {
+		return []nostr.Event{{ID: "a", Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", victim}}}, {ID: "b", Kind: 1}}, nil
+	})
+	config.Reject = func(ctx context.Context, c Client, filters nostr.Filters) error {
+		return relay.RejectReq(ctx, c, filters)
+	}
+	config.Deliver = func(c Client, sample string, e *nostr.Event) bool {
+		return relay.On.Deliver == nil || relay.On.Deliver(c, sample, e)
+	}
+
+	sampling, err := NewSampling(config)
+	if err != nil {
+		t.Fatalf("failed to create the sampling: %v", err)
+	}
+
+	relay = NewRelay(WithDomain("example.com"), WithVerb("SAMPLE", sampling.HandleSample))
+	relay.Reject.Req = append(relay.Reject.Req, wraps.RejectReq)
+	relay.On.Deliver = ChainDeliver(relay.On.Deliver, wraps.Deliver)
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	send(t, conn, []any{"SAMPLE", "s", 1, nostr.Filter{Tags: nostr.TagMap{"p": {victim}}}})
+	label, msg := readMessage(t, conn)
+	if label == "AUTH" {
+		label, msg = readMessage(t, conn)
+	}
+	if label != "SAMPLE" || len(msg) != 3 {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+
+	var sample struct {
+		Events []nostr.Event `json:"events"`
+	}
+	if err := json.Unmarshal(msg[2], &sample); err != nil {
+		t.Fatalf("failed to unmarshal the sample: %v", err)
+	}
+
+	if len(sample.Events) != 1 || sample.Events[0].ID != "b" {
+		t.Fatalf("expected only the note to be sampled, got %v", sample.Events)
+	}
+}
@@ -0,0 +1,117 @@
+package rely
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	maxScopeKinds   = 100
+	maxScopeAuthors = 500
+)
+
+var (
+	ErrInvalidScope = fmt.Errorf("%w: the connection scope must be comma-separated kinds and hex pubkeys", ErrInvalid)
+	ErrOutOfScope   = fmt.Errorf("%w: outside the scope of the connection", ErrRestricted)
+)
+
+// scope constrains all the requests of a connection to some kinds and authors, specified by the client
+// with the query parameters of the connection URL:
+//
+//	wss://relay.example.com/?kinds=1,7&authors=<pubkey>,<pubkey>
+//
+// The filters without kinds or authors are narrowed to those of the scope, while the filters and events
+// outside of it are rejected with [ErrOutOfScope]. To enable it, use [WithConnectionScopes].
+type scope struct {
+	kinds   []int
+	authors []string
+}
+
+// parseScope returns the scope of the query parameters, or nil if they specify none.
+func parseScope(query url.Values) (*scope, error) {
+	s := &scope{}
+	if param := query.Get("kinds"); param != "" {
+		for _, k := range strings.Split(param, ",") {
+			kind, err := strconv.Atoi(strings.TrimSpace(k))
+			if err != nil || kind < 0 || kind > 65535 {
+				return nil, fmt.Errorf("%w: invalid kind %q", ErrInvalidScope, k)
+			}
+			s.kinds = append(s.kinds, kind)
+		}
+	}
+
+	if param := query.Get("authors"); param != "" {
+		for _, pk := range strings.Split(param, ",") {
+			pk = strings.TrimSpace(pk)
+			if !nostr.IsValidPublicKey(pk) {
+				return nil, fmt.Errorf("%w: invalid author %q", ErrInvalidScope, pk)
+			}
+			s.authors = append(s.authors, pk)
+		}
+	}
+
+	if len(s.kinds) > maxScopeKinds || len(s.authors) > maxScopeAuthors {
+		return nil, fmt.Errorf("%w: at most %d kinds and %d authors", ErrInvalidScope, maxScopeKinds, maxScopeAuthors)
+	}
+
+	if len(s.kinds) == 0 && len(s.authors) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// checkEvent returns an error if the event is outside of the scope.
+func (s *scope) checkEvent(e *nostr.Event) error {
+	if len(s.kinds) > 0 && !slices.Contains(s.kinds, e.Kind) {
+		return fmt.Errorf("%w: kinds %s", ErrOutOfScope, joinInts(s.kinds))
+	}
+
+	if len(s.authors) > 0 && !slices.Contains(s.authors, e.PubKey) {
+		return fmt.Errorf("%w: the author is not allowed", ErrOutOfScope)
+	}
+	return nil
+}
+
+// narrow restricts the filters without kinds or authors to those of the scope,
+// returning an error if any filter asks for kinds or authors outside of it.
+func (s *scope) narrow(filters nostr.Filters) error {
+	for i := range filters {
+		if len(s.kinds) > 0 {
+			if len(filters[i].Kinds) == 0 {
+				filters[i].Kinds = slices.Clone(s.kinds)
+			}
+
+			for _, kind := range filters[i].Kinds {
+				if !slices.Contains(s.kinds, kind) {
+					return fmt.Errorf("%w: kinds %s", ErrOutOfScope, joinInts(s.kinds))
+				}
+			}
+		}
+
+		if len(s.authors) > 0 {
+			if len(filters[i].Authors) == 0 {
+				filters[i].Authors = slices.Clone(s.authors)
+			}
+
+			for _, author := range filters[i].Authors {
+				if !slices.Contains(s.authors, author) {
+					return fmt.Errorf("%w: the author %s is not allowed", ErrOutOfScope, author)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
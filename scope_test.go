@@ -0,0 +1,118 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		query string
+		scope *scope
+		err   error
+	}{
+		{query: "", scope: nil},
+		{query: "token=abc", scope: nil},
+		{query: "kinds=1,7", scope: &scope{kinds: []int{1, 7}}},
+		{query: "authors=" + pk, scope: &scope{authors: []string{pk}}},
+		{query: "kinds=1&authors=" + pk, scope: &scope{kinds: []int{1}, authors: []string{pk}}},
+		{query: "kinds=one", err: ErrInvalidScope},
+		{query: "kinds=70000", err: ErrInvalidScope},
+		{query: "authors=abc", err: ErrInvalidScope},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		scope, err := parseScope(query)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: expected error %v, got %v", test.query, test.err, err)
+		}
+		if !reflect.DeepEqual(scope, test.scope) {
+			t.Errorf("%q: expected scope %v, got %v", test.query, test.scope, scope)
+		}
+	}
+}
+
+func TestScopeNarrow(t *testing.T) {
+	s := &scope{kinds: []int{1, 7}, authors: []string{pk}}
+
+	filters := nostr.Filters{{}, {Kinds: []int{7}, Limit: 10}}
+	if err := s.narrow(filters); err != nil {
+		t.Fatalf("expected the filters to be narrowed, got %v", err)
+	}
+
+	expected := nostr.Filters{
+		{Kinds: []int{1, 7}, Authors: []string{pk}},
+		{Kinds: []int{7}, Authors: []string{pk}, Limit: 10},
+	}
+	if !reflect.DeepEqual(filters, expected) {
+		t.Errorf("expected %v, got %v", expected, filters)
+	}
+
+	if err := s.narrow(nostr.Filters{{Kinds: []int{1, 4}}}); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected %v for a kind outside the scope, got %v", ErrOutOfScope, err)
+	}
+
+	if err := s.narrow(nostr.Filters{{Authors: []string{strings.Repeat("b", 64)}}}); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected %v for an author outside the scope, got %v", ErrOutOfScope, err)
+	}
+
+	if err := s.checkEvent(&nostr.Event{Kind: 1, PubKey: pk}); err != nil {
+		t.Errorf("expected the event to be in scope, got %v", err)
+	}
+
+	if err := s.checkEvent(&nostr.Event{Kind: 4, PubKey: pk}); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("expected %v for an event outside the scope, got %v", ErrOutOfScope, err)
+	}
+}
+
+func TestConnectionScopes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan nostr.Filters, 1)
+	relay := NewRelay(WithConnectionScopes())
+	relay.On.Req = func(_ context.Context, _ Client, filters nostr.Filters) ([]nostr.Event, error) {
+		received <- filters
+		return nil, nil
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, response, err := ws.DefaultDialer.DialContext(ctx, url+"?kinds=abc", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the invalid scope to be refused with 400, got %v", err)
+	}
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url+"?kinds=1,7", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "dms", Filters: nostr.Filters{{Kinds: []int{4}}}})
+	if label, msg := readMessage(t, conn); label != "CLOSED" || !strings.Contains(string(msg[2]), "restricted:") {
+		t.Fatalf("expected the REQ outside the scope to be closed, got %s %s", label, msg)
+	}
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "feed", Filters: nostr.Filters{{Limit: 10}}})
+	if label, _ := readMessage(t, conn); label != "EOSE" {
+		t.Fatalf("expected the REQ to be accepted, got %s", label)
+	}
+
+	if filters := <-received; !reflect.DeepEqual(filters[0].Kinds, []int{1, 7}) {
+		t.Errorf("expected the filter to be narrowed to the kinds of the scope, got %v", filters)
+	}
+}
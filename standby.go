@@ -0,0 +1,406 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrStandby = fmt.Errorf("%w: this relay is a standby replica, please publish to the primary", ErrRestricted)
+
+// StandbyConfig configures the [Standby].
+type StandbyConfig struct {
+	// Primary is the websocket URL of the primary relay, whose events are replicated.
+	Primary string
+
+	// Save stores a replicated event, typically the SaveEvent method of the storage.
+	Save func(context.Context, *nostr.Event) error
+
+	// Since is when the replication starts, typically the creation time of the newest stored event.
+	// It is clamped to the current time, so that a future-dated event can't skip the events still to be replicated.
+	Since nostr.Timestamp
+
+	// Overlap is how long before the last replicated event the replication resumes after reconnecting,
+	// so that the events the primary received out of order are not missed. They are saved again.
+	Overlap time.Duration
+
+	// PageSize is the number of events of each page of the backfill of the missed events.
+	// It must not exceed the number of events the primary returns to a REQ.
+	PageSize int
+
+	// RetryInterval is how long to wait before reconnecting to the primary.
+	RetryInterval time.Duration
+
+	// HealthURL is the HTTP URL of the health check of the primary, e.g. its /ready endpoint.
+	// If the primary fails it for FailoverAfter, checked every CheckInterval, the standby is promoted.
+	// Empty disables the automatic failover, leaving only the manual promotion.
+	HealthURL     string
+	FailoverAfter time.Duration
+	CheckInterval time.Duration
+}
+
+// DefaultStandbyConfig returns a [StandbyConfig] with sane defaults, replicating the primary into the save.
+func DefaultStandbyConfig(primary string, save func(context.Context, *nostr.Event) error) StandbyConfig {
+	return StandbyConfig{
+		Primary:       primary,
+		Save:          save,
+		Overlap:       time.Minute,
+		PageSize:      500,
+		RetryInterval: 5 * time.Second,
+		FailoverAfter: 30 * time.Second,
+		CheckInterval: 5 * time.Second,
+	}
+}
+
+// Standby makes the relay the hot standby of a primary relay, for operators needing high availability
+// without a full cluster. The standby replicates the events of the primary with a REQ matching all events:
+// it first backfills those it missed since the last replicated one, page by page, and then streams the new ones,
+// which are saved and broadcast to its subscriptions. Meanwhile, it serves the REQs but rejects the EVENTs
+// with [ErrStandby], so the clients publish to the primary.
+//
+// The standby is promoted manually with [Standby.Promote], or when the primary fails its health check for long
+// enough. Once promoted, it stops replicating and accepts the EVENTs, so that the clients can be routed to it.
+// If the primary requires authentication, the standby authenticates with the identity of the relay (see [WithIdentity]),
+// whose pubkey should be allowed to subscribe to all events of the primary.
+//
+// Example:
+//
+//	standby, err := NewStandby(relay, DefaultStandbyConfig("wss://primary.example.com", save))
+//	relay.Reject.Event = append(relay.Reject.Event, standby.RejectEvent)
+//	go standby.Run(ctx)
+type Standby struct {
+	relay  *Relay
+	config StandbyConfig
+
+	last       atomic.Int64 // the creation time of the newest replicated event
+	promoted   atomic.Bool
+	promotion  chan struct{}
+	replicated atomic.Int64
+	failed     atomic.Int64
+}
+
+// NewStandby returns a [Standby] of the relay, or an error if the config is invalid.
+func NewStandby(relay *Relay, config StandbyConfig) (*Standby, error) {
+	if relay == nil {
+		return nil, errors.New("relay must not be nil")
+	}
+
+	if !strings.HasPrefix(config.Primary, "ws://") && !strings.HasPrefix(config.Primary, "wss://") {
+		return nil, fmt.Errorf("the primary must be a websocket URL, got %q", config.Primary)
+	}
+
+	if config.Save == nil {
+		return nil, errors.New("the save function is required")
+	}
+
+	if config.Overlap < 0 {
+		return nil, errors.New("the overlap must not be negative")
+	}
+
+	if config.PageSize < 1 || config.RetryInterval <= 0 {
+		return nil, errors.New("the page size and the retry interval must be positive")
+	}
+
+	if config.HealthURL != "" && (config.FailoverAfter <= 0 || config.CheckInterval <= 0) {
+		return nil, errors.New("the failover delay and the check interval must be positive")
+	}
+
+	s := &Standby{relay: relay, config: config, promotion: make(chan struct{})}
+	s.last.Store(int64(min(config.Since, nostr.Now())))
+	return s, nil
+}
+
+// Promoted reports whether the standby has been promoted.
+func (s *Standby) Promoted() bool { return s.promoted.Load() }
+
+// Replicated returns the number of events replicated from the primary.
+func (s *Standby) Replicated() int64 { return s.replicated.Load() }
+
+// Failed returns the number of replicated events that couldn't be saved.
+func (s *Standby) Failed() int64 { return s.failed.Load() }
+
+// Last returns the creation time of the newest replicated event, a lower bound of the lag behind the primary.
+// The events created in the future count as created now.
+func (s *Standby) Last() nostr.Timestamp { return nostr.Timestamp(s.last.Load()) }
+
+// Promote stops the replication and makes the relay accept the EVENTs. It returns false if it was already promoted.
+func (s *Standby) Promote() bool {
+	if !s.promoted.CompareAndSwap(false, true) {
+		return false
+	}
+
+	close(s.promotion)
+	s.relay.log.Warn("standby promoted: the relay now accepts the events", "primary", s.config.Primary, "last", s.Last())
+	return true
+}
+
+// RejectEvent is a Reject.Event hook rejecting the events with [ErrStandby] until the standby is promoted.
+func (s *Standby) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if !s.promoted.Load() {
+		return ErrStandby
+	}
+	return nil
+}
+
+// Run replicates the primary, reconnecting when the connection fails, and checks its health
+// if the automatic failover is enabled, until the standby is promoted or the context is cancelled.
+func (s *Standby) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.promotion:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if s.config.HealthURL != "" {
+		go s.watch(ctx)
+	}
+
+	for {
+		err := s.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.relay.log.Warn("standby replication interrupted", "primary", s.config.Primary, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.config.RetryInterval):
+		}
+	}
+}
+
+// replicate connects to the primary, backfills the events missed since the last replicated one
+// and then saves the new ones as they arrive, until the connection fails.
+func (s *Standby) replicate(ctx context.Context) error {
+	conn, err := nostr.RelayConnect(ctx, s.config.Primary)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	now := nostr.Now()
+	since := max(s.Last()-nostr.Timestamp(s.config.Overlap.Seconds()), 0)
+	if err := s.backfill(ctx, conn, since, now); err != nil {
+		return fmt.Errorf("failed to backfill: %w", err)
+	}
+
+	sub, stored, err := s.request(ctx, conn, nostr.Filter{Since: &now})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+
+	for _, e := range stored {
+		s.save(ctx, e, true)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-conn.Context().Done():
+			return errors.New("the connection to the primary was closed")
+
+		case reason := <-sub.ClosedReason:
+			return fmt.Errorf("the primary closed the subscription: %s", reason)
+
+		case e, ok := <-sub.Events:
+			if !ok {
+				return errors.New("the subscription to the primary ended")
+			}
+			s.save(ctx, e, true)
+		}
+	}
+}
+
+// backfill saves the events of the primary created between since and until, page by page from the newest.
+func (s *Standby) backfill(ctx context.Context, conn *nostr.Relay, since, until nostr.Timestamp) error {
+	for until >= since {
+		sub, events, err := s.request(ctx, conn, nostr.Filter{Since: &since, Until: &until, Limit: s.config.PageSize})
+		if err != nil {
+			return err
+		}
+		sub.Unsub()
+
+		oldest := until
+		for _, e := range events {
+			s.save(ctx, e, false)
+			oldest = min(oldest, e.CreatedAt)
+		}
+
+		if len(events) < s.config.PageSize {
+			return nil
+		}
+
+		// the events of the oldest second may not fit the page, so they are requested again,
+		// unless the whole page is of that second
+		if oldest == until {
+			oldest--
+		}
+		until = oldest
+	}
+	return nil
+}
+
+// request subscribes to the filter and returns the subscription with the stored events, authenticating
+// with the identity of the relay and subscribing again if the primary requires it.
+func (s *Standby) request(ctx context.Context, conn *nostr.Relay, filter nostr.Filter) (*nostr.Subscription, []*nostr.Event, error) {
+	authenticated := false
+	for {
+		sub, err := conn.Subscribe(ctx, nostr.Filters{filter})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+
+		stored, reason, err := s.stored(ctx, sub)
+		switch {
+		case err != nil:
+			sub.Unsub()
+			return nil, nil, err
+
+		case reason == "":
+			return sub, stored, nil
+
+		case strings.HasPrefix(reason, ErrAuthRequired.Error()) && !authenticated && s.relay.Identity() != nil:
+			if err := conn.Auth(ctx, s.relay.Identity().Sign); err != nil {
+				return nil, nil, fmt.Errorf("failed to authenticate: %w", err)
+			}
+			authenticated = true
+
+		default:
+			return nil, nil, fmt.Errorf("the primary closed the subscription: %s", reason)
+		}
+	}
+}
+
+// stored returns the stored events of the subscription, or the reason of the primary for closing it.
+func (s *Standby) stored(ctx context.Context, sub *nostr.Subscription) ([]*nostr.Event, string, error) {
+	var events []*nostr.Event
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+
+		case <-sub.EndOfStoredEvents:
+			// the events are dispatched before the EOSE, but may still be in the channel
+			for {
+				select {
+				case e, ok := <-sub.Events:
+					if !ok {
+						return events, "", nil
+					}
+					events = append(events, e)
+				default:
+					return events, "", nil
+				}
+			}
+
+		case reason := <-sub.ClosedReason:
+			return nil, reason, nil
+
+		case e, ok := <-sub.Events:
+			if !ok {
+				return nil, "", errors.New("the subscription to the primary ended")
+			}
+			events = append(events, e)
+		}
+	}
+}
+
+// save verifies and saves the replicated event, and broadcasts the live ones to the subscriptions of the relay.
+// The backfilled events are not broadcast, since they are old and may have been replicated already.
+func (s *Standby) save(ctx context.Context, e *nostr.Event, live bool) {
+	if !e.CheckID() {
+		s.failed.Add(1)
+		return
+	}
+
+	if ok, err := e.CheckSignature(); !ok || err != nil {
+		s.failed.Add(1)
+		return
+	}
+
+	if err := s.config.Save(ctx, e); err != nil {
+		if !errors.Is(err, ErrDuplicate) {
+			s.failed.Add(1)
+			s.relay.log.Warn("standby failed to save the event", "id", e.ID, "error", err)
+		}
+		return
+	}
+
+	// the future-dated events must not advance the watermark past the events still to be replicated
+	created := int64(min(e.CreatedAt, nostr.Now()))
+
+	s.replicated.Add(1)
+	for {
+		last := s.last.Load()
+		if created <= last || s.last.CompareAndSwap(last, created) {
+			break
+		}
+	}
+
+	if live {
+		s.relay.Broadcast(e)
+	}
+}
+
+// watch checks the health of the primary every interval, promoting the standby once it's been
+// failing for long enough, until the context is cancelled.
+func (s *Standby) watch(ctx context.Context) {
+	ticker := time.NewTicker(s.config.CheckInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: s.config.CheckInterval}
+	var failing time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if s.healthy(ctx, client) {
+				failing = time.Time{}
+				continue
+			}
+
+			if failing.IsZero() {
+				failing = time.Now()
+				s.relay.log.Warn("the primary failed its health check", "url", s.config.HealthURL)
+			}
+
+			if time.Since(failing) >= s.config.FailoverAfter {
+				s.Promote()
+				return
+			}
+		}
+	}
+}
+
+// healthy reports whether the health check of the primary succeeds.
+func (s *Standby) healthy(ctx context.Context, client *http.Client) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.HealthURL, nil)
+	if err != nil {
+		return false
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
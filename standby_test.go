@@ -0,0 +1,169 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signedNote returns a note signed by a new key, created at the time.
+func signedNote(t *testing.T, createdAt nostr.Timestamp) nostr.Event {
+	e := nostr.Event{Kind: 1, CreatedAt: createdAt, Content: "hello"}
+	if err := e.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatalf("failed to sign the event: %v", err)
+	}
+	return e
+}
+
+func TestStandby(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stored := signedNote(t, nostr.Now()-10)
+	primary := NewRelay(WithDomain("example.com"))
+	primary.On.Req = memoryQuery(stored)
+	primary.Start(ctx)
+
+	server := httptest.NewServer(primary)
+	defer server.Close()
+
+	saved := make(chan string, 10)
+	save := func(_ context.Context, e *nostr.Event) error {
+		saved <- e.ID
+		return nil
+	}
+
+	relay := NewRelay(WithDomain("example.com"))
+	standby, err := NewStandby(relay, DefaultStandbyConfig("ws"+strings.TrimPrefix(server.URL, "http"), save))
+	if err != nil {
+		t.Fatalf("failed to create the standby: %v", err)
+	}
+	go standby.Run(ctx)
+
+	expect := func(id string) {
+		t.Helper()
+		select {
+		case got := <-saved:
+			if got != id {
+				t.Fatalf("expected the event %s to be replicated, got %s", id, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the event %s was not replicated", id)
+		}
+	}
+
+	// the stored event is backfilled, then the new ones are streamed
+	expect(stored.ID)
+
+	// the live subscription is opened after the backfill, so the event is broadcast until it's replicated
+	live := signedNote(t, nostr.Now())
+	replicated := false
+	for deadline := time.Now().Add(2 * time.Second); !replicated && time.Now().Before(deadline); {
+		primary.Broadcast(&live)
+		select {
+		case id := <-saved:
+			if id != live.ID {
+				t.Fatalf("expected the event %s to be replicated, got %s", live.ID, id)
+			}
+			replicated = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if !replicated {
+		t.Fatal("the live event was not replicated")
+	}
+
+	if err := standby.RejectEvent(ctx, nil, &live); !errors.Is(err, ErrStandby) {
+		t.Fatalf("expected the events to be rejected before the promotion, got %v", err)
+	}
+
+	if !standby.Promote() || standby.Promote() {
+		t.Fatal("expected the standby to be promoted once")
+	}
+	if err := standby.RejectEvent(ctx, nil, &live); err != nil {
+		t.Fatalf("expected the events to be accepted after the promotion, got %v", err)
+	}
+}
+
+func TestStandbyFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer health.Close()
+
+	config := DefaultStandbyConfig("ws://127.0.0.1:1", func(context.Context, *nostr.Event) error { return nil })
+	config.HealthURL = health.URL
+	config.CheckInterval = 10 * time.Millisecond
+	config.FailoverAfter = 50 * time.Millisecond
+
+	standby, err := NewStandby(NewRelay(WithDomain("example.com")), config)
+	if err != nil {
+		t.Fatalf("failed to create the standby: %v", err)
+	}
+	go standby.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !standby.Promoted() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !standby.Promoted() {
+		t.Fatal("expected the standby to be promoted when the primary is unhealthy")
+	}
+}
+
+func TestStandbyInvalidID(t *testing.T) {
+	standby, err := NewStandby(NewRelay(WithDomain("example.com")), DefaultStandbyConfig("ws://primary.example.com", func(context.Context, *nostr.Event) error {
+		t.Fatal("the event with an invalid ID must not be saved")
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create the standby: %v", err)
+	}
+
+	e := signedNote(t, nostr.Now())
+	e.ID = strings.Repeat("0", 64) // the signature of the content is still valid
+	standby.save(context.Background(), &e, true)
+
+	if standby.Failed() != 1 {
+		t.Fatalf("expected 1 failed event, got %d", standby.Failed())
+	}
+}
+
+func TestStandbyFutureEvent(t *testing.T) {
+	standby, err := NewStandby(NewRelay(WithDomain("example.com")), DefaultStandbyConfig("ws://primary.example.com", func(context.Context, *nostr.Event) error {
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create the standby: %v", err)
+	}
+
+	now := nostr.Now()
+	e := signedNote(t, now+365*24*3600)
+	standby.save(context.Background(), &e, false)
+
+	if last := standby.Last(); last < now || last > nostr.Now() {
+		t.Fatalf("expected the watermark to be clamped to the current time %d, got %d", now, last)
+	}
+
+	config := DefaultStandbyConfig("ws://primary.example.com", func(context.Context, *nostr.Event) error { return nil })
+	config.Since = now + 365*24*3600
+	standby, err = NewStandby(NewRelay(WithDomain("example.com")), config)
+	if err != nil {
+		t.Fatalf("failed to create the standby: %v", err)
+	}
+
+	if last := standby.Last(); last > nostr.Now() {
+		t.Fatalf("expected the initial watermark to be clamped to the current time, got %d", last)
+	}
+}
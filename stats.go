@@ -2,6 +2,7 @@ package rely
 
 import (
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -28,6 +29,35 @@ type Stats interface {
 
 	// TotalConnections returns the total number of connections since the relay startup.
 	TotalConnections() int
+
+	// Latencies returns the latency percentiles of the recent relay operations.
+	Latencies() Latencies
+}
+
+// Latencies groups the latency distributions of the relay operations.
+type Latencies struct {
+	// Event is the time from receiving an EVENT to sending the corresponding OK.
+	Event Latency
+
+	// ReqToEOSE is the time from receiving a REQ to sending the corresponding EOSE.
+	ReqToEOSE Latency
+
+	// OnEvent, OnReq and OnCount are the durations of the corresponding [OnHooks],
+	// which typically are the storage calls.
+	OnEvent Latency
+	OnReq   Latency
+	OnCount Latency
+}
+
+// Latency summarizes a distribution of durations with its percentiles.
+// Count is the number of durations since startup, while the percentiles are of the last one to two minutes,
+// so that they reflect the current latency. Percentiles are approximated by the upper bound of the histogram
+// bucket they fall in.
+type Latency struct {
+	Count int64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
 }
 
 type stats struct {
@@ -37,6 +67,23 @@ type stats struct {
 
 	nextClient           atomic.Int64
 	lastRegistrationFail atomic.Int64
+
+	// accounting of the memory budget, see [WithMemoryBudget]
+	bufferedBytes atomic.Int64
+	shedEvents    atomic.Int64
+	shedReqs      atomic.Int64
+
+	// NOTICEs not sent to the clients, see [WithNoticeLimits]
+	suppressedNotices atomic.Int64
+
+	eventLatency   histogram
+	reqLatency     histogram
+	onEventLatency histogram
+	onReqLatency   histogram
+	onCountLatency histogram
+
+	// the round-trip times of the clients by country, see [Relay.RTTs]
+	rtts sync.Map // string → *histogram
 }
 
 func (r *Relay) Clients() int          { return int(r.stats.clients.Load()) }
@@ -51,4 +98,189 @@ func (r *Relay) LastRegistrationFail() time.Time {
 	return time.Unix(r.stats.lastRegistrationFail.Load(), 0)
 }
 
+func (r *Relay) Latencies() Latencies {
+	return Latencies{
+		Event:     r.stats.eventLatency.Summary(),
+		ReqToEOSE: r.stats.reqLatency.Summary(),
+		OnEvent:   r.stats.onEventLatency.Summary(),
+		OnReq:     r.stats.onReqLatency.Summary(),
+		OnCount:   r.stats.onCountLatency.Summary(),
+	}
+}
+
+// RTTs returns the round-trip times of the client connections, by the country of their IP,
+// measured with the websocket pings (see [WithPingPeriod]). Compared across countries, they help deciding
+// where to add relay instances. The clients of unknown country, or all of them if the relay has no [GeoIP],
+// are grouped under the empty country.
+func (r *Relay) RTTs() map[string]Latency {
+	rtts := make(map[string]Latency)
+	r.stats.rtts.Range(func(country, h any) bool {
+		rtts[country.(string)] = h.(*histogram).Summary()
+		return true
+	})
+	return rtts
+}
+
+func (r *Relay) observeRTT(country string, rtt time.Duration) {
+	h, ok := r.stats.rtts.Load(country)
+	if !ok {
+		h, _ = r.stats.rtts.LoadOrStore(country, &histogram{})
+	}
+	h.(*histogram).Observe(rtt)
+}
+
 func (r *Relay) assignID() string { return strconv.FormatInt(r.stats.nextClient.Add(1), 10) }
+
+const (
+	histogramBuckets = 24
+	histogramBase    = 50 * time.Microsecond
+	histogramWindow  = time.Minute
+)
+
+// histogram is a histogram of durations with exponential buckets, over sliding windows.
+// The upper bound of bucket i is histogramBase * 2^i, and the last bucket holds everything above.
+// With the chosen constants, buckets range from 50µs to ~7 minutes.
+// The durations are counted in the current window, and the summary merges it with the previous one,
+// so that the old durations are forgotten after one to two windows.
+type histogram struct {
+	total atomic.Int64 // the durations observed since startup
+
+	mu       sync.Mutex
+	window   int64 // the index of the current window since the unix epoch
+	current  [histogramBuckets]int64
+	previous [histogramBuckets]int64
+}
+
+// Observe adds the duration to the histogram.
+func (h *histogram) Observe(d time.Duration) {
+	h.observe(time.Now(), d)
+}
+
+func (h *histogram) observe(now time.Time, d time.Duration) {
+	i := 0
+	for bound := histogramBase; d > bound && i < histogramBuckets-1; bound *= 2 {
+		i++
+	}
+
+	h.total.Add(1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(now)
+	h.current[i]++
+}
+
+// rotate moves to the window of now, forgetting the windows that are over. It must be called holding the mu.
+func (h *histogram) rotate(now time.Time) {
+	window := now.UnixNano() / int64(histogramWindow)
+	switch window {
+	case h.window:
+		return
+
+	case h.window + 1:
+		h.previous = h.current
+
+	default:
+		h.previous = [histogramBuckets]int64{}
+	}
+
+	h.current = [histogramBuckets]int64{}
+	h.window = window
+}
+
+// Summary returns the count and the approximate percentiles of the observed durations.
+func (h *histogram) Summary() Latency {
+	return h.summary(time.Now())
+}
+
+func (h *histogram) summary(now time.Time) Latency {
+	var counts [histogramBuckets]int64
+	var total int64
+
+	h.mu.Lock()
+	h.rotate(now)
+	for i := range counts {
+		counts[i] = h.current[i] + h.previous[i]
+		total += counts[i]
+	}
+	h.mu.Unlock()
+
+	return Latency{
+		Count: h.total.Load(),
+		P50:   quantile(counts, total, 0.50),
+		P95:   quantile(counts, total, 0.95),
+		P99:   quantile(counts, total, 0.99),
+	}
+}
+
+// quantile returns the upper bound of the bucket containing the q-th quantile.
+func quantile(counts [histogramBuckets]int64, total int64, q float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+
+	rank := max(int64(q*float64(total)+0.5), 1)
+	var cumulative int64
+	bound := histogramBase
+
+	for i := range counts {
+		cumulative += counts[i]
+		if cumulative >= rank {
+			return bound
+		}
+		bound *= 2
+	}
+	return bound
+}
+
+// ClientStats are the statistics of the connection of a [Client], returned by [Client.Stats].
+type ClientStats struct {
+	MessagesReceived int64 `json:"messages_received"`
+	MessagesSent     int64 `json:"messages_sent"`
+	BytesReceived    int64 `json:"bytes_received"`
+	BytesSent        int64 `json:"bytes_sent"`
+
+	// EventsPublished are the EVENTs sent by the client, which are either accepted, rejected,
+	// or still being processed.
+	EventsPublished int64 `json:"events_published"`
+	EventsAccepted  int64 `json:"events_accepted"`
+	EventsRejected  int64 `json:"events_rejected"`
+
+	// Rejections are the EVENT, REQ, COUNT and AUTH messages of the client that were rejected,
+	// either because they were invalid or refused by the relay.
+	Rejections int64 `json:"rejections"`
+
+	SubscriptionsOpened int64 `json:"subscriptions_opened"`
+	Subscriptions       int   `json:"subscriptions"` // currently open
+
+	// LastActivity is when the client sent its last message, or when it connected if it sent none.
+	LastActivity time.Time `json:"last_activity"`
+
+	// RTT is the round-trip time of the last ping, see [Client.RTT].
+	RTT time.Duration `json:"rtt"`
+}
+
+func (c *client) Stats() ClientStats {
+	last := c.connectedAt
+	if ns := c.lastActivity.Load(); ns > 0 {
+		last = time.Unix(0, ns)
+	}
+
+	c.mu.Lock()
+	subs := len(c.subs)
+	c.mu.Unlock()
+
+	return ClientStats{
+		MessagesReceived:    c.messagesReceived.Load(),
+		MessagesSent:        c.messagesSent.Load(),
+		BytesReceived:       c.bytesReceived.Load(),
+		BytesSent:           c.bytesSent.Load(),
+		EventsPublished:     c.eventsPublished.Load(),
+		EventsAccepted:      c.eventsAccepted.Load(),
+		EventsRejected:      c.eventsRejected.Load(),
+		Rejections:          c.rejections.Load(),
+		SubscriptionsOpened: c.subscriptionsOpened.Load(),
+		Subscriptions:       subs,
+		LastActivity:        last,
+		RTT:                 c.RTT(),
+	}
+}
@@ -0,0 +1,193 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHistogramSummary(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		expected  Latency
+	}{
+		{
+			name:     "empty",
+			expected: Latency{},
+		},
+		{
+			name:      "single",
+			durations: []time.Duration{time.Millisecond},
+			expected:  Latency{Count: 1, P50: 1600 * time.Microsecond, P95: 1600 * time.Microsecond, P99: 1600 * time.Microsecond},
+		},
+		{
+			name:      "below base",
+			durations: []time.Duration{0, time.Microsecond, 50 * time.Microsecond},
+			expected:  Latency{Count: 3, P50: histogramBase, P95: histogramBase, P99: histogramBase},
+		},
+		{
+			name: "tail",
+			durations: append(
+				repeat(99, 100*time.Microsecond),
+				time.Second,
+			),
+			expected: Latency{Count: 100, P50: 100 * time.Microsecond, P95: 100 * time.Microsecond, P99: 100 * time.Microsecond},
+		},
+		{
+			name: "heavy tail",
+			durations: append(
+				repeat(90, 100*time.Microsecond),
+				repeat(10, time.Second)...,
+			),
+			expected: Latency{Count: 100, P50: 100 * time.Microsecond, P95: 1638400 * time.Microsecond, P99: 1638400 * time.Microsecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &histogram{}
+			for _, d := range test.durations {
+				h.Observe(d)
+			}
+
+			summary := h.Summary()
+			if summary != test.expected {
+				t.Fatalf("expected %+v, got %+v", test.expected, summary)
+			}
+		})
+	}
+}
+
+func TestHistogramOverflow(t *testing.T) {
+	h := &histogram{}
+	h.Observe(24 * time.Hour)
+
+	if h.current[histogramBuckets-1] != 1 {
+		t.Fatalf("expected the duration to end up in the last bucket")
+	}
+}
+
+func TestHistogramWindows(t *testing.T) {
+	h := &histogram{}
+	start := time.Unix(0, 0).Add(1000 * histogramWindow)
+
+	for range 10 {
+		h.observe(start, time.Second)
+	}
+
+	// the durations of the previous window are still summarized
+	h.observe(start.Add(histogramWindow), 100*time.Microsecond)
+	if summary := h.summary(start.Add(histogramWindow)); summary.P50 != 1638400*time.Microsecond {
+		t.Fatalf("expected the median of the previous window, got %+v", summary)
+	}
+
+	// then they are forgotten, but still counted
+	summary := h.summary(start.Add(2 * histogramWindow))
+	expected := Latency{Count: 11, P50: 100 * time.Microsecond, P95: 100 * time.Microsecond, P99: 100 * time.Microsecond}
+	if summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+
+	summary = h.summary(start.Add(10 * histogramWindow))
+	if expected := (Latency{Count: 11}); summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+}
+
+func repeat(n int, d time.Duration) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		durations[i] = d
+	}
+	return durations
+}
+
+func TestClientStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"))
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	valid := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "hello"}
+	if err := valid.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	invalid := valid
+	invalid.Content = "tampered"
+
+	send(t, conn, nostr.EventEnvelope{Event: valid})
+	send(t, conn, nostr.EventEnvelope{Event: invalid})
+	for range 2 {
+		if label, _ := readMessage(t, conn); label != "OK" {
+			t.Fatalf("expected OK, got %s", label)
+		}
+	}
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "notes", Filters: nostr.Filters{{Kinds: []int{1}}}})
+	if label, _ := readMessage(t, conn); label != "EOSE" {
+		t.Fatalf("expected EOSE, got %s", label)
+	}
+
+	clients, err := relay.ListClients()
+	if err != nil {
+		t.Fatalf("failed to list the clients: %v", err)
+	}
+	if len(clients) != 1 {
+		t.Fatalf("expected 1 client, got %d", len(clients))
+	}
+
+	stats := clients[0].Stats()
+	if stats.MessagesReceived != 3 || stats.MessagesSent != 3 {
+		t.Errorf("expected 3 messages in and out, got %d and %d", stats.MessagesReceived, stats.MessagesSent)
+	}
+	if stats.BytesReceived == 0 || stats.BytesSent == 0 {
+		t.Errorf("expected the bytes to be counted, got %d in and %d out", stats.BytesReceived, stats.BytesSent)
+	}
+	if stats.EventsPublished != 2 || stats.EventsAccepted != 1 || stats.EventsRejected != 1 || stats.Rejections != 1 {
+		t.Errorf("expected 2 events published, 1 accepted and 1 rejected, got %+v", stats)
+	}
+	if stats.SubscriptionsOpened != 1 || stats.Subscriptions != 1 {
+		t.Errorf("expected 1 subscription opened and open, got %d and %d", stats.SubscriptionsOpened, stats.Subscriptions)
+	}
+	if time.Since(stats.LastActivity) > time.Minute {
+		t.Errorf("unexpected last activity %v", stats.LastActivity)
+	}
+}
+
+func TestClientRTT(t *testing.T) {
+	relay := NewRelay()
+	c := &client{relay: relay, country: "DE"}
+
+	c.pong() // unsolicited
+	if c.RTT() != 0 {
+		t.Fatalf("expected no RTT for an unsolicited pong, got %v", c.RTT())
+	}
+
+	c.pingSentAt.Store(time.Now().Add(-20 * time.Millisecond).UnixNano())
+	c.pong()
+	if c.RTT() < 20*time.Millisecond {
+		t.Fatalf("expected an RTT of at least 20ms, got %v", c.RTT())
+	}
+
+	rtts := relay.RTTs()
+	if len(rtts) != 1 || rtts["DE"].Count != 1 {
+		t.Fatalf("expected one RTT of DE, got %v", rtts)
+	}
+}
@@ -21,6 +21,11 @@ func NewAnalyticsService(db *sql.DB, database string) *AnalyticsService {
 	}
 }
 
+// Analytics returns the analytics service of the storage database
+func (s *Storage) Analytics() *AnalyticsService {
+	return NewAnalyticsService(s.db, s.database)
+}
+
 // ==============================================================================
 // USER ANALYTICS
 // ==============================================================================
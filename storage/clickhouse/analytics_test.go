@@ -173,7 +173,7 @@ func TestGetActiveUsers(t *testing.T) {
 	// Insert test events
 	for i := 0; i < 10; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("active user test %d", i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 	time.Sleep(300 * time.Millisecond)
 
@@ -239,7 +239,7 @@ func TestGetEventKindStats(t *testing.T) {
 	for kind := 1; kind <= 3; kind++ {
 		for i := 0; i < 5; i++ {
 			event := createTestEvent(t, kind, fmt.Sprintf("kind %d event %d", kind, i))
-			testStorage.SaveEvent(nil, &event)
+			testStorage.SaveEvent(context.Background(), nil, &event)
 		}
 	}
 	time.Sleep(300 * time.Millisecond)
@@ -302,8 +302,8 @@ func TestGetTopEngagedEvents(t *testing.T) {
 	event1 := createTestEvent(t, 1, "Engagement test 1")
 	event2 := createTestEvent(t, 1, "Engagement test 2")
 
-	testStorage.SaveEvent(nil, &event1)
-	testStorage.SaveEvent(nil, &event2)
+	testStorage.SaveEvent(context.Background(), nil, &event1)
+	testStorage.SaveEvent(context.Background(), nil, &event2)
 	time.Sleep(300 * time.Millisecond)
 
 	// Insert engagement data
@@ -340,7 +340,7 @@ func TestGetGrowthMetrics(t *testing.T) {
 	// Insert events from multiple users over time
 	for i := 0; i < 20; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("growth test %d", i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 	time.Sleep(300 * time.Millisecond)
 
@@ -394,7 +394,7 @@ func TestGetTrendingPosts(t *testing.T) {
 
 	// Create test events
 	event := createTestEvent(t, 1, "Trending post test")
-	testStorage.SaveEvent(nil, &event)
+	testStorage.SaveEvent(context.Background(), nil, &event)
 	time.Sleep(300 * time.Millisecond)
 
 	// Insert hot posts data
@@ -435,7 +435,7 @@ func TestRefreshHotPosts(t *testing.T) {
 	// Create test events
 	for i := 0; i < 5; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("hot post %d", i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 	time.Sleep(300 * time.Millisecond)
 
@@ -455,7 +455,7 @@ func TestSampleEvents(t *testing.T) {
 	// Insert test events
 	for i := 0; i < 100; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("sample test %d", i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 	time.Sleep(300 * time.Millisecond)
 
@@ -513,7 +513,7 @@ func TestAnalyticsWithRealData(t *testing.T) {
 					t.Fatalf("Failed to sign event: %v", err)
 				}
 
-				testStorage.SaveEvent(nil, &event)
+				testStorage.SaveEvent(context.Background(), nil, &event)
 			}
 		}
 	}
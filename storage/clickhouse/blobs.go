@@ -0,0 +1,124 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// blobMarker replaces the content of the events stored in the event_blobs table.
+// Contents starting with the marker are always offloaded, so the marker is never ambiguous.
+const blobMarker = "\x00blob"
+
+// isOffloaded reports whether the content must be stored in the event_blobs table.
+func (s *Storage) isOffloaded(content string) bool {
+	if s.blobThreshold <= 0 {
+		return false
+	}
+	return len(content) > s.blobThreshold || strings.HasPrefix(content, blobMarker)
+}
+
+// storedContent returns the content stored in the events table.
+func (s *Storage) storedContent(content string) string {
+	if s.isOffloaded(content) {
+		return blobMarker
+	}
+	return content
+}
+
+// insertBlobs stores the contents of the offloaded events in the event_blobs table.
+// It must succeed before the events are inserted, so that their markers can always be resolved.
+func (s *Storage) insertBlobs(ctx context.Context, events []*nostr.Event) error {
+	if s.blobThreshold <= 0 {
+		return nil
+	}
+
+	var blobs []*nostr.Event
+	for _, event := range events {
+		if s.isOffloaded(event.Content) {
+			blobs = append(blobs, event)
+		}
+	}
+
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO %s.event_blobs (id, content, created_at) VALUES (?, ?, ?)", s.database)
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range blobs {
+		if _, err := stmt.ExecContext(ctx, event.ID, event.Content, uint32(event.CreatedAt)); err != nil {
+			return fmt.Errorf("failed to insert blob of event %s: %w", event.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// loadBlobs replaces the markers of the offloaded events with their contents,
+// fetching them from the event_blobs table with a single query.
+func (s *Storage) loadBlobs(ctx context.Context, events []nostr.Event) error {
+	index := make(map[string]int)
+	for i := range events {
+		if events[i].Content == blobMarker {
+			index[events[i].ID] = i
+		}
+	}
+
+	if len(index) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(index))
+	args := make([]interface{}, 0, len(index))
+	for id := range index {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf("SELECT id, content FROM %s.event_blobs FINAL WHERE id IN (%s)",
+		s.database, strings.Join(placeholders, ","))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query blobs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return fmt.Errorf("failed to scan blob: %w", err)
+		}
+
+		if i, ok := index[id]; ok {
+			events[i].Content = content
+		}
+	}
+	return rows.Err()
+}
+
+// searchCondition returns the full-text search condition, which also looks into the
+// offloaded contents when the blob table is used.
+func (s *Storage) searchCondition() string {
+	if s.blobThreshold <= 0 {
+		return "hasToken(content, ?)"
+	}
+	return fmt.Sprintf("(hasToken(content, ?) OR id IN (SELECT id FROM %s.event_blobs WHERE hasToken(content, ?)))", s.database)
+}
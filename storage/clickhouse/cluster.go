@@ -0,0 +1,61 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nostr-net/rely"
+)
+
+// SaveClusterEntries stores the entries of the cluster state, whose creation time is set by the server.
+// It implements [rely.ClusterState].
+func (s *Storage) SaveClusterEntries(ctx context.Context, entries []rely.ClusterEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(entries))
+	args := make([]any, 0, 6*len(entries))
+	for i, e := range entries {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, e.ID, e.Origin, e.Kind, e.Key, e.Amount, e.Until)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s.cluster_state (id, origin, kind, key, amount, until) VALUES %s`,
+		s.database, strings.Join(placeholders, ", "))
+
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to save cluster entries: %w", err)
+	}
+	return nil
+}
+
+// ClusterEntries returns the entries of the cluster state created after the time whose until hasn't passed,
+// ordered by creation time. It implements [rely.ClusterState].
+func (s *Storage) ClusterEntries(ctx context.Context, since time.Time) ([]rely.ClusterEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT id, origin, kind, key, amount, until, created_at
+		FROM %s.cluster_state
+		WHERE created_at > ? AND until > now()
+		ORDER BY created_at
+	`, s.database)
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cluster entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []rely.ClusterEntry
+	for rows.Next() {
+		var e rely.ClusterEntry
+		if err := rows.Scan(&e.ID, &e.Origin, &e.Kind, &e.Key, &e.Amount, &e.Until, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan cluster entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,166 @@
+package clickhouse
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// maxCoalescedPairs is the maximum number of (author, kind) pairs merged in a single query.
+	maxCoalescedPairs = 1000
+
+	// coalescedLimit is the limit of the merged query. If reached, the results may be
+	// truncated, so each filter of the batch is queried on its own.
+	coalescedLimit = 5000
+
+	// coalescedTimeout bounds the merged query, which is shared by many requests.
+	coalescedTimeout = 10 * time.Second
+)
+
+// readBatch is a group of compatible filters served by a single query.
+type readBatch struct {
+	kinds   []int
+	authors map[string]struct{}
+
+	done   chan struct{} // closed when the events are ready
+	events []nostr.Event
+	err    error
+}
+
+// coalescer merges the compatible filters received within a window into a single query.
+type coalescer struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*readBatch // by kinds
+
+	merged atomic.Uint64 // filters served by a query of another filter
+}
+
+// isCoalescible reports whether the filter can be merged with others. Only filters
+// of authors and replaceable kinds (like profiles and follow lists) are coalescible,
+// because they match few events per author, so the merged query stays bounded.
+func isCoalescible(filter nostr.Filter) bool {
+	if len(filter.Authors) == 0 || len(filter.Kinds) == 0 ||
+		len(filter.IDs) > 0 || len(filter.Tags) > 0 || filter.Since != nil ||
+		filter.Until != nil || filter.Search != "" {
+		return false
+	}
+
+	if len(filter.Authors)*len(filter.Kinds) > maxCoalescedPairs {
+		return false
+	}
+
+	for _, kind := range filter.Kinds {
+		if !nostr.IsReplaceableKind(kind) {
+			return false
+		}
+	}
+	return true
+}
+
+// coalescedQuery serves the filter with a query shared by the compatible filters received within the window.
+func (s *Storage) coalescedQuery(ctx context.Context, filter nostr.Filter) ([]nostr.Event, error) {
+	kinds := slices.Compact(slices.Sorted(slices.Values(filter.Kinds)))
+	key := kindsKey(kinds)
+
+	s.coalescer.mu.Lock()
+	batch, ok := s.coalescer.pending[key]
+	if ok && (len(batch.authors)+len(filter.Authors))*len(kinds) > maxCoalescedPairs {
+		// the batch is full, a new one takes its place
+		ok = false
+	}
+
+	if !ok {
+		batch = &readBatch{
+			kinds:   kinds,
+			authors: make(map[string]struct{}),
+			done:    make(chan struct{}),
+		}
+		s.coalescer.pending[key] = batch
+		time.AfterFunc(s.coalescer.window, func() { s.runBatch(key, batch) })
+	} else {
+		s.coalescer.merged.Add(1)
+	}
+
+	for _, author := range filter.Authors {
+		batch.authors[author] = struct{}{}
+	}
+	s.coalescer.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-batch.done:
+	}
+
+	if batch.err != nil {
+		return nil, batch.err
+	}
+
+	if len(batch.events) >= coalescedLimit {
+		return s.queryFilter(ctx, filter)
+	}
+	return demultiplex(batch.events, filter), nil
+}
+
+// runBatch closes the batch to new filters and queries the events of all of them.
+func (s *Storage) runBatch(key string, batch *readBatch) {
+	s.coalescer.mu.Lock()
+	if s.coalescer.pending[key] == batch {
+		delete(s.coalescer.pending, key)
+	}
+	s.coalescer.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), coalescedTimeout)
+	defer cancel()
+
+	filter := nostr.Filter{
+		Authors: slices.Sorted(maps.Keys(batch.authors)),
+		Kinds:   batch.kinds,
+		Limit:   coalescedLimit,
+	}
+
+	batch.events, batch.err = s.queryFilter(ctx, filter)
+	close(batch.done)
+}
+
+// demultiplex returns the events of the batch matching the filter, respecting its limit.
+// The events are already sorted by created_at in descending order.
+func demultiplex(events []nostr.Event, filter nostr.Filter) []nostr.Event {
+	var result []nostr.Event
+	for _, event := range events {
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+
+		if slices.Contains(filter.Authors, event.PubKey) && slices.Contains(filter.Kinds, event.Kind) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// kindsKey returns the key of the batches of the sorted kinds.
+func kindsKey(kinds []int) string {
+	strs := make([]string, len(kinds))
+	for i, k := range kinds {
+		strs[i] = strconv.Itoa(k)
+	}
+	return strings.Join(strs, ",")
+}
+
+// CoalescedQueries returns the number of filters served by the query of another filter.
+func (s *Storage) CoalescedQueries() uint64 {
+	if s.coalescer == nil {
+		return 0
+	}
+	return s.coalescer.merged.Load()
+}
@@ -0,0 +1,94 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Compaction is the state of the parts of a ReplacingMergeTree table. Rows with the same sorting key
+// are only deduplicated when their parts are merged, so until then queries need FINAL to be correct.
+type Compaction struct {
+	Table string
+
+	// Rows is the number of rows in the active parts, and UniqueKeys the approximate number of distinct sorting keys.
+	Rows       uint64
+	UniqueKeys uint64
+
+	// Unmerged are the partitions with more than one active part, which may hold duplicates.
+	Unmerged []string
+}
+
+// DuplicateRatio returns the approximate fraction of the rows that are duplicates, waiting to be merged.
+func (c Compaction) DuplicateRatio() float64 {
+	if c.Rows == 0 || c.UniqueKeys >= c.Rows {
+		return 0
+	}
+	return 1 - float64(c.UniqueKeys)/float64(c.Rows)
+}
+
+// CompactionState returns the state of the parts of the table.
+// It reads the whole sorting key of the table, so it's not cheap on large tables.
+func (s *Storage) CompactionState(ctx context.Context, table string) (Compaction, error) {
+	c := Compaction{Table: table}
+
+	var key string
+	query := "SELECT sorting_key FROM system.tables WHERE database = ? AND name = ?"
+	if err := s.db.QueryRowContext(ctx, query, s.database, table).Scan(&key); err != nil {
+		return c, fmt.Errorf("failed to get the sorting key of %s: %w", table, err)
+	}
+
+	query = fmt.Sprintf("SELECT count(), uniq(%s) FROM %s.%s", key, s.database, table)
+	if err := s.db.QueryRowContext(ctx, query).Scan(&c.Rows, &c.UniqueKeys); err != nil {
+		return c, fmt.Errorf("failed to count the rows of %s: %w", table, err)
+	}
+
+	query = `
+		SELECT partition_id
+		FROM system.parts
+		WHERE database = ? AND table = ? AND active
+		GROUP BY partition_id
+		HAVING count() > 1
+		ORDER BY partition_id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, s.database, table)
+	if err != nil {
+		return c, fmt.Errorf("failed to query the parts of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			return c, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		c.Unmerged = append(c.Unmerged, partition)
+	}
+	return c, rows.Err()
+}
+
+// OptimizePartitions forces the merge of the partitions of the table, one at a time,
+// pausing between them to limit the impact on the latency of the queries.
+// The progress function, if not nil, is called after each partition.
+func (s *Storage) OptimizePartitions(ctx context.Context, table string, partitions []string, pause time.Duration, progress func(Progress)) error {
+	for i, partition := range partitions {
+		if i > 0 && pause > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+
+		query := fmt.Sprintf("OPTIMIZE TABLE %s.%s PARTITION ID ? FINAL", s.database, table)
+		if _, err := s.db.ExecContext(ctx, query, partition); err != nil {
+			return fmt.Errorf("failed to optimize partition %s of %s: %w", partition, table, err)
+		}
+
+		if progress != nil {
+			progress(Progress{Partition: partition, Done: i + 1, Total: len(partitions)})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EventTables are the tables storing the events, whose codecs are configured by [Compression].
+var EventTables = []string{"events", "events_by_author", "events_by_kind", "events_by_tag_p", "events_by_tag_e", "events_by_address", "event_blobs"}
+
+// Compression configures the column codecs of the event tables.
+type Compression struct {
+	// ZSTD level of the content and tags columns, between 1 and 22 (0 keeps the default LZ4)
+	ContentLevel int
+
+	// Delta-encode the created_at columns before compressing them
+	DeltaTimestamps bool
+
+	// Store the kind columns as LowCardinality(UInt16).
+	// Kinds are part of the sorting keys, so this only applies to new schemas.
+	LowCardinalityKind bool
+}
+
+// DefaultCompression returns a Compression with sensible defaults
+func DefaultCompression() Compression {
+	return Compression{
+		ContentLevel:    3,
+		DeltaTimestamps: true,
+	}
+}
+
+// Validate returns an error if the compression settings are invalid
+func (c Compression) Validate() error {
+	if c.ContentLevel < 0 || c.ContentLevel > 22 {
+		return fmt.Errorf("the ZSTD level must be between 0 and 22, got %d", c.ContentLevel)
+	}
+	return nil
+}
+
+// contentCodec returns the codec of the content and tags columns.
+func (c Compression) contentCodec() string {
+	if c.ContentLevel == 0 {
+		return "CODEC(LZ4)"
+	}
+	return fmt.Sprintf("CODEC(ZSTD(%d))", c.ContentLevel)
+}
+
+// timestampCodec returns the codec of the created_at columns.
+func (c Compression) timestampCodec() string {
+	if !c.DeltaTimestamps {
+		return "CODEC(LZ4)"
+	}
+	return "CODEC(Delta(4), ZSTD(1))"
+}
+
+// columnTypes are the types of the columns affected by the compression settings.
+var columnTypes = map[string]string{
+	"content":    "String",
+	"tags":       "Array(Array(String))",
+	"created_at": "UInt32",
+	"kind":       "UInt16",
+}
+
+// schemaColumn matches the column definitions affected by the compression settings,
+// as long as they don't already specify a codec.
+var schemaColumn = regexp.MustCompile(`(?m)^(\s+)(content|tags|created_at|kind)(\s+)(String|Array\(Array\(String\)\)|UInt32|UInt16)(\s*(?:,|--|$))`)
+
+// Schema rewrites the column definitions of a migration with the configured codecs.
+// It's used to generate the schema of new deployments, see [Migrations].
+func (c Compression) Schema(sql string) string {
+	sql = schemaColumn.ReplaceAllStringFunc(sql, func(column string) string {
+		m := schemaColumn.FindStringSubmatch(column)
+		indent, name, space, typ, rest := m[1], m[2], m[3], m[4], m[5]
+		if columnTypes[name] != typ {
+			return column
+		}
+
+		switch name {
+		case "content", "tags":
+			typ += " " + c.contentCodec()
+		case "created_at":
+			typ += " " + c.timestampCodec()
+		case "kind":
+			if !c.LowCardinalityKind {
+				return column
+			}
+			typ = "LowCardinality(" + typ + ")"
+		}
+		return indent + name + space + typ + rest
+	})
+
+	if c.LowCardinalityKind && strings.Contains(sql, "LowCardinality(UInt16)") {
+		sql = "SET allow_suspicious_low_cardinality_types = 1;\n\n" + sql
+	}
+	return sql
+}
+
+// ApplyCompression changes the codecs of the existing event tables.
+// Only new parts use them, so call [Storage.Optimize] to rewrite the existing ones.
+// The kind columns are part of the sorting keys, so their type is never changed.
+func (s *Storage) ApplyCompression(ctx context.Context, c Compression) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	for _, table := range EventTables {
+		columns := []string{"content", "tags", "created_at"}
+		if table == "event_blobs" {
+			columns = []string{"content", "created_at"}
+		}
+
+		for _, column := range columns {
+			codec := c.contentCodec()
+			if column == "created_at" {
+				codec = c.timestampCodec()
+			}
+
+			query := fmt.Sprintf("ALTER TABLE %s.%s MODIFY COLUMN %s %s", s.database, table, column, codec)
+			if _, err := s.db.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to change the codec of %s.%s: %w", table, column, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Optimize forces the merge of the parts of the tables, rewriting them with the current codecs.
+// It's an expensive operation, which should be run when the relay is not busy.
+func (s *Storage) Optimize(ctx context.Context, table string) error {
+	query := fmt.Sprintf("OPTIMIZE TABLE %s.%s FINAL", s.database, table)
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to optimize %s: %w", table, err)
+	}
+	return nil
+}
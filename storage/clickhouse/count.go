@@ -13,11 +13,14 @@ func (s *Storage) countFilter(ctx context.Context, filter nostr.Filter) (int64,
 	// Build count query (similar to regular query but with COUNT(*))
 	table, query, args := s.buildCountQuery(filter)
 
+	limited, cancel := s.limit(ctx)
+	defer cancel()
+
 	// Execute query
 	var count int64
-	err := s.db.QueryRowContext(ctx, query, args...).Scan(&count)
+	err := s.db.QueryRowContext(limited, query, args...).Scan(&count)
 	if err != nil {
-		return 0, fmt.Errorf("count query failed on table %s: %w", table, err)
+		return 0, fmt.Errorf("count query failed on table %s: %w", table, limitError(ctx, err))
 	}
 
 	return count, nil
@@ -25,56 +28,31 @@ func (s *Storage) countFilter(ctx context.Context, filter nostr.Filter) (int64,
 
 // buildCountQuery constructs an optimized count query based on the filter
 func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interface{}) {
-	var table string
 	var args []interface{}
 
-	// Count how many different tag types are requested
-	tagTypeCount := 0
-	if len(filter.Tags["p"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["e"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["a"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["t"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["d"]) > 0 {
-		tagTypeCount++
-	}
-
-	// Choose optimal table based on filter characteristics
-	// IMPORTANT: Only use tag-specific tables when ONLY that tag type is requested
-	switch {
-	case len(filter.IDs) > 0:
-		table = fmt.Sprintf("%s.events", s.database)
-	case len(filter.Authors) > 0:
-		table = fmt.Sprintf("%s.events_by_author", s.database)
-	case len(filter.Kinds) > 0:
-		table = fmt.Sprintf("%s.events_by_kind", s.database)
-	case tagTypeCount == 1 && len(filter.Tags["p"]) > 0:
-		// Only use tag_p table if it's the ONLY tag filter
-		table = fmt.Sprintf("%s.events_by_tag_p", s.database)
-	case tagTypeCount == 1 && len(filter.Tags["e"]) > 0:
-		// Only use tag_e table if it's the ONLY tag filter
-		table = fmt.Sprintf("%s.events_by_tag_e", s.database)
-	default:
-		// Fall back to base table for multiple tag types or other cases
-		table = fmt.Sprintf("%s.events", s.database)
-	}
+	// Choose the table whose sorting key scans the fewest rows
+	table := s.route(filter)
 
 	// Build SELECT clause for counting
+	dedup := s.readMode == ReadDedup
 	query := fmt.Sprintf(`
 		SELECT count()
 		FROM %s FINAL
 	`, table)
 
+	if dedup {
+		// Count the events whose latest version isn't deleted
+		query = fmt.Sprintf(`
+		SELECT countIf(latest_deleted = 0)
+		FROM (SELECT id, argMax(deleted, version) AS latest_deleted FROM %s
+	`, table)
+	}
+
 	// Build WHERE conditions (same logic as regular query)
 	var conditions []string
-	conditions = append(conditions, "deleted = 0")
+	if !dedup {
+		conditions = append(conditions, "deleted = 0")
+	}
 
 	// ID filter
 	if len(filter.IDs) > 0 {
@@ -119,7 +97,7 @@ func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interf
 
 	// Tag filters
 	if eTags := filter.Tags["e"]; len(eTags) > 0 {
-		if table == fmt.Sprintf("%s.events_by_tag_e", s.database) {
+		if table == s.table("events_by_tag_e") {
 			placeholders := make([]string, len(eTags))
 			for i, tag := range eTags {
 				placeholders[i] = "?"
@@ -133,7 +111,7 @@ func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interf
 	}
 
 	if pTags := filter.Tags["p"]; len(pTags) > 0 {
-		if table == fmt.Sprintf("%s.events_by_tag_p", s.database) {
+		if table == s.table("events_by_tag_p") {
 			placeholders := make([]string, len(pTags))
 			for i, tag := range pTags {
 				placeholders[i] = "?"
@@ -156,6 +134,13 @@ func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interf
 		args = append(args, tTags)
 	}
 
+	for _, tag := range s.columnTags() {
+		if values := filter.Tags[tag]; len(values) > 0 {
+			conditions = append(conditions, s.tagCondition(table, tag))
+			args = append(args, values)
+		}
+	}
+
 	if dTags := filter.Tags["d"]; len(dTags) > 0 {
 		placeholders := make([]string, len(dTags))
 		for i, tag := range dTags {
@@ -167,8 +152,11 @@ func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interf
 
 	// Search filter
 	if filter.Search != "" {
-		conditions = append(conditions, "hasToken(content, ?)")
+		conditions = append(conditions, s.searchCondition())
 		args = append(args, filter.Search)
+		if s.blobThreshold > 0 {
+			args = append(args, filter.Search)
+		}
 	}
 
 	// Add WHERE clause
@@ -176,5 +164,8 @@ func (s *Storage) buildCountQuery(filter nostr.Filter) (string, string, []interf
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
+	if dedup {
+		query += " GROUP BY id)"
+	}
 	return table, query, args
 }
@@ -0,0 +1,189 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tombstones mirrors the tombstones table in memory, so that the inserts don't query it.
+// Tombstones written by other instances sharing the database are loaded when the storage starts.
+type tombstones struct {
+	mu        sync.RWMutex
+	ids       map[string]struct{}
+	addresses map[string]int64 // the versions created until then are deleted
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{
+		ids:       make(map[string]struct{}),
+		addresses: make(map[string]int64),
+	}
+}
+
+// tombstoneAddress returns the address "kind:pubkey:d" of the tombstones table.
+func tombstoneAddress(kind int, pubkey, d string) string {
+	return strconv.Itoa(kind) + ":" + pubkey + ":" + d
+}
+
+// add adds the tombstone of the key, which is an ID if until is 0, or an address otherwise.
+func (t *tombstones) add(key string, until int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until == 0 {
+		t.ids[key] = struct{}{}
+		return
+	}
+
+	if until > t.addresses[key] {
+		t.addresses[key] = until
+	}
+}
+
+// blocks returns whether the event has been deleted.
+func (t *tombstones) blocks(e *nostr.Event) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if _, ok := t.ids[e.ID]; ok {
+		return true
+	}
+
+	if len(t.addresses) == 0 || !(nostr.IsReplaceableKind(e.Kind) || nostr.IsAddressableKind(e.Kind)) {
+		return false
+	}
+
+	d := ""
+	if nostr.IsAddressableKind(e.Kind) {
+		d = e.Tags.GetD()
+	}
+
+	until, ok := t.addresses[tombstoneAddress(e.Kind, e.PubKey, d)]
+	return ok && int64(e.CreatedAt) <= until
+}
+
+// filter returns the events that have not been deleted, reusing the slice.
+func (t *tombstones) filter(events []*nostr.Event) []*nostr.Event {
+	kept := events[:0]
+	for _, e := range events {
+		if !t.blocks(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// loadTombstones loads the tombstones table in memory.
+func (s *Storage) loadTombstones(ctx context.Context) error {
+	query := fmt.Sprintf(`SELECT key, max(until) FROM %s.tombstones GROUP BY key`, s.database)
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var until uint32
+
+		if err := rows.Scan(&key, &until); err != nil {
+			return fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		s.tombstones.add(key, int64(until))
+	}
+
+	return rows.Err()
+}
+
+// DeleteEvents deletes the events with the IDs, leaving tombstones so that they can't be inserted again.
+func (s *Storage) DeleteEvents(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if err := s.insertTombstones(ctx, ids, 0, now); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		s.tombstones.add(id, 0)
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	condition := fmt.Sprintf("id IN (%s)", strings.Join(placeholders, ","))
+	if err := s.markDeleted(ctx, condition, args, now); err != nil {
+		return fmt.Errorf("failed to delete events: %w", err)
+	}
+	return nil
+}
+
+// DeleteByAddress deletes the versions of the replaceable or addressable event created until now,
+// leaving a tombstone so that they can't be inserted again. The d tag is empty for replaceable events.
+func (s *Storage) DeleteByAddress(ctx context.Context, kind int, pubkey, d string) error {
+	now := time.Now()
+	address := tombstoneAddress(kind, pubkey, d)
+
+	if err := s.insertTombstones(ctx, []string{address}, uint32(now.Unix()), now); err != nil {
+		return err
+	}
+	s.tombstones.add(address, now.Unix())
+
+	condition := "kind = ? AND pubkey = ? AND tag_d = ? AND created_at <= ?"
+	if err := s.markDeleted(ctx, condition, []any{uint16(kind), pubkey, d, uint32(now.Unix())}, now); err != nil {
+		return fmt.Errorf("failed to delete address %s: %w", address, err)
+	}
+	return nil
+}
+
+// insertTombstones inserts the tombstones of the keys, which are IDs if until is 0, or addresses otherwise.
+func (s *Storage) insertTombstones(ctx context.Context, keys []string, until uint32, now time.Time) error {
+	placeholders := make([]string, len(keys))
+	args := make([]any, 0, 3*len(keys))
+	for i, key := range keys {
+		placeholders[i] = "(?, ?, ?)"
+		args = append(args, key, until, now)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s.tombstones (key, until, deleted_at) VALUES %s`,
+		s.database, strings.Join(placeholders, ", "))
+
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert tombstones: %w", err)
+	}
+	return nil
+}
+
+// markDeleted inserts a newer version of the events matching the condition, with the deleted flag set,
+// which replaces them in the events table and its projections when merged.
+func (s *Storage) markDeleted(ctx context.Context, condition string, args []any, now time.Time) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s.events (
+			id, pubkey, created_at, kind, content, sig,
+			tags, tag_e, tag_p, tag_a, tag_t, tag_d, tag_g, tag_r,
+			relay_received_at, deleted, version
+		)
+		SELECT
+			id, pubkey, created_at, kind, content, sig,
+			tags, tag_e, tag_p, tag_a, tag_t, tag_d, tag_g, tag_r,
+			relay_received_at, 1, greatest(version + 1, ?)
+		FROM %[1]s.events FINAL
+		WHERE %[2]s AND deleted = 0
+	`, s.database, condition)
+
+	_, err := s.db.ExecContext(ctx, query, append([]any{uint32(now.Unix())}, args...)...)
+	return err
+}
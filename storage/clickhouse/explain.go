@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Explanation describes how a filter is queried, to diagnose slow filters.
+type Explanation struct {
+	Table    string // the table the filter is routed to
+	Query    string
+	Args     []any
+	Windowed bool // whether the filter is first queried within the implicit window, as in the query
+
+	Cost  float64 // the planner's estimate of the rows scanned, see [Storage.EstimateCost]
+	Parts uint64  // the parts, marks and rows to read according to EXPLAIN ESTIMATE
+	Marks uint64
+	Rows  uint64
+
+	// The results of the query, if it was run.
+	Ran      bool
+	Events   int
+	Duration time.Duration
+}
+
+// Explain returns the table and SQL the filter would be queried with, and the rows ClickHouse estimates
+// to read. If run is true, the query is also executed and timed, bypassing the caches.
+func (s *Storage) Explain(ctx context.Context, filter nostr.Filter, run bool) (Explanation, error) {
+	ex := Explanation{Cost: s.EstimateCost(filter)}
+	if s.window > 0 && isUnbounded(filter) {
+		since := nostr.Timestamp(time.Now().Add(-s.window).Unix())
+		filter.Since = &since
+		ex.Windowed = true
+	}
+
+	ex.Table, ex.Query, ex.Args = s.buildQuery(filter)
+
+	rows, err := s.db.QueryContext(ctx, "EXPLAIN ESTIMATE "+ex.Query, ex.Args...)
+	if err != nil {
+		return ex, fmt.Errorf("failed to estimate the query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var database, table string
+		var parts, row, marks uint64
+		if err := rows.Scan(&database, &table, &parts, &row, &marks); err != nil {
+			return ex, fmt.Errorf("failed to scan the estimate: %w", err)
+		}
+		ex.Parts += parts
+		ex.Rows += row
+		ex.Marks += marks
+	}
+	if err := rows.Err(); err != nil {
+		return ex, err
+	}
+
+	if !run {
+		return ex, nil
+	}
+
+	start := time.Now()
+	events, err := s.runQuery(ctx, ex.Query, ex.Args)
+	if err != nil {
+		return ex, fmt.Errorf("query failed on table %s: %w", ex.Table, err)
+	}
+
+	ex.Ran = true
+	ex.Events = len(events)
+	ex.Duration = time.Since(start)
+	return ex, nil
+}
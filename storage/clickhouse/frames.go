@@ -0,0 +1,109 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nostr-net/rely"
+)
+
+// Sampled returns whether the connection with the provided UID is recorded into the frames table,
+// which is never the case if the frame log is disabled. With [Storage.Record], it implements [rely.Recorder]:
+//
+//	relay := rely.NewRelay(rely.WithRecorder(storage))
+func (s *Storage) Sampled(clientUID string) bool {
+	return s.frameLog != nil && rely.SampleConnection(clientUID, s.frameSampleRate)
+}
+
+// Record the frame of a sampled connection into the frames table, without blocking.
+func (s *Storage) Record(f rely.Frame) {
+	if s.frameLog == nil {
+		return
+	}
+
+	if s.redactFrames {
+		f.Data = rely.RedactPubkeys(f.Data)
+	}
+
+	select {
+	case s.frameLog <- f:
+	default:
+		// the frame log is best-effort, frames are dropped when it can't keep up
+	}
+}
+
+// frameLogger continuously batches and inserts the frames
+func (s *Storage) frameLogger() {
+	defer close(s.frameLogDone)
+
+	buffer := make([]rely.Frame, 0, 1000)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(buffer) == 0 {
+			return
+		}
+
+		if err := s.insertFrames(context.Background(), buffer); err != nil {
+			log.Printf("frame log insert error: %v", err)
+		}
+		buffer = buffer[:0]
+	}
+
+	for {
+		select {
+		case <-s.stopBatch:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case frame := <-s.frameLog:
+			buffer = append(buffer, frame)
+			if len(buffer) >= cap(buffer) {
+				flush()
+			}
+		}
+	}
+}
+
+// insertFrames inserts a batch of frames in a single transaction
+func (s *Storage) insertFrames(ctx context.Context, frames []rely.Frame) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s.frames (time, client, ip, direction, data) VALUES (?, ?, ?, ?, ?)
+	`, s.database)
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, frame := range frames {
+		_, err := stmt.ExecContext(ctx,
+			frame.Time,
+			frame.ClientUID,
+			frame.IP,
+			string(frame.Direction),
+			string(frame.Data),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert frame: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Grant is a time-limited write permission given to a pubkey
+type Grant struct {
+	Pubkey    string
+	Method    string // How the grant was obtained, e.g. "captcha" or "lightning"
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// SaveGrant stores a write permission grant
+func (s *Storage) SaveGrant(ctx context.Context, g Grant) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s.write_grants (pubkey, method, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+	`, s.database)
+
+	_, err := s.db.ExecContext(ctx, query, g.Pubkey, g.Method, uint32(g.ExpiresAt.Unix()), uint32(g.CreatedAt.Unix()))
+	if err != nil {
+		return fmt.Errorf("failed to save grant for %s: %w", g.Pubkey, err)
+	}
+	return nil
+}
+
+// ActiveGrants returns the latest unexpired grant of each pubkey
+func (s *Storage) ActiveGrants(ctx context.Context) ([]Grant, error) {
+	query := fmt.Sprintf(`
+		SELECT pubkey, argMax(method, expires_at), max(expires_at), max(created_at)
+		FROM %s.write_grants
+		GROUP BY pubkey
+		HAVING max(expires_at) > toUInt32(now())
+	`, s.database)
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var g Grant
+		var expiresAt, createdAt uint32
+
+		if err := rows.Scan(&g.Pubkey, &g.Method, &expiresAt, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %w", err)
+		}
+
+		g.ExpiresAt = time.Unix(int64(expiresAt), 0)
+		g.CreatedAt = time.Unix(int64(createdAt), 0)
+		grants = append(grants, g)
+	}
+
+	return grants, rows.Err()
+}
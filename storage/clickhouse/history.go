@@ -0,0 +1,71 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replaceableCondition selects the replaceable and addressable events, whose versions share an address.
+const replaceableCondition = "(kind IN (0, 3) OR kind BETWEEN 10000 AND 19999 OR kind BETWEEN 30000 AND 39999)"
+
+// History returns the stored versions of the replaceable or addressable event, newest first, up to the limit.
+// The d tag is empty for replaceable events. The versions beyond the ones kept by [Storage.PruneHistory]
+// are not returned, as are the deleted ones.
+func (s *Storage) History(ctx context.Context, kind int, pubkey, d string, limit int) ([]nostr.Event, error) {
+	if !nostr.IsReplaceableKind(kind) && !nostr.IsAddressableKind(kind) {
+		return nil, fmt.Errorf("kind %d is neither replaceable nor addressable", kind)
+	}
+	if !nostr.IsAddressableKind(kind) {
+		d = ""
+	}
+
+	// the versions of an author are contiguous in the sorting key of events_by_author
+	query := fmt.Sprintf(`
+		SELECT id, pubkey, created_at, kind, content, sig, toJSONString(tags) AS tags_json
+		FROM %s FINAL
+		WHERE pubkey = ? AND kind = ? AND tag_d = ? AND deleted = 0
+		ORDER BY created_at DESC, id
+		LIMIT %d
+	`, s.table("events_by_author"), max(limit, 1))
+
+	events, err := s.runQuery(ctx, query, []any{pubkey, uint16(kind), d})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the history: %w", err)
+	}
+
+	if err := s.loadBlobs(ctx, events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// PruneHistory deletes the versions of the replaceable and addressable events superseded by more than keep
+// newer versions, so that each address keeps its latest version and up to keep previous ones, see [Storage.History].
+// Unlike [Storage.DeleteByAddress], it leaves no tombstones: a pruned version re-broadcast to the relay
+// is pruned again by the next run. It scans the replaceable events, so it should run periodically
+// rather than on every insert.
+func (s *Storage) PruneHistory(ctx context.Context, keep int) error {
+	if keep < 0 {
+		return fmt.Errorf("the versions kept must not be negative, got %d", keep)
+	}
+
+	condition := fmt.Sprintf(`%[2]s AND (kind, pubkey, tag_d, id) IN (
+			SELECT kind, pubkey, tag_d, id FROM (
+				SELECT kind, pubkey, tag_d, id, row_number() OVER (
+					PARTITION BY kind, pubkey, if(kind BETWEEN 30000 AND 39999, tag_d, '')
+					ORDER BY created_at DESC, id
+				) AS rank
+				FROM %[1]s.events FINAL
+				WHERE deleted = 0 AND %[2]s
+			)
+			WHERE rank > ?
+		)`, s.database, replaceableCondition)
+
+	if err := s.markDeleted(ctx, condition, []any{keep + 1}, time.Now()); err != nil {
+		return fmt.Errorf("failed to prune the history: %w", err)
+	}
+	return nil
+}
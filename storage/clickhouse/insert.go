@@ -115,11 +115,29 @@ func extractAllTags(tags nostr.Tags) ExtractedTags {
 
 // batchInsert inserts a batch of events in a single transaction
 // OPTIMIZED: Uses single-pass tag extraction and prepared statement
-func (s *Storage) batchInsert(ctx context.Context, events []*nostr.Event) error {
+func (s *Storage) batchInsert(ctx context.Context, events []*nostr.Event) (err error) {
+	// events deleted while waiting in the batch
+	for _, event := range events {
+		if s.tombstones.blocks(event) {
+			s.forgetVersions([]*nostr.Event{event})
+		}
+	}
+
+	events = s.tombstones.filter(events)
 	if len(events) == 0 {
 		return nil
 	}
 
+	defer func() {
+		if err != nil {
+			s.forgetVersions(events)
+		}
+	}()
+
+	if err := s.insertBlobs(ctx, events); err != nil {
+		return err
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -151,7 +169,7 @@ func (s *Storage) batchInsert(ctx context.Context, events []*nostr.Event) error
 			event.PubKey,
 			uint32(event.CreatedAt),
 			uint16(event.Kind),
-			event.Content,
+			s.storedContent(event.Content),
 			event.Sig,
 			extracted.tagsArray,
 			extracted.e,
@@ -173,6 +191,9 @@ func (s *Storage) batchInsert(ctx context.Context, events []*nostr.Event) error
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	if s.negative != nil {
+		s.negative.invalidate(events)
+	}
 	return nil
 }
 
@@ -15,6 +15,10 @@ func (s *Storage) batchInsertOptimized(ctx context.Context, events []*nostr.Even
 		return nil
 	}
 
+	if err := s.insertBlobs(ctx, events); err != nil {
+		return err
+	}
+
 	// Use standard SQL prepared statement (compatible with database/sql)
 	query := fmt.Sprintf(`
 		INSERT INTO %s.events (
@@ -43,7 +47,7 @@ func (s *Storage) batchInsertOptimized(ctx context.Context, events []*nostr.Even
 			event.PubKey,
 			uint32(event.CreatedAt),
 			uint16(event.Kind),
-			event.Content,
+			s.storedContent(event.Content),
 			event.Sig,
 			extracted.tagsArray,
 			extracted.e,
@@ -0,0 +1,102 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Location describes where an event is stored, for diagnostics.
+type Location struct {
+	Event      *nostr.Event // the latest version in the events table, nil if missing
+	ReceivedAt time.Time    // when the relay received the event
+	Deleted    bool         // whether the latest version is soft-deleted
+	Tombstoned bool         // whether a deletion forbids saving the event again
+	Tables     []TableRows  // the rows of the event in the events table and its projections
+}
+
+// TableRows counts the rows of an event in a table, which may hold several versions
+// (e.g. the deleted one) until its parts are merged.
+type TableRows struct {
+	Table   string `json:"table"`
+	Rows    uint64 `json:"rows"`
+	Deleted uint64 `json:"deleted"`
+}
+
+// Locate returns where the event with the ID is stored, including its soft-deleted versions
+// that are hidden from the queries. It scans the projections by ID, so it's meant for diagnostics only.
+func (s *Storage) Locate(ctx context.Context, id string) (Location, error) {
+	var loc Location
+	event, receivedAt, deleted, err := s.latestVersion(ctx, id)
+	if err != nil {
+		return loc, err
+	}
+
+	if event != nil {
+		loc.Event = event
+		loc.ReceivedAt = receivedAt
+		loc.Deleted = deleted
+		loc.Tombstoned = s.tombstones.blocks(event)
+	} else {
+		loc.Tombstoned = s.tombstones.blocks(&nostr.Event{ID: id})
+	}
+
+	for _, name := range append([]string{"events"}, ProjectionNames...) {
+		if s.missing[name] {
+			continue
+		}
+
+		query := fmt.Sprintf("SELECT count(), countIf(deleted = 1) FROM %s.%s WHERE id = ?", s.database, name)
+		rows := TableRows{Table: name}
+		if err := s.db.QueryRowContext(ctx, query, id).Scan(&rows.Rows, &rows.Deleted); err != nil {
+			return loc, fmt.Errorf("failed to count the rows of %s: %w", name, err)
+		}
+
+		if rows.Rows > 0 {
+			loc.Tables = append(loc.Tables, rows)
+		}
+	}
+	return loc, nil
+}
+
+// latestVersion returns the latest version of the event with the ID in the events table, or nil if missing.
+func (s *Storage) latestVersion(ctx context.Context, id string) (*nostr.Event, time.Time, bool, error) {
+	query := fmt.Sprintf(`
+		SELECT id, pubkey, created_at, kind, content, sig, toJSONString(tags), relay_received_at, deleted
+		FROM %s.events FINAL
+		WHERE id = ?
+		LIMIT 1
+	`, s.database)
+
+	var event nostr.Event
+	var createdAt, receivedAt uint32
+	var kind uint16
+	var tags string
+	var deleted uint8
+
+	row := s.db.QueryRowContext(ctx, query, id)
+	err := row.Scan(&event.ID, &event.PubKey, &createdAt, &kind, &event.Content, &event.Sig, &tags, &receivedAt, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("failed to query event %s: %w", id, err)
+	}
+
+	event.CreatedAt = nostr.Timestamp(createdAt)
+	event.Kind = int(kind)
+	if err := json.Unmarshal([]byte(tags), &event.Tags); err != nil {
+		event.Tags = nostr.Tags{}
+	}
+
+	events := []nostr.Event{event}
+	if err := s.loadBlobs(ctx, events); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return &events[0], time.Unix(int64(receivedAt), 0), deleted == 1, nil
+}
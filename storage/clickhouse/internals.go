@@ -0,0 +1,133 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Internals are the ClickHouse metrics relevant to the relay, read from the system tables.
+type Internals struct {
+	Tables []TableInternals
+	Disks  []DiskInternals
+}
+
+// TableInternals are the ClickHouse metrics of a table of the relay database.
+type TableInternals struct {
+	Table       string
+	Parts       uint64 // Active parts, which ClickHouse throttles inserts above a few hundred per partition
+	Rows        uint64
+	BytesOnDisk uint64
+	Merges      uint64 // Merges in progress
+
+	// ReplicationDelay and ReplicationQueue are the delay and the tasks in queue
+	// of the replica, zero for non-replicated tables.
+	ReplicationDelay time.Duration
+	ReplicationQueue uint64
+}
+
+// DiskInternals is the space of a disk of the ClickHouse server.
+type DiskInternals struct {
+	Disk       string
+	FreeBytes  uint64
+	TotalBytes uint64
+}
+
+// Internals returns the parts, merges, replication state and disk usage of the tables of the database,
+// from the ClickHouse system tables. It's cheap enough to be called every few seconds.
+func (s *Storage) Internals(ctx context.Context) (Internals, error) {
+	var internals Internals
+	tables := make(map[string]*TableInternals)
+	table := func(name string) *TableInternals {
+		if _, ok := tables[name]; !ok {
+			tables[name] = &TableInternals{Table: name}
+		}
+		return tables[name]
+	}
+
+	query := `
+		SELECT table, count(), sum(rows), sum(bytes_on_disk)
+		FROM system.parts
+		WHERE database = ? AND active
+		GROUP BY table
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return internals, fmt.Errorf("failed to query the parts: %w", err)
+	}
+
+	for rows.Next() {
+		var name string
+		var parts, count, bytes uint64
+		if err := rows.Scan(&name, &parts, &count, &bytes); err != nil {
+			rows.Close()
+			return internals, fmt.Errorf("failed to scan the parts: %w", err)
+		}
+
+		t := table(name)
+		t.Parts, t.Rows, t.BytesOnDisk = parts, count, bytes
+	}
+	rows.Close()
+
+	query = "SELECT table, count() FROM system.merges WHERE database = ? GROUP BY table"
+	rows, err = s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return internals, fmt.Errorf("failed to query the merges: %w", err)
+	}
+
+	for rows.Next() {
+		var name string
+		var merges uint64
+		if err := rows.Scan(&name, &merges); err != nil {
+			rows.Close()
+			return internals, fmt.Errorf("failed to scan the merges: %w", err)
+		}
+		table(name).Merges = merges
+	}
+	rows.Close()
+
+	query = "SELECT table, absolute_delay, queue_size FROM system.replicas WHERE database = ?"
+	rows, err = s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return internals, fmt.Errorf("failed to query the replicas: %w", err)
+	}
+
+	for rows.Next() {
+		var name string
+		var delay uint64
+		var queue uint32
+		if err := rows.Scan(&name, &delay, &queue); err != nil {
+			rows.Close()
+			return internals, fmt.Errorf("failed to scan the replicas: %w", err)
+		}
+
+		t := table(name)
+		t.ReplicationDelay = time.Duration(delay) * time.Second
+		t.ReplicationQueue = uint64(queue)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, "SELECT name, free_space, total_space FROM system.disks")
+	if err != nil {
+		return internals, fmt.Errorf("failed to query the disks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DiskInternals
+		if err := rows.Scan(&d.Disk, &d.FreeBytes, &d.TotalBytes); err != nil {
+			return internals, fmt.Errorf("failed to scan the disks: %w", err)
+		}
+		internals.Disks = append(internals.Disks, d)
+	}
+
+	for _, t := range tables {
+		internals.Tables = append(internals.Tables, *t)
+	}
+
+	slices.SortFunc(internals.Tables, func(a, b TableInternals) int { return strings.Compare(a.Table, b.Table) })
+	return internals, rows.Err()
+}
@@ -0,0 +1,78 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/nostr-net/rely"
+)
+
+// ErrQueryLimit wraps the errors of the filter and count queries that exceeded the [QueryLimits].
+// The REQs and COUNTs are closed as too expensive, see [rely.ErrQueryTooExpensive].
+var ErrQueryLimit = fmt.Errorf("%w: query exceeded the limits", rely.ErrQueryTooExpensive)
+
+// ClickHouse exception codes of the queries exceeding their limits.
+const (
+	codeTooManyRows         = 158
+	codeTimeoutExceeded     = 159
+	codeMemoryLimitExceeded = 241
+)
+
+// QueryLimits are the limits of each filter, count and sample query, applied by ClickHouse as settings of the query,
+// so that a single pathological filter fails instead of exhausting the memory or the CPU of a shared cluster.
+// The zero values don't limit.
+type QueryLimits struct {
+	MaxMemoryUsage   uint64        // bytes of memory used by the query (max_memory_usage)
+	MaxRowsToRead    uint64        // rows read from the tables, before filtering (max_rows_to_read)
+	MaxExecutionTime time.Duration // duration of the query (max_execution_time)
+}
+
+// settings returns the ClickHouse settings of the limits, nil if there are none. The execution time is not
+// among them: the driver derives max_execution_time from the deadline of the context, see [Storage.limit].
+func (l QueryLimits) settings() ch.Settings {
+	settings := ch.Settings{}
+	if l.MaxMemoryUsage > 0 {
+		settings["max_memory_usage"] = l.MaxMemoryUsage
+	}
+	if l.MaxRowsToRead > 0 {
+		settings["max_rows_to_read"] = l.MaxRowsToRead
+	}
+
+	if len(settings) == 0 {
+		return nil
+	}
+	return settings
+}
+
+// limit returns the context of a query applying the limits. The execution time is applied as a deadline,
+// which the driver sends as the max_execution_time setting, and enforces by cancelling the query.
+func (s *Storage) limit(ctx context.Context) (context.Context, context.CancelFunc) {
+	if settings := s.limits.settings(); settings != nil {
+		ctx = ch.Context(ctx, ch.WithSettings(settings))
+	}
+
+	if s.limits.MaxExecutionTime > 0 {
+		return context.WithTimeout(ctx, s.limits.MaxExecutionTime)
+	}
+	return ctx, func() {}
+}
+
+// limitError wraps the error of a query with [ErrQueryLimit] if the query exceeded the limits.
+// The parent is the context of the query before [Storage.limit], to tell its own cancellation apart.
+func limitError(parent context.Context, err error) error {
+	var exception *ch.Exception
+	if errors.As(err, &exception) {
+		switch exception.Code {
+		case codeTooManyRows, codeTimeoutExceeded, codeMemoryLimitExceeded:
+			return fmt.Errorf("%w: %w", ErrQueryLimit, err)
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil {
+		return fmt.Errorf("%w: %w", ErrQueryLimit, err)
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+package clickhouse
+
+import "embed"
+
+// Migrations holds the SQL files creating the schema, to be applied in lexical order.
+// They are executed by the ClickHouse docker image when mounted in /docker-entrypoint-initdb.d.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,13 @@
+-- Write permission grants issued by the registration flow (captcha, Lightning payment)
+-- Shared by all relay instances, which periodically load the active grants
+
+CREATE TABLE IF NOT EXISTS nostr.write_grants
+(
+    pubkey          FixedString(64),        -- Pubkey granted write permission
+    method          LowCardinality(String), -- How the grant was obtained (captcha, lightning)
+    expires_at      UInt32,                 -- Unix timestamp when the grant expires
+    created_at      UInt32                  -- Unix timestamp when the grant was issued
+)
+ENGINE = ReplacingMergeTree(created_at)
+ORDER BY (pubkey, expires_at)
+TTL toDateTime(expires_at) + INTERVAL 30 DAY;
@@ -0,0 +1,21 @@
+-- Sampled, anonymized log of the filter queries served by the relay
+-- Used to see which query patterns dominate and to tune the table routing
+
+CREATE TABLE IF NOT EXISTS nostr.query_log
+(
+    timestamp       DateTime64(3),          -- When the query was served
+    shape           LowCardinality(String), -- Fields used by the filter, e.g. "authors,kinds=1|6,limit"
+    table_name      LowCardinality(String), -- Table the query was routed to
+    kinds           Array(UInt16),          -- Kinds requested (not personal, so kept)
+    ids             UInt16,                 -- Number of IDs in the filter
+    authors         UInt16,                 -- Number of authors in the filter
+    tags            UInt16,                 -- Number of tag values in the filter
+    filter_limit    UInt32,                 -- Limit of the filter (0 if unset)
+    results         UInt32,                 -- Number of events returned
+    duration_ms     Float32,                -- Query latency in milliseconds
+    failed          UInt8                   -- 1 if the query failed
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (shape, timestamp)
+TTL toDateTime(timestamp) + INTERVAL 30 DAY;
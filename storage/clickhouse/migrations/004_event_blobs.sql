@@ -0,0 +1,12 @@
+-- Contents of the events larger than the configured blob threshold (e.g. long-form kind 30023)
+-- The events table and its views keep a short marker instead, so feed scans stay small
+
+CREATE TABLE IF NOT EXISTS nostr.event_blobs
+(
+    id              FixedString(64),            -- Event ID
+    content         String CODEC(ZSTD(3)),      -- Full content of the event
+    created_at      UInt32                      -- Unix timestamp of the event
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY id
+SETTINGS index_granularity = 1024;
@@ -0,0 +1,18 @@
+-- Connection sessions of the clients, for after-the-fact abuse investigations
+-- Only written if enabled; the retention is configurable (default 90 days)
+
+CREATE TABLE IF NOT EXISTS nostr.sessions
+(
+    connected_at    DateTime64(3),          -- When the client connected
+    duration_ms     UInt64,                 -- How long the client stayed connected
+    ip              String,                 -- IP address of the client
+    pubkey          String,                 -- Pubkey the client authenticated with (NIP-42), empty if none
+    messages        UInt64,                 -- Messages received from the client
+    rejections      UInt64,                 -- Messages of the client that were rejected
+    bytes_sent      UInt64,                 -- Bytes sent to the client
+    bytes_received  UInt64                  -- Bytes received from the client
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMMDD(connected_at)
+ORDER BY (ip, connected_at)
+TTL toDateTime(connected_at) + INTERVAL 90 DAY;
@@ -0,0 +1,3 @@
+-- Country of the IP of the sessions, resolved with GeoIP (empty if unknown or disabled)
+
+ALTER TABLE nostr.sessions ADD COLUMN IF NOT EXISTS country LowCardinality(String) AFTER ip;
@@ -0,0 +1,12 @@
+-- Events pinned by the operator (announcements, community rules), returned first to the REQs they match
+-- Kept apart from the events table, so they are never expired, deleted or dropped by its TTL
+
+CREATE TABLE IF NOT EXISTS nostr.pinned_events
+(
+    id              FixedString(64),        -- ID of the pinned event
+    event           String,                 -- The full event as JSON
+    pinned          UInt8,                  -- 1 if pinned, 0 if unpinned
+    updated_at      DateTime64(3)           -- When the event was pinned or unpinned
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
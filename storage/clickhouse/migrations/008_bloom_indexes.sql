@@ -0,0 +1,26 @@
+-- Bloom filter skipping indexes on the columns of the projections that are not in their sorting keys,
+-- so that the point lookups on them (e.g. the authors of a feed of kinds) skip most of the granules.
+-- The MATERIALIZE statements build the indexes of the existing parts, in the background.
+
+ALTER TABLE nostr.events_by_author
+    ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events_by_kind
+    ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4,
+    ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events_by_tag_p
+    ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4,
+    ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events_by_tag_e
+    ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4,
+    ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events_by_author MATERIALIZE INDEX idx_id;
+ALTER TABLE nostr.events_by_kind MATERIALIZE INDEX idx_id;
+ALTER TABLE nostr.events_by_kind MATERIALIZE INDEX idx_pubkey;
+ALTER TABLE nostr.events_by_tag_p MATERIALIZE INDEX idx_id;
+ALTER TABLE nostr.events_by_tag_p MATERIALIZE INDEX idx_pubkey;
+ALTER TABLE nostr.events_by_tag_e MATERIALIZE INDEX idx_id;
+ALTER TABLE nostr.events_by_tag_e MATERIALIZE INDEX idx_pubkey;
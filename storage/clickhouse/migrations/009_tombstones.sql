@@ -0,0 +1,12 @@
+-- Tombstones of the deleted events, so that they can't be inserted again (e.g. re-broadcast by another relay)
+-- The key is the ID of a deleted event, or the address "kind:pubkey:d" of a deleted replaceable event,
+-- whose versions created until the deletion are blocked
+
+CREATE TABLE IF NOT EXISTS nostr.tombstones
+(
+    key             String,                 -- ID or address of the deleted event
+    until           UInt32,                 -- Versions of the address created until then are deleted (0 for IDs)
+    deleted_at      DateTime                -- When the event was deleted
+)
+ENGINE = ReplacingMergeTree(until)
+ORDER BY key;
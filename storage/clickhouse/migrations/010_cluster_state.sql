@@ -0,0 +1,16 @@
+-- State shared by the relay instances of a cluster: bans, exemptions and reputation penalties
+-- Each instance appends its changes and periodically loads those of the others
+
+CREATE TABLE IF NOT EXISTS nostr.cluster_state
+(
+    id              String,                 -- Random ID of the entry
+    origin          LowCardinality(String), -- Instance that published the entry
+    kind            LowCardinality(String), -- ban, allow, lift or penalty
+    key             String,                 -- Pubkey or IP
+    amount          Float64,                -- Penalty of the IP
+    until           DateTime,               -- When the entry stops being relevant
+    created_at      DateTime64(3) DEFAULT now64(3) -- Set by the server, so that all instances share its clock
+)
+ENGINE = MergeTree()
+ORDER BY created_at
+TTL until + INTERVAL 1 DAY;
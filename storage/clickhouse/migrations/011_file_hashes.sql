@@ -0,0 +1,13 @@
+-- Content hashes of the files (the "x" tags, e.g. of the NIP-94 file metadata events of kind 1063),
+-- so that the clients can look up the files by hash with the "#x" filters.
+-- The column is computed from the tags, so the inserts don't change, and the bloom filter index
+-- skips the granules without the hashes. Without them, the "#x" filters scan the tags.
+-- The MATERIALIZE statements compute the column and build the index of the existing parts, in the background.
+
+ALTER TABLE nostr.events
+    ADD COLUMN IF NOT EXISTS tag_x Array(String)
+        MATERIALIZED arrayMap(t -> t[2], arrayFilter(t -> length(t) > 1 AND t[1] = 'x', tags)) AFTER tag_r,
+    ADD INDEX IF NOT EXISTS idx_tag_x tag_x TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events MATERIALIZE COLUMN tag_x;
+ALTER TABLE nostr.events MATERIALIZE INDEX idx_tag_x;
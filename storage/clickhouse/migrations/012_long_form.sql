@@ -0,0 +1,42 @@
+-- Projection of the NIP-23 long-form articles and drafts (kinds 30023 and 30024), sorted by their address,
+-- so that the blog clients fetch an article by author and d tag, or all the articles of an author,
+-- without scanning the other kinds of the author. The published_at column is the date of the
+-- published_at tag, which the edits of an article keep: the queries routed here are sorted by it.
+-- The existing articles are copied with: nostr-relay projections rebuild events_by_address
+
+CREATE TABLE IF NOT EXISTS nostr.events_by_address
+(
+    kind            UInt16,
+    pubkey          FixedString(64),
+    tag_d           String,
+    created_at      UInt32,
+    published_at    UInt32,                 -- published_at tag, or created_at without it
+    id              FixedString(64),
+    content         String,
+    tags            Array(Array(String)),
+    sig             FixedString(128),
+    tag_e           Array(FixedString(64)),
+    tag_p           Array(FixedString(64)),
+    tag_t           Array(String),
+    relay_received_at UInt32,
+    deleted         UInt8,
+    version         UInt32
+)
+ENGINE = ReplacingMergeTree(version)
+PARTITION BY toYYYYMM(toDateTime(created_at))
+PRIMARY KEY (kind, pubkey, tag_d)
+ORDER BY (kind, pubkey, tag_d, created_at, id)
+SETTINGS index_granularity = 8192;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS nostr.events_by_address_mv TO nostr.events_by_address
+AS SELECT
+    kind, pubkey, tag_d, created_at,
+    if(toUInt32OrZero(arrayElement(arrayFirst(t -> length(t) > 1 AND t[1] = 'published_at', tags), 2)) > 0,
+       toUInt32OrZero(arrayElement(arrayFirst(t -> length(t) > 1 AND t[1] = 'published_at', tags), 2)),
+       created_at) AS published_at,
+    id, content, tags, sig, tag_e, tag_p, tag_t, relay_received_at, deleted, version
+FROM nostr.events
+WHERE kind IN (30023, 30024);
+
+ALTER TABLE nostr.events_by_address
+    ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4;
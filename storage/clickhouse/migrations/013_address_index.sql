@@ -0,0 +1,9 @@
+-- Bloom filter skipping index on the addresses referenced by the events (the "a" tags), e.g. by the NIP-51
+-- lists, the NIP-58 badge awards and the comments of articles, so that the "#a" filters skip most of the granules.
+-- The projections don't have the tag_a column, so these filters are always served by the events table.
+-- The MATERIALIZE statement builds the index of the existing parts, in the background.
+
+ALTER TABLE nostr.events
+    ADD INDEX IF NOT EXISTS idx_tag_a tag_a TYPE bloom_filter(0.01) GRANULARITY 4;
+
+ALTER TABLE nostr.events MATERIALIZE INDEX idx_tag_a;
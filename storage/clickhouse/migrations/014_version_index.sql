@@ -0,0 +1,28 @@
+-- Minmax skipping indexes on the version of the rows, i.e. when they were inserted or deleted, so that the
+-- reconciliation of the projections with the events table only reads the granules written within its window.
+-- The MATERIALIZE statements build the indexes of the existing parts, in the background.
+
+ALTER TABLE nostr.events
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events_by_author
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events_by_kind
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events_by_tag_p
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events_by_tag_e
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events_by_address
+    ADD INDEX IF NOT EXISTS idx_version version TYPE minmax GRANULARITY 1;
+
+ALTER TABLE nostr.events MATERIALIZE INDEX idx_version;
+ALTER TABLE nostr.events_by_author MATERIALIZE INDEX idx_version;
+ALTER TABLE nostr.events_by_kind MATERIALIZE INDEX idx_version;
+ALTER TABLE nostr.events_by_tag_p MATERIALIZE INDEX idx_version;
+ALTER TABLE nostr.events_by_tag_e MATERIALIZE INDEX idx_version;
+ALTER TABLE nostr.events_by_address MATERIALIZE INDEX idx_version;
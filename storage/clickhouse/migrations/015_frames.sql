@@ -0,0 +1,15 @@
+-- Websocket frames of the sampled connections, recorded for diagnosing interoperability bugs
+-- Only written if enabled; kept for 7 days
+
+CREATE TABLE IF NOT EXISTS nostr.frames
+(
+    time        DateTime64(6),              -- When the frame was read or written
+    client      String,                     -- UID of the connection
+    ip          String,                     -- IP address of the client
+    direction   LowCardinality(String),     -- "in" if read from the client, "out" if written to it
+    data        String                      -- The frame, with the pubkeys redacted if enabled
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMMDD(time)
+ORDER BY (client, time)
+TTL toDateTime(time) + INTERVAL 7 DAY;
@@ -0,0 +1,232 @@
+package clickhouse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// negativeEntry is a filter that recently matched no events.
+type negativeEntry struct {
+	filter  nostr.Filter
+	expires time.Time
+}
+
+// negativeCache remembers the filters that recently matched no events, because clients
+// re-poll aggressively for the profiles and relay lists of unknown pubkeys.
+// Entries are invalidated when a matching event is inserted.
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]negativeEntry
+
+	byAuthor map[string]map[string]struct{} // keys of the entries by author
+	others   map[string]struct{}            // keys of the entries without authors
+
+	lastWrite    map[string]time.Time // last insert of each author
+	lastAnyWrite time.Time            // last insert of any event
+
+	hits atomic.Uint64
+}
+
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	return &negativeCache{
+		ttl:       ttl,
+		size:      size,
+		entries:   make(map[string]negativeEntry, size),
+		byAuthor:  make(map[string]map[string]struct{}),
+		others:    make(map[string]struct{}),
+		lastWrite: make(map[string]time.Time),
+	}
+}
+
+// isCacheable reports whether an empty result of the filter can be cached.
+func isCacheable(filter nostr.Filter) bool {
+	return !filter.LimitZero && filter.Search == ""
+}
+
+// filterKey returns the hash of the canonical form of the filter.
+func filterKey(filter nostr.Filter) string {
+	var b strings.Builder
+	write := func(name string, values []string) {
+		values = slices.Sorted(slices.Values(values))
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(values, ","))
+		b.WriteByte(';')
+	}
+
+	write("ids", filter.IDs)
+	write("authors", filter.Authors)
+
+	kinds := make([]string, len(filter.Kinds))
+	for i, k := range filter.Kinds {
+		kinds[i] = strconv.Itoa(k)
+	}
+	write("kinds", kinds)
+
+	keys := make([]string, 0, len(filter.Tags))
+	for key := range filter.Tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		write("#"+key, filter.Tags[key])
+	}
+
+	if filter.Since != nil {
+		write("since", []string{strconv.FormatInt(int64(*filter.Since), 10)})
+	}
+	if filter.Until != nil {
+		write("until", []string{strconv.FormatInt(int64(*filter.Until), 10)})
+	}
+	write("limit", []string{strconv.Itoa(filter.Limit)})
+
+	hash := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// isEmpty reports whether the filter recently matched no events.
+func (c *negativeCache) isEmpty(filter nostr.Filter) bool {
+	key := filterKey(filter)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(entry.expires) {
+		c.remove(key)
+		return false
+	}
+
+	c.hits.Add(1)
+	return true
+}
+
+// add records that the filter, queried at the given time, matched no events.
+// It's skipped if a possibly matching event was inserted since, because the query may have missed it.
+func (c *negativeCache) add(filter nostr.Filter, queriedAt time.Time) {
+	key := filterKey(filter)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(filter.Authors) > 0 {
+		for _, author := range filter.Authors {
+			if !c.lastWrite[author].Before(queriedAt) {
+				return
+			}
+		}
+	} else if !c.lastAnyWrite.Before(queriedAt) {
+		return
+	}
+
+	if len(c.entries) >= c.size {
+		c.evictExpired()
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	if _, ok := c.entries[key]; ok {
+		c.remove(key)
+	}
+
+	c.entries[key] = negativeEntry{filter: filter, expires: time.Now().Add(c.ttl)}
+	if len(filter.Authors) == 0 {
+		c.others[key] = struct{}{}
+		return
+	}
+
+	for _, author := range filter.Authors {
+		keys, ok := c.byAuthor[author]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.byAuthor[author] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// invalidate removes the entries matching the inserted events.
+func (c *negativeCache) invalidate(events []*nostr.Event) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, event := range events {
+		c.lastWrite[event.PubKey] = now
+
+		for key := range c.byAuthor[event.PubKey] {
+			if c.entries[key].filter.Matches(event) {
+				c.remove(key)
+			}
+		}
+
+		for key := range c.others {
+			if c.entries[key].filter.Matches(event) {
+				c.remove(key)
+			}
+		}
+	}
+	c.lastAnyWrite = now
+
+	if len(c.lastWrite) > 2*c.size {
+		// writes older than the ttl can't race with the queries of the cached entries
+		for author, at := range c.lastWrite {
+			if now.Sub(at) > c.ttl {
+				delete(c.lastWrite, author)
+			}
+		}
+	}
+}
+
+// evictExpired removes the expired entries.
+func (c *negativeCache) evictExpired() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			c.remove(key)
+		}
+	}
+}
+
+// remove the entry and its references. It must be called with the mutex held.
+func (c *negativeCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, key)
+	delete(c.others, key)
+	for _, author := range entry.filter.Authors {
+		if keys, ok := c.byAuthor[author]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.byAuthor, author)
+			}
+		}
+	}
+}
+
+// NegativeCacheHits returns the number of filters answered by the cache of empty results.
+func (s *Storage) NegativeCacheHits() uint64 {
+	if s.negative == nil {
+		return 0
+	}
+	return s.negative.hits.Load()
+}
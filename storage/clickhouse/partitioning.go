@@ -0,0 +1,84 @@
+package clickhouse
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Partitioning is the strategy used to partition the event tables.
+type Partitioning string
+
+const (
+	// PartitionByMonth creates a partition per month, so old events can be dropped cheaply.
+	PartitionByMonth Partitioning = "month"
+
+	// PartitionByKind creates a partition per bucket of 10000 kinds (regular, replaceable,
+	// ephemeral, addressable...), keeping the number of partitions small.
+	PartitionByKind Partitioning = "kind"
+
+	// PartitionByMonthAndKind combines the two, for big relays with different retentions per kind range.
+	PartitionByMonthAndKind Partitioning = "month_kind"
+
+	// PartitionNone doesn't partition the tables, which suits small relays.
+	PartitionNone Partitioning = "none"
+)
+
+// partitionedTables are the event tables whose partitioning is configured by [Partitioning].
+var partitionedTables = []string{"events", "events_by_author", "events_by_kind", "events_by_tag_p", "events_by_tag_e", "events_by_address"}
+
+// Validate returns an error if the partitioning strategy is unknown
+func (p Partitioning) Validate() error {
+	switch p {
+	case PartitionByMonth, PartitionByKind, PartitionByMonthAndKind, PartitionNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown partitioning %q, must be one of month, kind, month_kind or none", p)
+	}
+}
+
+// expression returns the partition expression of the strategy, or an empty string if none.
+func (p Partitioning) expression() string {
+	switch p {
+	case PartitionByKind:
+		return "intDiv(kind, 10000)"
+	case PartitionByMonthAndKind:
+		return "(toYYYYMM(toDateTime(created_at)), intDiv(kind, 10000))"
+	case PartitionNone:
+		return ""
+	default:
+		return "toYYYYMM(toDateTime(created_at))"
+	}
+}
+
+var createTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS \w+\.(\w+)`)
+
+// Schema rewrites the partition expressions of the event tables of a migration.
+// It's used to generate the schema of new deployments, see [Migrations].
+// Existing tables can't change their partitioning without being recreated.
+func (p Partitioning) Schema(sql string) string {
+	lines := strings.Split(sql, "\n")
+	result := make([]string, 0, len(lines))
+	var table string
+
+	for _, line := range lines {
+		if m := createTable.FindStringSubmatch(line); m != nil {
+			table = m[1]
+		}
+
+		if strings.HasPrefix(line, "PARTITION BY ") && slices.Contains(partitionedTables, table) {
+			expression := p.expression()
+			if expression == "" {
+				continue
+			}
+			line = "PARTITION BY " + expression
+		}
+
+		if strings.Contains(line, ";") {
+			table = ""
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
@@ -0,0 +1,74 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PinEvent stores the event as pinned
+func (s *Storage) PinEvent(ctx context.Context, e nostr.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event %s: %w", e.ID, err)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s.pinned_events (id, event, pinned, updated_at)
+		VALUES (?, ?, 1, ?)
+	`, s.database)
+
+	if _, err := s.db.ExecContext(ctx, query, e.ID, string(data), time.Now()); err != nil {
+		return fmt.Errorf("failed to pin event %s: %w", e.ID, err)
+	}
+	return nil
+}
+
+// UnpinEvent marks the event with the ID as unpinned
+func (s *Storage) UnpinEvent(ctx context.Context, id string) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s.pinned_events (id, event, pinned, updated_at)
+		VALUES (?, '', 0, ?)
+	`, s.database)
+
+	if _, err := s.db.ExecContext(ctx, query, id, time.Now()); err != nil {
+		return fmt.Errorf("failed to unpin event %s: %w", id, err)
+	}
+	return nil
+}
+
+// PinnedEvents returns the pinned events, most recently pinned first
+func (s *Storage) PinnedEvents(ctx context.Context) ([]nostr.Event, error) {
+	query := fmt.Sprintf(`
+		SELECT argMax(event, updated_at) AS event
+		FROM %s.pinned_events
+		GROUP BY id
+		HAVING argMax(pinned, updated_at) = 1
+		ORDER BY max(updated_at) DESC
+	`, s.database)
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []nostr.Event
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan pinned event: %w", err)
+		}
+
+		var e nostr.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pinned event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
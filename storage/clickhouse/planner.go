@@ -0,0 +1,320 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// plannerStats are the cardinality statistics used to estimate the rows scanned by a query.
+type plannerStats struct {
+	events  float64         // rows in the events table
+	authors float64         // distinct authors
+	oldest  float64         // created_at of the oldest event
+	kinds   map[int]float64 // rows per kind
+	perTagP float64         // average rows per value in the events_by_tag_p table
+	perTagE float64         // average rows per value in the events_by_tag_e table
+}
+
+// Blocks of rows covered by each mark of the bloom filter indexes (index_granularity * GRANULARITY),
+// and their false positive rate.
+const (
+	bloomBlock         = 4 * 8192
+	bloomFalsePositive = 0.01
+)
+
+// candidate is a table that can serve a filter, with the estimated rows it scans.
+type candidate struct {
+	table string
+	rows  float64
+}
+
+// route returns the qualified table that serves the filter.
+//
+// The candidate tables are the ones whose sorting key matches the filter.
+// An operator hint for the shape of the filter takes precedence. Otherwise, if
+// statistics have been collected, the candidate scanning the fewest rows is chosen.
+// Without statistics, the first candidate is chosen, in order: events (by ID, by address
+// or by content hash with the tag_x index), events_by_address (long-form articles), events_by_author,
+// events_by_kind, events_by_tag_p, events_by_tag_e, events.
+func (s *Storage) route(filter nostr.Filter) string {
+	return s.table(s.plan(filter, s.stats.Load()).table)
+}
+
+// plan returns the candidate table that serves the filter, see [Storage.route].
+func (s *Storage) plan(filter nostr.Filter, stats *plannerStats) candidate {
+	candidates := s.candidates(filter, stats)
+
+	if table, ok := s.plannerHints[filterShape(filter)]; ok {
+		if i := slices.IndexFunc(candidates, func(c candidate) bool { return c.table == table }); i >= 0 {
+			return candidates[i]
+		}
+	}
+
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.rows < best.rows {
+			best = c
+		}
+	}
+	return best
+}
+
+// EstimateCost estimates the cost of the filter before it's executed, as the rows scanned by its query:
+// the rows of the table it's routed to, in proportion of the history covered by its time range,
+// plus its limit. Without the planner statistics (see [Config.PlannerInterval]), the cost is the limit.
+func (s *Storage) EstimateCost(filter nostr.Filter) float64 {
+	if len(filter.IDs) > 0 {
+		return float64(len(filter.IDs))
+	}
+
+	limit := float64(queryLimit(filter))
+	stats := s.stats.Load()
+	if stats == nil {
+		return limit
+	}
+	return s.plan(filter, stats).rows*timeFraction(filter, stats, s.window, time.Now()) + limit
+}
+
+// timeFraction returns the fraction of the history of the events covered by the time range of the filter.
+// Unbounded filters are assumed to be served by the implicit window, if any.
+func timeFraction(filter nostr.Filter, stats *plannerStats, window time.Duration, now time.Time) float64 {
+	history := float64(now.Unix()) - stats.oldest
+	if stats.oldest == 0 || history <= 0 {
+		return 1
+	}
+
+	since, until := stats.oldest, float64(now.Unix())
+	if filter.Since != nil {
+		since = max(since, float64(*filter.Since))
+	} else if window > 0 && isUnbounded(filter) {
+		since = max(since, float64(now.Add(-window).Unix()))
+	}
+	if filter.Until != nil {
+		until = min(until, float64(*filter.Until))
+	}
+
+	if until <= since {
+		return 0
+	}
+	return min(1, (until-since)/history)
+}
+
+// candidates returns the tables that can serve the filter, in order of preference.
+// The estimated rows are zero if the statistics are nil.
+func (s *Storage) candidates(filter nostr.Filter, stats *plannerStats) []candidate {
+	if len(filter.IDs) > 0 {
+		return []candidate{{table: "events"}}
+	}
+
+	// the content hashes (and the tags of the configured columns) are looked up with the bloom filter index
+	// of their column, which only the events table has
+	if values := s.indexedTags(filter.Tags); values > 0 {
+		return []candidate{{table: "events", rows: float64(values) * bloomBlock}}
+	}
+
+	if stats == nil {
+		stats = &plannerStats{}
+	}
+
+	// the projections don't have the tag_a column, so the addresses are looked up in the events table,
+	// with the bloom filter index on tag_a if any
+	if addresses := filter.Tags["a"]; len(addresses) > 0 {
+		if s.bloom["events.tag_a"] {
+			return []candidate{{table: "events", rows: min(stats.events, float64(len(addresses))*bloomBlock)}}
+		}
+		return []candidate{{table: "events", rows: stats.events}}
+	}
+
+	// Count how many different tag types are requested
+	tagTypes := 0
+	for _, key := range []string{"p", "e", "a", "t", "d"} {
+		if len(filter.Tags[key]) > 0 {
+			tagTypes++
+		}
+	}
+
+	var candidates []candidate
+	if longForm(filter) && !s.missing["events_by_address"] {
+		// the articles of the authors are contiguous in the sorting key, after their kind
+		var rows float64
+		for _, kind := range filter.Kinds {
+			rows += stats.kinds[kind]
+		}
+		if len(filter.Authors) > 0 {
+			rows *= min(1, float64(len(filter.Authors))/max(stats.authors, 1))
+		}
+		candidates = append(candidates, candidate{table: "events_by_address", rows: rows})
+	}
+
+	if len(filter.Authors) > 0 && !s.missing["events_by_author"] {
+		candidates = append(candidates, candidate{
+			table: "events_by_author",
+			rows:  float64(len(filter.Authors)) * stats.events / max(stats.authors, 1),
+		})
+	}
+
+	if len(filter.Kinds) > 0 && !s.missing["events_by_kind"] {
+		var rows float64
+		for _, kind := range filter.Kinds {
+			rows += stats.kinds[kind]
+		}
+		candidates = append(candidates, candidate{table: "events_by_kind", rows: rows})
+	}
+
+	// tag-specific tables don't have columns for other tag types
+	if tagTypes == 1 && len(filter.Tags["p"]) > 0 && !s.missing["events_by_tag_p"] {
+		candidates = append(candidates, candidate{
+			table: "events_by_tag_p",
+			rows:  float64(len(filter.Tags["p"])) * stats.perTagP,
+		})
+	}
+
+	if tagTypes == 1 && len(filter.Tags["e"]) > 0 && !s.missing["events_by_tag_e"] {
+		candidates = append(candidates, candidate{
+			table: "events_by_tag_e",
+			rows:  float64(len(filter.Tags["e"])) * stats.perTagE,
+		})
+	}
+
+	// the events table can serve any filter, with a full scan
+	candidates = append(candidates, candidate{table: "events", rows: stats.events})
+
+	// the authors not in the sorting key are looked up with the bloom filter index on pubkey, if any
+	if len(filter.Authors) > 0 {
+		for i, c := range candidates {
+			if c.table != "events_by_author" && s.bloom[c.table+".pubkey"] {
+				candidates[i].rows = bloomRows(c.rows, len(filter.Authors), stats)
+			}
+		}
+	}
+	return candidates
+}
+
+// longForm reports whether the filter only requests NIP-23 long-form articles and drafts (kinds 30023 and 30024),
+// which the events_by_address table can serve, unless it has "#a" tags: the table doesn't have their column.
+func longForm(filter nostr.Filter) bool {
+	if len(filter.Kinds) == 0 || len(filter.Tags["a"]) > 0 {
+		return false
+	}
+	for _, kind := range filter.Kinds {
+		if kind != 30023 && kind != 30024 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomRows estimates the rows scanned in a table of the given rows when the bloom filter index
+// skips the blocks without any of the authors: at most a block per event of the authors,
+// plus the blocks of the false positives.
+func bloomRows(rows float64, authors int, stats *plannerStats) float64 {
+	if stats.authors == 0 {
+		return rows
+	}
+	matched := rows * float64(authors) / stats.authors * bloomBlock
+	return min(rows, matched+rows*bloomFalsePositive)
+}
+
+// loadBloomIndexes records the columns of the event tables with a bloom filter index, as "table.column".
+func (s *Storage) loadBloomIndexes(ctx context.Context) error {
+	query := "SELECT table, expr FROM system.data_skipping_indices WHERE database = ? AND type = 'bloom_filter'"
+	rows, err := s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return fmt.Errorf("failed to query the indexes: %w", err)
+	}
+	defer rows.Close()
+
+	s.bloom = make(map[string]bool)
+	for rows.Next() {
+		var table, expr string
+		if err := rows.Scan(&table, &expr); err != nil {
+			return fmt.Errorf("failed to scan index: %w", err)
+		}
+		s.bloom[table+"."+expr] = true
+	}
+	return rows.Err()
+}
+
+// planner periodically collects the statistics used by [Storage.route].
+func (s *Storage) planner(interval time.Duration) {
+	defer close(s.plannerDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		stats, err := s.collectStats(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("failed to collect the planner statistics: %v", err)
+		} else {
+			s.stats.Store(stats)
+		}
+
+		select {
+		case <-s.stopBatch:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectStats queries the cardinality statistics of the event tables.
+// Approximate functions are used, since only the order of magnitude matters.
+func (s *Storage) collectStats(ctx context.Context) (*plannerStats, error) {
+	stats := &plannerStats{kinds: make(map[int]float64)}
+
+	query := fmt.Sprintf("SELECT count(), uniq(pubkey), min(created_at) FROM %s.events", s.database)
+	var events, authors uint64
+	var oldest uint32
+	if err := s.db.QueryRowContext(ctx, query).Scan(&events, &authors, &oldest); err != nil {
+		return nil, fmt.Errorf("failed to query the events statistics: %w", err)
+	}
+	stats.events = float64(events)
+	stats.authors = float64(authors)
+	stats.oldest = float64(oldest)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT kind, count() FROM %s.events GROUP BY kind", s.database))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the kinds statistics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind uint16
+		var count uint64
+		if err := rows.Scan(&kind, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan the kinds statistics: %w", err)
+		}
+		stats.kinds[int(kind)] = float64(count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, tag := range []struct {
+		table  string
+		column string
+		result *float64
+	}{
+		{table: "events_by_tag_p", column: "tag_p_value", result: &stats.perTagP},
+		{table: "events_by_tag_e", column: "tag_e_value", result: &stats.perTagE},
+	} {
+		if s.missing[tag.table] {
+			continue
+		}
+
+		query := fmt.Sprintf("SELECT count() / greatest(uniq(%s), 1) FROM %s.%s", tag.column, s.database, tag.table)
+		if err := s.db.QueryRowContext(ctx, query).Scan(tag.result); err != nil {
+			return nil, fmt.Errorf("failed to query the %s statistics: %w", tag.table, err)
+		}
+	}
+	return stats, nil
+}
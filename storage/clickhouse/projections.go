@@ -0,0 +1,311 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"strings"
+
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ProjectionNames are the tables that copy the events table with a different sorting key,
+// kept up to date by their materialized views (named after them, with the _mv suffix).
+var ProjectionNames = []string{"events_by_author", "events_by_kind", "events_by_tag_p", "events_by_tag_e", "events_by_address"}
+
+// Projection describes the state of a projection of the events table.
+type Projection struct {
+	Name   string
+	Exists bool   // whether the table exists
+	Active bool   // whether the materialized view exists, keeping the table up to date
+	Rows   uint64 // rows in the active parts
+	Bytes  uint64 // compressed bytes on disk
+}
+
+// Progress reports the advancement of a backfill, partition by partition.
+type Progress struct {
+	Partition string
+	Done      int
+	Total     int
+}
+
+// table returns the qualified name of the table, falling back to the events table
+// if it's a projection that didn't exist when the storage was created.
+func (s *Storage) table(name string) string {
+	if s.missing[name] {
+		name = "events"
+	}
+	return fmt.Sprintf("%s.%s", s.database, name)
+}
+
+// loadMissing records the projections that don't exist, so that queries are routed to the events table.
+func (s *Storage) loadMissing(ctx context.Context) error {
+	projections, err := s.Projections(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.missing = make(map[string]bool)
+	for _, p := range projections {
+		if !p.Exists {
+			log.Printf("projection %s doesn't exist, its queries are routed to the events table", p.Name)
+			s.missing[p.Name] = true
+		}
+	}
+	return nil
+}
+
+// Projections returns the state of the projections of the events table.
+func (s *Storage) Projections(ctx context.Context) ([]Projection, error) {
+	tables := make(map[string]bool)
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM system.tables WHERE database = ?", s.database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		tables[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	projections := make([]Projection, len(ProjectionNames))
+	for i, name := range ProjectionNames {
+		projections[i] = Projection{
+			Name:   name,
+			Exists: tables[name],
+			Active: tables[name+"_mv"],
+		}
+	}
+
+	query := `
+		SELECT table, sum(rows), sum(bytes_on_disk)
+		FROM system.parts
+		WHERE database = ? AND active
+		GROUP BY table
+	`
+
+	parts, err := s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parts: %w", err)
+	}
+	defer parts.Close()
+
+	for parts.Next() {
+		var table string
+		var rows, bytes uint64
+		if err := parts.Scan(&table, &rows, &bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan parts: %w", err)
+		}
+
+		if i := slices.Index(ProjectionNames, table); i >= 0 {
+			projections[i].Rows = rows
+			projections[i].Bytes = bytes
+		}
+	}
+	return projections, parts.Err()
+}
+
+// AddProjection creates the table and the materialized view of the projection, as defined in [Migrations].
+// The transforms are applied to their definitions, e.g. [Compression.Schema] or [Partitioning.Schema].
+// Only new events are copied to it, so call [Storage.Backfill] to copy the existing ones.
+func (s *Storage) AddProjection(ctx context.Context, name string, transforms ...func(string) string) error {
+	table, view, err := s.projectionSchema(name)
+	if err != nil {
+		return err
+	}
+
+	for _, transform := range transforms {
+		table = transform(table)
+	}
+
+	// the transforms may prepend SET statements, which are applied as settings of the query
+	settings := ch.Settings{}
+	for _, statement := range splitStatements(table) {
+		if setting, ok := strings.CutPrefix(statement, "SET "); ok {
+			key, value, _ := strings.Cut(setting, " = ")
+			settings[key] = value
+			continue
+		}
+
+		if _, err := s.db.ExecContext(ch.Context(ctx, ch.WithSettings(settings)), statement); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", name, err)
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, view); err != nil {
+		return fmt.Errorf("failed to create materialized view %s_mv: %w", name, err)
+	}
+	return nil
+}
+
+// DropProjection drops the materialized view and the table of the projection.
+// Running relays keep routing queries to it until they are restarted.
+func (s *Storage) DropProjection(ctx context.Context, name string) error {
+	if !slices.Contains(ProjectionNames, name) {
+		return fmt.Errorf("unknown projection %q", name)
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DROP VIEW IF EXISTS %s.%s_mv", s.database, name)); err != nil {
+		return fmt.Errorf("failed to drop materialized view %s_mv: %w", name, err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", s.database, name)); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", name, err)
+	}
+	return nil
+}
+
+// RebuildProjection empties the projection and backfills it from the events table.
+// Queries routed to it return partial results until the backfill completes.
+func (s *Storage) RebuildProjection(ctx context.Context, name string, progress func(Progress)) error {
+	if !slices.Contains(ProjectionNames, name) {
+		return fmt.Errorf("unknown projection %q", name)
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE %s.%s", s.database, name)); err != nil {
+		return fmt.Errorf("failed to truncate table %s: %w", name, err)
+	}
+	return s.Backfill(ctx, name, progress)
+}
+
+// Backfill copies the existing events into the projection, one partition of the events table at a time.
+// Events already copied by the materialized view are deduplicated by the merges of the projection.
+func (s *Storage) Backfill(ctx context.Context, name string, progress func(Progress)) error {
+	selection, err := s.projectionSelection(name, "_partition_id = ?")
+	if err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s.%s %s", s.database, name, selection)
+
+	partitions, err := s.partitions(ctx, "events")
+	if err != nil {
+		return err
+	}
+
+	for i, partition := range partitions {
+		if _, err := s.db.ExecContext(ctx, insert, partition); err != nil {
+			return fmt.Errorf("failed to backfill partition %s of %s: %w", partition, name, err)
+		}
+
+		if progress != nil {
+			progress(Progress{Partition: partition, Done: i + 1, Total: len(partitions)})
+		}
+	}
+	return nil
+}
+
+// projectionSelection returns the SELECT of the materialized view of the projection, restricted to the rows
+// of the events table matching the condition, which copies them to the projection.
+func (s *Storage) projectionSelection(name, condition string) (string, error) {
+	_, view, err := s.projectionSchema(name)
+	if err != nil {
+		return "", err
+	}
+
+	_, selection, ok := strings.Cut(view, "\nAS ")
+	if !ok {
+		return "", fmt.Errorf("failed to parse the materialized view of %s", name)
+	}
+
+	if strings.Contains(selection, "\nWHERE ") {
+		return selection + " AND " + condition, nil
+	}
+	return selection + " WHERE " + condition, nil
+}
+
+// partitions returns the IDs of the partitions of the table, in order.
+func (s *Storage) partitions(ctx context.Context, table string) ([]string, error) {
+	query := `
+		SELECT DISTINCT partition_id
+		FROM system.parts
+		WHERE database = ? AND table = ? AND active
+		ORDER BY partition_id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, s.database, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []string
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// projectionSchema returns the statements creating the table and the materialized view of the projection,
+// extracted from the embedded [Migrations] and qualified with the database of the storage.
+// The table statements include the ones of later migrations altering it, e.g. adding indexes.
+func (s *Storage) projectionSchema(name string) (table, view string, err error) {
+	if !slices.Contains(ProjectionNames, name) {
+		return "", "", fmt.Errorf("unknown projection %q", name)
+	}
+
+	files, err := fs.Glob(Migrations, "migrations/*.sql")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list the migrations: %w", err)
+	}
+
+	var alters []string
+	for _, file := range files {
+		data, err := fs.ReadFile(Migrations, file)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read the schema: %w", err)
+		}
+
+		for _, statement := range splitStatements(string(data)) {
+			switch {
+			case strings.HasPrefix(statement, "CREATE TABLE IF NOT EXISTS nostr."+name+"\n"):
+				table = statement
+			case strings.HasPrefix(statement, "CREATE MATERIALIZED VIEW IF NOT EXISTS nostr."+name+"_mv "):
+				view = statement
+			case strings.HasPrefix(statement, "ALTER TABLE nostr."+name+"\n"),
+				strings.HasPrefix(statement, "ALTER TABLE nostr."+name+" "):
+				alters = append(alters, statement)
+			}
+		}
+	}
+
+	if table == "" || view == "" {
+		return "", "", fmt.Errorf("projection %s is not defined in the schema", name)
+	}
+
+	table = strings.Join(append([]string{table}, alters...), ";\n\n")
+	table = strings.ReplaceAll(table, "nostr.", s.database+".")
+	view = strings.ReplaceAll(view, "nostr.", s.database+".")
+	return table, view, nil
+}
+
+// splitStatements splits the SQL into its statements, without comments and the trailing semicolons.
+func splitStatements(sql string) []string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -15,10 +16,28 @@ func (s *Storage) queryFilter(ctx context.Context, filter nostr.Filter) ([]nostr
 	// Build optimized query
 	table, query, args := s.buildQuery(filter)
 
-	// Execute query
+	limited, cancel := s.limit(ctx)
+	defer cancel()
+
+	start := time.Now()
+	events, err := s.runQuery(limited, query, args)
+	s.recordQuery(filter, table, len(events), time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("query failed on table %s: %w", table, limitError(ctx, err))
+	}
+
+	if err := s.loadBlobs(ctx, events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// runQuery executes the query and scans the resulting events
+func (s *Storage) runQuery(ctx context.Context, query string, args []interface{}) ([]nostr.Event, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query failed on table %s: %w", table, err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -42,46 +61,21 @@ func (s *Storage) queryFilter(ctx context.Context, filter nostr.Filter) ([]nostr
 // buildQuery constructs an optimized query based on the filter
 // OPTIMIZED: Uses strings.Builder to avoid string concatenation overhead
 func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}) {
-	var table string
+	return s.buildSampledQuery(filter, 1)
+}
+
+// buildSampledQuery constructs the query of the events matching the filter whose hash falls in the rate,
+// in the order of their hash. A rate of 1 selects all the events, newest first, see [Storage.Sample].
+func (s *Storage) buildSampledQuery(filter nostr.Filter, rate float64) (string, string, []interface{}) {
 	var args []interface{}
 
-	// Count how many different tag types are requested
-	tagTypeCount := 0
-	if len(filter.Tags["p"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["e"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["a"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["t"]) > 0 {
-		tagTypeCount++
-	}
-	if len(filter.Tags["d"]) > 0 {
-		tagTypeCount++
-	}
+	// Choose the table whose sorting key scans the fewest rows
+	table := s.route(filter)
 
-	// Choose optimal table based on filter characteristics (PRIMARY KEY routing)
-	// IMPORTANT: Only use tag-specific tables when ONLY that tag type is requested
-	// because tag-specific tables don't have columns for other tag types
-	switch {
-	case len(filter.IDs) > 0:
-		table = fmt.Sprintf("%s.events", s.database)
-	case len(filter.Authors) > 0:
-		table = fmt.Sprintf("%s.events_by_author", s.database)
-	case len(filter.Kinds) > 0:
-		table = fmt.Sprintf("%s.events_by_kind", s.database)
-	case tagTypeCount == 1 && len(filter.Tags["p"]) > 0:
-		// Only use tag_p table if it's the ONLY tag filter
-		table = fmt.Sprintf("%s.events_by_tag_p", s.database)
-	case tagTypeCount == 1 && len(filter.Tags["e"]) > 0:
-		// Only use tag_e table if it's the ONLY tag filter
-		table = fmt.Sprintf("%s.events_by_tag_e", s.database)
-	default:
-		// Fall back to base table for multiple tag types or other cases
-		table = fmt.Sprintf("%s.events", s.database)
+	// The long-form articles are sorted by the date they were first published, which their edits keep
+	order, sortColumns := "created_at DESC", ""
+	if table == s.table("events_by_address") {
+		order, sortColumns = "published_at DESC, created_at DESC", ", published_at"
 	}
 
 	// Use strings.Builder for efficient string construction
@@ -89,14 +83,27 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 	b.Grow(512) // Pre-allocate typical query size
 
 	// Build SELECT clause - properly return tags as JSON
-	b.WriteString("SELECT id, pubkey, created_at, kind, content, sig, ")
-	b.WriteString("toJSONString(tags) as tags_json FROM ")
-	b.WriteString(table)
-	b.WriteString(" FINAL")
+	dedup := s.readMode == ReadDedup
+	if dedup {
+		// The latest version of each event is selected first, and the deleted ones are dropped afterwards
+		b.WriteString("SELECT id, pubkey, created_at, kind, content, sig, tags_json FROM (")
+		b.WriteString("SELECT id, pubkey, created_at, kind, content, sig, ")
+		b.WriteString("toJSONString(tags) AS tags_json, deleted")
+		b.WriteString(sortColumns)
+		b.WriteString(" FROM ")
+		b.WriteString(table)
+	} else {
+		b.WriteString("SELECT id, pubkey, created_at, kind, content, sig, ")
+		b.WriteString("toJSONString(tags) as tags_json FROM ")
+		b.WriteString(table)
+		b.WriteString(" FINAL")
+	}
 
 	// Build WHERE conditions
 	var conditions []string
-	conditions = append(conditions, "deleted = 0")
+	if !dedup {
+		conditions = append(conditions, "deleted = 0")
+	}
 
 	// ID filter
 	if len(filter.IDs) > 0 {
@@ -141,7 +148,7 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 
 	// Tag filters
 	if eTags := filter.Tags["e"]; len(eTags) > 0 {
-		if table == fmt.Sprintf("%s.events_by_tag_e", s.database) {
+		if table == s.table("events_by_tag_e") {
 			// Special handling for tag_e table
 			placeholders := make([]string, len(eTags))
 			for i, tag := range eTags {
@@ -157,7 +164,7 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 	}
 
 	if pTags := filter.Tags["p"]; len(pTags) > 0 {
-		if table == fmt.Sprintf("%s.events_by_tag_p", s.database) {
+		if table == s.table("events_by_tag_p") {
 			// Special handling for tag_p table
 			placeholders := make([]string, len(pTags))
 			for i, tag := range pTags {
@@ -182,6 +189,13 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 		args = append(args, tTags)
 	}
 
+	for _, tag := range s.columnTags() {
+		if values := filter.Tags[tag]; len(values) > 0 {
+			conditions = append(conditions, s.tagCondition(table, tag))
+			args = append(args, values)
+		}
+	}
+
 	if dTags := filter.Tags["d"]; len(dTags) > 0 {
 		placeholders := make([]string, len(dTags))
 		for i, tag := range dTags {
@@ -193,8 +207,18 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 
 	// Search filter (full-text search)
 	if filter.Search != "" {
-		conditions = append(conditions, "hasToken(content, ?)")
+		conditions = append(conditions, s.searchCondition())
 		args = append(args, filter.Search)
+		if s.blobThreshold > 0 {
+			args = append(args, filter.Search)
+		}
+	}
+
+	// The hash of the IDs is uniformly distributed, and the order by hash keeps the sample uniform when it's truncated
+	if rate < 1 {
+		conditions = append(conditions, fmt.Sprintf("cityHash64(id) %% %d < ?", sampleResolution))
+		args = append(args, uint64(rate*sampleResolution))
+		order = "cityHash64(id)"
 	}
 
 	// Add WHERE clause using Builder
@@ -204,13 +228,12 @@ func (s *Storage) buildQuery(filter nostr.Filter) (string, string, []interface{}
 	}
 
 	// ORDER BY and LIMIT
-	b.WriteString(" ORDER BY created_at DESC LIMIT ")
-
-	limit := filter.Limit
-	if limit == 0 || limit > 5000 {
-		limit = 5000 // Default/max limit
+	limit := queryLimit(filter)
+	if dedup {
+		fmt.Fprintf(&b, " ORDER BY %s, version DESC LIMIT 1 BY id LIMIT %d) WHERE deleted = 0 ORDER BY %s", order, limit, order)
+	} else {
+		fmt.Fprintf(&b, " ORDER BY %s LIMIT %d", order, limit)
 	}
-	b.WriteString(fmt.Sprintf("%d", limit))
 
 	return table, b.String(), args
 }
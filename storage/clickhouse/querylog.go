@@ -0,0 +1,262 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// queryRecord is the anonymized record of a single filter query.
+// It contains the shape of the filter, but none of its values (except kinds).
+type queryRecord struct {
+	timestamp time.Time
+	shape     string
+	table     string
+	kinds     []uint16
+	ids       uint16
+	authors   uint16
+	tags      uint16
+	limit     uint32
+	results   uint32
+	duration  time.Duration
+	failed    bool
+}
+
+// QueryPattern summarizes the queries sharing the same filter shape
+type QueryPattern struct {
+	Shape      string        // Anonymized shape of the filter, e.g. "authors,kinds=1|6,limit"
+	Table      string        // Table most used to serve the shape
+	Queries    uint64        // Number of sampled queries
+	AvgResults float64       // Average number of events returned
+	P50        time.Duration // Median latency
+	P95        time.Duration // 95th percentile latency
+	Failed     uint64        // Number of failed queries
+}
+
+// filterShape returns the anonymized shape of the filter, listing which fields are used.
+// Kinds are included because they are not personal and drive the table routing.
+func filterShape(filter nostr.Filter) string {
+	var parts []string
+	if len(filter.IDs) > 0 {
+		parts = append(parts, "ids")
+	}
+	if len(filter.Authors) > 0 {
+		parts = append(parts, "authors")
+	}
+	if len(filter.Kinds) > 0 {
+		kinds := slices.Clone(filter.Kinds)
+		slices.Sort(kinds)
+
+		strs := make([]string, len(kinds))
+		for i, k := range kinds {
+			strs[i] = strconv.Itoa(k)
+		}
+		parts = append(parts, "kinds="+strings.Join(strs, "|"))
+	}
+
+	tags := make([]string, 0, len(filter.Tags))
+	for key, values := range filter.Tags {
+		if len(values) > 0 {
+			tags = append(tags, "#"+key)
+		}
+	}
+	slices.Sort(tags)
+	parts = append(parts, tags...)
+
+	if filter.Since != nil {
+		parts = append(parts, "since")
+	}
+	if filter.Until != nil {
+		parts = append(parts, "until")
+	}
+	if filter.Search != "" {
+		parts = append(parts, "search")
+	}
+	if filter.Limit > 0 {
+		parts = append(parts, "limit")
+	}
+
+	if len(parts) == 0 {
+		return "empty"
+	}
+	return strings.Join(parts, ",")
+}
+
+// recordQuery samples the filter query into the query log, without blocking.
+func (s *Storage) recordQuery(filter nostr.Filter, table string, results int, duration time.Duration, err error) {
+	if s.queryLog == nil || rand.Float64() >= s.queryLogSampleRate {
+		return
+	}
+
+	var tags int
+	for _, values := range filter.Tags {
+		tags += len(values)
+	}
+
+	kinds := make([]uint16, len(filter.Kinds))
+	for i, k := range filter.Kinds {
+		kinds[i] = uint16(k)
+	}
+
+	record := queryRecord{
+		timestamp: time.Now(),
+		shape:     filterShape(filter),
+		table:     table,
+		kinds:     kinds,
+		ids:       uint16(min(len(filter.IDs), 65535)),
+		authors:   uint16(min(len(filter.Authors), 65535)),
+		tags:      uint16(min(tags, 65535)),
+		limit:     uint32(filter.Limit),
+		results:   uint32(results),
+		duration:  duration,
+		failed:    err != nil,
+	}
+
+	select {
+	case s.queryLog <- record:
+	default:
+		// the query log is best-effort, records are dropped when it can't keep up
+	}
+}
+
+// queryLogger continuously batches and inserts the query records
+func (s *Storage) queryLogger() {
+	defer close(s.queryLogDone)
+
+	buffer := make([]queryRecord, 0, 1000)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(buffer) == 0 {
+			return
+		}
+
+		if err := s.insertQueryRecords(context.Background(), buffer); err != nil {
+			log.Printf("query log insert error: %v", err)
+		}
+		buffer = buffer[:0]
+	}
+
+	for {
+		select {
+		case <-s.stopBatch:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case record := <-s.queryLog:
+			buffer = append(buffer, record)
+			if len(buffer) >= cap(buffer) {
+				flush()
+			}
+		}
+	}
+}
+
+// insertQueryRecords inserts a batch of query records in a single transaction
+func (s *Storage) insertQueryRecords(ctx context.Context, records []queryRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s.query_log (
+			timestamp, shape, table_name, kinds, ids, authors, tags,
+			filter_limit, results, duration_ms, failed
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.database)
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		var failed uint8
+		if r.failed {
+			failed = 1
+		}
+
+		_, err := stmt.ExecContext(ctx,
+			r.timestamp,
+			r.shape,
+			r.table,
+			r.kinds,
+			r.ids,
+			r.authors,
+			r.tags,
+			r.limit,
+			r.results,
+			float32(r.duration.Seconds()*1000),
+			failed,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert query record: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// QueryLogEnabled reports whether the filter queries are sampled into the query log
+func (s *Storage) QueryLogEnabled() bool {
+	return s.queryLog != nil
+}
+
+// QueryPatterns returns the most frequent filter shapes sampled within the period,
+// useful to see which query patterns dominate and to tune the table routing.
+func (s *Storage) QueryPatterns(ctx context.Context, period time.Duration, limit int) ([]QueryPattern, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			shape,
+			topK(1)(table_name)[1] AS table_name,
+			count() AS queries,
+			avg(results) AS avg_results,
+			quantile(0.5)(duration_ms) AS p50,
+			quantile(0.95)(duration_ms) AS p95,
+			countIf(failed = 1) AS failed
+		FROM %s.query_log
+		WHERE timestamp >= now() - INTERVAL ? SECOND
+		GROUP BY shape
+		ORDER BY queries DESC
+		LIMIT ?
+	`, s.database)
+
+	rows, err := s.db.QueryContext(ctx, query, uint64(period.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query patterns: %w", err)
+	}
+	defer rows.Close()
+
+	var patterns []QueryPattern
+	for rows.Next() {
+		var p QueryPattern
+		var p50, p95 float64
+
+		if err := rows.Scan(&p.Shape, &p.Table, &p.Queries, &p.AvgResults, &p50, &p95, &p.Failed); err != nil {
+			return nil, fmt.Errorf("failed to scan pattern: %w", err)
+		}
+
+		p.P50 = time.Duration(p50 * float64(time.Millisecond))
+		p.P95 = time.Duration(p95 * float64(time.Millisecond))
+		patterns = append(patterns, p)
+	}
+
+	return patterns, rows.Err()
+}
@@ -0,0 +1,28 @@
+package clickhouse
+
+import "fmt"
+
+// ReadMode is how the queries get rid of the duplicate rows of the ReplacingMergeTree tables
+// (e.g. an event and its deletion) that are waiting to be merged.
+type ReadMode string
+
+const (
+	// ReadFinal queries the tables with FINAL, which merges the rows at read time.
+	// It's always correct, but it's expensive on tables with many unmerged parts.
+	ReadFinal ReadMode = "final"
+
+	// ReadDedup keeps the latest version of each event with LIMIT 1 BY id, and drops the deleted ones afterwards.
+	// It's much cheaper than FINAL on high-QPS relays, but a query can return fewer events than its limit
+	// when some of the most recent matching events have been deleted but not merged yet.
+	ReadDedup ReadMode = "dedup"
+)
+
+// Validate returns an error if the read mode is unknown
+func (m ReadMode) Validate() error {
+	switch m {
+	case "", ReadFinal, ReadDedup:
+		return nil
+	default:
+		return fmt.Errorf("unknown read mode %q, must be final or dedup", m)
+	}
+}
@@ -0,0 +1,187 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Reconciliation reports the rows of a projection that its materialized view failed to copy.
+type Reconciliation struct {
+	Projection string
+	Missing    uint64 // rows of the events table missing from the projection, copied by the reconciliation
+}
+
+// reconcileCondition selects the rows of the events table written within the window (by their version,
+// which is when they were inserted or deleted) that are missing from the projection.
+const reconcileCondition = `version >= ? AND version < ? AND (id, version) NOT IN (
+		SELECT id, version FROM %s.%s WHERE version >= ? AND version < ?
+	)`
+
+// Reconcile copies to the projections the rows of the events table written between since and until
+// that they are missing. An insert into the events table and the ones of the materialized views into the
+// projections are not atomic: if the relay or ClickHouse crashes in between, or a view fails, the row is
+// stored but the queries routed to the projection never return it. Running it periodically over the
+// recent rows, with some lag for the inserts in flight, bounds how long the projections can diverge.
+// The projections that don't exist or whose materialized view was dropped are skipped.
+//
+// Example:
+//
+//	now := time.Now()
+//	repaired, err := storage.Reconcile(ctx, now.Add(-time.Hour), now.Add(-time.Minute))
+func (s *Storage) Reconcile(ctx context.Context, since, until time.Time) ([]Reconciliation, error) {
+	if !since.Before(until) {
+		return nil, fmt.Errorf("the reconciliation window is empty: %s to %s", since, until)
+	}
+
+	projections, err := s.Projections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := uint32(since.Unix()), uint32(until.Unix())
+	var reconciliations []Reconciliation
+
+	for _, p := range projections {
+		if !p.Exists || !p.Active {
+			continue
+		}
+
+		missing, err := s.reconcile(ctx, p.Name, from, to)
+		if err != nil {
+			return reconciliations, err
+		}
+		reconciliations = append(reconciliations, Reconciliation{Projection: p.Name, Missing: missing})
+	}
+	return reconciliations, nil
+}
+
+// reconcile copies to the projection the rows of the events table with a version within [from, to)
+// that it is missing, and returns how many there were.
+func (s *Storage) reconcile(ctx context.Context, name string, from, to uint32) (uint64, error) {
+	selection, err := s.projectionSelection(name, fmt.Sprintf(reconcileCondition, s.database, name))
+	if err != nil {
+		return 0, err
+	}
+	args := []any{from, to, from, to}
+
+	var missing uint64
+	count := fmt.Sprintf("SELECT count() FROM (%s)", selection)
+	if err := s.db.QueryRowContext(ctx, count, args...).Scan(&missing); err != nil {
+		return 0, fmt.Errorf("failed to count the rows missing from %s: %w", name, err)
+	}
+
+	if missing == 0 {
+		return 0, nil
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s.%s %s", s.database, name, selection)
+	if _, err := s.db.ExecContext(ctx, insert, args...); err != nil {
+		return 0, fmt.Errorf("failed to copy the rows missing from %s: %w", name, err)
+	}
+	return missing, nil
+}
+
+// Verification reports the rows of a projection missing among a sample of the events table.
+type Verification struct {
+	Projection string
+	Partition  string // partition of the events table sampled
+	Sampled    uint64 // rows of the sampled events the projection should contain
+	Missing    uint64 // rows of the sampled events missing from the projection, copied by the verification
+}
+
+// verifySample selects the sampled events: up to a number of events of a partition of the events table
+// written before a time, chosen by a seeded hash so that the queries of a verification select the same ones.
+const verifySample = `SELECT id FROM %s.events WHERE _partition_id = ? AND version < ? ORDER BY cityHash64(id, ?) LIMIT ?`
+
+// Verify checks that the projections contain the rows of a sample of the events of a random partition of
+// the events table, written before the given time, and copies the missing ones. Unlike [Storage.Reconcile],
+// which covers the recent rows, it spot-checks the whole history, e.g. rows lost by a projection restored
+// from an older backup or a failed backfill, so that the divergence of the projections is measured.
+// The projections that don't exist or whose materialized view was dropped are skipped.
+//
+// Example:
+//
+//	verifications, err := storage.Verify(ctx, 1000, time.Now().Add(-time.Hour))
+func (s *Storage) Verify(ctx context.Context, sample int, before time.Time) ([]Verification, error) {
+	if sample < 1 {
+		return nil, fmt.Errorf("the sample must be positive, got %d", sample)
+	}
+
+	partitions, err := s.partitions(ctx, "events")
+	if err != nil || len(partitions) == 0 {
+		return nil, err
+	}
+
+	projections, err := s.Projections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partition := partitions[rand.IntN(len(partitions))]
+	sampleArgs := []any{partition, uint32(before.Unix()), rand.Uint64(), sample}
+	var verifications []Verification
+
+	for _, p := range projections {
+		if !p.Exists || !p.Active {
+			continue
+		}
+
+		v, err := s.verify(ctx, p.Name, partition, sampleArgs)
+		if err != nil {
+			return verifications, err
+		}
+		verifications = append(verifications, v)
+	}
+	return verifications, nil
+}
+
+// verify counts the rows of the sampled events that the projection should contain and the missing ones,
+// and copies the latter.
+func (s *Storage) verify(ctx context.Context, name, partition string, sampleArgs []any) (Verification, error) {
+	v := Verification{Projection: name, Partition: partition}
+	count, insert, err := s.verifyQueries(name)
+	if err != nil {
+		return v, err
+	}
+
+	// the arguments of the condition: the partition and time of the sample, then the sample itself
+	args := append(slices.Clone(sampleArgs[:2]), sampleArgs...)
+
+	if err := s.db.QueryRowContext(ctx, count, append(slices.Clone(sampleArgs), args...)...).Scan(&v.Sampled, &v.Missing); err != nil {
+		return v, fmt.Errorf("failed to verify %s: %w", name, err)
+	}
+
+	if v.Missing == 0 {
+		return v, nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, insert, append(args, sampleArgs...)...); err != nil {
+		return v, fmt.Errorf("failed to copy the rows missing from %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// verifyQueries returns the queries counting the rows of the sampled events that the projection should
+// contain and the missing ones, and copying the latter. The rows written since the sampled time,
+// e.g. recent deletions, are ignored.
+func (s *Storage) verifyQueries(name string) (count, insert string, err error) {
+	sample := fmt.Sprintf(verifySample, s.database)
+	stored := fmt.Sprintf("(id, version) IN (SELECT id, version FROM %s.%s WHERE id IN (%s))", s.database, name, sample)
+	condition := "_partition_id = ? AND version < ? AND id IN (" + sample + ")"
+
+	selection, err := s.projectionSelection(name, condition)
+	if err != nil {
+		return "", "", err
+	}
+	count = fmt.Sprintf("SELECT count(), countIf(NOT (%s)) FROM (%s)", stored, selection)
+
+	selection, err = s.projectionSelection(name, condition+" AND NOT ("+stored+")")
+	if err != nil {
+		return "", "", err
+	}
+	insert = fmt.Sprintf("INSERT INTO %s.%s %s", s.database, name, selection)
+	return count, insert, nil
+}
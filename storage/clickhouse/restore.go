@@ -0,0 +1,94 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Restored counts the outcome of the events passed to RestoreEvents.
+type Restored struct {
+	Inserted   int // events inserted, or that would be with a dry run
+	Duplicates int // events already stored, or repeated in the batch
+	Deleted    int // events blocked by tombstones
+}
+
+// RestoreEvents inserts the events that are not stored yet, skipping the duplicates and those deleted,
+// and waits for them to be written. With a dry run, nothing is inserted.
+// The events must have been verified by the caller.
+func (s *Storage) RestoreEvents(ctx context.Context, events []*nostr.Event, dryRun bool) (Restored, error) {
+	var restored Restored
+	unique := make(map[string]struct{}, len(events))
+	candidates := make([]*nostr.Event, 0, len(events))
+
+	for _, e := range events {
+		if _, ok := unique[e.ID]; ok {
+			restored.Duplicates++
+			continue
+		}
+		unique[e.ID] = struct{}{}
+
+		if s.tombstones.blocks(e) {
+			restored.Deleted++
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+
+	if len(candidates) == 0 {
+		return restored, nil
+	}
+
+	stored, err := s.storedIDs(ctx, candidates)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	missing := candidates[:0]
+	for _, e := range candidates {
+		if _, ok := stored[e.ID]; ok {
+			restored.Duplicates++
+			continue
+		}
+		missing = append(missing, e)
+	}
+
+	if len(missing) > 0 && !dryRun {
+		if err := s.batchInsert(ctx, missing); err != nil {
+			return Restored{}, err
+		}
+	}
+
+	restored.Inserted = len(missing)
+	return restored, nil
+}
+
+// storedIDs returns the IDs of the events already stored.
+func (s *Storage) storedIDs(ctx context.Context, events []*nostr.Event) (map[string]struct{}, error) {
+	placeholders := make([]string, len(events))
+	args := make([]any, len(events))
+	for i, e := range events {
+		placeholders[i] = "?"
+		args[i] = e.ID
+	}
+
+	query := fmt.Sprintf("SELECT DISTINCT id FROM %s.events WHERE id IN (%s)", s.database, strings.Join(placeholders, ","))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored events: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]struct{}, len(events))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan stored event: %w", err)
+		}
+		stored[id] = struct{}{}
+	}
+
+	return stored, rows.Err()
+}
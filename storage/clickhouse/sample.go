@@ -0,0 +1,80 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// sampleResolution is the number of buckets of the hashes of the IDs, the precision of the sampling rates.
+const sampleResolution = 1_000_000
+
+// Sample returns a uniform random sample of the events matching the filter, each selected with the probability rate,
+// up to the limit of the filter. The sample is deterministic: the same filter and rate select the same events,
+// and a higher rate selects a superset of them.
+//
+// The events are selected by the hash of their ID rather than with the SAMPLE clause, which needs a sampling key
+// in the primary key of the tables, so the matching rows are still read, but only the sampled ones are sent.
+// The previous versions of the replaceable and addressable events, retained for [Storage.History], are left out.
+func (s *Storage) Sample(ctx context.Context, filter nostr.Filter, rate float64) ([]nostr.Event, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("the sample rate must be in (0, 1], got %f", rate)
+	}
+
+	table, query, args := s.buildSampledQuery(filter, rate)
+
+	limited, cancel := s.limit(ctx)
+	defer cancel()
+
+	start := time.Now()
+	events, err := s.runQuery(limited, query, args)
+	s.recordQuery(filter, table, len(events), time.Since(start), err)
+
+	if err != nil {
+		return nil, fmt.Errorf("sample failed on table %s: %w", table, limitError(ctx, err))
+	}
+
+	events, err = s.currentVersions(ctx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadBlobs(ctx, events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// currentVersions removes in-place the events that are not the newest stored version of their address.
+// Unlike a REQ, a sample can select a previous version of an address without the newest one.
+func (s *Storage) currentVersions(ctx context.Context, events []nostr.Event) ([]nostr.Event, error) {
+	current := make(map[string]version)
+	n := 0
+	for _, e := range events {
+		if address, ok := versionAddress(&e); ok {
+			latest, seen := current[address]
+			if !seen {
+				stored, found, err := s.storedVersion(ctx, &e)
+				if err != nil {
+					return nil, err
+				}
+
+				latest = version{createdAt: e.CreatedAt, id: e.ID}
+				if found {
+					latest = stored
+				}
+				current[address] = latest
+			}
+
+			if latest.id != e.ID {
+				continue
+			}
+		}
+
+		events[n] = e
+		n++
+	}
+	return events[:n], nil
+}
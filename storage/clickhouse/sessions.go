@@ -0,0 +1,137 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nostr-net/rely"
+)
+
+// Session is the metadata of a client connection, recorded when the client disconnects.
+type Session struct {
+	ConnectedAt   time.Time
+	Duration      time.Duration
+	IP            string
+	Country       string // empty if unknown
+	Pubkey        string // empty if the client didn't authenticate
+	Messages      int64
+	Rejections    int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// LogSession records the session of the disconnected client into the sessions table, without blocking.
+// It's meant to be called by the On.Disconnect hook, and does nothing if the session log is disabled.
+func (s *Storage) LogSession(c rely.Client) {
+	if s.sessionLog == nil {
+		return
+	}
+
+	stats := c.Stats()
+	session := Session{
+		ConnectedAt:   c.ConnectedAt(),
+		Duration:      c.Age(),
+		IP:            c.IP(),
+		Country:       c.Country(),
+		Pubkey:        c.Pubkey(),
+		Messages:      stats.MessagesReceived,
+		Rejections:    stats.Rejections,
+		BytesSent:     stats.BytesSent,
+		BytesReceived: stats.BytesReceived,
+	}
+
+	select {
+	case s.sessionLog <- session:
+	default:
+		// the session log is best-effort, sessions are dropped when it can't keep up
+	}
+}
+
+// sessionTTL returns the statement setting the retention of the sessions table.
+func (s *Storage) sessionTTL(retention time.Duration) string {
+	return fmt.Sprintf("ALTER TABLE %s.sessions MODIFY TTL toDateTime(connected_at) + INTERVAL %d SECOND",
+		s.database, int64(retention.Seconds()))
+}
+
+// sessionLogger continuously batches and inserts the sessions
+func (s *Storage) sessionLogger() {
+	defer close(s.sessionLogDone)
+
+	buffer := make([]Session, 0, 1000)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(buffer) == 0 {
+			return
+		}
+
+		if err := s.insertSessions(context.Background(), buffer); err != nil {
+			log.Printf("session log insert error: %v", err)
+		}
+		buffer = buffer[:0]
+	}
+
+	for {
+		select {
+		case <-s.stopBatch:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case session := <-s.sessionLog:
+			buffer = append(buffer, session)
+			if len(buffer) >= cap(buffer) {
+				flush()
+			}
+		}
+	}
+}
+
+// insertSessions inserts a batch of sessions in a single transaction
+func (s *Storage) insertSessions(ctx context.Context, sessions []Session) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s.sessions (
+			connected_at, duration_ms, ip, country, pubkey,
+			messages, rejections, bytes_sent, bytes_received
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.database)
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, session := range sessions {
+		_, err := stmt.ExecContext(ctx,
+			session.ConnectedAt,
+			uint64(session.Duration.Milliseconds()),
+			session.IP,
+			session.Country,
+			session.Pubkey,
+			uint64(session.Messages),
+			uint64(session.Rejections),
+			uint64(session.BytesSent),
+			uint64(session.BytesReceived),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert session: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
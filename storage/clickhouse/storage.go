@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go/v2"
@@ -23,6 +25,60 @@ type Storage struct {
 	batchChan     chan *nostr.Event
 	stopBatch     chan struct{}
 	batchDone     chan struct{}
+
+	// Contents larger than this are stored in the event_blobs table (0 if disabled)
+	blobThreshold int
+
+	// How the queries deduplicate the unmerged rows
+	readMode ReadMode
+
+	// Implicit time window of the unbounded filters (0 if disabled), and the filters queried beyond it
+	window            time.Duration
+	windowEscalations atomic.Uint64
+
+	// Projections that don't exist, whose queries are routed to the events table
+	missing map[string]bool
+
+	// Deleted events, which can't be inserted again
+	tombstones *tombstones
+
+	// Columns with a bloom filter index, as "table.column"
+	bloom map[string]bool
+
+	// Query planner statistics (nil until collected) and operator hints
+	stats        atomic.Pointer[plannerStats]
+	plannerHints map[string]string
+	plannerDone  chan struct{}
+
+	// Merges compatible filters into a single query (nil if disabled)
+	coalescer *coalescer
+
+	// Filters that recently matched no events (nil if disabled)
+	negative *negativeCache
+
+	// Newest versions of the replaceable and addressable events (nil if disabled)
+	versions *versionCache
+
+	// Limits of the filter, count and sample queries
+	limits QueryLimits
+
+	// Tags with a column computed from the tags of the events table, besides the "x" tags
+	tagColumns []string
+
+	// Query log (nil if disabled)
+	queryLog           chan queryRecord
+	queryLogSampleRate float64
+	queryLogDone       chan struct{}
+
+	// Session log (nil if disabled)
+	sessionLog     chan Session
+	sessionLogDone chan struct{}
+
+	// Frame log (nil if disabled)
+	frameLog        chan rely.Frame
+	frameSampleRate float64
+	redactFrames    bool
+	frameLogDone    chan struct{}
 }
 
 // Config holds ClickHouse connection configuration
@@ -37,6 +93,70 @@ type Config struct {
 	// Connection pool settings
 	MaxOpenConns int // Maximum number of open connections (default: 10)
 	MaxIdleConns int // Maximum number of idle connections (default: 5)
+
+	// Fraction of filter queries recorded in the query_log table, between 0 and 1 (default: 0, disabled)
+	QueryLogSampleRate float64
+
+	// Contents larger than this many bytes are stored in the event_blobs table, keeping
+	// the events table and its views small for feed scans (default: 0, disabled)
+	BlobThreshold int
+
+	// How often the cardinality statistics used to choose the table of each query are collected.
+	// When 0, queries are routed by a fixed order of preference (default: 0, disabled)
+	PlannerInterval time.Duration
+
+	// Tables forced for the filters of a given shape, e.g. "authors,kinds=1|6,limit" -> "events_by_kind".
+	// Hints for tables that can't serve the filter are ignored
+	PlannerHints map[string]string
+
+	// Filters of authors and replaceable kinds (e.g. profiles) received within this window
+	// are merged into a single query, cutting the queries during thundering herds.
+	// It delays these queries by up to the window (default: 0, disabled)
+	CoalesceWindow time.Duration
+
+	// How long filters that matched no events are answered without querying, unless a
+	// matching event is inserted in the meantime (default: 0, disabled)
+	NegativeCacheTTL time.Duration
+
+	// Maximum number of filters in the negative cache (default: 10000)
+	NegativeCacheSize int
+
+	// How long the connection sessions logged with [Storage.LogSession] are kept in the
+	// sessions table, for abuse investigations (default: 0, disabled)
+	SessionRetention time.Duration
+
+	// Fraction of connections whose websocket frames are recorded in the frames table, between 0 and 1,
+	// when the storage is set as the recorder of the relay with [rely.WithRecorder] (default: 0, disabled)
+	FrameSampleRate float64
+
+	// Whether the pubkeys in the recorded frames are replaced with a short fingerprint (default: false)
+	RedactFrames bool
+
+	// How the filter and count queries deduplicate the rows waiting to be merged: with FINAL,
+	// or with LIMIT 1 BY id, which is cheaper but can under-fill the limit of the queries (default: final)
+	ReadMode ReadMode
+
+	// Filters without since, until and IDs are first queried for the events of this recent window,
+	// and for the older events only if it doesn't fill their limit, so that typical feed queries
+	// don't scan the whole history (default: 0, disabled)
+	QueryWindow time.Duration
+
+	// Reject the replaceable and addressable events older than the stored version of their address
+	// with [ErrStaleVersion], preventing rollbacks by lagging clients (default: false, disabled)
+	RejectStaleVersions bool
+
+	// Maximum number of addresses whose newest version is remembered, beyond which the stored
+	// version is queried again (default: 100000)
+	VersionCacheSize int
+
+	// Memory, rows and time limits of each filter, count and sample query, which fail with [ErrQueryLimit]
+	// when exceeded (default: unlimited)
+	QueryLimits QueryLimits
+
+	// Tags with a column computed from the tags of the events table, besides the "x" tags, e.g. "i", "L" and "l"
+	// for the NIP-73 external identities and the NIP-32 labels. Their filters are looked up with the bloom filter
+	// index of the column, which is added when the storage is created (default: none)
+	TagColumns []string
 }
 
 // DefaultConfig returns a Config with sensible defaults
@@ -88,6 +208,20 @@ func extractDatabaseFromDSN(dsn string) string {
 
 // NewStorage creates a new ClickHouse storage instance
 func NewStorage(cfg Config) (*Storage, error) {
+	if err := cfg.ReadMode.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateTagColumns(cfg.TagColumns); err != nil {
+		return nil, err
+	}
+
+	for shape, table := range cfg.PlannerHints {
+		if table != "events" && !slices.Contains(ProjectionNames, table) {
+			return nil, fmt.Errorf("invalid planner hint for %q: unknown table %q", shape, table)
+		}
+	}
+
 	// Extract database name from DSN
 	database := extractDatabaseFromDSN(cfg.DSN)
 
@@ -118,11 +252,88 @@ func NewStorage(cfg Config) (*Storage, error) {
 		batchChan:     make(chan *nostr.Event, cfg.BatchSize*2),
 		stopBatch:     make(chan struct{}),
 		batchDone:     make(chan struct{}),
+		blobThreshold: cfg.BlobThreshold,
+		readMode:      cfg.ReadMode,
+		window:        cfg.QueryWindow,
+		limits:        cfg.QueryLimits,
+		tagColumns:    tagColumns(cfg.TagColumns),
+		tombstones:    newTombstones(),
+	}
+
+	if err := storage.loadMissing(ctx); err != nil {
+		log.Printf("failed to check the projections: %v", err)
+	}
+	if err := storage.addTagColumns(ctx, storage.tagColumns); err != nil {
+		log.Printf("failed to add the tag columns: %v", err)
+	}
+	if err := storage.loadBloomIndexes(ctx); err != nil {
+		log.Printf("failed to check the bloom filter indexes: %v", err)
+	}
+	if err := storage.loadTombstones(ctx); err != nil {
+		log.Printf("failed to load the tombstones: %v", err)
 	}
+	storage.plannerHints = cfg.PlannerHints
 
 	// Start batch inserter
 	go storage.batchInserter()
 
+	// Start query logger
+	if cfg.QueryLogSampleRate > 0 {
+		storage.queryLog = make(chan queryRecord, 10000)
+		storage.queryLogSampleRate = cfg.QueryLogSampleRate
+		storage.queryLogDone = make(chan struct{})
+		go storage.queryLogger()
+	}
+
+	// Start session logger
+	if cfg.SessionRetention > 0 {
+		if _, err := storage.db.ExecContext(ctx, storage.sessionTTL(cfg.SessionRetention)); err != nil {
+			log.Printf("failed to set the retention of the sessions: %v", err)
+		}
+
+		storage.sessionLog = make(chan Session, 10000)
+		storage.sessionLogDone = make(chan struct{})
+		go storage.sessionLogger()
+	}
+
+	// Start frame logger
+	if cfg.FrameSampleRate > 0 {
+		storage.frameLog = make(chan rely.Frame, 10000)
+		storage.frameSampleRate = cfg.FrameSampleRate
+		storage.redactFrames = cfg.RedactFrames
+		storage.frameLogDone = make(chan struct{})
+		go storage.frameLogger()
+	}
+
+	if cfg.CoalesceWindow > 0 {
+		storage.coalescer = &coalescer{
+			window:  cfg.CoalesceWindow,
+			pending: make(map[string]*readBatch),
+		}
+	}
+
+	if cfg.NegativeCacheTTL > 0 {
+		size := cfg.NegativeCacheSize
+		if size <= 0 {
+			size = 10000
+		}
+		storage.negative = newNegativeCache(cfg.NegativeCacheTTL, size)
+	}
+
+	if cfg.RejectStaleVersions {
+		size := cfg.VersionCacheSize
+		if size <= 0 {
+			size = 100000
+		}
+		storage.versions = newVersionCache(size)
+	}
+
+	// Start query planner
+	if cfg.PlannerInterval > 0 {
+		storage.plannerDone = make(chan struct{})
+		go storage.planner(cfg.PlannerInterval)
+	}
+
 	log.Printf("ClickHouse storage initialized (database=%s, batch_size=%d, flush_interval=%s)",
 		database, cfg.BatchSize, cfg.FlushInterval)
 
@@ -135,19 +346,45 @@ func (s *Storage) Close() error {
 	close(s.stopBatch)
 	<-s.batchDone
 
+	if s.queryLogDone != nil {
+		<-s.queryLogDone
+	}
+
+	if s.sessionLogDone != nil {
+		<-s.sessionLogDone
+	}
+
+	if s.frameLogDone != nil {
+		<-s.frameLogDone
+	}
+
+	if s.plannerDone != nil {
+		<-s.plannerDone
+	}
+
 	// Close database
 	return s.db.Close()
 }
 
 // SaveEvent stores a single event (non-blocking, queues for batch insert)
-func (s *Storage) SaveEvent(c rely.Client, event *nostr.Event) error {
+func (s *Storage) SaveEvent(ctx context.Context, c rely.Client, event *nostr.Event) error {
+	if s.tombstones.blocks(event) {
+		return rely.ErrDeleted
+	}
+
+	if s.versions != nil {
+		if err := s.checkVersion(ctx, event); err != nil {
+			return err
+		}
+	}
+
 	select {
 	case s.batchChan <- event:
 		return nil
 	default:
 		// Channel is full, log warning and try direct insert
 		log.Printf("batch channel full, falling back to direct insert for event %s", event.ID)
-		return s.insertEvent(context.Background(), event)
+		return s.insertEvent(ctx, event)
 	}
 }
 
@@ -157,7 +394,7 @@ func (s *Storage) QueryEvents(ctx context.Context, c rely.Client, filters nostr.
 
 	// Query each filter separately
 	for _, filter := range filters {
-		events, err := s.queryFilter(ctx, filter)
+		events, err := s.query(ctx, filter)
 		if err != nil {
 			return nil, fmt.Errorf("failed to query filter: %w", err)
 		}
@@ -170,10 +407,34 @@ func (s *Storage) QueryEvents(ctx context.Context, c rely.Client, filters nostr.
 	return allEvents, nil
 }
 
-// CountEvents returns the count of events matching the given filters
-func (s *Storage) CountEvents(c rely.Client, filters nostr.Filters) (int64, bool, error) {
-	ctx := context.Background()
+// query retrieves the events of a single filter, through the negative cache, the coalescer and the implicit window if enabled.
+func (s *Storage) query(ctx context.Context, filter nostr.Filter) ([]nostr.Event, error) {
+	cacheable := s.negative != nil && isCacheable(filter)
+	if cacheable && s.negative.isEmpty(filter) {
+		return nil, nil
+	}
+
+	queriedAt := time.Now()
+	var events []nostr.Event
+	var err error
+
+	switch {
+	case s.coalescer != nil && isCoalescible(filter):
+		events, err = s.coalescedQuery(ctx, filter)
+	case s.window > 0 && isUnbounded(filter):
+		events, err = s.windowedQuery(ctx, filter)
+	default:
+		events, err = s.queryFilter(ctx, filter)
+	}
 
+	if cacheable && err == nil && len(events) == 0 {
+		s.negative.add(filter, queriedAt)
+	}
+	return events, err
+}
+
+// CountEvents returns the count of events matching the given filters
+func (s *Storage) CountEvents(ctx context.Context, c rely.Client, filters nostr.Filters) (int64, bool, error) {
 	var totalCount int64
 
 	// Count each filter separately
@@ -75,7 +75,7 @@ func TestFunctionalIngestFromRelay(t *testing.T) {
 			eventIDs = append(eventIDs, event.ID)
 
 			// Save to storage
-			if err := testStorage.SaveEvent(nil, event); err != nil {
+			if err := testStorage.SaveEvent(context.Background(), nil, event); err != nil {
 				t.Errorf("Failed to save event %s: %v", event.ID, err)
 			}
 
@@ -130,7 +130,7 @@ done:
 
 	// Test counting ingested events
 	countFilters := nostr.Filters{{Kinds: []int{1}}}
-	count, _, err := testStorage.CountEvents(nil, countFilters)
+	count, _, err := testStorage.CountEvents(context.Background(), nil, countFilters)
 	if err != nil {
 		t.Errorf("Failed to count events: %v", err)
 	} else {
@@ -195,7 +195,7 @@ func TestFunctionalIngestMultipleKinds(t *testing.T) {
 			totalEvents++
 			kindCounts[event.Kind]++
 
-			if err := testStorage.SaveEvent(nil, event); err != nil {
+			if err := testStorage.SaveEvent(context.Background(), nil, event); err != nil {
 				t.Errorf("Failed to save event: %v", err)
 			}
 
@@ -321,7 +321,7 @@ func TestFunctionalIngestWithTags(t *testing.T) {
 				eventsWithETags++
 			}
 
-			if err := testStorage.SaveEvent(nil, event); err != nil {
+			if err := testStorage.SaveEvent(context.Background(), nil, event); err != nil {
 				t.Errorf("Failed to save event: %v", err)
 			}
 
@@ -422,7 +422,7 @@ func TestFunctionalStressIngest(t *testing.T) {
 
 			eventCount++
 
-			if err := testStorage.SaveEvent(nil, event); err != nil {
+			if err := testStorage.SaveEvent(context.Background(), nil, event); err != nil {
 				errorCount++
 				if errorCount < 5 { // Only log first few errors
 					t.Logf("Error saving event: %v", err)
@@ -529,7 +529,7 @@ func TestFunctionalQueryAfterIngest(t *testing.T) {
 	sub.Unsub()
 
 	// Save the event
-	if err := testStorage.SaveEvent(nil, ingestedEvent); err != nil {
+	if err := testStorage.SaveEvent(context.Background(), nil, ingestedEvent); err != nil {
 		t.Fatalf("Failed to save event: %v", err)
 	}
 
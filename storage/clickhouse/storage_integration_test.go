@@ -2,12 +2,16 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
+	ch "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
 )
 
 // TestInsertQueryFlow tests the complete insert → query flow
@@ -51,7 +55,7 @@ func TestInsertQueryFlow(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Insert events
 			for i := range tc.events {
-				if err := testStorage.SaveEvent(nil, &tc.events[i]); err != nil {
+				if err := testStorage.SaveEvent(context.Background(), nil, &tc.events[i]); err != nil {
 					t.Fatalf("Failed to save event: %v", err)
 				}
 			}
@@ -116,7 +120,7 @@ func TestInsertCountFlow(t *testing.T) {
 		}
 		events[i] = event
 
-		if err := testStorage.SaveEvent(nil, &events[i]); err != nil {
+		if err := testStorage.SaveEvent(context.Background(), nil, &events[i]); err != nil {
 			t.Fatalf("Failed to save event: %v", err)
 		}
 	}
@@ -132,7 +136,7 @@ func TestInsertCountFlow(t *testing.T) {
 		},
 	}
 
-	count, approximate, err := testStorage.CountEvents(nil, filters)
+	count, approximate, err := testStorage.CountEvents(context.Background(), nil, filters)
 	if err != nil {
 		t.Fatalf("Count failed: %v", err)
 	}
@@ -168,8 +172,8 @@ func TestFilterCombinations(t *testing.T) {
 	event2.Sign(nostr.GeneratePrivateKey())
 
 	// Insert events
-	testStorage.SaveEvent(nil, &event1)
-	testStorage.SaveEvent(nil, &event2)
+	testStorage.SaveEvent(context.Background(), nil, &event1)
+	testStorage.SaveEvent(context.Background(), nil, &event2)
 	time.Sleep(200 * time.Millisecond)
 
 	testCases := []struct {
@@ -243,7 +247,7 @@ func TestConcurrentInserts(t *testing.T) {
 
 			for j := 0; j < eventsPerGoroutine; j++ {
 				event := createTestEvent(t, 1, fmt.Sprintf("concurrent test %d-%d", workerID, j))
-				if err := testStorage.SaveEvent(nil, &event); err != nil {
+				if err := testStorage.SaveEvent(context.Background(), nil, &event); err != nil {
 					errors <- err
 				}
 			}
@@ -293,8 +297,8 @@ func TestDeduplication(t *testing.T) {
 	event := createTestEvent(t, 1, "duplicate test")
 
 	// Insert twice
-	testStorage.SaveEvent(nil, &event)
-	testStorage.SaveEvent(nil, &event)
+	testStorage.SaveEvent(context.Background(), nil, &event)
+	testStorage.SaveEvent(context.Background(), nil, &event)
 
 	// Wait for batch flush
 	time.Sleep(200 * time.Millisecond)
@@ -312,6 +316,252 @@ func TestDeduplication(t *testing.T) {
 	}
 }
 
+// TestDeduplicationReadDedup tests that duplicates are removed without FINAL
+func TestDeduplicationReadDedup(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	testStorage.readMode = ReadDedup
+	defer func() { testStorage.readMode = ReadFinal }()
+
+	ctx := context.Background()
+	event := createTestEvent(t, 1, "duplicate dedup test")
+	testStorage.SaveEvent(context.Background(), nil, &event)
+	testStorage.SaveEvent(context.Background(), nil, &event)
+	time.Sleep(200 * time.Millisecond)
+
+	filters := nostr.Filters{{IDs: []string{event.ID}}}
+	events, err := testStorage.QueryEvents(ctx, nil, filters)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 event after deduplication, got %d", len(events))
+	}
+
+	count, _, err := testStorage.CountEvents(context.Background(), nil, filters)
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected a count of 1 after deduplication, got %d", count)
+	}
+}
+
+// TestDeleteEvents tests that deleted events are no longer returned, and can't be inserted again
+func TestDeleteEvents(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	event := createTestEvent(t, 1, "deletion test")
+	testStorage.SaveEvent(context.Background(), nil, &event)
+	time.Sleep(200 * time.Millisecond)
+
+	if err := testStorage.DeleteEvents(ctx, []string{event.ID}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+
+	filters := nostr.Filters{{IDs: []string{event.ID}}}
+	events, err := testStorage.QueryEvents(ctx, nil, filters)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected the deleted event not to be returned, got %d events", len(events))
+	}
+
+	if err := testStorage.SaveEvent(context.Background(), nil, &event); !errors.Is(err, rely.ErrDeleted) {
+		t.Errorf("Expected re-inserting the deleted event to fail with ErrDeleted, got %v", err)
+	}
+
+	// the tombstones survive a restart
+	loaded := testStorage.tombstones
+	testStorage.tombstones = newTombstones()
+	defer func() { testStorage.tombstones = loaded }()
+
+	if err := testStorage.loadTombstones(ctx); err != nil {
+		t.Fatalf("loadTombstones failed: %v", err)
+	}
+	if !testStorage.tombstones.blocks(&event) {
+		t.Error("Expected the loaded tombstones to block the deleted event")
+	}
+}
+
+func TestReplaceableHistory(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+	now := time.Now().Unix()
+
+	var versions []nostr.Event
+	for i := range 4 {
+		event := nostr.Event{CreatedAt: nostr.Timestamp(now - int64(10*(3-i))), Kind: 0, Content: fmt.Sprintf(`{"name":"v%d"}`, i)}
+		if err := event.Sign(sk); err != nil {
+			t.Fatalf("Failed to sign event: %v", err)
+		}
+		testStorage.SaveEvent(ctx, nil, &event)
+		versions = append(versions, event)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	pubkey := versions[0].PubKey
+	history, err := testStorage.History(ctx, 0, pubkey, "", 10)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 4 || history[0].ID != versions[3].ID {
+		t.Fatalf("Expected the 4 versions, newest first, got %d", len(history))
+	}
+
+	if err := testStorage.PruneHistory(ctx, 1); err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+
+	history, err = testStorage.History(ctx, 0, pubkey, "", 10)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 2 || history[0].ID != versions[3].ID || history[1].ID != versions[2].ID {
+		t.Errorf("Expected the latest version and the previous one, got %d versions", len(history))
+	}
+}
+
+func TestClusterEntries(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+
+	entries := []rely.ClusterEntry{
+		{ID: "1", Origin: "a", Kind: rely.EntryBan, Key: "1.2.3.4", Until: time.Now().Add(time.Hour)},
+		{ID: "2", Origin: "a", Kind: rely.EntryBan, Key: "5.6.7.8", Until: time.Now().Add(-time.Hour)},
+	}
+
+	if err := testStorage.SaveClusterEntries(ctx, entries); err != nil {
+		t.Fatalf("SaveClusterEntries failed: %v", err)
+	}
+
+	loaded, err := testStorage.ClusterEntries(ctx, since)
+	if err != nil {
+		t.Fatalf("ClusterEntries failed: %v", err)
+	}
+
+	if len(loaded) != 1 || loaded[0].ID != "1" || loaded[0].CreatedAt.IsZero() {
+		t.Errorf("Expected only the active entry with its creation time, got %+v", loaded)
+	}
+}
+
+// TestWindowedQuery tests that unbounded filters escalate beyond the implicit window when it doesn't fill their limit
+func TestWindowedQuery(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	testStorage.window = time.Hour
+	defer func() { testStorage.window = 0 }()
+
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+
+	recent := nostr.Event{CreatedAt: nostr.Now(), Kind: 1, Tags: nostr.Tags{}, Content: "recent"}
+	old := nostr.Event{CreatedAt: nostr.Now() - 48*3600, Kind: 1, Tags: nostr.Tags{}, Content: "old"}
+	for _, e := range []*nostr.Event{&recent, &old} {
+		if err := e.Sign(sk); err != nil {
+			t.Fatalf("Failed to sign event: %v", err)
+		}
+		testStorage.SaveEvent(context.Background(), nil, e)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	escalations := testStorage.WindowEscalations()
+	events, err := testStorage.QueryEvents(ctx, nil, nostr.Filters{{Authors: []string{pubkey}, Limit: 1}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != recent.ID {
+		t.Errorf("Expected the recent event only, got %v", events)
+	}
+	if testStorage.WindowEscalations() != escalations {
+		t.Error("Expected the window to fill the limit without escalation")
+	}
+
+	events, err = testStorage.QueryEvents(ctx, nil, nostr.Filters{{Authors: []string{pubkey}, Limit: 10}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[1].ID != old.ID {
+		t.Errorf("Expected the recent and old events, got %v", events)
+	}
+	if testStorage.WindowEscalations() != escalations+1 {
+		t.Error("Expected the query to escalate beyond the window")
+	}
+}
+
+// BenchmarkQueryReadModes compares the latency of the feed queries with FINAL and with LIMIT 1 BY id
+func BenchmarkQueryReadModes(b *testing.B) {
+	if testStorage == nil {
+		b.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	filters := map[string]nostr.Filters{
+		"kind":   {{Kinds: []int{1}, Limit: 100}},
+		"author": {{Authors: []string{"79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"}, Limit: 100}},
+		"recent": {{Limit: 500}},
+	}
+
+	defer func() { testStorage.readMode = ReadFinal }()
+	for _, mode := range []ReadMode{ReadFinal, ReadDedup} {
+		for name, f := range filters {
+			b.Run(fmt.Sprintf("%s/%s", mode, name), func(b *testing.B) {
+				testStorage.readMode = mode
+				for b.Loop() {
+					if _, err := testStorage.QueryEvents(ctx, nil, f); err != nil {
+						b.Fatalf("Query failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBloomIndexes compares the latency of the lookups of authors on the projections
+// sorted by kind and by tag, without and with their bloom filter indexes on pubkey
+func BenchmarkBloomIndexes(b *testing.B) {
+	if testStorage == nil {
+		b.Skip("Test storage not available")
+	}
+
+	author := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	filters := map[string]nostr.Filter{
+		"kind":  {Kinds: []int{1}, Authors: []string{author}, Limit: 100},
+		"tag_p": {Tags: nostr.TagMap{"p": {author}}, Authors: []string{author}, Limit: 100},
+	}
+
+	for _, skip := range []int{0, 1} {
+		ctx := ch.Context(context.Background(), ch.WithSettings(ch.Settings{"use_skip_indexes": skip}))
+		for name, f := range filters {
+			_, query, args := testStorage.buildQuery(f)
+			b.Run(fmt.Sprintf("use_skip_indexes=%d/%s", skip, name), func(b *testing.B) {
+				for b.Loop() {
+					if _, err := testStorage.runQuery(ctx, query, args); err != nil {
+						b.Fatalf("Query failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
 // TestLargeQueryResults tests querying large result sets
 func TestLargeQueryResults(t *testing.T) {
 	if testStorage == nil {
@@ -325,7 +575,7 @@ func TestLargeQueryResults(t *testing.T) {
 	testPrefix := fmt.Sprintf("large_query_%d", time.Now().Unix())
 	for i := 0; i < numEvents; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("%s_%d", testPrefix, i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 
 	// Wait for batch flush
@@ -370,8 +620,8 @@ func TestComplexTagFilters(t *testing.T) {
 	event2.Sign(nostr.GeneratePrivateKey())
 
 	// Insert events
-	testStorage.SaveEvent(nil, &event1)
-	testStorage.SaveEvent(nil, &event2)
+	testStorage.SaveEvent(context.Background(), nil, &event1)
+	testStorage.SaveEvent(context.Background(), nil, &event2)
 	time.Sleep(200 * time.Millisecond)
 
 	// Query by t tag
@@ -400,7 +650,7 @@ func TestQueryPerformance(t *testing.T) {
 	// Insert test data
 	for i := 0; i < 100; i++ {
 		event := createTestEvent(t, 1, fmt.Sprintf("perf test %d", i))
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 	time.Sleep(300 * time.Millisecond)
 
@@ -421,3 +671,123 @@ func TestQueryPerformance(t *testing.T) {
 		t.Errorf("Query took too long: %s", duration)
 	}
 }
+
+func TestInternals(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	event := createTestEvent(t, 1, "internals test")
+	testStorage.SaveEvent(context.Background(), nil, &event)
+	time.Sleep(200 * time.Millisecond)
+
+	internals, err := testStorage.Internals(context.Background())
+	if err != nil {
+		t.Fatalf("Internals failed: %v", err)
+	}
+
+	if len(internals.Disks) == 0 {
+		t.Error("Expected at least one disk")
+	}
+
+	i := slices.IndexFunc(internals.Tables, func(t TableInternals) bool { return t.Table == "events" })
+	if i == -1 || internals.Tables[i].Parts == 0 || internals.Tables[i].Rows == 0 {
+		t.Errorf("Expected the events table to have parts and rows, got %+v", internals.Tables)
+	}
+}
+
+// TestRestoreEvents tests that restoring skips the events already stored, repeated or deleted
+func TestExplain(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ex, err := testStorage.Explain(context.Background(), nostr.Filter{Kinds: []int{1}, Limit: 10}, true)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if ex.Table == "" || ex.Query == "" || !ex.Ran {
+		t.Fatalf("unexpected explanation %+v", ex)
+	}
+	if ex.Events > 10 {
+		t.Fatalf("expected at most 10 events, got %d", ex.Events)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	event := createTestEvent(t, 1, "locate")
+	if _, err := testStorage.RestoreEvents(ctx, []*nostr.Event{&event}, false); err != nil {
+		t.Fatalf("RestoreEvents failed: %v", err)
+	}
+
+	loc, err := testStorage.Locate(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("Locate failed: %v", err)
+	}
+	if loc.Event == nil || loc.Event.ID != event.ID || loc.Deleted || loc.Tombstoned {
+		t.Fatalf("unexpected location %+v", loc)
+	}
+	if len(loc.Tables) == 0 || loc.Tables[0].Table != "events" {
+		t.Fatalf("expected the event in the events table, got %+v", loc.Tables)
+	}
+
+	if err := testStorage.DeleteEvents(ctx, []string{event.ID}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+
+	loc, err = testStorage.Locate(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("Locate failed: %v", err)
+	}
+	if !loc.Tombstoned {
+		t.Fatalf("expected the deleted event to be tombstoned, got %+v", loc)
+	}
+}
+
+func TestRestoreEvents(t *testing.T) {
+	if testStorage == nil {
+		t.Skip("Test storage not available")
+	}
+
+	ctx := context.Background()
+	stored := createTestEvent(t, 1, "restore stored")
+	deleted := createTestEvent(t, 1, "restore deleted")
+	missing := createTestEvent(t, 1, "restore missing")
+
+	if _, err := testStorage.RestoreEvents(ctx, []*nostr.Event{&stored}, false); err != nil {
+		t.Fatalf("RestoreEvents failed: %v", err)
+	}
+	if err := testStorage.DeleteEvents(ctx, []string{deleted.ID}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+
+	batch := []*nostr.Event{&stored, &deleted, &missing, &missing}
+	restored, err := testStorage.RestoreEvents(ctx, batch, true)
+	if err != nil {
+		t.Fatalf("RestoreEvents failed: %v", err)
+	}
+
+	expected := Restored{Inserted: 1, Duplicates: 2, Deleted: 1}
+	if restored != expected {
+		t.Fatalf("expected dry run %+v, got %+v", expected, restored)
+	}
+
+	if _, err := testStorage.RestoreEvents(ctx, batch, false); err != nil {
+		t.Fatalf("RestoreEvents failed: %v", err)
+	}
+
+	restored, err = testStorage.RestoreEvents(ctx, batch, true)
+	if err != nil {
+		t.Fatalf("RestoreEvents failed: %v", err)
+	}
+
+	expected = Restored{Inserted: 0, Duplicates: 3, Deleted: 1}
+	if restored != expected {
+		t.Fatalf("expected after restoring %+v, got %+v", expected, restored)
+	}
+}
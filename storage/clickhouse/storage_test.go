@@ -2,11 +2,17 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	ch "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
 )
 
 var testStorage *Storage
@@ -160,6 +166,32 @@ func runMigrations(s *Storage) error {
 		) ENGINE = ReplacingMergeTree(version, deleted)
 		ORDER BY (tag_e_value, created_at)
 		PRIMARY KEY (tag_e_value)`,
+
+		// Bloom filter indexes on the columns outside of the sorting keys
+		`ALTER TABLE nostr.events_by_author ADD INDEX IF NOT EXISTS idx_id id TYPE bloom_filter(0.01) GRANULARITY 4`,
+		`ALTER TABLE nostr.events_by_kind ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4`,
+		`ALTER TABLE nostr.events_by_tag_p ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4`,
+		`ALTER TABLE nostr.events_by_tag_e ADD INDEX IF NOT EXISTS idx_pubkey pubkey TYPE bloom_filter(0.01) GRANULARITY 4`,
+
+		// Create tombstones table
+		`CREATE TABLE IF NOT EXISTS nostr.tombstones (
+			key String,
+			until UInt32,
+			deleted_at DateTime
+		) ENGINE = ReplacingMergeTree(until)
+		ORDER BY key`,
+
+		// Create cluster state table
+		`CREATE TABLE IF NOT EXISTS nostr.cluster_state (
+			id String,
+			origin LowCardinality(String),
+			kind LowCardinality(String),
+			key String,
+			amount Float64,
+			until DateTime,
+			created_at DateTime64(3) DEFAULT now64(3)
+		) ENGINE = MergeTree()
+		ORDER BY created_at`,
 	}
 
 	for _, migration := range migrations {
@@ -290,6 +322,661 @@ func TestExtractAllTags(t *testing.T) {
 	}
 }
 
+// TestFilterShape tests that filters are anonymized into deterministic shapes
+func TestFilterShape(t *testing.T) {
+	since := nostr.Timestamp(1000)
+
+	tests := []struct {
+		name     string
+		filter   nostr.Filter
+		expected string
+	}{
+		{
+			name:     "empty filter",
+			filter:   nostr.Filter{},
+			expected: "empty",
+		},
+		{
+			name:     "authors and sorted kinds",
+			filter:   nostr.Filter{Authors: []string{"abc", "def"}, Kinds: []int{6, 1}, Limit: 10},
+			expected: "authors,kinds=1|6,limit",
+		},
+		{
+			name:     "sorted tags",
+			filter:   nostr.Filter{Tags: nostr.TagMap{"p": {"abc"}, "e": {"def"}}, Since: &since},
+			expected: "#e,#p,since",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if shape := filterShape(tt.filter); shape != tt.expected {
+				t.Errorf("got %q, want %q", shape, tt.expected)
+			}
+		})
+	}
+}
+
+// TestStoredContent tests that only large or marker-like contents are offloaded to the blob table
+func TestStoredContent(t *testing.T) {
+	s := &Storage{blobThreshold: 8}
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "small content", content: "hello", expected: "hello"},
+		{name: "content at threshold", content: "12345678", expected: "12345678"},
+		{name: "large content", content: "123456789", expected: blobMarker},
+		{name: "marker-like content", content: blobMarker, expected: blobMarker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stored := s.storedContent(tt.content); stored != tt.expected {
+				t.Errorf("got %q, want %q", stored, tt.expected)
+			}
+		})
+	}
+
+	disabled := &Storage{}
+	if stored := disabled.storedContent("123456789"); stored != "123456789" {
+		t.Errorf("expected no offloading when disabled, got %q", stored)
+	}
+}
+
+// TestCompressionSchema tests that the migrations are rewritten with the configured codecs
+func TestCompressionSchema(t *testing.T) {
+	schema := `CREATE TABLE IF NOT EXISTS nostr.events
+(
+    id              FixedString(64),
+    created_at      UInt32,                 -- Unix timestamp
+    kind            UInt16,
+    content         String,
+    blob            String CODEC(ZSTD(3)),
+    tags            Array(Array(String))
+)`
+	unrelated := "    tags            UInt16,"
+
+	c := Compression{ContentLevel: 5, DeltaTimestamps: true, LowCardinalityKind: true}
+	result := c.Schema(schema)
+
+	expected := []string{
+		"SET allow_suspicious_low_cardinality_types = 1;",
+		"created_at      UInt32 CODEC(Delta(4), ZSTD(1)),                 -- Unix timestamp",
+		"kind            LowCardinality(UInt16),",
+		"content         String CODEC(ZSTD(5)),",
+		"blob            String CODEC(ZSTD(3)),",
+		"tags            Array(Array(String)) CODEC(ZSTD(5))\n)",
+	}
+
+	for _, e := range expected {
+		e = strings.ReplaceAll(e, "\\n", "\n")
+		if !strings.Contains(result, e) {
+			t.Errorf("expected the schema to contain %q, got:\n%s", e, result)
+		}
+	}
+
+	if c.Schema(unrelated) != unrelated {
+		t.Errorf("expected columns with unrelated types to be unchanged, got %q", c.Schema(unrelated))
+	}
+
+	result = Compression{}.Schema(schema)
+	if strings.Contains(result, "LowCardinality") || !strings.Contains(result, "kind            UInt16,") {
+		t.Errorf("expected kind to be unchanged, got:\n%s", result)
+	}
+}
+
+// TestPartitioningSchema tests that only the event tables are repartitioned
+func TestPartitioningSchema(t *testing.T) {
+	schema := `CREATE TABLE IF NOT EXISTS nostr.events_by_kind
+(
+    kind            UInt16
+)
+ENGINE = ReplacingMergeTree(version)
+PARTITION BY kind
+ORDER BY (kind, created_at);
+
+CREATE TABLE IF NOT EXISTS nostr.daily_stats
+(
+    date            Date
+)
+ENGINE = SummingMergeTree()
+PARTITION BY toYYYYMM(date)
+ORDER BY (date, kind);`
+
+	tests := []struct {
+		partitioning Partitioning
+		expected     string
+	}{
+		{partitioning: PartitionByMonth, expected: "PARTITION BY toYYYYMM(toDateTime(created_at))\nORDER BY (kind"},
+		{partitioning: PartitionByKind, expected: "PARTITION BY intDiv(kind, 10000)\nORDER BY (kind"},
+		{partitioning: PartitionByMonthAndKind, expected: "PARTITION BY (toYYYYMM(toDateTime(created_at)), intDiv(kind, 10000))\nORDER BY (kind"},
+		{partitioning: PartitionNone, expected: "ReplacingMergeTree(version)\nORDER BY (kind"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.partitioning), func(t *testing.T) {
+			result := tt.partitioning.Schema(schema)
+			if !strings.Contains(result, tt.expected) {
+				t.Errorf("expected the schema to contain %q, got:\n%s", tt.expected, result)
+			}
+			if !strings.Contains(result, "PARTITION BY toYYYYMM(date)") {
+				t.Errorf("expected the other tables to be unchanged, got:\n%s", result)
+			}
+		})
+	}
+
+	if err := Partitioning("weekly").Validate(); err == nil {
+		t.Error("expected an unknown partitioning to be invalid")
+	}
+}
+
+// TestProjectionSchema tests that the projections are extracted from the embedded schema
+func TestProjectionSchema(t *testing.T) {
+	s := &Storage{database: "relay"}
+
+	for _, name := range ProjectionNames {
+		t.Run(name, func(t *testing.T) {
+			table, view, err := s.projectionSchema(name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.HasPrefix(table, "CREATE TABLE IF NOT EXISTS relay."+name+"\n") {
+				t.Errorf("unexpected table statement:\n%s", table)
+			}
+			if !strings.Contains(table, "ALTER TABLE relay."+name+"\n    ADD INDEX IF NOT EXISTS idx_id ") {
+				t.Errorf("expected the indexes of the later migrations:\n%s", table)
+			}
+			if !strings.HasPrefix(view, "CREATE MATERIALIZED VIEW IF NOT EXISTS relay."+name+"_mv TO relay."+name) {
+				t.Errorf("unexpected view statement:\n%s", view)
+			}
+			if !strings.HasSuffix(view, "FROM relay.events") && !strings.Contains(view, "FROM relay.events\nWHERE") {
+				t.Errorf("expected the view to select from the events table:\n%s", view)
+			}
+		})
+	}
+
+	if _, _, err := s.projectionSchema("events"); err == nil {
+		t.Error("expected an error for an unknown projection")
+	}
+}
+
+// TestProjectionSelection tests that the SELECT of the materialized views is restricted to the condition
+func TestProjectionSelection(t *testing.T) {
+	s := &Storage{database: "relay"}
+
+	selection, err := s.projectionSelection("events_by_author", "_partition_id = ?")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(selection, "SELECT") || !strings.HasSuffix(selection, "FROM relay.events WHERE _partition_id = ?") {
+		t.Errorf("unexpected selection:\n%s", selection)
+	}
+
+	condition := fmt.Sprintf(reconcileCondition, s.database, "events_by_address")
+	selection, err = s.projectionSelection("events_by_address", condition)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(selection, "\nWHERE kind IN (30023, 30024) AND version >= ?") {
+		t.Errorf("expected the condition to be added to the one of the view:\n%s", selection)
+	}
+	if !strings.Contains(selection, "SELECT id, version FROM relay.events_by_address WHERE") {
+		t.Errorf("expected the rows of the projection to be excluded:\n%s", selection)
+	}
+	if strings.Count(selection, "?") != 4 {
+		t.Errorf("expected 4 placeholders:\n%s", selection)
+	}
+}
+
+// TestVerifyQueries tests that the queries of the verification select the sampled events of the projection
+func TestVerifyQueries(t *testing.T) {
+	s := &Storage{database: "relay"}
+
+	for _, name := range ProjectionNames {
+		count, insert, err := s.verifyQueries(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		if !strings.HasPrefix(count, "SELECT count(), countIf(NOT ((id, version) IN (SELECT id, version FROM relay."+name+" WHERE") {
+			t.Errorf("%s: unexpected count query:\n%s", name, count)
+		}
+		if !strings.HasPrefix(insert, "INSERT INTO relay."+name+" SELECT") || !strings.Contains(insert, "AND NOT ((id, version) IN") {
+			t.Errorf("%s: unexpected insert query:\n%s", name, insert)
+		}
+
+		// the partition and time of the condition, and the two samples of 4 arguments
+		if n := strings.Count(count, "?"); n != 10 {
+			t.Errorf("%s: expected 10 placeholders in the count query, got %d", name, n)
+		}
+		if n := strings.Count(insert, "?"); n != 10 {
+			t.Errorf("%s: expected 10 placeholders in the insert query, got %d", name, n)
+		}
+	}
+}
+
+// TestQueryLimits tests the settings of the query limits, and that the errors of the exceeded limits are wrapped
+func TestQueryLimits(t *testing.T) {
+	if settings := (QueryLimits{MaxExecutionTime: time.Second}).settings(); settings != nil {
+		t.Errorf("expected no settings, got %v", settings)
+	}
+
+	settings := QueryLimits{MaxMemoryUsage: 1 << 30, MaxRowsToRead: 1e8}.settings()
+	if settings["max_memory_usage"] != uint64(1<<30) || settings["max_rows_to_read"] != uint64(1e8) {
+		t.Errorf("unexpected settings %v", settings)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &ch.Exception{Code: codeMemoryLimitExceeded}, expected: true},
+		{err: fmt.Errorf("row iteration error: %w", &ch.Exception{Code: codeTooManyRows}), expected: true},
+		{err: &ch.Exception{Code: codeTimeoutExceeded}, expected: true},
+		{err: context.DeadlineExceeded, expected: true},
+		{err: &ch.Exception{Code: 62}, expected: false}, // syntax error
+		{err: errors.New("connection refused"), expected: false},
+	}
+
+	for _, test := range tests {
+		err := limitError(ctx, test.err)
+		if errors.Is(err, ErrQueryLimit) != test.expected {
+			t.Errorf("%v: expected the limit error %v, got %v", test.err, test.expected, err)
+		}
+		if test.expected && !errors.Is(err, rely.ErrQueryTooExpensive) {
+			t.Errorf("%v: expected the query to be too expensive, got %v", test.err, err)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limitError(cancelled, context.DeadlineExceeded); errors.Is(err, ErrQueryLimit) {
+		t.Errorf("expected the deadline of the caller not to be a limit error, got %v", err)
+	}
+}
+
+// TestMissingProjection tests that queries are routed to the events table when a projection is missing
+func TestMissingProjection(t *testing.T) {
+	s := &Storage{database: "nostr", missing: map[string]bool{"events_by_author": true}}
+	filter := nostr.Filter{Authors: []string{"abc"}}
+
+	if table, _, _ := s.buildQuery(filter); table != "nostr.events" {
+		t.Errorf("expected the query to be routed to nostr.events, got %s", table)
+	}
+
+	if table, _, _ := s.buildCountQuery(filter); table != "nostr.events" {
+		t.Errorf("expected the count to be routed to nostr.events, got %s", table)
+	}
+
+	s.missing = nil
+	if table, _, _ := s.buildQuery(filter); table != "nostr.events_by_author" {
+		t.Errorf("expected the query to be routed to nostr.events_by_author, got %s", table)
+	}
+}
+
+// TestHashQuery tests that the "#x" filters use the tag_x index of the events table, or scan the tags without it
+func TestHashQuery(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	hash := "5d2899290e0e69bcd809949ee516a4a1597205390878f780c098707a7f18e3df"
+	filter := nostr.Filter{Kinds: []int{1063}, Tags: nostr.TagMap{"x": {hash}}}
+
+	table, query, args := s.buildQuery(filter)
+	if table != "nostr.events_by_kind" || !strings.Contains(query, "arrayExists(t -> length(t) > 1 AND t[1] = 'x'") {
+		t.Errorf("expected the tags to be scanned without the index, got %s: %s", table, query)
+	}
+	if len(args) != 2 {
+		t.Errorf("expected 2 arguments, got %d", len(args))
+	}
+
+	s.bloom = map[string]bool{"events.tag_x": true}
+	table, query, _ = s.buildQuery(filter)
+	if table != "nostr.events" || !strings.Contains(query, "hasAny(tag_x, ?)") {
+		t.Errorf("expected the index of the events table to be used, got %s: %s", table, query)
+	}
+
+	if _, query, _ = s.buildCountQuery(filter); !strings.Contains(query, "hasAny(tag_x, ?)") {
+		t.Errorf("expected the count to use the index, got %s", query)
+	}
+}
+
+// TestTagColumns tests the configured tag columns, and that their filters use the index of the events table
+func TestTagColumns(t *testing.T) {
+	if err := ValidateTagColumns([]string{"x", "i", "L", "l"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, invalid := range []string{"", "p", "g", "ab", "1", "'"} {
+		if err := ValidateTagColumns([]string{invalid}); err == nil {
+			t.Errorf("expected an error for the tag column %q", invalid)
+		}
+	}
+
+	if tags := tagColumns([]string{"l", "x", "L", "l"}); !slices.Equal(tags, []string{"L", "l"}) {
+		t.Errorf("expected the sorted tags without duplicates nor x, got %v", tags)
+	}
+
+	schema := TagColumnSchema("nostr", "L")
+	if len(schema) != 3 || !strings.Contains(schema[0], "ADD COLUMN IF NOT EXISTS tag_L Array(String)") ||
+		!strings.Contains(schema[0], "t[1] = 'L'") || !strings.Contains(schema[0], "ADD INDEX IF NOT EXISTS idx_tag_L tag_L") {
+		t.Errorf("unexpected schema:\n%s", strings.Join(schema, ";\n"))
+	}
+
+	s := &Storage{database: "nostr", tagColumns: []string{"L", "l"}}
+	filter := nostr.Filter{Kinds: []int{1985}, Tags: nostr.TagMap{"L": {"ISO-639-1"}, "l": {"en"}}}
+
+	table, query, args := s.buildQuery(filter)
+	if table != "nostr.events_by_kind" || !strings.Contains(query, "t[1] = 'L' AND has(?, t[2])") || !strings.Contains(query, "t[1] = 'l' AND has(?, t[2])") {
+		t.Errorf("expected the tags to be scanned without the index, got %s: %s", table, query)
+	}
+	if len(args) != 3 {
+		t.Errorf("expected 3 arguments, got %d", len(args))
+	}
+
+	s.bloom = map[string]bool{"events.tag_L": true, "events.tag_l": true}
+	table, query, _ = s.buildQuery(filter)
+	if table != "nostr.events" || !strings.Contains(query, "hasAny(tag_L, ?)") || !strings.Contains(query, "hasAny(tag_l, ?)") {
+		t.Errorf("expected the index of the events table to be used, got %s: %s", table, query)
+	}
+
+	if _, query, _ = s.buildCountQuery(filter); !strings.Contains(query, "hasAny(tag_l, ?)") {
+		t.Errorf("expected the count to use the index, got %s", query)
+	}
+}
+
+// TestAddressQuery tests that the "#a" filters are served by the events table, which has the tag_a column
+func TestAddressQuery(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	filter := nostr.Filter{Kinds: []int{8}, Authors: []string{"abc"}, Tags: nostr.TagMap{"a": {"30009:abc:bravery"}}}
+
+	if table, query, _ := s.buildQuery(filter); table != "nostr.events" || !strings.Contains(query, "hasAny(tag_a, ?)") {
+		t.Errorf("expected the addresses to be looked up in the events table, got %s: %s", table, query)
+	}
+
+	stats := &plannerStats{events: 1e8, authors: 1e6, kinds: map[int]float64{8: 1e5}}
+	if c := s.plan(filter, stats); c.rows != 1e8 {
+		t.Errorf("expected a full scan without the index, got %f rows", c.rows)
+	}
+
+	s.bloom = map[string]bool{"events.tag_a": true}
+	if c := s.plan(filter, stats); c.table != "events" || c.rows != bloomBlock {
+		t.Errorf("expected the blocks of the address with the index, got %s with %f rows", c.table, c.rows)
+	}
+}
+
+// TestLongFormQuery tests that the long-form articles are served by events_by_address, sorted by published_at
+func TestLongFormQuery(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	filter := nostr.Filter{Kinds: []int{30023}, Authors: []string{"abc"}, Tags: nostr.TagMap{"d": {"hello"}}}
+
+	table, query, _ := s.buildQuery(filter)
+	if table != "nostr.events_by_address" || !strings.HasSuffix(query, "ORDER BY published_at DESC, created_at DESC LIMIT 500") {
+		t.Errorf("expected the article to be served by events_by_address, got %s: %s", table, query)
+	}
+
+	s.readMode = ReadDedup
+	if _, query, _ = s.buildQuery(filter); !strings.Contains(query, "deleted, published_at FROM") {
+		t.Errorf("expected the dedup query to select published_at, got %s", query)
+	}
+
+	for _, f := range []nostr.Filter{
+		{Kinds: []int{30023, 1}, Authors: []string{"abc"}},
+		{Kinds: []int{30023}, Tags: nostr.TagMap{"a": {"30023:abc:hello"}}},
+	} {
+		if table := s.route(f); table == "nostr.events_by_address" {
+			t.Errorf("expected %v not to be served by events_by_address", f)
+		}
+	}
+
+	s.missing = map[string]bool{"events_by_address": true}
+	if _, query, _ = s.buildQuery(filter); strings.Contains(query, "published_at") {
+		t.Errorf("expected the events table to be sorted by created_at, got %s", query)
+	}
+}
+
+// TestSampleQuery tests that the sampled events are selected and sorted by the hash of their ID
+func TestSampleQuery(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	filter := nostr.Filter{Kinds: []int{1}, Limit: 100}
+
+	_, query, args := s.buildSampledQuery(filter, 0.01)
+	if !strings.Contains(query, "cityHash64(id) % 1000000 < ?") || !strings.HasSuffix(query, "ORDER BY cityHash64(id) LIMIT 100") {
+		t.Errorf("expected the events to be sampled by hash, got %s", query)
+	}
+	if rate := args[len(args)-1]; rate != uint64(10_000) {
+		t.Errorf("expected the rate to be 10000 buckets of the hashes, got %v", rate)
+	}
+
+	if _, query, _ = s.buildQuery(filter); strings.Contains(query, "cityHash64") {
+		t.Errorf("expected the queries not to be sampled, got %s", query)
+	}
+
+	if _, err := s.Sample(context.Background(), filter, 0); err == nil {
+		t.Error("expected a zero rate to be rejected")
+	}
+}
+
+// TestReadModeQuery tests that the dedup read mode replaces FINAL with LIMIT 1 BY id,
+// filtering the deleted events after the deduplication
+func TestReadModeQuery(t *testing.T) {
+	s := &Storage{database: "nostr", readMode: ReadDedup}
+	filter := nostr.Filter{Kinds: []int{1}, Limit: 10}
+
+	_, query, args := s.buildQuery(filter)
+	if strings.Contains(query, "FINAL") {
+		t.Errorf("expected no FINAL in the dedup query, got %s", query)
+	}
+	if !strings.Contains(query, "LIMIT 1 BY id LIMIT 10) WHERE deleted = 0") {
+		t.Errorf("expected the deleted events to be filtered after the deduplication, got %s", query)
+	}
+	if len(args) != 1 {
+		t.Errorf("expected 1 argument, got %d", len(args))
+	}
+
+	_, query, _ = s.buildCountQuery(filter)
+	if strings.Contains(query, "FINAL") || !strings.Contains(query, "argMax(deleted, version)") {
+		t.Errorf("expected the count to use the latest version of each event, got %s", query)
+	}
+
+	s.readMode = ReadFinal
+	if _, query, _ = s.buildQuery(filter); !strings.Contains(query, "FINAL") {
+		t.Errorf("expected FINAL in the default query, got %s", query)
+	}
+
+	if err := ReadMode("fast").Validate(); err == nil {
+		t.Error("expected an error for an unknown read mode")
+	}
+}
+
+// TestPlannerRoute tests that the table scanning the fewest rows is chosen, unless hinted otherwise
+func TestPlannerRoute(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	filter := nostr.Filter{Authors: []string{"abc", "def"}, Kinds: []int{30023}, Limit: 10}
+
+	if table := s.route(filter); table != "nostr.events_by_author" {
+		t.Errorf("expected the fixed order without statistics, got %s", table)
+	}
+
+	s.stats.Store(&plannerStats{
+		events:  1_000_000,
+		authors: 1000,
+		kinds:   map[int]float64{1: 900_000, 30023: 50},
+	})
+
+	if table := s.route(filter); table != "nostr.events_by_kind" {
+		t.Errorf("expected the rare kind to be served by events_by_kind, got %s", table)
+	}
+
+	filter.Kinds = []int{1}
+	if table := s.route(filter); table != "nostr.events_by_author" {
+		t.Errorf("expected the frequent kind to be served by events_by_author, got %s", table)
+	}
+
+	s.plannerHints = map[string]string{"authors,kinds=1,limit": "events_by_kind"}
+	if table := s.route(filter); table != "nostr.events_by_kind" {
+		t.Errorf("expected the hint to take precedence, got %s", table)
+	}
+
+	s.plannerHints = map[string]string{"authors,kinds=1,limit": "events_by_tag_p"}
+	if table := s.route(filter); table != "nostr.events_by_author" {
+		t.Errorf("expected the hint of a table that can't serve the filter to be ignored, got %s", table)
+	}
+
+	if table := s.route(nostr.Filter{IDs: []string{"abc"}, Authors: []string{"def"}}); table != "nostr.events" {
+		t.Errorf("expected queries by ID to be served by events, got %s", table)
+	}
+}
+
+// TestBloomRows tests the estimate of the rows scanned with the bloom filter index on pubkey
+func TestBloomRows(t *testing.T) {
+	stats := &plannerStats{events: 1e8, authors: 1e6, kinds: map[int]float64{1: 1e7}}
+
+	if rows := bloomRows(1e7, 1, stats); rows != 1e7/1e6*bloomBlock+1e7*bloomFalsePositive {
+		t.Errorf("expected the blocks of the author and the false positives, got %f", rows)
+	}
+	if rows := bloomRows(1e7, 1000, stats); rows != 1e7 {
+		t.Errorf("expected the estimate to be capped by the rows of the table, got %f", rows)
+	}
+	if rows := bloomRows(1e7, 1, &plannerStats{}); rows != 1e7 {
+		t.Errorf("expected no estimate without statistics, got %f", rows)
+	}
+
+	s := &Storage{database: "nostr", bloom: map[string]bool{"events_by_kind.pubkey": true}}
+	filter := nostr.Filter{Authors: []string{"abc"}, Kinds: []int{1}}
+	for _, c := range s.candidates(filter, stats) {
+		if c.table == "events_by_kind" && c.rows >= stats.kinds[1] {
+			t.Errorf("expected the index to reduce the rows of events_by_kind, got %f", c.rows)
+		}
+		if c.table == "events" && c.rows != stats.events {
+			t.Errorf("expected the rows of events without index to be unchanged, got %f", c.rows)
+		}
+	}
+}
+
+// TestEstimateCost tests that the cost of a filter is the rows it scans within its time range, plus its limit
+func TestEstimateCost(t *testing.T) {
+	s := &Storage{database: "nostr"}
+	filter := nostr.Filter{Kinds: []int{1}, Limit: 100}
+
+	if cost := s.EstimateCost(filter); cost != 100 {
+		t.Errorf("expected the limit without statistics, got %f", cost)
+	}
+	if cost := s.EstimateCost(nostr.Filter{IDs: []string{"a", "b"}}); cost != 2 {
+		t.Errorf("expected the number of IDs, got %f", cost)
+	}
+
+	now := time.Now()
+	stats := &plannerStats{events: 1e8, authors: 1e6, kinds: map[int]float64{1: 1e7}, oldest: float64(now.Add(-100 * 24 * time.Hour).Unix())}
+	s.stats.Store(stats)
+
+	if cost := s.EstimateCost(filter); cost != 1e7+100 {
+		t.Errorf("expected the rows of the kind plus the limit, got %f", cost)
+	}
+
+	since := nostr.Timestamp(now.Add(-10 * 24 * time.Hour).Unix())
+	filter.Since = &since
+	if fraction := timeFraction(filter, stats, 0, now); fraction < 0.099 || fraction > 0.101 {
+		t.Errorf("expected a tenth of the history, got %f", fraction)
+	}
+
+	filter.Since = nil
+	if fraction := timeFraction(filter, stats, 50*24*time.Hour, now); fraction < 0.499 || fraction > 0.501 {
+		t.Errorf("expected the unbounded filter to cover the implicit window, got %f", fraction)
+	}
+
+	until := nostr.Timestamp(stats.oldest - 1)
+	filter.Until = &until
+	if fraction := timeFraction(filter, stats, 0, now); fraction != 0 {
+		t.Errorf("expected a time range before the oldest event to be free, got %f", fraction)
+	}
+}
+
+// TestDemultiplex tests that the events of a merged query are split back to the filters
+func TestDemultiplex(t *testing.T) {
+	events := []nostr.Event{
+		{ID: "1", PubKey: "alice", Kind: 0, CreatedAt: 300},
+		{ID: "2", PubKey: "bob", Kind: 0, CreatedAt: 200},
+		{ID: "3", PubKey: "alice", Kind: 3, CreatedAt: 150},
+		{ID: "4", PubKey: "carol", Kind: 0, CreatedAt: 100},
+	}
+
+	tests := []struct {
+		name     string
+		filter   nostr.Filter
+		expected []string
+	}{
+		{name: "single author", filter: nostr.Filter{Authors: []string{"bob"}, Kinds: []int{0}}, expected: []string{"2"}},
+		{name: "many authors", filter: nostr.Filter{Authors: []string{"alice", "carol"}, Kinds: []int{0}}, expected: []string{"1", "4"}},
+		{name: "many kinds", filter: nostr.Filter{Authors: []string{"alice"}, Kinds: []int{0, 3}}, expected: []string{"1", "3"}},
+		{name: "limit", filter: nostr.Filter{Authors: []string{"alice", "bob", "carol"}, Kinds: []int{0}, Limit: 2}, expected: []string{"1", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			for _, e := range demultiplex(events, tt.filter) {
+				ids = append(ids, e.ID)
+			}
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Errorf("got %v, want %v", ids, tt.expected)
+			}
+		})
+	}
+
+	if isCoalescible(nostr.Filter{Authors: []string{"alice"}, Kinds: []int{1}}) {
+		t.Error("expected filters of regular kinds not to be coalescible")
+	}
+	if !isCoalescible(nostr.Filter{Authors: []string{"alice"}, Kinds: []int{0, 10002}, Limit: 2}) {
+		t.Error("expected filters of authors and replaceable kinds to be coalescible")
+	}
+}
+
+// TestNegativeCache tests that empty results are cached until a matching event is inserted
+func TestNegativeCache(t *testing.T) {
+	cache := newNegativeCache(time.Minute, 10)
+	profile := nostr.Filter{Authors: []string{"alice", "bob"}, Kinds: []int{0}}
+	mentions := nostr.Filter{Kinds: []int{1}, Tags: nostr.TagMap{"p": {"alice"}}}
+
+	queriedAt := time.Now()
+	cache.add(profile, queriedAt)
+	cache.add(mentions, queriedAt)
+
+	reordered := nostr.Filter{Authors: []string{"bob", "alice"}, Kinds: []int{0}}
+	if !cache.isEmpty(reordered) || !cache.isEmpty(mentions) {
+		t.Fatal("expected the filters to be cached")
+	}
+
+	// events that don't match keep the entries
+	cache.invalidate([]*nostr.Event{{PubKey: "alice", Kind: 1}})
+	if !cache.isEmpty(profile) || !cache.isEmpty(mentions) {
+		t.Fatal("expected the filters to stay cached")
+	}
+
+	cache.invalidate([]*nostr.Event{{PubKey: "bob", Kind: 0}})
+	if cache.isEmpty(profile) {
+		t.Error("expected the profile filter to be invalidated")
+	}
+
+	cache.invalidate([]*nostr.Event{{PubKey: "carol", Kind: 1, Tags: nostr.Tags{{"p", "alice"}}}})
+	if cache.isEmpty(mentions) {
+		t.Error("expected the mentions filter to be invalidated")
+	}
+
+	// a query started before a write of the author may have missed the event
+	cache.add(profile, queriedAt)
+	if cache.isEmpty(profile) {
+		t.Error("expected the filter queried before the write not to be cached")
+	}
+
+	if len(cache.byAuthor) != 0 || len(cache.others) != 0 {
+		t.Errorf("expected the indexes to be empty, got %v and %v", cache.byAuthor, cache.others)
+	}
+}
+
 // TestSaveEvent tests event saving
 func TestSaveEvent(t *testing.T) {
 	if testStorage == nil {
@@ -298,7 +985,7 @@ func TestSaveEvent(t *testing.T) {
 
 	event := createTestEvent(t, 1, "Test content")
 
-	err := testStorage.SaveEvent(nil, &event)
+	err := testStorage.SaveEvent(context.Background(), nil, &event)
 	if err != nil {
 		t.Errorf("SaveEvent failed: %v", err)
 	}
@@ -315,7 +1002,7 @@ func TestQueryEvents(t *testing.T) {
 
 	// Save test event
 	event := createTestEvent(t, 1, "Query test content")
-	err := testStorage.SaveEvent(nil, &event)
+	err := testStorage.SaveEvent(context.Background(), nil, &event)
 	if err != nil {
 		t.Fatalf("SaveEvent failed: %v", err)
 	}
@@ -359,7 +1046,7 @@ func TestCountEvents(t *testing.T) {
 	// Save test events
 	for i := 0; i < 5; i++ {
 		event := createTestEvent(t, 1, "Count test")
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 
 	// Wait for batch to flush
@@ -370,7 +1057,7 @@ func TestCountEvents(t *testing.T) {
 		{Kinds: []int{1}},
 	}
 
-	count, approximate, err := testStorage.CountEvents(nil, filters)
+	count, approximate, err := testStorage.CountEvents(context.Background(), nil, filters)
 	if err != nil {
 		t.Fatalf("CountEvents failed: %v", err)
 	}
@@ -393,7 +1080,7 @@ func TestStats(t *testing.T) {
 	// Save some events
 	for i := 0; i < 3; i++ {
 		event := createTestEvent(t, 1, "Stats test")
-		testStorage.SaveEvent(nil, &event)
+		testStorage.SaveEvent(context.Background(), nil, &event)
 	}
 
 	// Wait for batch to flush
@@ -446,3 +1133,184 @@ func createTestEvent(t *testing.T, kind int, content string) nostr.Event {
 
 	return event
 }
+
+// TestUnboundedFilter tests which filters are queried within the implicit window
+func TestUnboundedFilter(t *testing.T) {
+	since := nostr.Timestamp(1700000000)
+	cases := []struct {
+		filter    nostr.Filter
+		unbounded bool
+	}{
+		{nostr.Filter{Kinds: []int{1}, Limit: 20}, true},
+		{nostr.Filter{Authors: []string{"abc"}, Tags: nostr.TagMap{"t": {"nostr"}}}, true},
+		{nostr.Filter{Kinds: []int{1}, Since: &since}, false},
+		{nostr.Filter{Kinds: []int{1}, Until: &since}, false},
+		{nostr.Filter{IDs: []string{"abc"}}, false},
+	}
+
+	for _, c := range cases {
+		if got := isUnbounded(c.filter); got != c.unbounded {
+			t.Errorf("isUnbounded(%v) = %v, expected %v", c.filter, got, c.unbounded)
+		}
+	}
+
+	if limit := queryLimit(nostr.Filter{}); limit != maxLimit {
+		t.Errorf("expected the default limit %d, got %d", maxLimit, limit)
+	}
+	if limit := queryLimit(nostr.Filter{Limit: 10}); limit != 10 {
+		t.Errorf("expected the limit of the filter, got %d", limit)
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	s := &Storage{database: "relay"}
+	expected := "ALTER TABLE relay.sessions MODIFY TTL toDateTime(connected_at) + INTERVAL 2592000 SECOND"
+	if ttl := s.sessionTTL(30 * 24 * time.Hour); ttl != expected {
+		t.Fatalf("expected %q, got %q", expected, ttl)
+	}
+}
+
+func TestFrameLog(t *testing.T) {
+	disabled := &Storage{}
+	if disabled.Sampled("uid") {
+		t.Fatal("expected no connection to be sampled when the frame log is disabled")
+	}
+	disabled.Record(rely.Frame{ClientUID: "uid"})
+
+	s := &Storage{frameLog: make(chan rely.Frame, 1), frameSampleRate: 1, redactFrames: true}
+	if !s.Sampled("uid") {
+		t.Fatal("expected the connection to be sampled")
+	}
+
+	pubkey := "f683e87035f7ad4f44e0b98cfbd9537e16455a92cd38cefc4cb31db7557f5ef2"
+	s.Record(rely.Frame{ClientUID: "uid", Data: []byte(`["REQ","sub",{"authors":["` + pubkey + `"]}]`)})
+	if frame := <-s.frameLog; strings.Contains(string(frame.Data), pubkey) {
+		t.Fatalf("expected the pubkey to be redacted, got %s", frame.Data)
+	}
+}
+
+func TestDuplicateRatio(t *testing.T) {
+	tests := []struct {
+		compaction Compaction
+		expected   float64
+	}{
+		{compaction: Compaction{}, expected: 0},
+		{compaction: Compaction{Rows: 100, UniqueKeys: 100}, expected: 0},
+		{compaction: Compaction{Rows: 100, UniqueKeys: 75}, expected: 0.25},
+		{compaction: Compaction{Rows: 100, UniqueKeys: 101}, expected: 0}, // uniq is approximate
+	}
+
+	for _, test := range tests {
+		if ratio := test.compaction.DuplicateRatio(); ratio != test.expected {
+			t.Fatalf("expected %v, got %v for %+v", test.expected, ratio, test.compaction)
+		}
+	}
+}
+
+// TestTombstones tests that the deleted events and versions of addresses are blocked
+func TestTombstones(t *testing.T) {
+	pubkey := strings.Repeat("a", 64)
+	tombstones := newTombstones()
+	tombstones.add("deleted", 0)
+	tombstones.add(tombstoneAddress(30023, pubkey, "article"), 1000)
+	tombstones.add(tombstoneAddress(0, pubkey, ""), 1000)
+
+	tests := []struct {
+		name    string
+		event   nostr.Event
+		blocked bool
+	}{
+		{name: "deleted ID", event: nostr.Event{ID: "deleted", Kind: 1}, blocked: true},
+		{name: "other ID", event: nostr.Event{ID: "other", Kind: 1}},
+		{name: "old version", event: nostr.Event{ID: "a", Kind: 30023, PubKey: pubkey, CreatedAt: 999, Tags: nostr.Tags{{"d", "article"}}}, blocked: true},
+		{name: "version at deletion", event: nostr.Event{ID: "b", Kind: 30023, PubKey: pubkey, CreatedAt: 1000, Tags: nostr.Tags{{"d", "article"}}}, blocked: true},
+		{name: "new version", event: nostr.Event{ID: "c", Kind: 30023, PubKey: pubkey, CreatedAt: 1001, Tags: nostr.Tags{{"d", "article"}}}},
+		{name: "other d tag", event: nostr.Event{ID: "d", Kind: 30023, PubKey: pubkey, CreatedAt: 999, Tags: nostr.Tags{{"d", "other"}}}},
+		{name: "replaceable", event: nostr.Event{ID: "e", Kind: 0, PubKey: pubkey, CreatedAt: 999}, blocked: true},
+		{name: "regular kind", event: nostr.Event{ID: "f", Kind: 1, PubKey: pubkey, CreatedAt: 999}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if blocked := tombstones.blocks(&test.event); blocked != test.blocked {
+				t.Errorf("expected blocked %v, got %v", test.blocked, blocked)
+			}
+		})
+	}
+
+	events := []*nostr.Event{&tests[0].event, &tests[1].event, &tests[4].event}
+	if kept := tombstones.filter(events); len(kept) != 2 || kept[0].ID != "other" || kept[1].ID != "c" {
+		t.Errorf("expected the deleted event to be filtered out, got %v", kept)
+	}
+}
+
+func TestVersionCache(t *testing.T) {
+	cache := newVersionCache(2)
+	stored := version{createdAt: 1000, id: "b"}
+
+	tests := []struct {
+		name    string
+		version version
+		stale   bool
+	}{
+		{name: "older than stored", version: version{createdAt: 999, id: "a"}, stale: true},
+		{name: "same time, higher ID", version: version{createdAt: 1000, id: "c"}, stale: true},
+		{name: "same event", version: stored},
+		{name: "newer", version: version{createdAt: 1001, id: "d"}},
+		{name: "older than the pending version", version: version{createdAt: 1000, id: "a"}, stale: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := cache.advance("0:alice:", stored, true, test.version)
+			if test.stale && !errors.Is(err, ErrStaleVersion) {
+				t.Fatalf("expected %v, got %v", ErrStaleVersion, err)
+			}
+			if !test.stale && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	if err := rely.Reason(ErrStaleVersion); err != rely.ErrDuplicate {
+		t.Errorf("expected the reason %v, got %v", rely.ErrDuplicate, err)
+	}
+
+	// the oldest addresses are forgotten
+	cache.advance("0:bob:", version{}, false, version{createdAt: 1, id: "e"})
+	cache.advance("0:carol:", version{}, false, version{createdAt: 1, id: "f"})
+	if _, ok := cache.get("0:alice:"); ok {
+		t.Error("expected the oldest address to be forgotten")
+	}
+}
+
+func TestVersionCacheForget(t *testing.T) {
+	cache := newVersionCache(2)
+	stored := version{createdAt: 1000, id: "b"}
+	failed := version{createdAt: 1001, id: "c"}
+
+	if err := cache.advance("0:alice:", stored, true, failed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a newer version replaced the failed one, which must not forget it
+	cache.advance("0:bob:", version{}, false, version{createdAt: 2, id: "e"})
+	cache.forget("0:bob:", version{createdAt: 1, id: "d"})
+	if _, ok := cache.get("0:bob:"); !ok {
+		t.Fatal("expected the newer version to be remembered")
+	}
+
+	// the failed version is forgotten, so the stored version is queried again
+	cache.forget("0:alice:", failed)
+	if _, ok := cache.get("0:alice:"); ok {
+		t.Fatal("expected the failed version to be forgotten")
+	}
+
+	if err := cache.advance("0:alice:", stored, true, version{createdAt: 1000, id: "a"}); err != nil {
+		t.Fatalf("expected the version newer than the stored one to be accepted, got %v", err)
+	}
+
+	if len(cache.order) != len(cache.latest) {
+		t.Errorf("expected %d addresses in order, got %d", len(cache.latest), len(cache.order))
+	}
+}
@@ -0,0 +1,114 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// extractedTags are the tags with their own column in the events table, filled by the inserts (see extractAllTags),
+// which can't be configured as tag columns.
+var extractedTags = []string{"e", "p", "a", "t", "d", "g", "r"}
+
+// ValidateTagColumns returns an error if a tag can't have a column: it must be a single letter,
+// and not one of the tags extracted by the inserts (e, p, a, t, d, g and r).
+func ValidateTagColumns(tags []string) error {
+	for _, tag := range tags {
+		if len(tag) != 1 || !(tag[0] >= 'a' && tag[0] <= 'z' || tag[0] >= 'A' && tag[0] <= 'Z') {
+			return fmt.Errorf("invalid tag column %q, must be a single letter", tag)
+		}
+
+		if slices.Contains(extractedTags, tag) {
+			return fmt.Errorf("the %q tags already have a column", tag)
+		}
+	}
+	return nil
+}
+
+// tagColumns returns the configured tags, sorted and without duplicates nor the "x" tags, which have a column.
+func tagColumns(tags []string) []string {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	return slices.DeleteFunc(slices.Compact(tags), func(tag string) bool { return tag == "x" })
+}
+
+// TagColumnSchema returns the statements adding the column of the tag to the events table, computed from the tags
+// so that the inserts don't change, with the bloom filter index skipping the granules without the values.
+// The MATERIALIZE statements compute the column and build the index of the existing parts, in the background.
+// It's the schema of the "x" tags (migration 011) for any tag, e.g. the NIP-32 labels ("L" and "l").
+func TagColumnSchema(database, tag string) []string {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %[1]s.events
+    ADD COLUMN IF NOT EXISTS tag_%[2]s Array(String)
+        MATERIALIZED arrayMap(t -> t[2], arrayFilter(t -> length(t) > 1 AND t[1] = '%[2]s', tags)),
+    ADD INDEX IF NOT EXISTS idx_tag_%[2]s tag_%[2]s TYPE bloom_filter(0.01) GRANULARITY 4`, database, tag),
+		fmt.Sprintf("ALTER TABLE %s.events MATERIALIZE COLUMN tag_%s", database, tag),
+		fmt.Sprintf("ALTER TABLE %s.events MATERIALIZE INDEX idx_tag_%s", database, tag),
+	}
+}
+
+// addTagColumns adds the missing columns of the tags to the events table. The existing ones are left untouched,
+// so that their MATERIALIZE statements, which rewrite the parts, run only once.
+func (s *Storage) addTagColumns(ctx context.Context, tags []string) error {
+	query := "SELECT name FROM system.columns WHERE database = ? AND table = 'events' AND startsWith(name, 'tag_')"
+	rows, err := s.db.QueryContext(ctx, query, s.database)
+	if err != nil {
+		return fmt.Errorf("failed to query the tag columns: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan the tag column: %w", err)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if existing["tag_"+tag] {
+			continue
+		}
+
+		for _, statement := range TagColumnSchema(s.database, tag) {
+			if _, err := s.db.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to add the column of the %q tags: %w", tag, err)
+			}
+		}
+		log.Printf("added the column of the %q tags to the events table", tag)
+	}
+	return nil
+}
+
+// columnTags returns the tags with a column computed from the tags of the events table: the "x" tags
+// of the migration 011, and the configured ones.
+func (s *Storage) columnTags() []string {
+	return append([]string{"x"}, s.tagColumns...)
+}
+
+// indexedTags returns the number of values of the filter tags looked up with the bloom filter index of their column
+// of the events table, if any.
+func (s *Storage) indexedTags(tags map[string][]string) int {
+	values := 0
+	for _, tag := range s.columnTags() {
+		if len(tags[tag]) > 0 && s.bloom["events.tag_"+tag] {
+			values += len(tags[tag])
+		}
+	}
+	return values
+}
+
+// tagCondition returns the condition of the filters of a tag with a column (see [Config.TagColumns]).
+// The events table looks them up in the column when its bloom filter index exists,
+// the other tables, and the events table before the column is added, scan the tags.
+func (s *Storage) tagCondition(table, tag string) string {
+	if table == s.table("events") && s.bloom["events.tag_"+tag] {
+		return fmt.Sprintf("hasAny(tag_%s, ?)", tag)
+	}
+	return fmt.Sprintf("arrayExists(t -> length(t) > 1 AND t[1] = '%s' AND has(?, t[2]), tags)", tag)
+}
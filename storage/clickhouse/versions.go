@@ -0,0 +1,172 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely"
+)
+
+// ErrStaleVersion is returned when saving a replaceable or addressable event older than the stored version
+// of its address, e.g. a profile update sent late by a lagging client, which would otherwise roll it back.
+var ErrStaleVersion = fmt.Errorf("%w: older than stored version", rely.ErrDuplicate)
+
+// version is the created_at and ID of a version of a replaceable or addressable event.
+type version struct {
+	createdAt nostr.Timestamp
+	id        string
+}
+
+// supersedes reports whether the version replaces the other one: it's newer or, with the same created_at,
+// it has the lowest ID (NIP-01).
+func (v version) supersedes(other version) bool {
+	return v.createdAt > other.createdAt || (v.createdAt == other.createdAt && v.id < other.id)
+}
+
+// versionCache remembers the newest version accepted for the recently saved addresses, so that only the
+// first save of an address queries its stored version, and the versions waiting in the insert batch are
+// compared with each other. When full, the least recently inserted addresses are queried again.
+type versionCache struct {
+	mu     sync.Mutex
+	size   int
+	latest map[string]version // by address "kind:pubkey:d"
+	order  []string           // the addresses in insertion order
+}
+
+func newVersionCache(size int) *versionCache {
+	return &versionCache{size: size, latest: make(map[string]version, size)}
+}
+
+// get returns the newest version of the address, if it's remembered.
+func (c *versionCache) get(address string) (version, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.latest[address]
+	return v, ok
+}
+
+// advance checks the new version of the address against the newest known one (the remembered one,
+// or the stored one if found), remembering it if it's not stale.
+func (c *versionCache) advance(address string, stored version, found bool, v version) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	latest, ok := c.latest[address]
+	if found && (!ok || stored.supersedes(latest)) {
+		latest, ok = stored, true
+	}
+
+	if ok && latest.id != v.id && latest.supersedes(v) {
+		return ErrStaleVersion
+	}
+
+	if _, ok := c.latest[address]; !ok {
+		c.order = append(c.order, address)
+	}
+	c.latest[address] = v
+
+	for len(c.latest) > c.size {
+		delete(c.latest, c.order[0])
+		c.order = c.order[1:]
+	}
+	return nil
+}
+
+// forget forgets the version of the address if it's still the newest one remembered, e.g. because its event
+// failed to insert, so that the next save of the address queries the stored version again.
+func (c *versionCache) forget(address string, v version) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if latest, ok := c.latest[address]; !ok || latest.id != v.id {
+		return
+	}
+
+	delete(c.latest, address)
+	if i := slices.Index(c.order, address); i >= 0 {
+		c.order = slices.Delete(c.order, i, i+1)
+	}
+}
+
+// versionAddress returns the address of the event, and whether it's replaceable or addressable.
+func versionAddress(e *nostr.Event) (string, bool) {
+	switch {
+	case nostr.IsReplaceableKind(e.Kind):
+		return tombstoneAddress(e.Kind, e.PubKey, ""), true
+	case nostr.IsAddressableKind(e.Kind):
+		return tombstoneAddress(e.Kind, e.PubKey, e.Tags.GetD()), true
+	default:
+		return "", false
+	}
+}
+
+// checkVersion returns [ErrStaleVersion] if the replaceable or addressable event is older than the stored
+// or the pending version of its address. If the stored version can't be read, the event is accepted.
+func (s *Storage) checkVersion(ctx context.Context, e *nostr.Event) error {
+	address, ok := versionAddress(e)
+	if !ok {
+		return nil
+	}
+
+	v := version{createdAt: e.CreatedAt, id: e.ID}
+	if _, ok := s.versions.get(address); ok {
+		return s.versions.advance(address, version{}, false, v)
+	}
+
+	stored, found, err := s.storedVersion(ctx, e)
+	if err != nil {
+		log.Printf("failed to check the version of event %s: %v", e.ID, err)
+		return nil
+	}
+	return s.versions.advance(address, stored, found, v)
+}
+
+// forgetVersions forgets the pending versions of the events that were not inserted.
+func (s *Storage) forgetVersions(events []*nostr.Event) {
+	if s.versions == nil {
+		return
+	}
+
+	for _, e := range events {
+		if address, ok := versionAddress(e); ok {
+			s.versions.forget(address, version{createdAt: e.CreatedAt, id: e.ID})
+		}
+	}
+}
+
+// storedVersion returns the newest stored version of the address of the event, including the deleted ones,
+// so that a deleted version can't be rolled back either.
+func (s *Storage) storedVersion(ctx context.Context, e *nostr.Event) (version, bool, error) {
+	d := ""
+	if nostr.IsAddressableKind(e.Kind) {
+		d = e.Tags.GetD()
+	}
+
+	// the versions of an author are contiguous in the sorting key of events_by_author
+	query := fmt.Sprintf(`
+		SELECT created_at, id
+		FROM %s
+		WHERE pubkey = ? AND kind = ? AND tag_d = ?
+		ORDER BY created_at DESC, id
+		LIMIT 1
+	`, s.table("events_by_author"))
+
+	var createdAt uint32
+	var v version
+	err := s.db.QueryRowContext(ctx, query, e.PubKey, uint16(e.Kind), d).Scan(&createdAt, &v.id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return version{}, false, nil
+	}
+	if err != nil {
+		return version{}, false, fmt.Errorf("failed to query the stored version: %w", err)
+	}
+
+	v.createdAt = nostr.Timestamp(createdAt)
+	return v, true, nil
+}
@@ -0,0 +1,55 @@
+package clickhouse
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxLimit is the default and maximum number of events returned for a filter.
+const maxLimit = 5000
+
+// queryLimit returns the number of events returned for the filter.
+func queryLimit(filter nostr.Filter) int {
+	if filter.Limit == 0 || filter.Limit > maxLimit {
+		return maxLimit
+	}
+	return filter.Limit
+}
+
+// isUnbounded reports whether the filter has no time bounds nor IDs, so that it scans the whole history.
+func isUnbounded(filter nostr.Filter) bool {
+	return filter.Since == nil && filter.Until == nil && len(filter.IDs) == 0
+}
+
+// windowedQuery queries the unbounded filter within the window first, since the most recent events
+// are what feeds ask for, and escalates to the older events only if the window doesn't fill the limit.
+func (s *Storage) windowedQuery(ctx context.Context, filter nostr.Filter) ([]nostr.Event, error) {
+	limit := queryLimit(filter)
+	since := nostr.Timestamp(time.Now().Add(-s.window).Unix())
+
+	recent := filter
+	recent.Since = &since
+	events, err := s.queryFilter(ctx, recent)
+	if err != nil || len(events) >= limit {
+		return events, err
+	}
+
+	s.windowEscalations.Add(1)
+	until := since - 1
+	older := filter
+	older.Until = &until
+	older.Limit = limit - len(events)
+
+	rest, err := s.queryFilter(ctx, older)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, rest...), nil
+}
+
+// WindowEscalations returns the number of unbounded filters that were queried beyond the implicit window.
+func (s *Storage) WindowEscalations() uint64 {
+	return s.windowEscalations.Load()
+}
@@ -0,0 +1,110 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var ErrDeleted = fmt.Errorf("%w: event was deleted", ErrBlocked)
+
+// Store is a storage of events, whose methods match the On.Event, On.Req and On.Count hooks,
+// that can delete them. Deletions leave tombstones, so that the deleted events can't be saved again
+// (e.g. re-broadcast by another relay), and saving them returns [ErrDeleted].
+type Store interface {
+	SaveEvent(context.Context, Client, *nostr.Event) error
+	QueryEvents(context.Context, Client, nostr.Filters) ([]nostr.Event, error)
+	CountEvents(context.Context, Client, nostr.Filters) (int64, bool, error)
+
+	// DeleteEvents deletes the events with the IDs, and forbids saving them again.
+	DeleteEvents(ctx context.Context, ids []string) error
+
+	// DeleteByAddress deletes the versions of the replaceable or addressable event created until now,
+	// and forbids saving them again. The d tag is empty for replaceable events.
+	DeleteByAddress(ctx context.Context, kind int, pubkey, d string) error
+}
+
+// SaveWithDeletions returns an On.Event hook that saves the events in the store, and applies the NIP-09
+// deletion requests (kind 5): the events of the "e" tags and the addresses of the "a" tags are deleted,
+// as long as they belong to the author of the request.
+// See https://github.com/nostr-protocol/nips/blob/master/09.md
+//
+// Example:
+//
+//	relay.On.Event = SaveWithDeletions(store)
+func SaveWithDeletions(store Store) func(context.Context, Client, *nostr.Event) error {
+	return func(ctx context.Context, c Client, e *nostr.Event) error {
+		if err := store.SaveEvent(ctx, c, e); err != nil {
+			return err
+		}
+
+		if e.Kind != nostr.KindDeletion {
+			return nil
+		}
+		return applyDeletion(ctx, store, c, e)
+	}
+}
+
+// applyDeletion deletes the events and addresses referenced by the deletion request, if they belong to its author.
+func applyDeletion(ctx context.Context, store Store, c Client, e *nostr.Event) error {
+	var ids []string
+	for _, tag := range e.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "e":
+			ids = append(ids, tag[1])
+
+		case "a":
+			kind, pubkey, d, ok := parseAddress(tag[1])
+			if !ok || pubkey != e.PubKey || kind == nostr.KindDeletion {
+				continue
+			}
+
+			if err := store.DeleteByAddress(ctx, kind, pubkey, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// only the events of the author are deleted, and deletion requests can't be deleted
+	events, err := store.QueryEvents(ctx, c, nostr.Filters{{IDs: ids, Authors: []string{e.PubKey}}})
+	if err != nil {
+		return err
+	}
+
+	owned := make([]string, 0, len(events))
+	for _, event := range events {
+		if event.Kind != nostr.KindDeletion {
+			owned = append(owned, event.ID)
+		}
+	}
+
+	if len(owned) == 0 {
+		return nil
+	}
+	return store.DeleteEvents(ctx, owned)
+}
+
+// parseAddress parses the address "<kind>:<pubkey>:<d>" of an "a" tag.
+func parseAddress(address string) (kind int, pubkey, d string, ok bool) {
+	parts := strings.SplitN(address, ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil || !nostr.IsValidPublicKey(parts[1]) {
+		return 0, "", "", false
+	}
+	return kind, parts[1], parts[2], true
+}
@@ -0,0 +1,86 @@
+package rely
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryStore is a [Store] keeping the events and the deletions in memory.
+type memoryStore struct {
+	events    map[string]nostr.Event
+	deleted   []string
+	addresses []string
+}
+
+func (s *memoryStore) SaveEvent(ctx context.Context, _ Client, e *nostr.Event) error {
+	s.events[e.ID] = *e
+	return nil
+}
+
+func (s *memoryStore) QueryEvents(_ context.Context, _ Client, filters nostr.Filters) ([]nostr.Event, error) {
+	var events []nostr.Event
+	for _, e := range s.events {
+		if filters.Match(&e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStore) CountEvents(context.Context, Client, nostr.Filters) (int64, bool, error) {
+	return 0, false, nil
+}
+
+func (s *memoryStore) DeleteEvents(_ context.Context, ids []string) error {
+	s.deleted = append(s.deleted, ids...)
+	return nil
+}
+
+func (s *memoryStore) DeleteByAddress(_ context.Context, kind int, pubkey, d string) error {
+	s.addresses = append(s.addresses, fmt.Sprintf("%d:%s:%s", kind, pubkey, d))
+	return nil
+}
+
+func TestSaveWithDeletions(t *testing.T) {
+	other := "0" + pk[1:]
+	owned := nostr.Event{ID: "1", PubKey: pk, Kind: 1}
+	foreign := nostr.Event{ID: "2", PubKey: other, Kind: 1}
+	request := nostr.Event{ID: "3", PubKey: pk, Kind: nostr.KindDeletion}
+
+	store := &memoryStore{events: map[string]nostr.Event{"1": owned, "2": foreign, "3": request}}
+	save := SaveWithDeletions(store)
+
+	deletion := &nostr.Event{
+		ID:     "4",
+		PubKey: pk,
+		Kind:   nostr.KindDeletion,
+		Tags: nostr.Tags{
+			{"e", owned.ID},
+			{"e", foreign.ID},
+			{"e", request.ID},
+			{"a", "30023:" + pk + ":article"},
+			{"a", "30023:" + other + ":article"},
+			{"a", "invalid"},
+		},
+	}
+
+	if err := save(context.Background(), nil, deletion); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := store.events[deletion.ID]; !ok {
+		t.Error("expected the deletion request to be saved")
+	}
+
+	if !slices.Equal(store.deleted, []string{owned.ID}) {
+		t.Errorf("expected only the event of the author to be deleted, got %v", store.deleted)
+	}
+
+	if !slices.Equal(store.addresses, []string{"30023:" + pk + ":article"}) {
+		t.Errorf("expected only the address of the author to be deleted, got %v", store.addresses)
+	}
+}
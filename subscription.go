@@ -2,12 +2,19 @@ package rely
 
 import (
 	"context"
+	"fmt"
 	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
+var (
+	ErrSubscriptionLifetime = fmt.Errorf("%w: the subscription exceeded its maximum lifetime", ErrRateLimited)
+	ErrSubscriptionEvents   = fmt.Errorf("%w: the subscription exceeded its maximum number of events", ErrRateLimited)
+)
+
 // Subscription represent the nostr subscription created by a [Client] with a REQ.
 // All methods are safe for concurrent use.
 type Subscription interface {
@@ -31,6 +38,9 @@ type Subscription interface {
 	// Short for time.Since(subscription.CreatedAt())
 	Age() time.Duration
 
+	// Delivered returns the number of events delivered to the subscription, stored and live combined.
+	Delivered() int64
+
 	// Close the subscription, and send the client a CLOSED message with the provided reason
 	Close(reason string)
 }
@@ -43,6 +53,13 @@ type subscription struct {
 	createdAt time.Time
 	cancel    context.CancelFunc // calling it cancels the context of the associated REQ
 	client    *client
+
+	// the events delivered to the subscription, shared with its REQ.
+	delivered *atomic.Int64
+
+	// the addresses of the replaceable events delivered by ID, shared with its REQ.
+	// It's nil if the subscription has no filters by IDs or [WithReplaceableUpdates] is not set.
+	followed *followedSet
 }
 
 func (s subscription) UID() string                 { return s.uid }
@@ -52,3 +69,66 @@ func (s subscription) CreatedAt() time.Time        { return s.createdAt }
 func (s subscription) Age() time.Duration          { return time.Since(s.createdAt) }
 func (s subscription) Matches(e *nostr.Event) bool { return s.filters.Match(e) }
 func (s subscription) Close(reason string)         { s.client.CloseSubWithReason(s.id, reason) }
+
+func (s subscription) Delivered() int64 {
+	if s.delivered == nil {
+		return 0
+	}
+	return s.delivered.Load()
+}
+
+// SubscriptionInfo is the snapshot of an open subscription, returned by [Relay.ListSubscriptions].
+type SubscriptionInfo struct {
+	ClientUID string        `json:"client"`
+	IP        string        `json:"ip"`
+	Pubkey    string        `json:"pubkey,omitempty"`
+	ID        string        `json:"id"`
+	Filters   nostr.Filters `json:"filters"`
+	CreatedAt time.Time     `json:"created_at"`
+	Delivered int64         `json:"delivered"`
+}
+
+// ListSubscriptions returns the open subscriptions of all connected clients, oldest first,
+// to see what's driving the load of the relay right now.
+// It returns [ErrShuttingDown] if the relay is shutting down.
+//
+// Example:
+//
+//	subs, err := relay.ListSubscriptions()
+//	if err != nil {
+//		return err
+//	}
+//
+//	for _, sub := range subs {
+//		log.Printf("%s (%s): %s delivered %d events", sub.ClientUID, sub.IP, sub.ID, sub.Delivered)
+//	}
+func (r *Relay) ListSubscriptions() ([]SubscriptionInfo, error) {
+	clients, err := r.connected()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SubscriptionInfo
+	for _, c := range clients {
+		ip, pubkey := c.IP(), c.Pubkey()
+		for _, sub := range c.Subscriptions() {
+			infos = append(infos, SubscriptionInfo{
+				ClientUID: c.uid,
+				IP:        ip,
+				Pubkey:    pubkey,
+				ID:        sub.ID(),
+				Filters:   sub.Filters(),
+				CreatedAt: sub.CreatedAt(),
+				Delivered: sub.Delivered(),
+			})
+		}
+	}
+
+	slices.SortFunc(infos, func(a, b SubscriptionInfo) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return infos, nil
+}
+
+// follows reports whether the subscription receives the new versions of the replaceable events with the address.
+func (s subscription) follows(address string) bool {
+	return s.followed != nil && address != "" && s.followed.contains(address)
+}
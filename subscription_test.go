@@ -0,0 +1,53 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestListSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(WithDomain("example.com"))
+	relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) {
+		return []nostr.Event{{ID: "a", Kind: 1}, {ID: "b", Kind: 1}}, nil
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	send(t, conn, nostr.ReqEnvelope{SubscriptionID: "notes", Filters: nostr.Filters{{Kinds: []int{1}}}})
+	for _, expected := range []string{"EVENT", "EVENT", "EOSE"} {
+		if label, _ := readMessage(t, conn); label != expected {
+			t.Fatalf("expected %s, got %s", expected, label)
+		}
+	}
+
+	subs, err := relay.ListSubscriptions()
+	if err != nil {
+		t.Fatalf("failed to list the subscriptions: %v", err)
+	}
+
+	if len(subs) != 1 {
+		t.Fatalf("expected 1 subscription, got %d", len(subs))
+	}
+
+	sub := subs[0]
+	if sub.ID != "notes" || sub.IP != "127.0.0.1" || sub.Delivered != 2 || len(sub.Filters) != 1 || sub.CreatedAt.IsZero() {
+		t.Fatalf("unexpected subscription %+v", sub)
+	}
+}
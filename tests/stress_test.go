@@ -115,7 +115,7 @@ func dummyOnConnect(c rely.Client) {
 	}
 }
 
-func dummyOnEvent(c rely.Client, e *nostr.Event) error {
+func dummyOnEvent(ctx context.Context, c rely.Client, e *nostr.Event) error {
 	processed.Add(1)
 	if rg.Float32() < relayFailProbability {
 		return errors.New("failed")
@@ -145,7 +145,7 @@ func dummyOnReq(ctx context.Context, c rely.Client, f nostr.Filters) ([]nostr.Ev
 
 	return nil, nil
 }
-func dummyOnCount(c rely.Client, f nostr.Filters) (int64, bool, error) {
+func dummyOnCount(ctx context.Context, c rely.Client, f nostr.Filters) (int64, bool, error) {
 	processed.Add(1)
 	if rg.Float32() < relayFailProbability {
 		return 0, false, errors.New("failed")
@@ -0,0 +1,57 @@
+package rely
+
+import (
+	"fmt"
+)
+
+// UnknownMode defines how the relay responds to the messages with an unrecognized type,
+// such as those of NIPs the relay doesn't implement yet. In all modes, the On.Unknown hook
+// (if set) is invoked first, so that extensions can be prototyped without forking the relay.
+type UnknownMode int
+
+const (
+	// UnknownNotice responds with a NOTICE, and counts the message as invalid:
+	// clients sending too many invalid messages are disconnected. This is the default.
+	UnknownNotice UnknownMode = iota
+
+	// UnknownIgnore silently ignores the message.
+	UnknownIgnore
+
+	// UnknownDisconnect disconnects the client.
+	UnknownDisconnect
+)
+
+func (m UnknownMode) String() string {
+	switch m {
+	case UnknownNotice:
+		return "notice"
+	case UnknownIgnore:
+		return "ignore"
+	case UnknownDisconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("UnknownMode(%d)", int(m))
+	}
+}
+
+// handleUnknown invokes the On.Unknown hook with the message of unrecognized type, and responds to it
+// according to the [UnknownMode].
+func (c *client) handleUnknown(raw []byte) {
+	if c.relay.On.Unknown != nil {
+		ctx, cancel := c.relay.hookContext(c.messageContext())
+		c.relay.On.Unknown(ctx, c, raw)
+		cancel()
+	}
+
+	switch c.relay.unknownMode {
+	case UnknownIgnore:
+		return
+
+	case UnknownDisconnect:
+		c.disconnectWith(DisconnectKicked, ErrUnsupportedType)
+
+	default:
+		c.invalidMessages++
+		c.notice(ErrUnsupportedType.Error())
+	}
+}
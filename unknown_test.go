@@ -0,0 +1,70 @@
+package rely
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestUnknownMessages(t *testing.T) {
+	tests := []struct {
+		mode     UnknownMode
+		expected string // label of the first message received after the unknown one
+	}{
+		{mode: UnknownNotice, expected: "NOTICE"},
+		{mode: UnknownIgnore, expected: "EOSE"},
+		{mode: UnknownDisconnect, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			unknown := make(chan string, 1)
+			relay := NewRelay(WithDomain("example.com"), WithUnknownMessages(test.mode))
+			relay.On.Req = func(context.Context, Client, nostr.Filters) ([]nostr.Event, error) { return nil, nil }
+			relay.On.Unknown = func(ctx context.Context, c Client, raw []byte) { unknown <- string(raw) }
+			relay.Start(ctx)
+
+			server := httptest.NewServer(relay)
+			defer server.Close()
+			url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+			conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			send(t, conn, []any{"PROTO", "hello"})
+			send(t, conn, nostr.ReqEnvelope{SubscriptionID: "sub", Filters: nostr.Filters{{Kinds: []int{1}}}})
+
+			select {
+			case raw := <-unknown:
+				if strings.TrimSpace(raw) != `["PROTO","hello"]` {
+					t.Fatalf("unexpected raw message %q", raw)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the On.Unknown hook was not invoked")
+			}
+
+			if test.expected == "" {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, _, err := conn.ReadMessage(); err == nil {
+					t.Fatal("expected the client to be disconnected")
+				}
+				return
+			}
+
+			if label, _ := readMessage(t, conn); label != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, label)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package rely
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryShuttingDown is the Retry-After of the upgrades refused while the relay is shutting down,
+// for the clients to reconnect once another instance took over.
+const retryShuttingDown = 10 * time.Second
+
+// RetryAfterError is a rejection that the client can retry after some time, such as a temporary ban
+// or an overloaded relay. The connections it refuses are answered with a Retry-After header.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+// RetryAfter wraps the error so that the refused connections are retried after the duration.
+// Reject.Connection hooks use it to tell the clients when to reconnect.
+//
+// Example:
+//
+//	relay.Reject.Connection = append(relay.Reject.Connection, func(s Stats, r *http.Request) error {
+//		if s.Clients() > 10000 {
+//			return RetryAfter(ErrOverloaded, time.Minute)
+//		}
+//		return nil
+//	})
+func RetryAfter(err error, d time.Duration) error {
+	return &RetryAfterError{Err: err, After: d}
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// upgradeError is the JSON body of the responses refusing a websocket upgrade.
+type upgradeError struct {
+	Error      string `json:"error"`
+	Reason     string `json:"reason"`
+	Status     int    `json:"status"`
+	RetryAfter int64  `json:"retry_after,omitempty"` // seconds
+}
+
+// upgradeStatus returns the HTTP status of the responses refusing an upgrade because of the error.
+func upgradeStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrShuttingDown), errors.Is(err, ErrOverloaded):
+		return http.StatusServiceUnavailable
+	}
+
+	switch Reason(err) {
+	case ErrRateLimited:
+		return http.StatusTooManyRequests
+	case ErrAuthRequired:
+		return http.StatusUnauthorized
+	case ErrInvalid:
+		return http.StatusBadRequest
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// refuseUpgrade answers a refused websocket upgrade with the status and a JSON body describing the error,
+// with its machine-readable reason (see [Reason]). If the error is a [RetryAfterError], the Retry-After header
+// tells the client when to reconnect, so that its backoff doesn't hammer the relay nor give up too early.
+func refuseUpgrade(w http.ResponseWriter, status int, err error) {
+	body := upgradeError{
+		Error:  reasonMessage(err),
+		Reason: Reason(err).Error(),
+		Status: status,
+	}
+
+	var retry *RetryAfterError
+	if errors.As(err, &retry) && retry.After > 0 {
+		body.RetryAfter = int64(math.Ceil(retry.After.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(body.RetryAfter, 10))
+	}
+
+	data, _ := json.Marshal(body)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,62 @@
+package rely
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpgradeStatus(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{err: ErrBanned, expected: http.StatusForbidden},
+		{err: RetryAfter(ErrOverloaded, time.Second), expected: http.StatusServiceUnavailable},
+		{err: fmt.Errorf("%w: slow down", ErrRateLimited), expected: http.StatusTooManyRequests},
+		{err: ErrNoCredentials, expected: http.StatusUnauthorized},
+		{err: fmt.Errorf("%w: bad scope", ErrInvalid), expected: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if status := upgradeStatus(test.err); status != test.expected {
+			t.Errorf("%v: expected status %d, got %d", test.err, test.expected, status)
+		}
+	}
+}
+
+func TestRefuseUpgrade(t *testing.T) {
+	bans := NewBanlist()
+	bans.Ban("192.0.2.1", time.Now().Add(90*time.Second))
+
+	relay := NewRelay(WithDomain("relay.example.com"))
+	relay.Reject.Connection = append(relay.Reject.Connection, bans.RejectConnection)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Upgrade", "websocket")
+
+	w := httptest.NewRecorder()
+	relay.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if retry := w.Header().Get("Retry-After"); retry != "90" {
+		t.Fatalf("expected Retry-After 90, got %q", retry)
+	}
+
+	var body upgradeError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode the body %q: %v", w.Body.String(), err)
+	}
+
+	expected := upgradeError{Error: reasonMessage(ErrBanned), Reason: "blocked", Status: http.StatusForbidden, RetryAfter: 90}
+	if body != expected {
+		t.Fatalf("expected body %+v, got %+v", expected, body)
+	}
+}
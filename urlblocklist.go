@@ -0,0 +1,243 @@
+package rely
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+var ErrBlockedURL = fmt.Errorf("%w: the event links to a blocked URL", ErrBlocked)
+
+// contentURL matches the http and https URLs in the content of the events.
+var contentURL = regexp.MustCompile(`(?i)https?://[^\s<>"'\x60]+`)
+
+// URLBlocklistConfig configures the [URLBlocklist].
+type URLBlocklistConfig struct {
+	// Domains are the blocked domains, including their subdomains.
+	Domains []string
+
+	// Patterns are regular expressions blocking the URLs they match, e.g. `\.exe$` or `bit\.ly/`.
+	Patterns []string
+
+	// Source is the http or https URL of a blocklist refreshed every Refresh by [URLBlocklist.Run], whose domains
+	// are blocked along with the configured ones. It has one domain per line, or the hosts file format
+	// (e.g. "0.0.0.0 spam.example.com"), with the comments starting with "#". Empty disables the refresh.
+	Source  string
+	Refresh time.Duration
+
+	// Timeout of the download of the source, up to MaxSize bytes.
+	Timeout time.Duration
+	MaxSize int64
+}
+
+// DefaultURLBlocklistConfig returns a [URLBlocklistConfig] blocking the domains, with sane defaults for the refresh.
+func DefaultURLBlocklistConfig(domains ...string) URLBlocklistConfig {
+	return URLBlocklistConfig{
+		Domains: domains,
+		Refresh: time.Hour,
+		Timeout: 30 * time.Second,
+		MaxSize: 32 << 20,
+	}
+}
+
+// URLBlocklist rejects the events whose content links to blocked domains, e.g. of malware or spam, or
+// to URLs matching the blocked patterns. The URLs are extracted from the content, and their host is blocked
+// if it or any of its parent domains is in the blocklist. The blocklist can be refreshed periodically from
+// a source URL, such as the public lists of malicious domains.
+//
+// Example:
+//
+//	config := DefaultURLBlocklistConfig("spam.example.com")
+//	config.Source = "https://example.com/blocklist.txt"
+//
+//	blocklist, err := NewURLBlocklist(config)
+//	relay.Reject.Event = append(relay.Reject.Event, blocklist.RejectEvent)
+//	go blocklist.Run(ctx)
+type URLBlocklist struct {
+	config   URLBlocklistConfig
+	patterns []*regexp.Regexp
+	fetcher  *fetch.Fetcher
+
+	domains atomic.Pointer[map[string]struct{}] // the configured and the fetched domains
+
+	blocked  atomic.Int64
+	failures atomic.Int64
+}
+
+// NewURLBlocklist returns a [URLBlocklist], or an error if the config or any of the patterns is invalid.
+func NewURLBlocklist(config URLBlocklistConfig) (*URLBlocklist, error) {
+	b := &URLBlocklist{config: config}
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		b.patterns = append(b.patterns, re)
+	}
+
+	if config.Source != "" {
+		u, err := url.Parse(config.Source)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("the source must be an http or https URL, got %q", config.Source)
+		}
+
+		if config.Refresh <= 0 || config.Timeout <= 0 || config.MaxSize <= 0 {
+			return nil, errors.New("the refresh, the timeout and the max size must be positive")
+		}
+
+		fetching := fetch.DefaultConfig()
+		fetching.Timeout = config.Timeout
+		fetching.MaxBodySize = config.MaxSize
+		fetching.MaxRedirects = 3
+		fetching.HostRate = 0
+		b.fetcher = fetch.New(fetching)
+	}
+
+	b.set(nil)
+	return b, nil
+}
+
+// Domains returns the number of blocked domains, configured and fetched.
+func (b *URLBlocklist) Domains() int { return len(*b.domains.Load()) }
+
+// Blocked returns the number of events rejected because they link to a blocked URL.
+func (b *URLBlocklist) Blocked() int64 { return b.blocked.Load() }
+
+// Failures returns the number of failed refreshes of the blocklist.
+func (b *URLBlocklist) Failures() int64 { return b.failures.Load() }
+
+// set replaces the blocked domains with the configured ones and the fetched ones.
+func (b *URLBlocklist) set(fetched []string) {
+	domains := make(map[string]struct{}, len(b.config.Domains)+len(fetched))
+	for _, domain := range slices.Concat(b.config.Domains, fetched) {
+		if domain = normalizeDomain(domain); domain != "" {
+			domains[domain] = struct{}{}
+		}
+	}
+	b.domains.Store(&domains)
+}
+
+// normalizeDomain returns the domain in lower case, without the port and the trailing dot.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+// Refresh downloads the blocklist from the source, replacing the fetched domains.
+// If it fails, the previous domains are kept.
+func (b *URLBlocklist) Refresh(ctx context.Context) error {
+	if b.fetcher == nil {
+		return errors.New("the blocklist has no source")
+	}
+
+	body, err := b.fetcher.Get(ctx, b.config.Source)
+	if err != nil {
+		b.failures.Add(1)
+		return fmt.Errorf("failed to download the blocklist: %w", err)
+	}
+
+	b.set(parseBlocklist(body))
+	return nil
+}
+
+// parseBlocklist returns the domains of the blocklist, with one domain per line or in the hosts file format.
+func parseBlocklist(body []byte) []string {
+	var domains []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// in the hosts file format, the domain follows the address
+		domain := fields[len(fields)-1]
+		if domain == "localhost" || net.ParseIP(domain) != nil {
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// Run refreshes the blocklist from the source right away and then every interval, until the context is cancelled.
+// It returns immediately if there is no source.
+func (b *URLBlocklist) Run(ctx context.Context) {
+	if b.fetcher == nil {
+		return
+	}
+
+	ticker := time.NewTicker(b.config.Refresh)
+	defer ticker.Stop()
+
+	for {
+		b.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RejectEvent is a Reject.Event hook rejecting the events whose content links to a blocked domain
+// or to a URL matching a blocked pattern.
+func (b *URLBlocklist) RejectEvent(ctx context.Context, c Client, e *nostr.Event) error {
+	if len(e.Content) < len("http://") {
+		return nil
+	}
+
+	domains := *b.domains.Load()
+	for _, raw := range contentURL.FindAllString(e.Content, -1) {
+		for _, re := range b.patterns {
+			if re.MatchString(raw) {
+				b.blocked.Add(1)
+				return fmt.Errorf("%w: %s", ErrBlockedURL, raw)
+			}
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+
+		if domain, ok := blockedDomain(domains, normalizeDomain(u.Hostname())); ok {
+			b.blocked.Add(1)
+			return fmt.Errorf("%w: %s", ErrBlockedURL, domain)
+		}
+	}
+	return nil
+}
+
+// blockedDomain returns the domain or its parent domain that is blocked, if any.
+func blockedDomain(domains map[string]struct{}, domain string) (string, bool) {
+	for domain != "" {
+		if _, ok := domains[domain]; ok {
+			return domain, true
+		}
+
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return "", false
+		}
+		domain = parent
+	}
+	return "", false
+}
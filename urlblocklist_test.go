@@ -0,0 +1,89 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nostr-net/rely/fetch"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	body := []byte("# malware\nevil.com\n0.0.0.0 spam.example.org # hosts\n127.0.0.1 localhost\n\n  phishing.net  \n")
+	expected := []string{"evil.com", "spam.example.org", "phishing.net"}
+
+	if domains := parseBlocklist(body); !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("expected %v, got %v", expected, domains)
+	}
+}
+
+func TestURLBlocklist(t *testing.T) {
+	config := DefaultURLBlocklistConfig("Evil.com.")
+	config.Patterns = []string{`\.exe$`}
+
+	blocklist, err := NewURLBlocklist(config)
+	if err != nil {
+		t.Fatalf("failed to create the blocklist: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		blocked bool
+	}{
+		{name: "blocked domain", content: "check https://evil.com/free", blocked: true},
+		{name: "blocked subdomain", content: "check http://www.EVIL.com:8080/free", blocked: true},
+		{name: "blocked pattern", content: "download https://files.example.com/setup.exe", blocked: true},
+		{name: "similar domain", content: "check https://notevil.com/free"},
+		{name: "domain without URL", content: "evil.com is bad"},
+		{name: "allowed URL", content: "read https://example.com/article"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := blocklist.RejectEvent(context.Background(), nil, &nostr.Event{Content: test.content})
+			if test.blocked && !errors.Is(err, ErrBlockedURL) {
+				t.Fatalf("expected the event to be blocked, got %v", err)
+			}
+			if !test.blocked && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestURLBlocklistRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("spam.example.org\n"))
+	}))
+	defer server.Close()
+
+	config := DefaultURLBlocklistConfig("evil.com")
+	config.Source = server.URL
+
+	blocklist, err := NewURLBlocklist(config)
+	if err != nil {
+		t.Fatalf("failed to create the blocklist: %v", err)
+	}
+
+	fetching := fetch.DefaultConfig()
+	fetching.AllowPrivate = true
+	blocklist.fetcher = fetch.New(fetching)
+
+	if err := blocklist.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	if blocklist.Domains() != 2 {
+		t.Fatalf("expected the configured and the fetched domains, got %d", blocklist.Domains())
+	}
+
+	err = blocklist.RejectEvent(context.Background(), nil, &nostr.Event{Content: "https://spam.example.org"})
+	if !errors.Is(err, ErrBlockedURL) {
+		t.Fatalf("expected the fetched domain to be blocked, got %v", err)
+	}
+}
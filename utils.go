@@ -9,6 +9,8 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -16,8 +18,23 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 )
 
-// Extracts the IP address from the http request.
+// ipKey is the context key of the IP of the client, resolved by the relay before the Reject.Connection hooks.
+type ipKey struct{}
+
+// loopbackNetworks are the proxies trusted by default.
+var loopbackNetworks = []*net.IPNet{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// IP returns the IP address of the client of the http request. In the hooks of the relay, it's the IP resolved with
+// its trusted proxies (see [WithTrustedProxies]). Otherwise, the X-Real-IP and X-Forwarded-For headers are read
+// from any request, so they can be spoofed: use [TrustedIP] instead.
 func IP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ipKey{}).(string); ok {
+		return ip
+	}
+
 	if IP := r.Header.Get("X-Real-IP"); IP != "" {
 		return IP
 	}
@@ -26,15 +43,67 @@ func IP(r *http.Request) string {
 		first := strings.Split(IPs, ",")[0]
 		return strings.TrimSpace(first)
 	}
+	return remoteIP(r)
+}
+
+// TrustedIP returns the IP address of the client of the http request. The X-Real-IP and X-Forwarded-For headers
+// are read only if the request comes from one of the trusted proxies: the client is then the X-Real-IP, or the last
+// address of X-Forwarded-For that is not a trusted proxy. Otherwise, it's the remote address of the request.
+func TrustedIP(r *http.Request, proxies []*net.IPNet) string {
+	remote := remoteIP(r)
+	if !trustedProxy(remote, proxies) {
+		return remote
+	}
+
+	if IP := r.Header.Get("X-Real-IP"); IP != "" {
+		return strings.TrimSpace(IP)
+	}
+
+	if IPs := r.Header.Get("X-Forwarded-For"); IPs != "" {
+		hops := strings.Split(IPs, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if i == 0 || !trustedProxy(hop, proxies) {
+				return hop
+			}
+		}
+	}
+	return remote
+}
 
+// trustedProxy reports whether the IP belongs to one of the networks of the trusted proxies.
+func trustedProxy(IP string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(IP)
+	return ip != nil && slices.ContainsFunc(proxies, func(network *net.IPNet) bool { return network.Contains(ip) })
+}
+
+// remoteIP returns the IP of the remote address of the request.
+func remoteIP(r *http.Request) string {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return r.RemoteAddr // fallback: return as-is
 	}
-
 	return host
 }
 
+// IsExpired reports whether the event has a NIP-40 expiration tag before the given time.
+func IsExpired(e *nostr.Event, now nostr.Timestamp) bool {
+	tag := e.Tags.Find("expiration")
+	if tag == nil {
+		return false
+	}
+
+	expiration, err := strconv.ParseInt(tag[1], 10, 64)
+	return err == nil && nostr.Timestamp(expiration) < now
+}
+
+// DropExpired removes in-place the events with a NIP-40 expiration in the past,
+// and returns the remaining ones. Use it in On.Req when the storage doesn't delete expired events.
+func DropExpired(events []nostr.Event) []nostr.Event {
+	now := nostr.Now()
+	return slices.DeleteFunc(events, func(e nostr.Event) bool { return IsExpired(&e, now) })
+}
+
 // ApplyBudget adjusts the Limit of each filter in-place so that the total does not exceed the given budget.
 // Filters with limits <= budget / len(filters) are preserved, while larger ones are scaled down proportionally.
 // It panics if budget is negative.
@@ -120,7 +189,7 @@ func isUnexpectedClose(err error) bool {
 		websocket.CloseAbnormalClosure)
 }
 
-func logEvent(c Client, e *nostr.Event) error {
+func logEvent(ctx context.Context, c Client, e *nostr.Event) error {
 	log.Printf("received eventID %s from IP %s", e.ID, c.IP())
 	return nil
 }
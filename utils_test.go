@@ -0,0 +1,44 @@
+package rely
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name      string
+		remote    string
+		realIP    string
+		forwarded string
+		expected  string
+	}{
+		{name: "direct", remote: "1.2.3.4:5678", expected: "1.2.3.4"},
+		{name: "spoofed forwarded for", remote: "1.2.3.4:5678", forwarded: "10.1.2.3", expected: "1.2.3.4"},
+		{name: "spoofed real IP", remote: "1.2.3.4:5678", realIP: "10.1.2.3", expected: "1.2.3.4"},
+		{name: "proxy real IP", remote: "10.0.0.1:5678", realIP: "1.2.3.4", expected: "1.2.3.4"},
+		{name: "proxy forwarded for", remote: "10.0.0.1:5678", forwarded: "1.2.3.4", expected: "1.2.3.4"},
+		{name: "spoofed hop", remote: "10.0.0.1:5678", forwarded: "10.1.2.3, 1.2.3.4, 10.0.0.2", expected: "1.2.3.4"},
+		{name: "proxy without headers", remote: "10.0.0.1:5678", expected: "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remote
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+
+			if ip := TrustedIP(r, []*net.IPNet{proxies}); ip != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+}
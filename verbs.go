@@ -0,0 +1,18 @@
+package rely
+
+// handleVerb invokes the handler of the custom verb with the message, sending its error as a NOTICE.
+// It returns false if no handler is registered for the verb. See [WithVerb].
+func (c *client) handleVerb(verb string, raw []byte) bool {
+	handler, ok := c.relay.verbs[verb]
+	if !ok {
+		return false
+	}
+
+	ctx, cancel := c.relay.hookContext(c.messageContext())
+	defer cancel()
+
+	if err := handler(ctx, c, raw); err != nil {
+		c.notice(reasonMessage(err))
+	}
+	return true
+}
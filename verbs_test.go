@@ -0,0 +1,61 @@
+package rely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestCustomVerbs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := NewRelay(
+		WithDomain("example.com"),
+		WithVerb("STATS", func(ctx context.Context, c Client, raw []byte) error {
+			c.SendMessage([]byte(fmt.Sprintf(`["STATS",{"ip":%q}]`, c.IP())))
+			return nil
+		}),
+		WithVerb("SYNC", func(ctx context.Context, c Client, raw []byte) error {
+			return fmt.Errorf("%w: sync is disabled", ErrRestricted)
+		}),
+	)
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	send(t, conn, []any{"STATS"})
+	label, msg := readMessage(t, conn)
+	if label != "STATS" || len(msg) != 2 || !strings.Contains(string(msg[1]), `"ip":"127.0.0.1"`) {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+
+	send(t, conn, []any{"SYNC", "abc"})
+	label, msg = readMessage(t, conn)
+	if label != "NOTICE" || string(msg[1]) != `"restricted: sync is disabled"` {
+		t.Fatalf("unexpected response %s %s", label, msg)
+	}
+}
+
+func TestCustomVerbsReserved(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering the REQ verb to panic")
+		}
+	}()
+
+	NewRelay(WithVerb("REQ", func(context.Context, Client, []byte) error { return errors.New("unreachable") }))
+}